@@ -0,0 +1,429 @@
+// Package accesslog provides access logging middleware for fasthttp.
+//
+// Example usage:
+//
+//	w := accesslog.NewBufferedWriter(logFile, 64*1024, time.Second)
+//	defer w.Close()
+//	m, err := accesslog.New(accesslog.Config{
+//		Output: w,
+//		Format: accesslog.FormatCombined,
+//	})
+//	if err != nil {
+//		log.Fatalf("cannot create access log: %s", err)
+//	}
+//	fasthttp.ListenAndServe(":8080", m(requestHandler))
+package accesslog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Format is an access log line format.
+type Format int
+
+const (
+	// FormatCommon is Common Log Format:
+	//
+	//	127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /foo HTTP/1.1" 200 2326
+	FormatCommon Format = iota
+
+	// FormatCombined is Combined Log Format, which extends Common Log
+	// Format with Referer and User-Agent request headers:
+	//
+	//	127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /foo HTTP/1.1" 200 2326 "http://foo/" "curl/7.64"
+	FormatCombined
+
+	// FormatJSON is JSON object per line containing all the Entry fields.
+	// Duration is logged in seconds.
+	FormatJSON
+)
+
+// Entry contains access log data for the served request.
+//
+// Byte slice fields are valid only until Formatter returns.
+type Entry struct {
+	// Time is the time the request has been read by the server.
+	Time time.Time
+
+	// Duration is the time spent on the request by the request handler.
+	Duration time.Duration
+
+	// RemoteIP is the client IP.
+	//
+	// It is obtained from X-Forwarded-For request header if the request
+	// is received from trusted proxy. See Config.TrustedProxies.
+	RemoteIP []byte
+
+	Method     []byte
+	RequestURI []byte
+	Protocol   []byte
+	StatusCode int
+
+	// BytesIn is the request body size.
+	BytesIn int
+
+	// BytesOut is the response body size.
+	//
+	// It is -1 if the response body is streamed without Content-Length.
+	BytesOut int
+
+	Referer   []byte
+	UserAgent []byte
+}
+
+// Config configures access log middleware created via New.
+type Config struct {
+	// Output is the access log sink.
+	//
+	// Each log line is passed to Output in a single Write call.
+	// Output must be safe for concurrent use. Use BufferedWriter
+	// for buffering log lines before writing them to slow sinks
+	// such as files.
+	//
+	// By default access log is written to os.Stdout.
+	Output io.Writer
+
+	// Format is the access log line format.
+	//
+	// By default FormatCommon is used.
+	Format Format
+
+	// Formatter appends log line for the given entry to dst and returns
+	// the extended dst. The line must end with '\n'.
+	//
+	// Formatter overrides Format if set.
+	Formatter func(dst []byte, e *Entry) []byte
+
+	// TrustedProxies contains IPs and CIDRs of trusted reverse proxies.
+	//
+	// Client IP is obtained from X-Forwarded-For request header
+	// if the request is received from trusted proxy. The rightmost
+	// X-Forwarded-For address not belonging to trusted proxies is used
+	// in this case.
+	//
+	// By default X-Forwarded-For header is ignored.
+	TrustedProxies []string
+}
+
+// New returns access log middleware with the given config.
+//
+// The middleware logs each request after the wrapped handler returns.
+func New(cfg Config) (fasthttp.Middleware, error) {
+	l := &logger{
+		output:    cfg.Output,
+		formatter: cfg.Formatter,
+	}
+	if l.output == nil {
+		l.output = os.Stdout
+	}
+	if l.formatter == nil {
+		switch cfg.Format {
+		case FormatCommon:
+			l.formatter = appendCommon
+		case FormatCombined:
+			l.formatter = appendCombined
+		case FormatJSON:
+			l.formatter = appendJSON
+		default:
+			return nil, fmt.Errorf("unsupported access log format: %d", cfg.Format)
+		}
+	}
+	for _, s := range cfg.TrustedProxies {
+		ipNet, err := parseIPNet(s)
+		if err != nil {
+			return nil, err
+		}
+		l.trustedProxies = append(l.trustedProxies, ipNet)
+	}
+	return l.middleware, nil
+}
+
+func parseIPNet(s string) (*net.IPNet, error) {
+	if strings.IndexByte(s, '/') >= 0 {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse trusted proxy CIDR %q: %s", s, err)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("cannot parse trusted proxy IP %q", s)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 8 * net.IPv4len
+	}
+	return &net.IPNet{
+		IP:   ip,
+		Mask: net.CIDRMask(bits, bits),
+	}, nil
+}
+
+type logger struct {
+	output         io.Writer
+	formatter      func(dst []byte, e *Entry) []byte
+	trustedProxies []*net.IPNet
+}
+
+func (l *logger) middleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		h(ctx)
+		l.log(ctx)
+	}
+}
+
+func (l *logger) log(ctx *fasthttp.RequestCtx) {
+	le := acquireLogEntry()
+	e := &le.e
+	e.Time = ctx.Time()
+	e.Duration = time.Since(e.Time)
+	le.ipBuf = l.appendRemoteIP(le.ipBuf[:0], ctx)
+	e.RemoteIP = le.ipBuf
+	e.Method = ctx.Method()
+	e.RequestURI = ctx.RequestURI()
+	e.Protocol = strHTTP11
+	if !ctx.Request.Header.IsHTTP11() {
+		e.Protocol = strHTTP10
+	}
+	e.StatusCode = ctx.Response.StatusCode()
+	e.BytesIn = len(ctx.Request.Body())
+	if ctx.Response.IsBodyStream() {
+		e.BytesOut = ctx.Response.Header.ContentLength()
+		if e.BytesOut < 0 {
+			e.BytesOut = -1
+		}
+	} else {
+		e.BytesOut = len(ctx.Response.Body())
+	}
+	e.Referer = ctx.Referer()
+	e.UserAgent = ctx.UserAgent()
+
+	le.buf = l.formatter(le.buf[:0], e)
+	if _, err := l.output.Write(le.buf); err != nil {
+		ctx.Logger().Printf("cannot write access log: %s", err)
+	}
+	releaseLogEntry(le)
+}
+
+// appendRemoteIP appends client IP to dst.
+func (l *logger) appendRemoteIP(dst []byte, ctx *fasthttp.RequestCtx) []byte {
+	ip := ctx.RemoteIP()
+	if len(l.trustedProxies) > 0 && l.isTrustedProxy(ip) {
+		xff := ctx.Request.Header.Peek("X-Forwarded-For")
+		for len(xff) > 0 {
+			var s []byte
+			if n := bytes.LastIndexByte(xff, ','); n >= 0 {
+				s = xff[n+1:]
+				xff = xff[:n]
+			} else {
+				s = xff
+				xff = nil
+			}
+			s = bytes.TrimSpace(s)
+			xffIP := net.ParseIP(string(s))
+			if xffIP == nil {
+				// Invalid address cannot be trusted, so stop here.
+				break
+			}
+			ip = xffIP
+			if !l.isTrustedProxy(ip) {
+				break
+			}
+		}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return fasthttp.AppendIPv4(dst, ip4)
+	}
+	return append(dst, ip.String()...)
+}
+
+func (l *logger) isTrustedProxy(ip net.IP) bool {
+	for _, ipNet := range l.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	strHTTP11 = []byte("HTTP/1.1")
+	strHTTP10 = []byte("HTTP/1.0")
+)
+
+type logEntry struct {
+	e     Entry
+	ipBuf []byte
+	buf   []byte
+}
+
+func acquireLogEntry() *logEntry {
+	v := logEntryPool.Get()
+	if v == nil {
+		return &logEntry{}
+	}
+	return v.(*logEntry)
+}
+
+func releaseLogEntry(le *logEntry) {
+	le.e = Entry{}
+	logEntryPool.Put(le)
+}
+
+var logEntryPool sync.Pool
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+func appendCommon(dst []byte, e *Entry) []byte {
+	dst = appendCommonFields(dst, e)
+	return append(dst, '\n')
+}
+
+func appendCombined(dst []byte, e *Entry) []byte {
+	dst = appendCommonFields(dst, e)
+	dst = append(dst, ' ')
+	dst = appendCLFQuoted(dst, e.Referer)
+	dst = append(dst, ' ')
+	dst = appendCLFQuoted(dst, e.UserAgent)
+	return append(dst, '\n')
+}
+
+func appendCommonFields(dst []byte, e *Entry) []byte {
+	dst = append(dst, e.RemoteIP...)
+	dst = append(dst, " - - ["...)
+	dst = e.Time.AppendFormat(dst, clfTimeFormat)
+	dst = append(dst, "] \""...)
+	dst = appendCLFEscaped(dst, e.Method)
+	dst = append(dst, ' ')
+	dst = appendCLFEscaped(dst, e.RequestURI)
+	dst = append(dst, ' ')
+	dst = append(dst, e.Protocol...)
+	dst = append(dst, "\" "...)
+	dst = fasthttp.AppendUint(dst, e.StatusCode)
+	dst = append(dst, ' ')
+	if e.BytesOut < 0 {
+		return append(dst, '-')
+	}
+	return fasthttp.AppendUint(dst, e.BytesOut)
+}
+
+// appendCLFQuoted appends quoted s to dst. Empty s is logged as "-".
+func appendCLFQuoted(dst, s []byte) []byte {
+	if len(s) == 0 {
+		return append(dst, `"-"`...)
+	}
+	dst = append(dst, '"')
+	dst = appendCLFEscaped(dst, s)
+	return append(dst, '"')
+}
+
+// appendCLFEscaped appends s to dst, escaping quotes, backslashes
+// and non-printable chars the same way as Apache does.
+func appendCLFEscaped(dst, s []byte) []byte {
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			dst = append(dst, '\\', c)
+		case c < 0x20 || c >= 0x7f:
+			dst = append(dst, '\\', 'x', hexChars[c>>4], hexChars[c&0xf])
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+func appendJSON(dst []byte, e *Entry) []byte {
+	dst = append(dst, `{"time":"`...)
+	dst = e.Time.AppendFormat(dst, time.RFC3339Nano)
+	dst = append(dst, `","duration":`...)
+	dst = appendSeconds(dst, e.Duration)
+	dst = append(dst, `,"remote_ip":`...)
+	dst = appendJSONString(dst, e.RemoteIP)
+	dst = append(dst, `,"method":`...)
+	dst = appendJSONString(dst, e.Method)
+	dst = append(dst, `,"uri":`...)
+	dst = appendJSONString(dst, e.RequestURI)
+	dst = append(dst, `,"protocol":`...)
+	dst = appendJSONString(dst, e.Protocol)
+	dst = append(dst, `,"status":`...)
+	dst = fasthttp.AppendUint(dst, e.StatusCode)
+	dst = append(dst, `,"bytes_in":`...)
+	dst = fasthttp.AppendUint(dst, e.BytesIn)
+	dst = append(dst, `,"bytes_out":`...)
+	if e.BytesOut < 0 {
+		dst = append(dst, "null"...)
+	} else {
+		dst = fasthttp.AppendUint(dst, e.BytesOut)
+	}
+	dst = append(dst, `,"referer":`...)
+	dst = appendJSONString(dst, e.Referer)
+	dst = append(dst, `,"user_agent":`...)
+	dst = appendJSONString(dst, e.UserAgent)
+	return append(dst, "}\n"...)
+}
+
+// appendSeconds appends d in seconds with microsecond precision to dst.
+func appendSeconds(dst []byte, d time.Duration) []byte {
+	if d < 0 {
+		d = 0
+	}
+	us := int(d / time.Microsecond)
+	dst = fasthttp.AppendUint(dst, us/1e6)
+	dst = append(dst, '.')
+	frac := us % 1e6
+	for n := 100000; n > 1 && frac < n; n /= 10 {
+		dst = append(dst, '0')
+	}
+	return fasthttp.AppendUint(dst, frac)
+}
+
+// appendJSONString appends JSON string for s to dst.
+//
+// Invalid UTF-8 sequences are replaced by U+FFFD.
+func appendJSONString(dst, s []byte) []byte {
+	dst = append(dst, '"')
+	for len(s) > 0 {
+		c := s[0]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRune(s)
+			if r == utf8.RuneError && size == 1 {
+				dst = append(dst, `\ufffd`...)
+			} else {
+				dst = append(dst, s[:size]...)
+			}
+			s = s[size:]
+			continue
+		}
+		s = s[1:]
+		switch {
+		case c == '"' || c == '\\':
+			dst = append(dst, '\\', c)
+		case c == '\n':
+			dst = append(dst, '\\', 'n')
+		case c == '\r':
+			dst = append(dst, '\\', 'r')
+		case c == '\t':
+			dst = append(dst, '\\', 't')
+		case c < 0x20:
+			dst = append(dst, '\\', 'u', '0', '0', hexChars[c>>4], hexChars[c&0xf])
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, '"')
+}
+
+const hexChars = "0123456789abcdef"
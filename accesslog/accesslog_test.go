@@ -0,0 +1,167 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+type testOutput struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (o *testOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	o.lines = append(o.lines, string(p))
+	o.mu.Unlock()
+	return len(p), nil
+}
+
+func testAccessLog(t *testing.T, cfg Config, remoteAddr string, prepare func(req *fasthttp.Request)) string {
+	t.Helper()
+	var o testOutput
+	cfg.Output = &o
+	m, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	h := m(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+		ctx.WriteString("response body")
+	})
+
+	var req fasthttp.Request
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("/foo?bar=baz")
+	req.SetBodyString("request")
+	req.Header.SetReferer("http://referer/")
+	req.Header.SetUserAgent(`agent "quoted"`)
+	if prepare != nil {
+		prepare(&req)
+	}
+	raddr, err := net.ResolveTCPAddr("tcp", remoteAddr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, raddr, nil)
+	h(&ctx)
+
+	if len(o.lines) != 1 {
+		t.Fatalf("unexpected number of log lines: %d. Expecting 1", len(o.lines))
+	}
+	line := o.lines[0]
+	if !strings.HasSuffix(line, "\n") {
+		t.Fatalf("log line must end with newline: %q", line)
+	}
+	return line
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	line := testAccessLog(t, Config{}, "1.2.3.4:5678", nil)
+	expectedPrefix := `1.2.3.4 - - [`
+	expectedSuffix := `] "POST /foo?bar=baz HTTP/1.1" 201 13` + "\n"
+	if !strings.HasPrefix(line, expectedPrefix) || !strings.HasSuffix(line, expectedSuffix) {
+		t.Fatalf("unexpected common log line %q. Expecting %q...%q", line, expectedPrefix, expectedSuffix)
+	}
+
+	line = testAccessLog(t, Config{Format: FormatCombined}, "1.2.3.4:5678", nil)
+	expectedSuffix = `] "POST /foo?bar=baz HTTP/1.1" 201 13 "http://referer/" "agent \"quoted\""` + "\n"
+	if !strings.HasPrefix(line, expectedPrefix) || !strings.HasSuffix(line, expectedSuffix) {
+		t.Fatalf("unexpected combined log line %q. Expecting %q...%q", line, expectedPrefix, expectedSuffix)
+	}
+
+	line = testAccessLog(t, Config{Format: FormatJSON}, "[::1]:5678", func(req *fasthttp.Request) {
+		req.Header.SetUserAgent("agent\n\xff")
+	})
+	var v struct {
+		Time      time.Time `json:"time"`
+		Duration  float64   `json:"duration"`
+		RemoteIP  string    `json:"remote_ip"`
+		Method    string    `json:"method"`
+		URI       string    `json:"uri"`
+		Protocol  string    `json:"protocol"`
+		Status    int       `json:"status"`
+		BytesIn   int       `json:"bytes_in"`
+		BytesOut  int       `json:"bytes_out"`
+		Referer   string    `json:"referer"`
+		UserAgent string    `json:"user_agent"`
+	}
+	if err := json.Unmarshal([]byte(line), &v); err != nil {
+		t.Fatalf("cannot parse JSON log line %q: %s", line, err)
+	}
+	if v.Time.IsZero() || v.Duration < 0 || v.RemoteIP != "::1" || v.Method != "POST" || v.URI != "/foo?bar=baz" ||
+		v.Protocol != "HTTP/1.1" || v.Status != 201 || v.BytesIn != 7 || v.BytesOut != 13 ||
+		v.Referer != "http://referer/" || v.UserAgent != "agent\n�" {
+		t.Fatalf("unexpected JSON log line %q: %+v", line, v)
+	}
+
+	line = testAccessLog(t, Config{
+		Formatter: func(dst []byte, e *Entry) []byte {
+			dst = append(dst, e.Method...)
+			dst = append(dst, ' ')
+			dst = fasthttp.AppendUint(dst, e.StatusCode)
+			return append(dst, '\n')
+		},
+	}, "1.2.3.4:5678", nil)
+	if line != "POST 201\n" {
+		t.Fatalf("unexpected custom log line %q. Expecting %q", line, "POST 201\n")
+	}
+}
+
+func TestAccessLogTrustedProxies(t *testing.T) {
+	testRemoteIP := func(remoteAddr, xff, expectedIP string) {
+		t.Helper()
+		line := testAccessLog(t, Config{
+			TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"},
+		}, remoteAddr, func(req *fasthttp.Request) {
+			if len(xff) > 0 {
+				req.Header.Set("X-Forwarded-For", xff)
+			}
+		})
+		if !strings.HasPrefix(line, expectedIP+" ") {
+			t.Fatalf("unexpected remote ip in log line %q. Expecting %q", line, expectedIP)
+		}
+	}
+
+	testRemoteIP("1.2.3.4:1234", "5.6.7.8", "1.2.3.4")
+	testRemoteIP("10.1.2.3:1234", "", "10.1.2.3")
+	testRemoteIP("10.1.2.3:1234", "5.6.7.8", "5.6.7.8")
+	testRemoteIP("192.168.1.1:1234", "5.6.7.8, 9.9.9.9, 10.0.0.1", "9.9.9.9")
+	testRemoteIP("192.168.1.2:1234", "5.6.7.8", "192.168.1.2")
+	testRemoteIP("10.1.2.3:1234", "5.6.7.8, garbage, 10.0.0.1", "10.0.0.1")
+	testRemoteIP("10.1.2.3:1234", "2001:db8::1", "2001:db8::1")
+
+	for _, s := range []string{"foobar", "10.0.0.0/33"} {
+		if _, err := New(Config{TrustedProxies: []string{s}}); err == nil {
+			t.Fatalf("expecting error for invalid trusted proxy %q", s)
+		}
+	}
+	if _, err := New(Config{Format: Format(100)}); err == nil {
+		t.Fatalf("expecting error for unsupported format")
+	}
+}
+
+func TestAccessLogBodyStream(t *testing.T) {
+	var buf bytes.Buffer
+	m, err := New(Config{Output: &buf})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var ctx fasthttp.RequestCtx
+	var req fasthttp.Request
+	ctx.Init(&req, nil, nil)
+	m(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyStream(strings.NewReader("foobar"), -1)
+	})(&ctx)
+	if s := buf.String(); !strings.HasSuffix(s, `"GET / HTTP/1.1" 200 -`+"\n") {
+		t.Fatalf("unexpected log line %q", s)
+	}
+}
@@ -0,0 +1,43 @@
+package accesslog
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func BenchmarkAccessLogCombined(b *testing.B) {
+	benchmarkAccessLog(b, FormatCombined)
+}
+
+func BenchmarkAccessLogJSON(b *testing.B) {
+	benchmarkAccessLog(b, FormatJSON)
+}
+
+func benchmarkAccessLog(b *testing.B, format Format) {
+	m, err := New(Config{
+		Output:         ioutil.Discard,
+		Format:         format,
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+	if err != nil {
+		b.Fatalf("unexpected error: %s", err)
+	}
+	h := m(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString("foobar")
+	})
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var req fasthttp.Request
+		req.SetRequestURI("/foo/bar?baz=123")
+		req.Header.SetUserAgent("Mozilla/5.0")
+		var ctx fasthttp.RequestCtx
+		ctx.Init(&req, &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4)}, nil)
+		for pb.Next() {
+			h(&ctx)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package accesslog
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var errWriterClosed = errors.New("access log writer is closed")
+
+// BufferedWriter buffers data written to the underlying writer.
+//
+// Buffered data is written to the underlying writer when the buffer
+// is full, on Flush and Close calls and every flush interval.
+// Each Write call is either buffered as a whole or written directly,
+// so lines passed to Write are never split between underlying
+// writer calls.
+//
+// BufferedWriter is safe for concurrent use.
+type BufferedWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+	err error
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewBufferedWriter returns writer buffering up to bufSize bytes
+// before writing them to w.
+//
+// Buffered data is flushed every flushInterval if it is positive.
+// Call Close for stopping the flusher and flushing the remaining data.
+func NewBufferedWriter(w io.Writer, bufSize int, flushInterval time.Duration) *BufferedWriter {
+	if bufSize <= 0 {
+		bufSize = 4096
+	}
+	bw := &BufferedWriter{
+		w:      w,
+		buf:    make([]byte, 0, bufSize),
+		stopCh: make(chan struct{}),
+	}
+	if flushInterval > 0 {
+		bw.wg.Add(1)
+		go func() {
+			defer bw.wg.Done()
+			bw.flusher(flushInterval)
+		}()
+	}
+	return bw
+}
+
+func (bw *BufferedWriter) flusher(flushInterval time.Duration) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			bw.Flush()
+		case <-bw.stopCh:
+			return
+		}
+	}
+}
+
+// Write buffers p.
+//
+// The error returned by the underlying writer is returned from all
+// the subsequent calls.
+func (bw *BufferedWriter) Write(p []byte) (int, error) {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+
+	if bw.err != nil {
+		return 0, bw.err
+	}
+	if len(bw.buf)+len(p) > cap(bw.buf) {
+		if err := bw.flushLocked(); err != nil {
+			return 0, err
+		}
+		if len(p) > cap(bw.buf) {
+			n, err := bw.w.Write(p)
+			if err != nil {
+				bw.err = err
+			}
+			return n, err
+		}
+	}
+	bw.buf = append(bw.buf, p...)
+	return len(p), nil
+}
+
+// Flush writes buffered data to the underlying writer.
+func (bw *BufferedWriter) Flush() error {
+	bw.mu.Lock()
+	err := bw.flushLocked()
+	bw.mu.Unlock()
+	return err
+}
+
+func (bw *BufferedWriter) flushLocked() error {
+	if bw.err != nil {
+		return bw.err
+	}
+	if len(bw.buf) == 0 {
+		return nil
+	}
+	if _, err := bw.w.Write(bw.buf); err != nil {
+		bw.err = err
+		return err
+	}
+	bw.buf = bw.buf[:0]
+	return nil
+}
+
+// Close stops the periodic flusher and flushes buffered data.
+//
+// The underlying writer isn't closed. Subsequent Write calls return
+// an error.
+func (bw *BufferedWriter) Close() error {
+	bw.mu.Lock()
+	select {
+	case <-bw.stopCh:
+		bw.mu.Unlock()
+		return errWriterClosed
+	default:
+	}
+	close(bw.stopCh)
+	bw.mu.Unlock()
+
+	bw.wg.Wait()
+
+	bw.mu.Lock()
+	err := bw.flushLocked()
+	if bw.err == nil {
+		bw.err = errWriterClosed
+	}
+	bw.mu.Unlock()
+	return err
+}
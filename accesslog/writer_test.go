@@ -0,0 +1,104 @@
+package accesslog
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSink struct {
+	mu     sync.Mutex
+	writes []string
+	err    error
+}
+
+func (s *testSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.writes = append(s.writes, string(p))
+	return len(p), nil
+}
+
+func (s *testSink) data() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var buf bytes.Buffer
+	for _, w := range s.writes {
+		buf.WriteString(w)
+	}
+	return buf.String()
+}
+
+func TestBufferedWriter(t *testing.T) {
+	var s testSink
+	bw := NewBufferedWriter(&s, 10, 0)
+
+	bw.Write([]byte("foo\n"))
+	bw.Write([]byte("bar\n"))
+	if data := s.data(); data != "" {
+		t.Fatalf("unexpected data written before buffer is full: %q", data)
+	}
+
+	// Lines mustn't be split when the buffer is full.
+	bw.Write([]byte("baz\n"))
+	if len(s.writes) != 1 || s.writes[0] != "foo\nbar\n" {
+		t.Fatalf("unexpected writes %q. Expecting %q", s.writes, []string{"foo\nbar\n"})
+	}
+
+	// Lines exceeding buffer size are written directly.
+	bw.Write([]byte("very long line\n"))
+	if data := s.data(); data != "foo\nbar\nbaz\nvery long line\n" {
+		t.Fatalf("unexpected data %q", data)
+	}
+
+	bw.Write([]byte("end\n"))
+	if err := bw.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if data := s.data(); data != "foo\nbar\nbaz\nvery long line\nend\n" {
+		t.Fatalf("unexpected data %q", data)
+	}
+	if _, err := bw.Write([]byte("foo\n")); err != errWriterClosed {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errWriterClosed)
+	}
+	if err := bw.Close(); err != errWriterClosed {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errWriterClosed)
+	}
+}
+
+func TestBufferedWriterFlushInterval(t *testing.T) {
+	var s testSink
+	bw := NewBufferedWriter(&s, 4096, 10*time.Millisecond)
+	defer bw.Close()
+
+	bw.Write([]byte("foo\n"))
+	deadline := time.Now().Add(time.Second)
+	for s.data() != "foo\n" {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when waiting for periodic flush")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBufferedWriterError(t *testing.T) {
+	expectedErr := errors.New("sink error")
+	s := testSink{
+		err: expectedErr,
+	}
+	bw := NewBufferedWriter(&s, 4096, 0)
+	if _, err := bw.Write([]byte("foo\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := bw.Flush(); err != expectedErr {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, expectedErr)
+	}
+	if _, err := bw.Write([]byte("foo\n")); err != expectedErr {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, expectedErr)
+	}
+}
@@ -0,0 +1,130 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"net"
+	"sort"
+	"time"
+)
+
+// ALPNHandler serves TLS connection with the application protocol
+// negotiated via ALPN.
+//
+// The handshake is already complete when ALPNHandler is called, so
+// c.ConnectionState().NegotiatedProtocol contains the protocol.
+// The connection is closed after ALPNHandler returns.
+type ALPNHandler func(c *tls.Conn)
+
+const (
+	alpnProtoHTTP11 = "http/1.1"
+	alpnProtoHTTP2  = "h2"
+
+	// alpnProtoACME is used by ACME tls-alpn-01 challenge.
+	// See RFC 8737 for details.
+	alpnProtoACME = "acme-tls/1"
+)
+
+func (s *Server) hasALPNHandlers() bool {
+	return s.EnableHTTP2 || len(s.ALPNHandlers) > 0
+}
+
+// nextProtos returns ALPN protocols advertised by the server.
+//
+// Protocols from ALPNHandlers missing in protos are preferred
+// over protos, while http/1.1 goes last.
+func (s *Server) nextProtos(protos []string) []string {
+	if !s.hasALPNHandlers() {
+		return protos
+	}
+	var missing []string
+	if s.EnableHTTP2 && !hasString(protos, alpnProtoHTTP2) {
+		missing = append(missing, alpnProtoHTTP2)
+	}
+	for proto := range s.ALPNHandlers {
+		if !hasString(protos, proto) && !hasString(missing, proto) && proto != alpnProtoHTTP11 {
+			missing = append(missing, proto)
+		}
+	}
+	if len(missing) > 1 {
+		// Sort protocols for stable negotiation, but keep h2 first.
+		first := 0
+		if missing[0] == alpnProtoHTTP2 {
+			first = 1
+		}
+		sort.Strings(missing[first:])
+	}
+	result := append(missing, protos...)
+	if !hasString(result, alpnProtoHTTP11) {
+		result = append(result, alpnProtoHTTP11)
+	}
+	return result
+}
+
+func hasString(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// alpnHandler returns handler for the given negotiated protocol.
+//
+// nil is returned if the connection must be served as HTTP/1.x.
+func (s *Server) alpnHandler(proto string, connID uint64, connTime time.Time) ALPNHandler {
+	if h := s.ALPNHandlers[proto]; h != nil {
+		return h
+	}
+	switch proto {
+	case alpnProtoHTTP2:
+		if s.EnableHTTP2 {
+			return func(c *tls.Conn) {
+				s.serveH2C(c, c, connID, connTime, nil, nil)
+			}
+		}
+	case alpnProtoACME:
+		// The challenge is verified during the handshake
+		// by tls.Config.GetCertificate, so just close the connection.
+		return func(c *tls.Conn) {}
+	}
+	return nil
+}
+
+// serveALPN completes TLS handshake on c and serves c via ALPNHandler
+// for the negotiated protocol.
+//
+// false is returned if c must be served as HTTP/1.x.
+func (s *Server) serveALPN(c net.Conn, connID uint64, connTime time.Time) (bool, error) {
+	nc := c
+	if pc, ok := nc.(*perIPConn); ok {
+		nc = pc.Conn
+	}
+	tlsConn, ok := nc.(*tls.Conn)
+	if !ok {
+		return false, nil
+	}
+
+	if s.ReadTimeout > 0 {
+		if err := c.SetDeadline(time.Now().Add(s.ReadTimeout)); err != nil {
+			return false, err
+		}
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return false, err
+	}
+	if s.ReadTimeout > 0 {
+		// Timeouts are set by serveConn or ALPNHandler after that.
+		if err := c.SetDeadline(zeroTime); err != nil {
+			return false, err
+		}
+	}
+
+	proto := tlsConn.ConnectionState().NegotiatedProtocol
+	h := s.alpnHandler(proto, connID, connTime)
+	if h == nil {
+		return false, nil
+	}
+	h(tlsConn)
+	return true, nil
+}
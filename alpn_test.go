@@ -0,0 +1,223 @@
+package fasthttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+	"golang.org/x/net/http2"
+)
+
+func testALPNServer(t *testing.T, s *Server) (*fasthttputil.InmemoryListener, func()) {
+	ln := fasthttputil.NewInmemoryListener()
+	serverStopCh := make(chan struct{})
+	go func() {
+		if err := s.ServeTLS(ln, "./ssl-cert-snakeoil.pem", "./ssl-cert-snakeoil.key"); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		close(serverStopCh)
+	}()
+	return ln, func() {
+		if err := ln.Close(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		select {
+		case <-serverStopCh:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+}
+
+func dialALPN(t *testing.T, ln *fasthttputil.InmemoryListener, protos ...string) *tls.Conn {
+	t.Helper()
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         protos,
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return tlsConn
+}
+
+func TestServerALPNHandlers(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("success")
+		},
+		ALPNHandlers: map[string]ALPNHandler{
+			"echo/1": func(c *tls.Conn) {
+				io.Copy(c, c)
+			},
+		},
+		TLSConfig: &tls.Config{
+			// acme-tls/1 is advertised by autocert.Manager.TLSConfig.
+			NextProtos: []string{alpnProtoACME},
+		},
+	}
+	ln, stop := testALPNServer(t, s)
+	defer stop()
+
+	// Custom protocol must be served by ALPNHandler.
+	c := dialALPN(t, ln, "echo/1", "http/1.1")
+	if proto := c.ConnectionState().NegotiatedProtocol; proto != "echo/1" {
+		t.Fatalf("unexpected negotiated protocol: %q. Expecting %q", proto, "echo/1")
+	}
+	if _, err := c.Write([]byte("foobar")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	buf := make([]byte, len("foobar"))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(buf) != "foobar" {
+		t.Fatalf("unexpected response: %q. Expecting %q", buf, "foobar")
+	}
+	c.Close()
+
+	// HTTP/1.1 must be served with and without ALPN.
+	for _, protos := range [][]string{{"http/1.1"}, nil} {
+		c = dialALPN(t, ln, protos...)
+		if _, err := c.Write([]byte("GET / HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		verifyResponse(t, bufio.NewReader(c), StatusOK, string(defaultContentType), "success")
+		c.Close()
+	}
+
+	// ACME challenge connections must be closed after the handshake.
+	c = dialALPN(t, ln, alpnProtoACME)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(buf); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+	c.Close()
+}
+
+func TestServerEnableHTTP2(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("success")
+		},
+		EnableHTTP2: true,
+	}
+	ln, stop := testALPNServer(t, s)
+	defer stop()
+
+	hc := &http.Client{
+		Transport: &http2.Transport{
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dialALPN(t, ln, http2.NextProtoTLS), nil
+			},
+		},
+	}
+	resp, err := hc.Get("https://foobar/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("unexpected protocol: %q. Expecting HTTP/2", resp.Proto)
+	}
+	if string(body) != "success" {
+		t.Fatalf("unexpected body: %q. Expecting %q", body, "success")
+	}
+
+	// HTTP/1.1 clients must be served too.
+	c := dialALPN(t, ln, "http/1.1")
+	defer c.Close()
+	if _, err := c.Write([]byte("GET / HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	verifyResponse(t, bufio.NewReader(c), StatusOK, string(defaultContentType), "success")
+}
+
+func TestRequestCtxWriteChunkHTTP2(t *testing.T) {
+	ch := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Response.Header.AddTrailer("X-Checksum")
+			if err := ctx.WriteChunk([]byte("foo")); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			<-ch
+			ctx.WriteString("bar")
+			ctx.Response.Header.Set("X-Checksum", "abc")
+		},
+		EnableHTTP2: true,
+	}
+	ln, stop := testALPNServer(t, s)
+	defer stop()
+
+	hc := &http.Client{
+		Transport: &http2.Transport{
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dialALPN(t, ln, http2.NextProtoTLS), nil
+			},
+		},
+	}
+	resp, err := hc.Get("https://foobar/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	// The first chunk must be sent before returning from the handler.
+	chunk := make([]byte, 3)
+	if _, err = io.ReadFull(resp.Body, chunk); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(chunk) != "foo" {
+		t.Fatalf("unexpected chunk %q. Expecting %q", chunk, "foo")
+	}
+	close(ch)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != "bar" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "bar")
+	}
+	if v := resp.Trailer.Get("X-Checksum"); v != "abc" {
+		t.Fatalf("unexpected trailer value %q. Expecting %q", v, "abc")
+	}
+}
+
+func TestServerNextProtos(t *testing.T) {
+	testServerNextProtos(t, &Server{}, nil, nil)
+	testServerNextProtos(t, &Server{}, []string{"foo"}, []string{"foo"})
+	testServerNextProtos(t, &Server{EnableHTTP2: true}, nil, []string{"h2", "http/1.1"})
+	testServerNextProtos(t, &Server{
+		EnableHTTP2: true,
+		ALPNHandlers: map[string]ALPNHandler{
+			"foo":      nil,
+			"bar":      nil,
+			"http/1.1": nil,
+		},
+	}, []string{"http/1.1", "acme-tls/1"}, []string{"h2", "bar", "foo", "http/1.1", "acme-tls/1"})
+}
+
+func testServerNextProtos(t *testing.T, s *Server, protos, expectedProtos []string) {
+	t.Helper()
+	result := s.nextProtos(protos)
+	if !reflect.DeepEqual(result, expectedProtos) {
+		t.Fatalf("unexpected protos: %q. Expecting %q", result, expectedProtos)
+	}
+}
@@ -66,6 +66,33 @@ func (a *Args) VisitAll(f func(key, value []byte)) {
 	visitArgs(a.args, f)
 }
 
+// VisitAllMulti calls f for each unique arg key with all the values
+// for this key.
+//
+// Keys are visited in the order of their first occurrence. Values are passed
+// in the order they appear in args.
+//
+// f must not retain references to key and values after returning.
+// Make key and/or values copies if you need storing them after returning.
+func (a *Args) VisitAllMulti(f func(key []byte, values [][]byte)) {
+	var values [][]byte
+	args := a.args
+	for i, n := 0, len(args); i < n; i++ {
+		kv := &args[i]
+		if hasArg(args[:i], b2s(kv.key)) {
+			// The key has been already visited.
+			continue
+		}
+		values = append(values[:0], kv.value)
+		for j := i + 1; j < n; j++ {
+			if string(args[j].key) == string(kv.key) {
+				values = append(values, args[j].value)
+			}
+		}
+		f(kv.key, values)
+	}
+}
+
 // Len returns the number of query args.
 func (a *Args) Len() int {
 	return len(a.args)
@@ -170,23 +197,31 @@ func (a *Args) AddBytesKV(key, value []byte) {
 }
 
 // Set sets 'key=value' argument.
+//
+// All the existing values for the given key are replaced by the value.
 func (a *Args) Set(key, value string) {
-	a.args = setArg(a.args, key, value)
+	a.args = setAllArgs(a.args, key, value)
 }
 
 // SetBytesK sets 'key=value' argument.
+//
+// All the existing values for the given key are replaced by the value.
 func (a *Args) SetBytesK(key []byte, value string) {
-	a.args = setArg(a.args, b2s(key), value)
+	a.args = setAllArgs(a.args, b2s(key), value)
 }
 
 // SetBytesV sets 'key=value' argument.
+//
+// All the existing values for the given key are replaced by the value.
 func (a *Args) SetBytesV(key string, value []byte) {
-	a.args = setArg(a.args, key, b2s(value))
+	a.args = setAllArgs(a.args, key, b2s(value))
 }
 
 // SetBytesKV sets 'key=value' argument.
+//
+// All the existing values for the given key are replaced by the value.
 func (a *Args) SetBytesKV(key, value []byte) {
-	a.args = setArgBytes(a.args, key, value)
+	a.args = setAllArgs(a.args, b2s(key), b2s(value))
 }
 
 // Peek returns query arg value for the given key.
@@ -203,18 +238,25 @@ func (a *Args) PeekBytes(key []byte) []byte {
 	return peekArgBytes(a.args, key)
 }
 
-// PeekMulti returns all the arg values for the given key.
+// PeekMulti returns all the arg values for the given key
+// in the order they appear in args.
+//
+// Returned values are valid until the next Args call.
 func (a *Args) PeekMulti(key string) [][]byte {
 	var values [][]byte
-	a.VisitAll(func(k, v []byte) {
-		if string(k) == key {
-			values = append(values, v)
+	for i, n := 0, len(a.args); i < n; i++ {
+		kv := &a.args[i]
+		if string(kv.key) == key {
+			values = append(values, kv.value)
 		}
-	})
+	}
 	return values
 }
 
-// PeekMultiBytes returns all the arg values for the given key.
+// PeekMultiBytes returns all the arg values for the given key
+// in the order they appear in args.
+//
+// Returned values are valid until the next Args call.
 func (a *Args) PeekMultiBytes(key []byte) [][]byte {
 	return a.PeekMulti(b2s(key))
 }
@@ -333,6 +375,7 @@ func delAllArgs(args []argsKV, key string) []argsKV {
 			tmp := *kv
 			copy(args[i:], args[i+1:])
 			n--
+			i--
 			args[n] = tmp
 			args = args[:n]
 		}
@@ -356,6 +399,21 @@ func setArg(h []argsKV, key, value string) []argsKV {
 	return appendArg(h, key, value)
 }
 
+// setAllArgs sets the value for the first arg with the given key
+// and deletes the remaining args with this key.
+func setAllArgs(h []argsKV, key, value string) []argsKV {
+	n := len(h)
+	for i := 0; i < n; i++ {
+		kv := &h[i]
+		if key == string(kv.key) {
+			kv.value = append(kv.value[:0], value...)
+			tail := delAllArgs(h[i+1:], key)
+			return h[:i+1+len(tail)]
+		}
+	}
+	return appendArg(h, key, value)
+}
+
 func appendArgBytes(h []argsKV, key, value []byte) []argsKV {
 	return appendArg(h, b2s(key), b2s(value))
 }
@@ -129,6 +129,56 @@ func TestArgsPeekMulti(t *testing.T) {
 	}
 }
 
+func TestArgsVisitAllMulti(t *testing.T) {
+	var a Args
+	a.Parse("tags=a&foo=bar&tags=b&x=&tags=c")
+
+	var result []string
+	a.VisitAllMulti(func(key []byte, values [][]byte) {
+		result = append(result, fmt.Sprintf("%s=%q", key, values))
+	})
+	expectedResult := []string{
+		`tags=["a" "b" "c"]`,
+		`foo=["bar"]`,
+		`x=[""]`,
+	}
+	if !reflect.DeepEqual(result, expectedResult) {
+		t.Fatalf("unexpected result %q. Expecting %q", result, expectedResult)
+	}
+}
+
+func TestArgsMultiValues(t *testing.T) {
+	var a Args
+	a.Parse("tags=a&tags=b&foo=bar&tags=c")
+	if s := a.String(); s != "tags=a&tags=b&foo=bar&tags=c" {
+		t.Fatalf("unexpected args %q. Expecting %q", s, "tags=a&tags=b&foo=bar&tags=c")
+	}
+
+	// Set must replace all the values for the given key.
+	a.Set("tags", "x")
+	if s := a.String(); s != "tags=x&foo=bar" {
+		t.Fatalf("unexpected args %q. Expecting %q", s, "tags=x&foo=bar")
+	}
+	a.Add("tags", "y")
+	a.AddBytesKV([]byte("tags"), []byte("z"))
+	vv := a.PeekMulti("tags")
+	expectedVV := [][]byte{[]byte("x"), []byte("y"), []byte("z")}
+	if !reflect.DeepEqual(vv, expectedVV) {
+		t.Fatalf("unexpected values %q. Expecting %q", vv, expectedVV)
+	}
+
+	// Del must delete all the values for the given key,
+	// including adjacent ones.
+	a.Parse("tags=a&tags=b&tags=c&foo=bar")
+	a.Del("tags")
+	if s := a.String(); s != "foo=bar" {
+		t.Fatalf("unexpected args %q. Expecting %q", s, "foo=bar")
+	}
+	if a.Has("tags") {
+		t.Fatalf("unexpected tags after deletion")
+	}
+}
+
 func TestArgsEscape(t *testing.T) {
 	testArgsEscape(t, "foo", "bar", "foo=bar")
 	testArgsEscape(t, "f.o,1:2/4", "~`!@#$%^&*()_-=+\\|/[]{};:'\"<>,./?",
@@ -0,0 +1,176 @@
+// Package autocert provides automatic TLS certificate provisioning
+// for fasthttp.Server via ACME protocol, e.g. from Let's Encrypt.
+//
+// The package is based on golang.org/x/crypto/acme/autocert.
+// Both tls-alpn-01 and http-01 challenges are supported.
+//
+// Example usage:
+//
+//	m := &autocert.Manager{
+//		Hosts: []string{"example.com", "www.example.com"},
+//		Email: "admin@example.com",
+//		Cache: autocert.DirCache("/var/cache/myapp-certs"),
+//	}
+//
+//	// Serve http-01 challenges and redirect other requests to https.
+//	go fasthttp.ListenAndServe(":80", m.HTTPHandler(nil))
+//
+//	s := &fasthttp.Server{
+//		Handler:   requestHandler,
+//		TLSConfig: m.TLSConfig(),
+//	}
+//	if err := s.ListenAndServeTLS(":443", "", ""); err != nil {
+//		log.Fatalf("error in ListenAndServeTLS: %s", err)
+//	}
+package autocert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"golang.org/x/crypto/acme"
+	xautocert "golang.org/x/crypto/acme/autocert"
+)
+
+// Cache stores account keys, certificates and challenge tokens
+// for Manager.
+//
+// Get must return ErrCacheMiss if the key isn't found.
+// Cache implementations must be safe for concurrent use.
+type Cache interface {
+	// Get returns data for the given key.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Put stores data under the given key.
+	Put(ctx context.Context, key string, data []byte) error
+
+	// Delete removes data for the given key.
+	//
+	// Delete mustn't return error if the key isn't found.
+	Delete(ctx context.Context, key string) error
+}
+
+// ErrCacheMiss must be returned from Cache.Get for missing keys.
+var ErrCacheMiss = xautocert.ErrCacheMiss
+
+// DirCache returns Cache storing data in files in the given dir.
+//
+// The dir is created on the first Put if it doesn't exist.
+func DirCache(dir string) Cache {
+	return xautocert.DirCache(dir)
+}
+
+// Manager obtains and renews TLS certificates for the given Hosts.
+//
+// It is forbidden copying Manager instances. Create new instances instead.
+type Manager struct {
+	// Hosts contains domain names the certificates are requested for.
+	//
+	// Certificates for other server names aren't requested, so the ACME
+	// account rate limits cannot be exhausted by arbitrary SNI values.
+	Hosts []string
+
+	// Email is an optional contact email for the ACME account.
+	Email string
+
+	// Cache stores certificates between restarts.
+	//
+	// Certificates are requested on every start if Cache isn't set.
+	// This may quickly hit ACME rate limits, so always set Cache
+	// in production.
+	Cache Cache
+
+	// DirectoryURL is ACME directory URL.
+	//
+	// By default Let's Encrypt production directory is used.
+	DirectoryURL string
+
+	// RenewBefore is the duration before certificate expiration
+	// when the certificate is renewed.
+	//
+	// By default certificates are renewed 30 days before expiration.
+	RenewBefore time.Duration
+
+	once sync.Once
+	m    *xautocert.Manager
+	h    fasthttp.RequestHandler
+}
+
+func (m *Manager) init() {
+	m.once.Do(func() {
+		m.m = &xautocert.Manager{
+			Prompt:      xautocert.AcceptTOS,
+			HostPolicy:  xautocert.HostWhitelist(m.Hosts...),
+			Email:       m.Email,
+			RenewBefore: m.RenewBefore,
+		}
+		if m.Cache != nil {
+			m.m.Cache = m.Cache
+		}
+		if len(m.DirectoryURL) > 0 {
+			m.m.Client = &acme.Client{
+				DirectoryURL: m.DirectoryURL,
+			}
+		}
+		m.h = fasthttpadaptor.NewFastHTTPHandler(m.m.HTTPHandler(nil))
+	})
+}
+
+// GetCertificate returns certificate for the given TLS client hello.
+//
+// The certificate is obtained via ACME on the first request for the host
+// and is renewed in background after that. GetCertificate responds
+// to tls-alpn-01 challenges if the client hello contains acme.ALPNProto.
+//
+// GetCertificate may be used as tls.Config.GetCertificate.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.init()
+	return m.m.GetCertificate(hello)
+}
+
+// TLSConfig returns TLS config for Server.TLSConfig.
+//
+// The returned config obtains certificates via GetCertificate
+// and advertises acme.ALPNProto for tls-alpn-01 challenges.
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"http/1.1", acme.ALPNProto},
+	}
+}
+
+var challengePathPrefix = []byte("/.well-known/acme-challenge/")
+
+// HTTPHandler returns request handler serving http-01 challenges.
+//
+// Other requests are passed to fallback. GET and HEAD requests
+// are redirected to https if fallback is nil, while the remaining requests
+// are rejected with 400 Bad Request.
+func (m *Manager) HTTPHandler(fallback fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if bytes.HasPrefix(ctx.Path(), challengePathPrefix) {
+			m.init()
+			m.h(ctx)
+			return
+		}
+		if fallback != nil {
+			fallback(ctx)
+			return
+		}
+		if !ctx.IsGet() && !ctx.IsHead() {
+			ctx.Error("Use HTTPS", fasthttp.StatusBadRequest)
+			return
+		}
+		host := string(ctx.Host())
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		ctx.Redirect("https://"+host+string(ctx.URI().RequestURI()), fasthttp.StatusFound)
+	}
+}
@@ -0,0 +1,147 @@
+package autocert
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/acme"
+)
+
+type memoryCache struct {
+	lock sync.Mutex
+	m    map[string][]byte
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	data, ok := c.m[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	return data, nil
+}
+
+func (c *memoryCache) Put(ctx context.Context, key string, data []byte) error {
+	c.lock.Lock()
+	c.m[key] = data
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *memoryCache) Delete(ctx context.Context, key string) error {
+	c.lock.Lock()
+	delete(c.m, key)
+	c.lock.Unlock()
+	return nil
+}
+
+func testHTTPHandler(h fasthttp.RequestHandler, method, uri string) *fasthttp.RequestCtx {
+	var ctx fasthttp.RequestCtx
+	var req fasthttp.Request
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	ctx.Init(&req, nil, nil)
+	h(&ctx)
+	return &ctx
+}
+
+func TestManagerHTTPHandlerChallenge(t *testing.T) {
+	c := &memoryCache{
+		m: map[string][]byte{
+			"foobar+http-01": []byte("foobar.key-auth"),
+		},
+	}
+	m := &Manager{
+		Hosts: []string{"example.com"},
+		Cache: c,
+	}
+	h := m.HTTPHandler(nil)
+
+	ctx := testHTTPHandler(h, "GET", "http://example.com/.well-known/acme-challenge/foobar")
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", ctx.Response.StatusCode(), fasthttp.StatusOK)
+	}
+	if string(ctx.Response.Body()) != "foobar.key-auth" {
+		t.Fatalf("unexpected body: %q. Expecting %q", ctx.Response.Body(), "foobar.key-auth")
+	}
+
+	// Challenges for unknown hosts must be rejected.
+	ctx = testHTTPHandler(h, "GET", "http://foo.com/.well-known/acme-challenge/foobar")
+	if ctx.Response.StatusCode() != fasthttp.StatusForbidden {
+		t.Fatalf("unexpected status code: %d. Expecting %d", ctx.Response.StatusCode(), fasthttp.StatusForbidden)
+	}
+
+	// Unknown tokens must be rejected.
+	ctx = testHTTPHandler(h, "GET", "http://example.com/.well-known/acme-challenge/unknown")
+	if ctx.Response.StatusCode() != fasthttp.StatusNotFound {
+		t.Fatalf("unexpected status code: %d. Expecting %d", ctx.Response.StatusCode(), fasthttp.StatusNotFound)
+	}
+}
+
+func TestManagerHTTPHandlerRedirect(t *testing.T) {
+	m := &Manager{
+		Hosts: []string{"example.com"},
+	}
+	h := m.HTTPHandler(nil)
+
+	ctx := testHTTPHandler(h, "GET", "http://example.com:80/foo/bar?baz=1")
+	if ctx.Response.StatusCode() != fasthttp.StatusFound {
+		t.Fatalf("unexpected status code: %d. Expecting %d", ctx.Response.StatusCode(), fasthttp.StatusFound)
+	}
+	location := string(ctx.Response.Header.Peek("Location"))
+	if location != "https://example.com/foo/bar?baz=1" {
+		t.Fatalf("unexpected location: %q. Expecting %q", location, "https://example.com/foo/bar?baz=1")
+	}
+
+	ctx = testHTTPHandler(h, "POST", "http://example.com/foo")
+	if ctx.Response.StatusCode() != fasthttp.StatusBadRequest {
+		t.Fatalf("unexpected status code: %d. Expecting %d", ctx.Response.StatusCode(), fasthttp.StatusBadRequest)
+	}
+
+	h = m.HTTPHandler(func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("fallback")
+	})
+	ctx = testHTTPHandler(h, "POST", "http://example.com/foo")
+	if string(ctx.Response.Body()) != "fallback" {
+		t.Fatalf("unexpected body: %q. Expecting %q", ctx.Response.Body(), "fallback")
+	}
+}
+
+func TestManagerGetCertificateHostPolicy(t *testing.T) {
+	m := &Manager{
+		Hosts: []string{"example.com"},
+		Cache: &memoryCache{
+			m: make(map[string][]byte),
+		},
+	}
+	// Certificates for unknown hosts mustn't be requested.
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "foo.com"}); err == nil {
+		t.Fatalf("expecting error for unknown host")
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Fatalf("expecting error for missing server name")
+	}
+}
+
+func TestManagerTLSConfig(t *testing.T) {
+	m := &Manager{
+		Hosts: []string{"example.com"},
+	}
+	cfg := m.TLSConfig()
+	if cfg.GetCertificate == nil {
+		t.Fatalf("GetCertificate must be set")
+	}
+	found := false
+	for _, proto := range cfg.NextProtos {
+		if proto == acme.ALPNProto {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("%q must be advertised via ALPN. NextProtos: %q", acme.ALPNProto, cfg.NextProtos)
+	}
+}
@@ -0,0 +1,116 @@
+package fasthttp
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBatchConcurrency is the maximum number of concurrent requests
+// sent by BatchFetcher if BatchFetcher.Concurrency isn't set.
+const DefaultBatchConcurrency = 16
+
+// BatchResultFunc is called by BatchFetcher for each fetched url.
+//
+// resp contains the last response received after following redirects.
+// resp contents are undefined if err isn't nil. resp is returned
+// to the pool after the function returns, so it mustn't be retained.
+// Copy resp contents if they must be used after returning.
+type BatchResultFunc func(url string, resp *Response, err error)
+
+// BatchFetcher fetches multiple urls with bounded concurrency.
+//
+// Redirects are followed according to Client.RedirectPolicy
+// like in Client.Get. Requests and responses are obtained from the pool
+// and are re-used between urls.
+//
+// It is safe calling BatchFetcher methods from concurrently running
+// goroutines.
+type BatchFetcher struct {
+	// Client is used for sending requests.
+	//
+	// The default client is used if not set.
+	Client *Client
+
+	// The maximum number of concurrent requests.
+	//
+	// DefaultBatchConcurrency is used if not set.
+	Concurrency int
+
+	// Timeout for fetching a single url including redirects.
+	//
+	// By default requests are limited only by the Client timeouts.
+	Timeout time.Duration
+}
+
+// Fetch fetches the given urls and calls f with the result for each url.
+//
+// f is called from concurrently running goroutines in the order urls
+// are fetched, which may differ from the order of urls.
+// Fetch returns after all the urls are fetched and all f calls return.
+func (b *BatchFetcher) Fetch(urls []string, f BatchResultFunc) {
+	concurrency := b.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	if concurrency > len(urls) {
+		concurrency = len(urls)
+	}
+
+	var wg sync.WaitGroup
+	var next uint32
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.fetchWorker(urls, &next, f)
+		}()
+	}
+	wg.Wait()
+}
+
+func (b *BatchFetcher) fetchWorker(urls []string, next *uint32, f BatchResultFunc) {
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+
+	d := &batchDoer{
+		c: b.Client,
+	}
+	if d.c == nil {
+		d.c = &defaultClient
+	}
+	policy := d.c.RedirectPolicy
+	for {
+		n := int(atomic.AddUint32(next, 1)) - 1
+		if n >= len(urls) {
+			return
+		}
+		url := urls[n]
+
+		req.Reset()
+		resp.Reset()
+		d.deadline = zeroTime
+		if b.Timeout > 0 {
+			d.deadline = time.Now().Add(b.Timeout)
+		}
+		setRequestURL(req, url)
+		_, err := followRedirects(req, resp, url, policy.maxRedirectsCount(), d, policy)
+		f(url, resp, err)
+	}
+}
+
+// batchDoer limits all the requests sent while following redirects
+// by the same deadline.
+type batchDoer struct {
+	c        *Client
+	deadline time.Time
+}
+
+func (d *batchDoer) Do(req *Request, resp *Response) error {
+	if d.deadline.IsZero() {
+		return d.c.Do(req, resp)
+	}
+	return d.c.DoDeadline(req, resp, d.deadline)
+}
@@ -0,0 +1,94 @@
+package fasthttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestBatchFetcher(t *testing.T) {
+	var concurrency, maxConcurrency int32
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/slow":
+				// The handler continues execution after the client
+				// times out, so it isn't counted in concurrency.
+				time.Sleep(300 * time.Millisecond)
+			case "/redirect":
+				ctx.Redirect("/target", StatusFound)
+				return
+			default:
+				n := atomic.AddInt32(&concurrency, 1)
+				for {
+					m := atomic.LoadInt32(&maxConcurrency)
+					if n <= m || atomic.CompareAndSwapInt32(&maxConcurrency, m, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&concurrency, -1)
+			}
+			ctx.Write(ctx.Path())
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	b := &BatchFetcher{
+		Client: &Client{
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		},
+		Concurrency: 5,
+		Timeout:     100 * time.Millisecond,
+	}
+
+	var urls []string
+	for i := 0; i < 50; i++ {
+		urls = append(urls, fmt.Sprintf("http://foobar/%d", i))
+	}
+	urls = append(urls, "http://foobar/slow", "http://foobar/redirect")
+
+	var lock sync.Mutex
+	results := make(map[string]string)
+	b.Fetch(urls, func(url string, resp *Response, err error) {
+		result := string(resp.Body())
+		if err != nil {
+			result = err.Error()
+			if !errors.Is(err, ErrTimeout) {
+				t.Errorf("unexpected error for %q: %s", url, err)
+			}
+		}
+		lock.Lock()
+		results[url] = result
+		lock.Unlock()
+	})
+
+	if len(results) != len(urls) {
+		t.Fatalf("unexpected number of results: %d. Expecting %d", len(results), len(urls))
+	}
+	for i := 0; i < 50; i++ {
+		url := fmt.Sprintf("http://foobar/%d", i)
+		if result, expected := results[url], fmt.Sprintf("/%d", i); result != expected {
+			t.Fatalf("unexpected result for %q: %q. Expecting %q", url, result, expected)
+		}
+	}
+	if result := results["http://foobar/redirect"]; result != "/target" {
+		t.Fatalf("unexpected result for redirect: %q. Expecting %q", result, "/target")
+	}
+	if result := results["http://foobar/slow"]; result == "/slow" {
+		t.Fatalf("expecting timeout error for slow url")
+	}
+	if n := atomic.LoadInt32(&maxConcurrency); n > 5 {
+		t.Fatalf("too many concurrent requests: %d. Expecting up to 5", n)
+	}
+}
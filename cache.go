@@ -0,0 +1,440 @@
+package fasthttp
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultCacheMaxEntries is the maximum number of entries stored
+// in LRUCacheStorage if LRUCacheStorage.MaxEntries isn't set.
+const DefaultCacheMaxEntries = 1024
+
+// maxHeuristicFreshness limits freshness lifetime calculated
+// from Last-Modified response header.
+const maxHeuristicFreshness = 24 * time.Hour
+
+// CacheEntry is a response stored in CacheStorage.
+//
+// CacheEntry mustn't be modified after it is passed to CacheStorage.Set,
+// since it may be read concurrently.
+type CacheEntry struct {
+	// Header contains response status line and headers in HTTP/1.1 format.
+	Header []byte
+
+	// Body contains response body as received from the server.
+	Body []byte
+
+	// Vary contains values of request headers listed in Vary response
+	// header for the request the response has been received for.
+	Vary []byte
+
+	// ResponseTime is the time the response has been received at.
+	ResponseTime time.Time
+}
+
+// CacheStorage stores responses cached by Client.
+//
+// See Client.Cache for details.
+//
+// CacheStorage implementations must be safe for concurrent use.
+type CacheStorage interface {
+	// Get returns the entry stored under the given key.
+	//
+	// nil must be returned if there is no entry for the key.
+	Get(key string) *CacheEntry
+
+	// Set stores the entry under the given key, replacing
+	// the previously stored entry.
+	Set(key string, e *CacheEntry)
+
+	// Delete deletes the entry stored under the given key.
+	Delete(key string)
+}
+
+// LRUCacheStorage is an in-memory CacheStorage, which evicts the least
+// recently used entries when the maximum number of entries is reached.
+//
+// It is safe calling LRUCacheStorage methods from concurrently running
+// goroutines.
+type LRUCacheStorage struct {
+	// The maximum number of entries in the storage.
+	//
+	// DefaultCacheMaxEntries is used if not set.
+	MaxEntries int
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List
+}
+
+type lruCacheItem struct {
+	key string
+	e   *CacheEntry
+}
+
+// Get returns the entry stored under the given key or nil
+// if there is no such entry.
+func (s *LRUCacheStorage) Get(key string) *CacheEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	el := s.entries[key]
+	if el == nil {
+		return nil
+	}
+	s.lru.MoveToFront(el)
+	return el.Value.(*lruCacheItem).e
+}
+
+// Set stores the entry under the given key.
+//
+// The least recently used entries are evicted if the number of entries
+// exceeds MaxEntries.
+func (s *LRUCacheStorage) Set(key string, e *CacheEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if el := s.entries[key]; el != nil {
+		el.Value.(*lruCacheItem).e = e
+		s.lru.MoveToFront(el)
+		return
+	}
+	if s.entries == nil {
+		s.entries = make(map[string]*list.Element)
+	}
+	s.entries[key] = s.lru.PushFront(&lruCacheItem{
+		key: key,
+		e:   e,
+	})
+
+	maxEntries := s.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+	for s.lru.Len() > maxEntries {
+		el := s.lru.Back()
+		s.lru.Remove(el)
+		delete(s.entries, el.Value.(*lruCacheItem).key)
+	}
+}
+
+// Delete deletes the entry stored under the given key.
+func (s *LRUCacheStorage) Delete(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if el := s.entries[key]; el != nil {
+		s.lru.Remove(el)
+		delete(s.entries, key)
+	}
+}
+
+// Len returns the number of entries in the storage.
+func (s *LRUCacheStorage) Len() int {
+	s.lock.Lock()
+	n := s.lru.Len()
+	s.lock.Unlock()
+	return n
+}
+
+func (c *Client) doCached(req *Request, resp *Response, deadline time.Time) error {
+	if !req.Header.IsGet() {
+		err := c.doUncached(req, resp, deadline)
+		if err == nil && !isSafeMethod(req) && resp.Header.StatusCode() < 400 {
+			// Successful unsafe requests invalidate the stored response.
+			// See RFC 7234, section 4.4.
+			c.Cache.Delete(cacheKey(req))
+		}
+		return err
+	}
+
+	var reqCC cacheControl
+	reqCC.parse(req.Header.peek(strCacheControl))
+	if reqCC.noStore || len(req.Header.peek(strIfNoneMatch)) > 0 ||
+		len(req.Header.peek(strIfModifiedSince)) > 0 || len(req.Header.peek(strRange)) > 0 {
+		// The caller manages conditional and partial requests on its own.
+		return c.doUncached(req, resp, deadline)
+	}
+
+	key := cacheKey(req)
+	cached := AcquireResponse()
+	defer ReleaseResponse(cached)
+	e := c.Cache.Get(key)
+	if e != nil && !e.matches(req, &cached.Header) {
+		e = nil
+	}
+
+	if e != nil && !c.RevalidateCachedResponses {
+		var cc cacheControl
+		cc.parse(cached.Header.peek(strCacheControl))
+		age := e.age(&cached.Header, time.Now())
+		fresh := !cc.noCache && !reqCC.noCache && age < freshnessLifetime(e, &cached.Header, &cc) &&
+			(!reqCC.hasMaxAge || age <= reqCC.maxAge)
+		if fresh {
+			e.writeTo(resp, &cached.Header, age)
+			return nil
+		}
+	}
+
+	// Revalidate the stored response with a conditional request.
+	// See RFC 7234, section 4.3.
+	var etag, lastModified []byte
+	if e != nil {
+		etag = cached.Header.peek(strETag)
+		if len(etag) > 0 {
+			req.Header.SetCanonical(strIfNoneMatch, etag)
+		}
+		lastModified = cached.Header.peek(strLastModified)
+		if len(lastModified) > 0 {
+			req.Header.SetCanonical(strIfModifiedSince, lastModified)
+		}
+	}
+	err := c.doUncached(req, resp, deadline)
+	if len(etag) > 0 {
+		req.Header.DelBytes(strIfNoneMatch)
+	}
+	if len(lastModified) > 0 {
+		req.Header.DelBytes(strIfModifiedSince)
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if e != nil && resp.Header.StatusCode() == StatusNotModified {
+		// Update the stored response with the headers from 304 response.
+		// See RFC 7234, section 4.3.4.
+		if len(resp.Header.peek(strDate)) == 0 {
+			// The stored Date doesn't match the revalidated response.
+			cached.Header.DelBytes(strDate)
+		}
+		visitArgs(resp.Header.h, func(k, v []byte) {
+			if !bytes.Equal(k, strTransferEncoding) && !bytes.Equal(k, strConnection) {
+				cached.Header.SetCanonical(k, v)
+			}
+		})
+		updated := &CacheEntry{
+			Header:       appendCacheHeader(nil, &cached.Header, len(e.Body)),
+			Body:         e.Body,
+			Vary:         e.Vary,
+			ResponseTime: now,
+		}
+		c.Cache.Set(key, updated)
+		e.writeTo(resp, &cached.Header, 0)
+		return nil
+	}
+
+	if isCacheableResponse(resp, c.RevalidateCachedResponses) {
+		body := resp.Body()
+		c.Cache.Set(key, &CacheEntry{
+			Header:       appendCacheHeader(nil, &resp.Header, len(body)),
+			Body:         append([]byte(nil), body...),
+			Vary:         appendVaryValues(nil, resp.Header.peek(strVary), req),
+			ResponseTime: now,
+		})
+	}
+	return nil
+}
+
+// matches parses e.Header into h and returns true if e may be used
+// for the given request.
+func (e *CacheEntry) matches(req *Request, h *ResponseHeader) bool {
+	if _, err := h.parse(e.Header); err != nil {
+		return false
+	}
+	vary := appendVaryValues(nil, h.peek(strVary), req)
+	return bytes.Equal(vary, e.Vary)
+}
+
+// age returns the current age of the stored response with the header h.
+//
+// See RFC 7234, section 4.2.3.
+func (e *CacheEntry) age(h *ResponseHeader, now time.Time) time.Duration {
+	initialAge := time.Duration(0)
+	if n, err := ParseUint(h.peek(strAge)); err == nil {
+		initialAge = time.Duration(n) * time.Second
+	}
+	if date, err := ParseHTTPDate(h.peek(strDate)); err == nil {
+		if apparentAge := e.ResponseTime.Sub(date); apparentAge > initialAge {
+			initialAge = apparentAge
+		}
+	}
+	age := initialAge + now.Sub(e.ResponseTime)
+	if age < 0 {
+		age = 0
+	}
+	return age
+}
+
+// writeTo writes the stored response with the header h to resp.
+func (e *CacheEntry) writeTo(resp *Response, h *ResponseHeader, age time.Duration) {
+	resp.Reset()
+	h.CopyTo(&resp.Header)
+	if age > 0 {
+		resp.Header.SetCanonical(strAge, AppendUint(nil, int(age/time.Second)))
+	}
+	resp.SetBody(e.Body)
+}
+
+// freshnessLifetime returns the freshness lifetime of the stored response
+// with the header h.
+//
+// See RFC 7234, section 4.2.1.
+func freshnessLifetime(e *CacheEntry, h *ResponseHeader, cc *cacheControl) time.Duration {
+	if cc.hasMaxAge {
+		return cc.maxAge
+	}
+	date, err := ParseHTTPDate(h.peek(strDate))
+	if err != nil {
+		date = e.ResponseTime
+	}
+	if expiresStr := h.peek(strExpires); len(expiresStr) > 0 {
+		expires, err := ParseHTTPDate(expiresStr)
+		if err != nil {
+			// Invalid Expires header means the response is already expired.
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if lastModified, err := ParseHTTPDate(h.peek(strLastModified)); err == nil {
+		// Heuristic freshness. See RFC 7234, section 4.2.2.
+		d := date.Sub(lastModified) / 10
+		if d > maxHeuristicFreshness {
+			d = maxHeuristicFreshness
+		}
+		return d
+	}
+	return 0
+}
+
+// isCacheableResponse returns true if resp may be stored in the cache.
+//
+// Only responses with validators are cacheable if revalidate is set.
+func isCacheableResponse(resp *Response, revalidate bool) bool {
+	switch resp.Header.StatusCode() {
+	case StatusOK, StatusNonAuthoritativeInfo, StatusNoContent, StatusMultipleChoices,
+		StatusMovedPermanently, StatusNotFound, StatusMethodNotAllowed, StatusGone,
+		StatusRequestURITooLong, StatusNotImplemented:
+	default:
+		return false
+	}
+
+	h := &resp.Header
+	var cc cacheControl
+	cc.parse(h.peek(strCacheControl))
+	if cc.noStore {
+		return false
+	}
+	if bytes.Equal(bytes.TrimSpace(h.peek(strVary)), strStar) {
+		return false
+	}
+	hasValidators := len(h.peek(strETag)) > 0 || len(h.peek(strLastModified)) > 0
+	if revalidate {
+		return hasValidators
+	}
+	// Responses without freshness information and validators
+	// cannot be served from the cache.
+	return hasValidators || cc.hasMaxAge || len(h.peek(strExpires)) > 0
+}
+
+func isSafeMethod(req *Request) bool {
+	if req.Header.IsGet() || req.Header.IsHead() {
+		return true
+	}
+	method := req.Header.Method()
+	return bytes.Equal(method, strOptions) || bytes.Equal(method, strTrace)
+}
+
+// cacheKey returns the key for the response to req in CacheStorage.
+func cacheKey(req *Request) string {
+	uri := req.URI()
+	return string(uri.Scheme()) + "://" + string(uri.Host()) + string(uri.RequestURI())
+}
+
+// appendCacheHeader appends h in HTTP/1.1 format to dst for storing
+// in CacheEntry.
+//
+// Set-Cookie headers aren't stored, so cookies aren't replayed
+// from the cache.
+func appendCacheHeader(dst []byte, h *ResponseHeader, contentLength int) []byte {
+	dst = append(dst, statusLine(h.StatusCode())...)
+	if len(h.contentType) > 0 {
+		dst = appendHeaderLine(dst, strContentType, h.contentType)
+	}
+	if len(h.server) > 0 {
+		dst = appendHeaderLine(dst, strServer, h.server)
+	}
+	dst = appendHeaderLine(dst, strContentLength, AppendUint(nil, contentLength))
+	visitArgs(h.h, func(k, v []byte) {
+		if !bytes.Equal(k, strTransferEncoding) && !bytes.Equal(k, strConnection) {
+			dst = appendHeaderLine(dst, k, v)
+		}
+	})
+	return append(dst, strCRLF...)
+}
+
+// appendVaryValues appends values of request headers listed in vary
+// to dst.
+func appendVaryValues(dst, vary []byte, req *Request) []byte {
+	for len(vary) > 0 {
+		var name []byte
+		n := bytes.IndexByte(vary, ',')
+		if n < 0 {
+			name, vary = vary, nil
+		} else {
+			name, vary = vary[:n], vary[n+1:]
+		}
+		name = bytes.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		dst = append(dst, name...)
+		dst = append(dst, ':')
+		dst = append(dst, req.Header.PeekBytes(name)...)
+		dst = append(dst, '\n')
+	}
+	return dst
+}
+
+// cacheControl contains Cache-Control directives used by Client.Cache.
+type cacheControl struct {
+	noStore   bool
+	noCache   bool
+	hasMaxAge bool
+	maxAge    time.Duration
+}
+
+func (cc *cacheControl) parse(b []byte) {
+	for len(b) > 0 {
+		var directive []byte
+		n := bytes.IndexByte(b, ',')
+		if n < 0 {
+			directive, b = b, nil
+		} else {
+			directive, b = b[:n], b[n+1:]
+		}
+		var value []byte
+		if n := bytes.IndexByte(directive, '='); n >= 0 {
+			directive, value = directive[:n], bytes.Trim(directive[n+1:], " \t\"")
+		}
+		directive = bytes.TrimSpace(directive)
+		switch {
+		case bytes.EqualFold(directive, strNoStore):
+			cc.noStore = true
+		case bytes.EqualFold(directive, strNoCache):
+			cc.noCache = true
+		case bytes.EqualFold(directive, strMaxAge):
+			if n, err := ParseUint(value); err == nil {
+				cc.hasMaxAge = true
+				cc.maxAge = time.Duration(n) * time.Second
+			} else {
+				// Invalid max-age means the response is stale.
+				// See RFC 7234, section 1.2.1.
+				cc.hasMaxAge = true
+				cc.maxAge = 0
+			}
+		}
+	}
+}
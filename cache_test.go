@@ -0,0 +1,225 @@
+package fasthttp
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestLRUCacheStorage(t *testing.T) {
+	s := &LRUCacheStorage{MaxEntries: 2}
+	e1 := &CacheEntry{Body: []byte("1")}
+	e2 := &CacheEntry{Body: []byte("2")}
+	e3 := &CacheEntry{Body: []byte("3")}
+
+	s.Set("a", e1)
+	s.Set("b", e2)
+	if e := s.Get("a"); e != e1 {
+		t.Fatalf("unexpected entry %v. Expecting %v", e, e1)
+	}
+
+	// "b" is the least recently used entry, so it must be evicted.
+	s.Set("c", e3)
+	if n := s.Len(); n != 2 {
+		t.Fatalf("unexpected number of entries: %d. Expecting 2", n)
+	}
+	if e := s.Get("b"); e != nil {
+		t.Fatalf("unexpected entry %v. Expecting nil", e)
+	}
+	if e := s.Get("c"); e != e3 {
+		t.Fatalf("unexpected entry %v. Expecting %v", e, e3)
+	}
+
+	s.Set("a", e2)
+	if e := s.Get("a"); e != e2 {
+		t.Fatalf("unexpected entry %v. Expecting %v", e, e2)
+	}
+	s.Delete("a")
+	if e := s.Get("a"); e != nil {
+		t.Fatalf("unexpected entry %v. Expecting nil", e)
+	}
+	if n := s.Len(); n != 1 {
+		t.Fatalf("unexpected number of entries: %d. Expecting 1", n)
+	}
+}
+
+func TestCacheControlParse(t *testing.T) {
+	testCacheControlParse(t, "", false, false, false, 0)
+	testCacheControlParse(t, "no-store", true, false, false, 0)
+	testCacheControlParse(t, "No-Cache, max-age=10", false, true, true, 10)
+	testCacheControlParse(t, "public, max-age=\"3600\"", false, false, true, 3600)
+	testCacheControlParse(t, "max-age=foo", false, false, true, 0)
+}
+
+func testCacheControlParse(t *testing.T, s string, noStore, noCache, hasMaxAge bool, maxAgeSeconds int) {
+	var cc cacheControl
+	cc.parse([]byte(s))
+	if cc.noStore != noStore {
+		t.Fatalf("unexpected noStore for %q: %v. Expecting %v", s, cc.noStore, noStore)
+	}
+	if cc.noCache != noCache {
+		t.Fatalf("unexpected noCache for %q: %v. Expecting %v", s, cc.noCache, noCache)
+	}
+	if cc.hasMaxAge != hasMaxAge {
+		t.Fatalf("unexpected hasMaxAge for %q: %v. Expecting %v", s, cc.hasMaxAge, hasMaxAge)
+	}
+	if int(cc.maxAge.Seconds()) != maxAgeSeconds {
+		t.Fatalf("unexpected maxAge for %q: %s. Expecting %ds", s, cc.maxAge, maxAgeSeconds)
+	}
+}
+
+func TestClientCache(t *testing.T) {
+	var hits uint32
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			n := atomic.AddUint32(&hits, 1)
+			switch string(ctx.Path()) {
+			case "/fresh":
+				ctx.Response.Header.Set("Cache-Control", "max-age=60")
+			case "/etag":
+				ctx.Response.Header.Set("Cache-Control", "no-cache")
+				ctx.Response.Header.Set("ETag", `"v1"`)
+				if string(ctx.Request.Header.Peek("If-None-Match")) == `"v1"` {
+					ctx.SetStatusCode(StatusNotModified)
+					return
+				}
+			case "/vary":
+				ctx.Response.Header.Set("Cache-Control", "max-age=60")
+				ctx.Response.Header.Set("Vary", "Accept-Language")
+			case "/no-store":
+				ctx.Response.Header.Set("Cache-Control", "no-store, max-age=60")
+			}
+			fmt.Fprintf(ctx, "response %d", n)
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		Cache: &LRUCacheStorage{},
+	}
+
+	// Fresh responses must be served from the cache.
+	testClientCacheGet(t, c, "/fresh", "", "response 1")
+	testClientCacheGet(t, c, "/fresh", "", "response 1")
+
+	// Stale responses must be revalidated.
+	testClientCacheGet(t, c, "/etag", "", "response 2")
+	testClientCacheGet(t, c, "/etag", "", "response 2")
+	if n := atomic.LoadUint32(&hits); n != 3 {
+		t.Fatalf("unexpected number of server hits: %d. Expecting 3", n)
+	}
+
+	// Responses must be stored per Vary request header values.
+	testClientCacheGet(t, c, "/vary", "en", "response 4")
+	testClientCacheGet(t, c, "/vary", "en", "response 4")
+	testClientCacheGet(t, c, "/vary", "de", "response 5")
+
+	// no-store responses mustn't be stored.
+	testClientCacheGet(t, c, "/no-store", "", "response 6")
+	testClientCacheGet(t, c, "/no-store", "", "response 7")
+
+	// Unsafe requests must invalidate the stored response.
+	var req Request
+	var resp Response
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("http://example.com/fresh")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testClientCacheGet(t, c, "/fresh", "", "response 9")
+	testClientCacheGet(t, c, "/fresh", "", "response 9")
+
+	// Requests with no-cache must bypass fresh responses.
+	req.Reset()
+	req.SetRequestURI("http://example.com/fresh")
+	req.Header.Set("Cache-Control", "no-cache")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "response 10" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "response 10")
+	}
+}
+
+func testClientCacheGet(t *testing.T, c *Client, path, acceptLanguage, expectedBody string) {
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com" + path)
+	if len(acceptLanguage) > 0 {
+		req.Header.Set("Accept-Language", acceptLanguage)
+	}
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code for %q: %d. Expecting %d", path, resp.StatusCode(), StatusOK)
+	}
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body for %q: %q. Expecting %q", path, resp.Body(), expectedBody)
+	}
+	if len(req.Header.Peek("If-None-Match")) > 0 {
+		t.Fatalf("unexpected If-None-Match header left in the request %q", path)
+	}
+}
+
+func TestClientRevalidateCachedResponses(t *testing.T) {
+	var hits, notModified uint32
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			n := atomic.AddUint32(&hits, 1)
+			switch string(ctx.Path()) {
+			case "/etag":
+				ctx.Response.Header.Set("Cache-Control", "max-age=60")
+				ctx.Response.Header.Set("ETag", `"v1"`)
+				if string(ctx.Request.Header.Peek("If-None-Match")) == `"v1"` {
+					atomic.AddUint32(&notModified, 1)
+					ctx.SetStatusCode(StatusNotModified)
+					return
+				}
+			case "/last-modified":
+				ctx.Response.Header.Set("Last-Modified", "Sat, 01 Jan 2022 00:00:00 GMT")
+				if len(ctx.Request.Header.Peek("If-Modified-Since")) > 0 {
+					atomic.AddUint32(&notModified, 1)
+					ctx.SetStatusCode(StatusNotModified)
+					return
+				}
+			case "/fresh":
+				ctx.Response.Header.Set("Cache-Control", "max-age=60")
+			}
+			fmt.Fprintf(ctx, "response %d", n)
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		Cache:                     &LRUCacheStorage{},
+		RevalidateCachedResponses: true,
+	}
+
+	for i := 0; i < 3; i++ {
+		testClientCacheGet(t, c, "/etag", "", "response 1")
+	}
+	for i := 0; i < 3; i++ {
+		testClientCacheGet(t, c, "/last-modified", "", "response 4")
+	}
+	if n := atomic.LoadUint32(&notModified); n != 4 {
+		t.Fatalf("unexpected number of 304 responses: %d. Expecting 4", n)
+	}
+
+	// Responses without validators mustn't be stored.
+	testClientCacheGet(t, c, "/fresh", "", "response 7")
+	testClientCacheGet(t, c, "/fresh", "", "response 8")
+}
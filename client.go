@@ -3,15 +3,22 @@ package fasthttp
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"net"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/valyala/fasthttp/proxyproto"
+	"golang.org/x/net/http2"
 )
 
 // Do performs the given http request and fills the given http response.
@@ -24,7 +31,8 @@ import (
 //   - from RequestURI if it contains full url with scheme and host;
 //   - from Host header otherwise.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get*
+// for following redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -48,7 +56,8 @@ func Do(req *Request, resp *Response) error {
 //   - from RequestURI if it contains full url with scheme and host;
 //   - from Host header otherwise.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get*
+// for following redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -75,7 +84,8 @@ func DoTimeout(req *Request, resp *Response, timeout time.Duration) error {
 //   - from RequestURI if it contains full url with scheme and host;
 //   - from Host header otherwise.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get*
+// for following redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -91,6 +101,37 @@ func DoDeadline(req *Request, resp *Response, deadline time.Time) error {
 	return defaultClient.DoDeadline(req, resp, deadline)
 }
 
+// DoContext performs the given request and waits for response until
+// the given context is done.
+//
+// Request must contain at least non-zero RequestURI with full url (including
+// scheme and host) or non-zero Host header + RequestURI.
+//
+// The function doesn't follow redirects. Use DoRedirects or Get*
+// for following redirects.
+//
+// Response is ignored if resp is nil.
+//
+// The request is limited by ctx deadline. Pending I/O on the request
+// connection is interrupted when ctx is cancelled, so the function returns
+// shortly after ctx is done. ctx.Err() is returned in this case. The streamed
+// response body (if any) is also interrupted when ctx is done.
+//
+// req and resp aren't copied, so the caller must own them
+// until the function returns. This makes the function suitable
+// for latency-critical code such as proxies.
+func DoContext(ctx context.Context, req *Request, resp *Response) error {
+	return defaultClient.DoContext(ctx, req, resp)
+}
+
+// DoRedirects performs the given http request and fills the given http response,
+// following up to maxRedirectsCount redirects.
+//
+// See Client.DoRedirects for details.
+func DoRedirects(req *Request, resp *Response, maxRedirectsCount int) error {
+	return defaultClient.DoRedirects(req, resp, maxRedirectsCount)
+}
+
 // Get appends url contents to dst and returns it as body.
 //
 // The function follows redirects. Use Do* for manually handling redirects.
@@ -157,6 +198,19 @@ type Client struct {
 	// Default Dial is used if not set.
 	Dial DialFunc
 
+	// Callback for establishing new connections to hosts
+	// within the given timeout.
+	//
+	// DialWithTimeout takes precedence over Dial if both are set.
+	DialWithTimeout DialFuncWithTimeout
+
+	// Maximum duration for establishing new connection to the host,
+	// including attempts to dial all the host addresses.
+	//
+	// By default ReadTimeout+WriteTimeout is used if set, otherwise
+	// DefaultDialTimeout is used.
+	DialTimeout time.Duration
+
 	// Attempt to connect to both ipv4 and ipv6 addresses if set to true.
 	//
 	// This option is used only if default TCP dialer is used,
@@ -166,22 +220,102 @@ type Client struct {
 	// since unfortunately ipv6 remains broken in many networks worldwide :)
 	DialDualStack bool
 
+	// ProxyProtocolHeader returns PROXY protocol header, which is sent
+	// to the host on new connections before any other data including
+	// TLS handshake. This allows forwarding the original client address
+	// to hosts behind PROXY protocol listeners.
+	//
+	// conn is the dialed connection. The header isn't sent if
+	// ProxyProtocolHeader returns nil.
+	//
+	// Connections are reused among requests, so use distinct Client
+	// per original client for forwarding per-client addresses.
+	//
+	// By default PROXY protocol header isn't sent.
+	ProxyProtocolHeader func(conn net.Conn) *proxyproto.Header
+
 	// TLS config for https connections.
 	//
 	// Default TLS config is used if not set.
 	TLSConfig *tls.Config
 
+	// Root certificate authorities for verifying host certificates.
+	//
+	// Overrides TLSConfig.RootCAs if set. System root CAs are used
+	// by default.
+	RootCAs *x509.CertPool
+
+	// Optional callback for additional verification of host certificates.
+	//
+	// It is called after the normal certificate verification.
+	//
+	// Overrides TLSConfig.VerifyPeerCertificate if set.
+	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+
+	// Disables verification of host certificates if set to true.
+	//
+	// This makes https connections vulnerable to man-in-the-middle attacks,
+	// so use it only for testing or for hosts with self-signed certificates
+	// if RootCAs cannot be used.
+	//
+	// By default host certificates are verified.
+	InsecureSkipTLSVerify bool
+
+	// Maximum duration for TLS handshake with hosts.
+	//
+	// DefaultTLSHandshakeTimeout is used if not set.
+	TLSHandshakeTimeout time.Duration
+
+	// Client certificates presented to hosts requiring mutual TLS.
+	//
+	// Overrides TLSConfig.Certificates if set.
+	ClientCertificates []tls.Certificate
+
+	// Callback returning client certificate for hosts requiring mutual TLS.
+	//
+	// Use ClientCertificateLoader.GetClientCertificate for reloading
+	// rotated certificates from files.
+	//
+	// Overrides TLSConfig.GetClientCertificate if set.
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
 	// Maximum number of connections per each host which may be established.
 	//
 	// DefaultMaxConnsPerHost is used if not set.
 	MaxConnsPerHost int
 
+	// Maximum duration for waiting for a free connection to the host
+	// if all the MaxConnsPerHost connections are busy.
+	//
+	// By default ErrNoFreeConns is returned immediately.
+	MaxConnWaitTimeout time.Duration
+
 	// Idle keep-alive connections are closed after this duration.
 	//
 	// By default idle connections are closed
 	// after DefaultMaxIdleConnDuration.
 	MaxIdleConnDuration time.Duration
 
+	// HostClient instances for hosts, which weren't requested
+	// for this duration, are evicted together with their idle connections.
+	//
+	// By default HostClient instances are evicted
+	// after DefaultMaxIdleHostClientDuration.
+	MaxIdleHostClientDuration time.Duration
+
+	// Strategy for picking idle keep-alive connections to hosts.
+	//
+	// By default LIFO is used.
+	ConnPoolStrategy ConnPoolStrategyType
+
+	// Idle keep-alive connections are checked for liveness before re-use
+	// if this option is set.
+	//
+	// See HostClient.CheckIdleConns for details.
+	//
+	// By default idle connections are re-used without checks.
+	CheckIdleConns bool
+
 	// Per-connection buffer size for responses' reading.
 	// This also limits the maximum header size.
 	//
@@ -205,12 +339,22 @@ type Client struct {
 
 	// Maximum response body size.
 	//
-	// The client returns ErrBodyTooLarge if this limit is greater than 0
-	// and response body is greater than the limit.
+	// The client returns ErrPartialResponseBody wrapping ErrBodyTooLarge
+	// if this limit is greater than 0 and response body is greater
+	// than the limit.
 	//
 	// By default response body size is unlimited.
 	MaxResponseBodySize int
 
+	// The received part of the response body is kept
+	// in ErrPartialResponseBody.Body if this option is set.
+	//
+	// ErrPartialResponseBody is returned if the response body exceeds
+	// MaxResponseBodySize or if the body cannot be read completely.
+	//
+	// By default only the number of received body bytes is reported.
+	KeepPartialResponseBody bool
+
 	// Header names are passed as-is without normalization
 	// if this option is set.
 	//
@@ -229,9 +373,133 @@ type Client struct {
 	//     * cONTENT-lenGTH -> Content-Length
 	DisableHeaderNamesNormalizing bool
 
-	mLock sync.Mutex
-	m     map[string]*HostClient
-	ms    map[string]*HostClient
+	// Path values are sent as-is without normalization if this option is set.
+	//
+	// See HostClient.DisablePathNormalizing for details.
+	DisablePathNormalizing bool
+
+	// Response bodies are streamed from the connection if this option is set.
+	//
+	// See HostClient.StreamResponseBody for details.
+	StreamResponseBody bool
+
+	// HTTP/2 is negotiated via TLS ALPN for https requests
+	// if this option is set.
+	//
+	// See HostClient.EnableHTTP2 for details.
+	EnableHTTP2 bool
+
+	// Policy for following redirects in Get* and Post calls.
+	//
+	// By default up to DefaultMaxRedirectsCount redirects are followed.
+	RedirectPolicy *RedirectPolicy
+
+	// Policy for retrying failed requests.
+	//
+	// By default idempotent requests are retried immediately
+	// up to DefaultMaxAttempts times.
+	RetryPolicy *RetryPolicy
+
+	// Callback for deciding whether the failed request must be retried.
+	//
+	// See HostClient.RetryIf for details.
+	RetryIf RetryIfFunc
+
+	// Transport for performing requests.
+	//
+	// DefaultTransport is used if not set.
+	Transport Transport
+
+	// Hooks called in order before performing each request.
+	//
+	// See HostClient.BeforeRequest for details.
+	BeforeRequest []BeforeRequestHook
+
+	// Hooks called in order after performing each request.
+	//
+	// See HostClient.AfterResponse for details.
+	AfterResponse []AfterResponseHook
+
+	// MetricsCollector receives events for all the requests performed
+	// by the client.
+	//
+	// See HostClient.MetricsCollector for details.
+	MetricsCollector MetricsCollector
+
+	// Instrumenter starts spans for all the requests performed
+	// by the client.
+	//
+	// See HostClient.Instrumenter for details.
+	Instrumenter ClientInstrumenter
+
+	// CredentialsProvider returns Authorization header value before each
+	// request attempt, including requests to redirect locations.
+	//
+	// The provider is called for requests to all the hosts, so it must
+	// check req.URI().Host() if the credentials mustn't be sent
+	// to arbitrary hosts.
+	//
+	// See HostClient.CredentialsProvider for details.
+	CredentialsProvider CredentialsProvider
+
+	// SignRequest is called before writing each request attempt
+	// to the connection.
+	//
+	// See HostClient.SignRequest for details.
+	SignRequest func(req *Request) error
+
+	// CookieJar stores cookies from responses and sends them back
+	// with subsequent requests to matching hosts and paths.
+	//
+	// Cookies already set in the request aren't overwritten.
+	//
+	// By default cookies aren't stored.
+	CookieJar *CookieJar
+
+	// Cache stores responses to GET requests, so fresh responses are served
+	// without contacting the server, while stale responses are revalidated
+	// with If-None-Match and If-Modified-Since requests.
+	//
+	// Cache-Control, Expires and Vary response headers are respected
+	// according to RFC 7234 for private caches. Requests with conditional
+	// or Range headers and streamed responses bypass the cache.
+	// Successful POST, PUT and DELETE requests invalidate the response
+	// stored for the request uri.
+	//
+	// Use LRUCacheStorage for in-memory caching.
+	//
+	// By default responses aren't cached.
+	Cache CacheStorage
+
+	// RevalidateCachedResponses makes Client revalidate responses stored
+	// in Cache on each request with If-None-Match and If-Modified-Since
+	// headers, regardless of the response freshness. Only responses
+	// with ETag or Last-Modified headers are stored then, and the stored
+	// response is returned when the server responds with 304 Not Modified.
+	//
+	// This is useful for polling APIs, since unchanged resources
+	// aren't transferred over and over again, while changes
+	// are noticed immediately.
+	//
+	// By default fresh responses are served from Cache without
+	// revalidation.
+	RevalidateCachedResponses bool
+
+	// ConfigureHostClient is called for each HostClient created by Client
+	// before the HostClient is used for the first request.
+	//
+	// The callback may adjust HostClient settings for particular hosts,
+	// for instance, MaxConns, TLS config or Dial. The host may be obtained
+	// from HostClient.Addr, while HostClient.IsTLS is set for https hosts.
+	//
+	// The callback is called under Client lock, so it mustn't call
+	// Client methods.
+	ConfigureHostClient func(hc *HostClient)
+
+	m  hostClientMap
+	ms hostClientMap
+
+	cleanerRunning uint32
 }
 
 // Get appends url contents to dst and returns it as body.
@@ -240,7 +508,7 @@ type Client struct {
 //
 // New body buffer is allocated if dst is nil.
 func (c *Client) Get(dst []byte, url string) (statusCode int, body []byte, err error) {
-	return clientGetURL(dst, url, c)
+	return clientGetURL(dst, url, c, c.RedirectPolicy)
 }
 
 // GetTimeout appends url contents to dst and returns it as body.
@@ -252,7 +520,7 @@ func (c *Client) Get(dst []byte, url string) (statusCode int, body []byte, err e
 // ErrTimeout error is returned if url contents couldn't be fetched
 // during the given timeout.
 func (c *Client) GetTimeout(dst []byte, url string, timeout time.Duration) (statusCode int, body []byte, err error) {
-	return clientGetURLTimeout(dst, url, timeout, c)
+	return clientGetURLTimeout(dst, url, timeout, c, c.RedirectPolicy)
 }
 
 // GetDeadline appends url contents to dst and returns it as body.
@@ -264,7 +532,7 @@ func (c *Client) GetTimeout(dst []byte, url string, timeout time.Duration) (stat
 // ErrTimeout error is returned if url contents couldn't be fetched
 // until the given deadline.
 func (c *Client) GetDeadline(dst []byte, url string, deadline time.Time) (statusCode int, body []byte, err error) {
-	return clientGetURLDeadline(dst, url, deadline, c)
+	return clientGetURLDeadline(dst, url, deadline, c, c.RedirectPolicy)
 }
 
 // Post sends POST request to the given url with the given POST arguments.
@@ -277,7 +545,7 @@ func (c *Client) GetDeadline(dst []byte, url string, deadline time.Time) (status
 //
 // Empty POST body is sent if postArgs is nil.
 func (c *Client) Post(dst []byte, url string, postArgs *Args) (statusCode int, body []byte, err error) {
-	return clientPostURL(dst, url, postArgs, c)
+	return clientPostURL(dst, url, postArgs, c, c.RedirectPolicy)
 }
 
 // DoTimeout performs the given request and waits for response during
@@ -291,7 +559,8 @@ func (c *Client) Post(dst []byte, url string, postArgs *Args) (statusCode int, b
 //   - from RequestURI if it contains full url with scheme and host;
 //   - from Host header otherwise.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get*
+// for following redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -318,7 +587,8 @@ func (c *Client) DoTimeout(req *Request, resp *Response, timeout time.Duration)
 //   - from RequestURI if it contains full url with scheme and host;
 //   - from Host header otherwise.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get*
+// for following redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -334,6 +604,37 @@ func (c *Client) DoDeadline(req *Request, resp *Response, deadline time.Time) er
 	return clientDoDeadline(req, resp, deadline, c)
 }
 
+// DoContext performs the given request and waits for response until
+// the given context is done.
+//
+// Pending I/O on the request connection is interrupted when ctx
+// is cancelled, and ctx.Err() is returned. req and resp aren't copied,
+// so the caller must own them until the function returns.
+//
+// See DoContext for details.
+func (c *Client) DoContext(ctx context.Context, req *Request, resp *Response) error {
+	return clientDoContext(ctx, req, resp, c)
+}
+
+// DoRedirects performs the given http request and fills the given http
+// response, following up to maxRedirectsCount redirects.
+//
+// Redirects are followed according to Client.RedirectPolicy, while
+// its MaxRedirectsCount is ignored. Redirects aren't followed
+// if maxRedirectsCount isn't positive. ErrTooManyRedirects is returned
+// if the number of redirects exceeds maxRedirectsCount.
+//
+// req is updated while following redirects, i.e. it contains the last
+// request sent to the redirect location when the function returns.
+//
+// Response is ignored if resp is nil.
+//
+// It is recommended obtaining req and resp via AcquireRequest
+// and AcquireResponse in performance-critical code.
+func (c *Client) DoRedirects(req *Request, resp *Response, maxRedirectsCount int) error {
+	return clientDoRedirects(req, resp, maxRedirectsCount, c, c.RedirectPolicy)
+}
+
 // Do performs the given http request and fills the given http response.
 //
 // Request must contain at least non-zero RequestURI with full url (including
@@ -344,9 +645,14 @@ func (c *Client) DoDeadline(req *Request, resp *Response, deadline time.Time) er
 //   - from RequestURI if it contains full url with scheme and host;
 //   - from Host header otherwise.
 //
+// The request is sent to the address set via Request.SetDialAddr
+// if it is set. Host header and TLS server name are still determined
+// by the server to be requested in this case.
+//
 // Response is ignored if resp is nil.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get*
+// for following redirects.
 //
 // ErrNoFreeConns is returned if all Client.MaxConnsPerHost connections
 // to the requested host are busy.
@@ -354,83 +660,272 @@ func (c *Client) DoDeadline(req *Request, resp *Response, deadline time.Time) er
 // It is recommended obtaining req and resp via AcquireRequest
 // and AcquireResponse in performance-critical code.
 func (c *Client) Do(req *Request, resp *Response) error {
-	uri := req.URI()
-	host := uri.Host()
+	return c.doDeadline(req, resp, zeroTime)
+}
+
+func (c *Client) doDeadline(req *Request, resp *Response, deadline time.Time) error {
+	if c.CookieJar != nil {
+		return c.doWithCookieJar(req, resp, deadline)
+	}
+	return c.doCacheable(req, resp, deadline)
+}
+
+func (c *Client) doCacheable(req *Request, resp *Response, deadline time.Time) error {
+	if c.Cache != nil && resp != nil && !resp.StreamBody && !c.StreamResponseBody {
+		return c.doCached(req, resp, deadline)
+	}
+	return c.doUncached(req, resp, deadline)
+}
+
+func (c *Client) doUncached(req *Request, resp *Response, deadline time.Time) error {
+	hc, err := c.hostClient(req.URI(), req.TLSServerName(), req.DialAddr())
+	if err != nil {
+		return err
+	}
+	return hc.doDeadline(req, resp, deadline)
+}
+
+// Warmup establishes up to n connections to the host from the given url
+// in advance. See HostClient.Warmup for details.
+func (c *Client) Warmup(url string, n int) error {
+	uri := AcquireURI()
+	uri.Parse(nil, []byte(url))
+	hc, err := c.hostClient(uri, "", "")
+	ReleaseURI(uri)
+	if err != nil {
+		return err
+	}
+	return hc.Warmup(n)
+}
+
+// hostClient returns HostClient for the given uri.
+//
+// serverName is used only for https uris. The returned HostClient
+// connects to dialAddr instead of the uri host if dialAddr isn't empty.
+func (c *Client) hostClient(uri *URI, serverName, dialAddr string) (*HostClient, error) {
+	// Internationalized host names are dialed and verified
+	// in punycode form.
+	host, err := uri.hostASCIIErr()
+	if err != nil {
+		return nil, err
+	}
 
 	isTLS := false
 	scheme := uri.Scheme()
 	if bytes.Equal(scheme, strHTTPS) {
 		isTLS = true
 	} else if !bytes.Equal(scheme, strHTTP) {
-		return fmt.Errorf("unsupported protocol %q. http and https are supported", scheme)
+		return nil, fmt.Errorf("unsupported protocol %q. http and https are supported", scheme)
 	}
 
-	startCleaner := false
-
-	c.mLock.Lock()
-	m := c.m
+	m := &c.m
 	if isTLS {
-		m = c.ms
+		m = &c.ms
 	}
-	if m == nil {
-		m = make(map[string]*HostClient)
-		if isTLS {
-			c.ms = m
-		} else {
-			c.m = m
+	if len(dialAddr) == 0 && (!isTLS || len(serverName) == 0) {
+		// Fast path - the host key matches the host,
+		// so it may be looked up without memory allocations.
+		if hc := m.get(b2s(host)); hc != nil {
+			return hc, nil
 		}
 	}
-	hc := m[string(host)]
-	if hc == nil {
-		hc = &HostClient{
-			Addr:                          addMissingPort(string(host), isTLS),
+
+	hostKey := string(host)
+	addr := addMissingPort(string(host), isTLS)
+	if len(dialAddr) > 0 {
+		// Connections to distinct addresses mustn't be shared.
+		addr = addMissingPort(dialAddr, isTLS)
+		hostKey += "\x01" + addr
+		if isTLS && len(serverName) == 0 {
+			// Verify the certificate against the requested host
+			// instead of the dial address.
+			serverName = tlsServerName(string(host))
+		}
+	}
+	if !isTLS {
+		serverName = ""
+	} else if len(serverName) > 0 {
+		// Connections with distinct TLS server names
+		// mustn't be shared.
+		hostKey += "\x00" + serverName
+	}
+
+	hc, created := m.getOrCreate(hostKey, func() *HostClient {
+		hc := &HostClient{
+			Addr:                          addr,
 			Name:                          c.Name,
 			Dial:                          c.Dial,
+			DialWithTimeout:               c.DialWithTimeout,
+			DialTimeout:                   c.DialTimeout,
 			DialDualStack:                 c.DialDualStack,
+			ProxyProtocolHeader:           c.ProxyProtocolHeader,
 			IsTLS:                         isTLS,
 			TLSConfig:                     c.TLSConfig,
+			TLSServerName:                 serverName,
+			RootCAs:                       c.RootCAs,
+			VerifyPeerCertificate:         c.VerifyPeerCertificate,
+			InsecureSkipTLSVerify:         c.InsecureSkipTLSVerify,
+			TLSHandshakeTimeout:           c.TLSHandshakeTimeout,
+			ClientCertificates:            c.ClientCertificates,
+			GetClientCertificate:          c.GetClientCertificate,
 			MaxConns:                      c.MaxConnsPerHost,
+			MaxConnWaitTimeout:            c.MaxConnWaitTimeout,
 			MaxIdleConnDuration:           c.MaxIdleConnDuration,
+			ConnPoolStrategy:              c.ConnPoolStrategy,
+			CheckIdleConns:                c.CheckIdleConns,
 			ReadBufferSize:                c.ReadBufferSize,
 			WriteBufferSize:               c.WriteBufferSize,
 			ReadTimeout:                   c.ReadTimeout,
 			WriteTimeout:                  c.WriteTimeout,
 			MaxResponseBodySize:           c.MaxResponseBodySize,
+			KeepPartialResponseBody:       c.KeepPartialResponseBody,
 			DisableHeaderNamesNormalizing: c.DisableHeaderNamesNormalizing,
+			DisablePathNormalizing:        c.DisablePathNormalizing,
+			StreamResponseBody:            c.StreamResponseBody,
+			EnableHTTP2:                   c.EnableHTTP2,
+			RetryPolicy:                   c.RetryPolicy,
+			RetryIf:                       c.RetryIf,
+			Transport:                     c.Transport,
+			BeforeRequest:                 c.BeforeRequest,
+			AfterResponse:                 c.AfterResponse,
+			MetricsCollector:              c.MetricsCollector,
+			Instrumenter:                  c.Instrumenter,
+			CredentialsProvider:           c.CredentialsProvider,
+			SignRequest:                   c.SignRequest,
 		}
-		m[string(host)] = hc
-		if len(m) == 1 {
-			startCleaner = true
+		if c.ConfigureHostClient != nil {
+			c.ConfigureHostClient(hc)
 		}
+		// Do not evict the HostClient before the first request.
+		hc.updateLastUseTime()
+		return hc
+	})
+	if created {
+		c.startCleaner()
 	}
-	c.mLock.Unlock()
+	return hc, nil
+}
 
-	if startCleaner {
-		go c.mCleaner(m)
+// hostClientShardsCount is the number of shards in hostClientMap.
+//
+// Sharding reduces lock contention when Client sends requests
+// to many hosts from concurrently running goroutines.
+const hostClientShardsCount = 64
+
+// hostClientMap maps host keys to HostClient instances.
+type hostClientMap struct {
+	shards [hostClientShardsCount]hostClientShard
+}
+
+type hostClientShard struct {
+	lock sync.RWMutex
+	m    map[string]*HostClient
+}
+
+func (m *hostClientMap) shard(hostKey string) *hostClientShard {
+	// FNV-1a hash
+	h := uint32(2166136261)
+	for i := 0; i < len(hostKey); i++ {
+		h ^= uint32(hostKey[i])
+		h *= 16777619
 	}
+	return &m.shards[h%hostClientShardsCount]
+}
 
-	return hc.Do(req, resp)
+func (m *hostClientMap) get(hostKey string) *HostClient {
+	shard := m.shard(hostKey)
+	shard.lock.RLock()
+	hc := shard.m[hostKey]
+	shard.lock.RUnlock()
+	return hc
 }
 
-func (c *Client) mCleaner(m map[string]*HostClient) {
-	mustStop := false
-	for {
-		t := time.Now()
-		c.mLock.Lock()
-		for k, v := range m {
-			if t.Sub(v.LastUseTime()) > time.Minute {
-				delete(m, k)
+// getOrCreate returns HostClient for the given hostKey.
+//
+// The HostClient is created via newHostClient if it is missing.
+// The second returned value is set to true in this case.
+func (m *hostClientMap) getOrCreate(hostKey string, newHostClient func() *HostClient) (*HostClient, bool) {
+	shard := m.shard(hostKey)
+	shard.lock.RLock()
+	hc := shard.m[hostKey]
+	shard.lock.RUnlock()
+	if hc != nil {
+		return hc, false
+	}
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if hc = shard.m[hostKey]; hc != nil {
+		// The HostClient has been created by concurrent goroutine.
+		return hc, false
+	}
+	hc = newHostClient()
+	if shard.m == nil {
+		shard.m = make(map[string]*HostClient)
+	}
+	shard.m[hostKey] = hc
+	return hc, true
+}
+
+// deleteUnused deletes HostClient instances, which weren't used
+// for longer than maxIdleDuration before the given time t,
+// and returns the number of remaining instances. Idle connections
+// of the deleted instances are closed.
+//
+// Shards are locked one by one, so concurrent lookups in other shards
+// aren't blocked.
+func (m *hostClientMap) deleteUnused(t time.Time, maxIdleDuration time.Duration) int {
+	n := 0
+	var deleted []*HostClient
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.lock.Lock()
+		for k, hc := range shard.m {
+			if t.Sub(hc.LastUseTime()) > maxIdleDuration {
+				delete(shard.m, k)
+				deleted = append(deleted, hc)
 			}
 		}
-		if len(m) == 0 {
-			mustStop = true
-		}
-		c.mLock.Unlock()
+		n += len(shard.m)
+		shard.lock.Unlock()
+	}
 
-		if mustStop {
-			break
+	// Close connections outside the shard locks, since this may be slow.
+	for _, hc := range deleted {
+		hc.closeIdleConns()
+	}
+	return n
+}
+
+func (c *Client) startCleaner() {
+	if atomic.CompareAndSwapUint32(&c.cleanerRunning, 0, 1) {
+		go c.mCleaner()
+	}
+}
+
+func (c *Client) mCleaner() {
+	maxIdleDuration := c.MaxIdleHostClientDuration
+	if maxIdleDuration <= 0 {
+		maxIdleDuration = DefaultMaxIdleHostClientDuration
+	}
+	sleepDuration := 10 * time.Second
+	if maxIdleDuration < sleepDuration {
+		sleepDuration = maxIdleDuration
+	}
+	for {
+		time.Sleep(sleepDuration)
+		t := time.Now()
+		if c.m.deleteUnused(t, maxIdleDuration)+c.ms.deleteUnused(t, maxIdleDuration) > 0 {
+			continue
+		}
+		atomic.StoreUint32(&c.cleanerRunning, 0)
+		// HostClient may be added concurrently after the cleanup.
+		// The cleaner must continue running in this case, since
+		// the goroutine adding the HostClient may have failed
+		// starting the new cleaner.
+		if c.m.deleteUnused(t, maxIdleDuration)+c.ms.deleteUnused(t, maxIdleDuration) == 0 || !atomic.CompareAndSwapUint32(&c.cleanerRunning, 0, 1) {
+			return
 		}
-		time.Sleep(10 * time.Second)
 	}
 }
 
@@ -439,10 +934,49 @@ func (c *Client) mCleaner(m map[string]*HostClient) {
 // Client.MaxConnsPerHost isn't set).
 const DefaultMaxConnsPerHost = 512
 
+// ConnPoolStrategyType determines the order in which idle keep-alive
+// connections are re-used.
+type ConnPoolStrategyType int
+
+const (
+	// LIFO re-uses the most recently used idle connection first.
+	LIFO ConnPoolStrategyType = iota
+
+	// FIFO re-uses the least recently used idle connection first.
+	FIFO
+)
+
+// AddrSelectionType determines how HostClient chooses the address
+// for new connections among addresses listed in HostClient.Addr.
+type AddrSelectionType int
+
+const (
+	// AddrRoundRobin chooses addresses in a round-robin manner
+	// taking into account their weights.
+	AddrRoundRobin AddrSelectionType = iota
+
+	// AddrLeastConns chooses the address with the least number
+	// of in-flight requests relative to its weight. Equally loaded
+	// addresses are chosen in a round-robin manner.
+	//
+	// This prevents from hammering slow upstream hosts, since requests
+	// to them are in-flight for longer periods of time.
+	// Each HTTP/2 connection is counted as a single in-flight request.
+	AddrLeastConns
+)
+
 // DefaultMaxIdleConnDuration is the default duration before idle keep-alive
 // connection is closed.
 const DefaultMaxIdleConnDuration = 10 * time.Second
 
+// DefaultMaxIdleHostClientDuration is the default duration before HostClient
+// for the host, which isn't requested by Client, is evicted.
+const DefaultMaxIdleHostClientDuration = time.Minute
+
+// DefaultTLSHandshakeTimeout is the default timeout for TLS handshake
+// with the host.
+const DefaultTLSHandshakeTimeout = 10 * time.Second
+
 // DialFunc must establish connection to addr.
 //
 // There is no need in establishing TLS (SSL) connection for https.
@@ -457,6 +991,21 @@ const DefaultMaxIdleConnDuration = 10 * time.Second
 //   - foobar.com:8080
 type DialFunc func(addr string) (net.Conn, error)
 
+// DialFuncWithTimeout must establish connection to addr
+// within the given timeout.
+//
+// There is no need in establishing TLS (SSL) connection for https.
+// The client automatically converts connection to TLS
+// if HostClient.IsTLS is set.
+//
+// TCP address passed to DialFuncWithTimeout always contains host and port.
+// Example TCP addr values:
+//
+//   - foobar.com:80
+//   - foobar.com:443
+//   - foobar.com:8080
+type DialFuncWithTimeout func(addr string, timeout time.Duration) (net.Conn, error)
+
 // HostClient balances http requests among hosts listed in Addr.
 //
 // HostClient may be used for balancing load among multiple upstream hosts.
@@ -479,6 +1028,25 @@ type HostClient struct {
 	//    - foobar.com:80
 	//    - foobar.com:443
 	//    - foobar.com:8080
+	//
+	// Unix domain sockets may be specified with unix: prefix
+	// if default dialer is used. For example,
+	//
+	//    - unix:/var/run/app.sock
+	//
+	// Host header is always taken from the request uri (or from Host header
+	// if the request uri doesn't contain host), so Addr may point to IPs,
+	// unix sockets or proxies without affecting Host header. TLS server
+	// name is determined by TLSServerName or by Addr otherwise.
+	//
+	// Each address may be followed by a positive integer weight in the form
+	// ' w=N'. Addresses with higher weights receive proportionally more
+	// connections. For example, the first address receives three times
+	// more connections than the second one in the following list:
+	//
+	//    - foo.com:80 w=3,bar.com:80 w=1
+	//
+	// The default weight is 1.
 	Addr string
 
 	// Client name. Used in User-Agent request header.
@@ -489,6 +1057,22 @@ type HostClient struct {
 	// Default Dial is used if not set.
 	Dial DialFunc
 
+	// Callback for establishing new connection to the host
+	// within the given timeout.
+	//
+	// DialWithTimeout takes precedence over Dial if both are set.
+	DialWithTimeout DialFuncWithTimeout
+
+	// Maximum duration for establishing new connection to the host,
+	// including attempts to dial all the addresses from Addr.
+	//
+	// The remaining duration is passed to DialWithTimeout on each
+	// dial attempt.
+	//
+	// By default ReadTimeout+WriteTimeout is used if set, otherwise
+	// DefaultDialTimeout is used.
+	DialTimeout time.Duration
+
 	// Attempt to connect to both ipv4 and ipv6 host addresses
 	// if set to true.
 	//
@@ -499,18 +1083,99 @@ type HostClient struct {
 	// since unfortunately ipv6 remains broken in many networks worldwide :)
 	DialDualStack bool
 
+	// ProxyProtocolHeader returns PROXY protocol header, which is sent
+	// to the host on new connections before any other data including
+	// TLS handshake. This allows forwarding the original client address
+	// to hosts behind PROXY protocol listeners.
+	//
+	// conn is the dialed connection. The header isn't sent if
+	// ProxyProtocolHeader returns nil.
+	//
+	// Connections are reused among requests, so use distinct HostClient
+	// per original client for forwarding per-client addresses.
+	//
+	// By default PROXY protocol header isn't sent.
+	ProxyProtocolHeader func(conn net.Conn) *proxyproto.Header
+
 	// Whether to use TLS (aka SSL or HTTPS) for host connections.
 	IsTLS bool
 
 	// Optional TLS config.
 	TLSConfig *tls.Config
 
+	// TLS server name (SNI) presented to the host.
+	//
+	// Host certificates are verified against this name. This allows
+	// connecting to an IP or alternate address listed in Addr while
+	// validating against the intended host name.
+	//
+	// Overrides TLSConfig.ServerName if set. By default the server name
+	// is obtained from the dialed address.
+	TLSServerName string
+
+	// Root certificate authorities for verifying host certificates.
+	//
+	// Overrides TLSConfig.RootCAs if set. System root CAs are used
+	// by default.
+	RootCAs *x509.CertPool
+
+	// Optional callback for additional verification of host certificates.
+	//
+	// It is called after the normal certificate verification.
+	//
+	// Overrides TLSConfig.VerifyPeerCertificate if set.
+	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+
+	// Disables verification of host certificates if set to true.
+	//
+	// This makes https connections vulnerable to man-in-the-middle attacks,
+	// so use it only for testing or for hosts with self-signed certificates
+	// if RootCAs cannot be used.
+	//
+	// By default host certificates are verified.
+	InsecureSkipTLSVerify bool
+
+	// Maximum duration for TLS handshake with the host.
+	//
+	// The handshake is performed right after the connection is established,
+	// so stalled handshakes don't occupy connection slots until
+	// WriteTimeout or ReadTimeout fires. The next address from Addr
+	// is dialed if the handshake fails.
+	//
+	// DefaultTLSHandshakeTimeout is used if not set.
+	TLSHandshakeTimeout time.Duration
+
+	// Client certificates presented to the host if it requires mutual TLS.
+	//
+	// Overrides TLSConfig.Certificates if set.
+	ClientCertificates []tls.Certificate
+
+	// Callback returning client certificate if the host requires
+	// mutual TLS.
+	//
+	// The callback is called on each TLS handshake, so it may return
+	// rotated certificates. Use ClientCertificateLoader.GetClientCertificate
+	// for reloading certificates from files.
+	//
+	// Overrides TLSConfig.GetClientCertificate if set.
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
 	// Maximum number of connections which may be established to all hosts
 	// listed in Addr.
 	//
 	// DefaultMaxConnsPerHost is used if not set.
 	MaxConns int
 
+	// Maximum duration for waiting for a free connection
+	// if all the MaxConns connections are busy.
+	//
+	// Waiting requests obtain connections in FIFO order.
+	// ErrNoFreeConns is returned if no connection becomes free
+	// during the given duration or until the request deadline.
+	//
+	// By default ErrNoFreeConns is returned immediately.
+	MaxConnWaitTimeout time.Duration
+
 	// Keep-alive connections are closed after this duration.
 	//
 	// By default connection duration is unlimited.
@@ -522,6 +1187,68 @@ type HostClient struct {
 	// after DefaultMaxIdleConnDuration.
 	MaxIdleConnDuration time.Duration
 
+	// Strategy for picking idle keep-alive connections to the host.
+	//
+	// LIFO re-uses the most recently used connection, so the number
+	// of open connections shrinks under low load. FIFO cycles through
+	// all the idle connections evenly, so they are kept warm.
+	// This may be useful behind L4 load balancers, which close
+	// idle connections.
+	//
+	// By default LIFO is used.
+	ConnPoolStrategy ConnPoolStrategyType
+
+	// Idle keep-alive connections are checked for liveness before re-use
+	// if this option is set.
+	//
+	// The check is a non-blocking read from the connection, so connections
+	// closed by the server, NATs or load balancers while being idle
+	// are discarded instead of failing the request with ErrConnectionClosed.
+	// This is especially useful for non-idempotent requests,
+	// which aren't retried.
+	//
+	// The check is performed only on unix-like systems for connections
+	// implementing syscall.Conn, including TLS connections over them.
+	//
+	// By default idle connections are re-used without checks.
+	CheckIdleConns bool
+
+	// AddrSelection determines how the address for new connections
+	// is chosen if Addr contains multiple addresses.
+	//
+	// By default AddrRoundRobin is used.
+	AddrSelection AddrSelectionType
+
+	// HealthCheckInterval is the interval for active health checks
+	// of addresses listed in Addr.
+	//
+	// Unhealthy addresses are removed from rotation until they pass
+	// the health check again. All the addresses are used if all of them
+	// are unhealthy.
+	//
+	// Health checks are performed in background while HostClient
+	// has open connections.
+	//
+	// By default health checks are disabled.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckPath is the request path for HTTP health checks.
+	// The address is considered healthy if it responds with 2xx
+	// status code.
+	//
+	// By default health checks only establish TCP connection
+	// to the address.
+	HealthCheckPath string
+
+	// HealthCheckTimeout is the timeout for a single health check.
+	//
+	// By default DefaultHealthCheckTimeout is used.
+	HealthCheckTimeout time.Duration
+
+	// OnAddrHealthChange is called when the address is removed from
+	// rotation or re-added to it according to health checks.
+	OnAddrHealthChange func(addr string, healthy bool)
+
 	// Per-connection buffer size for responses' reading.
 	// This also limits the maximum header size.
 	//
@@ -545,12 +1272,22 @@ type HostClient struct {
 
 	// Maximum response body size.
 	//
-	// The client returns ErrBodyTooLarge if this limit is greater than 0
-	// and response body is greater than the limit.
+	// The client returns ErrPartialResponseBody wrapping ErrBodyTooLarge
+	// if this limit is greater than 0 and response body is greater
+	// than the limit.
 	//
 	// By default response body size is unlimited.
 	MaxResponseBodySize int
 
+	// The received part of the response body is kept
+	// in ErrPartialResponseBody.Body if this option is set.
+	//
+	// ErrPartialResponseBody is returned if the response body exceeds
+	// MaxResponseBodySize or if the body cannot be read completely.
+	//
+	// By default only the number of received body bytes is reported.
+	KeepPartialResponseBody bool
+
 	// Header names are passed as-is without normalization
 	// if this option is set.
 	//
@@ -569,17 +1306,157 @@ type HostClient struct {
 	//     * cONTENT-lenGTH -> Content-Length
 	DisableHeaderNamesNormalizing bool
 
-	clientName  atomic.Value
-	lastUseTime uint32
+	// Path values are sent as-is without normalization if this option is set.
+	//
+	// Disabled path normalization may be useful for proxying requests
+	// to servers, which expect paths to be forwarded as-is. For instance,
+	// servers may treat %2F and / differently.
+	//
+	// See URI.DisablePathNormalizing for details.
+	//
+	// By default path values are normalized, i.e. duplicate slashes
+	// are removed, dot segments are resolved and special characters
+	// are encoded.
+	DisablePathNormalizing bool
+
+	// Response bodies are streamed from the connection if this option is set.
+	//
+	// The response body must be read via Response.BodyStream then.
+	// The underlying connection is returned to the pool only after
+	// the body stream is read till io.EOF and closed via
+	// Response.CloseBodyStream or ReleaseResponse. The connection
+	// is closed if the body stream is closed before reaching io.EOF.
+	//
+	// This is useful for downloading huge response bodies without
+	// buffering them in memory.
+	//
+	// Response.StreamBody may be set for enabling streaming
+	// for a single response.
+	//
+	// By default response bodies are read into memory.
+	StreamResponseBody bool
+
+	// HTTP/2 is negotiated via TLS ALPN if this option is set
+	// and IsTLS is true.
+	//
+	// All the requests to the host are multiplexed over a single
+	// connection if the host supports HTTP/2. The client transparently
+	// falls back to HTTP/1.1 otherwise.
+	//
+	// By default only HTTP/1.1 is used.
+	EnableHTTP2 bool
+
+	// Policy for following redirects in Get* and Post calls.
+	//
+	// By default up to DefaultMaxRedirectsCount redirects are followed.
+	RedirectPolicy *RedirectPolicy
+
+	// Policy for retrying failed requests.
+	//
+	// By default idempotent requests are retried immediately
+	// up to DefaultMaxAttempts times.
+	RetryPolicy *RetryPolicy
+
+	// Callback for deciding whether the failed request must be retried.
+	//
+	// It is called with non-nil err if the request fails, and with nil err
+	// if the response status code is in RetryPolicy.RetryableStatusCodes.
+	// The request is retried if RetryIf returns true and the maximum number
+	// of attempts isn't reached yet. resp may be nil if nil response is passed
+	// to Do. resp contents are undefined if err isn't nil.
+	//
+	// Use it for retrying non-idempotent requests with idempotency keys
+	// or for disabling retries at all.
+	//
+	// By default only idempotent requests (GET, HEAD and PUT) are retried.
+	// Non-idempotent requests are retried only if the server closes
+	// the connection before sending the response.
+	RetryIf RetryIfFunc
+
+	// Transport for performing requests.
+	//
+	// DefaultTransport is used if not set.
+	Transport Transport
+
+	// Hooks called in order before performing each request.
+	//
+	// The hooks may modify the request, for instance, for injecting
+	// authorization headers. The request isn't performed if a hook returns
+	// an error. The error is passed to AfterResponse hooks then.
+	//
+	// The hooks are called once per Do call, i.e. retries don't trigger
+	// the hooks.
+	BeforeRequest []BeforeRequestHook
+
+	// Hooks called in order after performing each request.
+	//
+	// Each hook receives the error returned by the previous hook
+	// (or by the request itself for the first hook) and returns the error,
+	// which is returned to the caller from Do. This allows logging,
+	// collecting metrics and converting responses to errors.
+	AfterResponse []AfterResponseHook
+
+	// MetricsCollector receives request, connection and dial events.
+	//
+	// By default metrics aren't collected.
+	MetricsCollector MetricsCollector
+
+	// Instrumenter starts spans for requests, e.g. for distributed tracing.
+	//
+	// By default requests aren't instrumented.
+	Instrumenter ClientInstrumenter
+
+	// CredentialsProvider returns Authorization header value before each
+	// request attempt, so expiring credentials may be refreshed
+	// between attempts.
+	//
+	// By default Authorization header is sent as set in the request.
+	CredentialsProvider CredentialsProvider
+
+	// SignRequest is called before writing each request attempt
+	// to the connection, after the final request headers are set.
+	//
+	// Host, User-Agent and Content-Length headers are already
+	// set when SignRequest is called, so signature schemes covering
+	// canonical headers and body hashes (e.g. AWS SigV4) may be implemented
+	// by adding signature headers to req. Note that req.Body() reads
+	// request body stream into memory.
+	//
+	// The request fails without retries with the error returned
+	// by SignRequest.
+	//
+	// By default requests aren't signed.
+	SignRequest func(req *Request) error
+
+	clientName atomic.Value
+
+	// lastUseTime is the monotonic time in nanoseconds since startTime
+	// when the client was last used.
+	lastUseTime int64
 
 	connsLock  sync.Mutex
 	connsCount int
 	conns      []*clientConn
+	connsWait  []*wantConn
 
 	addrsLock sync.Mutex
 	addrs     []string
 	addrIdx   uint32
 
+	// addrWeights and addrCurrentWeights are used for smooth weighted
+	// round-robin if Addr contains weighted addresses.
+	addrWeights        []int
+	addrCurrentWeights []int
+
+	// addrRequests contains the number of in-flight requests per address
+	// if AddrSelection is set to AddrLeastConns.
+	addrRequests []int
+
+	// addrUnhealthy marks addresses failed health checks
+	// if HealthCheckInterval is set.
+	addrUnhealthy  []bool
+	unhealthyAddrs int
+
 	tlsConfigMap     map[string]*tls.Config
 	tlsConfigMapLock sync.Mutex
 
@@ -588,7 +1465,13 @@ type HostClient struct {
 
 	pendingRequests uint64
 
-	connsCleanerRun bool
+	connsCleanerRun  bool
+	healthCheckerRun bool
+
+	h2Lock       sync.Mutex
+	h2Conn       *http2.ClientConn
+	h2ClientConn *clientConn
+	h2Transport  *http2.Transport
 }
 
 type clientConn struct {
@@ -599,14 +1482,87 @@ type clientConn struct {
 
 	lastReadDeadlineTime  time.Time
 	lastWriteDeadlineTime time.Time
+
+	// These flags are set if the corresponding deadline has been set
+	// to the explicitly passed deadline instead of the timeout.
+	readDeadlineOverridden  bool
+	writeDeadlineOverridden bool
+
+	// addrIdx is the index of the connection address in HostClient.addrs.
+	addrIdx int
+
+	// inFlight is set if the connection is counted
+	// in HostClient.addrRequests.
+	inFlight bool
+
+	// dedicated is set for connections established for a single request
+	// via Request.SetDial or Request.SetConn.
+	dedicated bool
+
+	// external is set for connections passed via Request.SetConn.
+	// Such connections are never closed by the client.
+	external bool
+
+	// stopCtxWatcher stops interrupting the connection on the request
+	// context cancellation. It returns false if the connection
+	// has been already interrupted.
+	stopCtxWatcher func() bool
 }
 
-var startTimeUnix = time.Now().Unix()
+// startTime contains monotonic clock reading, so durations
+// between times obtained via startTime.Add aren't affected
+// by wall clock changes.
+var startTime = time.Now()
 
-// LastUseTime returns time the client was last used
+// LastUseTime returns time the client was last used.
+//
+// The returned time contains monotonic clock reading, so it may be safely
+// compared with time.Now().
 func (c *HostClient) LastUseTime() time.Time {
-	n := atomic.LoadUint32(&c.lastUseTime)
-	return time.Unix(startTimeUnix+int64(n), 0)
+	n := atomic.LoadInt64(&c.lastUseTime)
+	return startTime.Add(time.Duration(n))
+}
+
+func (c *HostClient) updateLastUseTime() {
+	atomic.StoreInt64(&c.lastUseTime, int64(time.Since(startTime)))
+}
+
+// ConnInfo contains information about HostClient connection.
+type ConnInfo struct {
+	// LocalAddr is the local address of the connection.
+	LocalAddr net.Addr
+
+	// RemoteAddr is the remote address of the connection.
+	RemoteAddr net.Addr
+
+	// CreatedTime is the time the connection has been established.
+	CreatedTime time.Time
+
+	// LastUseTime is the time the connection has been returned
+	// to the pool of idle connections after the last request.
+	LastUseTime time.Time
+}
+
+// VisitIdleConns calls f for each idle keep-alive connection in the pool.
+//
+// Connection times have second resolution. f is called without holding
+// HostClient locks, so it may call HostClient methods.
+func (c *HostClient) VisitIdleConns(f func(ci *ConnInfo)) {
+	c.connsLock.Lock()
+	cis := make([]ConnInfo, len(c.conns))
+	for i, cc := range c.conns {
+		cis[i] = ConnInfo{
+			LocalAddr:   cc.c.LocalAddr(),
+			RemoteAddr:  cc.c.RemoteAddr(),
+			CreatedTime: cc.createdTime,
+			LastUseTime: cc.lastUseTime,
+		}
+	}
+	c.connsLock.Unlock()
+
+	for i := range cis {
+		f(&cis[i])
+	}
 }
 
 // Get appends url contents to dst and returns it as body.
@@ -615,7 +1571,7 @@ func (c *HostClient) LastUseTime() time.Time {
 //
 // New body buffer is allocated if dst is nil.
 func (c *HostClient) Get(dst []byte, url string) (statusCode int, body []byte, err error) {
-	return clientGetURL(dst, url, c)
+	return clientGetURL(dst, url, c, c.RedirectPolicy)
 }
 
 // GetTimeout appends url contents to dst and returns it as body.
@@ -627,7 +1583,7 @@ func (c *HostClient) Get(dst []byte, url string) (statusCode int, body []byte, e
 // ErrTimeout error is returned if url contents couldn't be fetched
 // during the given timeout.
 func (c *HostClient) GetTimeout(dst []byte, url string, timeout time.Duration) (statusCode int, body []byte, err error) {
-	return clientGetURLTimeout(dst, url, timeout, c)
+	return clientGetURLTimeout(dst, url, timeout, c, c.RedirectPolicy)
 }
 
 // GetDeadline appends url contents to dst and returns it as body.
@@ -639,7 +1595,7 @@ func (c *HostClient) GetTimeout(dst []byte, url string, timeout time.Duration) (
 // ErrTimeout error is returned if url contents couldn't be fetched
 // until the given deadline.
 func (c *HostClient) GetDeadline(dst []byte, url string, deadline time.Time) (statusCode int, body []byte, err error) {
-	return clientGetURLDeadline(dst, url, deadline, c)
+	return clientGetURLDeadline(dst, url, deadline, c, c.RedirectPolicy)
 }
 
 // Post sends POST request to the given url with the given POST arguments.
@@ -652,25 +1608,25 @@ func (c *HostClient) GetDeadline(dst []byte, url string, deadline time.Time) (st
 //
 // Empty POST body is sent if postArgs is nil.
 func (c *HostClient) Post(dst []byte, url string, postArgs *Args) (statusCode int, body []byte, err error) {
-	return clientPostURL(dst, url, postArgs, c)
+	return clientPostURL(dst, url, postArgs, c, c.RedirectPolicy)
 }
 
 type clientDoer interface {
 	Do(req *Request, resp *Response) error
 }
 
-func clientGetURL(dst []byte, url string, c clientDoer) (statusCode int, body []byte, err error) {
+func clientGetURL(dst []byte, url string, c clientDoer, policy *RedirectPolicy) (statusCode int, body []byte, err error) {
 	req := AcquireRequest()
 
-	statusCode, body, err = doRequestFollowRedirects(req, dst, url, c)
+	statusCode, body, err = doRequestFollowRedirects(req, dst, url, c, policy)
 
 	ReleaseRequest(req)
 	return statusCode, body, err
 }
 
-func clientGetURLTimeout(dst []byte, url string, timeout time.Duration, c clientDoer) (statusCode int, body []byte, err error) {
+func clientGetURLTimeout(dst []byte, url string, timeout time.Duration, c clientDoer, policy *RedirectPolicy) (statusCode int, body []byte, err error) {
 	deadline := time.Now().Add(timeout)
-	return clientGetURLDeadline(dst, url, deadline, c)
+	return clientGetURLDeadline(dst, url, deadline, c, policy)
 }
 
 type clientURLResponse struct {
@@ -679,10 +1635,10 @@ type clientURLResponse struct {
 	err        error
 }
 
-func clientGetURLDeadline(dst []byte, url string, deadline time.Time, c clientDoer) (statusCode int, body []byte, err error) {
+func clientGetURLDeadline(dst []byte, url string, deadline time.Time, c clientDoer, policy *RedirectPolicy) (statusCode int, body []byte, err error) {
 	timeout := -time.Since(deadline)
 	if timeout <= 0 {
-		return 0, dst, ErrTimeout
+		return 0, dst, newErrClient(ErrTimeout, urlHost(url), clientDoerAddr(c))
 	}
 
 	var ch chan clientURLResponse
@@ -702,7 +1658,7 @@ func clientGetURLDeadline(dst []byte, url string, deadline time.Time, c clientDo
 	// concurrent requests, since timed out requests on client side
 	// usually continue execution on the host.
 	go func() {
-		statusCodeCopy, bodyCopy, errCopy := doRequestFollowRedirects(req, dst, url, c)
+		statusCodeCopy, bodyCopy, errCopy := doRequestFollowRedirects(req, dst, url, c, policy)
 		ch <- clientURLResponse{
 			statusCode: statusCodeCopy,
 			body:       bodyCopy,
@@ -720,7 +1676,7 @@ func clientGetURLDeadline(dst []byte, url string, deadline time.Time, c clientDo
 		err = resp.err
 	case <-tc.C:
 		body = dst
-		err = ErrTimeout
+		err = newErrClient(ErrTimeout, urlHost(url), clientDoerAddr(c))
 	}
 	releaseTimer(tc)
 
@@ -729,7 +1685,15 @@ func clientGetURLDeadline(dst []byte, url string, deadline time.Time, c clientDo
 
 var clientURLResponseChPool sync.Pool
 
-func clientPostURL(dst []byte, url string, postArgs *Args, c clientDoer) (statusCode int, body []byte, err error) {
+func urlHost(url string) []byte {
+	u := AcquireURI()
+	u.Parse(nil, s2b(url))
+	host := append([]byte(nil), u.Host()...)
+	ReleaseURI(u)
+	return host
+}
+
+func clientPostURL(dst []byte, url string, postArgs *Args, c clientDoer, policy *RedirectPolicy) (statusCode int, body []byte, err error) {
 	req := AcquireRequest()
 	req.Header.SetMethodBytes(strPost)
 	req.Header.SetContentTypeBytes(strPostArgsContentType)
@@ -737,65 +1701,179 @@ func clientPostURL(dst []byte, url string, postArgs *Args, c clientDoer) (status
 		postArgs.WriteTo(req.BodyWriter())
 	}
 
-	statusCode, body, err = doRequestFollowRedirects(req, dst, url, c)
+	statusCode, body, err = doRequestFollowRedirects(req, dst, url, c, policy)
 
 	ReleaseRequest(req)
 	return statusCode, body, err
 }
 
-var (
-	errMissingLocation  = errors.New("missing Location header for http redirect")
-	errTooManyRedirects = errors.New("too many redirects detected when doing the request")
-)
+var errMissingLocation = errors.New("missing Location header for http redirect")
+
+// ErrTooManyRedirects is returned by clients if the number of redirects
+// exceeds the maximum number of redirects to follow.
+var ErrTooManyRedirects = errors.New("too many redirects detected when doing the request")
+
+// DefaultMaxRedirectsCount is the maximum number of redirects followed
+// by Get* and Post calls if RedirectPolicy.MaxRedirectsCount isn't set.
+const DefaultMaxRedirectsCount = 16
+
+// RedirectPolicy controls how Get* and Post calls follow http redirects.
+//
+// 301, 302 and 303 redirects are followed with GET method and without
+// request body, while 307 and 308 redirects are followed with the original
+// request method and body.
+type RedirectPolicy struct {
+	// Maximum number of redirects to follow.
+	//
+	// DefaultMaxRedirectsCount is used if not set.
+	// Redirects aren't followed if MaxRedirectsCount is negative.
+	MaxRedirectsCount int
+
+	// Authorization and Cookie request headers are sent to the redirect
+	// location on another host if this option is set.
+	//
+	// By default these headers are removed from the request when
+	// redirecting to another host.
+	KeepSensitiveHeaders bool
+
+	// CheckRedirect is called before following each redirect.
+	//
+	// req contains the request to the redirect location, while resp
+	// contains the redirect response. The redirect isn't followed
+	// and resp is returned to the caller if CheckRedirect returns false.
+	//
+	// By default all the redirects are followed.
+	CheckRedirect func(req *Request, resp *Response) bool
+}
+
+func (p *RedirectPolicy) maxRedirectsCount() int {
+	if p == nil || p.MaxRedirectsCount == 0 {
+		return DefaultMaxRedirectsCount
+	}
+	if p.MaxRedirectsCount < 0 {
+		return 0
+	}
+	return p.MaxRedirectsCount
+}
 
-const maxRedirectsCount = 16
+// StatusCodeIsRedirect returns true if the status code is one of
+// the http redirect codes followed by the client.
+func StatusCodeIsRedirect(statusCode int) bool {
+	switch statusCode {
+	case StatusMovedPermanently, StatusFound, StatusSeeOther, StatusTemporaryRedirect, StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
 
-func doRequestFollowRedirects(req *Request, dst []byte, url string, c clientDoer) (statusCode int, body []byte, err error) {
+func doRequestFollowRedirects(req *Request, dst []byte, url string, c clientDoer, policy *RedirectPolicy) (statusCode int, body []byte, err error) {
 	resp := AcquireResponse()
 	bodyBuf := resp.bodyBuffer()
 	resp.keepBodyBuffer = true
 	oldBody := bodyBuf.B
 	bodyBuf.B = dst
 
+	setRequestURL(req, url)
+	statusCode, err = followRedirects(req, resp, url, policy.maxRedirectsCount(), c, policy)
+
+	body = bodyBuf.B
+	bodyBuf.B = oldBody
+	resp.keepBodyBuffer = false
+	ReleaseResponse(resp)
+
+	return statusCode, body, err
+}
+
+func clientDoRedirects(req *Request, resp *Response, maxRedirectsCount int, c clientDoer, policy *RedirectPolicy) error {
+	if resp == nil {
+		resp = AcquireResponse()
+		defer ReleaseResponse(resp)
+	}
+	if maxRedirectsCount < 0 {
+		maxRedirectsCount = 0
+	}
+	_, err := followRedirects(req, resp, req.URI().String(), maxRedirectsCount, c, policy)
+	return err
+}
+
+// followRedirects performs req to the given url, following up
+// to maxRedirectsCount redirects.
+//
+// The status code of the last received response is returned.
+func followRedirects(req *Request, resp *Response, url string, maxRedirectsCount int, c clientDoer, policy *RedirectPolicy) (statusCode int, err error) {
 	redirectsCount := 0
 	for {
-		req.parsedURI = false
-		req.Header.host = req.Header.host[:0]
-		req.SetRequestURI(url)
-
 		if err = c.Do(req, resp); err != nil {
-			break
+			return statusCode, err
 		}
 		statusCode = resp.Header.StatusCode()
-		if statusCode != StatusMovedPermanently && statusCode != StatusFound && statusCode != StatusSeeOther {
-			break
+		if !StatusCodeIsRedirect(statusCode) || maxRedirectsCount == 0 {
+			return statusCode, nil
 		}
 
 		redirectsCount++
 		if redirectsCount > maxRedirectsCount {
-			err = errTooManyRedirects
-			break
+			return statusCode, ErrTooManyRedirects
 		}
 		location := resp.Header.peek(strLocation)
 		if len(location) == 0 {
-			err = errMissingLocation
-			break
+			return statusCode, errMissingLocation
 		}
 		url = getRedirectURL(url, location)
+		if !prepareRedirectRequest(req, url, statusCode, policy) {
+			return statusCode, nil
+		}
+		if policy != nil && policy.CheckRedirect != nil && !policy.CheckRedirect(req, resp) {
+			return statusCode, nil
+		}
 	}
+}
 
-	body = bodyBuf.B
-	bodyBuf.B = oldBody
-	resp.keepBodyBuffer = false
-	ReleaseResponse(resp)
+func setRequestURL(req *Request, url string) {
+	req.parsedURI = false
+	req.Header.host = req.Header.host[:0]
+	req.SetRequestURI(url)
+}
 
-	return statusCode, body, err
+// prepareRedirectRequest updates req for following the redirect
+// with the given statusCode to redirectURL.
+//
+// false is returned if the redirect cannot be followed.
+func prepareRedirectRequest(req *Request, redirectURL string, statusCode int, policy *RedirectPolicy) bool {
+	if statusCode == StatusTemporaryRedirect || statusCode == StatusPermanentRedirect {
+		if req.bodyStream != nil {
+			// Body stream cannot be sent twice.
+			return false
+		}
+	} else if !req.Header.IsGet() && !req.Header.IsHead() {
+		req.Header.SetMethodBytes(strGet)
+		req.ResetBody()
+		req.Header.Del("Content-Type")
+		req.Header.Del("Content-Length")
+	}
+
+	prevHost := string(req.URI().Host())
+	setRequestURL(req, redirectURL)
+	if (policy == nil || !policy.KeepSensitiveHeaders) && string(req.URI().Host()) != prevHost {
+		req.Header.Del("Authorization")
+		req.Header.Del("Cookie")
+	}
+	return true
 }
 
 func getRedirectURL(baseURL string, location []byte) string {
 	u := AcquireURI()
 	u.Update(baseURL)
+	var fragment []byte
+	if bytes.IndexByte(location, '#') < 0 {
+		// The redirect inherits the original fragment if the location
+		// has no fragment. See RFC 7231, section 7.1.2.
+		fragment = append(fragment, u.Fragment()...)
+	}
 	u.UpdateBytes(location)
+	if len(fragment) > 0 {
+		u.SetFragmentBytes(fragment)
+	}
 	redirectURL := u.String()
 	ReleaseURI(u)
 	return redirectURL
@@ -856,7 +1934,8 @@ func ReleaseResponse(resp *Response) {
 // Request must contain at least non-zero RequestURI with full url (including
 // scheme and host) or non-zero Host header + RequestURI.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get*
+// for following redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -878,7 +1957,8 @@ func (c *HostClient) DoTimeout(req *Request, resp *Response, timeout time.Durati
 // Request must contain at least non-zero RequestURI with full url (including
 // scheme and host) or non-zero Host header + RequestURI.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get*
+// for following redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -894,70 +1974,100 @@ func (c *HostClient) DoDeadline(req *Request, resp *Response, deadline time.Time
 	return clientDoDeadline(req, resp, deadline, c)
 }
 
-func clientDoTimeout(req *Request, resp *Response, timeout time.Duration, c clientDoer) error {
+// DoContext performs the given request and waits for response until
+// the given context is done.
+//
+// Pending I/O on the request connection is interrupted when ctx
+// is cancelled, and ctx.Err() is returned. req and resp aren't copied,
+// so the caller must own them until the function returns.
+//
+// See DoContext for details.
+func (c *HostClient) DoContext(ctx context.Context, req *Request, resp *Response) error {
+	return clientDoContext(ctx, req, resp, c)
+}
+
+// DoRedirects performs the given http request and fills the given http
+// response, following up to maxRedirectsCount redirects.
+//
+// Redirects are followed according to HostClient.RedirectPolicy, while
+// its MaxRedirectsCount is ignored. Redirects aren't followed
+// if maxRedirectsCount isn't positive. ErrTooManyRedirects is returned
+// if the number of redirects exceeds maxRedirectsCount.
+//
+// Note that HostClient sends all the requests to Addr, including
+// requests to redirect locations on other hosts.
+//
+// req is updated while following redirects, i.e. it contains the last
+// request sent to the redirect location when the function returns.
+//
+// Response is ignored if resp is nil.
+//
+// It is recommended obtaining req and resp via AcquireRequest
+// and AcquireResponse in performance-critical code.
+func (c *HostClient) DoRedirects(req *Request, resp *Response, maxRedirectsCount int) error {
+	return clientDoRedirects(req, resp, maxRedirectsCount, c, c.RedirectPolicy)
+}
+
+func clientDoTimeout(req *Request, resp *Response, timeout time.Duration, c deadlineDoer) error {
 	deadline := time.Now().Add(timeout)
 	return clientDoDeadline(req, resp, deadline, c)
 }
 
-func clientDoDeadline(req *Request, resp *Response, deadline time.Time, c clientDoer) error {
-	timeout := -time.Since(deadline)
-	if timeout <= 0 {
-		return ErrTimeout
+func clientDoDeadline(req *Request, resp *Response, deadline time.Time, c deadlineDoer) error {
+	if !time.Now().Before(deadline) {
+		return wrapClientError(ErrTimeout, req, clientDoerAddr(c))
 	}
+	// The deadline is enforced via connection deadlines, so the request
+	// is executed synchronously without copying req and resp.
+	return c.doDeadline(req, resp, deadline)
+}
 
-	var ch chan error
-	chv := errorChPool.Get()
-	if chv == nil {
-		chv = make(chan error, 1)
+func clientDoContext(ctx context.Context, req *Request, resp *Response, c deadlineDoer) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	ch = chv.(chan error)
-
-	// Make req and resp copies, since on timeout they no longer
-	// may be accessed.
-	reqCopy := AcquireRequest()
-	req.copyToSkipBody(reqCopy)
-	swapRequestBody(req, reqCopy)
-	respCopy := AcquireResponse()
-
-	// Note that the request continues execution on ErrTimeout until
-	// client-specific ReadTimeout exceeds. This helps limiting load
-	// on slow hosts by MaxConns* concurrent requests.
-	//
-	// Without this 'hack' the load on slow host could exceed MaxConns*
-	// concurrent requests, since timed out requests on client side
-	// usually continue execution on the host.
-	go func() {
-		ch <- c.Do(reqCopy, respCopy)
-	}()
-
-	tc := acquireTimer(timeout)
-	var err error
-	select {
-	case err = <-ch:
-		if resp != nil {
-			respCopy.copyToSkipBody(resp)
-			swapResponseBody(resp, respCopy)
+	deadline, _ := ctx.Deadline()
+	if ctx.Done() != nil {
+		// The context may be cancelled, so the connection
+		// must be interrupted on cancellation.
+		req.ctx = ctx
+	}
+	err := c.doDeadline(req, resp, deadline)
+	req.ctx = nil
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if !deadline.IsZero() && errors.Is(err, ErrTimeout) && !time.Now().Before(deadline) {
+			// The connection deadline may expire before the context.
+			return context.DeadlineExceeded
 		}
-		swapRequestBody(reqCopy, req)
-		ReleaseResponse(respCopy)
-		ReleaseRequest(reqCopy)
-		errorChPool.Put(chv)
-	case <-tc.C:
-		err = ErrTimeout
 	}
-	releaseTimer(tc)
-
 	return err
 }
 
-var errorChPool sync.Pool
+// deadlineDoer is implemented by Client and HostClient, which enforce
+// request deadlines via connection deadlines.
+type deadlineDoer interface {
+	clientDoer
+	doDeadline(req *Request, resp *Response, deadline time.Time) error
+}
+
+// clientDoerAddr returns HostClient.Addr if c is HostClient.
+func clientDoerAddr(c clientDoer) string {
+	if hc, ok := c.(*HostClient); ok {
+		return hc.Addr
+	}
+	return ""
+}
 
 // Do performs the given http request and sets the corresponding response.
 //
 // Request must contain at least non-zero RequestURI with full url (including
 // scheme and host) or non-zero Host header + RequestURI.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get*
+// for following redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -967,104 +2077,523 @@ var errorChPool sync.Pool
 // It is recommended obtaining req and resp via AcquireRequest
 // and AcquireResponse in performance-critical code.
 func (c *HostClient) Do(req *Request, resp *Response) error {
+	return c.doDeadline(req, resp, zeroTime)
+}
+
+// doDeadline performs the given request. The request is limited
+// by the given deadline via connection deadlines if it isn't zero.
+func (c *HostClient) doDeadline(req *Request, resp *Response, deadline time.Time) error {
+	var span RequestSpan
+	if c.Instrumenter != nil {
+		startTime := time.Now()
+		if span = c.Instrumenter.StartClientRequest(req); span != nil {
+			req.spanTiming = acquireSpanTiming()
+			req.spanTiming.Start = startTime
+		}
+	}
+
+	var err error
+	for _, hook := range c.BeforeRequest {
+		if err = hook(req); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = c.doWithRetries(req, resp, deadline)
+		err = wrapClientError(err, req, c.Addr)
+	}
+	for _, hook := range c.AfterResponse {
+		err = hook(req, resp, err)
+	}
+
+	if span != nil {
+		timing := req.spanTiming
+		req.spanTiming = nil
+		timing.End = time.Now()
+		span.End(resp, timing, err)
+		releaseSpanTiming(timing)
+	}
+	return err
+}
+
+func (c *HostClient) doWithRetries(req *Request, resp *Response, deadline time.Time) error {
 	var err error
 	var retry bool
-	const maxAttempts = 5
+	policy := c.RetryPolicy
+	maxAttempts := policy.maxAttempts()
 	attempts := 0
 
+	if resp == nil && policy != nil && len(policy.RetryableStatusCodes) > 0 {
+		// The response is required for checking its' status code.
+		resp = AcquireResponse()
+		defer ReleaseResponse(resp)
+	}
+
+	mc := c.MetricsCollector
+	var startTime time.Time
+	if mc != nil {
+		startTime = time.Now()
+		mc.RequestStarted(c.Addr)
+	}
+
 	atomic.AddUint64(&c.pendingRequests, 1)
 	for {
-		retry, err = c.do(req, resp)
-		if err == nil || !retry {
+		attemptDeadline := policy.attemptDeadline()
+		if !deadline.IsZero() && (attemptDeadline.IsZero() || deadline.Before(attemptDeadline)) {
+			attemptDeadline = deadline
+		}
+		if c.CredentialsProvider != nil {
+			if err = c.setCredentials(req); err != nil {
+				break
+			}
+		}
+		if timing := req.spanTiming; timing != nil {
+			timing.Attempts++
+			timing.GotConn = zeroTime
+			timing.WroteRequest = zeroTime
+			timing.GotFirstResponseByte = zeroTime
+		}
+		retry, err = c.do(req, resp, attemptDeadline)
+		if err != nil && !attemptDeadline.IsZero() && !time.Now().Before(attemptDeadline) {
+			err = ErrTimeout
+		}
+		if err != nil && !deadline.IsZero() && !time.Now().Before(deadline) {
+			// There is no time left for retrying the request.
 			break
 		}
-
-		if !isIdempotent(req) {
-			// Retry non-idempotent requests if the server closes
-			// the connection before sending the response.
-			//
-			// This case is possible if the server closes the idle
-			// keep-alive connection on timeout.
-			//
-			// Apache and nginx usually do this.
-			if err != io.EOF {
+		if err != nil && req.ctx != nil && req.ctx.Err() != nil {
+			// The request has been cancelled.
+			break
+		}
+		if err == nil {
+			if resp == nil || !policy.isRetryableStatusCode(resp.Header.StatusCode()) || !c.shouldRetry(req, resp, nil) {
+				break
+			}
+			if resp.bodySinkUsed {
+				// The request cannot be retried, since the response body
+				// has been already written to the body sink.
 				break
 			}
+		} else if !retry || req.conn != nil || !c.shouldRetry(req, resp, err) {
+			// Requests with pre-established connection cannot be retried,
+			// since the connection cannot be re-established.
+			break
 		}
 		attempts++
 		if attempts >= maxAttempts {
 			break
 		}
+		if d := policy.backoff(attempts); d > 0 {
+			if !deadline.IsZero() && time.Now().Add(d).After(deadline) {
+				// There is no time left for the next attempt.
+				// Return the last response or error as is.
+				break
+			}
+			if !sleepBackoff(req, d) {
+				// The request has been cancelled while waiting
+				// for the next attempt.
+				break
+			}
+		}
+		if mc != nil {
+			mc.RequestRetried(c.Addr, attempts+1, err)
+		}
 	}
 	atomic.AddUint64(&c.pendingRequests, ^uint64(0))
 
 	if err == io.EOF {
 		err = ErrConnectionClosed
 	}
+	if mc != nil {
+		statusCode := 0
+		if err == nil && resp != nil {
+			statusCode = resp.Header.StatusCode()
+		}
+		mc.RequestFinished(c.Addr, statusCode, time.Since(startTime), err)
+	}
 	return err
 }
 
-// PendingRequests returns the current number of requests the client
-// is executing.
-//
-// This function may be used for balancing load among multiple HostClient
-// instances.
-func (c *HostClient) PendingRequests() int {
-	return int(atomic.LoadUint64(&c.pendingRequests))
+func (c *HostClient) signRequest(req *Request) error {
+	if err := req.finalizeHeader(); err != nil {
+		return err
+	}
+	return c.SignRequest(req)
 }
 
-func isIdempotent(req *Request) bool {
-	return req.Header.IsGet() || req.Header.IsHead() || req.Header.IsPut()
-}
+// CredentialsProvider returns Authorization header value for the given
+// request.
+//
+// The request is sent with unmodified Authorization header if the returned
+// value is empty. The request fails with the returned error if it isn't nil.
+type CredentialsProvider func(req *Request) (authorization string, err error)
 
-func (c *HostClient) do(req *Request, resp *Response) (bool, error) {
-	nilResp := false
-	if resp == nil {
-		nilResp = true
-		resp = AcquireResponse()
+func (c *HostClient) setCredentials(req *Request) error {
+	authorization, err := c.CredentialsProvider(req)
+	if err != nil {
+		return err
 	}
-
-	ok, err := c.doNonNilReqResp(req, resp)
-
-	if nilResp {
-		ReleaseResponse(resp)
+	if len(authorization) > 0 {
+		req.Header.SetCanonical(strAuthorization, s2b(authorization))
 	}
-
-	return ok, err
+	return nil
 }
 
-func (c *HostClient) doNonNilReqResp(req *Request, resp *Response) (bool, error) {
-	if req == nil {
-		panic("BUG: req cannot be nil")
-	}
-	if resp == nil {
-		panic("BUG: resp cannot be nil")
-	}
+// DefaultMaxAttempts is the maximum number of attempts HostClient.Do
+// makes for a single request if RetryPolicy.MaxAttempts isn't set.
+const DefaultMaxAttempts = 5
 
-	atomic.StoreUint32(&c.lastUseTime, uint32(CoarseTimeNow().Unix()-startTimeUnix))
+// RetryIfFunc decides whether the request must be retried.
+//
+// See HostClient.RetryIf for details.
+type RetryIfFunc func(req *Request, resp *Response, err error) bool
+
+// BeforeRequestHook is called before performing the request.
+//
+// See HostClient.BeforeRequest for details.
+type BeforeRequestHook func(req *Request) error
+
+// AfterResponseHook is called after performing the request.
+//
+// resp may be nil if nil response is passed to Do.
+//
+// See HostClient.AfterResponse for details.
+type AfterResponseHook func(req *Request, resp *Response, err error) error
+
+// RetryPolicy controls retrying failed requests in HostClient.Do.
+//
+// Only idempotent requests (GET, HEAD and PUT) are retried by default.
+// Non-idempotent requests are retried only if the server closes
+// the connection before sending the response. Use HostClient.RetryIf
+// for overriding this behavior.
+type RetryPolicy struct {
+	// Maximum number of attempts per request, including the first attempt.
+	//
+	// DefaultMaxAttempts is used if not set.
+	MaxAttempts int
+
+	// Delay before the first retry.
+	//
+	// The delay is doubled on each subsequent retry until it reaches
+	// MaxBackoff. Random jitter in the range [0..delay/2) is subtracted
+	// from each delay, so concurrent clients don't retry simultaneously.
+	//
+	// By default failed requests are retried immediately.
+	InitialBackoff time.Duration
+
+	// Maximum delay between retries.
+	//
+	// By default the delay isn't limited.
+	MaxBackoff time.Duration
+
+	// Responses with these status codes are retried in the same way
+	// as failed requests. The last response is returned if all
+	// the attempts fail.
+	//
+	// By default requests are retried only on errors.
+	RetryableStatusCodes []int
+
+	// Maximum duration for a single attempt.
+	//
+	// Idempotent requests are retried if the attempt doesn't complete
+	// in the given time. ErrTimeout is returned if all the attempts time out.
+	//
+	// By default each attempt is limited only by ReadTimeout
+	// and WriteTimeout.
+	AttemptTimeout time.Duration
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p == nil || p.MaxAttempts <= 0 {
+		return DefaultMaxAttempts
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) attemptDeadline() time.Time {
+	if p == nil || p.AttemptTimeout <= 0 {
+		return zeroTime
+	}
+	return time.Now().Add(p.AttemptTimeout)
+}
+
+func (p *RetryPolicy) isRetryableStatusCode(statusCode int) bool {
+	if p == nil {
+		return false
+	}
+	for _, n := range p.RetryableStatusCodes {
+		if n == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// sleepBackoff waits for d before the next attempt of req.
+//
+// false is returned if req is cancelled via its' context in the meantime.
+func sleepBackoff(req *Request, d time.Duration) bool {
+	var done <-chan struct{}
+	if req.ctx != nil {
+		done = req.ctx.Done()
+	}
+	t := acquireTimer(d)
+	defer releaseTimer(t)
+	select {
+	case <-t.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// backoff returns the delay before the given retry attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	if p == nil || p.InitialBackoff <= 0 {
+		return 0
+	}
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d <= math.MaxInt64/2; i++ {
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+		d <<= 1
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if jitter := int64(d / 2); jitter > 0 {
+		d -= time.Duration(rand.Int63n(jitter))
+	}
+	return d
+}
+
+// PendingRequests returns the current number of requests the client
+// is executing.
+//
+// This function may be used for balancing load among multiple HostClient
+// instances.
+func (c *HostClient) PendingRequests() int {
+	return int(atomic.LoadUint64(&c.pendingRequests))
+}
+
+// Warmup establishes up to n connections to the host in advance,
+// so the subsequent requests don't wait for dialing and TLS handshakes.
+//
+// Connections are established concurrently. The number of connections
+// is limited by MaxConns. Established connections are closed
+// after MaxIdleConnDuration if they aren't used.
+//
+// The first error occurred when establishing connections is returned.
+// Nothing is done if n <= 0.
+func (c *HostClient) Warmup(n int) error {
+	if n <= 0 {
+		return nil
+	}
+	c.updateLastUseTime()
+
+	startCleaner := false
+	startHealthChecker := false
+
+	c.connsLock.Lock()
+	maxConns := c.MaxConns
+	if maxConns <= 0 {
+		maxConns = DefaultMaxConnsPerHost
+	}
+	if n > maxConns-c.connsCount {
+		n = maxConns - c.connsCount
+	}
+	if n > 0 {
+		c.connsCount += n
+		if !c.connsCleanerRun {
+			startCleaner = true
+			c.connsCleanerRun = true
+		}
+		if c.HealthCheckInterval > 0 && !c.healthCheckerRun {
+			startHealthChecker = true
+			c.healthCheckerRun = true
+		}
+	}
+	c.connsLock.Unlock()
+
+	if startCleaner {
+		go c.connsCleaner()
+	}
+	if startHealthChecker {
+		go c.healthChecker()
+	}
+	if n <= 0 {
+		// MaxConns connections are already established.
+		return nil
+	}
+
+	errCh := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			conn, addrIdx, err := c.dialHostHard(zeroTime, nil)
+			if err != nil {
+				c.decConnsCount()
+				errCh <- err
+				return
+			}
+			cc := acquireClientConn(conn)
+			cc.addrIdx = addrIdx
+			cc.inFlight = c.AddrSelection == AddrLeastConns
+			c.releaseConn(cc)
+			errCh <- nil
+		}()
+	}
+
+	var firstErr error
+	for i := 0; i < n; i++ {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (c *HostClient) shouldRetry(req *Request, resp *Response, err error) bool {
+	if c.RetryIf != nil {
+		return c.RetryIf(req, resp, err)
+	}
+	if isIdempotent(req) {
+		return true
+	}
+
+	// Retry non-idempotent requests if the server closes
+	// the connection before sending the response.
+	//
+	// This case is possible if the server closes the idle
+	// keep-alive connection on timeout.
+	//
+	// Apache and nginx usually do this.
+	return err == io.EOF
+}
+
+func isIdempotent(req *Request) bool {
+	return req.Header.IsGet() || req.Header.IsHead() || req.Header.IsPut()
+}
+
+// Transport performs http requests on behalf of HostClient.
+//
+// Custom Transport may be used for plugging alternative wire protocols
+// or for recording requests and responses in tests. Wrap DefaultTransport
+// for customizing the default behavior.
+type Transport interface {
+	// RoundTrip performs the given request to the host served by hc
+	// and fills the given response.
+	//
+	// The request must be completed until the given deadline
+	// if the deadline isn't zero.
+	//
+	// retry must be true if the request may be retried on another
+	// connection after the error, i.e. the error is connection-specific.
+	RoundTrip(hc *HostClient, req *Request, resp *Response, deadline time.Time) (retry bool, err error)
+}
+
+// DefaultTransport is the Transport used by HostClient if Transport
+// isn't set.
+//
+// It performs HTTP/1.1 requests over connections from HostClient
+// connection pool. HTTP/2 is used if HostClient.EnableHTTP2 is set
+// and the host supports it.
+var DefaultTransport Transport = &defaultTransport{}
+
+type defaultTransport struct{}
+
+func (t *defaultTransport) RoundTrip(hc *HostClient, req *Request, resp *Response, deadline time.Time) (bool, error) {
+	return hc.doNonNilReqResp(req, resp, deadline)
+}
+
+func (c *HostClient) do(req *Request, resp *Response, deadline time.Time) (bool, error) {
+	if req == nil {
+		panic("BUG: req cannot be nil")
+	}
+
+	nilResp := false
+	if resp == nil {
+		nilResp = true
+		resp = AcquireResponse()
+	}
+
+	c.updateLastUseTime()
+
+	transport := c.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+	ok, err := transport.RoundTrip(c, req, resp, deadline)
+
+	if nilResp {
+		ReleaseResponse(resp)
+	}
+
+	return ok, err
+}
+
+func (c *HostClient) doNonNilReqResp(req *Request, resp *Response, deadline time.Time) (bool, error) {
+	if resp == nil {
+		panic("BUG: resp cannot be nil")
+	}
+
+	streamBody := c.StreamResponseBody || resp.StreamBody
 
 	// Free up resources occupied by response before sending the request,
 	// so the GC may reclaim these resources (e.g. response body).
+	bodySink := resp.bodySink
 	resp.Reset()
+	resp.bodySink = bodySink
+
+	if c.DisablePathNormalizing {
+		req.URI().DisablePathNormalizing = true
+	}
+
+	// Requests with custom connections are always sent over HTTP/1.1.
+	useHTTP2 := c.EnableHTTP2 && c.IsTLS && req.dial == nil && req.conn == nil
+	if useHTTP2 {
+		if h2c := c.acquireHTTP2Conn(); h2c != nil {
+			resp.StreamBody = streamBody
+			return c.doHTTP2(h2c, req, resp, deadline)
+		}
+	}
 
-	cc, err := c.acquireConn()
+	trace := req.trace
+	var cc *clientConn
+	var err error
+	if req.dial != nil || req.conn != nil {
+		cc, err = c.acquireRequestConn(req, deadline, trace)
+	} else {
+		cc, err = c.acquireConn(deadline, trace)
+	}
 	if err != nil {
 		return false, err
 	}
+	if req.ctx != nil {
+		c.watchConnCtx(cc, req.ctx)
+	}
 	conn := cc.c
+	trace.gotConn(conn, !cc.lastUseTime.IsZero())
+	timing := req.spanTiming
+	if timing != nil {
+		timing.GotConn = time.Now()
+	}
 
-	if c.WriteTimeout > 0 {
-		// Optimization: update write deadline only if more than 25%
-		// of the last write deadline exceeded.
-		// See https://github.com/golang/go/issues/15133 for details.
-		currentTime := CoarseTimeNow()
-		if currentTime.Sub(cc.lastWriteDeadlineTime) > (c.WriteTimeout >> 2) {
-			if err = conn.SetWriteDeadline(currentTime.Add(c.WriteTimeout)); err != nil {
-				c.closeConn(cc)
-				return true, err
-			}
-			cc.lastWriteDeadlineTime = currentTime
+	if useHTTP2 {
+		h2c, err := c.tryHTTP2(cc)
+		if err != nil {
+			c.closeConn(cc)
+			return true, err
 		}
+		if h2c != nil {
+			resp.StreamBody = streamBody
+			return c.doHTTP2(h2c, req, resp, deadline)
+		}
+	}
+
+	if err = c.updateWriteDeadline(cc, deadline); err != nil {
+		c.closeConn(cc)
+		return true, err
 	}
 
 	resetConnection := false
@@ -1077,6 +2606,18 @@ func (c *HostClient) doNonNilReqResp(req *Request, resp *Response) (bool, error)
 	if len(userAgentOld) == 0 {
 		req.Header.userAgent = c.getClientName()
 	}
+	if c.SignRequest != nil {
+		if err = c.signRequest(req); err != nil {
+			if len(userAgentOld) == 0 {
+				req.Header.userAgent = userAgentOld
+			}
+			if resetConnection {
+				req.Header.ResetConnectionClose()
+			}
+			c.releaseConn(cc)
+			return false, err
+		}
+	}
 	bw := c.acquireWriter(conn)
 	err = req.Write(bw)
 	if len(userAgentOld) == 0 {
@@ -1090,6 +2631,10 @@ func (c *HostClient) doNonNilReqResp(req *Request, resp *Response) (bool, error)
 	if err == nil {
 		err = bw.Flush()
 	}
+	trace.wroteRequest(err)
+	if timing != nil && err == nil {
+		timing.WroteRequest = time.Now()
+	}
 	if err != nil {
 		c.releaseWriter(bw)
 		c.closeConn(cc)
@@ -1097,18 +2642,9 @@ func (c *HostClient) doNonNilReqResp(req *Request, resp *Response) (bool, error)
 	}
 	c.releaseWriter(bw)
 
-	if c.ReadTimeout > 0 {
-		// Optimization: update read deadline only if more than 25%
-		// of the last read deadline exceeded.
-		// See https://github.com/golang/go/issues/15133 for details.
-		currentTime := CoarseTimeNow()
-		if currentTime.Sub(cc.lastReadDeadlineTime) > (c.ReadTimeout >> 2) {
-			if err = conn.SetReadDeadline(currentTime.Add(c.ReadTimeout)); err != nil {
-				c.closeConn(cc)
-				return true, err
-			}
-			cc.lastReadDeadlineTime = currentTime
-		}
+	if err = c.updateReadDeadline(cc, deadline); err != nil {
+		c.closeConn(cc)
+		return true, err
 	}
 
 	if !req.Header.IsGet() && req.Header.IsHead() {
@@ -1118,15 +2654,44 @@ func (c *HostClient) doNonNilReqResp(req *Request, resp *Response) (bool, error)
 		resp.Header.DisableNormalizing()
 	}
 
+	resp.StreamBody = streamBody
+
 	br := c.acquireReader(conn)
-	if err = resp.ReadLimitBody(br, c.MaxResponseBodySize); err != nil {
+	if trace.hasGotFirstResponseByte() || timing != nil {
+		// Read errors are handled by ReadLimitBody below.
+		if _, err := br.Peek(1); err == nil {
+			if timing != nil {
+				timing.GotFirstResponseByte = time.Now()
+			}
+			if trace.hasGotFirstResponseByte() {
+				trace.GotFirstResponseByte()
+			}
+		}
+	}
+	if err = resp.readLimitBody(br, c.MaxResponseBodySize, true, c.KeepPartialResponseBody); err != nil {
 		c.releaseReader(br)
 		c.closeConn(cc)
-		return true, err
+		// The request cannot be retried if the response body
+		// has been partially written to the body sink.
+		return !resp.bodySinkUsed, err
+	}
+
+	closeConn := resetConnection || req.ConnectionClose() || resp.ConnectionClose()
+	if bs, ok := resp.bodyStream.(*bodyStreamReader); ok {
+		// The connection is busy until the response body is read.
+		bs.onClose = func(complete bool) {
+			c.releaseReader(br)
+			if closeConn || !complete || bs.contentLength == -2 {
+				c.closeConn(cc)
+			} else {
+				c.releaseConn(cc)
+			}
+		}
+		return false, nil
 	}
 	c.releaseReader(br)
 
-	if resetConnection || req.ConnectionClose() || resp.ConnectionClose() {
+	if closeConn {
 		c.closeConn(cc)
 	} else {
 		c.releaseConn(cc)
@@ -1135,16 +2700,110 @@ func (c *HostClient) doNonNilReqResp(req *Request, resp *Response) (bool, error)
 	return false, err
 }
 
+// updateWriteDeadline sets write deadline for cc according to WriteTimeout
+// and the given deadline. Zero deadline is ignored.
+func (c *HostClient) updateWriteDeadline(cc *clientConn, deadline time.Time) error {
+	currentTime := CoarseTimeNow()
+	if !deadline.IsZero() {
+		if c.WriteTimeout > 0 {
+			if d := currentTime.Add(c.WriteTimeout); d.Before(deadline) {
+				deadline = d
+			}
+		}
+		cc.lastWriteDeadlineTime = time.Time{}
+		cc.writeDeadlineOverridden = true
+		return cc.c.SetWriteDeadline(deadline)
+	}
+
+	if c.WriteTimeout > 0 {
+		// Optimization: update write deadline only if more than 25%
+		// of the last write deadline exceeded.
+		// See https://github.com/golang/go/issues/15133 for details.
+		if currentTime.Sub(cc.lastWriteDeadlineTime) > (c.WriteTimeout >> 2) {
+			if err := cc.c.SetWriteDeadline(currentTime.Add(c.WriteTimeout)); err != nil {
+				return err
+			}
+			cc.lastWriteDeadlineTime = currentTime
+			cc.writeDeadlineOverridden = false
+		}
+		return nil
+	}
+
+	if cc.writeDeadlineOverridden {
+		cc.writeDeadlineOverridden = false
+		return cc.c.SetWriteDeadline(zeroTime)
+	}
+	return nil
+}
+
+// updateReadDeadline sets read deadline for cc according to ReadTimeout
+// and the given deadline. Zero deadline is ignored.
+func (c *HostClient) updateReadDeadline(cc *clientConn, deadline time.Time) error {
+	currentTime := CoarseTimeNow()
+	if !deadline.IsZero() {
+		if c.ReadTimeout > 0 {
+			if d := currentTime.Add(c.ReadTimeout); d.Before(deadline) {
+				deadline = d
+			}
+		}
+		cc.lastReadDeadlineTime = time.Time{}
+		cc.readDeadlineOverridden = true
+		return cc.c.SetReadDeadline(deadline)
+	}
+
+	if c.ReadTimeout > 0 {
+		// Optimization: update read deadline only if more than 25%
+		// of the last read deadline exceeded.
+		// See https://github.com/golang/go/issues/15133 for details.
+		if currentTime.Sub(cc.lastReadDeadlineTime) > (c.ReadTimeout >> 2) {
+			if err := cc.c.SetReadDeadline(currentTime.Add(c.ReadTimeout)); err != nil {
+				return err
+			}
+			cc.lastReadDeadlineTime = currentTime
+			cc.readDeadlineOverridden = false
+		}
+		return nil
+	}
+
+	if cc.readDeadlineOverridden {
+		cc.readDeadlineOverridden = false
+		return cc.c.SetReadDeadline(zeroTime)
+	}
+	return nil
+}
+
+// Client errors below implement net.Error. Clients return them wrapped
+// into *ErrClient with the request host, so errors.Is(err, ErrTimeout)
+// must be used for checking them.
+//
+// Note that this breaks the code comparing errors directly such as
+// err == ErrTimeout, since it is false for wrapped errors. The bare error
+// is returned only if the request host is unknown.
 var (
 	// ErrNoFreeConns is returned when no free connections available
 	// to the given host.
 	//
 	// Increase the allowed number of connections per host if you
 	// see this error.
-	ErrNoFreeConns = errors.New("no free connections available to host")
+	ErrNoFreeConns error = &clientError{
+		msg:       "no free connections available to host",
+		temporary: true,
+	}
 
 	// ErrTimeout is returned from timed out calls.
-	ErrTimeout = errors.New("timeout")
+	ErrTimeout error = &clientError{
+		msg:       "timeout",
+		timeout:   true,
+		temporary: true,
+	}
+
+	// ErrTLSHandshakeTimeout is returned if TLS handshake with the host
+	// isn't completed during HostClient.TLSHandshakeTimeout.
+	ErrTLSHandshakeTimeout error = &clientError{
+		msg:       "tls handshake timed out",
+		timeout:   true,
+		temporary: true,
+	}
 
 	// ErrConnectionClosed may be returned from client methods if the server
 	// closes connection before returning the first response byte.
@@ -1153,14 +2812,108 @@ var (
 	// 'Connection: close' response header before closing the connection
 	// or add 'Connection: close' request header before sending requests
 	// to broken server.
-	ErrConnectionClosed = errors.New("the server closed connection before returning the first response byte. " +
-		"Make sure the server returns 'Connection: close' response header before closing the connection")
+	ErrConnectionClosed error = &clientError{
+		msg: "the server closed connection before returning the first response byte. " +
+			"Make sure the server returns 'Connection: close' response header before closing the connection",
+		temporary: true,
+	}
 )
 
-func (c *HostClient) acquireConn() (*clientConn, error) {
+// clientError is the type of client errors such as ErrTimeout.
+type clientError struct {
+	msg       string
+	timeout   bool
+	temporary bool
+}
+
+// Error implements error interface.
+func (e *clientError) Error() string {
+	return e.msg
+}
+
+// Timeout implements net.Error interface.
+func (e *clientError) Timeout() bool {
+	return e.timeout
+}
+
+// Temporary implements net.Error interface.
+func (e *clientError) Temporary() bool {
+	return e.temporary
+}
+
+// ErrClient is returned by clients instead of ErrTimeout, ErrNoFreeConns,
+// ErrTLSHandshakeTimeout and ErrConnectionClosed. It contains the host
+// the failed request has been sent to. Use errors.Is(err, ErrTimeout)
+// instead of err == ErrTimeout for checking the underlying error.
+//
+// ErrClient implements net.Error, so the error class may be checked
+// via Timeout and Temporary methods. The underlying error may be checked
+// via errors.Is.
+type ErrClient struct {
+	// Host is the request host.
+	Host string
+
+	// Addr is the address the request has been sent to,
+	// i.e. HostClient.Addr. It is empty if the address is unknown.
+	Addr string
+
+	// Err is the underlying error such as ErrTimeout.
+	Err error
+}
+
+// Error implements error interface.
+func (e *ErrClient) Error() string {
+	if len(e.Addr) == 0 || e.Addr == e.Host {
+		return fmt.Sprintf("%s (host %q)", e.Err, e.Host)
+	}
+	return fmt.Sprintf("%s (host %q, addr %q)", e.Err, e.Host, e.Addr)
+}
+
+// Unwrap returns the underlying error.
+func (e *ErrClient) Unwrap() error {
+	return e.Err
+}
+
+// Timeout implements net.Error interface.
+func (e *ErrClient) Timeout() bool {
+	ne, ok := e.Err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// Temporary implements net.Error interface.
+func (e *ErrClient) Temporary() bool {
+	ne, ok := e.Err.(net.Error)
+	return ok && ne.Temporary()
+}
+
+// wrapClientError wraps client errors such as ErrTimeout into *ErrClient
+// with req host and the given addr. Other errors and client errors without
+// the host and addr are returned as is.
+func wrapClientError(err error, req *Request, addr string) error {
+	if _, ok := err.(*clientError); !ok {
+		return err
+	}
+	return newErrClient(err, req.Host(), addr)
+}
+
+func newErrClient(err error, host []byte, addr string) error {
+	if len(host) == 0 && len(addr) == 0 {
+		// There is no context to add.
+		return err
+	}
+	return &ErrClient{
+		Host: string(host),
+		Addr: addr,
+		Err:  err,
+	}
+}
+
+func (c *HostClient) acquireConn(deadline time.Time, trace *ClientTrace) (*clientConn, error) {
 	var cc *clientConn
+	var w *wantConn
 	createConn := false
 	startCleaner := false
+	startHealthChecker := false
 
 	var n int
 	c.connsLock.Lock()
@@ -1177,18 +2930,48 @@ func (c *HostClient) acquireConn() (*clientConn, error) {
 				startCleaner = true
 				c.connsCleanerRun = true
 			}
+			if c.HealthCheckInterval > 0 && !c.healthCheckerRun {
+				startHealthChecker = true
+				c.healthCheckerRun = true
+			}
+		} else if c.MaxConnWaitTimeout > 0 {
+			w = &wantConn{
+				ready: make(chan struct{}),
+			}
+			c.connsWait = append(c.connsWait, w)
 		}
 	} else {
+		conns := c.conns
 		n--
-		cc = c.conns[n]
-		c.conns[n] = nil
-		c.conns = c.conns[:n]
+		if c.ConnPoolStrategy == FIFO {
+			// conns are ordered by lastUseTime, so the least recently
+			// used connection is at the head.
+			cc = conns[0]
+			copy(conns, conns[1:])
+		} else {
+			cc = conns[n]
+		}
+		conns[n] = nil
+		c.conns = conns[:n]
 	}
 	c.connsLock.Unlock()
 
 	if cc != nil {
+		if c.CheckIdleConns && !isConnAlive(cc.c) {
+			// The connection has been closed by the peer while being idle.
+			c.closeConn(cc)
+			return c.acquireConn(deadline, trace)
+		}
+		c.incAddrRequests(cc)
 		return cc, nil
 	}
+	if w != nil {
+		cc, err := c.waitForConn(w, deadline)
+		if err == nil {
+			c.incAddrRequests(cc)
+		}
+		return cc, err
+	}
 	if !createConn {
 		return nil, ErrNoFreeConns
 	}
@@ -1196,17 +2979,116 @@ func (c *HostClient) acquireConn() (*clientConn, error) {
 	if startCleaner {
 		go c.connsCleaner()
 	}
+	if startHealthChecker {
+		go c.healthChecker()
+	}
 
-	conn, err := c.dialHostHard()
+	conn, addrIdx, err := c.dialHostHard(deadline, trace)
 	if err != nil {
 		c.decConnsCount()
 		return nil, err
 	}
 	cc = acquireClientConn(conn)
+	cc.addrIdx = addrIdx
+	cc.inFlight = c.AddrSelection == AddrLeastConns
 
 	return cc, nil
 }
 
+// wantConn is a request waiting for a free connection.
+//
+// All the fields except ready are protected by HostClient.connsLock.
+type wantConn struct {
+	// ready is closed when either conn or err is set.
+	ready     chan struct{}
+	conn      *clientConn
+	err       error
+	cancelled bool
+}
+
+func (c *HostClient) waitForConn(w *wantConn, deadline time.Time) (*clientConn, error) {
+	timeout := c.MaxConnWaitTimeout
+	if !deadline.IsZero() {
+		if d := -time.Since(deadline); d < timeout {
+			timeout = d
+		}
+	}
+
+	tc := acquireTimer(timeout)
+	defer releaseTimer(tc)
+	select {
+	case <-w.ready:
+		return w.conn, w.err
+	case <-tc.C:
+	}
+
+	c.connsLock.Lock()
+	select {
+	case <-w.ready:
+		// The connection has been delivered concurrently with the timeout.
+		c.connsLock.Unlock()
+		return w.conn, w.err
+	default:
+		w.cancelled = true
+	}
+	c.connsLock.Unlock()
+	return nil, ErrNoFreeConns
+}
+
+// popConnWaiterLocked removes the oldest non-cancelled request waiting
+// for a free connection from the queue and returns it.
+//
+// nil is returned if there are no waiting requests.
+// c.connsLock must be held.
+func (c *HostClient) popConnWaiterLocked() *wantConn {
+	for len(c.connsWait) > 0 {
+		w := c.connsWait[0]
+		c.connsWait[0] = nil
+		c.connsWait = c.connsWait[1:]
+		if !w.cancelled {
+			return w
+		}
+	}
+	return nil
+}
+
+// hasConnWaitersLocked returns true if there are requests waiting
+// for a free connection.
+//
+// c.connsLock must be held.
+func (c *HostClient) hasConnWaitersLocked() bool {
+	for len(c.connsWait) > 0 {
+		if !c.connsWait[0].cancelled {
+			return true
+		}
+		c.connsWait[0] = nil
+		c.connsWait = c.connsWait[1:]
+	}
+	return false
+}
+
+// dialConnForWaiter establishes new connection for the oldest request
+// waiting for a free connection.
+//
+// The caller must reserve a slot in c.connsCount for the connection.
+func (c *HostClient) dialConnForWaiter() {
+	conn, addrIdx, err := c.dialHostHard(zeroTime, nil)
+	if err != nil {
+		c.connsLock.Lock()
+		c.connsCount--
+		if w := c.popConnWaiterLocked(); w != nil {
+			w.err = err
+			close(w.ready)
+		}
+		c.connsLock.Unlock()
+		return
+	}
+	cc := acquireClientConn(conn)
+	cc.addrIdx = addrIdx
+	cc.inFlight = c.AddrSelection == AddrLeastConns
+	c.releaseConn(cc)
+}
+
 func (c *HostClient) connsCleaner() {
 	var (
 		scratch             []*clientConn
@@ -1257,18 +3139,122 @@ func (c *HostClient) connsCleaner() {
 	}
 }
 
+// closeIdleConns closes idle connections to the host.
+//
+// Busy connections are closed by connsCleaner after they become idle.
+func (c *HostClient) closeIdleConns() {
+	c.connsLock.Lock()
+	conns := append([]*clientConn(nil), c.conns...)
+	for i := range c.conns {
+		c.conns[i] = nil
+	}
+	c.conns = c.conns[:0]
+	c.connsLock.Unlock()
+
+	for _, cc := range conns {
+		c.closeConn(cc)
+	}
+}
+
+// watchConnCtx interrupts pending I/O on cc when ctx is done.
+//
+// The watcher is stopped when cc is released or closed.
+func (c *HostClient) watchConnCtx(cc *clientConn, ctx context.Context) {
+	// cc may be re-used after release, so the callback must refer
+	// to the underlying connection only.
+	conn := cc.c
+	external := cc.external
+	cc.stopCtxWatcher = context.AfterFunc(ctx, func() {
+		if external {
+			// External connections mustn't be closed.
+			conn.SetDeadline(aLongTimeAgo)
+		} else {
+			conn.Close()
+		}
+	})
+}
+
+// aLongTimeAgo is a deadline in the past, which interrupts pending I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
 func (c *HostClient) closeConn(cc *clientConn) {
+	if cc.stopCtxWatcher != nil {
+		cc.stopCtxWatcher()
+		cc.stopCtxWatcher = nil
+	}
+	if cc.dedicated {
+		c.closeRequestConn(cc)
+		return
+	}
+	c.decAddrRequests(cc)
 	c.decConnsCount()
 	cc.c.Close()
+	if c.MetricsCollector != nil {
+		c.MetricsCollector.ConnClosed(c.connAddr(cc))
+	}
 	releaseClientConn(cc)
 }
 
+// connAddr returns the address the given connection has been dialed to.
+func (c *HostClient) connAddr(cc *clientConn) string {
+	c.addrsLock.Lock()
+	defer c.addrsLock.Unlock()
+	if cc.addrIdx < len(c.addrs) {
+		return c.addrs[cc.addrIdx]
+	}
+	return c.Addr
+}
+
 func (c *HostClient) decConnsCount() {
 	c.connsLock.Lock()
+	if c.hasConnWaitersLocked() {
+		// Re-use the slot of the closed connection for a new connection
+		// to the waiting request.
+		c.connsLock.Unlock()
+		go c.dialConnForWaiter()
+		return
+	}
 	c.connsCount--
 	c.connsLock.Unlock()
 }
 
+// acquireRequestConn returns dedicated connection for the request
+// with custom Dial or Conn. The connection isn't counted in MaxConns
+// and isn't put into the pool.
+func (c *HostClient) acquireRequestConn(req *Request, deadline time.Time, trace *ClientTrace) (*clientConn, error) {
+	conn := req.conn
+	if conn == nil {
+		addr, idx := c.nextAddr()
+		c.decAddrRequestsIdx(idx)
+		timeout := c.DialTimeout
+		if timeout <= 0 {
+			timeout = DefaultDialTimeout
+		}
+		if d := -time.Since(deadline); !deadline.IsZero() && d < timeout {
+			timeout = d
+		}
+		var err error
+		conn, err = dialAddr(addr, req.dial, nil, false, c.IsTLS, c.cachedTLSConfig(addr), c.ProxyProtocolHeader, timeout, trace)
+		if err == nil && c.IsTLS {
+			err = c.tlsHandshake(conn, deadline, trace)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	cc := acquireClientConn(conn)
+	cc.dedicated = true
+	cc.external = req.conn != nil
+	return cc, nil
+}
+
+func (c *HostClient) closeRequestConn(cc *clientConn) {
+	if !cc.external {
+		cc.c.Close()
+	}
+	releaseClientConn(cc)
+}
+
 func acquireClientConn(conn net.Conn) *clientConn {
 	v := clientConnPool.Get()
 	if v == nil {
@@ -1281,16 +3267,38 @@ func acquireClientConn(conn net.Conn) *clientConn {
 }
 
 func releaseClientConn(cc *clientConn) {
-	cc.c = nil
+	// Reset all the fields, so the next connection doesn't inherit
+	// deadlines from the current one.
+	*cc = clientConn{}
 	clientConnPool.Put(cc)
 }
 
 var clientConnPool sync.Pool
 
 func (c *HostClient) releaseConn(cc *clientConn) {
+	if cc.stopCtxWatcher != nil {
+		interrupted := !cc.stopCtxWatcher()
+		cc.stopCtxWatcher = nil
+		if interrupted {
+			// The connection cannot be re-used after the interruption.
+			c.closeConn(cc)
+			return
+		}
+	}
+	if cc.dedicated {
+		// Dedicated connections aren't reused by other requests.
+		c.closeRequestConn(cc)
+		return
+	}
+	c.decAddrRequests(cc)
 	cc.lastUseTime = CoarseTimeNow()
 	c.connsLock.Lock()
-	c.conns = append(c.conns, cc)
+	if w := c.popConnWaiterLocked(); w != nil {
+		w.conn = cc
+		close(w.ready)
+	} else {
+		c.conns = append(c.conns, cc)
+	}
 	c.connsLock.Unlock()
 }
 
@@ -1345,6 +3353,9 @@ func newClientTLSConfig(c *tls.Config, addr string) *tls.Config {
 			NextProtos:        c.NextProtos,
 			ServerName:        c.ServerName,
 
+			GetClientCertificate:  c.GetClientCertificate,
+			VerifyPeerCertificate: c.VerifyPeerCertificate,
+
 			// Do not copy ClientAuth, since it is server-related stuff
 			// Do not copy ClientCAs, since it is server-related stuff
 
@@ -1369,12 +3380,11 @@ func newClientTLSConfig(c *tls.Config, addr string) *tls.Config {
 	}
 
 	if len(c.ServerName) == 0 {
-		serverName := tlsServerName(addr)
-		if serverName == "*" {
-			c.InsecureSkipVerify = true
-		} else {
-			c.ServerName = serverName
-		}
+		// Do not disable certificate verification if the server name
+		// cannot be determined, since this silently opens the connection
+		// to man-in-the-middle attacks. The handshake fails instead
+		// unless InsecureSkipVerify is explicitly set.
+		c.ServerName = tlsServerName(addr)
 	}
 	return c
 }
@@ -1385,26 +3395,196 @@ func tlsServerName(addr string) string {
 	}
 	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		return "*"
+		return ""
 	}
 	return host
 }
 
-func (c *HostClient) nextAddr() string {
+// nextAddr returns the address for the next connection and its index
+// in c.addrs.
+//
+// The caller must call decAddrRequestsIdx for the returned index
+// if the connection to the address cannot be established.
+func (c *HostClient) nextAddr() (string, int) {
+	leastConns := c.AddrSelection == AddrLeastConns
 	c.addrsLock.Lock()
-	if c.addrs == nil {
-		c.addrs = strings.Split(c.Addr, ",")
-	}
-	addr := c.addrs[0]
+	c.initAddrsLocked()
+	idx := 0
 	if len(c.addrs) > 1 {
-		addr = c.addrs[c.addrIdx%uint32(len(c.addrs))]
+		if leastConns {
+			idx = c.leastConnsAddrIdx()
+		} else if c.addrWeights != nil {
+			idx = c.nextWeightedAddrIdx()
+		} else {
+			idx = c.nextRoundRobinAddrIdx()
+		}
+	}
+	if leastConns {
+		c.addrRequests[idx]++
+	}
+	addr := c.addrs[idx]
+	c.addrsLock.Unlock()
+	return addr, idx
+}
+
+// initAddrsLocked parses c.Addr on the first call.
+//
+// addrsLock must be held by the caller.
+func (c *HostClient) initAddrsLocked() {
+	if c.addrs != nil {
+		return
+	}
+	c.addrs, c.addrWeights = parseWeightedAddrs(c.Addr)
+	if c.addrWeights != nil {
+		c.addrCurrentWeights = make([]int, len(c.addrWeights))
+	}
+	if c.AddrSelection == AddrLeastConns {
+		c.addrRequests = make([]int, len(c.addrs))
+	}
+	if c.HealthCheckInterval > 0 {
+		c.addrUnhealthy = make([]bool, len(c.addrs))
+	}
+}
+
+// isAddrAvailable returns false if the address at the given index
+// is removed from rotation by health checks.
+//
+// addrsLock must be held by the caller.
+func (c *HostClient) isAddrAvailable(idx int) bool {
+	if c.unhealthyAddrs == 0 || c.unhealthyAddrs == len(c.addrs) {
+		return true
+	}
+	return !c.addrUnhealthy[idx]
+}
+
+// nextRoundRobinAddrIdx returns the index of the next available address.
+//
+// addrsLock must be held by the caller.
+func (c *HostClient) nextRoundRobinAddrIdx() int {
+	n := uint32(len(c.addrs))
+	for {
+		idx := int(c.addrIdx % n)
 		c.addrIdx++
+		if c.isAddrAvailable(idx) {
+			return idx
+		}
+	}
+}
+
+// leastConnsAddrIdx returns the index of the address with the least number
+// of in-flight requests relative to its weight.
+//
+// addrsLock must be held by the caller.
+func (c *HostClient) leastConnsAddrIdx() int {
+	n := uint32(len(c.addrs))
+	start := c.addrIdx % n
+	c.addrIdx++
+
+	best := -1
+	for i := uint32(0); i < n; i++ {
+		idx := int((start + i) % n)
+		if !c.isAddrAvailable(idx) {
+			continue
+		}
+		// Compare requests[idx]/weight[idx] < requests[best]/weight[best]
+		// without division.
+		if best < 0 || c.addrRequests[idx]*c.addrWeight(best) < c.addrRequests[best]*c.addrWeight(idx) {
+			best = idx
+		}
+	}
+	return best
+}
+
+func (c *HostClient) addrWeight(idx int) int {
+	if c.addrWeights == nil {
+		return 1
+	}
+	return c.addrWeights[idx]
+}
+
+func (c *HostClient) incAddrRequests(cc *clientConn) {
+	if c.AddrSelection != AddrLeastConns {
+		return
+	}
+	c.addrsLock.Lock()
+	c.addrRequests[cc.addrIdx]++
+	c.addrsLock.Unlock()
+	cc.inFlight = true
+}
+
+func (c *HostClient) decAddrRequests(cc *clientConn) {
+	if !cc.inFlight {
+		return
 	}
+	cc.inFlight = false
+	c.decAddrRequestsIdx(cc.addrIdx)
+}
+
+func (c *HostClient) decAddrRequestsIdx(idx int) {
+	if c.AddrSelection != AddrLeastConns {
+		return
+	}
+	c.addrsLock.Lock()
+	c.addrRequests[idx]--
 	c.addrsLock.Unlock()
-	return addr
 }
 
-func (c *HostClient) dialHostHard() (conn net.Conn, err error) {
+// nextWeightedAddrIdx returns the index of the next address
+// using smooth weighted round-robin, which spreads the picks of heavy
+// addresses evenly instead of returning them in bursts.
+//
+// addrsLock must be held by the caller.
+func (c *HostClient) nextWeightedAddrIdx() int {
+	total := 0
+	best := -1
+	for i, w := range c.addrWeights {
+		if !c.isAddrAvailable(i) {
+			continue
+		}
+		c.addrCurrentWeights[i] += w
+		total += w
+		if best < 0 || c.addrCurrentWeights[i] > c.addrCurrentWeights[best] {
+			best = i
+		}
+	}
+	c.addrCurrentWeights[best] -= total
+	return best
+}
+
+const addrWeightPrefix = " w="
+
+// parseWeightedAddrs splits comma-separated addrs and strips
+// optional ' w=N' weight suffixes from them.
+//
+// The returned weights are nil if all the addresses have default weight.
+func parseWeightedAddrs(addrs string) ([]string, []int) {
+	as := strings.Split(addrs, ",")
+	var weights []int
+	for i, addr := range as {
+		w := 1
+		n := strings.LastIndex(addr, addrWeightPrefix)
+		if n >= 0 {
+			v, err := ParseUint(s2b(strings.TrimSpace(addr[n+len(addrWeightPrefix):])))
+			if err == nil && v > 0 {
+				w = v
+				addr = addr[:n]
+			}
+		}
+		as[i] = strings.TrimSpace(addr)
+		if w != 1 && weights == nil {
+			weights = make([]int, len(as))
+			for j := range weights {
+				weights[j] = 1
+			}
+		}
+		if weights != nil {
+			weights[i] = w
+		}
+	}
+	return as, weights
+}
+
+func (c *HostClient) dialHostHard(deadline time.Time, trace *ClientTrace) (conn net.Conn, addrIdx int, err error) {
 	// attempt to dial all the available hosts before giving up.
 
 	c.addrsLock.Lock()
@@ -1416,24 +3596,82 @@ func (c *HostClient) dialHostHard() (conn net.Conn, err error) {
 		n = 1
 	}
 
-	timeout := c.ReadTimeout + c.WriteTimeout
+	timeout := c.DialTimeout
 	if timeout <= 0 {
-		timeout = DefaultDialTimeout
+		timeout = c.ReadTimeout + c.WriteTimeout
+		if timeout <= 0 {
+			timeout = DefaultDialTimeout
+		}
+	}
+	dialDeadline := time.Now().Add(timeout)
+	if !deadline.IsZero() && deadline.Before(dialDeadline) {
+		// Dialing mustn't exceed the request deadline.
+		dialDeadline = deadline
 	}
-	deadline := time.Now().Add(timeout)
 	for n > 0 {
-		addr := c.nextAddr()
+		addr, idx := c.nextAddr()
 		tlsConfig := c.cachedTLSConfig(addr)
-		conn, err = dialAddr(addr, c.Dial, c.DialDualStack, c.IsTLS, tlsConfig)
+		attemptTimeout := -time.Since(dialDeadline)
+		if c.DialTimeout <= 0 && deadline.IsZero() && attemptTimeout < DefaultDialTimeout {
+			// Preserve the legacy behaviour, where each dial attempt
+			// is limited only by DefaultDialTimeout.
+			attemptTimeout = DefaultDialTimeout
+		}
+		conn, err = dialAddr(addr, c.Dial, c.DialWithTimeout, c.DialDualStack, c.IsTLS, tlsConfig, c.ProxyProtocolHeader, attemptTimeout, trace)
+		if err == nil && c.IsTLS {
+			err = c.tlsHandshake(conn, deadline, trace)
+		}
 		if err == nil {
-			return conn, nil
+			if c.MetricsCollector != nil {
+				c.MetricsCollector.ConnOpened(addr)
+			}
+			return conn, idx, nil
+		}
+		if c.MetricsCollector != nil {
+			c.MetricsCollector.DialFailed(addr, err)
 		}
-		if time.Since(deadline) >= 0 {
+		c.decAddrRequestsIdx(idx)
+		if time.Since(dialDeadline) >= 0 {
 			break
 		}
 		n--
 	}
-	return nil, err
+	return nil, 0, err
+}
+
+// tlsHandshake performs TLS handshake on conn within TLSHandshakeTimeout.
+//
+// The handshake is limited by the given deadline if it isn't zero.
+func (c *HostClient) tlsHandshake(conn net.Conn, deadline time.Time, trace *ClientTrace) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	timeout := c.TLSHandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultTLSHandshakeTimeout
+	}
+	if d := time.Now().Add(timeout); deadline.IsZero() || d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	trace.tlsHandshakeStart()
+	err := tlsConn.Handshake()
+	trace.tlsHandshakeDone(tlsConn.ConnectionState(), err)
+	if err == nil {
+		err = conn.SetDeadline(zeroTime)
+	}
+	if err != nil {
+		conn.Close()
+		if !time.Now().Before(deadline) {
+			return ErrTLSHandshakeTimeout
+		}
+		return err
+	}
+	return nil
 }
 
 func (c *HostClient) cachedTLSConfig(addr string) *tls.Config {
@@ -1448,6 +3686,27 @@ func (c *HostClient) cachedTLSConfig(addr string) *tls.Config {
 	cfg := c.tlsConfigMap[addr]
 	if cfg == nil {
 		cfg = newClientTLSConfig(c.TLSConfig, addr)
+		if len(c.TLSServerName) > 0 {
+			cfg.ServerName = c.TLSServerName
+		}
+		if len(c.ClientCertificates) > 0 {
+			cfg.Certificates = c.ClientCertificates
+		}
+		if c.GetClientCertificate != nil {
+			cfg.GetClientCertificate = c.GetClientCertificate
+		}
+		if c.RootCAs != nil {
+			cfg.RootCAs = c.RootCAs
+		}
+		if c.VerifyPeerCertificate != nil {
+			cfg.VerifyPeerCertificate = c.VerifyPeerCertificate
+		}
+		if c.InsecureSkipTLSVerify {
+			cfg.InsecureSkipVerify = true
+		}
+		if c.EnableHTTP2 && len(cfg.NextProtos) == 0 {
+			cfg.NextProtos = http2NextProtos
+		}
 		c.tlsConfigMap[addr] = cfg
 	}
 	c.tlsConfigMapLock.Unlock()
@@ -1455,28 +3714,55 @@ func (c *HostClient) cachedTLSConfig(addr string) *tls.Config {
 	return cfg
 }
 
-func dialAddr(addr string, dial DialFunc, dialDualStack, isTLS bool, tlsConfig *tls.Config) (net.Conn, error) {
-	if dial == nil {
-		if dialDualStack {
-			dial = DialDualStack
-		} else {
-			dial = Dial
-		}
+func dialAddr(addr string, dial DialFunc, dialWithTimeout DialFuncWithTimeout, dialDualStack, isTLS bool,
+	tlsConfig *tls.Config, proxyHeader func(conn net.Conn) *proxyproto.Header, timeout time.Duration, trace *ClientTrace) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	switch {
+	case dialWithTimeout != nil:
+		trace.connectStart("tcp", addr)
+		conn, err = dialWithTimeout(addr, timeout)
+		trace.connectDone("tcp", addr, err)
+	case dial != nil:
+		trace.connectStart("tcp", addr)
+		conn, err = dial(addr)
+		trace.connectDone("tcp", addr, err)
+	case strings.HasPrefix(addr, unixAddrPrefix):
+		trace.connectStart("unix", addr)
+		conn, err = dialUnix(addr, timeout)
+		trace.connectDone("unix", addr, err)
+	default:
+		// The default dialer calls trace hooks by itself.
 		addr = addMissingPort(addr, isTLS)
+		conn, err = defaultDialer.dial(addr, dialDualStack, timeout, trace)
 	}
-	conn, err := dial(addr)
 	if err != nil {
 		return nil, err
 	}
 	if conn == nil {
 		panic("BUG: DialFunc returned (nil, nil)")
 	}
+	if proxyHeader != nil {
+		if h := proxyHeader(conn); h != nil {
+			if _, err = conn.Write(h.AppendTo(nil)); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+	}
 	if isTLS {
 		conn = tls.Client(conn, tlsConfig)
 	}
 	return conn, nil
 }
 
+const unixAddrPrefix = "unix:"
+
+// dialUnix dials unix domain socket at addr with unix: prefix.
+func dialUnix(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", addr[len(unixAddrPrefix):], timeout)
+}
+
 func (c *HostClient) getClientName() []byte {
 	v := c.clientName.Load()
 	var clientName []byte
@@ -1669,7 +3955,8 @@ func (c *PipelineClient) DoTimeout(req *Request, resp *Response, timeout time.Du
 // It is recommended obtaining req and resp via AcquireRequest
 // and AcquireResponse in performance-critical code.
 func (c *PipelineClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
-	return c.getConnClient().DoDeadline(req, resp, deadline)
+	err := c.getConnClient().DoDeadline(req, resp, deadline)
+	return wrapClientError(err, req, c.Addr)
 }
 
 func (c *pipelineConnClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
@@ -1731,7 +4018,8 @@ func (c *pipelineConnClient) DoDeadline(req *Request, resp *Response, deadline t
 // It is recommended obtaining req and resp via AcquireRequest
 // and AcquireResponse in performance-critical code.
 func (c *PipelineClient) Do(req *Request, resp *Response) error {
-	return c.getConnClient().Do(req, resp)
+	err := c.getConnClient().Do(req, resp)
+	return wrapClientError(err, req, c.Addr)
 }
 
 func (c *pipelineConnClient) Do(req *Request, resp *Response) error {
@@ -1874,7 +4162,7 @@ func (c *pipelineConnClient) init() {
 
 func (c *pipelineConnClient) worker() error {
 	tlsConfig := c.cachedTLSConfig()
-	conn, err := dialAddr(c.Addr, c.Dial, c.DialDualStack, c.IsTLS, tlsConfig)
+	conn, err := dialAddr(c.Addr, c.Dial, nil, c.DialDualStack, c.IsTLS, tlsConfig, nil, DefaultDialTimeout, nil)
 	if err != nil {
 		return err
 	}
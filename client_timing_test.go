@@ -51,6 +51,18 @@ func (c *fakeClientConn) Close() error {
 	return nil
 }
 
+func (c *fakeClientConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *fakeClientConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *fakeClientConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
 func releaseFakeServerConn(c *fakeClientConn) {
 	c.n = 0
 	fakeClientConnPool.Put(c)
@@ -129,6 +141,36 @@ func BenchmarkClientDoFastServer(b *testing.B) {
 	})
 }
 
+func BenchmarkClientDoTimeoutFastServer(b *testing.B) {
+	body := []byte("012345678912")
+	s := []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return acquireFakeServerConn(s), nil
+		},
+		MaxConnsPerHost: runtime.GOMAXPROCS(-1),
+	}
+
+	nn := uint32(0)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var req Request
+		var resp Response
+		req.Header.SetRequestURI(fmt.Sprintf("http://foobar%d.com/aaa/bbb", atomic.AddUint32(&nn, 1)))
+		for pb.Next() {
+			if err := c.DoTimeout(&req, &resp, time.Second); err != nil {
+				b.Fatalf("unexpected error: %s", err)
+			}
+			if resp.Header.StatusCode() != StatusOK {
+				b.Fatalf("unexpected status code: %d", resp.Header.StatusCode())
+			}
+			if !bytes.Equal(resp.Body(), body) {
+				b.Fatalf("unexpected response body: %q. Expected %q", resp.Body(), body)
+			}
+		}
+	})
+}
+
 func BenchmarkNetHTTPClientDoFastServer(b *testing.B) {
 	body := []byte("012345678912")
 	s := []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
@@ -176,6 +218,25 @@ func nethttpEchoHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(r.RequestURI))
 }
 
+func BenchmarkClientHostClientManyHosts(b *testing.B) {
+	var c Client
+	uris := make([]*URI, 1000)
+	for i := range uris {
+		uris[i] = AcquireURI()
+		uris[i].Parse(nil, []byte(fmt.Sprintf("http://host%d.com/foo", i)))
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		n := 0
+		for pb.Next() {
+			if _, err := c.hostClient(uris[n%len(uris)], "", ""); err != nil {
+				b.Fatalf("unexpected error: %s", err)
+			}
+			n++
+		}
+	})
+}
+
 func BenchmarkClientGetEndToEnd1TCP(b *testing.B) {
 	benchmarkClientGetEndToEndTCP(b, 1)
 }
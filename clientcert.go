@@ -0,0 +1,107 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCertReloadInterval is the interval for checking certificate files
+// for updates by ClientCertificateLoader if ReloadInterval isn't set.
+const DefaultCertReloadInterval = time.Minute
+
+// ClientCertificateLoader loads TLS client certificate from files
+// and reloads it after the files are updated.
+//
+// Pass its' GetClientCertificate method to Client.GetClientCertificate
+// or HostClient.GetClientCertificate for picking up rotated certificates
+// without re-creating the client:
+//
+//	l := &fasthttp.ClientCertificateLoader{
+//	    CertFile: "/etc/certs/client.pem",
+//	    KeyFile:  "/etc/certs/client.key",
+//	}
+//	c := &fasthttp.HostClient{
+//	    Addr:                 "internal.service:443",
+//	    IsTLS:                true,
+//	    GetClientCertificate: l.GetClientCertificate,
+//	}
+//
+// It is safe calling ClientCertificateLoader methods from concurrently
+// running goroutines.
+type ClientCertificateLoader struct {
+	// Path to PEM-encoded certificate file.
+	CertFile string
+
+	// Path to PEM-encoded private key file.
+	KeyFile string
+
+	// Certificate files are checked for updates at most once
+	// per ReloadInterval.
+	//
+	// DefaultCertReloadInterval is used if not set.
+	ReloadInterval time.Duration
+
+	lock        sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	checkTime   time.Time
+}
+
+// GetClientCertificate returns the loaded client certificate.
+//
+// The certificate is reloaded if certificate files have been modified
+// since the last load. The previously loaded certificate is returned
+// if the updated files cannot be loaded, for instance, if they are
+// being written at the moment.
+//
+// The function signature matches tls.Config.GetClientCertificate.
+func (l *ClientCertificateLoader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	reloadInterval := l.ReloadInterval
+	if reloadInterval <= 0 {
+		reloadInterval = DefaultCertReloadInterval
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.cert != nil && time.Since(l.checkTime) < reloadInterval {
+		return l.cert, nil
+	}
+	l.checkTime = time.Now()
+
+	cert, err := l.load()
+	if err != nil {
+		if l.cert != nil {
+			return l.cert, nil
+		}
+		return nil, err
+	}
+	return cert, nil
+}
+
+func (l *ClientCertificateLoader) load() (*tls.Certificate, error) {
+	certStat, err := os.Stat(l.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat client certificate file %q: %s", l.CertFile, err)
+	}
+	keyStat, err := os.Stat(l.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat client key file %q: %s", l.KeyFile, err)
+	}
+	if l.cert != nil && certStat.ModTime().Equal(l.certModTime) && keyStat.ModTime().Equal(l.keyModTime) {
+		return l.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load client certificate from certFile=%q, keyFile=%q: %s", l.CertFile, l.KeyFile, err)
+	}
+	l.cert = &cert
+	l.certModTime = certStat.ModTime()
+	l.keyModTime = keyStat.ModTime()
+	return l.cert, nil
+}
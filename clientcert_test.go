@@ -0,0 +1,137 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestClientCertificateLoader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasthttp-clientcert")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := &ClientCertificateLoader{
+		CertFile:       dir + "/client.pem",
+		KeyFile:        dir + "/client.key",
+		ReloadInterval: time.Nanosecond,
+	}
+	if _, err := l.GetClientCertificate(nil); err == nil {
+		t.Fatalf("expecting non-nil error for missing certificate files")
+	}
+
+	copyFile(t, "./ssl-cert-snakeoil.pem", l.CertFile)
+	copyFile(t, "./ssl-cert-snakeoil.key", l.KeyFile)
+	cert, err := l.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cert1, err := l.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cert1 != cert {
+		t.Fatalf("certificate mustn't be reloaded if files aren't modified")
+	}
+
+	// Rotated certificate must be reloaded.
+	modTime := time.Now().Add(time.Hour)
+	touchFile(t, l.CertFile, modTime)
+	touchFile(t, l.KeyFile, modTime)
+	cert2, err := l.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cert2 == cert {
+		t.Fatalf("certificate must be reloaded after files modification")
+	}
+
+	// The previous certificate must be used if the updated files are broken.
+	if err := ioutil.WriteFile(l.CertFile, []byte("broken"), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	touchFile(t, l.CertFile, modTime.Add(time.Hour))
+	cert3, err := l.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cert3 != cert2 {
+		t.Fatalf("the previous certificate must be returned for broken certificate files")
+	}
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := ioutil.WriteFile(dst, data, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func touchFile(t *testing.T, path string, modTime time.Time) {
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestHostClientClientCertificate(t *testing.T) {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%d", len(r.TLS.PeerCertificates))
+	}))
+	s.TLS = &tls.Config{
+		ClientAuth: tls.RequireAnyClientCert,
+	}
+	s.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	s.StartTLS()
+	defer s.Close()
+
+	cert, err := tls.LoadX509KeyPair("./ssl-cert-snakeoil.pem", "./ssl-cert-snakeoil.key")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	l := &ClientCertificateLoader{
+		CertFile: "./ssl-cert-snakeoil.pem",
+		KeyFile:  "./ssl-cert-snakeoil.key",
+	}
+
+	testHostClientClientCertificate(t, s.Listener.Addr().String(), nil, nil, false)
+	testHostClientClientCertificate(t, s.Listener.Addr().String(), []tls.Certificate{cert}, nil, true)
+	testHostClientClientCertificate(t, s.Listener.Addr().String(), nil, l.GetClientCertificate, true)
+}
+
+func testHostClientClientCertificate(t *testing.T, addr string, certs []tls.Certificate,
+	getCert func(*tls.CertificateRequestInfo) (*tls.Certificate, error), expectSuccess bool) {
+	c := &HostClient{
+		Addr:                 addr,
+		IsTLS:                true,
+		TLSConfig:            &tls.Config{InsecureSkipVerify: true},
+		ClientCertificates:   certs,
+		GetClientCertificate: getCert,
+	}
+	statusCode, body, err := c.Get(nil, "https://foobar.com/")
+	if !expectSuccess {
+		if err == nil {
+			t.Fatalf("expecting non-nil error without client certificate")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", statusCode, StatusOK)
+	}
+	if string(body) != "1" {
+		t.Fatalf("unexpected number of peer certificates: %q. Expecting %q", body, "1")
+	}
+}
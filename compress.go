@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"os"
 	"sync"
 
+	"github.com/andybalholm/brotli"
 	"github.com/klauspost/compress/flate"
 	"github.com/klauspost/compress/gzip"
 	"github.com/klauspost/compress/zlib"
@@ -23,6 +23,16 @@ const (
 	CompressHuffmanOnly        = -2 // flate.HuffmanOnly
 )
 
+// Supported brotli compression levels.
+//
+// Any level in the range [CompressBrotliBestSpeed..CompressBrotliBestCompression]
+// may be used.
+const (
+	CompressBrotliBestSpeed          = brotli.BestSpeed
+	CompressBrotliBestCompression    = brotli.BestCompression
+	CompressBrotliDefaultCompression = 4
+)
+
 func acquireGzipReader(r io.Reader) (*gzip.Reader, error) {
 	v := gzipReaderPool.Get()
 	if v == nil {
@@ -133,9 +143,11 @@ var (
 //    * CompressDefaultCompression
 //    * CompressHuffmanOnly
 func AppendGzipBytesLevel(dst, src []byte, level int) []byte {
-	w := &byteSliceWriter{dst}
+	w := acquireByteSliceWriter(dst)
 	WriteGzipLevel(w, src, level)
-	return w.b
+	dst = w.b
+	releaseByteSliceWriter(w)
+	return dst
 }
 
 // WriteGzipLevel writes gzipped p to w using the given compression level
@@ -155,12 +167,9 @@ func WriteGzipLevel(w io.Writer, p []byte, level int) (int, error) {
 		*ByteBuffer,
 		*bytebufferpool.ByteBuffer:
 		// These writers don't block, so we can just use stacklessWriteGzip
-		ctx := &compressCtx{
-			w:     w,
-			p:     p,
-			level: level,
-		}
+		ctx := acquireCompressCtx(w, p, level)
 		stacklessWriteGzip(ctx)
+		releaseCompressCtx(ctx)
 		return len(p), nil
 	default:
 		zw := acquireStacklessGzipWriter(w, level)
@@ -230,9 +239,11 @@ func AppendGunzipBytes(dst, src []byte) ([]byte, error) {
 //    * CompressDefaultCompression
 //    * CompressHuffmanOnly
 func AppendDeflateBytesLevel(dst, src []byte, level int) []byte {
-	w := &byteSliceWriter{dst}
+	w := acquireByteSliceWriter(dst)
 	WriteDeflateLevel(w, src, level)
-	return w.b
+	dst = w.b
+	releaseByteSliceWriter(w)
+	return dst
 }
 
 // WriteDeflateLevel writes deflated p to w using the given compression level
@@ -252,12 +263,9 @@ func WriteDeflateLevel(w io.Writer, p []byte, level int) (int, error) {
 		*ByteBuffer,
 		*bytebufferpool.ByteBuffer:
 		// These writers don't block, so we can just use stacklessWriteDeflate
-		ctx := &compressCtx{
-			w:     w,
-			p:     p,
-			level: level,
-		}
+		ctx := acquireCompressCtx(w, p, level)
 		stacklessWriteDeflate(ctx)
+		releaseCompressCtx(ctx)
 		return len(p), nil
 	default:
 		zw := acquireStacklessDeflateWriter(w, level)
@@ -287,6 +295,26 @@ type compressCtx struct {
 	level int
 }
 
+var compressCtxPool sync.Pool
+
+func acquireCompressCtx(w io.Writer, p []byte, level int) *compressCtx {
+	v := compressCtxPool.Get()
+	if v == nil {
+		v = &compressCtx{}
+	}
+	ctx := v.(*compressCtx)
+	ctx.w = w
+	ctx.p = p
+	ctx.level = level
+	return ctx
+}
+
+func releaseCompressCtx(ctx *compressCtx) {
+	ctx.w = nil
+	ctx.p = nil
+	compressCtxPool.Put(ctx)
+}
+
 // WriteDeflate writes deflated p to w and returns the number of compressed
 // bytes written to w.
 func WriteDeflate(w io.Writer, p []byte) (int, error) {
@@ -326,6 +354,23 @@ type byteSliceWriter struct {
 	b []byte
 }
 
+var byteSliceWriterPool sync.Pool
+
+func acquireByteSliceWriter(b []byte) *byteSliceWriter {
+	v := byteSliceWriterPool.Get()
+	if v == nil {
+		v = &byteSliceWriter{}
+	}
+	w := v.(*byteSliceWriter)
+	w.b = b
+	return w
+}
+
+func releaseByteSliceWriter(w *byteSliceWriter) {
+	w.b = nil
+	byteSliceWriterPool.Put(w)
+}
+
 func (w *byteSliceWriter) Write(p []byte) (int, error) {
 	w.b = append(w.b, p...)
 	return len(p), nil
@@ -405,7 +450,7 @@ func newCompressWriterPoolMap() []*sync.Pool {
 	return m
 }
 
-func isFileCompressible(f *os.File, minCompressRatio float64) bool {
+func isFileCompressible(f io.ReadSeeker, minCompressRatio float64) bool {
 	// Try compressing the first 4kb of of the file
 	// and see if it can be compressed by more than
 	// the given minCompressRatio.
@@ -438,3 +483,319 @@ func normalizeCompressLevel(level int) int {
 	}
 	return level + 2
 }
+
+func acquireBrotliReader(r io.Reader) (*brotli.Reader, error) {
+	v := brotliReaderPool.Get()
+	if v == nil {
+		return brotli.NewReader(r), nil
+	}
+	zr := v.(*brotli.Reader)
+	if err := zr.Reset(r); err != nil {
+		return nil, err
+	}
+	return zr, nil
+}
+
+func releaseBrotliReader(zr *brotli.Reader) {
+	brotliReaderPool.Put(zr)
+}
+
+var brotliReaderPool sync.Pool
+
+func acquireStacklessBrotliWriter(w io.Writer, level int) stackless.Writer {
+	nLevel := normalizeBrotliCompressLevel(level)
+	p := stacklessBrotliWriterPoolMap[nLevel]
+	v := p.Get()
+	if v == nil {
+		return stackless.NewWriter(w, func(w io.Writer) stackless.Writer {
+			return acquireRealBrotliWriter(w, level)
+		})
+	}
+	sw := v.(stackless.Writer)
+	sw.Reset(w)
+	return sw
+}
+
+func releaseStacklessBrotliWriter(sw stackless.Writer, level int) {
+	sw.Close()
+	nLevel := normalizeBrotliCompressLevel(level)
+	p := stacklessBrotliWriterPoolMap[nLevel]
+	p.Put(sw)
+}
+
+func acquireRealBrotliWriter(w io.Writer, level int) *brotli.Writer {
+	nLevel := normalizeBrotliCompressLevel(level)
+	p := realBrotliWriterPoolMap[nLevel]
+	v := p.Get()
+	if v == nil {
+		return brotli.NewWriterLevel(w, nLevel)
+	}
+	zw := v.(*brotli.Writer)
+	zw.Reset(w)
+	return zw
+}
+
+func releaseRealBrotliWriter(zw *brotli.Writer, level int) {
+	zw.Close()
+	nLevel := normalizeBrotliCompressLevel(level)
+	p := realBrotliWriterPoolMap[nLevel]
+	p.Put(zw)
+}
+
+var (
+	stacklessBrotliWriterPoolMap = newCompressWriterPoolMap()
+	realBrotliWriterPoolMap      = newCompressWriterPoolMap()
+)
+
+// AppendBrotliBytesLevel appends brotlied src to dst using the given
+// compression level and returns the resulting dst.
+//
+// Supported compression levels are:
+//
+//    * CompressBrotliBestSpeed
+//    * CompressBrotliBestCompression
+//    * CompressBrotliDefaultCompression
+func AppendBrotliBytesLevel(dst, src []byte, level int) []byte {
+	w := acquireByteSliceWriter(dst)
+	WriteBrotliLevel(w, src, level)
+	dst = w.b
+	releaseByteSliceWriter(w)
+	return dst
+}
+
+// WriteBrotliLevel writes brotlied p to w using the given compression level
+// and returns the number of compressed bytes written to w.
+//
+// Supported compression levels are:
+//
+//    * CompressBrotliBestSpeed
+//    * CompressBrotliBestCompression
+//    * CompressBrotliDefaultCompression
+func WriteBrotliLevel(w io.Writer, p []byte, level int) (int, error) {
+	switch w.(type) {
+	case *byteSliceWriter,
+		*bytes.Buffer,
+		*ByteBuffer,
+		*bytebufferpool.ByteBuffer:
+		// These writers don't block, so we can just use stacklessWriteBrotli
+		ctx := acquireCompressCtx(w, p, level)
+		stacklessWriteBrotli(ctx)
+		releaseCompressCtx(ctx)
+		return len(p), nil
+	default:
+		zw := acquireStacklessBrotliWriter(w, level)
+		n, err := zw.Write(p)
+		releaseStacklessBrotliWriter(zw, level)
+		return n, err
+	}
+}
+
+var stacklessWriteBrotli = stackless.NewFunc(nonblockingWriteBrotli)
+
+func nonblockingWriteBrotli(ctxv interface{}) {
+	ctx := ctxv.(*compressCtx)
+	zw := acquireRealBrotliWriter(ctx.w, ctx.level)
+
+	_, err := zw.Write(ctx.p)
+	if err != nil {
+		panic(fmt.Sprintf("BUG: brotli.Writer.Write for len(p)=%d returned unexpected error: %s", len(ctx.p), err))
+	}
+
+	releaseRealBrotliWriter(zw, ctx.level)
+}
+
+// WriteBrotli writes brotlied p to w and returns the number of compressed
+// bytes written to w.
+func WriteBrotli(w io.Writer, p []byte) (int, error) {
+	return WriteBrotliLevel(w, p, CompressBrotliDefaultCompression)
+}
+
+// AppendBrotliBytes appends brotlied src to dst and returns the resulting dst.
+func AppendBrotliBytes(dst, src []byte) []byte {
+	return AppendBrotliBytesLevel(dst, src, CompressBrotliDefaultCompression)
+}
+
+// WriteUnbrotli writes unbrotlied p to w and returns the number of uncompressed
+// bytes written to w.
+func WriteUnbrotli(w io.Writer, p []byte) (int, error) {
+	r := &byteSliceReader{p}
+	zr, err := acquireBrotliReader(r)
+	if err != nil {
+		return 0, err
+	}
+	n, err := copyZeroAlloc(w, zr)
+	releaseBrotliReader(zr)
+	nn := int(n)
+	if int64(nn) != n {
+		return 0, fmt.Errorf("too much data unbrotlied: %d", n)
+	}
+	return nn, err
+}
+
+// AppendUnbrotliBytes appends unbrotlied src to dst and returns the resulting dst.
+func AppendUnbrotliBytes(dst, src []byte) ([]byte, error) {
+	w := &byteSliceWriter{dst}
+	_, err := WriteUnbrotli(w, src)
+	return w.b, err
+}
+
+// normalizes brotli compression level into [0..11], so it could be used
+// as an index in *PoolMap.
+func normalizeBrotliCompressLevel(level int) int {
+	if level < CompressBrotliBestSpeed || level > CompressBrotliBestCompression {
+		level = CompressBrotliDefaultCompression
+	}
+	return level
+}
+
+func acquireStacklessCompressWriter(encoding []byte, w io.Writer, level int) stackless.Writer {
+	switch string(encoding) {
+	case "br":
+		return acquireStacklessBrotliWriter(w, level)
+	case "gzip":
+		return acquireStacklessGzipWriter(w, level)
+	case "deflate":
+		return acquireStacklessDeflateWriter(w, level)
+	default:
+		panic(fmt.Sprintf("BUG: unsupported encoding %q", encoding))
+	}
+}
+
+func releaseStacklessCompressWriter(encoding []byte, sw stackless.Writer, level int) {
+	switch string(encoding) {
+	case "br":
+		releaseStacklessBrotliWriter(sw, level)
+	case "gzip":
+		releaseStacklessGzipWriter(sw, level)
+	case "deflate":
+		releaseStacklessDeflateWriter(sw, level)
+	default:
+		panic(fmt.Sprintf("BUG: unsupported encoding %q", encoding))
+	}
+}
+
+func appendCompressedBytes(encoding, dst, src []byte, level int) []byte {
+	switch string(encoding) {
+	case "br":
+		return AppendBrotliBytesLevel(dst, src, level)
+	case "gzip":
+		return AppendGzipBytesLevel(dst, src, level)
+	case "deflate":
+		return AppendDeflateBytesLevel(dst, src, level)
+	default:
+		panic(fmt.Sprintf("BUG: unsupported encoding %q", encoding))
+	}
+}
+
+// canCompress returns true if the response may be compressed.
+//
+// The response may be compressed if it isn't compressed yet, it isn't
+// a partial response, it has one of the given contentTypes and its body
+// isn't smaller than minSize.
+func (resp *Response) canCompress(contentTypes [][]byte, minSize int) bool {
+	h := &resp.Header
+	if len(h.peek(strContentEncoding)) > 0 {
+		return false
+	}
+	statusCode := h.StatusCode()
+	if statusCode == StatusNoContent || statusCode == StatusNotModified || (statusCode >= 100 && statusCode < 200) {
+		return false
+	}
+	if statusCode == StatusPartialContent || len(h.peek(strContentRange)) > 0 {
+		// Compressing the body would break the byte range.
+		return false
+	}
+	if hasHeaderValueFold(h.peek(strCacheControl), strNoTransform) {
+		return false
+	}
+	if !matchContentType(h.ContentType(), contentTypes) {
+		return false
+	}
+	if resp.bodyStream != nil {
+		// The size of streamed body is known only if Content-Length is set.
+		contentLength := h.ContentLength()
+		return contentLength < 0 || contentLength >= minSize
+	}
+	return len(resp.bodyBytes()) >= minSize
+}
+
+// matchContentType returns true if contentType matches one of contentTypes.
+//
+// contentTypes entries ending with '/' match all the subtypes.
+func matchContentType(contentType []byte, contentTypes [][]byte) bool {
+	if n := bytes.IndexByte(contentType, ';'); n >= 0 {
+		contentType = contentType[:n]
+	}
+	contentType = bytes.TrimSpace(contentType)
+	for _, ct := range contentTypes {
+		if ct[len(ct)-1] == '/' {
+			if len(contentType) > len(ct) && bytes.EqualFold(contentType[:len(ct)], ct) {
+				return true
+			}
+		} else if bytes.EqualFold(contentType, ct) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateContentEncoding returns the encoding with the highest quality
+// in the given Accept-Encoding header value.
+//
+// The first encoding is preferred among encodings with equal quality.
+// nil is returned if none of encodings is acceptable.
+func negotiateContentEncoding(acceptEncoding []byte, encodings [][]byte) []byte {
+	var bestEncoding []byte
+	bestQuality := 0.0
+	for _, encoding := range encodings {
+		if q := acceptEncodingQuality(acceptEncoding, encoding); q > bestQuality {
+			bestEncoding = encoding
+			bestQuality = q
+		}
+	}
+	return bestEncoding
+}
+
+// acceptEncodingQuality returns the quality of the given encoding
+// in the given Accept-Encoding header value.
+//
+// 0 is returned if the encoding isn't acceptable.
+func acceptEncodingQuality(acceptEncoding, encoding []byte) float64 {
+	quality := -1.0
+	wildcardQuality := -1.0
+	b := acceptEncoding
+	for len(b) > 0 {
+		item := b
+		if n := bytes.IndexByte(b, ','); n >= 0 {
+			item = b[:n]
+			b = b[n+1:]
+		} else {
+			b = nil
+		}
+		name := item
+		q := 1.0
+		if n := bytes.IndexByte(item, ';'); n >= 0 {
+			name = item[:n]
+			param := bytes.TrimSpace(item[n+1:])
+			if bytes.HasPrefix(param, strQualityParam) {
+				var err error
+				if q, err = ParseUfloat(param[len(strQualityParam):]); err != nil {
+					q = 0
+				}
+			}
+		}
+		name = bytes.TrimSpace(name)
+		if bytes.EqualFold(name, encoding) {
+			quality = q
+		} else if len(name) == 1 && name[0] == '*' {
+			wildcardQuality = q
+		}
+	}
+	if quality >= 0 {
+		return quality
+	}
+	if wildcardQuality >= 0 {
+		return wildcardQuality
+	}
+	return 0
+}
@@ -43,6 +43,18 @@ func TestDeflateBytesConcurrent(t *testing.T) {
 	}
 }
 
+func TestBrotliBytesSerial(t *testing.T) {
+	if err := testBrotliBytes(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBrotliBytesConcurrent(t *testing.T) {
+	if err := testConcurrent(10, testBrotliBytes); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func testGzipBytes() error {
 	for _, s := range compressTestcases {
 		if err := testGzipBytesSingleCase(s); err != nil {
@@ -61,6 +73,15 @@ func testDeflateBytes() error {
 	return nil
 }
 
+func testBrotliBytes() error {
+	for _, s := range compressTestcases {
+		if err := testBrotliBytesSingleCase(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func testGzipBytesSingleCase(s string) error {
 	prefix := []byte("foobar")
 	gzippedS := AppendGzipBytes(prefix, []byte(s))
@@ -103,6 +124,27 @@ func testDeflateBytesSingleCase(s string) error {
 	return nil
 }
 
+func testBrotliBytesSingleCase(s string) error {
+	prefix := []byte("foobar")
+	brotliedS := AppendBrotliBytes(prefix, []byte(s))
+	if !bytes.Equal(brotliedS[:len(prefix)], prefix) {
+		return fmt.Errorf("unexpected prefix when compressing %q: %q. Expecting %q", s, brotliedS[:len(prefix)], prefix)
+	}
+
+	unbrotliedS, err := AppendUnbrotliBytes(prefix, brotliedS[len(prefix):])
+	if err != nil {
+		return fmt.Errorf("unexpected error when uncompressing %q: %s", s, err)
+	}
+	if !bytes.Equal(unbrotliedS[:len(prefix)], prefix) {
+		return fmt.Errorf("unexpected prefix when uncompressing %q: %q. Expecting %q", s, unbrotliedS[:len(prefix)], prefix)
+	}
+	unbrotliedS = unbrotliedS[len(prefix):]
+	if string(unbrotliedS) != s {
+		return fmt.Errorf("unexpected uncompressed string %q. Expecting %q", unbrotliedS, s)
+	}
+	return nil
+}
+
 func TestGzipCompressSerial(t *testing.T) {
 	if err := testGzipCompress(); err != nil {
 		t.Fatal(err)
@@ -127,6 +169,18 @@ func TestFlateCompressConcurrent(t *testing.T) {
 	}
 }
 
+func TestBrotliCompressSerial(t *testing.T) {
+	if err := testBrotliCompress(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBrotliCompressConcurrent(t *testing.T) {
+	if err := testConcurrent(10, testBrotliCompress); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func testGzipCompress() error {
 	for _, s := range compressTestcases {
 		if err := testGzipCompressSingleCase(s); err != nil {
@@ -145,6 +199,15 @@ func testFlateCompress() error {
 	return nil
 }
 
+func testBrotliCompress() error {
+	for _, s := range compressTestcases {
+		if err := testBrotliCompressSingleCase(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func testGzipCompressSingleCase(s string) error {
 	var buf bytes.Buffer
 	zw := acquireStacklessGzipWriter(&buf, CompressDefaultCompression)
@@ -191,6 +254,29 @@ func testFlateCompressSingleCase(s string) error {
 	return nil
 }
 
+func testBrotliCompressSingleCase(s string) error {
+	var buf bytes.Buffer
+	zw := acquireStacklessBrotliWriter(&buf, CompressBrotliDefaultCompression)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		return fmt.Errorf("unexpected error: %s. s=%q", err, s)
+	}
+	releaseStacklessBrotliWriter(zw, CompressBrotliDefaultCompression)
+
+	zr, err := acquireBrotliReader(&buf)
+	if err != nil {
+		return fmt.Errorf("unexpected error: %s. s=%q", err, s)
+	}
+	body, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return fmt.Errorf("unexpected error: %s. s=%q", err, s)
+	}
+	if string(body) != s {
+		return fmt.Errorf("unexpected string after decompression: %q. Expecting %q", body, s)
+	}
+	releaseBrotliReader(zr)
+	return nil
+}
+
 func testConcurrent(concurrency int, f func() error) error {
 	ch := make(chan error, concurrency)
 	for i := 0; i < concurrency; i++ {
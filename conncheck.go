@@ -0,0 +1,74 @@
+// +build aix darwin dragonfly freebsd illumos linux netbsd openbsd solaris
+
+package fasthttp
+
+import (
+	"crypto/tls"
+	"net"
+	"syscall"
+)
+
+// isConnAlive returns false if the given idle connection has been closed
+// by the peer or contains unexpected data.
+//
+// The check is a non-blocking read from the underlying socket.
+// Connections not implementing syscall.Conn are considered alive.
+func isConnAlive(conn net.Conn) bool {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return true
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	alive := false
+	var b [1]byte
+	err = rc.Read(func(fd uintptr) bool {
+		n, err := syscall.Read(int(fd), b[:])
+		// n == 0 means the connection has been closed by the peer,
+		// while n > 0 means unexpected data such as 408 response
+		// sent by the server before closing the connection.
+		alive = n < 0 && (err == syscall.EAGAIN || err == syscall.EWOULDBLOCK)
+		// Do not wait for the connection to become readable.
+		return true
+	})
+	return err == nil && alive
+}
+
+// isConnClosed returns true if the given connection has been closed
+// by the peer or has been broken.
+//
+// Unlike isConnAlive, the check doesn't consume data from the connection,
+// so it may be used for connections with pending requests.
+// Connections not implementing syscall.Conn are considered open.
+func isConnClosed(conn net.Conn) bool {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return true
+	}
+
+	closed := false
+	var b [1]byte
+	err = rc.Read(func(fd uintptr) bool {
+		n, _, err := syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK)
+		// n == 0 means the connection has been closed by the peer,
+		// while EAGAIN means there is no data to read yet.
+		closed = (err == nil && n == 0) ||
+			(err != nil && err != syscall.EAGAIN && err != syscall.EWOULDBLOCK && err != syscall.EINTR)
+		// Do not wait for the connection to become readable.
+		return true
+	})
+	return err != nil || closed
+}
@@ -0,0 +1,19 @@
+// +build !aix,!darwin,!dragonfly,!freebsd,!illumos,!linux,!netbsd,!openbsd,!solaris
+
+package fasthttp
+
+import (
+	"net"
+)
+
+// isConnAlive always returns true, since non-blocking reads
+// aren't supported on this platform.
+func isConnAlive(conn net.Conn) bool {
+	return true
+}
+
+// isConnClosed always returns false, since non-blocking reads
+// aren't supported on this platform.
+func isConnClosed(conn net.Conn) bool {
+	return false
+}
@@ -0,0 +1,400 @@
+package fasthttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoCookieStorage is returned by CookieJar.Save and CookieJar.Load
+// if CookieJar.Storage isn't set.
+var ErrNoCookieStorage = errors.New("CookieJar.Storage isn't set")
+
+// CookieStorage persists CookieJar contents between process restarts.
+//
+// Custom CookieStorage may be used for storing cookies in a database,
+// for instance, in BoltDB or Redis.
+//
+// CookieStorage implementations must be safe for concurrent use.
+type CookieStorage interface {
+	// Load returns the data passed to the last successful Save call.
+	//
+	// Empty data must be returned if nothing has been saved yet.
+	Load() ([]byte, error)
+
+	// Save saves the given data, replacing the previously saved data.
+	Save(data []byte) error
+}
+
+// FileCookieStorage persists CookieJar contents in a file.
+type FileCookieStorage struct {
+	// Path to the file.
+	//
+	// The file is created on the first Save call.
+	//
+	// This field is required.
+	Path string
+}
+
+// Load reads the data from the file.
+//
+// Empty data is returned if the file doesn't exist.
+func (s *FileCookieStorage) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// Save atomically replaces the file contents with the given data.
+func (s *FileCookieStorage) Save(data []byte) error {
+	tmpPath := s.Path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.Path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// CookieJar stores cookies received by Client in Set-Cookie response
+// headers and sends them back with subsequent requests according
+// to domain, path, secure and expiration cookie attributes.
+//
+// See RFC 6265 for details. Public suffix list isn't consulted,
+// so domain cookies are accepted only for domains with at least
+// two labels.
+//
+// Cookies may be persisted with Save and restored with Load,
+// so sessions survive process restarts.
+//
+// It is safe calling CookieJar methods from concurrently running
+// goroutines.
+type CookieJar struct {
+	// Storage for persisting cookies via Save and Load.
+	//
+	// Session cookies without expiration time are persisted too,
+	// since the session lasts until the cookies are removed from the jar.
+	//
+	// By default cookies are stored in memory only.
+	Storage CookieStorage
+
+	lock sync.Mutex
+
+	// m maps domains to cookies. Host-only cookies have empty domain.
+	m map[string][]*Cookie
+}
+
+// Set stores the given cookies received from the given uri.
+//
+// Cookies with expiration time in the past are removed from the jar.
+func (j *CookieJar) Set(uri *URI, cookies ...*Cookie) {
+	host := cookieJarHost(uri)
+	defaultPath := cookieDefaultPath(uri.Path())
+	now := time.Now()
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	for _, c := range cookies {
+		j.set(host, defaultPath, c, now)
+	}
+}
+
+// Get returns cookies, which must be sent to the given uri.
+//
+// Cookies with longer paths are returned first. The returned cookies
+// are copies, so they may be modified by the caller.
+func (j *CookieJar) Get(uri *URI) []*Cookie {
+	host := cookieJarHost(uri)
+	path := uri.Path()
+	isTLS := bytes.Equal(uri.Scheme(), strHTTPS)
+	isIP := net.ParseIP(host) != nil
+	now := time.Now()
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	var dst []*Cookie
+	domain := host
+	for {
+		cookies := j.m[domain]
+		n := 0
+		for _, c := range cookies {
+			if isCookieExpired(c, now) {
+				continue
+			}
+			cookies[n] = c
+			n++
+			if len(c.domain) == 0 && domain != host {
+				// Host-only cookies are sent to the exact host only.
+				continue
+			}
+			if c.secure && !isTLS {
+				continue
+			}
+			if !cookiePathMatch(path, c.path) {
+				continue
+			}
+			cc := &Cookie{}
+			cc.CopyTo(c)
+			dst = append(dst, cc)
+		}
+		if n < len(cookies) {
+			j.setDomainCookies(domain, cookies[:n])
+		}
+
+		i := bytes.IndexByte(s2b(domain), '.')
+		if i < 0 || isIP {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	sort.SliceStable(dst, func(i, k int) bool {
+		return len(dst[i].path) > len(dst[k].path)
+	})
+	return dst
+}
+
+// Save saves all the cookies from the jar to Storage.
+func (j *CookieJar) Save() error {
+	if j.Storage == nil {
+		return ErrNoCookieStorage
+	}
+	return j.Storage.Save(j.appendCookies(nil))
+}
+
+// Load loads cookies from Storage into the jar.
+//
+// Loaded cookies replace the cookies with the same name, domain and path
+// in the jar. Expired cookies are skipped.
+func (j *CookieJar) Load() error {
+	if j.Storage == nil {
+		return ErrNoCookieStorage
+	}
+	data, err := j.Storage.Load()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	for len(data) > 0 {
+		var line []byte
+		n := bytes.IndexByte(data, '\n')
+		if n < 0 {
+			line, data = data, nil
+		} else {
+			line, data = data[:n], data[n+1:]
+		}
+		n = bytes.IndexByte(line, ' ')
+		if n <= 0 {
+			return fmt.Errorf("cannot find cookie domain in %q", line)
+		}
+		c := &Cookie{}
+		if err := c.ParseBytes(line[n+1:]); err != nil {
+			return fmt.Errorf("cannot parse cookie %q: %s", line[n+1:], err)
+		}
+		if !isCookieExpired(c, now) {
+			j.replace(string(line[:n]), c)
+		}
+	}
+	return nil
+}
+
+// appendCookies appends all the cookies from the jar to dst in the format
+// accepted by Load.
+//
+// Each cookie is written on a separate line prefixed with its' domain.
+func (j *CookieJar) appendCookies(dst []byte) []byte {
+	now := time.Now()
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	domains := make([]string, 0, len(j.m))
+	for domain := range j.m {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		for _, c := range j.m[domain] {
+			if isCookieExpired(c, now) {
+				continue
+			}
+			dst = append(dst, domain...)
+			dst = append(dst, ' ')
+			dst = c.AppendBytes(dst)
+			dst = append(dst, '\n')
+		}
+	}
+	return dst
+}
+
+// set stores the cookie c received from host. It must be called
+// under j.lock.
+func (j *CookieJar) set(host, defaultPath string, c *Cookie, now time.Time) {
+	if len(c.key) == 0 {
+		return
+	}
+
+	domain := host
+	var cookieDomain []byte
+	if len(c.domain) > 0 {
+		d := bytes.ToLower(bytes.TrimPrefix(c.domain, strDot))
+		if string(d) != host {
+			if net.ParseIP(host) != nil || bytes.IndexByte(d, '.') < 0 ||
+				len(host) <= len(d) || !bytes.HasSuffix(s2b(host), d) || host[len(host)-len(d)-1] != '.' {
+				// The cookie domain doesn't match the host.
+				return
+			}
+		}
+		domain = string(d)
+		cookieDomain = d
+	}
+
+	cc := &Cookie{}
+	cc.CopyTo(c)
+	cc.domain = append(cc.domain[:0], cookieDomain...)
+	if len(cc.path) == 0 || cc.path[0] != '/' {
+		cc.path = append(cc.path[:0], defaultPath...)
+	}
+
+	if isCookieExpired(cc, now) {
+		j.delete(domain, cc)
+		return
+	}
+	j.replace(domain, cc)
+}
+
+// replace stores c under the given domain, replacing the cookie
+// with the same name, domain and path. It must be called under j.lock.
+func (j *CookieJar) replace(domain string, c *Cookie) {
+	cookies := j.m[domain]
+	for i, x := range cookies {
+		if isSameCookie(x, c) {
+			cookies[i] = c
+			return
+		}
+	}
+	j.setDomainCookies(domain, append(cookies, c))
+}
+
+// delete removes the cookie with the same name, domain and path as c
+// from the given domain. It must be called under j.lock.
+func (j *CookieJar) delete(domain string, c *Cookie) {
+	cookies := j.m[domain]
+	for i, x := range cookies {
+		if isSameCookie(x, c) {
+			cookies = append(cookies[:i], cookies[i+1:]...)
+			j.setDomainCookies(domain, cookies)
+			return
+		}
+	}
+}
+
+func (j *CookieJar) setDomainCookies(domain string, cookies []*Cookie) {
+	if len(cookies) == 0 {
+		delete(j.m, domain)
+		return
+	}
+	if j.m == nil {
+		j.m = make(map[string][]*Cookie)
+	}
+	j.m[domain] = cookies
+}
+
+// addRequestCookies adds cookies from the jar to req and returns
+// the added cookies.
+//
+// Cookies already set in req aren't overwritten.
+func (j *CookieJar) addRequestCookies(req *Request) []*Cookie {
+	cookies := j.Get(req.URI())
+	n := 0
+	for _, c := range cookies {
+		if len(req.Header.CookieBytes(c.key)) > 0 {
+			continue
+		}
+		req.Header.SetCookieBytesKV(c.key, c.value)
+		cookies[n] = c
+		n++
+	}
+	return cookies[:n]
+}
+
+func (c *Client) doWithCookieJar(req *Request, resp *Response, deadline time.Time) error {
+	jar := c.CookieJar
+	added := jar.addRequestCookies(req)
+	err := c.doCacheable(req, resp, deadline)
+
+	// Remove the added cookies, so they aren't leaked to other hosts
+	// if the request is re-used, e.g. when following redirects.
+	for _, cookie := range added {
+		req.Header.DelCookieBytes(cookie.key)
+	}
+
+	if err == nil && resp != nil {
+		var cookies []*Cookie
+		resp.Header.VisitAllCookie(func(key, value []byte) {
+			cookie := &Cookie{}
+			if cookie.ParseBytes(value) == nil {
+				cookies = append(cookies, cookie)
+			}
+		})
+		if len(cookies) > 0 {
+			jar.Set(req.URI(), cookies...)
+		}
+	}
+	return err
+}
+
+func isSameCookie(a, b *Cookie) bool {
+	return bytes.Equal(a.key, b.key) && bytes.Equal(a.domain, b.domain) && bytes.Equal(a.path, b.path)
+}
+
+func isCookieExpired(c *Cookie, now time.Time) bool {
+	return !c.expire.IsZero() && !c.expire.After(now)
+}
+
+// cookieJarHost returns lowercase uri host without port.
+func cookieJarHost(uri *URI) string {
+	host := uri.Host()
+	if h, _, err := net.SplitHostPort(b2s(host)); err == nil {
+		host = s2b(h)
+	}
+	return string(bytes.ToLower(host))
+}
+
+// cookieDefaultPath returns the default cookie path for the given
+// request path. See RFC 6265, section 5.1.4.
+func cookieDefaultPath(path []byte) string {
+	n := bytes.LastIndexByte(path, '/')
+	if n <= 0 {
+		return "/"
+	}
+	return string(path[:n])
+}
+
+// cookiePathMatch returns true if the request path matches
+// the cookie path. See RFC 6265, section 5.1.4.
+func cookiePathMatch(path, cookiePath []byte) bool {
+	if !bytes.HasPrefix(path, cookiePath) {
+		return false
+	}
+	return len(path) == len(cookiePath) || cookiePath[len(cookiePath)-1] == '/' ||
+		path[len(cookiePath)] == '/'
+}
@@ -0,0 +1,160 @@
+package fasthttp
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestCookieJar(t *testing.T) {
+	var j CookieJar
+	j.Set(testCookieJarURI("http://www.example.com/foo/bar"),
+		testCookieJarCookie(t, "host=1"),
+		testCookieJarCookie(t, "domain=2; domain=.example.com; path=/"),
+		testCookieJarCookie(t, "secure=3; path=/; secure"),
+		testCookieJarCookie(t, "path=4; path=/baz"),
+		testCookieJarCookie(t, "expired=5; expires=Tue, 10 Nov 2009 23:00:00 GMT"),
+		testCookieJarCookie(t, "foreign=6; domain=example.org"),
+		testCookieJarCookie(t, "tld=7; domain=com"),
+	)
+
+	testCookieJarGet(t, &j, "http://www.example.com/foo/bar", "host=1; domain=2")
+	testCookieJarGet(t, &j, "https://www.example.com/foo/", "host=1; secure=3; domain=2")
+	testCookieJarGet(t, &j, "http://www.example.com/foobar", "domain=2")
+	testCookieJarGet(t, &j, "http://www.example.com/baz/x", "path=4; domain=2")
+	testCookieJarGet(t, &j, "http://api.example.com:8080/foo/", "domain=2")
+	testCookieJarGet(t, &j, "http://example.com/", "domain=2")
+	testCookieJarGet(t, &j, "http://example.org/", "")
+
+	// Cookies must be replaced and deleted.
+	j.Set(testCookieJarURI("http://www.example.com/"),
+		testCookieJarCookie(t, "domain=new; domain=example.com; path=/"),
+		testCookieJarCookie(t, "host=1; path=/foo; expires=Tue, 10 Nov 2009 23:00:00 GMT"),
+	)
+	testCookieJarGet(t, &j, "http://www.example.com/foo/bar", "domain=new")
+}
+
+func TestCookieJarSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasthttp-cookiejar")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	storage := &FileCookieStorage{
+		Path: filepath.Join(dir, "cookies"),
+	}
+
+	var j CookieJar
+	if err := j.Save(); err != ErrNoCookieStorage {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrNoCookieStorage)
+	}
+	j.Storage = storage
+
+	// Missing file must be loaded as empty jar.
+	if err := j.Load(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expire := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	session := testCookieJarCookie(t, "session=abc")
+	persistent := testCookieJarCookie(t, "id=1; domain=example.com; path=/")
+	persistent.SetExpire(expire)
+	j.Set(testCookieJarURI("https://www.example.com/foo/bar"), session, persistent)
+	if err := j.Save(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	j1 := &CookieJar{Storage: storage}
+	if err := j1.Load(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testCookieJarGet(t, j1, "https://www.example.com/foo/", "session=abc; id=1")
+	testCookieJarGet(t, j1, "https://api.example.com/", "id=1")
+
+	cookies := j1.Get(testCookieJarURI("https://api.example.com/"))
+	if !cookies[0].Expire().Equal(expire) {
+		t.Fatalf("unexpected cookie expiration time %s. Expecting %s", cookies[0].Expire(), expire)
+	}
+}
+
+func TestClientCookieJar(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/login":
+				var c Cookie
+				c.SetKey("session")
+				c.SetValue("secret")
+				ctx.Response.Header.SetCookie(&c)
+				ctx.Redirect("/check", StatusFound)
+			case "/check":
+				ctx.Write(ctx.Request.Header.Peek("Cookie"))
+			}
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		CookieJar: &CookieJar{},
+	}
+
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://example.com/login")
+	if err := c.DoRedirects(&req, &resp, 1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "session=secret" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "session=secret")
+	}
+	if len(req.Header.Peek("Cookie")) > 0 {
+		t.Fatalf("unexpected cookie left in the request: %q", req.Header.Peek("Cookie"))
+	}
+
+	// Cookies set in the request mustn't be overwritten.
+	req.Reset()
+	req.SetRequestURI("http://example.com/check")
+	req.Header.SetCookie("session", "custom")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "session=custom" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "session=custom")
+	}
+}
+
+func testCookieJarURI(uri string) *URI {
+	u := &URI{}
+	u.Parse(nil, []byte(uri))
+	return u
+}
+
+func testCookieJarCookie(t *testing.T, s string) *Cookie {
+	c := &Cookie{}
+	if err := c.Parse(s); err != nil {
+		t.Fatalf("cannot parse cookie %q: %s", s, err)
+	}
+	return c
+}
+
+func testCookieJarGet(t *testing.T, j *CookieJar, uri, expectedCookies string) {
+	var a []string
+	for _, c := range j.Get(testCookieJarURI(uri)) {
+		a = append(a, string(c.Key())+"="+string(c.Value()))
+	}
+	cookies := strings.Join(a, "; ")
+	if cookies != expectedCookies {
+		t.Fatalf("unexpected cookies for %q: %q. Expecting %q", uri, cookies, expectedCookies)
+	}
+}
@@ -0,0 +1,512 @@
+package fasthttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultDownloadSegmentSize is the default size of a segment requested
+// by RangeDownloader in a single Range request.
+const DefaultDownloadSegmentSize = 4 * 1024 * 1024
+
+// DefaultDownloadMaxAttempts is the default number of attempts
+// RangeDownloader makes for downloading a single segment.
+const DefaultDownloadMaxAttempts = 5
+
+// ErrDownloadResourceChanged is returned by RangeDownloader if the resource
+// has been changed on the server during the download.
+var ErrDownloadResourceChanged = errors.New("the resource has been changed during the download")
+
+// RangeDownloader downloads resources in segments using Range requests.
+//
+// Each segment is requested via the given HostClient, so pooled connections
+// are re-used between segments. Failed segments are re-requested, so
+// the download is resumed from the last successfully written segment
+// after transient errors. ETag and Last-Modified response headers are
+// verified between segments, so the download fails
+// with ErrDownloadResourceChanged instead of mixing up distinct resource
+// versions.
+//
+// It is safe calling RangeDownloader methods from concurrently running
+// goroutines.
+type RangeDownloader struct {
+	// Client is used for sending Range requests.
+	//
+	// This field is required.
+	Client *HostClient
+
+	// The maximum number of bytes requested in a single Range request.
+	//
+	// Every segment is buffered in memory before being written
+	// to the destination, so this value limits memory usage per download.
+	//
+	// DefaultDownloadSegmentSize is used if not set.
+	SegmentSize int
+
+	// The maximum number of attempts for downloading a single segment.
+	//
+	// DefaultDownloadMaxAttempts is used if not set.
+	MaxAttempts int
+
+	// Delay between attempts for downloading a single segment.
+	//
+	// By default the segment is re-requested without delay.
+	RetryDelay time.Duration
+
+	// Timeout for downloading a single segment.
+	//
+	// By default segment downloads are limited only by the Client
+	// timeouts.
+	Timeout time.Duration
+}
+
+// Download downloads the resource from the given url and writes it to w.
+//
+// w may be an *os.File. The resource is written starting from offset 0.
+//
+// The number of bytes written to w is returned. The server may ignore
+// Range requests and return the whole resource in a single response.
+func (d *RangeDownloader) Download(w io.WriterAt, url string) (int64, error) {
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+
+	segmentSize := d.SegmentSize
+	if segmentSize <= 0 {
+		segmentSize = DefaultDownloadSegmentSize
+	}
+
+	var etag, lastModified []byte
+	offset := 0
+	total := -1
+	for total < 0 || offset < total {
+		end := offset + segmentSize - 1
+		if total >= 0 && end >= total {
+			end = total - 1
+		}
+
+		req.SetRequestURI(url)
+		req.Header.SetByteRange(offset, end)
+		if len(etag) > 0 && !bytes.HasPrefix(etag, strWeakETagPrefix) {
+			req.Header.SetCanonical(strIfRange, etag)
+		} else if len(lastModified) > 0 {
+			req.Header.SetCanonical(strIfRange, lastModified)
+		}
+		if err := d.doSegment(req, resp); err != nil {
+			return int64(offset), err
+		}
+
+		switch resp.StatusCode() {
+		case StatusOK:
+			if offset > 0 {
+				// The server returns the whole resource only
+				// if If-Range validator doesn't match.
+				return int64(offset), ErrDownloadResourceChanged
+			}
+			body := resp.Body()
+			if _, err := w.WriteAt(body, 0); err != nil {
+				return 0, err
+			}
+			return int64(len(body)), nil
+		case StatusPartialContent:
+		case StatusRequestedRangeNotSatisfiable:
+			// The server cannot satisfy the range if the resource is empty
+			// or its length is a multiple of segmentSize.
+			_, _, n, err := parseContentRange(resp.Header.peek(strContentRange))
+			if err == nil && n == offset && total < 0 {
+				return int64(offset), nil
+			}
+			return int64(offset), fmt.Errorf("unexpected status code %d returned for range %d-%d of %q", StatusRequestedRangeNotSatisfiable, offset, end, url)
+		default:
+			return int64(offset), fmt.Errorf("unexpected status code %d returned for %q", resp.StatusCode(), url)
+		}
+
+		if offset == 0 {
+			etag = append(etag[:0], resp.Header.peek(strETag)...)
+			lastModified = append(lastModified[:0], resp.Header.peek(strLastModified)...)
+		} else if !bytes.Equal(etag, resp.Header.peek(strETag)) ||
+			!bytes.Equal(lastModified, resp.Header.peek(strLastModified)) {
+			return int64(offset), ErrDownloadResourceChanged
+		}
+
+		startPos, endPos, n, err := parseContentRange(resp.Header.peek(strContentRange))
+		if err != nil {
+			return int64(offset), fmt.Errorf("cannot parse Content-Range returned for %q: %s", url, err)
+		}
+		body := resp.Body()
+		if startPos != offset || endPos > end || endPos-startPos+1 != len(body) {
+			return int64(offset), fmt.Errorf("unexpected Content-Range %q returned for range %d-%d of %q",
+				resp.Header.peek(strContentRange), offset, end, url)
+		}
+		if total >= 0 && n != total {
+			return int64(offset), ErrDownloadResourceChanged
+		}
+		if _, err = w.WriteAt(body, int64(offset)); err != nil {
+			return int64(offset), err
+		}
+		offset += len(body)
+		total = n
+		if total < 0 && endPos < end {
+			// The resource length is unknown and the server returned
+			// less bytes than requested, so the end of the resource
+			// has been reached.
+			break
+		}
+	}
+	return int64(offset), nil
+}
+
+// ErrDownloadChecksumMismatch is returned by FileDownloader if the checksum
+// of the downloaded resource doesn't match FileDownloader.Checksum.
+var ErrDownloadChecksumMismatch = errors.New("the downloaded resource checksum mismatch")
+
+// DownloadFile downloads the resource from the given url to the file
+// at the given path.
+//
+// See FileDownloader for details.
+func DownloadFile(url, path string) error {
+	var d FileDownloader
+	return d.Download(url, path)
+}
+
+// DownloadFile downloads the resource from the given url to the file
+// at the given path.
+//
+// See FileDownloader for details.
+func (c *Client) DownloadFile(url, path string) error {
+	d := &FileDownloader{
+		Client: c,
+	}
+	return d.Download(url, path)
+}
+
+// FileDownloader downloads resources to files.
+//
+// The resource is streamed to the temporary file at path + ".download",
+// which is synced to disk and atomically renamed to the destination path
+// after the download is complete and verified. So the destination file
+// never contains partially downloaded resource.
+//
+// Interrupted downloads are resumed with Range requests if the server
+// supports them. The temporary file is kept on failed downloads, so
+// the next download to the same path is resumed from the already
+// downloaded part. Set Checksum for verifying resources resumed
+// after restarts, since the resource may change in the mean time.
+type FileDownloader struct {
+	// Client is used for sending requests.
+	//
+	// The default client is used if not set.
+	Client *Client
+
+	// The maximum number of attempts for downloading the resource.
+	//
+	// DefaultDownloadMaxAttempts is used if not set.
+	MaxAttempts int
+
+	// Delay between attempts.
+	//
+	// By default the download is resumed without delay.
+	RetryDelay time.Duration
+
+	// Timeout for a single attempt including reading response body.
+	//
+	// By default attempts are limited only by the Client timeouts.
+	Timeout time.Duration
+
+	// Expected checksum of the resource.
+	//
+	// The download fails with ErrDownloadChecksumMismatch and
+	// the temporary file is removed if the checksum doesn't match.
+	//
+	// By default the checksum isn't verified.
+	Checksum []byte
+
+	// Hash is used for calculating the resource checksum.
+	//
+	// sha256.New is used if not set.
+	Hash func() hash.Hash
+}
+
+// Download downloads the resource from the given url to the file
+// at the given path.
+//
+// The destination file is replaced only if the download succeeds.
+func (d *FileDownloader) Download(url, path string) error {
+	tmpPath := path + ".download"
+	f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	fd := &fileDownload{
+		url:    url,
+		f:      f,
+		offset: offset,
+		total:  -1,
+	}
+	if err = d.download(fd); err != nil {
+		// Keep the temporary file for resuming the download later.
+		f.Close()
+		return err
+	}
+	if err = d.verifyChecksum(f); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (d *FileDownloader) download(fd *fileDownload) error {
+	c := d.Client
+	if c == nil {
+		c = &defaultClient
+	}
+	maxAttempts := d.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultDownloadMaxAttempts
+	}
+
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+
+	for attempt := 1; ; attempt++ {
+		retry, err := fd.attempt(c, req, resp, d.Timeout)
+		if err == nil {
+			break
+		}
+		if !retry || attempt >= maxAttempts {
+			return err
+		}
+		if d.RetryDelay > 0 {
+			time.Sleep(d.RetryDelay)
+		}
+	}
+	if fd.total >= 0 && fd.offset != fd.total {
+		return fmt.Errorf("unexpected size of the resource downloaded from %q: %d bytes. Expecting %d bytes", fd.url, fd.offset, fd.total)
+	}
+	return nil
+}
+
+func (d *FileDownloader) verifyChecksum(f *os.File) error {
+	if len(d.Checksum) == 0 {
+		return nil
+	}
+	newHash := d.Hash
+	if newHash == nil {
+		newHash = sha256.New
+	}
+	h := newHash()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := copyZeroAlloc(h, f); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), d.Checksum) {
+		return ErrDownloadChecksumMismatch
+	}
+	return nil
+}
+
+// fileDownload holds the state of FileDownloader.Download call.
+type fileDownload struct {
+	url string
+	f   *os.File
+
+	// offset is the number of bytes already written to f.
+	offset int64
+
+	// total is the resource length or -1 if it is unknown yet.
+	total int64
+
+	// validator is ETag or Last-Modified of the resource,
+	// which is sent in If-Range header on resumption.
+	validator []byte
+}
+
+// attempt downloads the remaining part of the resource.
+//
+// retry is set to true if the download may be resumed after the error.
+func (fd *fileDownload) attempt(c *Client, req *Request, resp *Response, timeout time.Duration) (retry bool, err error) {
+	req.Reset()
+	req.SetRequestURI(fd.url)
+	if fd.offset > 0 {
+		req.Header.SetByteRange(int(fd.offset), -1)
+		if len(fd.validator) > 0 {
+			req.Header.SetCanonical(strIfRange, fd.validator)
+		}
+	}
+	resp.Reset()
+	resp.StreamBody = true
+	if timeout > 0 {
+		err = c.DoTimeout(req, resp, timeout)
+	} else {
+		err = c.Do(req, resp)
+	}
+	if err != nil {
+		return true, err
+	}
+	defer resp.CloseBodyStream()
+
+	switch statusCode := resp.StatusCode(); {
+	case statusCode == StatusOK:
+		if fd.offset > 0 {
+			// The server doesn't support Range requests
+			// or the resource has been changed, so start from scratch.
+			if err = fd.f.Truncate(0); err != nil {
+				return false, err
+			}
+			fd.offset = 0
+			fd.validator = fd.validator[:0]
+		}
+		if n := resp.Header.ContentLength(); n >= 0 {
+			fd.total = int64(n)
+		}
+	case statusCode == StatusPartialContent && fd.offset > 0:
+		startPos, _, n, err := parseContentRange(resp.Header.peek(strContentRange))
+		if err != nil || int64(startPos) != fd.offset {
+			return false, fmt.Errorf("unexpected Content-Range %q returned for range %d- of %q",
+				resp.Header.peek(strContentRange), fd.offset, fd.url)
+		}
+		fd.total = int64(n)
+	case statusCode == StatusRequestedRangeNotSatisfiable && fd.offset > 0:
+		_, _, n, err := parseContentRange(resp.Header.peek(strContentRange))
+		if err == nil && int64(n) == fd.offset {
+			// The resource has been already downloaded.
+			fd.total = fd.offset
+			return false, nil
+		}
+		// The resource has been changed, so start from scratch.
+		if err = fd.f.Truncate(0); err != nil {
+			return false, err
+		}
+		fd.offset = 0
+		return true, fmt.Errorf("unexpected status code %d returned for %q", statusCode, fd.url)
+	default:
+		return isTransientStatusCode(statusCode), fmt.Errorf("unexpected status code %d returned for %q", statusCode, fd.url)
+	}
+
+	if len(fd.validator) == 0 {
+		if etag := resp.Header.peek(strETag); len(etag) > 0 && !bytes.HasPrefix(etag, strWeakETagPrefix) {
+			fd.validator = append(fd.validator[:0], etag...)
+		} else {
+			fd.validator = append(fd.validator[:0], resp.Header.peek(strLastModified)...)
+		}
+	}
+
+	if _, err = fd.f.Seek(fd.offset, io.SeekStart); err != nil {
+		return false, err
+	}
+	var n int64
+	if bs := resp.BodyStream(); bs != nil {
+		n, err = copyZeroAlloc(fd.f, bs)
+	} else {
+		var m int
+		m, err = fd.f.Write(resp.Body())
+		n = int64(m)
+	}
+	fd.offset += n
+	if err != nil {
+		return true, err
+	}
+	if contentLength := resp.Header.ContentLength(); contentLength >= 0 && n != int64(contentLength) {
+		return true, io.ErrUnexpectedEOF
+	}
+	return false, nil
+}
+
+func (d *RangeDownloader) doSegment(req *Request, resp *Response) error {
+	maxAttempts := d.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultDownloadMaxAttempts
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if d.Timeout > 0 {
+			err = d.Client.DoTimeout(req, resp, d.Timeout)
+		} else {
+			err = d.Client.Do(req, resp)
+		}
+		if err == nil && !isTransientStatusCode(resp.StatusCode()) {
+			return nil
+		}
+		if attempt >= maxAttempts {
+			break
+		}
+		if d.RetryDelay > 0 {
+			time.Sleep(d.RetryDelay)
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("unexpected status code %d returned after %d attempts", resp.StatusCode(), maxAttempts)
+	}
+	return err
+}
+
+func isTransientStatusCode(statusCode int) bool {
+	return statusCode >= 500 || statusCode == StatusRequestTimeout || statusCode == StatusTooManyRequests
+}
+
+// parseContentRange parses 'Content-Range: bytes startPos-endPos/contentLength'
+// header value.
+//
+// contentLength is set to -1 if the resource length is unknown.
+// startPos and endPos are set to -1 for unsatisfied ranges.
+func parseContentRange(b []byte) (startPos, endPos, contentLength int, err error) {
+	if !bytes.HasPrefix(b, strBytes) || len(b) == len(strBytes) || b[len(strBytes)] != ' ' {
+		return 0, 0, 0, fmt.Errorf("unsupported content range %q", b)
+	}
+	s := b[len(strBytes)+1:]
+	n := bytes.IndexByte(s, '/')
+	if n < 0 {
+		return 0, 0, 0, fmt.Errorf("missing resource length in content range %q", b)
+	}
+	contentLength = -1
+	if cl := s[n+1:]; len(cl) != 1 || cl[0] != '*' {
+		if contentLength, err = ParseUint(cl); err != nil {
+			return 0, 0, 0, fmt.Errorf("cannot parse resource length in content range %q: %s", b, err)
+		}
+	}
+
+	s = s[:n]
+	if len(s) == 1 && s[0] == '*' {
+		return -1, -1, contentLength, nil
+	}
+	n = bytes.IndexByte(s, '-')
+	if n < 0 {
+		return 0, 0, 0, fmt.Errorf("missing the end position in content range %q", b)
+	}
+	if startPos, err = ParseUint(s[:n]); err != nil {
+		return 0, 0, 0, fmt.Errorf("cannot parse the start position in content range %q: %s", b, err)
+	}
+	if endPos, err = ParseUint(s[n+1:]); err != nil {
+		return 0, 0, 0, fmt.Errorf("cannot parse the end position in content range %q: %s", b, err)
+	}
+	if endPos < startPos || (contentLength >= 0 && endPos >= contentLength) {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", b)
+	}
+	return startPos, endPos, contentLength, nil
+}
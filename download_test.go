@@ -0,0 +1,385 @@
+package fasthttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+type testWriterAt struct {
+	b []byte
+}
+
+func (w *testWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if n := int(off) + len(p); n > len(w.b) {
+		w.b = append(w.b, make([]byte, n-len(w.b))...)
+	}
+	return copy(w.b[off:], p), nil
+}
+
+func newRangeDownloaderTestClient(t *testing.T, h RequestHandler) (*HostClient, func()) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: h,
+	}
+	go s.Serve(ln)
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	return c, func() { ln.Close() }
+}
+
+func serveTestRange(ctx *RequestCtx, body []byte, etag string) {
+	if len(etag) > 0 {
+		ctx.Response.Header.Set("ETag", etag)
+	}
+	byteRange := ctx.Request.Header.Peek("Range")
+	if len(byteRange) == 0 {
+		ctx.Write(body)
+		return
+	}
+	startPos, endPos, err := ParseByteRange(byteRange, len(body))
+	if err != nil {
+		ctx.Response.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", len(body)))
+		ctx.SetStatusCode(StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	ctx.Response.Header.SetContentRange(startPos, endPos, len(body))
+	ctx.SetStatusCode(StatusPartialContent)
+	ctx.Write(body[startPos : endPos+1])
+}
+
+func TestRangeDownloader(t *testing.T) {
+	for _, n := range []int{0, 1, 99, 100, 101, 1000} {
+		body := createFixedBody(n)
+		var requestsCount uint32
+		c, stop := newRangeDownloaderTestClient(t, func(ctx *RequestCtx) {
+			atomic.AddUint32(&requestsCount, 1)
+			serveTestRange(ctx, body, `"foo"`)
+		})
+
+		d := &RangeDownloader{
+			Client:      c,
+			SegmentSize: 100,
+		}
+		var w testWriterAt
+		written, err := d.Download(&w, "http://foobar/baz")
+		if err != nil {
+			t.Fatalf("n=%d: unexpected error: %s", n, err)
+		}
+		if written != int64(n) {
+			t.Fatalf("n=%d: unexpected number of bytes written: %d. Expecting %d", n, written, n)
+		}
+		if !bytes.Equal(w.b, body) {
+			t.Fatalf("n=%d: unexpected body %q. Expecting %q", n, w.b, body)
+		}
+		expectedRequestsCount := (n + 99) / 100
+		if expectedRequestsCount == 0 {
+			expectedRequestsCount = 1
+		}
+		if rc := atomic.LoadUint32(&requestsCount); int(rc) != expectedRequestsCount {
+			t.Fatalf("n=%d: unexpected number of requests: %d. Expecting %d", n, rc, expectedRequestsCount)
+		}
+		stop()
+	}
+}
+
+func TestRangeDownloaderNoRangeSupport(t *testing.T) {
+	body := createFixedBody(1000)
+	c, stop := newRangeDownloaderTestClient(t, func(ctx *RequestCtx) {
+		ctx.Write(body)
+	})
+	defer stop()
+
+	d := &RangeDownloader{
+		Client:      c,
+		SegmentSize: 100,
+	}
+	var w testWriterAt
+	written, err := d.Download(&w, "http://foobar/baz")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if written != int64(len(body)) || !bytes.Equal(w.b, body) {
+		t.Fatalf("unexpected body %q. Expecting %q", w.b, body)
+	}
+}
+
+func TestRangeDownloaderResume(t *testing.T) {
+	body := createFixedBody(1000)
+	var requestsCount uint32
+	c, stop := newRangeDownloaderTestClient(t, func(ctx *RequestCtx) {
+		switch atomic.AddUint32(&requestsCount, 1) % 3 {
+		case 1:
+			ctx.Error("try again", StatusServiceUnavailable)
+		case 2:
+			// Send incomplete response body, so the server closes
+			// the connection.
+			ctx.SetStatusCode(StatusPartialContent)
+			ctx.Response.Header.SetContentRange(0, 99, len(body))
+			ctx.SetBodyStream(bytes.NewReader(body[:10]), 100)
+		default:
+			serveTestRange(ctx, body, "")
+		}
+	})
+	defer stop()
+
+	// Disable retries in HostClient, so failed segments are re-requested
+	// by RangeDownloader.
+	c.RetryIf = func(req *Request, resp *Response, err error) bool {
+		return false
+	}
+
+	d := &RangeDownloader{
+		Client:      c,
+		SegmentSize: 100,
+		MaxAttempts: 3,
+		RetryDelay:  time.Millisecond,
+	}
+	var w testWriterAt
+	written, err := d.Download(&w, "http://foobar/baz")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if written != int64(len(body)) || !bytes.Equal(w.b, body) {
+		t.Fatalf("unexpected body %q. Expecting %q", w.b, body)
+	}
+
+	// The download must fail when the number of attempts is exceeded.
+	d.MaxAttempts = 2
+	if _, err = d.Download(&w, "http://foobar/baz"); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
+
+func TestRangeDownloaderResourceChanged(t *testing.T) {
+	body := createFixedBody(1000)
+	var requestsCount uint32
+	c, stop := newRangeDownloaderTestClient(t, func(ctx *RequestCtx) {
+		etag := `"foo"`
+		if atomic.AddUint32(&requestsCount, 1) > 2 {
+			etag = `"bar"`
+		}
+		serveTestRange(ctx, body, etag)
+	})
+	defer stop()
+
+	d := &RangeDownloader{
+		Client:      c,
+		SegmentSize: 100,
+	}
+	var w testWriterAt
+	written, err := d.Download(&w, "http://foobar/baz")
+	if err != ErrDownloadResourceChanged {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrDownloadResourceChanged)
+	}
+	if written != 200 {
+		t.Fatalf("unexpected number of bytes written: %d. Expecting 200", written)
+	}
+}
+
+func TestRangeDownloaderFS(t *testing.T) {
+	f, err := ioutil.TempFile("", "fasthttp-download")
+	if err != nil {
+		t.Fatalf("cannot create temporary file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// The resource is served by FS, which verifies Last-Modified support.
+	c, stop := newRangeDownloaderTestClient(t, FSHandler(".", 0))
+	defer stop()
+
+	d := &RangeDownloader{
+		Client:      c,
+		SegmentSize: 1000,
+	}
+	written, err := d.Download(f, "http://foobar/fs.go")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedBody, err := ioutil.ReadFile("fs.go")
+	if err != nil {
+		t.Fatalf("cannot read file: %s", err)
+	}
+	body, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("cannot read file: %s", err)
+	}
+	if written != int64(len(expectedBody)) || !bytes.Equal(body, expectedBody) {
+		t.Fatalf("unexpected file contents with len %d. Expecting len %d", len(body), len(expectedBody))
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	testParseContentRangeSuccess(t, "bytes 0-0/1", 0, 0, 1)
+	testParseContentRangeSuccess(t, "bytes 10-19/100", 10, 19, 100)
+	testParseContentRangeSuccess(t, "bytes 10-19/*", 10, 19, -1)
+	testParseContentRangeSuccess(t, "bytes */100", -1, -1, 100)
+
+	testParseContentRangeError(t, "")
+	testParseContentRangeError(t, "bytes")
+	testParseContentRangeError(t, "items 0-1/2")
+	testParseContentRangeError(t, "bytes 0-1")
+	testParseContentRangeError(t, "bytes 1-0/2")
+	testParseContentRangeError(t, "bytes 0-2/2")
+	testParseContentRangeError(t, "bytes a-1/2")
+	testParseContentRangeError(t, "bytes 0-b/2")
+	testParseContentRangeError(t, "bytes 0-1/c")
+}
+
+func testParseContentRangeSuccess(t *testing.T, s string, expectedStartPos, expectedEndPos, expectedContentLength int) {
+	startPos, endPos, contentLength, err := parseContentRange([]byte(s))
+	if err != nil {
+		t.Fatalf("unexpected error: %s. s=%q", err, s)
+	}
+	if startPos != expectedStartPos || endPos != expectedEndPos || contentLength != expectedContentLength {
+		t.Fatalf("unexpected result for %q: %d-%d/%d. Expecting %d-%d/%d", s,
+			startPos, endPos, contentLength, expectedStartPos, expectedEndPos, expectedContentLength)
+	}
+}
+
+func testParseContentRangeError(t *testing.T, s string) {
+	if _, _, _, err := parseContentRange([]byte(s)); err == nil {
+		t.Fatalf("expecting error when parsing %q", s)
+	}
+}
+
+func newFileDownloaderTestClient(h RequestHandler) (*Client, func()) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: h,
+	}
+	go s.Serve(ln)
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	return c, func() { ln.Close() }
+}
+
+func TestFileDownloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasthttp-download")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	body := createFixedBody(1000)
+	checksum := sha256.Sum256(body)
+	var ranges []string
+	var requestsCount uint32
+	c, stop := newFileDownloaderTestClient(func(ctx *RequestCtx) {
+		if atomic.AddUint32(&requestsCount, 1) == 1 {
+			ctx.SetStatusCode(StatusServiceUnavailable)
+			return
+		}
+		ranges = append(ranges, string(ctx.Request.Header.Peek("Range")))
+		serveTestRange(ctx, body, `"foo"`)
+	})
+	defer stop()
+
+	// The download must be resumed from the partially downloaded file.
+	path := filepath.Join(dir, "foo")
+	if err := ioutil.WriteFile(path+".download", body[:300], 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	d := &FileDownloader{
+		Client:   c,
+		Checksum: checksum[:],
+	}
+	if err := d.Download("http://foobar/baz", path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=300-" {
+		t.Fatalf("unexpected ranges requested: %q. Expecting %q", ranges, []string{"bytes=300-"})
+	}
+	testFileDownloaderResult(t, path, body)
+
+	// The existing file must be replaced.
+	ranges = ranges[:0]
+	if err := c.DownloadFile("http://foobar/baz", path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(ranges) != 1 || ranges[0] != "" {
+		t.Fatalf("unexpected ranges requested: %q. Expecting %q", ranges, []string{""})
+	}
+	testFileDownloaderResult(t, path, body)
+}
+
+func TestFileDownloaderNoRangeSupport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasthttp-download")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	body := createFixedBody(1000)
+	c, stop := newFileDownloaderTestClient(func(ctx *RequestCtx) {
+		ctx.Write(body)
+	})
+	defer stop()
+
+	// Stale partially downloaded data must be discarded.
+	path := filepath.Join(dir, "foo")
+	if err := ioutil.WriteFile(path+".download", []byte("stale data"), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.DownloadFile("http://foobar/baz", path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testFileDownloaderResult(t, path, body)
+}
+
+func TestFileDownloaderChecksumMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasthttp-download")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, stop := newFileDownloaderTestClient(func(ctx *RequestCtx) {
+		ctx.WriteString("foobar")
+	})
+	defer stop()
+
+	path := filepath.Join(dir, "foo")
+	d := &FileDownloader{
+		Client:   c,
+		Checksum: []byte("invalid"),
+	}
+	if err := d.Download("http://foobar/baz", path); err != ErrDownloadChecksumMismatch {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrDownloadChecksumMismatch)
+	}
+	for _, p := range []string{path, path + ".download"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("unexpected file %q. Expecting it is missing: %v", p, err)
+		}
+	}
+}
+
+func testFileDownloaderResult(t *testing.T, path string, body []byte) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(data, body) {
+		t.Fatalf("unexpected file contents %q. Expecting %q", data, body)
+	}
+	if _, err := os.Stat(path + ".download"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected temporary file left: %v", err)
+	}
+}
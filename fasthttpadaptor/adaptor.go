@@ -1,5 +1,6 @@
 // Package fasthttpadaptor provides helper functions for converting net/http
-// request handlers to fasthttp request handlers.
+// request handlers to fasthttp request handlers and for converting
+// between net/http and fasthttp clients.
 package fasthttpadaptor
 
 import (
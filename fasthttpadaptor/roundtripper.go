@@ -0,0 +1,205 @@
+package fasthttpadaptor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Doer performs http requests.
+//
+// fasthttp.Client, fasthttp.HostClient and fasthttp.PipelineClient
+// implement Doer.
+type Doer interface {
+	Do(req *fasthttp.Request, resp *fasthttp.Response) error
+}
+
+type deadlineDoer interface {
+	DoDeadline(req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error
+}
+
+type contextDoer interface {
+	DoContext(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response) error
+}
+
+// NewRoundTripper wraps fasthttp client to http.RoundTripper,
+// so it can be used as http.Client.Transport.
+//
+// This allows libraries accepting only *http.Client to use fasthttp
+// connection pool. Request bodies are streamed to the server, while
+// response bodies are streamed from the server if the client supports
+// fasthttp.Response.StreamBody.
+//
+// Request context cancellation and deadline are respected if the client
+// implements DoContext. Only request context deadline is respected
+// if the client implements DoDeadline.
+func NewRoundTripper(c Doer) http.RoundTripper {
+	return &roundTripper{c: c}
+}
+
+type roundTripper struct {
+	c Doer
+}
+
+func (rt *roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+
+	req.Header.SetMethod(r.Method)
+	req.SetRequestURI(r.URL.String())
+	if r.Host != "" && r.Host != r.URL.Host {
+		req.Header.SetHost(r.Host)
+		req.UseHostHeader = true
+	}
+	for k, vv := range r.Header {
+		for _, v := range vv {
+			switch k {
+			case "Host", "Content-Length", "Transfer-Encoding", "Connection":
+				// These headers are managed by fasthttp.
+			case "Content-Type", "User-Agent", "Cookie":
+				req.Header.Set(k, v)
+			default:
+				req.Header.Add(k, v)
+			}
+		}
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		contentLength := int(r.ContentLength)
+		if contentLength <= 0 {
+			contentLength = -1
+		}
+		// The body is closed by ReleaseRequest.
+		req.SetBodyStream(r.Body, contentLength)
+	}
+	resp.StreamBody = true
+
+	var err error
+	ctx := r.Context()
+	deadline, ok := ctx.Deadline()
+	if cd, isContextDoer := rt.c.(contextDoer); isContextDoer {
+		err = cd.DoContext(ctx, req, resp)
+	} else if dd, isDeadlineDoer := rt.c.(deadlineDoer); ok && isDeadlineDoer {
+		err = dd.DoDeadline(req, resp, deadline)
+	} else {
+		err = rt.c.Do(req, resp)
+	}
+	fasthttp.ReleaseRequest(req)
+	if err != nil {
+		fasthttp.ReleaseResponse(resp)
+		return nil, err
+	}
+
+	hr := &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.StatusCode(), http.StatusText(resp.StatusCode())),
+		StatusCode:    resp.StatusCode(),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		ContentLength: -1,
+		Request:       r,
+	}
+	resp.Header.VisitAll(func(k, v []byte) {
+		hr.Header.Add(string(k), string(v))
+	})
+	hr.Header.Del("Content-Length")
+
+	var body io.Reader
+	if bs := resp.BodyStream(); bs != nil {
+		body = bs
+		if n := resp.Header.ContentLength(); n >= 0 {
+			hr.ContentLength = int64(n)
+		}
+	} else {
+		b := resp.Body()
+		body = bytes.NewReader(b)
+		hr.ContentLength = int64(len(b))
+	}
+	hr.Body = &responseBody{
+		r:    body,
+		resp: resp,
+	}
+	return hr, nil
+}
+
+// responseBody releases fasthttp response on Close.
+type responseBody struct {
+	r    io.Reader
+	resp *fasthttp.Response
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	if b.resp == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return b.r.Read(p)
+}
+
+func (b *responseBody) Close() error {
+	if b.resp == nil {
+		return nil
+	}
+	fasthttp.ReleaseResponse(b.resp)
+	b.resp = nil
+	return nil
+}
+
+// NewDoer wraps http.RoundTripper to Doer, so net/http transports
+// may be used via fasthttp request and response types.
+func NewDoer(rt http.RoundTripper) Doer {
+	return &netHTTPDoer{rt: rt}
+}
+
+type netHTTPDoer struct {
+	rt http.RoundTripper
+}
+
+func (d *netHTTPDoer) Do(req *fasthttp.Request, resp *fasthttp.Response) error {
+	body := req.Body()
+	r, err := http.NewRequest(string(req.Header.Method()), req.URI().String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.ContentLength = int64(len(body))
+	req.Header.VisitAll(func(k, v []byte) {
+		switch string(k) {
+		case "Host", "Content-Length", "Connection", "Transfer-Encoding":
+			// These headers are managed by net/http.
+		default:
+			r.Header.Add(string(k), string(v))
+		}
+	})
+
+	hr, err := d.rt.RoundTrip(r)
+	if err != nil {
+		return err
+	}
+	defer hr.Body.Close()
+
+	resp.Reset()
+	resp.SetStatusCode(hr.StatusCode)
+	for k, vv := range hr.Header {
+		for _, v := range vv {
+			switch k {
+			case "Content-Length", "Transfer-Encoding", "Connection":
+				// These headers are managed by fasthttp.
+			case "Content-Type", "Server", "Set-Cookie":
+				resp.Header.Set(k, v)
+			default:
+				resp.Header.Add(k, v)
+			}
+		}
+	}
+	b, err := ioutil.ReadAll(hr.Body)
+	if err != nil {
+		return err
+	}
+	resp.SetBody(b)
+	return nil
+}
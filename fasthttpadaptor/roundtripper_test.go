@@ -0,0 +1,207 @@
+package fasthttpadaptor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestRoundTripper(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.Response.Header.Set("X-Foo", string(ctx.Request.Header.Peek("X-Foo")))
+			ctx.SetContentType("text/plain")
+			fmt.Fprintf(ctx, "%s %s %s %s", ctx.Method(), ctx.RequestURI(), ctx.Request.Header.ContentType(), ctx.PostBody())
+		},
+	}
+	serverStopCh := make(chan struct{})
+	go func() {
+		if err := s.Serve(ln); err != nil {
+			t.Errorf("unexpected error: %s", err)
+			return
+		}
+		close(serverStopCh)
+	}()
+
+	c := &http.Client{
+		Transport: NewRoundTripper(&fasthttp.HostClient{
+			Addr: "foobar",
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		}),
+		Timeout: time.Second,
+	}
+	for i := 0; i < 5; i++ {
+		req, err := http.NewRequest("POST", fmt.Sprintf("http://foobar/foo?n=%d", i), strings.NewReader("body"))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		req.Header.Set("Content-Type", "text/foo")
+		req.Header.Set("X-Foo", "bar")
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode, http.StatusOK)
+		}
+		expectedBody := fmt.Sprintf("POST /foo?n=%d text/foo body", i)
+		if string(body) != expectedBody {
+			t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+		}
+		if resp.ContentLength != int64(len(expectedBody)) {
+			t.Fatalf("unexpected content length %d. Expecting %d", resp.ContentLength, len(expectedBody))
+		}
+		if resp.Header.Get("X-Foo") != "bar" {
+			t.Fatalf("unexpected X-Foo header %q. Expecting %q", resp.Header.Get("X-Foo"), "bar")
+		}
+		if resp.Header.Get("Content-Type") != "text/plain" {
+			t.Fatalf("unexpected content type %q. Expecting %q", resp.Header.Get("Content-Type"), "text/plain")
+		}
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-serverStopCh:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestRoundTripperHostOverride(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.Write(ctx.Host()) //nolint:errcheck
+		},
+	}
+	go s.Serve(ln) //nolint:errcheck
+	defer ln.Close()
+
+	c := &http.Client{
+		Transport: NewRoundTripper(&fasthttp.HostClient{
+			Addr: "foobar",
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		}),
+		Timeout: time.Second,
+	}
+	testHost := func(host, expectedHost string) {
+		t.Helper()
+		req, err := http.NewRequest("GET", "http://foobar/", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		req.Host = host
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		resp.Body.Close()
+		if string(body) != expectedHost {
+			t.Fatalf("unexpected host %q. Expecting %q", body, expectedHost)
+		}
+	}
+	testHost("", "foobar")
+	testHost("foobar", "foobar")
+	testHost("example.com", "example.com")
+}
+
+func TestRoundTripperContextCancel(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			time.Sleep(300 * time.Millisecond)
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	c := &http.Client{
+		Transport: NewRoundTripper(&fasthttp.HostClient{
+			Addr: "foobar",
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://foobar/", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	startTime := time.Now()
+	_, err = c.Do(req)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
+	}
+	if d := time.Since(startTime); d > 250*time.Millisecond {
+		t.Fatalf("too long request duration: %s", d)
+	}
+}
+
+func TestDoer(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		w.Header().Set("X-Foo", r.Header.Get("X-Foo"))
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.RequestURI(), body)
+	}))
+	defer s.Close()
+
+	d := NewDoer(http.DefaultTransport)
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	req.SetRequestURI(s.URL + "/foo?bar=baz")
+	req.Header.SetMethod("PUT")
+	req.Header.Set("X-Foo", "xxx")
+	req.SetBodyString("body")
+	if err := d.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != fasthttp.StatusCreated {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), fasthttp.StatusCreated)
+	}
+	expectedBody := "PUT /foo?bar=baz body"
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	if string(resp.Header.Peek("X-Foo")) != "xxx" {
+		t.Fatalf("unexpected X-Foo header %q. Expecting %q", resp.Header.Peek("X-Foo"), "xxx")
+	}
+	if string(resp.Header.ContentType()) != "text/plain" {
+		t.Fatalf("unexpected content type %q. Expecting %q", resp.Header.ContentType(), "text/plain")
+	}
+	fasthttp.ReleaseRequest(req)
+	fasthttp.ReleaseResponse(resp)
+}
@@ -2,16 +2,21 @@ package fasthttp
 
 import (
 	"bytes"
+	"container/list"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"html"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -198,6 +203,28 @@ type FS struct {
 	// Path to the root directory to serve files from.
 	Root string
 
+	// Filesystem to serve files from.
+	//
+	// Root is the path to the root directory inside FS if FS is set.
+	// This allows serving files embedded into the binary with embed.FS:
+	//
+	//     //go:embed static
+	//     var staticFiles embed.FS
+	//
+	//     fs := &fasthttp.FS{
+	//         FS:   staticFiles,
+	//         Root: "static",
+	//     }
+	//
+	// Files opened via FS must implement io.ReaderAt and io.Seeker.
+	// Files from embed.FS and os.DirFS implement these interfaces.
+	//
+	// Transparently compressed files are kept in memory, since they
+	// cannot be saved to FS.
+	//
+	// By default files are served from the local filesystem.
+	FS fs.FS
+
 	// List of index file names to try opening during directory access.
 	//
 	// For example:
@@ -233,9 +260,33 @@ type FS struct {
 
 	// Enables byte range requests if set to true.
 	//
+	// 'If-Range' request header is taken into account, so the whole file
+	// is sent if the file has been changed since the client obtained
+	// the given validator.
+	//
 	// Byte range requests are disabled by default.
 	AcceptByteRange bool
 
+	// Serves precompressed files located near the original files
+	// if set to true.
+	//
+	// The file with '.br' suffix is sent instead of the original file
+	// if the client accepts brotli encoding, while the file with '.gz'
+	// suffix is sent if the client accepts gzip encoding.
+	// Precompressed files older than the original file are ignored.
+	//
+	// Precompressed files aren't served by default.
+	UsePrecompressedFiles bool
+
+	// Generates strong ETags from file contents if set to true.
+	//
+	// Strong ETags require reading the whole file when it is opened,
+	// but they allow clients resuming downloads with 'If-Range: <etag>'.
+	//
+	// By default weak ETags are generated from file size
+	// and modification time.
+	StrongETag bool
+
 	// Path rewriting function.
 	//
 	// By default request path is not modified.
@@ -243,9 +294,32 @@ type FS struct {
 
 	// Expiration duration for inactive file handlers.
 	//
+	// File contents cached in memory expire after CacheDuration too,
+	// so file changes become visible after this duration.
+	//
 	// FSHandlerCacheDuration is used by default.
 	CacheDuration time.Duration
 
+	// The maximum total size of file contents cached in memory.
+	//
+	// The least recently used files are evicted from the cache
+	// when the limit is exceeded.
+	//
+	// FSCacheMaxBytes is used by default.
+	CacheMaxBytes int
+
+	// The maximum size of the file, which contents may be cached in memory.
+	//
+	// Bigger files are sent directly from the filesystem, so they
+	// don't occupy memory. Set CacheMaxFileSize to negative value
+	// in order to disable caching file contents in memory.
+	//
+	// Directory index pages and files compressed in memory are always
+	// cached in memory, but they are accounted in CacheMaxBytes.
+	//
+	// FSCacheMaxFileSize is used by default.
+	CacheMaxFileSize int
+
 	// Suffix to add to the name of cached compressed file.
 	//
 	// This value has sense only if Compress is set.
@@ -253,8 +327,35 @@ type FS struct {
 	// FSCompressedFileSuffix is used by default.
 	CompressedFileSuffix string
 
-	once sync.Once
-	h    RequestHandler
+	once    sync.Once
+	h       RequestHandler
+	handler *fsHandler
+}
+
+// FSCacheStats contains statistics for files cached by FS.
+type FSCacheStats struct {
+	// The number of requests served from the cache.
+	Hits uint64
+
+	// The number of requests, which required opening files.
+	Misses uint64
+
+	// The number of files evicted from the cache due to FS.CacheMaxBytes
+	// limit.
+	Evictions uint64
+
+	// The number of cached files.
+	Files int
+
+	// The total size of file contents cached in memory.
+	Bytes int
+}
+
+// CacheStats returns statistics for files cached by the request handler
+// returned from NewRequestHandler.
+func (fs *FS) CacheStats() FSCacheStats {
+	fs.once.Do(fs.initRequestHandler)
+	return fs.handler.cacheStats()
 }
 
 // FSCompressedFileSuffix is the suffix FS adds to the original file names
@@ -266,6 +367,14 @@ const FSCompressedFileSuffix = ".fasthttp.gz"
 // file handlers opened by FS.
 const FSHandlerCacheDuration = 10 * time.Second
 
+// FSCacheMaxBytes is the default maximum total size of file contents
+// cached in memory by FS.
+const FSCacheMaxBytes = 64 * 1024 * 1024
+
+// FSCacheMaxFileSize is the default maximum size of the file, which
+// contents may be cached in memory by FS.
+const FSCacheMaxFileSize = 64 * 1024
+
 // FSHandler returns request handler serving static files from
 // the given root folder.
 //
@@ -336,18 +445,33 @@ func (fs *FS) initRequestHandler() {
 	if len(compressedFileSuffix) == 0 {
 		compressedFileSuffix = FSCompressedFileSuffix
 	}
+	cacheMaxBytes := fs.CacheMaxBytes
+	if cacheMaxBytes <= 0 {
+		cacheMaxBytes = FSCacheMaxBytes
+	}
+	cacheMaxFileSize := fs.CacheMaxFileSize
+	if cacheMaxFileSize == 0 {
+		cacheMaxFileSize = FSCacheMaxFileSize
+	}
 
 	h := &fsHandler{
-		root:                 root,
-		indexNames:           fs.IndexNames,
-		pathRewrite:          fs.PathRewrite,
-		generateIndexPages:   fs.GenerateIndexPages,
-		compress:             fs.Compress,
-		acceptByteRange:      fs.AcceptByteRange,
-		cacheDuration:        cacheDuration,
-		compressedFileSuffix: compressedFileSuffix,
-		cache:                make(map[string]*fsFile),
-		compressedCache:      make(map[string]*fsFile),
+		filesystem:            fs.FS,
+		root:                  root,
+		indexNames:            fs.IndexNames,
+		pathRewrite:           fs.PathRewrite,
+		generateIndexPages:    fs.GenerateIndexPages,
+		compress:              fs.Compress,
+		acceptByteRange:       fs.AcceptByteRange,
+		usePrecompressedFiles: fs.UsePrecompressedFiles,
+		strongETag:            fs.StrongETag,
+		cacheDuration:         cacheDuration,
+		cacheMaxBytes:         cacheMaxBytes,
+		cacheMaxFileSize:      cacheMaxFileSize,
+		compressedFileSuffix:  compressedFileSuffix,
+		cache:                 make(map[string]*fsFile),
+		compressedCache:       make(map[string]*fsFile),
+		brotliCache:           make(map[string]*fsFile),
+		brotliOnlyCache:       make(map[string]*fsFile),
 	}
 
 	go func() {
@@ -359,39 +483,72 @@ func (fs *FS) initRequestHandler() {
 	}()
 
 	fs.h = h.handleRequest
+	fs.handler = h
 }
 
 type fsHandler struct {
-	root                 string
-	indexNames           []string
-	pathRewrite          PathRewriteFunc
-	generateIndexPages   bool
-	compress             bool
-	acceptByteRange      bool
-	cacheDuration        time.Duration
-	compressedFileSuffix string
+	filesystem            fs.FS
+	root                  string
+	indexNames            []string
+	pathRewrite           PathRewriteFunc
+	generateIndexPages    bool
+	compress              bool
+	acceptByteRange       bool
+	usePrecompressedFiles bool
+	strongETag            bool
+	cacheDuration         time.Duration
+	cacheMaxBytes         int
+	cacheMaxFileSize      int
+	compressedFileSuffix  string
 
 	cache           map[string]*fsFile
 	compressedCache map[string]*fsFile
+	brotliCache     map[string]*fsFile
 	cacheLock       sync.Mutex
 
+	// brotliOnlyCache contains files for clients accepting br, but not gzip.
+	brotliOnlyCache map[string]*fsFile
+
+	// lru contains cached in-memory files ordered by access time.
+	lru        list.List
+	cacheBytes int
+
+	cacheHits      uint64
+	cacheMisses    uint64
+	cacheEvictions uint64
+
 	smallFileReaderPool sync.Pool
 }
 
 type fsFile struct {
-	h             *fsHandler
-	f             *os.File
-	dirIndex      []byte
+	h        *fsHandler
+	f        fsFileHandle
+	filePath string
+
+	// data contains contents for in-memory files such as directory
+	// index pages.
+	data []byte
+
 	contentType   string
 	contentLength int
 	compressed    bool
 
+	// contentEncoding is set only for compressed files.
+	contentEncoding []byte
+
 	lastModified    time.Time
 	lastModifiedStr []byte
+	etag            []byte
 
 	t            time.Time
 	readersCount int
 
+	// cache, cacheKey and lruElement are set for in-memory files
+	// stored in the cache.
+	cache      map[string]*fsFile
+	cacheKey   string
+	lruElement *list.Element
+
 	bigFiles     []*bigFileReader
 	bigFilesLock sync.Mutex
 }
@@ -425,7 +582,7 @@ func (ff *fsFile) smallFileReader() io.Reader {
 const maxSmallFileSize = 2 * 4096
 
 func (ff *fsFile) isBig() bool {
-	return ff.contentLength > maxSmallFileSize && len(ff.dirIndex) == 0
+	return ff.contentLength > maxSmallFileSize && len(ff.data) == 0
 }
 
 func (ff *fsFile) bigFileReader() (io.Reader, error) {
@@ -447,7 +604,7 @@ func (ff *fsFile) bigFileReader() (io.Reader, error) {
 		return r, nil
 	}
 
-	f, err := os.Open(ff.f.Name())
+	f, err := ff.h.openFile(ff.filePath)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open already opened file: %s", err)
 	}
@@ -484,7 +641,7 @@ func (ff *fsFile) decReadersCount() {
 // bigFileReader attempts to trigger sendfile
 // for sending big files over the wire.
 type bigFileReader struct {
-	f  *os.File
+	f  fsFileHandle
 	ff *fsFile
 	r  io.Reader
 	lr io.LimitedReader
@@ -571,7 +728,7 @@ func (r *fsSmallFileReader) Read(p []byte) (int, error) {
 		return n, err
 	}
 
-	n := copy(p, ff.dirIndex[r.startPos:])
+	n := copy(p, ff.data[r.startPos:])
 	r.startPos += n
 	return n, nil
 }
@@ -582,7 +739,7 @@ func (r *fsSmallFileReader) WriteTo(w io.Writer) (int64, error) {
 	var n int
 	var err error
 	if ff.f == nil {
-		n, err = w.Write(ff.dirIndex[r.startPos:r.endPos])
+		n, err = w.Write(ff.data[r.startPos:r.endPos])
 		return int64(n), err
 	}
 
@@ -636,8 +793,10 @@ func (h *fsHandler) cleanCache(pendingFiles []*fsFile) []*fsFile {
 	}
 	pendingFiles = remainingFiles
 
-	pendingFiles, filesToRelease = cleanCacheNolock(h.cache, pendingFiles, filesToRelease, h.cacheDuration)
-	pendingFiles, filesToRelease = cleanCacheNolock(h.compressedCache, pendingFiles, filesToRelease, h.cacheDuration)
+	pendingFiles, filesToRelease = h.cleanCacheNolock(h.cache, pendingFiles, filesToRelease)
+	pendingFiles, filesToRelease = h.cleanCacheNolock(h.compressedCache, pendingFiles, filesToRelease)
+	pendingFiles, filesToRelease = h.cleanCacheNolock(h.brotliCache, pendingFiles, filesToRelease)
+	pendingFiles, filesToRelease = h.cleanCacheNolock(h.brotliOnlyCache, pendingFiles, filesToRelease)
 
 	h.cacheLock.Unlock()
 
@@ -648,10 +807,10 @@ func (h *fsHandler) cleanCache(pendingFiles []*fsFile) []*fsFile {
 	return pendingFiles
 }
 
-func cleanCacheNolock(cache map[string]*fsFile, pendingFiles, filesToRelease []*fsFile, cacheDuration time.Duration) ([]*fsFile, []*fsFile) {
+func (h *fsHandler) cleanCacheNolock(cache map[string]*fsFile, pendingFiles, filesToRelease []*fsFile) ([]*fsFile, []*fsFile) {
 	t := time.Now()
 	for k, ff := range cache {
-		if t.Sub(ff.t) > cacheDuration {
+		if t.Sub(ff.t) > h.cacheDuration {
 			if ff.readersCount > 0 {
 				// There are pending readers on stale file handle,
 				// so we cannot close it. Put it into pendingFiles
@@ -661,11 +820,59 @@ func cleanCacheNolock(cache map[string]*fsFile, pendingFiles, filesToRelease []*
 				filesToRelease = append(filesToRelease, ff)
 			}
 			delete(cache, k)
+			h.removeFromLRUNolock(ff)
 		}
 	}
 	return pendingFiles, filesToRelease
 }
 
+// addToCacheNolock stores ff in the cache under the given key.
+//
+// The least recently used in-memory files are evicted from the cache
+// if the total size of cached file contents exceeds h.cacheMaxBytes.
+func (h *fsHandler) addToCacheNolock(cache map[string]*fsFile, key string, ff *fsFile) {
+	cache[key] = ff
+	if len(ff.data) == 0 {
+		return
+	}
+
+	ff.cache = cache
+	ff.cacheKey = key
+	ff.lruElement = h.lru.PushFront(ff)
+	h.cacheBytes += len(ff.data)
+	for h.cacheBytes > h.cacheMaxBytes {
+		// There is no need in releasing evicted in-memory files,
+		// since they don't hold file handles. Pending readers
+		// continue reading file contents until they are done.
+		ffOld := h.lru.Back().Value.(*fsFile)
+		delete(ffOld.cache, ffOld.cacheKey)
+		h.removeFromLRUNolock(ffOld)
+		h.cacheEvictions++
+	}
+}
+
+func (h *fsHandler) removeFromLRUNolock(ff *fsFile) {
+	if ff.lruElement == nil {
+		return
+	}
+	h.lru.Remove(ff.lruElement)
+	ff.lruElement = nil
+	h.cacheBytes -= len(ff.data)
+}
+
+func (h *fsHandler) cacheStats() FSCacheStats {
+	h.cacheLock.Lock()
+	stats := FSCacheStats{
+		Hits:      h.cacheHits,
+		Misses:    h.cacheMisses,
+		Evictions: h.cacheEvictions,
+		Files:     len(h.cache) + len(h.compressedCache) + len(h.brotliCache) + len(h.brotliOnlyCache),
+		Bytes:     h.cacheBytes,
+	}
+	h.cacheLock.Unlock()
+	return stats
+}
+
 func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 	var path []byte
 	if h.pathRewrite != nil {
@@ -691,18 +898,33 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 		}
 	}
 
-	mustCompress := false
+	var encoding []byte
+	var acceptGzip bool
 	fileCache := h.cache
 	byteRange := ctx.Request.Header.peek(strRange)
-	if len(byteRange) == 0 && h.compress && ctx.Request.Header.HasAcceptEncodingBytes(strGzip) {
-		mustCompress = true
-		fileCache = h.compressedCache
+	if len(byteRange) == 0 {
+		encoding, acceptGzip = h.fileEncoding(ctx)
+		if encoding != nil {
+			fileCache = h.compressedCache
+			if bytes.Equal(encoding, strBr) {
+				fileCache = h.brotliCache
+				if !acceptGzip {
+					fileCache = h.brotliOnlyCache
+				}
+			}
+		}
 	}
 
 	h.cacheLock.Lock()
 	ff, ok := fileCache[string(path)]
 	if ok {
 		ff.readersCount++
+		if ff.lruElement != nil {
+			h.lru.MoveToFront(ff.lruElement)
+		}
+		h.cacheHits++
+	} else {
+		h.cacheMisses++
 	}
 	h.cacheLock.Unlock()
 
@@ -710,15 +932,15 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 		pathStr := string(path)
 		filePath := h.root + pathStr
 		var err error
-		ff, err = h.openFSFile(filePath, mustCompress)
-		if mustCompress && err == errNoCreatePermission {
+		ff, err = h.openEncodedFSFile(filePath, encoding, acceptGzip)
+		if encoding != nil && err == errNoCreatePermission {
 			ctx.Logger().Printf("insufficient permissions for saving compressed file for %q. Serving uncompressed file. "+
 				"Allow write access to the directory with this file in order to improve fasthttp performance", filePath)
-			mustCompress = false
-			ff, err = h.openFSFile(filePath, mustCompress)
+			encoding = nil
+			ff, err = h.openFSFile(filePath, false)
 		}
 		if err == errDirIndexRequired {
-			ff, err = h.openIndexFile(ctx, filePath, mustCompress)
+			ff, err = h.openIndexFile(ctx, filePath, encoding, acceptGzip)
 			if err != nil {
 				ctx.Logger().Printf("cannot open dir index %q: %s", filePath, err)
 				ctx.Error("Directory index is forbidden", StatusForbidden)
@@ -733,7 +955,7 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 		h.cacheLock.Lock()
 		ff1, ok := fileCache[pathStr]
 		if !ok {
-			fileCache[pathStr] = ff
+			h.addToCacheNolock(fileCache, pathStr, ff)
 			ff.readersCount++
 		} else {
 			ff1.readersCount++
@@ -749,9 +971,15 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 		}
 	}
 
-	if !ctx.IfModifiedSince(ff.lastModified) {
+	switch ff.checkPreconditions(ctx) {
+	case StatusNotModified:
 		ff.decReadersCount()
 		ctx.NotModified()
+		ctx.Response.Header.SetCanonical(strETag, ff.etag)
+		return
+	case StatusPreconditionFailed:
+		ff.decReadersCount()
+		ctx.Error("Precondition Failed", StatusPreconditionFailed)
 		return
 	}
 
@@ -764,14 +992,17 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 
 	hdr := &ctx.Response.Header
 	if ff.compressed {
-		hdr.SetCanonical(strContentEncoding, strGzip)
+		hdr.SetCanonical(strContentEncoding, ff.contentEncoding)
+	}
+	if h.compress || h.usePrecompressedFiles {
+		hdr.addVaryValue(strAcceptEncoding)
 	}
 
 	statusCode := StatusOK
 	contentLength := ff.contentLength
 	if h.acceptByteRange {
 		hdr.SetCanonical(strAcceptRanges, strBytes)
-		if len(byteRange) > 0 {
+		if len(byteRange) > 0 && ff.ifRangeMatch(ctx.Request.Header.peek(strIfRange)) {
 			startPos, endPos, err := ParseByteRange(byteRange, contentLength)
 			if err != nil {
 				r.(io.Closer).Close()
@@ -794,6 +1025,7 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 	}
 
 	hdr.SetCanonical(strLastModified, ff.lastModifiedStr)
+	hdr.SetCanonical(strETag, ff.etag)
 	if !ctx.IsHead() {
 		ctx.SetBodyStream(r, contentLength)
 	} else {
@@ -816,6 +1048,134 @@ type byteRangeUpdater interface {
 	UpdateByteRange(startPos, endPos int) error
 }
 
+// fileEncoding returns the encoding for the file sent to the client
+// and whether the client accepts gzip.
+//
+// nil is returned if the file must be sent uncompressed.
+//
+// Brotli is chosen regardless of gzip support. The file without
+// precompressed brotli version is sent gzip-compressed to clients
+// accepting gzip and uncompressed to other clients.
+func (h *fsHandler) fileEncoding(ctx *RequestCtx) ([]byte, bool) {
+	if !h.compress && !h.usePrecompressedFiles {
+		return nil, false
+	}
+	ae := ctx.Request.Header.peek(strAcceptEncoding)
+	acceptGzip := acceptEncodingQuality(ae, strGzip) > 0
+	if h.usePrecompressedFiles && acceptEncodingQuality(ae, strBr) > 0 {
+		return strBr, acceptGzip
+	}
+	if acceptGzip {
+		return strGzip, true
+	}
+	return nil, false
+}
+
+// checkPreconditions evaluates conditional request headers
+// according to https://tools.ietf.org/html/rfc7232#section-6 .
+//
+// StatusOK is returned if the file must be sent to the client.
+func (ff *fsFile) checkPreconditions(ctx *RequestCtx) int {
+	h := &ctx.Request.Header
+	if ifMatch := h.peek(strIfMatch); len(ifMatch) > 0 {
+		if !etagMatch(ifMatch, ff.etag, false) {
+			return StatusPreconditionFailed
+		}
+	} else if ifUnmodStr := h.peek(strIfUnmodifiedSince); len(ifUnmodStr) > 0 {
+		ifUnmod, err := ParseHTTPDate(ifUnmodStr)
+		if err == nil && ff.lastModified.Truncate(time.Second).After(ifUnmod) {
+			return StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := h.peek(strIfNoneMatch); len(ifNoneMatch) > 0 {
+		// If-Modified-Since must be ignored if If-None-Match is present.
+		if etagMatch(ifNoneMatch, ff.etag, true) {
+			return StatusNotModified
+		}
+		return StatusOK
+	}
+	if !ctx.IfModifiedSince(ff.lastModified) {
+		return StatusNotModified
+	}
+	return StatusOK
+}
+
+// ifRangeMatch returns true if the byte range must be sent
+// for the given 'If-Range' header value.
+//
+// The whole file must be sent if the validator from 'If-Range' doesn't
+// match the file. Weak ETags never match.
+func (ff *fsFile) ifRangeMatch(ifRange []byte) bool {
+	if len(ifRange) == 0 {
+		return true
+	}
+	if ifRange[0] == '"' || bytes.HasPrefix(ifRange, strWeakETagPrefix) {
+		return etagMatch(ifRange, ff.etag, false)
+	}
+	t, err := ParseHTTPDate(ifRange)
+	if err != nil {
+		return false
+	}
+	return t.Equal(ff.lastModified.Truncate(time.Second))
+}
+
+// etagMatch returns true if etag matches any entity tag from the given
+// 'If-Match' or 'If-None-Match' header value.
+//
+// Weak comparison is used if weak is set, otherwise strong comparison
+// is used. See https://tools.ietf.org/html/rfc7232#section-2.3.2 .
+func etagMatch(list, etag []byte, weak bool) bool {
+	weakETag := bytes.HasPrefix(etag, strWeakETagPrefix)
+	if weakETag {
+		etag = etag[len(strWeakETagPrefix):]
+	}
+
+	var vs headerValueScanner
+	vs.b = list
+	for vs.next() {
+		v := vs.value
+		if bytes.Equal(v, strStar) {
+			return true
+		}
+		if weakETag && !weak {
+			continue
+		}
+		if bytes.HasPrefix(v, strWeakETagPrefix) {
+			if !weak {
+				continue
+			}
+			v = v[len(strWeakETagPrefix):]
+		}
+		if bytes.Equal(v, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// newETag returns ETag for the file with the given contents.
+//
+// r is read only if strong ETags must be generated.
+func (h *fsHandler) newETag(r io.Reader, contentLength int, lastModified time.Time) ([]byte, error) {
+	if !h.strongETag {
+		etag := append([]byte(nil), strWeakETagPrefix...)
+		etag = append(etag, '"')
+		etag = strconv.AppendInt(etag, lastModified.Unix(), 16)
+		etag = append(etag, '-')
+		etag = strconv.AppendInt(etag, int64(contentLength), 16)
+		return append(etag, '"'), nil
+	}
+
+	hash := fnv.New64a()
+	if _, err := copyZeroAlloc(hash, r); err != nil {
+		return nil, err
+	}
+	etag := []byte{'"'}
+	etag = strconv.AppendUint(etag, hash.Sum64(), 16)
+	return append(etag, '"'), nil
+}
+
 // ParseByteRange parses 'Range: bytes=...' header value.
 //
 // It follows https://www.w3.org/Protocols/rfc2616/rfc2616-sec14.html#sec14.35 .
@@ -872,10 +1232,10 @@ func ParseByteRange(byteRange []byte, contentLength int) (startPos, endPos int,
 	return startPos, endPos, nil
 }
 
-func (h *fsHandler) openIndexFile(ctx *RequestCtx, dirPath string, mustCompress bool) (*fsFile, error) {
+func (h *fsHandler) openIndexFile(ctx *RequestCtx, dirPath string, encoding []byte, acceptGzip bool) (*fsFile, error) {
 	for _, indexName := range h.indexNames {
 		indexFilePath := dirPath + "/" + indexName
-		ff, err := h.openFSFile(indexFilePath, mustCompress)
+		ff, err := h.openEncodedFSFile(indexFilePath, encoding, acceptGzip)
 		if err == nil {
 			return ff, nil
 		}
@@ -888,12 +1248,13 @@ func (h *fsHandler) openIndexFile(ctx *RequestCtx, dirPath string, mustCompress
 		return nil, fmt.Errorf("cannot access directory without index page. Directory %q", dirPath)
 	}
 
-	return h.createDirIndex(ctx.URI(), dirPath, mustCompress)
+	return h.createDirIndex(ctx.URI(), dirPath, h.compress && acceptGzip)
 }
 
 var (
-	errDirIndexRequired   = errors.New("directory index required")
-	errNoCreatePermission = errors.New("no 'create file' permissions")
+	errDirIndexRequired    = errors.New("directory index required")
+	errNoCreatePermission  = errors.New("no 'create file' permissions")
+	errNoPrecompressedFile = errors.New("no precompressed file")
 )
 
 func (h *fsHandler) createDirIndex(base *URI, dirPath string, mustCompress bool) (*fsFile, error) {
@@ -912,25 +1273,23 @@ func (h *fsHandler) createDirIndex(base *URI, dirPath string, mustCompress bool)
 		fmt.Fprintf(w, `<li><a href="%s" class="dir">..</a></li>`, parentPathEscaped)
 	}
 
-	f, err := os.Open(dirPath)
+	entries, err := h.readDir(dirPath)
 	if err != nil {
 		return nil, err
 	}
 
-	fileinfos, err := f.Readdir(0)
-	f.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	fm := make(map[string]os.FileInfo, len(fileinfos))
+	fm := make(map[string]fs.FileInfo, len(entries))
 	var filenames []string
-	for _, fi := range fileinfos {
-		name := fi.Name()
+	for _, entry := range entries {
+		name := entry.Name()
 		if strings.HasSuffix(name, h.compressedFileSuffix) {
 			// Do not show compressed files on index page.
 			continue
 		}
+		fi, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
 		fm[name] = fi
 		filenames = append(filenames, name)
 	}
@@ -962,18 +1321,33 @@ func (h *fsHandler) createDirIndex(base *URI, dirPath string, mustCompress bool)
 		w = &zbuf
 	}
 
-	dirIndex := w.B
-	lastModified := time.Now()
+	var contentEncoding []byte
+	if mustCompress {
+		contentEncoding = strGzip
+	}
+	return h.newMemFSFile(w.B, "text/html; charset=utf-8", contentEncoding, time.Now())
+}
+
+// newMemFSFile returns in-memory fsFile with the given contents.
+//
+// The contents are compressed with contentEncoding if it is non-nil.
+func (h *fsHandler) newMemFSFile(data []byte, contentType string, contentEncoding []byte, lastModified time.Time) (*fsFile, error) {
+	etag, err := h.newETag(bytes.NewReader(data), len(data), lastModified)
+	if err != nil {
+		return nil, err
+	}
 	ff := &fsFile{
 		h:               h,
-		dirIndex:        dirIndex,
-		contentType:     "text/html; charset=utf-8",
-		contentLength:   len(dirIndex),
-		compressed:      mustCompress,
+		data:            data,
+		contentType:     contentType,
+		contentLength:   len(data),
+		compressed:      contentEncoding != nil,
+		contentEncoding: contentEncoding,
 		lastModified:    lastModified,
 		lastModifiedStr: AppendHTTPDate(nil, lastModified),
+		etag:            etag,
 
-		t: lastModified,
+		t: time.Now(),
 	}
 	return ff, nil
 }
@@ -984,7 +1358,7 @@ const (
 )
 
 func (h *fsHandler) compressAndOpenFSFile(filePath string) (*fsFile, error) {
-	f, err := os.Open(filePath)
+	f, err := h.openFile(filePath)
 	if err != nil {
 		return nil, err
 	}
@@ -1003,7 +1377,13 @@ func (h *fsHandler) compressAndOpenFSFile(filePath string) (*fsFile, error) {
 	if strings.HasSuffix(filePath, h.compressedFileSuffix) ||
 		fileInfo.Size() > fsMaxCompressibleFileSize ||
 		!isFileCompressible(f, fsMinCompressRatio) {
-		return h.newFSFile(f, fileInfo, false)
+		return h.newFSFile(f, fileInfo, filePath, false)
+	}
+
+	if h.filesystem != nil {
+		// The compressed file cannot be saved to h.filesystem,
+		// so keep it in memory.
+		return h.compressFileInMemory(f, fileInfo, filePath)
 	}
 
 	compressedFilePath := filePath + h.compressedFileSuffix
@@ -1021,7 +1401,28 @@ func (h *fsHandler) compressAndOpenFSFile(filePath string) (*fsFile, error) {
 	return ff, err
 }
 
-func (h *fsHandler) compressFileNolock(f *os.File, fileInfo os.FileInfo, filePath, compressedFilePath string) (*fsFile, error) {
+func (h *fsHandler) compressFileInMemory(f fsFileHandle, fileInfo fs.FileInfo, filePath string) (*fsFile, error) {
+	contentType, err := h.fileContentType(f, filePath, false)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	var w ByteBuffer
+	zw := acquireStacklessGzipWriter(&w, CompressDefaultCompression)
+	_, err = copyZeroAlloc(zw, f)
+	if err1 := zw.Flush(); err == nil {
+		err = err1
+	}
+	releaseStacklessGzipWriter(zw, CompressDefaultCompression)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error when compressing file %q: %s", filePath, err)
+	}
+	return h.newMemFSFile(w.B, contentType, strGzip, fileInfo.ModTime())
+}
+
+func (h *fsHandler) compressFileNolock(f fsFileHandle, fileInfo fs.FileInfo, filePath, compressedFilePath string) (*fsFile, error) {
 	// Attempt to open compressed file created by another concurrent
 	// goroutine.
 	// It is safe opening such a file, since the file creation
@@ -1065,7 +1466,7 @@ func (h *fsHandler) compressFileNolock(f *os.File, fileInfo os.FileInfo, filePat
 }
 
 func (h *fsHandler) newCompressedFSFile(filePath string) (*fsFile, error) {
-	f, err := os.Open(filePath)
+	f, err := h.openFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open compressed file %q: %s", filePath, err)
 	}
@@ -1074,7 +1475,89 @@ func (h *fsHandler) newCompressedFSFile(filePath string) (*fsFile, error) {
 		f.Close()
 		return nil, fmt.Errorf("cannot obtain info for compressed file %q: %s", filePath, err)
 	}
-	return h.newFSFile(f, fileInfo, true)
+	return h.newFSFile(f, fileInfo, filePath, true)
+}
+
+// openEncodedFSFile opens the file for sending with the given encoding.
+//
+// The returned file may be sent with gzip encoding if acceptGzip is set
+// or uncompressed if it cannot be sent with the given encoding.
+func (h *fsHandler) openEncodedFSFile(filePath string, encoding []byte, acceptGzip bool) (*fsFile, error) {
+	if encoding == nil {
+		return h.openFSFile(filePath, false)
+	}
+	if h.usePrecompressedFiles {
+		if bytes.Equal(encoding, strBr) {
+			ff, err := h.openPrecompressedFSFile(filePath, fsBrotliFileSuffix, strBr)
+			if err != errNoPrecompressedFile {
+				return ff, err
+			}
+			if !acceptGzip {
+				return h.openFSFile(filePath, false)
+			}
+		}
+		ff, err := h.openPrecompressedFSFile(filePath, fsGzipFileSuffix, strGzip)
+		if err != errNoPrecompressedFile {
+			return ff, err
+		}
+	}
+	return h.openFSFile(filePath, h.compress)
+}
+
+const (
+	fsBrotliFileSuffix = ".br"
+	fsGzipFileSuffix   = ".gz"
+)
+
+// openPrecompressedFSFile opens the file located near filePath
+// and containing filePath contents compressed with the given encoding.
+//
+// errNoPrecompressedFile is returned if there is no up-to-date
+// precompressed file.
+func (h *fsHandler) openPrecompressedFSFile(filePath, suffix string, encoding []byte) (*fsFile, error) {
+	fileInfoOriginal, err := h.stat(filePath)
+	if err != nil || fileInfoOriginal.IsDir() {
+		// Let the caller deal with missing files and directories.
+		return nil, errNoPrecompressedFile
+	}
+
+	compressedFilePath := filePath + suffix
+	f, err := h.openFile(compressedFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errNoPrecompressedFile
+		}
+		return nil, err
+	}
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot obtain info for precompressed file %q: %s", compressedFilePath, err)
+	}
+	if fileInfo.IsDir() || fileInfo.ModTime().Before(fileInfoOriginal.ModTime()) {
+		// Stale precompressed file must be ignored.
+		f.Close()
+		return nil, errNoPrecompressedFile
+	}
+
+	// detect content-type from the original file
+	contentType := mime.TypeByExtension(fileExtension(filePath, false, ""))
+	if len(contentType) == 0 {
+		fo, err := h.openFile(filePath)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		contentType, err = h.fileContentType(fo, filePath, false)
+		fo.Close()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return h.newFSFileWithContentType(f, fileInfo, compressedFilePath, contentType, encoding)
 }
 
 func (h *fsHandler) openFSFile(filePath string, mustCompress bool) (*fsFile, error) {
@@ -1083,7 +1566,7 @@ func (h *fsHandler) openFSFile(filePath string, mustCompress bool) (*fsFile, err
 		filePath += h.compressedFileSuffix
 	}
 
-	f, err := os.Open(filePath)
+	f, err := h.openFile(filePath)
 	if err != nil {
 		if mustCompress && os.IsNotExist(err) {
 			return h.compressAndOpenFSFile(filePathOriginal)
@@ -1107,7 +1590,7 @@ func (h *fsHandler) openFSFile(filePath string, mustCompress bool) (*fsFile, err
 	}
 
 	if mustCompress {
-		fileInfoOriginal, err := os.Stat(filePathOriginal)
+		fileInfoOriginal, err := h.stat(filePathOriginal)
 		if err != nil {
 			f.Close()
 			return nil, fmt.Errorf("cannot obtain info for original file %q: %s", filePathOriginal, err)
@@ -1116,49 +1599,101 @@ func (h *fsHandler) openFSFile(filePath string, mustCompress bool) (*fsFile, err
 		if fileInfoOriginal.ModTime() != fileInfo.ModTime() {
 			// The compressed file became stale. Re-create it.
 			f.Close()
-			os.Remove(filePath)
+			if h.filesystem == nil {
+				os.Remove(filePath)
+			}
 			return h.compressAndOpenFSFile(filePathOriginal)
 		}
 	}
 
-	return h.newFSFile(f, fileInfo, mustCompress)
+	return h.newFSFile(f, fileInfo, filePath, mustCompress)
 }
 
-func (h *fsHandler) newFSFile(f *os.File, fileInfo os.FileInfo, compressed bool) (*fsFile, error) {
-	n := fileInfo.Size()
-	contentLength := int(n)
-	if n != int64(contentLength) {
+func (h *fsHandler) newFSFile(f fsFileHandle, fileInfo fs.FileInfo, filePath string, compressed bool) (*fsFile, error) {
+	contentType, err := h.fileContentType(f, filePath, compressed)
+	if err != nil {
 		f.Close()
-		return nil, fmt.Errorf("too big file: %d bytes", n)
+		return nil, err
 	}
 
-	// detect content-type
-	ext := fileExtension(fileInfo.Name(), compressed, h.compressedFileSuffix)
+	var contentEncoding []byte
+	if compressed {
+		contentEncoding = strGzip
+	}
+	return h.newFSFileWithContentType(f, fileInfo, filePath, contentType, contentEncoding)
+}
+
+// fileContentType detects content-type for the file at filePath
+// with contents read from f.
+func (h *fsHandler) fileContentType(f fsFileHandle, filePath string, compressed bool) (string, error) {
+	ext := fileExtension(filePath, compressed, h.compressedFileSuffix)
 	contentType := mime.TypeByExtension(ext)
 	if len(contentType) == 0 {
 		data, err := readFileHeader(f, compressed)
 		if err != nil {
-			return nil, fmt.Errorf("cannot read header of the file %q: %s", f.Name(), err)
+			return "", fmt.Errorf("cannot read header of the file %q: %s", filePath, err)
 		}
 		contentType = http.DetectContentType(data)
 	}
+	return contentType, nil
+}
+
+// newFSFileWithContentType returns fsFile for f opened at filePath.
+//
+// The file is compressed with contentEncoding if it is non-nil.
+func (h *fsHandler) newFSFileWithContentType(f fsFileHandle, fileInfo fs.FileInfo, filePath, contentType string, contentEncoding []byte) (*fsFile, error) {
+	n := fileInfo.Size()
+	contentLength := int(n)
+	if n != int64(contentLength) {
+		f.Close()
+		return nil, fmt.Errorf("too big file: %d bytes", n)
+	}
 
 	lastModified := fileInfo.ModTime()
+	if contentLength <= h.cacheMaxFileSize {
+		// Cache small file contents in memory in order to avoid
+		// reading the file on each request.
+		data := make([]byte, contentLength)
+		_, err := io.ReadFull(f, data)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the file %q: %s", filePath, err)
+		}
+		ff, err := h.newMemFSFile(data, contentType, contentEncoding, lastModified)
+		if err != nil {
+			return nil, err
+		}
+		ff.filePath = filePath
+		return ff, nil
+	}
+
+	etag, err := h.newETag(f, contentLength, lastModified)
+	if err == nil && h.strongETag {
+		_, err = f.Seek(0, 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot generate ETag for the file %q: %s", filePath, err)
+	}
+
 	ff := &fsFile{
 		h:               h,
 		f:               f,
+		filePath:        filePath,
 		contentType:     contentType,
 		contentLength:   contentLength,
-		compressed:      compressed,
+		compressed:      contentEncoding != nil,
+		contentEncoding: contentEncoding,
 		lastModified:    lastModified,
 		lastModifiedStr: AppendHTTPDate(nil, lastModified),
+		etag:            etag,
 
 		t: time.Now(),
 	}
 	return ff, nil
 }
 
-func readFileHeader(f *os.File, compressed bool) ([]byte, error) {
+func readFileHeader(f fsFileHandle, compressed bool) ([]byte, error) {
 	r := io.Reader(f)
 	var zr *gzip.Reader
 	if compressed {
@@ -1183,6 +1718,65 @@ func readFileHeader(f *os.File, compressed bool) ([]byte, error) {
 	return data, err
 }
 
+// fsFileHandle is a file opened by fsHandler.
+type fsFileHandle interface {
+	fs.File
+	io.ReaderAt
+	io.Seeker
+}
+
+// openFile opens the file at filePath relative to h.filesystem
+// or to the local filesystem if h.filesystem isn't set.
+func (h *fsHandler) openFile(filePath string) (fsFileHandle, error) {
+	if h.filesystem == nil {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	f, err := h.filesystem.Open(fsPath(filePath))
+	if err != nil {
+		return nil, err
+	}
+	fh, ok := f.(fsFileHandle)
+	if !ok {
+		fileInfo, err := f.Stat()
+		f.Close()
+		if err == nil && fileInfo.IsDir() {
+			// Directories opened via fs.FS usually aren't seekable.
+			return nil, errDirIndexRequired
+		}
+		return nil, fmt.Errorf("file %q must implement io.ReaderAt and io.Seeker", filePath)
+	}
+	return fh, nil
+}
+
+func (h *fsHandler) stat(filePath string) (fs.FileInfo, error) {
+	if h.filesystem == nil {
+		return os.Stat(filePath)
+	}
+	return fs.Stat(h.filesystem, fsPath(filePath))
+}
+
+func (h *fsHandler) readDir(dirPath string) ([]fs.DirEntry, error) {
+	if h.filesystem == nil {
+		return os.ReadDir(dirPath)
+	}
+	return fs.ReadDir(h.filesystem, fsPath(dirPath))
+}
+
+// fsPath converts filePath to the path accepted by fs.FS.
+//
+// fs.FS paths are unrooted and mustn't contain '.' and '..' elements.
+func fsPath(filePath string) string {
+	filePath = strings.TrimPrefix(path.Clean("/"+filePath), "/")
+	if len(filePath) == 0 {
+		return "."
+	}
+	return filePath
+}
+
 func stripLeadingSlashes(path []byte, stripSlashes int) []byte {
 	for stripSlashes > 0 && len(path) > 0 {
 		if path[0] != '/' {
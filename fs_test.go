@@ -10,7 +10,9 @@ import (
 	"os"
 	"path"
 	"sort"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -294,6 +296,398 @@ func testFSByteRange(t *testing.T, h RequestHandler, filePath string) {
 	}
 }
 
+func TestFSETag(t *testing.T) {
+	fs := &FS{
+		Root: ".",
+	}
+	h := fs.NewRequestHandler()
+
+	resp := testFSRequest(t, h, "/fs.go")
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	etag := string(resp.Header.Peek("ETag"))
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("unexpected ETag %q. Expecting weak ETag", etag)
+	}
+
+	resp = testFSRequest(t, h, "/fs.go", "If-None-Match", `"foo", `+etag)
+	if resp.StatusCode() != StatusNotModified {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusNotModified)
+	}
+	if string(resp.Header.Peek("ETag")) != etag {
+		t.Fatalf("unexpected ETag %q. Expecting %q", resp.Header.Peek("ETag"), etag)
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since.
+	lastModified := string(resp.Header.Peek("Last-Modified"))
+	resp = testFSRequest(t, h, "/fs.go", "If-None-Match", `"foo"`, "If-Modified-Since", lastModified)
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+
+	// Weak ETags never match If-Match.
+	resp = testFSRequest(t, h, "/fs.go", "If-Match", etag)
+	if resp.StatusCode() != StatusPreconditionFailed {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusPreconditionFailed)
+	}
+	resp = testFSRequest(t, h, "/fs.go", "If-Match", "*")
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+
+	resp = testFSRequest(t, h, "/fs.go", "If-Unmodified-Since", "Mon, 02 Jan 2006 15:04:05 GMT")
+	if resp.StatusCode() != StatusPreconditionFailed {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusPreconditionFailed)
+	}
+}
+
+func TestFSStrongETagIfRange(t *testing.T) {
+	fs := &FS{
+		Root:            ".",
+		AcceptByteRange: true,
+		StrongETag:      true,
+	}
+	h := fs.NewRequestHandler()
+
+	expectedBody, err := getFileContents("/fs.go")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	resp := testFSRequest(t, h, "/fs.go")
+	etag := string(resp.Header.Peek("ETag"))
+	if len(etag) == 0 || etag[0] != '"' {
+		t.Fatalf("unexpected ETag %q. Expecting strong ETag", etag)
+	}
+	if !bytes.Equal(resp.Body(), expectedBody) {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	lastModified := string(resp.Header.Peek("Last-Modified"))
+
+	resp = testFSRequest(t, h, "/fs.go", "If-Match", etag)
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+
+	for _, ifRange := range []string{etag, lastModified} {
+		resp = testFSRequest(t, h, "/fs.go", "Range", "bytes=10-19", "If-Range", ifRange)
+		if resp.StatusCode() != StatusPartialContent {
+			t.Fatalf("unexpected status code: %d. Expecting %d. If-Range=%q", resp.StatusCode(), StatusPartialContent, ifRange)
+		}
+		if !bytes.Equal(resp.Body(), expectedBody[10:20]) {
+			t.Fatalf("unexpected body %q. Expecting %q. If-Range=%q", resp.Body(), expectedBody[10:20], ifRange)
+		}
+	}
+
+	for _, ifRange := range []string{`"foobar"`, "W/" + etag, "Mon, 02 Jan 2006 15:04:05 GMT"} {
+		resp = testFSRequest(t, h, "/fs.go", "Range", "bytes=10-19", "If-Range", ifRange)
+		if resp.StatusCode() != StatusOK {
+			t.Fatalf("unexpected status code: %d. Expecting %d. If-Range=%q", resp.StatusCode(), StatusOK, ifRange)
+		}
+		if !bytes.Equal(resp.Body(), expectedBody) {
+			t.Fatalf("unexpected body length %d. Expecting %d. If-Range=%q", len(resp.Body()), len(expectedBody), ifRange)
+		}
+	}
+}
+
+func TestFSPrecompressedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasthttp-fs")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	body := []byte("precompressed file contents")
+	files := map[string][]byte{
+		"/foo.txt":    body,
+		"/foo.txt.br": AppendBrotliBytes(nil, body),
+		"/foo.txt.gz": AppendGzipBytes(nil, body),
+		"/bar.txt":    body,
+		"/bar.txt.br": AppendBrotliBytes(nil, []byte("stale file contents")),
+		"/baz.txt":    body,
+		"/baz.txt.gz": AppendGzipBytes(nil, body),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(dir+name, data, 0644); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	staleTime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(dir+"/bar.txt.br", staleTime, staleTime); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	fs := &FS{
+		Root:                  dir,
+		UsePrecompressedFiles: true,
+	}
+	h := fs.NewRequestHandler()
+
+	testFSPrecompressedFile(t, h, "/foo.txt", "gzip, br", "br", body)
+	testFSPrecompressedFile(t, h, "/foo.txt", "br", "br", body)
+	testFSPrecompressedFile(t, h, "/foo.txt", "gzip;q=0, br", "br", body)
+	testFSPrecompressedFile(t, h, "/foo.txt", "gzip", "gzip", body)
+	testFSPrecompressedFile(t, h, "/foo.txt", "gzip, br;q=0", "gzip", body)
+	testFSPrecompressedFile(t, h, "/foo.txt", "", "", body)
+	testFSPrecompressedFile(t, h, "/bar.txt", "gzip, br", "", body)
+
+	// Files without brotli version mustn't be sent gzip-compressed
+	// to clients accepting only br.
+	testFSPrecompressedFile(t, h, "/baz.txt", "br", "", body)
+	testFSPrecompressedFile(t, h, "/baz.txt", "gzip, br", "gzip", body)
+	testFSPrecompressedFile(t, h, "/baz.txt", "br", "", body)
+}
+
+func testFSPrecompressedFile(t *testing.T, h RequestHandler, filePath, acceptEncoding, expectedEncoding string, expectedBody []byte) {
+	resp := testFSRequest(t, h, filePath, "Accept-Encoding", acceptEncoding)
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	ce := string(resp.Header.Peek("Content-Encoding"))
+	if ce != expectedEncoding {
+		t.Fatalf("unexpected Content-Encoding %q. Expecting %q. Accept-Encoding=%q", ce, expectedEncoding, acceptEncoding)
+	}
+	if vary := string(resp.Header.Peek("Vary")); vary != "Accept-Encoding" {
+		t.Fatalf("unexpected Vary %q. Expecting %q", vary, "Accept-Encoding")
+	}
+	if ct := string(resp.Header.Peek("Content-Type")); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("unexpected Content-Type %q. Expecting text/plain", ct)
+	}
+
+	var body []byte
+	var err error
+	switch ce {
+	case "br":
+		body, err = resp.BodyUnbrotli()
+	case "gzip":
+		body, err = resp.BodyGunzip()
+	default:
+		body = resp.Body()
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(body, expectedBody) {
+		t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+	}
+}
+
+func TestFSFilesystem(t *testing.T) {
+	smallFile := []byte(strings.Repeat("small file contents. ", 100))
+	bigFile := []byte(strings.Repeat("big file contents. ", 1000))
+	filesystem := fstest.MapFS{
+		"static/index.html": {Data: []byte("<html>index page</html>"), ModTime: time.Now()},
+		"static/foo.txt":    {Data: smallFile, ModTime: time.Now()},
+		"static/big.txt":    {Data: bigFile, ModTime: time.Now()},
+		"static/dir/bar.js": {Data: []byte("var bar;"), ModTime: time.Now()},
+		"secret.txt":        {Data: []byte("secret"), ModTime: time.Now()},
+	}
+
+	fs := &FS{
+		FS:                 filesystem,
+		Root:               "/static",
+		IndexNames:         []string{"index.html"},
+		GenerateIndexPages: true,
+		Compress:           true,
+		AcceptByteRange:    true,
+
+		// Serve files via file handles instead of caching them in memory.
+		CacheMaxFileSize: -1,
+	}
+	h := fs.NewRequestHandler()
+
+	for i := 0; i < 3; i++ {
+		testFSFilesystemFile(t, h, "/foo.txt", "", smallFile)
+		testFSFilesystemFile(t, h, "/foo.txt", "gzip", smallFile)
+		testFSFilesystemFile(t, h, "/big.txt", "", bigFile)
+		testFSFilesystemFile(t, h, "/big.txt", "gzip", bigFile)
+		testFSFilesystemFile(t, h, "/", "", []byte("<html>index page</html>"))
+	}
+
+	resp := testFSRequest(t, h, "/big.txt", "Range", "bytes=10000-10009")
+	if resp.StatusCode() != StatusPartialContent {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusPartialContent)
+	}
+	if !bytes.Equal(resp.Body(), bigFile[10000:10010]) {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), bigFile[10000:10010])
+	}
+
+	resp = testFSRequest(t, h, "/dir")
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	if !bytes.Contains(resp.Body(), []byte("bar.js")) {
+		t.Fatalf("cannot find bar.js in the directory index %q", resp.Body())
+	}
+
+	for _, filePath := range []string{"/missing.txt", "/../secret.txt"} {
+		resp = testFSRequest(t, h, filePath)
+		if resp.StatusCode() != StatusNotFound {
+			t.Fatalf("unexpected status code: %d. Expecting %d. filePath=%q", resp.StatusCode(), StatusNotFound, filePath)
+		}
+	}
+}
+
+func TestFSDirFS(t *testing.T) {
+	fs := &FS{
+		FS: os.DirFS("."),
+	}
+	h := fs.NewRequestHandler()
+
+	expectedBody, err := getFileContents("/fs.go")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testFSFilesystemFile(t, h, "/fs.go", "", expectedBody)
+}
+
+func testFSFilesystemFile(t *testing.T, h RequestHandler, filePath, acceptEncoding string, expectedBody []byte) {
+	resp := testFSRequest(t, h, filePath, "Accept-Encoding", acceptEncoding)
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d. filePath=%q", resp.StatusCode(), StatusOK, filePath)
+	}
+	ce := string(resp.Header.Peek("Content-Encoding"))
+	if ce != acceptEncoding {
+		t.Fatalf("unexpected Content-Encoding %q. Expecting %q. filePath=%q", ce, acceptEncoding, filePath)
+	}
+	body := resp.Body()
+	if ce == "gzip" {
+		var err error
+		if body, err = resp.BodyGunzip(); err != nil {
+			t.Fatalf("unexpected error: %s. filePath=%q", err, filePath)
+		}
+	}
+	if !bytes.Equal(body, expectedBody) {
+		t.Fatalf("unexpected body %q. Expecting %q. filePath=%q", body, expectedBody, filePath)
+	}
+}
+
+func TestFSCacheEviction(t *testing.T) {
+	filesystem := fstest.MapFS{
+		"a.txt":   {Data: bytes.Repeat([]byte("a"), 100)},
+		"b.txt":   {Data: bytes.Repeat([]byte("b"), 100)},
+		"c.txt":   {Data: bytes.Repeat([]byte("c"), 100)},
+		"big.txt": {Data: bytes.Repeat([]byte("d"), 200)},
+	}
+	fs := &FS{
+		FS:               filesystem,
+		CacheMaxBytes:    250,
+		CacheMaxFileSize: 150,
+	}
+	h := fs.NewRequestHandler()
+
+	testFSCacheRequest(t, h, "/a.txt", filesystem)
+	testFSCacheRequest(t, h, "/b.txt", filesystem)
+	testFSCacheStats(t, fs, FSCacheStats{Misses: 2, Files: 2, Bytes: 200})
+
+	// a.txt becomes the most recently used file, so b.txt is evicted.
+	testFSCacheRequest(t, h, "/a.txt", filesystem)
+	testFSCacheRequest(t, h, "/c.txt", filesystem)
+	testFSCacheStats(t, fs, FSCacheStats{Hits: 1, Misses: 3, Evictions: 1, Files: 2, Bytes: 200})
+
+	testFSCacheRequest(t, h, "/a.txt", filesystem)
+	testFSCacheRequest(t, h, "/b.txt", filesystem)
+	testFSCacheStats(t, fs, FSCacheStats{Hits: 2, Misses: 4, Evictions: 2, Files: 2, Bytes: 200})
+
+	// big.txt exceeds CacheMaxFileSize, so its contents aren't cached in memory.
+	testFSCacheRequest(t, h, "/big.txt", filesystem)
+	testFSCacheRequest(t, h, "/big.txt", filesystem)
+	testFSCacheStats(t, fs, FSCacheStats{Hits: 3, Misses: 5, Evictions: 2, Files: 3, Bytes: 200})
+}
+
+func TestFSCacheExpiration(t *testing.T) {
+	filesystem := fstest.MapFS{
+		"a.txt": {Data: []byte("foobar")},
+	}
+	fs := &FS{
+		FS:            filesystem,
+		CacheDuration: 50 * time.Millisecond,
+	}
+	h := fs.NewRequestHandler()
+
+	testFSCacheRequest(t, h, "/a.txt", filesystem)
+	testFSCacheStats(t, fs, FSCacheStats{Misses: 1, Files: 1, Bytes: 6})
+
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if fs.CacheStats().Files == 0 {
+			break
+		}
+	}
+	testFSCacheStats(t, fs, FSCacheStats{Misses: 1})
+}
+
+func testFSCacheRequest(t *testing.T, h RequestHandler, filePath string, filesystem fstest.MapFS) {
+	resp := testFSRequest(t, h, filePath)
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d. filePath=%q", resp.StatusCode(), StatusOK, filePath)
+	}
+	expectedBody := filesystem[filePath[1:]].Data
+	if !bytes.Equal(resp.Body(), expectedBody) {
+		t.Fatalf("unexpected body %q. Expecting %q. filePath=%q", resp.Body(), expectedBody, filePath)
+	}
+}
+
+func testFSCacheStats(t *testing.T, fs *FS, expectedStats FSCacheStats) {
+	stats := fs.CacheStats()
+	if stats != expectedStats {
+		t.Fatalf("unexpected cache stats %+v. Expecting %+v", stats, expectedStats)
+	}
+}
+
+func TestFSPath(t *testing.T) {
+	testFSPath(t, "", ".")
+	testFSPath(t, "/", ".")
+	testFSPath(t, ".", ".")
+	testFSPath(t, "./foo/bar", "foo/bar")
+	testFSPath(t, "/static/foo", "static/foo")
+	testFSPath(t, "static//foo/", "static/foo")
+	testFSPath(t, "/../foo", "foo")
+}
+
+func testFSPath(t *testing.T, filePath, expectedPath string) {
+	if p := fsPath(filePath); p != expectedPath {
+		t.Fatalf("unexpected fs path for %q: %q. Expecting %q", filePath, p, expectedPath)
+	}
+}
+
+func testFSRequest(t *testing.T, h RequestHandler, filePath string, headers ...string) *Response {
+	var ctx RequestCtx
+	ctx.Init(&Request{}, nil, nil)
+	ctx.Request.SetRequestURI(filePath)
+	for i := 0; i < len(headers); i += 2 {
+		ctx.Request.Header.Set(headers[i], headers[i+1])
+	}
+	h(&ctx)
+
+	var resp Response
+	s := ctx.Response.String()
+	br := bufio.NewReader(bytes.NewBufferString(s))
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s. filePath=%q", err, filePath)
+	}
+	return &resp
+}
+
+func TestETagMatch(t *testing.T) {
+	testETagMatch(t, `"foo"`, `"foo"`, false, true)
+	testETagMatch(t, `"bar", "foo"`, `"foo"`, false, true)
+	testETagMatch(t, `*`, `"foo"`, false, true)
+	testETagMatch(t, `W/"foo"`, `"foo"`, false, false)
+	testETagMatch(t, `W/"foo"`, `"foo"`, true, true)
+	testETagMatch(t, `"foo"`, `W/"foo"`, false, false)
+	testETagMatch(t, `"foo"`, `W/"foo"`, true, true)
+	testETagMatch(t, `"bar"`, `"foo"`, true, false)
+	testETagMatch(t, ``, `"foo"`, true, false)
+}
+
+func testETagMatch(t *testing.T, list, etag string, weak, expectedMatch bool) {
+	if etagMatch([]byte(list), []byte(etag), weak) != expectedMatch {
+		t.Fatalf("unexpected result for etagMatch(%q, %q, %v). Expecting %v", list, etag, weak, expectedMatch)
+	}
+}
+
 func getFileContents(path string) ([]byte, error) {
 	path = "." + path
 	f, err := os.Open(path)
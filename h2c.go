@@ -0,0 +1,155 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// strH2CPreface is the connection preface sent by HTTP/2 clients.
+// See RFC 7540, section 3.5.
+var strH2CPreface = []byte(http2.ClientPreface)
+
+// isH2CPreface returns true if br starts with HTTP/2 connection preface.
+//
+// The preface isn't consumed from br.
+func isH2CPreface(br *bufio.Reader) bool {
+	// Peek the method first, since short HTTP/1.x requests
+	// may be smaller than the preface.
+	b, err := br.Peek(len("PRI "))
+	if err != nil || !bytes.Equal(b, strH2CPreface[:len(b)]) {
+		return false
+	}
+	b, err = br.Peek(len(strH2CPreface))
+	return err == nil && bytes.Equal(b, strH2CPreface)
+}
+
+// h2cUpgradeSettings returns decoded HTTP2-Settings header value
+// if the request asks for upgrading the connection to h2c.
+// See RFC 7540, section 3.2.
+//
+// false is returned if the request cannot be upgraded to h2c.
+func h2cUpgradeSettings(h *RequestHeader) ([]byte, bool) {
+	if !h.IsHTTP11() || !hasHeaderValueFold(h.PeekBytes(strUpgrade), strH2C) ||
+		!hasHeaderValueFold(h.PeekBytes(strConnection), strHTTP2Settings) {
+		return nil, false
+	}
+	settings, err := base64.RawURLEncoding.DecodeString(string(h.PeekBytes(strHTTP2Settings)))
+	if err != nil {
+		return nil, false
+	}
+	return settings, true
+}
+
+func hasHeaderValueFold(s, value []byte) bool {
+	var vs headerValueScanner
+	vs.b = s
+	for vs.next() {
+		if bytes.EqualFold(vs.value, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// initH2C initializes HTTP/2 server used for serving h2c connections.
+//
+// The returned base config is used for graceful shutdown of h2c
+// connections.
+func (s *Server) initH2C() (*http2.Server, *http.Server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.h2cServer == nil {
+		hs := &http.Server{
+			ReadTimeout:  s.ReadTimeout,
+			WriteTimeout: s.WriteTimeout,
+			IdleTimeout:  s.IdleTimeout,
+		}
+		h2s := &http2.Server{}
+		if err := http2.ConfigureServer(hs, h2s); err != nil {
+			panic("BUG: cannot configure h2c server: " + err.Error())
+		}
+		s.h2cServer = h2s
+		s.h2cBaseConfig = hs
+	}
+	return s.h2cServer, s.h2cBaseConfig
+}
+
+// serveH2C serves HTTP/2 connection c until it is closed.
+//
+// r must contain data read from c, which isn't processed yet.
+// upgradeReq must contain the request upgraded to h2c, if any.
+// The response for upgradeReq is sent in the stream 1.
+func (s *Server) serveH2C(c net.Conn, r io.Reader, connID uint64, connTime time.Time, upgradeReq *http.Request, settings []byte) {
+	// Shutdown must close h2c connections, since their in-flight
+	// requests are tracked by http2 server.
+	if !s.setConnIdle(c, true) {
+		return
+	}
+
+	h2s, hs := s.initH2C()
+
+	// http2 server manages timeouts on its' own.
+	c.SetReadDeadline(zeroTime)
+	c.SetWriteDeadline(zeroTime)
+
+	h2s.ServeConn(&h2cConn{Conn: c, r: r}, &http2.ServeConnOpts{
+		BaseConfig: hs,
+		Handler: &netHTTPHandler{
+			s:        s,
+			c:        c,
+			connID:   connID,
+			connTime: connTime,
+		},
+		UpgradeRequest: upgradeReq,
+		Settings:       settings,
+	})
+}
+
+// newH2CUpgradeRequest returns the request for serving upgraded req
+// via HTTP/2 server.
+func newH2CUpgradeRequest(req *Request) *http.Request {
+	r := &http.Request{
+		Method:        string(req.Header.Method()),
+		RequestURI:    string(req.RequestURI()),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Host:          string(req.Host()),
+		ContentLength: int64(len(req.Body())),
+		Body:          ioutil.NopCloser(bytes.NewReader(append([]byte(nil), req.Body()...))),
+	}
+	r.URL, _ = url.ParseRequestURI(r.RequestURI)
+	if r.URL == nil {
+		r.URL = &url.URL{}
+	}
+	req.Header.VisitAll(func(k, v []byte) {
+		switch string(k) {
+		case "Host", "Content-Length", "Connection", "Upgrade", "Http2-Settings":
+			// These headers aren't passed to HTTP/2 handlers.
+		default:
+			r.Header.Add(string(k), string(v))
+		}
+	})
+	return r
+}
+
+// h2cConn reads buffered data before reading from the underlying
+// connection.
+type h2cConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *h2cConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,228 @@
+package fasthttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+func testH2CServer(ln net.Listener) *Server {
+	s := &Server{
+		EnableH2C: true,
+		Handler: func(ctx *RequestCtx) {
+			ctx.Response.Header.Set("X-Method", string(ctx.Method()))
+			ctx.Response.Header.Set("X-Foo", string(ctx.Request.Header.Peek("X-Foo")))
+			ctx.SetStatusCode(StatusAccepted)
+			ctx.Write(ctx.RequestURI())
+			ctx.Write(ctx.PostBody())
+		},
+	}
+	go s.Serve(ln)
+	return s
+}
+
+func TestServerH2CPriorKnowledge(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := testH2CServer(ln)
+
+	c := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return ln.Dial()
+			},
+		},
+	}
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("POST", "http://foobar/foo?bar=baz", strings.NewReader("body"))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		req.Header.Set("X-Foo", "xxx")
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if resp.ProtoMajor != 2 {
+			t.Fatalf("unexpected protocol %q. Expecting HTTP/2", resp.Proto)
+		}
+		if resp.StatusCode != StatusAccepted {
+			t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode, StatusAccepted)
+		}
+		if string(body) != "/foo?bar=bazbody" {
+			t.Fatalf("unexpected body %q. Expecting %q", body, "/foo?bar=bazbody")
+		}
+		if v := resp.Header.Get("X-Method"); v != "POST" {
+			t.Fatalf("unexpected method %q. Expecting %q", v, "POST")
+		}
+		if v := resp.Header.Get("X-Foo"); v != "xxx" {
+			t.Fatalf("unexpected header %q. Expecting %q", v, "xxx")
+		}
+		if v := resp.Header.Get("Server"); v != string(defaultServerName) {
+			t.Fatalf("unexpected server %q. Expecting %q", v, defaultServerName)
+		}
+	}
+
+	// Shutdown must close h2c connections.
+	ch := make(chan error, 1)
+	go func() {
+		ch <- s.Shutdown()
+	}()
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestServerH2CUpgrade(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	testH2CServer(ln)
+	defer ln.Close()
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte("POST /upgrade HTTP/1.1\r\nHost: foobar\r\nX-Foo: bar\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\n" +
+		"Content-Length: 3\r\n\r\nabc")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(conn)
+	var resp Response
+	resp.SkipBody = true
+	if err = resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusSwitchingProtocols {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusSwitchingProtocols)
+	}
+	if string(resp.Header.Peek("Upgrade")) != "h2c" {
+		t.Fatalf("unexpected Upgrade header %q. Expecting %q", resp.Header.Peek("Upgrade"), "h2c")
+	}
+
+	if _, err = conn.Write(strH2CPreface); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fr := http2.NewFramer(conn, br)
+	fr.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	if err = fr.WriteSettings(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The response for the upgraded request is sent in the stream 1.
+	var status, foo string
+	var body []byte
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				if err = fr.WriteSettingsAck(); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+			continue
+		case *http2.MetaHeadersFrame:
+			if f.StreamID != 1 {
+				t.Fatalf("unexpected stream id %d. Expecting 1", f.StreamID)
+			}
+			status = f.PseudoValue("status")
+			for _, hf := range f.RegularFields() {
+				if hf.Name == "x-foo" {
+					foo = hf.Value
+				}
+			}
+			if !f.StreamEnded() {
+				continue
+			}
+		case *http2.DataFrame:
+			body = append(body, f.Data()...)
+			if !f.StreamEnded() {
+				continue
+			}
+		default:
+			continue
+		}
+		break
+	}
+	if status != "202" {
+		t.Fatalf("unexpected status %q. Expecting %q", status, "202")
+	}
+	if foo != "bar" {
+		t.Fatalf("unexpected header %q. Expecting %q", foo, "bar")
+	}
+	if string(body) != "/upgradeabc" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "/upgradeabc")
+	}
+}
+
+func TestNewH2CUpgradeRequest(t *testing.T) {
+	var req Request
+	s := "POST /upgrade HTTP/1.1\r\nHost: foobar\r\nX-Foo: bar\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\n" +
+		"Content-Length: 3\r\n\r\nabc"
+	if err := req.Read(bufio.NewReader(strings.NewReader(s))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	r := newH2CUpgradeRequest(&req)
+	if r.Host != "foobar" {
+		t.Fatalf("unexpected host %q. Expecting %q", r.Host, "foobar")
+	}
+	for _, k := range []string{"Host", "Content-Length", "Connection", "Upgrade", "Http2-Settings"} {
+		if v, ok := r.Header[k]; ok {
+			t.Fatalf("unexpected %s header %q", k, v)
+		}
+	}
+	if v := r.Header.Get("X-Foo"); v != "bar" {
+		t.Fatalf("unexpected X-Foo header %q. Expecting %q", v, "bar")
+	}
+}
+
+func TestServerH2CHTTP1(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	testH2CServer(ln)
+	defer ln.Close()
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	// The request is shorter than HTTP/2 preface.
+	if _, err = conn.Write([]byte("GET / HTTP/1.0\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var resp Response
+	if err = resp.Read(bufio.NewReader(conn)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusAccepted || string(resp.Body()) != "/" {
+		t.Fatalf("unexpected response %d %q. Expecting %d %q", resp.StatusCode(), resp.Body(), StatusAccepted, "/")
+	}
+}
@@ -1172,6 +1172,23 @@ func (h *RequestHeader) PeekBytes(key []byte) []byte {
 	return h.peek(h.bufKV.key)
 }
 
+// addVaryValue adds the given header name to Vary header unless
+// it is already listed there.
+func (h *ResponseHeader) addVaryValue(name []byte) {
+	vary := h.peek(strVary)
+	if hasHeaderValueFold(vary, name) || hasHeaderValueFold(vary, strStar) {
+		return
+	}
+	b := h.bufKV.value[:0]
+	if len(vary) > 0 {
+		b = append(b, vary...)
+		b = append(b, ", "...)
+	}
+	b = append(b, name...)
+	h.bufKV.value = b
+	h.SetCanonical(strVary, b)
+}
+
 func (h *ResponseHeader) peek(key []byte) []byte {
 	switch string(key) {
 	case "Content-Type":
@@ -1448,9 +1465,11 @@ func (h *ResponseHeader) AppendBytes(dst []byte) []byte {
 		dst = appendHeaderLine(dst, strContentLength, h.contentLengthBytes)
 	}
 
+	trailer := peekArgBytes(h.h, strTrailer)
+	chunked := h.contentLength == -1
 	for i, n := 0, len(h.h); i < n; i++ {
 		kv := &h.h[i]
-		if !bytes.Equal(kv.key, strDate) {
+		if !bytes.Equal(kv.key, strDate) && !skipTrailerField(trailer, kv.key, chunked) {
 			dst = appendHeaderLine(dst, kv.key, kv.value)
 		}
 	}
@@ -1503,7 +1522,12 @@ func (h *RequestHeader) AppendBytes(dst []byte) []byte {
 	// there is no need in h.parseRawHeaders() here - raw headers are specially handled below.
 	dst = append(dst, h.Method()...)
 	dst = append(dst, ' ')
-	dst = append(dst, h.RequestURI()...)
+	requestURI := h.RequestURI()
+	if n := bytes.IndexByte(requestURI, '#'); n >= 0 {
+		// Fragments mustn't be sent in the request line.
+		requestURI = requestURI[:n]
+	}
+	dst = append(dst, requestURI...)
 	dst = append(dst, ' ')
 	dst = append(dst, strHTTP11...)
 	dst = append(dst, strCRLF...)
@@ -1537,9 +1561,13 @@ func (h *RequestHeader) AppendBytes(dst []byte) []byte {
 		dst = appendHeaderLine(dst, strContentType, contentType)
 	}
 
+	trailer := peekArgBytes(h.h, strTrailer)
+	chunked := h.contentLength == -1
 	for i, n := 0, len(h.h); i < n; i++ {
 		kv := &h.h[i]
-		dst = appendHeaderLine(dst, kv.key, kv.value)
+		if !skipTrailerField(trailer, kv.key, chunked) {
+			dst = appendHeaderLine(dst, kv.key, kv.value)
+		}
 	}
 
 	// there is no need in h.collectCookies() here, since if cookies aren't collected yet,
@@ -1821,6 +1821,16 @@ func TestResponseHeaderReadSuccess(t *testing.T) {
 	}
 }
 
+func TestRequestHeaderWriteFragment(t *testing.T) {
+	var h RequestHeader
+	h.SetRequestURI("/foo/bar?baz=1#fragment")
+	h.SetHost("foobar.com")
+	s := h.String()
+	if !strings.HasPrefix(s, "GET /foo/bar?baz=1 HTTP/1.1\r\n") {
+		t.Fatalf("unexpected request line in %q. Expecting %q", s, "GET /foo/bar?baz=1 HTTP/1.1\r\n")
+	}
+}
+
 func TestRequestHeaderReadSuccess(t *testing.T) {
 	h := &RequestHeader{}
 
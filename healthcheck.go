@@ -0,0 +1,128 @@
+package fasthttp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHealthCheckTimeout is the default timeout for a single health check
+// performed by HostClient if HealthCheckTimeout isn't set.
+const DefaultHealthCheckTimeout = time.Second
+
+func (c *HostClient) healthChecker() {
+	for {
+		c.checkAddrsHealth()
+
+		// Determine whether to stop the healthChecker.
+		c.connsLock.Lock()
+		mustStop := c.connsCount == 0
+		if mustStop {
+			c.healthCheckerRun = false
+		}
+		c.connsLock.Unlock()
+		if mustStop {
+			break
+		}
+
+		time.Sleep(c.HealthCheckInterval)
+	}
+}
+
+func (c *HostClient) checkAddrsHealth() {
+	c.addrsLock.Lock()
+	c.initAddrsLocked()
+	addrs := c.addrs
+	c.addrsLock.Unlock()
+
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(idx int, addr string) {
+			err := c.checkAddrHealth(addr)
+			c.setAddrHealth(idx, err == nil)
+			wg.Done()
+		}(i, addr)
+	}
+	wg.Wait()
+}
+
+func (c *HostClient) setAddrHealth(idx int, healthy bool) {
+	c.addrsLock.Lock()
+	changed := c.addrUnhealthy[idx] == healthy
+	if changed {
+		c.addrUnhealthy[idx] = !healthy
+		if healthy {
+			c.unhealthyAddrs--
+		} else {
+			c.unhealthyAddrs++
+		}
+	}
+	addr := c.addrs[idx]
+	c.addrsLock.Unlock()
+
+	if changed && c.OnAddrHealthChange != nil {
+		c.OnAddrHealthChange(addr, healthy)
+	}
+}
+
+func (c *HostClient) checkAddrHealth(addr string) error {
+	timeout := c.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	tlsConfig := c.cachedTLSConfig(addr)
+	conn, err := dialAddr(addr, c.Dial, c.DialWithTimeout, c.DialDualStack, c.IsTLS, tlsConfig, c.ProxyProtocolHeader, timeout, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if len(c.HealthCheckPath) == 0 {
+		return nil
+	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+
+	req.Header.SetRequestURI(c.HealthCheckPath)
+	req.Header.SetHost(healthCheckHost(addr))
+	req.Header.SetUserAgentBytes(c.getClientName())
+	req.SetConnectionClose()
+
+	bw := c.acquireWriter(conn)
+	err = req.Write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	c.releaseWriter(bw)
+	if err != nil {
+		return err
+	}
+
+	br := c.acquireReader(conn)
+	err = resp.Read(br)
+	c.releaseReader(br)
+	if err != nil {
+		return err
+	}
+	if statusCode := resp.StatusCode(); statusCode < 200 || statusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d returned by health check", statusCode)
+	}
+	return nil
+}
+
+func healthCheckHost(addr string) string {
+	if strings.HasPrefix(addr, unixAddrPrefix) {
+		return "localhost"
+	}
+	return addr
+}
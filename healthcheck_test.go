@@ -0,0 +1,104 @@
+package fasthttp
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestHostClientHealthCheck(t *testing.T) {
+	testHostClientHealthCheck(t, "/health")
+}
+
+func TestHostClientHealthCheckTCP(t *testing.T) {
+	testHostClientHealthCheck(t, "")
+}
+
+func testHostClientHealthCheck(t *testing.T, healthCheckPath string) {
+	var bUnhealthy uint32
+	lns := make(map[string]*fasthttputil.InmemoryListener)
+	for _, addr := range []string{"a", "b"} {
+		addr := addr
+		ln := fasthttputil.NewInmemoryListener()
+		lns[addr] = ln
+		s := &Server{
+			Handler: func(ctx *RequestCtx) {
+				if string(ctx.Path()) == "/health" && addr == "b" && atomic.LoadUint32(&bUnhealthy) == 1 {
+					ctx.SetStatusCode(StatusServiceUnavailable)
+					return
+				}
+				ctx.WriteString(addr)
+			},
+		}
+		go s.Serve(ln)
+		defer ln.Close()
+	}
+
+	events := make(chan string, 10)
+	c := &HostClient{
+		Addr: "a,b",
+		Dial: func(addr string) (net.Conn, error) {
+			if healthCheckPath == "" && addr == "b" && atomic.LoadUint32(&bUnhealthy) == 1 {
+				return nil, fmt.Errorf("cannot dial %q", addr)
+			}
+			return lns[addr].Dial()
+		},
+		HealthCheckInterval: 10 * time.Millisecond,
+		HealthCheckPath:     healthCheckPath,
+		OnAddrHealthChange: func(addr string, healthy bool) {
+			events <- fmt.Sprintf("%s healthy=%v", addr, healthy)
+		},
+	}
+	get := func(connectionClose bool) string {
+		req := AcquireRequest()
+		resp := AcquireResponse()
+		defer ReleaseRequest(req)
+		defer ReleaseResponse(resp)
+
+		req.SetRequestURI("http://foobar/")
+		if connectionClose {
+			req.SetConnectionClose()
+		}
+		if err := c.DoTimeout(req, resp, time.Second); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return string(resp.Body())
+	}
+	expectEvent := func(expectedEvent string) {
+		select {
+		case event := <-events:
+			if event != expectedEvent {
+				t.Fatalf("unexpected event %q. Expecting %q", event, expectedEvent)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout when waiting for %q", expectedEvent)
+		}
+	}
+
+	// Keep-alive connection keeps the health checker running.
+	if body := get(false); body != "a" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "a")
+	}
+
+	atomic.StoreUint32(&bUnhealthy, 1)
+	expectEvent("b healthy=false")
+	for i := 0; i < 5; i++ {
+		if body := get(true); body != "a" {
+			t.Fatalf("unexpected body %q. Expecting %q", body, "a")
+		}
+	}
+
+	atomic.StoreUint32(&bUnhealthy, 0)
+	expectEvent("b healthy=true")
+	bodies := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		bodies[get(true)]++
+	}
+	if bodies["a"] != 2 || bodies["b"] != 2 {
+		t.Fatalf("unexpected responses distribution: %v. Expecting two responses from each address", bodies)
+	}
+}
@@ -3,12 +3,17 @@ package fasthttp
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
+	"net"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/valyala/bytebufferpool"
 )
@@ -27,6 +32,12 @@ type Request struct {
 	// Copying Header by value is forbidden. Use pointer to Header instead.
 	Header RequestHeader
 
+	// UseHostHeader makes Write send the Host header set via
+	// Header.SetHost instead of the host from the request URI.
+	//
+	// The request is still sent to the host from the request URI.
+	UseHostHeader bool
+
 	uri      URI
 	postArgs Args
 
@@ -37,6 +48,16 @@ type Request struct {
 	multipartForm         *multipart.Form
 	multipartFormBoundary string
 
+	tlsServerName string
+	dialAddr      string
+	dial          DialFunc
+	conn          net.Conn
+	trace         *ClientTrace
+	spanTiming    *SpanTiming
+
+	// ctx is set by DoContext for the duration of the call.
+	ctx context.Context
+
 	// Group bool members in order to reduce Request object size.
 	parsedURI      bool
 	parsedPostArgs bool
@@ -71,7 +92,36 @@ type Response struct {
 	// Use it for writing HEAD responses.
 	SkipBody bool
 
+	// Response.Read() doesn't read body if set to true. The body
+	// must be read via BodyStream instead.
+	//
+	// Use it for reading huge response bodies without buffering them
+	// in memory.
+	StreamBody bool
+
+	// Response.Write() flushes response headers to the client before
+	// reading the body stream if set to true.
+	//
+	// Use it for notifying the client about the response before the first
+	// body bytes become available, e.g. for long-polling responses.
+	ImmediateHeaderFlush bool
+
+	// Response.Write() flushes the data read from the body stream
+	// to the client at least every StreamFlushInterval if set to positive
+	// value.
+	//
+	// By default chunked body streams are flushed after each read from
+	// the stream, while body streams with known size are flushed only when
+	// the write buffer is full. Set StreamFlushInterval for bounding
+	// the time the data is buffered in the latter case or for coalescing
+	// small chunks in the former case. Note that StreamWriter.Flush calls
+	// are delayed by up to StreamFlushInterval then.
+	StreamFlushInterval time.Duration
+
 	keepBodyBuffer bool
+
+	bodySink     io.Writer
+	bodySinkUsed bool
 }
 
 // SetHost sets host for the request.
@@ -238,6 +288,10 @@ func (req *Request) SetBodyStreamWriter(sw StreamWriter) {
 //     * if response body must be streamed to the client in chunks
 //     (aka `http server push` or `chunked transfer-encoding`).
 //
+// The data written to sw is sent to the client after w.Flush() call
+// or when the sw buffer is full. See also ImmediateHeaderFlush
+// and StreamFlushInterval.
+//
 // See also SetBodyStream.
 func (resp *Response) SetBodyStreamWriter(sw StreamWriter) {
 	sr := NewStreamReader(sw)
@@ -294,6 +348,31 @@ func (resp *Response) Body() []byte {
 	return resp.bodyBytes()
 }
 
+// bodySize returns the request body size or -1 if it is unknown.
+func (req *Request) bodySize() int {
+	if req.bodyStream != nil {
+		if n := req.Header.ContentLength(); n >= 0 {
+			return n
+		}
+		return -1
+	}
+	return len(req.bodyBytes())
+}
+
+// bodySize returns the response body size or -1 if it is unknown.
+func (resp *Response) bodySize() int {
+	if resp.bodyStream != nil {
+		if n := resp.Header.ContentLength(); n >= 0 {
+			return n
+		}
+		if n := limitedReaderSize(resp.bodyStream); n >= 0 && int64(int(n)) == n {
+			return int(n)
+		}
+		return -1
+	}
+	return len(resp.bodyBytes())
+}
+
 func (resp *Response) bodyBytes() []byte {
 	if resp.body == nil {
 		return nil
@@ -381,6 +460,98 @@ func inflateData(p []byte) ([]byte, error) {
 	return bb.B, nil
 }
 
+// BodyUnbrotli returns un-brotlied body data.
+//
+// This method may be used if the request header contains
+// 'Content-Encoding: br' for reading un-brotlied body.
+// Use Body for reading brotlied request body.
+func (req *Request) BodyUnbrotli() ([]byte, error) {
+	return unBrotliData(req.Body())
+}
+
+// BodyUnbrotli returns un-brotlied body data.
+//
+// This method may be used if the response header contains
+// 'Content-Encoding: br' for reading un-brotlied body.
+// Use Body for reading brotlied response body.
+func (resp *Response) BodyUnbrotli() ([]byte, error) {
+	return unBrotliData(resp.Body())
+}
+
+func unBrotliData(p []byte) ([]byte, error) {
+	var bb ByteBuffer
+	_, err := WriteUnbrotli(&bb, p)
+	if err != nil {
+		return nil, err
+	}
+	return bb.B, nil
+}
+
+// BodyUncompressed returns body data decompressed according
+// to 'Content-Encoding' request header.
+//
+// gzip, deflate and br encodings are supported. The body is returned as is
+// if the request has no Content-Encoding.
+func (req *Request) BodyUncompressed() ([]byte, error) {
+	return uncompressData(req.Header.peek(strContentEncoding), req.Body())
+}
+
+// BodyUncompressed returns body data decompressed according
+// to 'Content-Encoding' response header.
+//
+// gzip, deflate and br encodings are supported. The body is returned as is
+// if the response has no Content-Encoding.
+//
+// This method may be used for reading responses to requests
+// with 'Accept-Encoding: gzip, deflate, br' header.
+func (resp *Response) BodyUncompressed() ([]byte, error) {
+	return uncompressData(resp.Header.peek(strContentEncoding), resp.Body())
+}
+
+func uncompressData(ce, p []byte) ([]byte, error) {
+	switch {
+	case len(ce) == 0 || bytes.Equal(ce, strIdentity):
+		return p, nil
+	case bytes.Equal(ce, strGzip):
+		return gunzipData(p)
+	case bytes.Equal(ce, strDeflate):
+		return inflateData(p)
+	case bytes.Equal(ce, strBr):
+		return unBrotliData(p)
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding: %q", ce)
+	}
+}
+
+// SetBodyBrotli sets brotlied body and 'Content-Encoding: br' request header.
+func (req *Request) SetBodyBrotli(body []byte) {
+	req.RemoveMultipartFormFiles()
+	req.closeBodyStream()
+
+	// Compress into a new buffer, since body may refer to the current one.
+	w := requestBodyPool.Get()
+	w.B = AppendBrotliBytes(w.B, body)
+	if req.body != nil {
+		requestBodyPool.Put(req.body)
+	}
+	req.body = w
+	req.Header.SetCanonical(strContentEncoding, strBr)
+}
+
+// SetBodyBrotli sets brotlied body and 'Content-Encoding: br' response header.
+func (resp *Response) SetBodyBrotli(body []byte) {
+	resp.closeBodyStream()
+
+	// Compress into a new buffer, since body may refer to the current one.
+	w := responseBodyPool.Get()
+	w.B = AppendBrotliBytes(w.B, body)
+	if resp.body != nil {
+		responseBodyPool.Put(resp.body)
+	}
+	resp.body = w
+	resp.Header.SetCanonical(strContentEncoding, strBr)
+}
+
 // BodyWriteTo writes request body to w.
 func (req *Request) BodyWriteTo(w io.Writer) error {
 	if req.bodyStream != nil {
@@ -395,6 +566,38 @@ func (req *Request) BodyWriteTo(w io.Writer) error {
 	return err
 }
 
+// BodyStream returns the response body stream.
+//
+// The stream is set either via SetBodyStream or by reading
+// the response with StreamBody set to true. nil is returned
+// if the response has no body stream.
+//
+// The body stream must be closed via CloseBodyStream or ReleaseResponse
+// after reading.
+func (resp *Response) BodyStream() io.Reader {
+	return resp.bodyStream
+}
+
+// SetBodySink sets w as the destination for the response body.
+//
+// Response.Read and client's Do* methods write the body directly to w
+// instead of buffering it in the response, so Body returns empty body then.
+// This is useful for saving huge response bodies to files. Unlike StreamBody,
+// the connection is returned to the pool as soon as the response is read.
+//
+// The request isn't retried by the client if the response body has been
+// partially written to w.
+//
+// The sink is reset by Reset.
+func (resp *Response) SetBodySink(w io.Writer) {
+	resp.bodySink = w
+}
+
+// CloseBodyStream closes the response body stream if it implements io.Closer.
+func (resp *Response) CloseBodyStream() error {
+	return resp.closeBodyStream()
+}
+
 // BodyWriteTo writes response body to w.
 func (resp *Response) BodyWriteTo(w io.Writer) error {
 	if resp.bodyStream != nil {
@@ -600,11 +803,22 @@ func (req *Request) copyToSkipBody(dst *Request) {
 	req.Header.CopyTo(&dst.Header)
 
 	req.uri.CopyTo(&dst.uri)
+	if dst.uri.h == &req.Header {
+		// The uri may read the host from the request header,
+		// so it mustn't refer to the header of req.
+		dst.uri.h = &dst.Header
+	}
 	dst.parsedURI = req.parsedURI
+	dst.UseHostHeader = req.UseHostHeader
 
 	req.postArgs.CopyTo(&dst.postArgs)
 	dst.parsedPostArgs = req.parsedPostArgs
 	dst.isTLS = req.isTLS
+	dst.tlsServerName = req.tlsServerName
+	dst.dialAddr = req.dialAddr
+	dst.dial = req.dial
+	dst.conn = req.conn
+	dst.trace = req.trace
 
 	// do not copy multipartForm - it will be automatically
 	// re-created on the first call to MultipartForm.
@@ -624,6 +838,56 @@ func (resp *Response) copyToSkipBody(dst *Response) {
 	dst.Reset()
 	resp.Header.CopyTo(&dst.Header)
 	dst.SkipBody = resp.SkipBody
+	dst.StreamBody = resp.StreamBody
+	dst.ImmediateHeaderFlush = resp.ImmediateHeaderFlush
+	dst.StreamFlushInterval = resp.StreamFlushInterval
+	dst.bodySink = resp.bodySink
+}
+
+// Clone returns a deep copy of req.
+//
+// Unlike CopyTo, the returned request doesn't share any data with req,
+// so both requests may be modified and used from concurrently running
+// goroutines.
+//
+// The body stream set via SetBodyStream is read into memory, so both
+// requests contain the body after the call. Multipart form is copied
+// to the returned request body. ClientTrace set via SetTrace is shared
+// between the requests.
+//
+// The returned request may be released via ReleaseRequest
+// when no longer needed.
+func (req *Request) Clone() *Request {
+	dst := AcquireRequest()
+	req.copyToSkipBody(dst)
+	if req.onlyMultipartForm() {
+		dst.Header.SetMultipartFormBoundary(req.multipartFormBoundary)
+	}
+	if body := req.Body(); len(body) > 0 {
+		dst.bodyBuffer().Set(body)
+	}
+	return dst
+}
+
+// Clone returns a deep copy of resp.
+//
+// Unlike CopyTo, the returned response doesn't share any data with resp,
+// so both responses may be modified and used from concurrently running
+// goroutines.
+//
+// The body stream is read into memory, so both responses contain
+// the body after the call. The body sink set via SetBodySink isn't copied.
+//
+// The returned response may be released via ReleaseResponse
+// when no longer needed.
+func (resp *Response) Clone() *Response {
+	dst := AcquireResponse()
+	resp.copyToSkipBody(dst)
+	dst.bodySink = nil
+	if body := resp.Body(); len(body) > 0 {
+		dst.bodyBuffer().Set(body)
+	}
+	return dst
 }
 
 func swapRequestBody(a, b *Request) {
@@ -681,6 +945,14 @@ var ErrNoMultipartForm = errors.New("request has no multipart/form-data Content-
 // RemoveMultipartFormFiles must be called after returned multipart form
 // is processed.
 func (req *Request) MultipartForm() (*multipart.Form, error) {
+	return req.multipartFormLimited(multipartFormLimits{})
+}
+
+// multipartFormLimited reads multipart form with the given limits.
+//
+// The form is read directly from the body stream if the body is streamed,
+// so files exceeding the memory limit don't occupy memory.
+func (req *Request) multipartFormLimited(l multipartFormLimits) (*multipart.Form, error) {
 	if req.multipartForm != nil {
 		return req.multipartForm, nil
 	}
@@ -691,18 +963,47 @@ func (req *Request) MultipartForm() (*multipart.Form, error) {
 	}
 
 	ce := req.Header.peek(strContentEncoding)
-	body := req.bodyBytes()
+	if req.bodyStream != nil && len(ce) == 0 {
+		if l.maxMemory <= 0 {
+			l.maxMemory = defaultMaxInMemoryFileSize
+		}
+		f, err := readMultipartForm(req.bodyStream, req.multipartFormBoundary, -1, l)
+		if err == nil {
+			// Discard the epilogue, so the body stream is read till the end.
+			_, err = copyZeroAlloc(ioutil.Discard, req.bodyStream)
+			if err != nil {
+				f.RemoveAll()
+			}
+		}
+		req.closeBodyStream()
+		if err != nil {
+			return nil, err
+		}
+		req.multipartForm = f
+		return f, nil
+	}
+
+	body := req.Body()
 	if bytes.Equal(ce, strGzip) {
 		// Do not care about memory usage here.
 		var err error
 		if body, err = AppendGunzipBytes(nil, body); err != nil {
 			return nil, fmt.Errorf("cannot gunzip request body: %s", err)
 		}
+	} else if bytes.Equal(ce, strBr) {
+		var err error
+		if body, err = AppendUnbrotliBytes(nil, body); err != nil {
+			return nil, fmt.Errorf("cannot unbrotli request body: %s", err)
+		}
 	} else if len(ce) > 0 {
 		return nil, fmt.Errorf("unsupported Content-Encoding: %q", ce)
 	}
 
-	f, err := readMultipartForm(bytes.NewReader(body), req.multipartFormBoundary, len(body), len(body))
+	if l.maxMemory <= 0 {
+		// The body is already in memory, so keep the whole form there.
+		l.maxMemory = len(body)
+	}
+	f, err := readMultipartForm(bytes.NewReader(body), req.multipartFormBoundary, len(body), l)
 	if err != nil {
 		return nil, err
 	}
@@ -768,18 +1069,33 @@ func WriteMultipartForm(w io.Writer, f *multipart.Form, boundary string) error {
 	return nil
 }
 
-func readMultipartForm(r io.Reader, boundary string, size, maxInMemoryFileSize int) (*multipart.Form, error) {
+// readMultipartForm reads multipart form of the given size from r.
+//
+// The form is read till the end of r if size isn't positive.
+func readMultipartForm(r io.Reader, boundary string, size int, l multipartFormLimits) (*multipart.Form, error) {
 	// Do not care about memory allocations here, since they are tiny
 	// compared to multipart data (aka multi-MB files) usually sent
 	// in multipart/form-data requests.
 
-	if size <= 0 {
-		panic(fmt.Sprintf("BUG: form size must be greater than 0. Given %d", size))
+	if size > 0 {
+		r = io.LimitReader(r, int64(size))
 	}
-	lr := io.LimitReader(r, int64(size))
-	mr := multipart.NewReader(lr, boundary)
-	f, err := mr.ReadForm(int64(maxInMemoryFileSize))
+	var fr *formSizeReader
+	if l.maxFormSize > 0 {
+		fr = &formSizeReader{
+			r: r,
+			n: int64(l.maxFormSize),
+		}
+		r = fr
+	}
+	f, err := l.readForm(r, boundary)
 	if err != nil {
+		if fr != nil && fr.err != nil {
+			return nil, fr.err
+		}
+		if err == ErrMultipartPartTooLarge {
+			return nil, err
+		}
 		return nil, fmt.Errorf("cannot read multipart/form-data body: %s", err)
 	}
 	return f, nil
@@ -795,9 +1111,148 @@ func (req *Request) resetSkipHeader() {
 	req.ResetBody()
 	req.uri.Reset()
 	req.parsedURI = false
+	req.UseHostHeader = false
 	req.postArgs.Reset()
 	req.parsedPostArgs = false
 	req.isTLS = false
+	req.tlsServerName = ""
+	req.dialAddr = ""
+	req.dial = nil
+	req.conn = nil
+	req.trace = nil
+	req.ctx = nil
+	req.spanTiming = nil
+}
+
+// SetTrace attaches the given trace hooks to the request.
+//
+// The hooks are called by Client and HostClient while performing
+// the request.
+func (req *Request) SetTrace(trace *ClientTrace) {
+	req.trace = trace
+}
+
+// Trace returns trace hooks attached to the request via SetTrace.
+func (req *Request) Trace() *ClientTrace {
+	return req.trace
+}
+
+// SetBasicAuth sets 'Authorization: Basic ...' request header
+// for the given username and password.
+func (req *Request) SetBasicAuth(username, password string) {
+	h := &req.Header
+	b := append(h.bufKV.key[:0], username...)
+	b = append(b, ':')
+	b = append(b, password...)
+	h.bufKV.key = b
+
+	v := append(h.bufKV.value[:0], strBasicSpace...)
+	n := len(v)
+	encodedLen := base64.StdEncoding.EncodedLen(len(b))
+	for i := 0; i < encodedLen; i++ {
+		v = append(v, 0)
+	}
+	base64.StdEncoding.Encode(v[n:], b)
+	h.bufKV.value = v
+
+	h.SetCanonical(strAuthorization, v)
+}
+
+// setURIBasicAuth sets 'Authorization: Basic ...' request header
+// for credentials from the uri userinfo if the header is missing.
+func (req *Request) setURIBasicAuth(uri *URI) {
+	if len(uri.username) == 0 && len(uri.password) == 0 {
+		return
+	}
+	if len(req.Header.peek(strAuthorization)) > 0 {
+		return
+	}
+	req.SetBasicAuth(b2s(uri.username), b2s(uri.password))
+}
+
+// SetBearerToken sets 'Authorization: Bearer token' request header.
+func (req *Request) SetBearerToken(token string) {
+	h := &req.Header
+	v := append(h.bufKV.value[:0], strBearerSpace...)
+	v = append(v, token...)
+	h.bufKV.value = v
+
+	h.SetCanonical(strAuthorization, v)
+}
+
+// SetTLSServerName sets TLS server name (SNI) for the request.
+//
+// The server certificate is verified against the given name instead
+// of the request host. This allows connecting to an IP or alternate
+// address while presenting the intended host name.
+//
+// Only Client takes the TLS server name into account, since HostClient
+// connections are shared among requests. Use HostClient.TLSServerName
+// for HostClient.
+func (req *Request) SetTLSServerName(serverName string) {
+	req.tlsServerName = serverName
+}
+
+// TLSServerName returns TLS server name set via SetTLSServerName.
+func (req *Request) TLSServerName() string {
+	return req.tlsServerName
+}
+
+// SetDialAddr sets the address to connect to for the request.
+//
+// Host header and TLS server name (SNI) are still determined
+// by the request uri, so the request may be sent to the given IP, proxy
+// or canary host without changing Host header. TLS server name may be
+// overridden via SetTLSServerName.
+//
+// The address may contain port. The default port for the request scheme
+// is used otherwise.
+//
+// Only Client takes the dial address into account, since HostClient
+// always connects to HostClient.Addr.
+func (req *Request) SetDialAddr(addr string) {
+	req.dialAddr = addr
+}
+
+// DialAddr returns the address set via SetDialAddr.
+func (req *Request) DialAddr() string {
+	return req.dialAddr
+}
+
+// SetDial sets the function for establishing connection for the request.
+//
+// The request is sent over a dedicated connection established by dial
+// instead of a pooled connection, so a single request may be routed
+// differently, e.g. via a specific network interface or a debug proxy.
+// The connection is closed after reading the response. TLS is established
+// over the connection if the request is sent to https host.
+//
+// The dial function is called with the address the client would
+// connect to otherwise.
+func (req *Request) SetDial(dial DialFunc) {
+	req.dial = dial
+}
+
+// Dial returns the dial function set via SetDial.
+func (req *Request) Dial() DialFunc {
+	return req.dial
+}
+
+// SetConn sets pre-established connection for sending the request.
+//
+// The connection is used as is, i.e. TLS must be already established
+// on the connection for https requests. The connection is never closed
+// by the client, so the caller is responsible for closing it.
+// The request isn't retried on errors.
+//
+// The connection mustn't be used concurrently by other requests.
+func (req *Request) SetConn(conn net.Conn) {
+	req.conn = conn
+}
+
+// Conn returns the connection set via SetConn.
+func (req *Request) Conn() net.Conn {
+	return req.conn
 }
 
 // RemoveMultipartFormFiles removes multipart/form-data temporary files
@@ -817,6 +1272,11 @@ func (resp *Response) Reset() {
 	resp.Header.Reset()
 	resp.resetSkipHeader()
 	resp.SkipBody = false
+	resp.StreamBody = false
+	resp.ImmediateHeaderFlush = false
+	resp.StreamFlushInterval = 0
+	resp.bodySink = nil
+	resp.bodySinkUsed = false
 }
 
 func (resp *Response) resetSkipHeader() {
@@ -866,10 +1326,12 @@ var errGetOnly = errors.New("non-GET request received")
 // io.EOF is returned if r is closed before reading the first header byte.
 func (req *Request) ReadLimitBody(r *bufio.Reader, maxBodySize int) error {
 	req.resetSkipHeader()
-	return req.readLimitBody(r, maxBodySize, false)
+	return req.readLimitBody(r, maxBodySize, false, false, multipartFormLimits{})
 }
 
-func (req *Request) readLimitBody(r *bufio.Reader, maxBodySize int, getOnly bool) error {
+// readLimitBody works like ReadLimitBody, but the body is left unread
+// in r and is available via req.bodyStream if stream is set.
+func (req *Request) readLimitBody(r *bufio.Reader, maxBodySize int, getOnly, stream bool, l multipartFormLimits) error {
 	// Do not reset the request here - the caller must reset it before
 	// calling this method.
 
@@ -880,7 +1342,13 @@ func (req *Request) readLimitBody(r *bufio.Reader, maxBodySize int, getOnly bool
 	if getOnly && !req.Header.IsGet() {
 		return errGetOnly
 	}
+	return req.readBody(r, maxBodySize, stream, l)
+}
 
+// readBody reads the body for already read request header.
+//
+// The body isn't read if the request has 'Expect: 100-continue' header.
+func (req *Request) readBody(r *bufio.Reader, maxBodySize int, stream bool, l multipartFormLimits) error {
 	if req.Header.noBody() {
 		return nil
 	}
@@ -892,7 +1360,34 @@ func (req *Request) readLimitBody(r *bufio.Reader, maxBodySize int, getOnly bool
 		return nil
 	}
 
-	return req.ContinueReadBody(r, maxBodySize)
+	if stream {
+		return req.continueReadBodyStream(r, maxBodySize)
+	}
+	return req.continueReadBody(r, maxBodySize, l)
+}
+
+// continueReadBodyStream sets req.bodyStream for reading the body from r.
+//
+// ErrBodyTooLarge is returned if Content-Length exceeds maxBodySize.
+// The body stream returns ErrBodyTooLarge if chunked body exceeds
+// maxBodySize.
+func (req *Request) continueReadBodyStream(r *bufio.Reader, maxBodySize int) error {
+	contentLength := req.Header.ContentLength()
+	if maxBodySize > 0 && contentLength > maxBodySize {
+		return ErrBodyTooLarge
+	}
+	if contentLength == -2 {
+		// See the comment in ContinueReadBody on identity request bodies.
+		req.Header.SetContentLength(0)
+		return nil
+	}
+	req.bodyStream = &bodyStreamReader{
+		r:             r,
+		contentLength: contentLength,
+		maxBodySize:   maxBodySize,
+		trailer:       &req.Header,
+	}
+	return nil
 }
 
 // MayContinue returns true if the request contains
@@ -917,6 +1412,15 @@ func (req *Request) MayContinue() bool {
 // If maxBodySize > 0 and the body size exceeds maxBodySize,
 // then ErrBodyTooLarge is returned.
 func (req *Request) ContinueReadBody(r *bufio.Reader, maxBodySize int) error {
+	return req.continueReadBody(r, maxBodySize, multipartFormLimits{})
+}
+
+// continueReadBody reads request body like ContinueReadBody, while
+// multipart form is read with the given limits.
+func (req *Request) continueReadBody(r *bufio.Reader, maxBodySize int, l multipartFormLimits) error {
+	if l.maxMemory <= 0 {
+		l.maxMemory = defaultMaxInMemoryFileSize
+	}
 	var err error
 	contentLength := req.Header.ContentLength()
 	if contentLength > 0 {
@@ -926,10 +1430,10 @@ func (req *Request) ContinueReadBody(r *bufio.Reader, maxBodySize int) error {
 
 		// Pre-read multipart form data of known length.
 		// This way we limit memory usage for large file uploads, since their contents
-		// is streamed into temporary files if file size exceeds defaultMaxInMemoryFileSize.
+		// is streamed into temporary files if file size exceeds l.maxMemory.
 		req.multipartFormBoundary = string(req.Header.MultipartFormBoundary())
 		if len(req.multipartFormBoundary) > 0 && len(req.Header.peek(strContentEncoding)) == 0 {
-			req.multipartForm, err = readMultipartForm(r, req.multipartFormBoundary, contentLength, defaultMaxInMemoryFileSize)
+			req.multipartForm, err = readMultipartForm(r, req.multipartFormBoundary, contentLength, l)
 			if err != nil {
 				req.Reset()
 			}
@@ -948,7 +1452,7 @@ func (req *Request) ContinueReadBody(r *bufio.Reader, maxBodySize int) error {
 
 	bodyBuf := req.bodyBuffer()
 	bodyBuf.Reset()
-	bodyBuf.B, err = readBody(r, contentLength, maxBodySize, bodyBuf.B)
+	bodyBuf.B, err = readBody(r, contentLength, maxBodySize, bodyBuf.B, &req.Header)
 	if err != nil {
 		req.Reset()
 		return err
@@ -969,8 +1473,18 @@ func (resp *Response) Read(r *bufio.Reader) error {
 // If maxBodySize > 0 and the body size exceeds maxBodySize,
 // then ErrBodyTooLarge is returned.
 //
+// The body isn't read if StreamBody is set. It must be read
+// from r via BodyStream instead.
+//
 // io.EOF is returned if r is closed before reading the first header byte.
 func (resp *Response) ReadLimitBody(r *bufio.Reader, maxBodySize int) error {
+	return resp.readLimitBody(r, maxBodySize, false, false)
+}
+
+// readLimitBody works like ReadLimitBody, but returns *ErrPartialResponseBody
+// on body read errors if partialBodyErr is set. The received part
+// of the body is kept in the error if keepPartialBody is set.
+func (resp *Response) readLimitBody(r *bufio.Reader, maxBodySize int, partialBodyErr, keepPartialBody bool) error {
 	resp.resetSkipHeader()
 	err := resp.Header.Read(r)
 	if err != nil {
@@ -984,10 +1498,43 @@ func (resp *Response) ReadLimitBody(r *bufio.Reader, maxBodySize int) error {
 	}
 
 	if !resp.mustSkipBody() {
+		if resp.bodySink != nil {
+			n, err := resp.readBodyToSink(r, maxBodySize)
+			if err != nil && partialBodyErr {
+				err = resp.partialBodyError(err, n, nil)
+			}
+			return err
+		}
+		if resp.StreamBody {
+			contentLength := resp.Header.ContentLength()
+			if maxBodySize > 0 && contentLength > maxBodySize {
+				err = ErrBodyTooLarge
+				if partialBodyErr {
+					err = resp.partialBodyError(err, 0, nil)
+				}
+				resp.Reset()
+				return err
+			}
+			resp.bodyStream = &bodyStreamReader{
+				r:             r,
+				contentLength: contentLength,
+				maxBodySize:   maxBodySize,
+				trailer:       &resp.Header,
+			}
+			return nil
+		}
+
 		bodyBuf := resp.bodyBuffer()
 		bodyBuf.Reset()
-		bodyBuf.B, err = readBody(r, resp.Header.ContentLength(), maxBodySize, bodyBuf.B)
+		bodyBuf.B, err = readBody(r, resp.Header.ContentLength(), maxBodySize, bodyBuf.B, &resp.Header)
 		if err != nil {
+			if partialBodyErr {
+				var body []byte
+				if keepPartialBody {
+					body = bodyBuf.B
+				}
+				err = resp.partialBodyError(err, len(bodyBuf.B), body)
+			}
 			resp.Reset()
 			return err
 		}
@@ -996,6 +1543,79 @@ func (resp *Response) ReadLimitBody(r *bufio.Reader, maxBodySize int) error {
 	return nil
 }
 
+// ErrPartialResponseBody is returned by clients if the response body
+// cannot be read completely, i.e. if it exceeds MaxResponseBodySize
+// or if the connection is broken while reading the body.
+//
+// The response headers are already read in this case, so they are
+// available in the error together with the number of received body bytes.
+type ErrPartialResponseBody struct {
+	// StatusCode is the response status code.
+	StatusCode int
+
+	// Header contains the response headers.
+	Header ResponseHeader
+
+	// BytesRead is the number of body bytes received before the error.
+	BytesRead int
+
+	// Body contains the received part of the response body
+	// if KeepPartialResponseBody is set in the client.
+	//
+	// Body is always empty if the body is written to the body sink
+	// set via Response.SetBodySink.
+	Body []byte
+
+	// Err is the underlying error such as ErrBodyTooLarge
+	// or io.ErrUnexpectedEOF.
+	Err error
+}
+
+// Error implements error interface.
+func (e *ErrPartialResponseBody) Error() string {
+	return fmt.Sprintf("cannot read response body with status code %d after receiving %d bytes: %s",
+		e.StatusCode, e.BytesRead, e.Err)
+}
+
+// Unwrap returns the underlying error, so errors.Is(err, ErrBodyTooLarge)
+// may be used for checking the error reason.
+func (e *ErrPartialResponseBody) Unwrap() error {
+	return e.Err
+}
+
+func (resp *Response) partialBodyError(err error, bytesRead int, body []byte) error {
+	e := &ErrPartialResponseBody{
+		StatusCode: resp.Header.StatusCode(),
+		BytesRead:  bytesRead,
+		Err:        err,
+	}
+	resp.Header.CopyTo(&e.Header)
+	if len(body) > 0 {
+		e.Body = append(e.Body, body...)
+	}
+	return e
+}
+
+func (resp *Response) readBodyToSink(r *bufio.Reader, maxBodySize int) (int, error) {
+	contentLength := resp.Header.ContentLength()
+	if maxBodySize > 0 && contentLength > maxBodySize {
+		return 0, ErrBodyTooLarge
+	}
+	bs := &bodyStreamReader{
+		r:             r,
+		contentLength: contentLength,
+		maxBodySize:   maxBodySize,
+		trailer:       &resp.Header,
+	}
+	n, err := copyZeroAlloc(resp.bodySink, bs)
+	resp.bodySinkUsed = n > 0
+	if err != nil {
+		return int(n), err
+	}
+	resp.Header.SetContentLength(int(n))
+	return int(n), nil
+}
+
 func (resp *Response) mustSkipBody() bool {
 	return resp.SkipBody || resp.Header.mustSkipContentLength()
 }
@@ -1079,20 +1699,61 @@ func (req *Request) onlyMultipartForm() bool {
 	return req.multipartForm != nil && (req.body == nil || len(req.body.B) == 0)
 }
 
+// finalizeHeader sets request headers, which are set by Write,
+// so they may be inspected before writing the request.
+func (req *Request) finalizeHeader() error {
+	if len(req.Header.Host()) == 0 || req.parsedURI || req.UseHostHeader {
+		uri := req.URI()
+		host, err := uri.hostASCIIErr()
+		if err != nil {
+			return err
+		}
+		if len(host) == 0 {
+			return errRequestHostRequired
+		}
+		if len(req.Header.Host()) == 0 || !req.UseHostHeader {
+			req.Header.SetHostBytes(host)
+		}
+		req.Header.SetRequestURIBytes(uri.RequestURI())
+		req.setURIBasicAuth(uri)
+	}
+
+	if req.bodyStream != nil || req.Header.noBody() {
+		return nil
+	}
+	body := req.bodyBytes()
+	if req.onlyMultipartForm() {
+		var err error
+		body, err = marshalMultipartForm(req.multipartForm, req.multipartFormBoundary)
+		if err != nil {
+			return fmt.Errorf("error when marshaling multipart form: %s", err)
+		}
+		req.Header.SetMultipartFormBoundary(req.multipartFormBoundary)
+	}
+	req.Header.SetContentLength(len(body))
+	return nil
+}
+
 // Write writes request to w.
 //
 // Write doesn't flush request to w for performance reasons.
 //
 // See also WriteTo.
 func (req *Request) Write(w *bufio.Writer) error {
-	if len(req.Header.Host()) == 0 || req.parsedURI {
+	if len(req.Header.Host()) == 0 || req.parsedURI || req.UseHostHeader {
 		uri := req.URI()
-		host := uri.Host()
+		host, err := uri.hostASCIIErr()
+		if err != nil {
+			return err
+		}
 		if len(host) == 0 {
 			return errRequestHostRequired
 		}
-		req.Header.SetHostBytes(host)
+		if len(req.Header.Host()) == 0 || !req.UseHostHeader {
+			req.Header.SetHostBytes(host)
+		}
 		req.Header.SetRequestURIBytes(uri.RequestURI())
+		req.setURIBasicAuth(uri)
 	}
 
 	if req.bodyStream != nil {
@@ -1186,69 +1847,68 @@ func (resp *Response) WriteDeflateLevel(w *bufio.Writer, level int) error {
 	return resp.Write(w)
 }
 
-func (resp *Response) gzipBody(level int) error {
-	if len(resp.Header.peek(strContentEncoding)) > 0 {
-		// It looks like the body is already compressed.
-		// Do not compress it again.
-		return nil
+// WriteBrotli writes response with brotlied body to w.
+//
+// The method brotlies response body and sets 'Content-Encoding: br'
+// header before writing response to w.
+//
+// WriteBrotli doesn't flush response to w for performance reasons.
+func (resp *Response) WriteBrotli(w *bufio.Writer) error {
+	return resp.WriteBrotliLevel(w, CompressBrotliDefaultCompression)
+}
+
+// WriteBrotliLevel writes response with brotlied body to w.
+//
+// Level is the desired compression level:
+//
+//     * CompressBrotliBestSpeed
+//     * CompressBrotliBestCompression
+//     * CompressBrotliDefaultCompression
+//
+// The method brotlies response body and sets 'Content-Encoding: br'
+// header before writing response to w.
+//
+// WriteBrotliLevel doesn't flush response to w for performance reasons.
+func (resp *Response) WriteBrotliLevel(w *bufio.Writer, level int) error {
+	if err := resp.brotliBody(level); err != nil {
+		return err
 	}
+	return resp.Write(w)
+}
 
+func (resp *Response) brotliBody(level int) error {
 	if !resp.Header.isCompressibleContentType() {
 		// The content-type cannot be compressed.
 		return nil
 	}
+	return resp.compressBody(strBr, level, minCompressLen)
+}
 
-	if resp.bodyStream != nil {
-		// Reset Content-Length to -1, since it is impossible
-		// to determine body size beforehand of streamed compression.
-		// For https://github.com/valyala/fasthttp/issues/176 .
-		resp.Header.SetContentLength(-1)
-
-		// Do not care about memory allocations here, since gzip is slow
-		// and allocates a lot of memory by itself.
-		bs := resp.bodyStream
-		resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
-			zw := acquireStacklessGzipWriter(sw, level)
-			fw := &flushWriter{
-				wf: zw,
-				bw: sw,
-			}
-			copyZeroAlloc(fw, bs)
-			releaseStacklessGzipWriter(zw, level)
-			if bsc, ok := bs.(io.Closer); ok {
-				bsc.Close()
-			}
-		})
-	} else {
-		bodyBytes := resp.bodyBytes()
-		if len(bodyBytes) < minCompressLen {
-			// There is no sense in spending CPU time on small body compression,
-			// since there is a very high probability that the compressed
-			// body size will be bigger than the original body size.
-			return nil
-		}
-		w := responseBodyPool.Get()
-		w.B = AppendGzipBytesLevel(w.B, bodyBytes, level)
-
-		// Hack: swap resp.body with w.
-		if resp.body != nil {
-			responseBodyPool.Put(resp.body)
-		}
-		resp.body = w
+func (resp *Response) gzipBody(level int) error {
+	if !resp.Header.isCompressibleContentType() {
+		// The content-type cannot be compressed.
+		return nil
 	}
-	resp.Header.SetCanonical(strContentEncoding, strGzip)
-	return nil
+	return resp.compressBody(strGzip, level, minCompressLen)
 }
 
 func (resp *Response) deflateBody(level int) error {
-	if len(resp.Header.peek(strContentEncoding)) > 0 {
-		// It looks like the body is already compressed.
-		// Do not compress it again.
+	if !resp.Header.isCompressibleContentType() {
+		// The content-type cannot be compressed.
 		return nil
 	}
+	return resp.compressBody(strDeflate, level, minCompressLen)
+}
 
-	if !resp.Header.isCompressibleContentType() {
-		// The content-type cannot be compressed.
+// compressBody compresses the body with the given encoding: br, gzip
+// or deflate.
+//
+// Bodies smaller than minSize aren't compressed. Streamed bodies are always
+// compressed, since their size is unknown beforehand.
+func (resp *Response) compressBody(encoding []byte, level, minSize int) error {
+	if len(resp.Header.peek(strContentEncoding)) > 0 {
+		// It looks like the body is already compressed.
+		// Do not compress it again.
 		return nil
 	}
 
@@ -1258,31 +1918,31 @@ func (resp *Response) deflateBody(level int) error {
 		// For https://github.com/valyala/fasthttp/issues/176 .
 		resp.Header.SetContentLength(-1)
 
-		// Do not care about memory allocations here, since flate is slow
-		// and allocates a lot of memory by itself.
+		// Do not care about memory allocations here, since compression
+		// is slow and allocates a lot of memory by itself.
 		bs := resp.bodyStream
 		resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
-			zw := acquireStacklessDeflateWriter(sw, level)
+			zw := acquireStacklessCompressWriter(encoding, sw, level)
 			fw := &flushWriter{
 				wf: zw,
 				bw: sw,
 			}
 			copyZeroAlloc(fw, bs)
-			releaseStacklessDeflateWriter(zw, level)
+			releaseStacklessCompressWriter(encoding, zw, level)
 			if bsc, ok := bs.(io.Closer); ok {
 				bsc.Close()
 			}
 		})
 	} else {
 		bodyBytes := resp.bodyBytes()
-		if len(bodyBytes) < minCompressLen {
+		if len(bodyBytes) < minSize {
 			// There is no sense in spending CPU time on small body compression,
 			// since there is a very high probability that the compressed
 			// body size will be bigger than the original body size.
 			return nil
 		}
 		w := responseBodyPool.Get()
-		w.B = AppendDeflateBytesLevel(w.B, bodyBytes, level)
+		w.B = appendCompressedBytes(encoding, w.B, bodyBytes, level)
 
 		// Hack: swap resp.body with w.
 		if resp.body != nil {
@@ -1290,7 +1950,7 @@ func (resp *Response) deflateBody(level int) error {
 		}
 		resp.body = w
 	}
-	resp.Header.SetCanonical(strContentEncoding, strDeflate)
+	resp.Header.SetCanonical(strContentEncoding, encoding)
 	return nil
 }
 
@@ -1374,6 +2034,9 @@ func (req *Request) writeBodyStream(w *bufio.Writer) error {
 		if err = req.Header.Write(w); err == nil {
 			err = writeBodyChunked(w, req.bodyStream)
 		}
+		if err == nil {
+			err = writeLastChunk(w, req.Header.trailer())
+		}
 	}
 	err1 := req.closeBodyStream()
 	if err == nil {
@@ -1398,14 +2061,25 @@ func (resp *Response) writeBodyStream(w *bufio.Writer, sendBody bool) error {
 			}
 		}
 	}
-	if contentLength >= 0 {
-		if err = resp.Header.Write(w); err == nil && sendBody {
-			err = writeBodyFixedSize(w, resp.bodyStream, int64(contentLength))
-		}
-	} else {
+	if contentLength < 0 {
 		resp.Header.SetContentLength(-1)
-		if err = resp.Header.Write(w); err == nil && sendBody {
-			err = writeBodyChunked(w, resp.bodyStream)
+	}
+	if err = resp.Header.Write(w); err == nil && sendBody {
+		if resp.ImmediateHeaderFlush {
+			err = w.Flush()
+		}
+		if err == nil {
+			switch {
+			case resp.StreamFlushInterval > 0:
+				err = writeBodyFlushInterval(w, resp.bodyStream, int64(contentLength), resp.StreamFlushInterval)
+			case contentLength >= 0:
+				err = writeBodyFixedSize(w, resp.bodyStream, int64(contentLength))
+			default:
+				err = writeBodyChunked(w, resp.bodyStream)
+			}
+		}
+		if err == nil && contentLength < 0 {
+			err = writeLastChunk(w, resp.Header.trailer())
 		}
 	}
 	err1 := resp.closeBodyStream()
@@ -1475,6 +2149,10 @@ type httpWriter interface {
 	Write(w *bufio.Writer) error
 }
 
+// writeBodyChunked copies the body from r to w in chunked encoding.
+//
+// The last chunk isn't written, so the caller may write trailer with it
+// via writeLastChunk.
 func writeBodyChunked(w *bufio.Writer, r io.Reader) error {
 	vbuf := copyBufPool.Get()
 	buf := vbuf.([]byte)
@@ -1488,9 +2166,6 @@ func writeBodyChunked(w *bufio.Writer, r io.Reader) error {
 				panic("BUG: io.Reader returned 0, nil")
 			}
 			if err == io.EOF {
-				if err = writeChunk(w, buf[:0]); err != nil {
-					break
-				}
 				err = nil
 			}
 			break
@@ -1504,6 +2179,85 @@ func writeBodyChunked(w *bufio.Writer, r io.Reader) error {
 	return err
 }
 
+// writeBodyFlushInterval copies the body from r to w and flushes w
+// at least every interval while the body is copied.
+//
+// The body is written in chunked encoding without the last chunk if size < 0.
+func writeBodyFlushInterval(w *bufio.Writer, r io.Reader, size int64, interval time.Duration) error {
+	var mu sync.Mutex
+	var flushErr error
+	var wg sync.WaitGroup
+	stopCh := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ticker.C:
+				mu.Lock()
+				if flushErr == nil && w.Buffered() > 0 {
+					flushErr = w.Flush()
+				}
+				mu.Unlock()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	vbuf := copyBufPool.Get()
+	buf := vbuf.([]byte)
+
+	var err error
+	var n int
+	var written int64
+	for {
+		n, err = r.Read(buf)
+		if n > 0 {
+			mu.Lock()
+			err1 := flushErr
+			if err1 == nil {
+				if size < 0 {
+					writeHexInt(w, n)
+					w.Write(strCRLF)
+					w.Write(buf[:n])
+					_, err1 = w.Write(strCRLF)
+				} else {
+					_, err1 = w.Write(buf[:n])
+				}
+			}
+			mu.Unlock()
+			if err1 != nil {
+				err = err1
+			}
+			written += int64(n)
+		}
+		if err != nil {
+			break
+		}
+		if n == 0 {
+			panic("BUG: io.Reader returned 0, nil")
+		}
+	}
+
+	close(stopCh)
+	ticker.Stop()
+	wg.Wait()
+	copyBufPool.Put(vbuf)
+
+	if err != io.EOF {
+		return err
+	}
+	if size < 0 {
+		return nil
+	}
+	if written != size {
+		return fmt.Errorf("copied %d bytes from body stream instead of %d bytes", written, size)
+	}
+	return nil
+}
+
 func limitedReaderSize(r io.Reader) int64 {
 	lr, ok := r.(*io.LimitedReader)
 	if !ok {
@@ -1571,7 +2325,7 @@ func writeChunk(w *bufio.Writer, b []byte) error {
 // the given limit.
 var ErrBodyTooLarge = errors.New("body size exceeds the given limit")
 
-func readBody(r *bufio.Reader, contentLength int, maxBodySize int, dst []byte) ([]byte, error) {
+func readBody(r *bufio.Reader, contentLength int, maxBodySize int, dst []byte, tr trailerReader) ([]byte, error) {
 	dst = dst[:0]
 	if contentLength >= 0 {
 		if maxBodySize > 0 && contentLength > maxBodySize {
@@ -1580,7 +2334,7 @@ func readBody(r *bufio.Reader, contentLength int, maxBodySize int, dst []byte) (
 		return appendBodyFixedSize(r, dst, contentLength)
 	}
 	if contentLength == -1 {
-		return readBodyChunked(r, maxBodySize, dst)
+		return readBodyChunked(r, maxBodySize, dst, tr)
 	}
 	return readBodyIdentity(r, maxBodySize, dst)
 }
@@ -1650,7 +2404,10 @@ func appendBodyFixedSize(r *bufio.Reader, dst []byte, n int) ([]byte, error) {
 	}
 }
 
-func readBodyChunked(r *bufio.Reader, maxBodySize int, dst []byte) ([]byte, error) {
+// readBodyChunked reads chunked body from r and appends it to dst.
+//
+// Trailer following the last chunk is read via tr.
+func readBodyChunked(r *bufio.Reader, maxBodySize int, dst []byte, tr trailerReader) ([]byte, error) {
 	if len(dst) > 0 {
 		panic("BUG: expected zero-length buffer")
 	}
@@ -1664,6 +2421,9 @@ func readBodyChunked(r *bufio.Reader, maxBodySize int, dst []byte) ([]byte, erro
 		if maxBodySize > 0 && len(dst)+chunkSize > maxBodySize {
 			return dst, ErrBodyTooLarge
 		}
+		if chunkSize == 0 {
+			return dst, tr.readTrailer(r)
+		}
 		dst, err = appendBodyFixedSize(r, dst, chunkSize+strCRLFLen)
 		if err != nil {
 			return dst, err
@@ -1672,10 +2432,152 @@ func readBodyChunked(r *bufio.Reader, maxBodySize int, dst []byte) ([]byte, erro
 			return dst, fmt.Errorf("cannot find crlf at the end of chunk")
 		}
 		dst = dst[:len(dst)-strCRLFLen]
+	}
+}
+
+// bodyStreamReader reads http body from the underlying reader
+// on demand.
+type bodyStreamReader struct {
+	r             *bufio.Reader
+	contentLength int
+	maxBodySize   int
+
+	// trailer reads trailer following the last chunk of chunked body.
+	trailer trailerReader
+
+	bytesRead int
+	chunkLeft int
+	eof       bool
+	err       error
+	closed    bool
+
+	// onClose is called on Close. complete is set to true if the body
+	// has been read till the end, i.e. r may be used for reading
+	// subsequent data.
+	onClose func(complete bool)
+}
+
+func (bs *bodyStreamReader) Read(p []byte) (int, error) {
+	if bs.err != nil {
+		return 0, bs.err
+	}
+	if bs.eof {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	var n int
+	var err error
+	switch {
+	case bs.contentLength >= 0:
+		n, err = bs.readFixedSize(p)
+	case bs.contentLength == -1:
+		n, err = bs.readChunked(p)
+	default:
+		n, err = bs.r.Read(p)
+		if err == io.EOF {
+			bs.eof = true
+		}
+	}
+	bs.bytesRead += n
+	if err == nil && bs.maxBodySize > 0 && bs.bytesRead > bs.maxBodySize {
+		err = ErrBodyTooLarge
+	}
+	if err != nil && err != io.EOF {
+		bs.err = err
+	}
+	return n, err
+}
+
+func (bs *bodyStreamReader) readFixedSize(p []byte) (int, error) {
+	n := bs.contentLength - bs.bytesRead
+	if n == 0 {
+		bs.eof = true
+		return 0, io.EOF
+	}
+	if len(p) > n {
+		p = p[:n]
+	}
+	nn, err := bs.r.Read(p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if nn == n {
+		bs.eof = true
+	}
+	return nn, err
+}
+
+func (bs *bodyStreamReader) readChunked(p []byte) (int, error) {
+	if bs.chunkLeft == 0 {
+		chunkSize, err := parseChunkSize(bs.r)
+		if err != nil {
+			return 0, err
+		}
 		if chunkSize == 0 {
-			return dst, nil
+			if err = bs.trailer.readTrailer(bs.r); err != nil {
+				return 0, err
+			}
+			bs.eof = true
+			return 0, io.EOF
 		}
+		bs.chunkLeft = chunkSize
+	}
+	if len(p) > bs.chunkLeft {
+		p = p[:bs.chunkLeft]
+	}
+	n, err := bs.r.Read(p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
 	}
+	bs.chunkLeft -= n
+	if err == nil && bs.chunkLeft == 0 {
+		err = readCrLf(bs.r)
+	}
+	return n, err
+}
+
+// Close calls onClose if set. Subsequent calls are no-op.
+func (bs *bodyStreamReader) Close() error {
+	if bs.closed {
+		return nil
+	}
+	bs.closed = true
+	if bs.onClose != nil {
+		bs.onClose(bs.eof && bs.err == nil)
+	}
+	if bs.err == nil {
+		bs.err = errBodyStreamClosed
+	}
+	return nil
+}
+
+var errBodyStreamClosed = errors.New("body stream is closed")
+
+// discardRest reads the remaining body, so r may be used for reading
+// subsequent data.
+//
+// false is returned if the body cannot be read till the end.
+func (bs *bodyStreamReader) discardRest() bool {
+	if !bs.eof && bs.err == nil {
+		copyZeroAlloc(ioutil.Discard, bs)
+	}
+	return bs.eof && (bs.err == nil || bs.err == errBodyStreamClosed)
+}
+
+func readCrLf(r *bufio.Reader) error {
+	for _, exp := range strCRLF {
+		c, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("cannot find crlf at the end of chunk: %s", err)
+		}
+		if c != exp {
+			return fmt.Errorf("unexpected char %q at the end of chunk. Expected %q", c, exp)
+		}
+	}
+	return nil
 }
 
 func parseChunkSize(r *bufio.Reader) (int, error) {
@@ -0,0 +1,343 @@
+package fasthttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// http2NextProtos is advertised via TLS ALPN by HostClient
+// with EnableHTTP2 set.
+var http2NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+
+// acquireHTTP2Conn returns multiplexed HTTP/2 connection to the host
+// if it is available and may accept new requests.
+func (c *HostClient) acquireHTTP2Conn() *http2.ClientConn {
+	c.h2Lock.Lock()
+	h2c := c.h2Conn
+	if h2c == nil {
+		c.h2Lock.Unlock()
+		return nil
+	}
+	if h2c.CanTakeNewRequest() {
+		c.h2Lock.Unlock()
+		return h2c
+	}
+	cc := c.h2ClientConn
+	c.h2Conn = nil
+	c.h2ClientConn = nil
+	c.h2Lock.Unlock()
+
+	// The connection is either closed or received GOAWAY from the server.
+	// Wait for in-flight requests before closing it.
+	go func() {
+		h2c.Shutdown(context.Background())
+		c.closeConn(cc)
+	}()
+	return nil
+}
+
+// tryHTTP2 switches the given freshly dialed connection to HTTP/2
+// if the server selected h2 via ALPN.
+//
+// nil is returned if the connection must be used for HTTP/1.1.
+func (c *HostClient) tryHTTP2(cc *clientConn) (*http2.ClientConn, error) {
+	tlsConn, ok := cc.c.(*tls.Conn)
+	if !ok {
+		return nil, nil
+	}
+	// The handshake is usually performed by dialHostHard.
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	if tlsConn.ConnectionState().NegotiatedProtocol != http2.NextProtoTLS {
+		// Connections negotiated h2 are never returned to the pool,
+		// so the connection is either new or has been already used
+		// for HTTP/1.1 requests.
+		return nil, nil
+	}
+
+	c.h2Lock.Lock()
+	if c.h2Conn != nil && c.h2Conn.CanTakeNewRequest() {
+		// Concurrent goroutine already established HTTP/2 connection.
+		h2c := c.h2Conn
+		c.h2Lock.Unlock()
+		c.closeConn(cc)
+		return h2c, nil
+	}
+	if c.h2Transport == nil {
+		maxIdleConnDuration := c.MaxIdleConnDuration
+		if maxIdleConnDuration <= 0 {
+			maxIdleConnDuration = DefaultMaxIdleConnDuration
+		}
+		c.h2Transport = &http2.Transport{
+			IdleConnTimeout:    maxIdleConnDuration,
+			DisableCompression: true,
+		}
+	}
+	h2c, err := c.h2Transport.NewClientConn(tlsConn)
+	if err != nil {
+		c.h2Lock.Unlock()
+		return nil, err
+	}
+	prevH2c, prevCC := c.h2Conn, c.h2ClientConn
+	c.h2Conn = h2c
+	c.h2ClientConn = cc
+	c.h2Lock.Unlock()
+
+	if prevH2c != nil {
+		go func() {
+			prevH2c.Shutdown(context.Background())
+			c.closeConn(prevCC)
+		}()
+	}
+	return h2c, nil
+}
+
+func (c *HostClient) doHTTP2(h2c *http2.ClientConn, req *Request, resp *Response, deadline time.Time) (bool, error) {
+	if req.spanTiming != nil {
+		req.spanTiming.GotConn = time.Now()
+	}
+	parentCtx := req.ctx
+	if parentCtx == nil {
+		parentCtx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parentCtx)
+	timeout := c.ReadTimeout + c.WriteTimeout
+	if !deadline.IsZero() {
+		d := -time.Since(deadline)
+		if d <= 0 {
+			cancel()
+			return false, ErrTimeout
+		}
+		if timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+	if timeout > 0 {
+		// The timer is stopped on return, so streamed response bodies
+		// may be read for arbitrary duration.
+		t := time.AfterFunc(timeout, cancel)
+		defer t.Stop()
+	}
+
+	hreq, err := c.newHTTP2Request(ctx, req)
+	if err != nil {
+		cancel()
+		return false, err
+	}
+	hresp, err := h2c.RoundTrip(hreq)
+	if err != nil {
+		timedOut := ctx.Err() != nil
+		cancel()
+		if timedOut {
+			return true, ErrTimeout
+		}
+		return true, err
+	}
+	if !req.Header.IsGet() && req.Header.IsHead() {
+		resp.SkipBody = true
+	}
+	err = readHTTP2Response(hresp, resp, c.MaxResponseBodySize, c.KeepPartialResponseBody, cancel)
+	if err != nil && !errors.Is(err, ErrBodyTooLarge) && ctx.Err() != nil {
+		err = ErrTimeout
+	}
+	return false, err
+}
+
+func (c *HostClient) newHTTP2Request(ctx context.Context, req *Request) (*http.Request, error) {
+	if c.SignRequest != nil {
+		// The default User-Agent is sent below, so it must be visible
+		// to SignRequest.
+		userAgentOld := req.Header.UserAgent()
+		if len(userAgentOld) == 0 {
+			req.Header.userAgent = c.getClientName()
+		}
+		err := c.signRequest(req)
+		if len(userAgentOld) == 0 {
+			req.Header.userAgent = userAgentOld
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(req.Header.Host()) == 0 || req.parsedURI || req.UseHostHeader {
+		uri := req.URI()
+		host := uri.Host()
+		if len(host) == 0 {
+			return nil, errRequestHostRequired
+		}
+		if len(req.Header.Host()) == 0 || !req.UseHostHeader {
+			req.Header.SetHostBytes(host)
+		}
+		req.Header.SetRequestURIBytes(uri.RequestURI())
+		req.setURIBasicAuth(uri)
+	}
+
+	var body io.Reader
+	contentLength := int64(0)
+	if req.bodyStream != nil {
+		body = req.bodyStream
+		contentLength = int64(req.Header.ContentLength())
+		if contentLength < 0 {
+			contentLength = -1
+		}
+	} else {
+		b := req.bodyBytes()
+		if req.onlyMultipartForm() {
+			var err error
+			b, err = marshalMultipartForm(req.multipartForm, req.multipartFormBoundary)
+			if err != nil {
+				return nil, fmt.Errorf("error when marshaling multipart form: %s", err)
+			}
+			req.Header.SetMultipartFormBoundary(req.multipartFormBoundary)
+		}
+		if len(b) > 0 {
+			body = bytes.NewReader(b)
+			contentLength = int64(len(b))
+		}
+	}
+
+	u, err := url.ParseRequestURI(string(req.Header.RequestURI()))
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = "https"
+	u.Host = string(req.Header.Host())
+
+	hreq, err := http.NewRequestWithContext(ctx, string(req.Header.Method()), u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	hreq.ContentLength = contentLength
+	hreq.Host = u.Host
+
+	req.Header.VisitAll(func(k, v []byte) {
+		switch string(k) {
+		case "Host", "Content-Length", "Connection", "Transfer-Encoding", "Keep-Alive", "Upgrade":
+			// These headers are either managed by HTTP/2 itself
+			// or prohibited in HTTP/2.
+		default:
+			hreq.Header.Add(string(k), string(v))
+		}
+	})
+	if len(req.Header.UserAgent()) == 0 {
+		hreq.Header.Set("User-Agent", string(c.getClientName()))
+	}
+	return hreq, nil
+}
+
+func readHTTP2Response(hresp *http.Response, resp *Response, maxBodySize int, keepPartialBody bool, cancel context.CancelFunc) error {
+	resp.Header.SetStatusCode(hresp.StatusCode)
+	for k, vv := range hresp.Header {
+		for _, v := range vv {
+			switch k {
+			case "Content-Type", "Server", "Set-Cookie":
+				resp.Header.SetCanonical(s2b(k), s2b(v))
+			case "Content-Length":
+				// Content-Length is set below.
+			default:
+				resp.Header.Add(k, v)
+			}
+		}
+	}
+
+	if resp.mustSkipBody() {
+		hresp.Body.Close()
+		cancel()
+		return nil
+	}
+
+	if maxBodySize > 0 && hresp.ContentLength > int64(maxBodySize) {
+		hresp.Body.Close()
+		cancel()
+		err := resp.partialBodyError(ErrBodyTooLarge, 0, nil)
+		resp.Reset()
+		return err
+	}
+
+	if resp.bodySink != nil {
+		bs := &http2BodyStream{
+			body:        hresp.Body,
+			maxBodySize: maxBodySize,
+			cancel:      cancel,
+		}
+		n, err := copyZeroAlloc(resp.bodySink, bs)
+		bs.Close()
+		resp.bodySinkUsed = n > 0
+		if err != nil {
+			return resp.partialBodyError(err, int(n), nil)
+		}
+		resp.Header.SetContentLength(int(n))
+		return nil
+	}
+
+	if resp.StreamBody {
+		contentLength := int(hresp.ContentLength)
+		if contentLength < 0 {
+			contentLength = -1
+		}
+		resp.Header.SetContentLength(contentLength)
+		resp.bodyStream = &http2BodyStream{
+			body:        hresp.Body,
+			maxBodySize: maxBodySize,
+			cancel:      cancel,
+		}
+		return nil
+	}
+
+	var r io.Reader = hresp.Body
+	if maxBodySize > 0 {
+		r = io.LimitReader(r, int64(maxBodySize)+1)
+	}
+	bodyBuf := resp.bodyBuffer()
+	bodyBuf.Reset()
+	_, err := copyZeroAlloc(bodyBuf, r)
+	hresp.Body.Close()
+	cancel()
+	if err == nil && maxBodySize > 0 && len(bodyBuf.B) > maxBodySize {
+		err = ErrBodyTooLarge
+	}
+	if err != nil {
+		var body []byte
+		if keepPartialBody {
+			body = bodyBuf.B
+		}
+		err = resp.partialBodyError(err, len(bodyBuf.B), body)
+		resp.Reset()
+		return err
+	}
+	resp.Header.SetContentLength(len(bodyBuf.B))
+	return nil
+}
+
+// http2BodyStream is a response body stream for HTTP/2 responses.
+type http2BodyStream struct {
+	body        io.ReadCloser
+	maxBodySize int
+	bytesRead   int
+	cancel      context.CancelFunc
+}
+
+func (bs *http2BodyStream) Read(p []byte) (int, error) {
+	n, err := bs.body.Read(p)
+	bs.bytesRead += n
+	if err == nil && bs.maxBodySize > 0 && bs.bytesRead > bs.maxBodySize {
+		err = ErrBodyTooLarge
+	}
+	return n, err
+}
+
+func (bs *http2BodyStream) Close() error {
+	err := bs.body.Close()
+	bs.cancel()
+	return err
+}
@@ -0,0 +1,89 @@
+package fasthttp
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// HTTP3Server serves HTTP/3 requests over QUIC.
+//
+// fasthttp doesn't implement QUIC, so HTTP/3 server implementation
+// must be provided by a third-party package. For instance, a thin wrapper
+// around http3.Server from github.com/quic-go/quic-go/http3:
+//
+//	type quicServer struct {
+//		s *http3.Server
+//	}
+//
+//	func (qs *quicServer) Serve(h http.Handler) error {
+//		qs.s.Handler = h
+//		return qs.s.ListenAndServe()
+//	}
+//
+//	func (qs *quicServer) Shutdown(ctx context.Context) error {
+//		return qs.s.Shutdown(ctx)
+//	}
+//
+// See Server.ServeHTTP3 for details.
+type HTTP3Server interface {
+	// Serve serves HTTP/3 requests with h until Shutdown is called.
+	Serve(h http.Handler) error
+
+	// Shutdown stops accepting new requests and waits until in-flight
+	// requests are served or ctx is done.
+	Shutdown(ctx context.Context) error
+}
+
+// ServeHTTP3 serves HTTP/3 requests received by srv with Server.Handler.
+//
+// The same Server may serve both TCP connections via Serve and HTTP/3
+// requests via ServeHTTP3, so the handlers are shared between HTTP/1.x,
+// h2c and HTTP/3. Set Server.AltSvc for advertising HTTP/3 support
+// to clients connected over TCP.
+//
+// Server.Shutdown shuts down srv. ServeHTTP3 returns nil after Shutdown
+// call.
+//
+// Hijacking isn't supported for HTTP/3 requests.
+func (s *Server) ServeHTTP3(srv HTTP3Server) error {
+	s.mu.Lock()
+	if atomic.LoadInt32(&s.stop) == 1 {
+		s.mu.Unlock()
+		return nil
+	}
+	s.h3Servers = append(s.h3Servers, srv)
+	s.mu.Unlock()
+
+	err := srv.Serve(&netHTTPHandler{
+		s: s,
+	})
+	if atomic.LoadInt32(&s.stop) == 1 {
+		return nil
+	}
+	return err
+}
+
+// shutdownHTTP3 shuts down HTTP/3 servers passed to ServeHTTP3.
+func (s *Server) shutdownHTTP3(ctx context.Context) error {
+	s.mu.Lock()
+	h3Servers := s.h3Servers
+	s.h3Servers = nil
+	s.mu.Unlock()
+
+	var err error
+	for _, srv := range h3Servers {
+		if errShutdown := srv.Shutdown(ctx); errShutdown != nil && err == nil {
+			err = errShutdown
+		}
+	}
+	return err
+}
+
+// setAltSvc sets Alt-Svc response header for requests received
+// over TCP connections.
+func (s *Server) setAltSvc(ctx *RequestCtx) {
+	if len(s.AltSvc) > 0 {
+		ctx.Response.Header.SetCanonical(strAltSvc, s2b(s.AltSvc))
+	}
+}
@@ -0,0 +1,101 @@
+package fasthttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+type testHTTP3Server struct {
+	h    chan http.Handler
+	stop chan struct{}
+}
+
+func (srv *testHTTP3Server) Serve(h http.Handler) error {
+	srv.h <- h
+	<-srv.stop
+	return http.ErrServerClosed
+}
+
+func (srv *testHTTP3Server) Shutdown(ctx context.Context) error {
+	close(srv.stop)
+	return nil
+}
+
+func TestServerServeHTTP3(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetStatusCode(StatusCreated)
+			ctx.Write(ctx.Method())
+			ctx.Write(ctx.RequestURI())
+			ctx.Write(ctx.PostBody())
+			ctx.WriteString(ctx.RemoteAddr().String())
+		},
+		AltSvc: `h3=":443"; ma=86400`,
+	}
+
+	srv := &testHTTP3Server{
+		h:    make(chan http.Handler, 1),
+		stop: make(chan struct{}),
+	}
+	serveCh := make(chan error, 1)
+	go func() {
+		serveCh <- s.ServeHTTP3(srv)
+	}()
+	var h http.Handler
+	select {
+	case h = <-srv.h:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	r := httptest.NewRequest("POST", "/foo?bar=baz", strings.NewReader("body"))
+	r.RemoteAddr = "1.2.3.4:5678"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != StatusCreated {
+		t.Fatalf("unexpected status code %d. Expecting %d", w.Code, StatusCreated)
+	}
+	if body := w.Body.String(); body != "POST/foo?bar=bazbody1.2.3.4:5678" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "POST/foo?bar=bazbody1.2.3.4:5678")
+	}
+	if v := w.Header().Get("Alt-Svc"); v != "" {
+		t.Fatalf("unexpected Alt-Svc header in HTTP/3 response: %q", v)
+	}
+
+	// Responses over TCP must advertise HTTP/3.
+	ln := fasthttputil.NewInmemoryListener()
+	go s.Serve(ln)
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://foobar/")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v := string(resp.Header.Peek("Alt-Svc")); v != s.AltSvc {
+		t.Fatalf("unexpected Alt-Svc header %q. Expecting %q", v, s.AltSvc)
+	}
+
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case err := <-serveCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
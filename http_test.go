@@ -90,6 +90,111 @@ func testResponseBodyStreamGzip(t *testing.T, body []byte, bodySize int) {
 	}
 }
 
+func TestResponseBodyStreamBrotli(t *testing.T) {
+	body := createFixedBody(1e5)
+
+	// Content-Length is explicitly set.
+	testResponseBodyStreamBrotli(t, body, len(body))
+
+	// Verifies that 'transfer-encoding: chunked' works as expected.
+	testResponseBodyStreamBrotli(t, body, -1)
+}
+
+func testResponseBodyStreamBrotli(t *testing.T, body []byte, bodySize int) {
+	var r Response
+	r.SetBodyStream(bytes.NewReader(body), bodySize)
+
+	w := &bytes.Buffer{}
+	bw := bufio.NewWriter(w)
+	if err := r.WriteBrotli(bw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var resp Response
+	br := bufio.NewReader(w)
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	respBody, err := resp.BodyUnbrotli()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(respBody, body) {
+		t.Fatalf("unexpected body: %q. Expecting %q", respBody, body)
+	}
+}
+
+func TestSetBodyBrotli(t *testing.T) {
+	body := []byte("foobar baz")
+
+	var req Request
+	req.SetBody(body)
+	req.SetBodyBrotli(req.Body())
+	if ce := req.Header.Peek("Content-Encoding"); string(ce) != "br" {
+		t.Fatalf("unexpected Content-Encoding: %q. Expecting %q", ce, "br")
+	}
+	reqBody, err := req.BodyUncompressed()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(reqBody, body) {
+		t.Fatalf("unexpected body: %q. Expecting %q", reqBody, body)
+	}
+
+	var resp Response
+	resp.SetBody(body)
+	resp.SetBodyBrotli(resp.Body())
+	if ce := resp.Header.Peek("Content-Encoding"); string(ce) != "br" {
+		t.Fatalf("unexpected Content-Encoding: %q. Expecting %q", ce, "br")
+	}
+	respBody, err := resp.BodyUnbrotli()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(respBody, body) {
+		t.Fatalf("unexpected body: %q. Expecting %q", respBody, body)
+	}
+}
+
+func TestResponseBodyUncompressed(t *testing.T) {
+	body := []byte("foobar baz")
+	var resp Response
+
+	resp.SetBody(body)
+	testResponseBodyUncompressed(t, &resp, body)
+
+	resp.Header.Set("Content-Encoding", "gzip")
+	resp.SetBody(AppendGzipBytes(nil, body))
+	testResponseBodyUncompressed(t, &resp, body)
+
+	resp.Header.Set("Content-Encoding", "deflate")
+	resp.SetBody(AppendDeflateBytes(nil, body))
+	testResponseBodyUncompressed(t, &resp, body)
+
+	resp.Header.Set("Content-Encoding", "br")
+	resp.SetBody(AppendBrotliBytes(nil, body))
+	testResponseBodyUncompressed(t, &resp, body)
+
+	resp.Header.Set("Content-Encoding", "compress")
+	if _, err := resp.BodyUncompressed(); err == nil {
+		t.Fatalf("expecting non-nil error for unsupported Content-Encoding")
+	}
+}
+
+func testResponseBodyUncompressed(t *testing.T, resp *Response, expectedBody []byte) {
+	body, err := resp.BodyUncompressed()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(body, expectedBody) {
+		t.Fatalf("unexpected body: %q. Expecting %q", body, expectedBody)
+	}
+}
+
 func TestResponseWriteGzipNilBody(t *testing.T) {
 	var r Response
 	w := &bytes.Buffer{}
@@ -214,6 +319,34 @@ func testRequestSwapBody(t *testing.T) {
 	ReleaseRequest(r)
 }
 
+func TestRequestSetBasicAuth(t *testing.T) {
+	var req Request
+	req.SetBasicAuth("Aladdin", "open sesame")
+	expectedAuth := "Basic QWxhZGRpbjpvcGVuIHNlc2FtZQ=="
+	if auth := string(req.Header.Peek("Authorization")); auth != expectedAuth {
+		t.Fatalf("unexpected Authorization header %q. Expecting %q", auth, expectedAuth)
+	}
+
+	// The header must be overwritten.
+	req.SetBasicAuth("foo", "")
+	expectedAuth = "Basic Zm9vOg=="
+	if auth := string(req.Header.Peek("Authorization")); auth != expectedAuth {
+		t.Fatalf("unexpected Authorization header %q. Expecting %q", auth, expectedAuth)
+	}
+	if n := bytes.Count(req.Header.Header(), []byte("Authorization")); n != 1 {
+		t.Fatalf("unexpected number of Authorization headers: %d. Expecting 1", n)
+	}
+}
+
+func TestRequestSetBearerToken(t *testing.T) {
+	var req Request
+	req.SetBearerToken("foobar")
+	expectedAuth := "Bearer foobar"
+	if auth := string(req.Header.Peek("Authorization")); auth != expectedAuth {
+		t.Fatalf("unexpected Authorization header %q. Expecting %q", auth, expectedAuth)
+	}
+}
+
 func TestRequestHostFromRequestURI(t *testing.T) {
 	hExpected := "foobar.com"
 	var req Request
@@ -235,6 +368,21 @@ func TestRequestHostFromHeader(t *testing.T) {
 	}
 }
 
+func TestRequestUseHostHeader(t *testing.T) {
+	var req Request
+	req.SetRequestURI("http://foobar.com/foo?bar")
+	req.Header.SetHost("example.com")
+	req.UseHostHeader = true
+	s := req.String()
+	expectedS := "GET /foo?bar HTTP/1.1\r\nUser-Agent: fasthttp\r\nHost: example.com\r\n\r\n"
+	if s != expectedS {
+		t.Fatalf("unexpected request: %q. Expecting %q", s, expectedS)
+	}
+	if h := req.Host(); string(h) != "foobar.com" {
+		t.Fatalf("unexpected host: %q. Expecting %q", h, "foobar.com")
+	}
+}
+
 func TestRequestContentTypeWithCharsetIssue100(t *testing.T) {
 	expectedContentType := "application/x-www-form-urlencoded; charset=UTF-8"
 	expectedBody := "0123=56789"
@@ -1040,6 +1188,200 @@ func TestResponseReadLimitBody(t *testing.T) {
 	testResponseReadLimitBodyError(t, "HTTP/1.1 400 OK\r\nContent-Type: aa\r\n\r\n123456", 5)
 }
 
+func TestRequestClone(t *testing.T) {
+	req := AcquireRequest()
+	req.Header.SetMethod("POST")
+	req.Header.Set("X-Foo", "bar")
+	req.Header.SetHost("example.com")
+	req.SetRequestURI("/foo?bar=baz")
+	req.SetBodyString("body")
+	req.URI().QueryArgs().Add("aaa", "bbb")
+	req.SetDialAddr("127.0.0.1:8080")
+
+	clone := req.Clone()
+	defer ReleaseRequest(clone)
+
+	// Modifications and re-use of the original request mustn't affect the clone.
+	req.Header.Set("X-Foo", "modified")
+	req.URI().QueryArgs().Set("aaa", "modified")
+	req.AppendBodyString("-modified")
+	ReleaseRequest(req)
+	req = AcquireRequest()
+	req.Header.SetHost("modified.com")
+	req.SetRequestURI("/modified")
+	defer ReleaseRequest(req)
+
+	if string(clone.Header.Method()) != "POST" {
+		t.Fatalf("unexpected method %q. Expecting %q", clone.Header.Method(), "POST")
+	}
+	if string(clone.Header.Peek("X-Foo")) != "bar" {
+		t.Fatalf("unexpected header %q. Expecting %q", clone.Header.Peek("X-Foo"), "bar")
+	}
+	if string(clone.Host()) != "example.com" {
+		t.Fatalf("unexpected host %q. Expecting %q", clone.Host(), "example.com")
+	}
+	if string(clone.URI().QueryArgs().Peek("aaa")) != "bbb" {
+		t.Fatalf("unexpected query arg %q. Expecting %q", clone.URI().QueryArgs().Peek("aaa"), "bbb")
+	}
+	if string(clone.Body()) != "body" {
+		t.Fatalf("unexpected body %q. Expecting %q", clone.Body(), "body")
+	}
+	if clone.DialAddr() != "127.0.0.1:8080" {
+		t.Fatalf("unexpected dial addr %q. Expecting %q", clone.DialAddr(), "127.0.0.1:8080")
+	}
+}
+
+func TestRequestCloneBodyStream(t *testing.T) {
+	var req Request
+	req.SetBodyStream(strings.NewReader("foobar"), -1)
+
+	clone := req.Clone()
+	defer ReleaseRequest(clone)
+	if string(clone.Body()) != "foobar" {
+		t.Fatalf("unexpected clone body %q. Expecting %q", clone.Body(), "foobar")
+	}
+	if string(req.Body()) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", req.Body(), "foobar")
+	}
+}
+
+func TestRequestCloneMultipartForm(t *testing.T) {
+	body := "--foobar\r\nContent-Disposition: form-data; name=\"key_0\"\r\n\r\nvalue_0\r\n" +
+		"--foobar\r\nContent-Disposition: form-data; name=\"key_1\"\r\n\r\nvalue_1\r\n--foobar--\r\n"
+	s := fmt.Sprintf("POST / HTTP/1.1\r\nHost: aaa\r\nContent-Type: multipart/form-data; boundary=foobar\r\nContent-Length: %d\r\n\r\n%s",
+		len(body), body)
+	var req Request
+	if err := req.Read(bufio.NewReader(strings.NewReader(s))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	clone := req.Clone()
+	defer ReleaseRequest(clone)
+	req.Reset()
+
+	f, err := clone.MultipartForm()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		k := fmt.Sprintf("key_%d", i)
+		v := fmt.Sprintf("value_%d", i)
+		if len(f.Value[k]) != 1 || f.Value[k][0] != v {
+			t.Fatalf("unexpected value for %q: %q. Expecting %q", k, f.Value[k], v)
+		}
+	}
+}
+
+func TestRequestCloneConcurrent(t *testing.T) {
+	var req Request
+	req.SetRequestURI("http://example.com/foo")
+	req.Header.Set("X-Foo", "bar")
+	req.SetBodyString("body")
+
+	ch := make(chan error, 10)
+	for i := 0; i < cap(ch); i++ {
+		clone := req.Clone()
+		go func(clone *Request, n int) {
+			defer ReleaseRequest(clone)
+			v := fmt.Sprintf("%d", n)
+			clone.Header.Set("X-Foo", v)
+			clone.URI().QueryArgs().Set("n", v)
+			clone.AppendBodyString(v)
+			if string(clone.Header.Peek("X-Foo")) != v || string(clone.Body()) != "body"+v {
+				ch <- fmt.Errorf("unexpected clone contents: header=%q, body=%q", clone.Header.Peek("X-Foo"), clone.Body())
+				return
+			}
+			ch <- nil
+		}(clone, i)
+	}
+	for i := 0; i < cap(ch); i++ {
+		if err := <-ch; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResponseClone(t *testing.T) {
+	var resp Response
+	resp.SetStatusCode(StatusCreated)
+	resp.Header.Set("X-Foo", "bar")
+	resp.SetBodyStream(strings.NewReader("foobar"), 6)
+
+	clone := resp.Clone()
+	defer ReleaseResponse(clone)
+
+	resp.Header.Set("X-Foo", "modified")
+	resp.SetBodyString("modified")
+
+	if clone.StatusCode() != StatusCreated {
+		t.Fatalf("unexpected status code %d. Expecting %d", clone.StatusCode(), StatusCreated)
+	}
+	if string(clone.Header.Peek("X-Foo")) != "bar" {
+		t.Fatalf("unexpected header %q. Expecting %q", clone.Header.Peek("X-Foo"), "bar")
+	}
+	if string(clone.Body()) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", clone.Body(), "foobar")
+	}
+}
+
+func TestResponseBodySink(t *testing.T) {
+	// response with content-length
+	testResponseBodySinkSuccess(t, "HTTP/1.1 200 OK\r\nContent-Type: aa\r\nContent-Length: 10\r\n\r\n9876543210", 0, "9876543210")
+	testResponseBodySinkSuccess(t, "HTTP/1.1 200 OK\r\nContent-Type: aa\r\nContent-Length: 10\r\n\r\n9876543210", 10, "9876543210")
+	testResponseBodySinkError(t, "HTTP/1.1 200 OK\r\nContent-Type: aa\r\nContent-Length: 10\r\n\r\n9876543210", 9)
+	testResponseBodySinkError(t, "HTTP/1.1 200 OK\r\nContent-Type: aa\r\nContent-Length: 10\r\n\r\n98765", 0)
+
+	// chunked response
+	testResponseBodySinkSuccess(t, "HTTP/1.1 200 OK\r\nContent-Type: aa\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nfoobar\r\n3\r\nbaz\r\n0\r\n\r\n", 0, "foobarbaz")
+	testResponseBodySinkError(t, "HTTP/1.1 200 OK\r\nContent-Type: aa\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nfoobar\r\n3\r\nbaz\r\n0\r\n\r\n", 2)
+
+	// identity response
+	testResponseBodySinkSuccess(t, "HTTP/1.1 400 OK\r\nContent-Type: aa\r\n\r\n123456", 0, "123456")
+	testResponseBodySinkError(t, "HTTP/1.1 400 OK\r\nContent-Type: aa\r\n\r\n123456", 5)
+
+	// The body mustn't be written to the sink for HEAD responses.
+	var resp Response
+	var sink bytes.Buffer
+	resp.SkipBody = true
+	resp.SetBodySink(&sink)
+	br := bufio.NewReader(bytes.NewBufferString("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n"))
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if sink.Len() != 0 {
+		t.Fatalf("unexpected body written to the sink: %q", sink.Bytes())
+	}
+}
+
+func testResponseBodySinkSuccess(t *testing.T, s string, maxBodySize int, expectedBody string) {
+	var resp Response
+	var sink bytes.Buffer
+	resp.SetBodySink(&sink)
+	br := bufio.NewReader(bytes.NewBufferString(s))
+	if err := resp.ReadLimitBody(br, maxBodySize); err != nil {
+		t.Fatalf("unexpected error: %s. s=%q, maxBodySize=%d", err, s, maxBodySize)
+	}
+	if sink.String() != expectedBody {
+		t.Fatalf("unexpected body written to the sink: %q. Expecting %q", sink.Bytes(), expectedBody)
+	}
+	if len(resp.Body()) != 0 {
+		t.Fatalf("unexpected buffered body: %q. Expecting empty body", resp.Body())
+	}
+	if n := resp.Header.ContentLength(); n != len(expectedBody) {
+		t.Fatalf("unexpected content-length: %d. Expecting %d", n, len(expectedBody))
+	}
+}
+
+func testResponseBodySinkError(t *testing.T, s string, maxBodySize int) {
+	var resp Response
+	var sink bytes.Buffer
+	resp.SetBodySink(&sink)
+	br := bufio.NewReader(bytes.NewBufferString(s))
+	if err := resp.ReadLimitBody(br, maxBodySize); err == nil {
+		t.Fatalf("expecting error. s=%q, maxBodySize=%d", s, maxBodySize)
+	}
+}
+
 func TestRequestReadLimitBody(t *testing.T) {
 	// request with content-length
 	testRequestReadLimitBodySuccess(t, "POST /foo HTTP/1.1\r\nHost: aaa.com\r\nContent-Length: 9\r\nContent-Type: aaa\r\n\r\n123456789", 9)
@@ -1194,6 +1536,107 @@ func TestSetResponseBodyStreamChunked(t *testing.T) {
 	testSetResponseBodyStream(t, body, true)
 }
 
+func TestResponseImmediateHeaderFlush(t *testing.T) {
+	bodyR, bodyW := io.Pipe()
+	var resp Response
+	resp.SetBodyStream(bodyR, -1)
+	resp.ImmediateHeaderFlush = true
+	br := testResponseWriteAsync(&resp)
+
+	// Response headers must be sent before the body data is available.
+	var resp1 Response
+	testReadWithTimeout(t, func() error {
+		return resp1.Header.Read(br)
+	})
+	if resp1.Header.ContentLength() != -1 {
+		t.Fatalf("unexpected Content-Length %d. Expecting %d", resp1.Header.ContentLength(), -1)
+	}
+
+	bodyW.Write([]byte("foo"))
+	bodyW.Close()
+	body, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != "3\r\nfoo\r\n0\r\n\r\n" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "3\r\nfoo\r\n0\r\n\r\n")
+	}
+}
+
+func TestResponseStreamFlushInterval(t *testing.T) {
+	testResponseStreamFlushInterval(t, 6, "foo", "bar", "foobar")
+	testResponseStreamFlushInterval(t, -1, "3\r\nfoo\r\n", "3\r\nbar\r\n", "3\r\nfoo\r\n3\r\nbar\r\n0\r\n\r\n")
+}
+
+func testResponseStreamFlushInterval(t *testing.T, bodySize int, expectedFirst, expectedSecond, expectedBody string) {
+	bodyR, bodyW := io.Pipe()
+	var resp Response
+	resp.SetBodyStream(bodyR, bodySize)
+	resp.StreamFlushInterval = 10 * time.Millisecond
+	br := testResponseWriteAsync(&resp)
+
+	// The data must be flushed to the client while the body stream
+	// is blocked.
+	bodyW.Write([]byte("foo"))
+	var resp1 Response
+	testReadWithTimeout(t, func() error {
+		return resp1.Header.Read(br)
+	})
+	testReadStringWithTimeout(t, br, expectedFirst)
+	bodyW.Write([]byte("bar"))
+	testReadStringWithTimeout(t, br, expectedSecond)
+
+	bodyW.Close()
+	body, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expectedRest := expectedBody[len(expectedFirst)+len(expectedSecond):]; string(body) != expectedRest {
+		t.Fatalf("unexpected body %q. Expecting %q", body, expectedRest)
+	}
+}
+
+func testResponseWriteAsync(resp *Response) *bufio.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		bw := bufio.NewWriter(pw)
+		err := resp.Write(bw)
+		if err == nil {
+			err = bw.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	return bufio.NewReader(pr)
+}
+
+func testReadStringWithTimeout(t *testing.T, br *bufio.Reader, expected string) {
+	t.Helper()
+	buf := make([]byte, len(expected))
+	testReadWithTimeout(t, func() error {
+		_, err := io.ReadFull(br, buf)
+		return err
+	})
+	if string(buf) != expected {
+		t.Fatalf("unexpected data %q. Expecting %q", buf, expected)
+	}
+}
+
+func testReadWithTimeout(t *testing.T, read func() error) {
+	t.Helper()
+	ch := make(chan error, 1)
+	go func() {
+		ch <- read()
+	}()
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
 func testSetRequestBodyStream(t *testing.T, body string, chunked bool) {
 	var req Request
 	req.Header.SetHost("foobar.com")
@@ -1742,7 +2185,7 @@ func testReadBodyChunked(t *testing.T, b []byte, bodySize int) {
 
 	r := bytes.NewBuffer(chunkedBody)
 	br := bufio.NewReader(r)
-	b, err := readBody(br, -1, 0, nil)
+	b, err := readBody(br, -1, 0, nil, &ResponseHeader{})
 	if err != nil {
 		t.Fatalf("Unexpected error for bodySize=%d: %s. body=%q, chunkedBody=%q", bodySize, err, body, chunkedBody)
 	}
@@ -1759,7 +2202,7 @@ func testReadBodyFixedSize(t *testing.T, b []byte, bodySize int) {
 
 	r := bytes.NewBuffer(bodyWithTrailer)
 	br := bufio.NewReader(r)
-	b, err := readBody(br, bodySize, 0, nil)
+	b, err := readBody(br, bodySize, 0, nil, &ResponseHeader{})
 	if err != nil {
 		t.Fatalf("Unexpected error in ReadResponseBody(%d): %s", bodySize, err)
 	}
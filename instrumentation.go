@@ -0,0 +1,99 @@
+package fasthttp
+
+import (
+	"sync"
+	"time"
+)
+
+// ClientInstrumenter starts spans for requests performed by Client
+// and HostClient.
+//
+// It is designed for tracing integrations such as OpenTelemetry.
+// Set it via Client.Instrumenter or HostClient.Instrumenter.
+type ClientInstrumenter interface {
+	// StartClientRequest is called before performing the request,
+	// i.e. before BeforeRequest hooks and before the first attempt.
+	//
+	// Trace context may be injected into req.Header here.
+	//
+	// The returned span is ended after the request is finished,
+	// including all the retries. The span may be nil.
+	StartClientRequest(req *Request) RequestSpan
+}
+
+// ServerInstrumenter starts spans for requests served by Server.
+//
+// It is designed for tracing integrations such as OpenTelemetry.
+// Set it via Server.Instrumenter.
+type ServerInstrumenter interface {
+	// StartServerRequest is called after the request is read
+	// and before calling the request handler.
+	//
+	// Trace context may be extracted from ctx.Request.Header here.
+	// The span may be attached to ctx via ctx.SetUserValue, so the request
+	// handler may access it.
+	//
+	// The returned span is ended after the response is written.
+	// The span may be nil.
+	StartServerRequest(ctx *RequestCtx) RequestSpan
+}
+
+// RequestSpan is a span started by ClientInstrumenter or ServerInstrumenter.
+type RequestSpan interface {
+	// End is called when the request is finished.
+	//
+	// resp is nil if the client is called with nil response.
+	// resp and timing mustn't be accessed after returning from End.
+	//
+	// err contains the error returned to the client caller or the error
+	// occurred when writing the response on the server.
+	End(resp *Response, timing *SpanTiming, err error)
+}
+
+// SpanTiming contains timing data for the instrumented request.
+//
+// Zero fields mean the corresponding event didn't occur or isn't applicable
+// to the request side.
+type SpanTiming struct {
+	// Start is the span start time.
+	//
+	// It is the time Do is called on the client and the time the request
+	// has been read on the server.
+	Start time.Time
+
+	// GotConn is the time the connection for the last attempt
+	// has been obtained by the client.
+	GotConn time.Time
+
+	// WroteRequest is the time the request of the last attempt
+	// has been written by the client.
+	WroteRequest time.Time
+
+	// GotFirstResponseByte is the time the first response byte
+	// of the last attempt has been received by the client.
+	GotFirstResponseByte time.Time
+
+	// HandlerDone is the time the request handler returned on the server.
+	HandlerDone time.Time
+
+	// End is the span end time.
+	End time.Time
+
+	// Attempts is the number of attempts made by the client.
+	Attempts int
+}
+
+func acquireSpanTiming() *SpanTiming {
+	v := spanTimingPool.Get()
+	if v == nil {
+		return &SpanTiming{}
+	}
+	return v.(*SpanTiming)
+}
+
+func releaseSpanTiming(t *SpanTiming) {
+	*t = SpanTiming{}
+	spanTimingPool.Put(t)
+}
+
+var spanTimingPool sync.Pool
@@ -0,0 +1,199 @@
+package fasthttp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+type testInstrumenter struct {
+	spans chan *testSpan
+}
+
+type testSpan struct {
+	traceID    string
+	statusCode int
+	timing     SpanTiming
+	err        error
+	done       chan *testSpan
+}
+
+func (ti *testInstrumenter) StartClientRequest(req *Request) RequestSpan {
+	req.Header.Set("X-Trace-Id", "foobar")
+	return &testSpan{
+		traceID: "foobar",
+		done:    ti.spans,
+	}
+}
+
+func (ti *testInstrumenter) StartServerRequest(ctx *RequestCtx) RequestSpan {
+	span := &testSpan{
+		traceID: string(ctx.Request.Header.Peek("X-Trace-Id")),
+		done:    ti.spans,
+	}
+	ctx.SetUserValue("span", span)
+	return span
+}
+
+func (span *testSpan) End(resp *Response, timing *SpanTiming, err error) {
+	if resp != nil {
+		span.statusCode = resp.StatusCode()
+	}
+	span.timing = *timing
+	span.err = err
+	span.done <- span
+}
+
+func TestInstrumenter(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	serverSpans := make(chan *testSpan, 10)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if _, ok := ctx.UserValue("span").(*testSpan); !ok {
+				t.Errorf("the span must be available to the request handler")
+			}
+			ctx.SetStatusCode(StatusAccepted)
+		},
+		Instrumenter: &testInstrumenter{
+			spans: serverSpans,
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	clientSpans := make(chan *testSpan, 10)
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		Instrumenter: &testInstrumenter{
+			spans: clientSpans,
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		var req Request
+		var resp Response
+		req.SetRequestURI("http://foobar/baz")
+		if err := c.DoTimeout(&req, &resp, time.Second); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		span := <-clientSpans
+		if span.err != nil {
+			t.Fatalf("unexpected error: %s", span.err)
+		}
+		if span.statusCode != StatusAccepted {
+			t.Fatalf("unexpected status code %d. Expecting %d", span.statusCode, StatusAccepted)
+		}
+		timing := span.timing
+		if timing.Attempts != 1 {
+			t.Fatalf("unexpected number of attempts: %d. Expecting 1", timing.Attempts)
+		}
+		if timing.Start.IsZero() || timing.GotConn.Before(timing.Start) ||
+			timing.WroteRequest.Before(timing.GotConn) ||
+			timing.GotFirstResponseByte.Before(timing.WroteRequest) ||
+			timing.End.Before(timing.GotFirstResponseByte) {
+			t.Fatalf("unexpected client timing: %+v", timing)
+		}
+		if !timing.HandlerDone.IsZero() {
+			t.Fatalf("HandlerDone must be zero for client spans")
+		}
+
+		select {
+		case span = <-serverSpans:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+		if span.traceID != "foobar" {
+			t.Fatalf("unexpected trace id %q. Expecting %q", span.traceID, "foobar")
+		}
+		if span.err != nil {
+			t.Fatalf("unexpected error: %s", span.err)
+		}
+		if span.statusCode != StatusAccepted {
+			t.Fatalf("unexpected status code %d. Expecting %d", span.statusCode, StatusAccepted)
+		}
+		timing = span.timing
+		if timing.Start.IsZero() || timing.HandlerDone.Before(timing.Start) || timing.End.Before(timing.HandlerDone) {
+			t.Fatalf("unexpected server timing: %+v", timing)
+		}
+		if !timing.GotConn.IsZero() || timing.Attempts != 0 {
+			t.Fatalf("client fields must be zero for server spans: %+v", timing)
+		}
+	}
+}
+
+func TestInstrumenterClientRetries(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetStatusCode(StatusServiceUnavailable)
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	spans := make(chan *testSpan, 10)
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		RetryPolicy: &RetryPolicy{
+			MaxAttempts:          3,
+			RetryableStatusCodes: []int{StatusServiceUnavailable},
+		},
+		Instrumenter: &testInstrumenter{
+			spans: spans,
+		},
+	}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://foobar/baz")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	span := <-spans
+	if span.statusCode != StatusServiceUnavailable {
+		t.Fatalf("unexpected status code %d. Expecting %d", span.statusCode, StatusServiceUnavailable)
+	}
+	if span.timing.Attempts != 3 {
+		t.Fatalf("unexpected number of attempts: %d. Expecting 3", span.timing.Attempts)
+	}
+}
+
+func TestInstrumenterClientError(t *testing.T) {
+	spans := make(chan *testSpan, 10)
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return nil, ErrNoFreeConns
+		},
+		Instrumenter: &testInstrumenter{
+			spans: spans,
+		},
+	}
+	var req Request
+	req.SetRequestURI("http://foobar/baz")
+	if err := c.Do(&req, nil); !errors.Is(err, ErrNoFreeConns) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrNoFreeConns)
+	}
+	span := <-spans
+	if !errors.Is(span.err, ErrNoFreeConns) {
+		t.Fatalf("unexpected error: %v. Expecting %v", span.err, ErrNoFreeConns)
+	}
+	if span.statusCode != 0 {
+		t.Fatalf("unexpected status code %d for nil response", span.statusCode)
+	}
+	if !span.timing.GotConn.IsZero() {
+		t.Fatalf("GotConn must be zero if the connection cannot be obtained")
+	}
+	if req.spanTiming != nil {
+		t.Fatalf("span timing must be detached from the request")
+	}
+}
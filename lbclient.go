@@ -1,6 +1,7 @@
 package fasthttp
 
 import (
+	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,6 +21,9 @@ type BalancingClient interface {
 //   - Balances load among available clients using 'least loaded' + 'round robin'
 //     hybrid technique.
 //   - Dynamically decreases load on unhealthy clients.
+//   - Supports pluggable balancing policies. See BalancingPolicy.
+//   - Ejects clients after MaxFails consecutive failures and re-admits
+//     them after EjectDuration.
 //
 // It is forbidden copying LBClient instances. Create new instances instead.
 //
@@ -47,6 +51,29 @@ type LBClient struct {
 	// DefaultLBClientTimeout is used by default.
 	Timeout time.Duration
 
+	// BalancingPolicy determines how the client for the next request
+	// is chosen.
+	//
+	// By default LBLeastPending is used.
+	BalancingPolicy LBBalancingPolicy
+
+	// MaxFails is the number of consecutive failed requests, after which
+	// the client is ejected from balancing for EjectDuration.
+	// Requests are considered failed if HealthCheck returns false.
+	//
+	// The ejected client is re-admitted after EjectDuration. It is ejected
+	// again if the first request after re-admission fails.
+	// Ejected clients are used if all the clients are ejected.
+	//
+	// By default clients aren't ejected.
+	MaxFails int
+
+	// EjectDuration is the duration the client is ejected for
+	// after MaxFails consecutive failures.
+	//
+	// DefaultLBEjectDuration is used by default.
+	EjectDuration time.Duration
+
 	cs []*lbClient
 
 	// nextIdx is for spreading requests among equally loaded clients
@@ -62,6 +89,31 @@ type LBClient struct {
 // The timeout may be overriden via LBClient.Timeout.
 const DefaultLBClientTimeout = time.Second
 
+// DefaultLBEjectDuration is the default duration unhealthy clients
+// are ejected for by LBClient.
+//
+// The duration may be overriden via LBClient.EjectDuration.
+const DefaultLBEjectDuration = 10 * time.Second
+
+// LBBalancingPolicy determines how LBClient chooses the client
+// for the next request.
+type LBBalancingPolicy int
+
+const (
+	// LBLeastPending chooses the client with the least number of pending
+	// requests. Equally loaded clients are chosen in a round-robin fashion.
+	LBLeastPending LBBalancingPolicy = iota
+
+	// LBRoundRobin chooses clients in a round-robin fashion regardless
+	// of their load.
+	LBRoundRobin
+
+	// LBRandomTwo chooses the least loaded client among two randomly
+	// chosen clients. This avoids herding on the same least loaded client
+	// when many LBClients share the same backends.
+	LBRandomTwo
+)
+
 // DoDeadline calls DoDeadline on the least loaded client
 func (cc *LBClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
 	return cc.get().DoDeadline(req, resp, deadline)
@@ -83,14 +135,122 @@ func (cc *LBClient) Do(req *Request, resp *Response) error {
 	return cc.DoTimeout(req, resp, timeout)
 }
 
+// DoHedged performs the request on the least loaded client and sends
+// the same request to another client if the response isn't received
+// during hedgeDelay. The first successful response is returned,
+// while the response from the other client is discarded.
+// This reduces tail latency for replicated backends at the cost
+// of additional load.
+//
+// The hedged request is sent immediately if the first attempt fails
+// before hedgeDelay. The request is performed on a single client
+// if LBClient.Clients contains only one client.
+//
+// The discarded attempt isn't interrupted, since clients don't support
+// cancellation, so it continues until the response is received
+// or the deadline calculated from LBClient.Timeout is reached.
+// This limits the load on slow hosts by the clients' MaxConns.
+//
+// Request body streams and response body sinks aren't supported
+// by DoHedged.
+func (cc *LBClient) DoHedged(req *Request, resp *Response, hedgeDelay time.Duration) error {
+	timeout := cc.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLBClientTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	c := cc.get()
+	if len(cc.cs) < 2 || hedgeDelay >= timeout {
+		return c.DoDeadline(req, resp, deadline)
+	}
+
+	ch := make(chan *hedgedAttempt, 2)
+	startAttempt := func(c *lbClient) {
+		a := &hedgedAttempt{
+			req:  AcquireRequest(),
+			resp: AcquireResponse(),
+		}
+		req.CopyTo(a.req)
+		go func() {
+			a.err = c.DoDeadline(a.req, a.resp, deadline)
+			ch <- a
+		}()
+	}
+
+	startAttempt(c)
+	pending := 1
+	hedged := false
+	tc := acquireTimer(hedgeDelay)
+	var a *hedgedAttempt
+	for {
+		select {
+		case <-tc.C:
+			if !hedged {
+				hedged = true
+				pending++
+				startAttempt(cc.getExcept(c))
+			}
+			continue
+		case a = <-ch:
+			pending--
+		}
+		if a.err == nil {
+			break
+		}
+		if !hedged {
+			hedged = true
+			pending++
+			startAttempt(cc.getExcept(c))
+		}
+		if pending == 0 {
+			break
+		}
+		releaseHedgedAttempt(a)
+	}
+	releaseTimer(tc)
+
+	if resp != nil {
+		a.resp.copyToSkipBody(resp)
+		swapResponseBody(resp, a.resp)
+	}
+	err := a.err
+	releaseHedgedAttempt(a)
+
+	if pending > 0 {
+		// Discard the response from the other client in background.
+		go func() {
+			releaseHedgedAttempt(<-ch)
+		}()
+	}
+	return err
+}
+
+type hedgedAttempt struct {
+	req  *Request
+	resp *Response
+	err  error
+}
+
+func releaseHedgedAttempt(a *hedgedAttempt) {
+	ReleaseRequest(a.req)
+	ReleaseResponse(a.resp)
+}
+
 func (cc *LBClient) init() {
 	if len(cc.Clients) == 0 {
 		panic("BUG: LBClient.Clients cannot be empty")
 	}
+	ejectDuration := cc.EjectDuration
+	if ejectDuration <= 0 {
+		ejectDuration = DefaultLBEjectDuration
+	}
 	for _, c := range cc.Clients {
 		cc.cs = append(cc.cs, &lbClient{
-			c:           c,
-			healthCheck: cc.HealthCheck,
+			c:             c,
+			healthCheck:   cc.HealthCheck,
+			maxFails:      uint32(cc.MaxFails),
+			ejectDuration: ejectDuration,
 		})
 	}
 
@@ -102,31 +262,109 @@ func (cc *LBClient) init() {
 func (cc *LBClient) get() *lbClient {
 	cc.once.Do(cc.init)
 
+	var now int64
+	skipEjected := cc.MaxFails > 0
+	if skipEjected {
+		now = time.Now().UnixNano()
+	}
+	c := cc.pick(now, skipEjected)
+	if c == nil {
+		// All the clients are ejected. Use them anyway.
+		c = cc.pick(now, false)
+	}
+	return c
+}
+
+func (cc *LBClient) pick(now int64, skipEjected bool) *lbClient {
+	switch cc.BalancingPolicy {
+	case LBRoundRobin:
+		return cc.getRoundRobin(now, skipEjected)
+	case LBRandomTwo:
+		return cc.getRandomTwo(now, skipEjected)
+	default:
+		return cc.getLeastPending(now, skipEjected)
+	}
+}
+
+func (cc *LBClient) getLeastPending(now int64, skipEjected bool) *lbClient {
 	cs := cc.cs
+	n := uint32(len(cs))
 	idx := atomic.AddUint32(&cc.nextIdx, 1)
-	idx %= uint32(len(cs))
 
-	minC := cs[idx]
-	minN := minC.PendingRequests()
-	if minN == 0 {
-		return minC
-	}
-	for _, c := range cs[idx+1:] {
-		n := c.PendingRequests()
-		if n == 0 {
+	var minC *lbClient
+	minN := 0
+	for i := uint32(0); i < n; i++ {
+		c := cs[(idx+i)%n]
+		if skipEjected && c.isEjected(now) {
+			continue
+		}
+		pending := c.PendingRequests()
+		if pending == 0 {
 			return c
 		}
-		if n < minN {
+		if minC == nil || pending < minN {
 			minC = c
-			minN = n
+			minN = pending
 		}
 	}
-	for _, c := range cs[:idx] {
-		n := c.PendingRequests()
-		if n == 0 {
+	return minC
+}
+
+func (cc *LBClient) getRoundRobin(now int64, skipEjected bool) *lbClient {
+	cs := cc.cs
+	n := uint32(len(cs))
+	idx := atomic.AddUint32(&cc.nextIdx, 1)
+
+	for i := uint32(0); i < n; i++ {
+		c := cs[(idx+i)%n]
+		if !skipEjected || !c.isEjected(now) {
 			return c
 		}
-		if n < minN {
+	}
+	return nil
+}
+
+func (cc *LBClient) getRandomTwo(now int64, skipEjected bool) *lbClient {
+	cs := cc.cs
+	n := len(cs)
+	if n < 2 {
+		return cc.getRoundRobin(now, skipEjected)
+	}
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+	c1, c2 := cs[i], cs[j]
+	if skipEjected {
+		ejected1 := c1.isEjected(now)
+		ejected2 := c2.isEjected(now)
+		if ejected1 && ejected2 {
+			return cc.getLeastPending(now, skipEjected)
+		}
+		if ejected1 {
+			return c2
+		}
+		if ejected2 {
+			return c1
+		}
+	}
+	if c2.PendingRequests() < c1.PendingRequests() {
+		return c2
+	}
+	return c1
+}
+
+// getExcept returns the least loaded client except the given one.
+func (cc *LBClient) getExcept(excluded *lbClient) *lbClient {
+	var minC *lbClient
+	minN := 0
+	for _, c := range cc.cs {
+		if c == excluded {
+			continue
+		}
+		n := c.PendingRequests()
+		if minC == nil || n < minN {
 			minC = c
 			minN = n
 		}
@@ -135,21 +373,48 @@ func (cc *LBClient) get() *lbClient {
 }
 
 type lbClient struct {
+	// ejectedUntil is the unix timestamp in nanoseconds
+	// until the client is ejected.
+	//
+	// It is the first field for 64-bit alignment on 32-bit platforms.
+	ejectedUntil int64
+
 	c           BalancingClient
 	healthCheck func(req *Request, resp *Response, err error) bool
 	penalty     uint32
+
+	maxFails      uint32
+	ejectDuration time.Duration
+
+	// fails is the number of consecutive failed requests.
+	fails uint32
 }
 
 func (c *lbClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
 	err := c.c.DoDeadline(req, resp, deadline)
-	if !c.isHealthy(req, resp, err) && c.incPenalty() {
+	if c.isHealthy(req, resp, err) {
+		if c.maxFails > 0 {
+			atomic.StoreUint32(&c.fails, 0)
+		}
+		return err
+	}
+	if c.incPenalty() {
 		// Penalize the client returning error, so the next requests
 		// are routed to another clients.
 		time.AfterFunc(penaltyDuration, c.decPenalty)
 	}
+	if c.maxFails > 0 && atomic.AddUint32(&c.fails, 1) >= c.maxFails {
+		// The failures counter isn't reset on ejection, so the re-admitted
+		// client is ejected again on the first failure.
+		atomic.StoreInt64(&c.ejectedUntil, time.Now().Add(c.ejectDuration).UnixNano())
+	}
 	return err
 }
 
+func (c *lbClient) isEjected(now int64) bool {
+	return atomic.LoadInt64(&c.ejectedUntil) > now
+}
+
 func (c *lbClient) PendingRequests() int {
 	n := c.c.PendingRequests()
 	m := atomic.LoadUint32(&c.penalty)
@@ -0,0 +1,215 @@
+package fasthttp
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func startLBClientTestServer(t *testing.T, h RequestHandler) (*HostClient, func()) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: h,
+	}
+	serverStopCh := make(chan struct{})
+	go func() {
+		if err := s.Serve(ln); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		close(serverStopCh)
+	}()
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	stop := func() {
+		if err := ln.Close(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		select {
+		case <-serverStopCh:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+	return c, stop
+}
+
+func TestLBClientDoHedged(t *testing.T) {
+	slowC, stopSlow := startLBClientTestServer(t, func(ctx *RequestCtx) {
+		time.Sleep(300 * time.Millisecond)
+		ctx.WriteString("slow")
+	})
+	defer stopSlow()
+	fastC, stopFast := startLBClientTestServer(t, func(ctx *RequestCtx) {
+		ctx.WriteString("fast")
+	})
+	defer stopFast()
+
+	lbc := &LBClient{
+		Clients: []BalancingClient{slowC, fastC},
+		Timeout: time.Second,
+	}
+
+	// The fast client must win regardless of the first picked client.
+	for i := 0; i < 4; i++ {
+		req := AcquireRequest()
+		resp := AcquireResponse()
+		req.SetRequestURI("http://foobar/baz")
+		startTime := time.Now()
+		if err := lbc.DoHedged(req, resp, 20*time.Millisecond); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if d := time.Since(startTime); d > 200*time.Millisecond {
+			t.Fatalf("too long hedged request duration: %s", d)
+		}
+		if string(resp.Body()) != "fast" {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "fast")
+		}
+		ReleaseRequest(req)
+		ReleaseResponse(resp)
+	}
+}
+
+func TestLBClientDoHedgedFailover(t *testing.T) {
+	okC, stopOK := startLBClientTestServer(t, func(ctx *RequestCtx) {
+		ctx.WriteString("ok")
+	})
+	defer stopOK()
+
+	// The client without a server fails immediately, so the hedged
+	// request must be sent without waiting for hedge delay.
+	badC := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return nil, ErrNoFreeConns
+		},
+	}
+
+	lbc := &LBClient{
+		Clients: []BalancingClient{badC, okC},
+		Timeout: time.Second,
+	}
+	for i := 0; i < 4; i++ {
+		req := AcquireRequest()
+		resp := AcquireResponse()
+		req.SetRequestURI("http://foobar/baz")
+		if err := lbc.DoHedged(req, resp, 500*time.Millisecond); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(resp.Body()) != "ok" {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+		}
+		ReleaseRequest(req)
+		ReleaseResponse(resp)
+	}
+}
+
+type testBalancingClient struct {
+	calls   uint32
+	err     error
+	pending int
+}
+
+func (c *testBalancingClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	atomic.AddUint32(&c.calls, 1)
+	return c.err
+}
+
+func (c *testBalancingClient) PendingRequests() int {
+	return c.pending
+}
+
+func (c *testBalancingClient) Calls() int {
+	return int(atomic.SwapUint32(&c.calls, 0))
+}
+
+func TestLBClientBalancingPolicy(t *testing.T) {
+	for _, policy := range []LBBalancingPolicy{LBLeastPending, LBRoundRobin, LBRandomTwo} {
+		cs := []*testBalancingClient{{}, {}, {}}
+		lbc := &LBClient{
+			BalancingPolicy: policy,
+		}
+		for _, c := range cs {
+			lbc.Clients = append(lbc.Clients, c)
+		}
+		for i := 0; i < 300; i++ {
+			if err := lbc.Do(nil, nil); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+		for i, c := range cs {
+			n := c.Calls()
+			if policy == LBRandomTwo {
+				if n == 0 {
+					t.Fatalf("policy %d: client #%d hasn't been called", policy, i)
+				}
+			} else if n != 100 {
+				t.Fatalf("policy %d: unexpected number of calls for client #%d: %d. Expecting 100", policy, i, n)
+			}
+		}
+	}
+}
+
+func TestLBClientEjection(t *testing.T) {
+	errTest := errors.New("test error")
+	for _, policy := range []LBBalancingPolicy{LBLeastPending, LBRoundRobin, LBRandomTwo} {
+		// The healthy client is heavily loaded, so the failing client
+		// is chosen whenever it isn't ejected.
+		badC := &testBalancingClient{err: errTest}
+		goodC := &testBalancingClient{pending: 1000}
+		lbc := &LBClient{
+			Clients:         []BalancingClient{badC, goodC},
+			BalancingPolicy: policy,
+			MaxFails:        2,
+			EjectDuration:   100 * time.Millisecond,
+		}
+
+		for badC.calls < 2 {
+			lbc.Do(nil, nil)
+		}
+		badC.Calls()
+		goodC.Calls()
+
+		// The failing client must be ejected.
+		for i := 0; i < 100; i++ {
+			if err := lbc.Do(nil, nil); err != nil {
+				t.Fatalf("policy %d: unexpected error: %s", policy, err)
+			}
+		}
+		if n := badC.Calls(); n != 0 {
+			t.Fatalf("policy %d: unexpected number of calls to the ejected client: %d", policy, n)
+		}
+
+		// The client must be re-admitted after EjectDuration
+		// and ejected again after the first failure.
+		time.Sleep(150 * time.Millisecond)
+		for badC.calls == 0 {
+			lbc.Do(nil, nil)
+		}
+		badC.Calls()
+		for i := 0; i < 100; i++ {
+			if err := lbc.Do(nil, nil); err != nil {
+				t.Fatalf("policy %d: unexpected error: %s", policy, err)
+			}
+		}
+		if n := badC.Calls(); n != 0 {
+			t.Fatalf("policy %d: unexpected number of calls to the re-ejected client: %d", policy, n)
+		}
+
+		// Ejected clients must be used if all the clients are ejected.
+		goodC.err = errTest
+		for goodC.calls < 2 {
+			lbc.Do(nil, nil)
+		}
+		if err := lbc.Do(nil, nil); err != errTest {
+			t.Fatalf("policy %d: unexpected error: %v. Expecting %v", policy, err, errTest)
+		}
+	}
+}
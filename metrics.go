@@ -0,0 +1,84 @@
+package fasthttp
+
+import (
+	"time"
+)
+
+// MetricsCollector receives events from Client and HostClient, so metrics
+// exporters (Prometheus, StatsD, etc.) may be built on top of it.
+//
+// Set it via Client.MetricsCollector or HostClient.MetricsCollector.
+//
+// Methods are called synchronously from concurrently running goroutines,
+// so they must be thread-safe and must return quickly.
+type MetricsCollector interface {
+	// RequestStarted is called when the request to the host is started.
+	//
+	// host is HostClient.Addr.
+	RequestStarted(host string)
+
+	// RequestRetried is called before the given attempt of the request,
+	// starting from the second attempt.
+	//
+	// err is the error returned by the previous attempt. It is nil
+	// if the previous attempt has been failed due to retryable status code.
+	RequestRetried(host string, attempt int, err error)
+
+	// RequestFinished is called when the request is finished,
+	// including all the retries.
+	//
+	// statusCode is 0 if err isn't nil or the response has been ignored.
+	// Use statusCode/100 for obtaining the status class.
+	RequestFinished(host string, statusCode int, duration time.Duration, err error)
+
+	// ConnOpened is called when a new connection to the given address
+	// is established.
+	ConnOpened(addr string)
+
+	// ConnClosed is called when the connection to the given address
+	// is closed.
+	ConnClosed(addr string)
+
+	// DialFailed is called when dialing the given address fails.
+	DialFailed(addr string, err error)
+}
+
+// ServerMetricsCollector receives events from Server, so metrics
+// exporters (Prometheus, StatsD, etc.) may be built on top of it.
+//
+// Set it via Server.MetricsCollector.
+//
+// Methods are called synchronously from concurrently running goroutines,
+// so they must be thread-safe and must return quickly.
+type ServerMetricsCollector interface {
+	// ConnOpened is called when the server starts serving a connection.
+	ConnOpened()
+
+	// ConnHijacked is called when the connection is hijacked,
+	// i.e. before calling HijackHandler.
+	ConnHijacked()
+
+	// ConnClosed is called when the connection is closed.
+	//
+	// hijacked is true if the connection has been hijacked. ConnClosed
+	// is called after HijackHandler returns in this case.
+	ConnClosed(hijacked bool)
+
+	// RequestStarted is called before passing the request to Server.Handler.
+	//
+	// Requests rejected before calling Server.Handler, e.g. due to parse
+	// errors, aren't reported.
+	RequestStarted()
+
+	// RequestFinished is called when the response to the request started
+	// via RequestStarted is written, so the number of in-flight requests
+	// may be obtained as the difference between RequestStarted
+	// and RequestFinished calls.
+	//
+	// duration is the time since RequestStarted call. Use statusCode/100
+	// for obtaining the status class.
+	//
+	// Body sizes are -1 if they are unknown, i.e. the body is streamed
+	// in chunked encoding.
+	RequestFinished(statusCode int, duration time.Duration, requestBodySize, responseBodySize int)
+}
@@ -0,0 +1,284 @@
+package fasthttp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+type testMetricsCollector struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (mc *testMetricsCollector) add(format string, args ...interface{}) {
+	mc.lock.Lock()
+	mc.events = append(mc.events, fmt.Sprintf(format, args...))
+	mc.lock.Unlock()
+}
+
+func (mc *testMetricsCollector) Events() []string {
+	mc.lock.Lock()
+	events := mc.events
+	mc.events = nil
+	mc.lock.Unlock()
+	return events
+}
+
+func (mc *testMetricsCollector) RequestStarted(host string) {
+	mc.add("started %s", host)
+}
+
+func (mc *testMetricsCollector) RequestRetried(host string, attempt int, err error) {
+	mc.add("retried %s attempt=%d err=%v", host, attempt, err)
+}
+
+func (mc *testMetricsCollector) RequestFinished(host string, statusCode int, duration time.Duration, err error) {
+	if duration <= 0 {
+		mc.add("non-positive duration %s", duration)
+	}
+	mc.add("finished %s status=%d err=%v", host, statusCode, err)
+}
+
+func (mc *testMetricsCollector) ConnOpened(addr string) {
+	mc.add("opened %s", addr)
+}
+
+func (mc *testMetricsCollector) ConnClosed(addr string) {
+	mc.add("closed %s", addr)
+}
+
+func (mc *testMetricsCollector) DialFailed(addr string, err error) {
+	mc.add("dial failed %s err=%v", addr, err)
+}
+
+func TestHostClientMetricsCollector(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	var requestsCount uint32
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if atomic.AddUint32(&requestsCount, 1) == 1 {
+				ctx.SetStatusCode(StatusServiceUnavailable)
+			}
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	errDial := errors.New("dial error")
+	mc := &testMetricsCollector{}
+	c := &HostClient{
+		Addr: "good,bad",
+		Dial: func(addr string) (net.Conn, error) {
+			if addr == "bad" {
+				return nil, errDial
+			}
+			return ln.Dial()
+		},
+		RetryPolicy: &RetryPolicy{
+			RetryableStatusCodes: []int{StatusServiceUnavailable},
+		},
+		MetricsCollector: mc,
+	}
+
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://foobar/")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	req.SetConnectionClose()
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The next connection is dialed to the bad address at first.
+	req.Header.ResetConnectionClose()
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectedEvents := []string{
+		"started good,bad",
+		"opened good",
+		"retried good,bad attempt=2 err=<nil>",
+		"finished good,bad status=200 err=<nil>",
+		"started good,bad",
+		"closed good",
+		"finished good,bad status=200 err=<nil>",
+		"started good,bad",
+		"dial failed bad err=dial error",
+		"opened good",
+		"finished good,bad status=200 err=<nil>",
+	}
+	events := mc.Events()
+	if len(events) != len(expectedEvents) {
+		t.Fatalf("unexpected events %q. Expecting %q", events, expectedEvents)
+	}
+	for i, event := range events {
+		if event != expectedEvents[i] {
+			t.Fatalf("unexpected event #%d: %q. Expecting %q", i, event, expectedEvents[i])
+		}
+	}
+}
+
+func TestClientMetricsCollector(t *testing.T) {
+	mc := &testMetricsCollector{}
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return nil, errors.New("dial error")
+		},
+		MetricsCollector: mc,
+	}
+	var req Request
+	req.SetRequestURI("http://foobar/")
+	if err := c.Do(&req, nil); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	events := mc.Events()
+	if len(events) == 0 || events[0] != "started foobar:80" {
+		t.Fatalf("unexpected events %q. Expecting events for %q", events, "foobar:80")
+	}
+	if e := events[len(events)-1]; e != "finished foobar:80 status=0 err=dial error" {
+		t.Fatalf("unexpected last event %q", e)
+	}
+}
+
+type testServerMetricsCollector struct {
+	testMetricsCollector
+}
+
+func (mc *testServerMetricsCollector) ConnOpened() {
+	mc.add("opened")
+}
+
+func (mc *testServerMetricsCollector) ConnHijacked() {
+	mc.add("hijacked")
+}
+
+func (mc *testServerMetricsCollector) ConnClosed(hijacked bool) {
+	mc.add("closed hijacked=%v", hijacked)
+}
+
+func (mc *testServerMetricsCollector) RequestStarted() {
+	mc.add("started")
+}
+
+func (mc *testServerMetricsCollector) RequestFinished(statusCode int, duration time.Duration, requestBodySize, responseBodySize int) {
+	if duration <= 0 {
+		mc.add("non-positive duration %s", duration)
+	}
+	mc.add("finished status=%d in=%d out=%d", statusCode, requestBodySize, responseBodySize)
+}
+
+func TestServerMetricsCollector(t *testing.T) {
+	mc := &testServerMetricsCollector{}
+	hijackDone := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/stream":
+				ctx.SetStatusCode(StatusNotFound)
+				ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+					w.WriteString("foobar")
+				})
+			case "/hijack":
+				ctx.Hijack(func(c net.Conn) {
+					close(hijackDone)
+				})
+			default:
+				ctx.WriteString("foo")
+			}
+		},
+		MetricsCollector: mc,
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("GET / HTTP/1.1\r\nHost: foo\r\n\r\n")
+	rw.r.WriteString("HEAD / HTTP/1.1\r\nHost: foo\r\n\r\n")
+	rw.r.WriteString("POST /stream HTTP/1.1\r\nHost: foo\r\nContent-Length: 3\r\n\r\nbar")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testServerMetricsEvents(t, mc, []string{
+		"opened",
+		"started",
+		"finished status=200 in=0 out=3",
+		"started",
+		"finished status=200 in=0 out=0",
+		"started",
+		"finished status=404 in=3 out=-1",
+		"closed hijacked=false",
+	})
+
+	rw = &readWriter{}
+	rw.r.WriteString("GET /hijack HTTP/1.1\r\nHost: foo\r\n\r\n")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	<-hijackDone
+	testServerMetricsEvents(t, mc, []string{
+		"opened",
+		"started",
+		"finished status=200 in=0 out=0",
+		"hijacked",
+		"closed hijacked=true",
+	})
+}
+
+type failingWriteDeadlineConn struct {
+	readWriter
+}
+
+func (c *failingWriteDeadlineConn) SetWriteDeadline(t time.Time) error {
+	return net.ErrClosed
+}
+
+func TestServerMetricsCollectorWriteDeadlineError(t *testing.T) {
+	mc := &testServerMetricsCollector{}
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("foo")
+		},
+		HeaderReceived: func(header *RequestHeader) (RequestConfig, error) {
+			return RequestConfig{WriteTimeout: time.Second}, nil
+		},
+		MetricsCollector: mc,
+	}
+
+	// The request must be finished even if the response cannot be sent.
+	c := &failingWriteDeadlineConn{}
+	c.r.WriteString("GET / HTTP/1.1\r\nHost: foo\r\n\r\n")
+	if err := s.ServeConn(c); err != net.ErrClosed {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, net.ErrClosed)
+	}
+	testServerMetricsEvents(t, mc, []string{
+		"opened",
+		"started",
+		"finished status=200 in=0 out=0",
+		"closed hijacked=false",
+	})
+}
+
+func testServerMetricsEvents(t *testing.T, mc *testServerMetricsCollector, expectedEvents []string) {
+	t.Helper()
+	var events []string
+	deadline := time.Now().Add(time.Second)
+	for {
+		events = append(events, mc.Events()...)
+		if len(events) >= len(expectedEvents) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if fmt.Sprintf("%q", events) != fmt.Sprintf("%q", expectedEvents) {
+		t.Fatalf("unexpected events %q. Expecting %q", events, expectedEvents)
+	}
+}
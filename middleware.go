@@ -0,0 +1,51 @@
+package fasthttp
+
+// Middleware wraps RequestHandler with cross-cutting functionality
+// such as logging, authentication or compression.
+//
+// The returned RequestHandler usually performs some work and then calls h,
+// but it may also respond without calling h. For instance:
+//
+//	func authMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+//		return func(ctx *fasthttp.RequestCtx) {
+//			if len(ctx.Request.Header.Peek("Authorization")) == 0 {
+//				ctx.Error("Unauthorized", fasthttp.StatusUnauthorized)
+//				return
+//			}
+//			h(ctx)
+//		}
+//	}
+//
+// Middleware is called once per wrapped handler, not per request.
+// Use Chain for composing multiple middlewares.
+type Middleware func(h RequestHandler) RequestHandler
+
+// Chain returns h wrapped with the given middlewares.
+//
+// Middlewares are applied in the order they are passed, i.e. m[0]
+// is the outermost middleware. So Chain(h, m1, m2) is equivalent
+// to m1(m2(h)): m1 sees the request first and the response last.
+//
+// Handlers with additional args such as TimeoutHandler or CompressHandlerLevel
+// may be turned into Middleware via closures:
+//
+//	timeout := func(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+//		return fasthttp.TimeoutHandler(h, time.Second, "timeout")
+//	}
+//	h = fasthttp.Chain(h, timeout, fasthttp.CompressHandler)
+func Chain(h RequestHandler, m ...Middleware) RequestHandler {
+	for i := len(m) - 1; i >= 0; i-- {
+		h = m[i](h)
+	}
+	return h
+}
+
+// ChainMiddleware returns Middleware composed of the given middlewares.
+//
+// ChainMiddleware(m...)(h) is equivalent to Chain(h, m...).
+func ChainMiddleware(m ...Middleware) Middleware {
+	m = append([]Middleware(nil), m...)
+	return func(h RequestHandler) RequestHandler {
+		return Chain(h, m...)
+	}
+}
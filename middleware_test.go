@@ -0,0 +1,45 @@
+package fasthttp
+
+import (
+	"testing"
+)
+
+func testMiddleware(name string) Middleware {
+	return func(h RequestHandler) RequestHandler {
+		return func(ctx *RequestCtx) {
+			ctx.WriteString(name + ">")
+			h(ctx)
+			ctx.WriteString("<" + name)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	h := func(ctx *RequestCtx) {
+		ctx.WriteString("h")
+	}
+	testChain := func(h RequestHandler, expectedBody string) {
+		t.Helper()
+		var ctx RequestCtx
+		h(&ctx)
+		if body := string(ctx.Response.Body()); body != expectedBody {
+			t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+		}
+	}
+
+	testChain(Chain(h), "h")
+	testChain(Chain(h, testMiddleware("a")), "a>h<a")
+	testChain(Chain(h, testMiddleware("a"), testMiddleware("b"), testMiddleware("c")), "a>b>c>h<c<b<a")
+
+	m := ChainMiddleware(testMiddleware("a"), testMiddleware("b"))
+	testChain(m(h), "a>b>h<b<a")
+	testChain(Chain(h, m, testMiddleware("c")), "a>b>c>h<c<b<a")
+
+	// Middleware may respond without calling the wrapped handler.
+	stop := func(h RequestHandler) RequestHandler {
+		return func(ctx *RequestCtx) {
+			ctx.WriteString("stop")
+		}
+	}
+	testChain(Chain(h, testMiddleware("a"), stop, testMiddleware("b")), "a>stop<a")
+}
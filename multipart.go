@@ -0,0 +1,283 @@
+package fasthttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+)
+
+// ErrMultipartPartTooLarge is returned by MultipartReader when the part body
+// exceeds MultipartReader.MaxPartSize.
+var ErrMultipartPartTooLarge = errors.New("multipart part exceeds the size limit")
+
+// ErrMultipartFormTooLarge is returned by MultipartReader when the total size
+// of part bodies exceeds MultipartReader.MaxFormSize.
+var ErrMultipartFormTooLarge = errors.New("multipart form exceeds the size limit")
+
+// MultipartReader reads parts of multipart/form-data body one by one
+// without buffering the whole form in memory.
+//
+// Use Request.MultipartReader or RequestCtx.MultipartReader for obtaining
+// MultipartReader. Set Server.StreamRequestBody for reading parts directly
+// from the connection, otherwise the whole body is read into memory before
+// calling RequestHandler.
+type MultipartReader struct {
+	// MaxPartSize is the maximum size of a single part body.
+	//
+	// ErrMultipartPartTooLarge is returned when the part body exceeds
+	// the limit. The rest of the form cannot be read after that.
+	//
+	// By default part size is unlimited.
+	MaxPartSize int64
+
+	// MaxFormSize is the maximum total size of all the part bodies,
+	// including the bodies of parts skipped via NextPart.
+	//
+	// ErrMultipartFormTooLarge is returned when the total size exceeds
+	// the limit. The rest of the form cannot be read after that.
+	//
+	// By default form size is limited only by Server.MaxRequestBodySize.
+	MaxFormSize int64
+
+	r        *multipart.Reader
+	part     *MultipartPart
+	formSize int64
+	err      error
+}
+
+// MultipartPart is a single part of multipart form read by MultipartReader.
+//
+// The part body is read via Read. It is valid until the next call
+// to MultipartReader.NextPart.
+type MultipartPart struct {
+	mr   *MultipartReader
+	p    *multipart.Part
+	size int64
+	err  error
+}
+
+// MultipartReader returns MultipartReader for reading parts of the request's
+// multipart form one by one.
+//
+// Returns ErrNoMultipartForm if request's Content-Type
+// isn't 'multipart/form-data'.
+//
+// Parts are read from the request body stream if the body is streamed,
+// so the body mustn't be read via other methods such as Body, PostArgs
+// or MultipartForm.
+func (req *Request) MultipartReader() (*MultipartReader, error) {
+	boundary := req.Header.MultipartFormBoundary()
+	if len(boundary) == 0 {
+		return nil, ErrNoMultipartForm
+	}
+	if ce := req.Header.peek(strContentEncoding); len(ce) > 0 && !bytes.Equal(ce, strIdentity) {
+		return nil, fmt.Errorf("unsupported Content-Encoding: %q", ce)
+	}
+
+	var r io.Reader
+	if req.bodyStream != nil {
+		r = req.bodyStream
+	} else {
+		// Body marshals multipart form if it is already read.
+		r = bytes.NewReader(req.Body())
+	}
+	return &MultipartReader{
+		r: multipart.NewReader(r, string(boundary)),
+	}, nil
+}
+
+// NextPart returns the next part of multipart form.
+//
+// The rest of the previous part body is skipped. io.EOF is returned
+// if there are no more parts.
+func (mr *MultipartReader) NextPart() (*MultipartPart, error) {
+	if mr.err != nil {
+		return nil, mr.err
+	}
+	if p := mr.part; p != nil {
+		mr.part = nil
+		if _, err := io.Copy(ioutil.Discard, p); err != nil {
+			mr.err = err
+			return nil, err
+		}
+	}
+
+	p, err := mr.r.NextPart()
+	if err != nil {
+		if err != io.EOF {
+			err = fmt.Errorf("cannot read multipart/form-data body: %s", err)
+		}
+		mr.err = err
+		return nil, err
+	}
+	mr.part = &MultipartPart{
+		mr: mr,
+		p:  p,
+	}
+	return mr.part, nil
+}
+
+// Header returns the part header.
+func (p *MultipartPart) Header() textproto.MIMEHeader {
+	return p.p.Header
+}
+
+// FormName returns the name parameter of the part's Content-Disposition
+// header.
+func (p *MultipartPart) FormName() string {
+	return p.p.FormName()
+}
+
+// FileName returns the filename parameter of the part's Content-Disposition
+// header.
+func (p *MultipartPart) FileName() string {
+	return p.p.FileName()
+}
+
+// Read reads the part body into b.
+//
+// ErrMultipartPartTooLarge or ErrMultipartFormTooLarge is returned
+// if the body exceeds limits set in MultipartReader.
+func (p *MultipartPart) Read(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	n, err := p.p.Read(b)
+	mr := p.mr
+	p.size += int64(n)
+	mr.formSize += int64(n)
+
+	// Bytes exceeding the limits aren't returned to the caller.
+	var excess int64
+	if mr.MaxPartSize > 0 && p.size > mr.MaxPartSize {
+		excess = p.size - mr.MaxPartSize
+		err = ErrMultipartPartTooLarge
+	}
+	if mr.MaxFormSize > 0 && mr.formSize-mr.MaxFormSize > excess {
+		excess = mr.formSize - mr.MaxFormSize
+		err = ErrMultipartFormTooLarge
+	}
+	n -= int(excess)
+
+	switch err {
+	case nil, io.EOF:
+	case ErrMultipartPartTooLarge, ErrMultipartFormTooLarge:
+		mr.err = err
+	default:
+		err = fmt.Errorf("cannot read multipart/form-data body: %s", err)
+		mr.err = err
+	}
+	p.err = err
+	return n, err
+}
+
+// multipartFormLimits contains limits for reading multipart form
+// via Request.MultipartForm.
+type multipartFormLimits struct {
+	// maxMemory is the maximum size of files kept in memory. Files
+	// exceeding the limit are stored in temporary files.
+	maxMemory int
+
+	// maxFormSize and maxFileSize are ignored if they are zero.
+	maxFormSize int
+	maxFileSize int
+}
+
+// readForm reads multipart form from mr, while enforcing l.maxFileSize
+// on files while they are read.
+//
+// Files are stored in temporary files if they exceed l.maxMemory, so files
+// exceeding l.maxFileSize are rejected before they are written to disk.
+func (l *multipartFormLimits) readForm(r io.Reader, boundary string) (*multipart.Form, error) {
+	if l.maxFileSize <= 0 {
+		return multipart.NewReader(r, boundary).ReadForm(int64(l.maxMemory))
+	}
+
+	// ReadForm cannot limit file sizes, so parts are passed to it
+	// via pipe after checking their sizes.
+	pr, pw := io.Pipe()
+	copyErrCh := make(chan error, 1)
+	go func() {
+		err := copyMultipartFormLimited(pw, r, boundary, int64(l.maxFileSize))
+		pw.CloseWithError(err)
+		copyErrCh <- err
+	}()
+	f, err := multipart.NewReader(pr, boundary).ReadForm(int64(l.maxMemory))
+
+	// Stop copying the form if ReadForm fails, since r mustn't be read
+	// after returning from readForm.
+	pr.Close()
+	copyErr := <-copyErrCh
+	if err != nil {
+		// ReadForm removes temporary files on error.
+		if copyErr == ErrMultipartPartTooLarge {
+			return nil, copyErr
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// copyMultipartFormLimited copies multipart form from r to w.
+//
+// ErrMultipartPartTooLarge is returned if a file in the form exceeds
+// maxFileSize. The file isn't written to w in full in this case.
+func copyMultipartFormLimited(w io.Writer, r io.Reader, boundary string, maxFileSize int64) error {
+	mr := multipart.NewReader(r, boundary)
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		pw, err := mw.CreatePart(p.Header)
+		if err != nil {
+			return err
+		}
+		if p.FileName() == "" {
+			if _, err = copyZeroAlloc(pw, p); err != nil {
+				return err
+			}
+			continue
+		}
+		n, err := copyZeroAlloc(pw, io.LimitReader(p, maxFileSize+1))
+		if err != nil {
+			return err
+		}
+		if n > maxFileSize {
+			return ErrMultipartPartTooLarge
+		}
+	}
+	return mw.Close()
+}
+
+// formSizeReader returns ErrMultipartFormTooLarge if r contains
+// more than n bytes.
+type formSizeReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (fr *formSizeReader) Read(p []byte) (int, error) {
+	if fr.err != nil {
+		return 0, fr.err
+	}
+	n, err := fr.r.Read(p)
+	fr.n -= int64(n)
+	if fr.n < 0 {
+		fr.err = ErrMultipartFormTooLarge
+		return 0, fr.err
+	}
+	return n, err
+}
@@ -0,0 +1,359 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestMultipartBody(t *testing.T, parts ...string) ([]byte, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for i := 0; i < len(parts); i += 2 {
+		w, err := mw.CreateFormFile(parts[i], parts[i]+".txt")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err = w.Write([]byte(parts[i+1])); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return buf.Bytes(), mw.FormDataContentType()
+}
+
+func TestRequestMultipartReader(t *testing.T) {
+	body, contentType := newTestMultipartBody(t, "foo", "foo value", "skipped", "abcdef", "bar", strings.Repeat("x", 10000))
+
+	var req Request
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType(contentType)
+	req.SetBody(body)
+	mr, err := req.MultipartReader()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testMultipartPart(t, mr, "foo", "foo value")
+
+	// The rest of partially read part must be skipped.
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = p.Read(make([]byte, 2)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testMultipartPart(t, mr, "bar", strings.Repeat("x", 10000))
+	if _, err = mr.NextPart(); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+
+	// Not a multipart form.
+	req.Header.SetContentType("text/plain")
+	if _, err = req.MultipartReader(); err != ErrNoMultipartForm {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrNoMultipartForm)
+	}
+}
+
+func testMultipartPart(t *testing.T, mr *MultipartReader, name, value string) {
+	t.Helper()
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if p.FormName() != name {
+		t.Fatalf("unexpected form name %q. Expecting %q", p.FormName(), name)
+	}
+	if p.FileName() != name+".txt" {
+		t.Fatalf("unexpected file name %q. Expecting %q", p.FileName(), name+".txt")
+	}
+	if ct := p.Header().Get("Content-Type"); ct != "application/octet-stream" {
+		t.Fatalf("unexpected content type %q. Expecting %q", ct, "application/octet-stream")
+	}
+	v, err := ioutil.ReadAll(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(v) != value {
+		t.Fatalf("unexpected part body %q. Expecting %q", v, value)
+	}
+}
+
+func TestRequestMultipartReaderLimits(t *testing.T) {
+	body, contentType := newTestMultipartBody(t, "foo", "0123456789", "bar", "0123456789")
+
+	testRequestMultipartReaderLimits(t, body, contentType, 10, 0, 20, io.EOF)
+	testRequestMultipartReaderLimits(t, body, contentType, 0, 20, 20, io.EOF)
+	testRequestMultipartReaderLimits(t, body, contentType, 5, 0, 5, ErrMultipartPartTooLarge)
+	testRequestMultipartReaderLimits(t, body, contentType, 0, 15, 15, ErrMultipartFormTooLarge)
+	testRequestMultipartReaderLimits(t, body, contentType, 8, 15, 8, ErrMultipartPartTooLarge)
+}
+
+func testRequestMultipartReaderLimits(t *testing.T, body []byte, contentType string, maxPartSize, maxFormSize int64, expectedSize int, expectedErr error) {
+	t.Helper()
+
+	var req Request
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType(contentType)
+	req.SetBody(body)
+	mr, err := req.MultipartReader()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	mr.MaxPartSize = maxPartSize
+	mr.MaxFormSize = maxFormSize
+
+	var buf bytes.Buffer
+	for {
+		var p *MultipartPart
+		if p, err = mr.NextPart(); err != nil {
+			break
+		}
+		if _, err = io.Copy(&buf, p); err != nil {
+			break
+		}
+	}
+	if err != expectedErr {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, expectedErr)
+	}
+	if buf.Len() != expectedSize {
+		t.Fatalf("unexpected size of read data %d. Expecting %d", buf.Len(), expectedSize)
+	}
+	if _, err = mr.NextPart(); err != expectedErr {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, expectedErr)
+	}
+}
+
+func TestServerMultipartReader(t *testing.T) {
+	testServerMultipartReader(t, false)
+	testServerMultipartReader(t, true)
+}
+
+func testServerMultipartReader(t *testing.T, stream bool) {
+	fileBody := strings.Repeat("y", 1e5)
+	body, contentType := newTestMultipartBody(t, "foo", "bar", "file", fileBody)
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			mr, err := ctx.MultipartReader()
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+				return
+			}
+			for {
+				p, err := mr.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+					return
+				}
+				n, err := io.Copy(ioutil.Discard, p)
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+					return
+				}
+				fmt.Fprintf(ctx, "%s=%d;", p.FormName(), n)
+			}
+		},
+		StreamRequestBody: stream,
+	}
+
+	rw := &readWriter{}
+	fmt.Fprintf(&rw.r, "POST / HTTP/1.1\r\nHost: aaa\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", contentType, len(body))
+	rw.r.Write(body)
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	verifyResponse(t, bufio.NewReader(&rw.w), StatusOK, string(defaultContentType), "foo=3;file=100000;")
+}
+
+func TestServerMultipartFormLimits(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		testServerMultipartFormLimits(t, &Server{StreamRequestBody: stream}, "ok")
+		testServerMultipartFormLimits(t, &Server{StreamRequestBody: stream, MaxMultipartFormSize: 1000}, ErrMultipartFormTooLarge.Error())
+		testServerMultipartFormLimits(t, &Server{StreamRequestBody: stream, MaxMultipartFileSize: 1000}, ErrMultipartPartTooLarge.Error())
+	}
+}
+
+func testServerMultipartFormLimits(t *testing.T, s *Server, expectedBody string) {
+	t.Helper()
+	body, contentType := newTestMultipartBody(t, "foo", "bar", "file", strings.Repeat("y", 2000))
+
+	s.Handler = func(ctx *RequestCtx) {
+		if _, err := ctx.MultipartForm(); err != nil {
+			ctx.WriteString(err.Error())
+			return
+		}
+		ctx.WriteString("ok")
+	}
+	s.ErrorHandler = func(ctx *RequestCtx, err error) {
+		// The form is read before calling Handler if the body isn't streamed.
+		ctx.WriteString(err.Error())
+	}
+	rw := &readWriter{}
+	fmt.Fprintf(&rw.r, "POST / HTTP/1.1\r\nHost: aaa\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", contentType, len(body))
+	rw.r.Write(body)
+	if err := s.ServeConn(rw); err != nil && err.Error() != expectedBody {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	verifyResponse(t, bufio.NewReader(&rw.w), StatusOK, string(defaultContentType), expectedBody)
+}
+
+func TestServerMultipartFormFileSizeDiskSpill(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		dir := t.TempDir()
+		t.Setenv("TMPDIR", dir)
+
+		fileSize := int(1e6)
+		body, contentType := newTestMultipartBody(t, "file", strings.Repeat("y", fileSize))
+		s := &Server{
+			Handler: func(ctx *RequestCtx) {
+				if _, err := ctx.MultipartForm(); err != nil {
+					ctx.WriteString(err.Error())
+				}
+			},
+			ErrorHandler: func(ctx *RequestCtx, err error) {
+				ctx.WriteString(err.Error())
+			},
+			StreamRequestBody:      stream,
+			MaxMultipartFormMemory: 100,
+			MaxMultipartFileSize:   1000,
+		}
+		rw := &readWriter{}
+		fmt.Fprintf(&rw.r, "POST / HTTP/1.1\r\nHost: aaa\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", contentType, len(body))
+		rw.r.Write(body)
+		s.ServeConn(rw) //nolint:errcheck
+		verifyResponse(t, bufio.NewReader(&rw.w), StatusOK, string(defaultContentType), ErrMultipartPartTooLarge.Error())
+
+		// The file must be rejected without reading it till the end.
+		if rw.r.Len() < fileSize/2 {
+			t.Fatalf("too many bytes read from the connection: %d. stream=%v", len(body)-rw.r.Len(), stream)
+		}
+		// The temporary file must be removed.
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(files) > 0 {
+			t.Fatalf("unexpected temporary files left: %d. stream=%v", len(files), stream)
+		}
+	}
+}
+
+func TestServerMultipartFormDiskSpill(t *testing.T) {
+	testServerMultipartFormDiskSpill(t, false, false)
+	testServerMultipartFormDiskSpill(t, false, true)
+	testServerMultipartFormDiskSpill(t, true, false)
+	testServerMultipartFormDiskSpill(t, true, true)
+}
+
+func testServerMultipartFormDiskSpill(t *testing.T, stream, save bool) {
+	fileBody := strings.Repeat("y", 1e5)
+	body, contentType := newTestMultipartBody(t, "file", fileBody)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+
+	var tmpPath string
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			fh, err := ctx.FormFile("file")
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+				return
+			}
+			f, err := fh.Open()
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+				return
+			}
+			ff, ok := f.(*os.File)
+			if !ok {
+				t.Errorf("the file exceeding MaxMultipartFormMemory must be stored in temporary file")
+				f.Close()
+				return
+			}
+			tmpPath = ff.Name()
+			f.Close()
+			if save {
+				if err = SaveMultipartFile(fh, path); err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}
+		},
+		StreamRequestBody:      stream,
+		MaxMultipartFormMemory: 1000,
+	}
+	rw := &readWriter{}
+	fmt.Fprintf(&rw.r, "POST / HTTP/1.1\r\nHost: aaa\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", contentType, len(body))
+	rw.r.Write(body)
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	verifyResponse(t, bufio.NewReader(&rw.w), StatusOK, string(defaultContentType), "")
+
+	// The temporary file must be either moved or removed after the request.
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Fatalf("the temporary file %q must be removed. Got error %v", tmpPath, err)
+	}
+	if save {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(data) != fileBody {
+			t.Fatalf("unexpected saved file size %d. Expecting %d", len(data), len(fileBody))
+		}
+	}
+}
+
+func TestSaveMultipartFileInMemory(t *testing.T) {
+	body, contentType := newTestMultipartBody(t, "file", "foobar")
+
+	var req Request
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType(contentType)
+	req.SetBody(body)
+	f, err := req.MultipartForm()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer req.RemoveMultipartFormFiles()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	if err = ioutil.WriteFile(path, []byte("old contents"), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err = SaveMultipartFile(f.File["file"][0], path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(data) != "foobar" {
+		t.Fatalf("unexpected file contents %q. Expecting %q", data, "foobar")
+	}
+
+	// Temporary files mustn't be left in the directory.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("unexpected number of files in the directory: %d. Expecting 1", len(files))
+	}
+}
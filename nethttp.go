@@ -0,0 +1,260 @@
+package fasthttp
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// netHTTPHandler serves net/http requests with Server.Handler.
+//
+// It is used for serving HTTP/2 and HTTP/3 requests, which are parsed
+// by third-party servers.
+type netHTTPHandler struct {
+	s *Server
+
+	// c is the connection requests are received from.
+	//
+	// Addresses are obtained from http.Request if c is nil.
+	c              net.Conn
+	connID         uint64
+	connTime       time.Time
+	connRequestNum uint64
+}
+
+func (h *netHTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := h.s
+	c := h.c
+	connID := h.connID
+	connTime := h.connTime
+	if c == nil {
+		c = netHTTPRequestAddrer(r)
+		connID = nextConnID()
+		connTime = time.Now()
+	}
+
+	ctx := s.acquireCtx(c)
+	ctx.deadlinesUnsupported = true
+	if s.DisableHeaderNamesNormalizing {
+		ctx.Request.Header.DisableNormalizing()
+		ctx.Response.Header.DisableNormalizing()
+	}
+	if err := initRequestFromNetHTTP(&ctx.Request, r, s.maxRequestBodySize()); err != nil {
+		w.WriteHeader(StatusRequestEntityTooLarge)
+		ctx.Request.Reset()
+		s.releaseCtx(ctx)
+		return
+	}
+
+	ctx.Response.Header.SetServerBytes(s.getServerName())
+	if h.c != nil {
+		s.setAltSvc(ctx)
+	}
+	ctx.connID = connID
+	ctx.connRequestNum = atomic.AddUint64(&h.connRequestNum, 1)
+	ctx.connTime = connTime
+	ctx.time = time.Now()
+	mc := s.MetricsCollector
+	var reqBodySize int
+	if mc != nil {
+		reqBodySize = ctx.Request.bodySize()
+		mc.RequestStarted()
+	}
+	var span RequestSpan
+	var timing *SpanTiming
+	if s.Instrumenter != nil {
+		startTime := time.Now()
+		if span = s.Instrumenter.StartServerRequest(ctx); span != nil {
+			timing = acquireSpanTiming()
+			timing.Start = startTime
+		}
+	}
+	ctx.connDone = r.Context().Done()
+	ctx.netHTTPWriter = w
+	s.Handler(ctx)
+	if timing != nil {
+		timing.HandlerDone = time.Now()
+	}
+	ctx.cancelDone(ctx.timeoutResponse == nil)
+
+	// Hijacking isn't supported for requests served via net/http.
+	ctx.hijackHandler = nil
+	ctx.hijackRawHandler = nil
+	ctx.hijackTrack = false
+
+	resp := &ctx.Response
+	if ctx.timeoutResponse != nil {
+		// ctx may still be used by the handler, so it isn't returned
+		// to the pool.
+		resp = ctx.timeoutResponse
+	}
+	chunked := ctx.chunked && ctx.timeoutResponse == nil
+	var statusCode, respBodySize int
+	if mc != nil {
+		statusCode = resp.StatusCode()
+		if chunked {
+			respBodySize = -1
+		} else if !resp.SkipBody {
+			respBodySize = resp.bodySize()
+		}
+	}
+	var err error
+	if chunked {
+		err = ctx.finishChunkedResponse(nil)
+	} else {
+		err = writeNetHTTPResponse(w, resp)
+	}
+	ctx.netHTTPWriter = nil
+	if mc != nil {
+		mc.RequestFinished(statusCode, time.Since(ctx.time), reqBodySize, respBodySize)
+	}
+	if span != nil {
+		timing.End = time.Now()
+		span.End(resp, timing, err)
+		releaseSpanTiming(timing)
+	}
+	if ctx.timeoutResponse == nil {
+		ctx.Request.Reset()
+		ctx.Response.Reset()
+		ctx.userValues.Reset()
+		s.releaseCtx(ctx)
+	}
+}
+
+// netHTTPRequestAddrer returns net.Conn with the addresses of r.
+func netHTTPRequestAddrer(r *http.Request) net.Conn {
+	fa := &fakeAddrer{
+		laddr: zeroTCPAddr,
+		raddr: zeroTCPAddr,
+	}
+	if laddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		fa.laddr = laddr
+	}
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		// HTTP/3 requests are received over UDP.
+		raddr := &net.UDPAddr{
+			IP: net.ParseIP(host),
+		}
+		raddr.Port, _ = strconv.Atoi(port)
+		fa.raddr = raddr
+	}
+	return fa
+}
+
+func (s *Server) maxRequestBodySize() int {
+	if s.MaxRequestBodySize <= 0 {
+		return DefaultMaxRequestBodySize
+	}
+	return s.MaxRequestBodySize
+}
+
+// initRequestFromNetHTTP fills req from net/http request r.
+func initRequestFromNetHTTP(req *Request, r *http.Request, maxBodySize int) error {
+	req.Header.SetMethod(r.Method)
+	req.Header.SetRequestURI(r.RequestURI)
+	req.Header.SetHost(r.Host)
+	for k, vv := range r.Header {
+		switch k {
+		case "Content-Length":
+			// Content-Length is set from the body length below.
+		case "Cookie":
+			req.Header.Set(k, strings.Join(vv, "; "))
+		case "Content-Type", "User-Agent":
+			req.Header.Set(k, vv[0])
+		default:
+			for _, v := range vv {
+				req.Header.Add(k, v)
+			}
+		}
+	}
+
+	if r.Body == nil {
+		return nil
+	}
+	bodyBuf := req.bodyBuffer()
+	n, err := bodyBuf.ReadFrom(io.LimitReader(r.Body, int64(maxBodySize)+1))
+	if err != nil {
+		return err
+	}
+	if n > int64(maxBodySize) {
+		return ErrBodyTooLarge
+	}
+	if n > 0 {
+		req.Header.SetContentLength(int(n))
+	}
+
+	// r.Trailer is filled after reading the body till the end.
+	for k, vv := range r.Trailer {
+		if isForbiddenTrailer(s2b(k)) {
+			continue
+		}
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+	return nil
+}
+
+// writeNetHTTPResponse writes resp to net/http response writer w.
+func writeNetHTTPResponse(w http.ResponseWriter, resp *Response) error {
+	contentLength := -1
+	if resp.bodyStream == nil {
+		contentLength = len(resp.bodyBytes())
+	} else if n := resp.Header.ContentLength(); n >= 0 {
+		contentLength = n
+	}
+	writeNetHTTPHeader(w, resp, contentLength)
+	if resp.SkipBody {
+		return nil
+	}
+	if err := resp.BodyWriteTo(w); err != nil {
+		return err
+	}
+	writeNetHTTPTrailer(w, resp)
+	return nil
+}
+
+// writeNetHTTPHeader writes the header of resp to net/http response
+// writer w.
+//
+// Content-Length isn't set if contentLength is negative.
+func writeNetHTTPHeader(w http.ResponseWriter, resp *Response, contentLength int) {
+	h := w.Header()
+	trailer := peekArgBytes(resp.Header.h, strTrailer)
+	resp.Header.VisitAll(func(k, v []byte) {
+		switch string(k) {
+		case "Content-Length", "Connection", "Transfer-Encoding", "Keep-Alive", "Upgrade":
+			// Connection-specific headers are prohibited in HTTP/2
+			// and HTTP/3. See RFC 7540, section 8.1.2.2.
+		default:
+			if !isTrailer(trailer, k) {
+				// Trailer fields are set after writing the body.
+				h.Add(string(k), string(v))
+			}
+		}
+	})
+	if contentLength >= 0 {
+		h.Set("Content-Length", strconv.Itoa(contentLength))
+	}
+	w.WriteHeader(resp.StatusCode())
+}
+
+// writeNetHTTPTrailer sets trailer fields of resp in net/http response
+// writer w after writing the body.
+func writeNetHTTPTrailer(w http.ResponseWriter, resp *Response) {
+	// The trailer is obtained after writing the body, since the body stream
+	// may set it. http.TrailerPrefix allows sending trailer fields, which
+	// weren't declared before writing the header.
+	h := w.Header()
+	trailer := peekArgBytes(resp.Header.h, strTrailer)
+	for i, n := 0, len(resp.Header.h); i < n; i++ {
+		kv := &resp.Header.h[i]
+		if isTrailer(trailer, kv.key) {
+			h.Add(http.TrailerPrefix+string(kv.key), string(kv.value))
+		}
+	}
+}
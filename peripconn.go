@@ -6,6 +6,27 @@ import (
 	"sync"
 )
 
+// PerIPConnLimitError is returned from Server.ServeConn if the number
+// of connections from the client IP exceeds Server.MaxConnsPerIP.
+//
+// errors.Is(err, ErrPerIPConnLimit) returns true for PerIPConnLimitError.
+type PerIPConnLimitError struct {
+	// IP is the client IP.
+	IP net.IP
+
+	// Limit is Server.MaxConnsPerIP.
+	Limit int
+}
+
+func (e *PerIPConnLimitError) Error() string {
+	return fmt.Sprintf("too many connections from ip %s. The limit is %d connections per ip", e.IP, e.Limit)
+}
+
+// Is returns true if err is ErrPerIPConnLimit.
+func (e *PerIPConnLimitError) Is(err error) bool {
+	return err == ErrPerIPConnLimit
+}
+
 type perIPConnCounter struct {
 	pool sync.Pool
 	lock sync.Mutex
@@ -70,10 +91,6 @@ func (c *perIPConn) Close() error {
 	return err
 }
 
-func getUint32IP(c net.Conn) uint32 {
-	return ip2uint32(getConnIP4(c))
-}
-
 func getConnIP4(c net.Conn) net.IP {
 	addr := c.RemoteAddr()
 	ipAddr, ok := addr.(*net.TCPAddr)
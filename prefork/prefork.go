@@ -0,0 +1,377 @@
+// +build linux darwin dragonfly freebsd netbsd openbsd rumprun
+
+// Package prefork provides prefork serving mode for fasthttp.Server.
+//
+// The master process starts child processes, which accept connections
+// on distinct SO_REUSEPORT listeners bound to the same address. This usually
+// outperforms a single shared listener on multi-CPU Linux servers, since
+// the kernel maintains a separate accept queue per child process.
+//
+// Example usage:
+//
+//	s := &fasthttp.Server{
+//		Handler: requestHandler,
+//	}
+//	p := prefork.New(s)
+//	if err := p.ListenAndServe(":8080"); err != nil {
+//		log.Fatalf("error in prefork server: %s", err)
+//	}
+//
+// Child processes are started by re-executing the program binary with
+// the same args, so the code before ListenAndServe runs in children too.
+// Use IsChild for skipping master-only initialization in children.
+//
+// The master process handles the following signals:
+//
+//   - SIGHUP gracefully reloads children. New children are started
+//     from the current program binary, then old children are gracefully
+//     shut down after new children start accepting connections.
+//
+//   - SIGINT and SIGTERM gracefully shut down children. ListenAndServe
+//     returns nil after all the children exit.
+package prefork
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/reuseport"
+)
+
+// childEnv is the environment variable set for child processes.
+const childEnv = "FASTHTTP_PREFORK_CHILD"
+
+// childReadyFd is the file descriptor of the pipe, which is used
+// by child processes for notifying the master process they are ready
+// to accept connections.
+const childReadyFd = 3
+
+// masterCheckInterval is the interval for checking whether the master
+// process is alive in child processes.
+const masterCheckInterval = 500 * time.Millisecond
+
+// ErrTooManyRestarts is returned from Prefork.ListenAndServe if children
+// exit unexpectedly more than Prefork.RecoverThreshold times.
+var ErrTooManyRestarts = errors.New("too many unexpected child process exits")
+
+// IsChild returns true if the current process is a child process
+// started by Prefork.
+func IsChild() bool {
+	return os.Getenv(childEnv) == "1"
+}
+
+// Prefork serves connections in child processes.
+//
+// It is forbidden copying Prefork instances. Create new instances instead.
+type Prefork struct {
+	// Network must be "tcp", "tcp4" or "tcp6".
+	//
+	// By default "tcp4" is used.
+	Network string
+
+	// Children is the number of child processes.
+	//
+	// By default runtime.NumCPU() children are started.
+	Children int
+
+	// RecoverThreshold is the maximum number of unexpected child exits
+	// the master process recovers from by starting new children.
+	// Children failing on restart are counted as unexpected exits.
+	//
+	// ListenAndServe shuts down all the children and returns
+	// ErrTooManyRestarts after that.
+	//
+	// By default Children is used.
+	RecoverThreshold int
+
+	// ServeFunc serves connections accepted from ln in child processes.
+	//
+	// Use Server.ServeTLS in ServeFunc for serving HTTPS.
+	ServeFunc func(ln net.Listener) error
+
+	// ShutdownFunc gracefully shuts down ServeFunc in child processes.
+	//
+	// ServeFunc must return after ShutdownFunc returns.
+	// The listener is closed instead if ShutdownFunc isn't set.
+	ShutdownFunc func() error
+
+	// Logger, which is used by the master process.
+	//
+	// By default standard logger from log package is used.
+	Logger fasthttp.Logger
+
+	// sigCh receives signals in the master process. It is set in tests.
+	sigCh chan os.Signal
+}
+
+// New returns Prefork serving connections with s in child processes.
+func New(s *fasthttp.Server) *Prefork {
+	return &Prefork{
+		ServeFunc:    s.Serve,
+		ShutdownFunc: s.Shutdown,
+		Logger:       s.Logger,
+	}
+}
+
+// ListenAndServe serves connections to the given TCP addr in child processes.
+//
+// The master process starts children and supervises them until SIGINT
+// or SIGTERM. Child processes serve connections until the master process
+// shuts them down or exits.
+func (p *Prefork) ListenAndServe(addr string) error {
+	if IsChild() {
+		return p.serveChild(addr)
+	}
+	return p.serveMaster(addr)
+}
+
+func (p *Prefork) network() string {
+	if p.Network == "" {
+		return "tcp4"
+	}
+	return p.Network
+}
+
+func (p *Prefork) children() int {
+	if p.Children <= 0 {
+		return runtime.NumCPU()
+	}
+	return p.Children
+}
+
+func (p *Prefork) recoverThreshold() int {
+	if p.RecoverThreshold <= 0 {
+		return p.children()
+	}
+	return p.RecoverThreshold
+}
+
+var defaultLogger = fasthttp.Logger(log.New(os.Stderr, "", log.LstdFlags))
+
+func (p *Prefork) logger() fasthttp.Logger {
+	if p.Logger != nil {
+		return p.Logger
+	}
+	return defaultLogger
+}
+
+func (p *Prefork) serveChild(addr string) error {
+	// SIGHUP is sent to the master process for reloading children,
+	// so it mustn't kill children if it is sent to the whole process group.
+	signal.Ignore(syscall.SIGHUP)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	ppid := os.Getppid()
+	ln, err := reuseport.Listen(p.network(), addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen to %q: %s", addr, err)
+	}
+
+	serveCh := make(chan error, 1)
+	go func() {
+		serveCh <- p.ServeFunc(ln)
+	}()
+
+	// Notify the master process the child is ready.
+	if f := os.NewFile(childReadyFd, "prefork-ready"); f != nil {
+		f.Write([]byte{1})
+		f.Close()
+	}
+
+	ticker := time.NewTicker(masterCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-serveCh:
+			return err
+		case <-sigCh:
+			return p.shutdownChild(ln, serveCh)
+		case <-ticker.C:
+			if os.Getppid() != ppid {
+				// The master process has exited, so nobody
+				// supervises the child anymore.
+				return p.shutdownChild(ln, serveCh)
+			}
+		}
+	}
+}
+
+func (p *Prefork) shutdownChild(ln net.Listener, serveCh <-chan error) error {
+	if p.ShutdownFunc == nil {
+		ln.Close()
+		<-serveCh
+		return nil
+	}
+	if err := p.ShutdownFunc(); err != nil {
+		return err
+	}
+	return <-serveCh
+}
+
+type child struct {
+	cmd      *exec.Cmd
+	err      error
+	stopping bool
+}
+
+type master struct {
+	p        *Prefork
+	children map[*child]struct{}
+	exitCh   chan *child
+}
+
+func (p *Prefork) serveMaster(addr string) error {
+	// Verify addr in the master process, so misconfiguration is reported
+	// before starting children. The listener mustn't be kept open,
+	// since the kernel would route connections to it.
+	ln, err := reuseport.Listen(p.network(), addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen to %q: %s", addr, err)
+	}
+	ln.Close()
+
+	sigCh := p.sigCh
+	if sigCh == nil {
+		sigCh = make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(sigCh)
+	}
+
+	m := &master{
+		p:        p,
+		children: make(map[*child]struct{}),
+		exitCh:   make(chan *child),
+	}
+	if _, err := m.startChildren(p.children()); err != nil {
+		m.stop()
+		return err
+	}
+
+	restarts := 0
+	for {
+		select {
+		case c := <-m.exitCh:
+			delete(m.children, c)
+			if c.stopping {
+				continue
+			}
+			p.logger().Printf("child process %d exited unexpectedly: %v", c.cmd.Process.Pid, c.err)
+			// Failed restarts are counted as unexpected exits, so the master
+			// process doesn't remain without the child forever.
+			for {
+				restarts++
+				if restarts > p.recoverThreshold() {
+					m.stop()
+					return ErrTooManyRestarts
+				}
+				_, err := m.startChildren(1)
+				if err == nil {
+					break
+				}
+				p.logger().Printf("cannot restart child process: %s", err)
+			}
+		case sig := <-sigCh:
+			if sig != syscall.SIGHUP {
+				m.stop()
+				return nil
+			}
+			m.reload()
+		}
+	}
+}
+
+// reload starts new children and shuts down old children after that.
+//
+// Old children continue serving connections if new children cannot be started.
+func (m *master) reload() {
+	old := make(map[*child]struct{}, len(m.children))
+	for c := range m.children {
+		old[c] = struct{}{}
+	}
+	started, err := m.startChildren(m.p.children())
+	if err != nil {
+		m.p.logger().Printf("cannot reload child processes: %s", err)
+		old = started
+	}
+	for c := range old {
+		m.stopChild(c)
+	}
+}
+
+// startChildren starts n children and waits until they are ready.
+func (m *master) startChildren(n int) (map[*child]struct{}, error) {
+	started := make(map[*child]struct{}, n)
+	for i := 0; i < n; i++ {
+		c, err := m.startChild()
+		if err != nil {
+			return started, err
+		}
+		started[c] = struct{}{}
+	}
+	return started, nil
+}
+
+func (m *master) startChild() (*child, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create pipe: %s", err)
+	}
+	defer r.Close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), childEnv+"=1")
+	cmd.ExtraFiles = []*os.File{w}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot start child process: %s", err)
+	}
+
+	c := &child{
+		cmd: cmd,
+	}
+	m.children[c] = struct{}{}
+	go func() {
+		c.err = cmd.Wait()
+		m.exitCh <- c
+	}()
+
+	// Read returns io.EOF if the child exits before it is ready.
+	var buf [1]byte
+	if n, _ := r.Read(buf[:]); n != 1 {
+		// Do not restart the child in serveMaster, since the caller
+		// handles start errors.
+		c.stopping = true
+		return nil, fmt.Errorf("child process %d exited on start", cmd.Process.Pid)
+	}
+	return c, nil
+}
+
+func (m *master) stopChild(c *child) {
+	c.stopping = true
+	c.cmd.Process.Signal(syscall.SIGTERM)
+}
+
+// stop gracefully shuts down all the children and waits until they exit.
+func (m *master) stop() {
+	for c := range m.children {
+		m.stopChild(c)
+	}
+	for len(m.children) > 0 {
+		c := <-m.exitCh
+		delete(m.children, c)
+	}
+}
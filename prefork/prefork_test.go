@@ -0,0 +1,207 @@
+// +build linux darwin dragonfly freebsd netbsd openbsd rumprun
+
+package prefork
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	testAddrEnv = "PREFORK_TEST_ADDR"
+	testFailEnv = "PREFORK_TEST_FAIL"
+)
+
+func TestMain(m *testing.M) {
+	if IsChild() {
+		// The test binary is re-executed by the master process.
+		if os.Getenv(testFailEnv) == "1" {
+			os.Exit(1)
+		}
+		s := &fasthttp.Server{
+			Handler: func(ctx *fasthttp.RequestCtx) {
+				fmt.Fprintf(ctx, "%d", os.Getpid())
+			},
+		}
+		if err := New(s).ListenAndServe(os.Getenv(testAddrEnv)); err != nil {
+			fmt.Fprintf(os.Stderr, "error in child process: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func testAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	os.Setenv(testAddrEnv, addr)
+	return addr
+}
+
+func getPid(addr string) (int, error) {
+	var req fasthttp.Request
+	var resp fasthttp.Response
+	req.SetRequestURI("http://" + addr + "/")
+	// Each request must be served over new connection, so it may be
+	// accepted by distinct children.
+	req.SetConnectionClose()
+	if err := fasthttp.DoTimeout(&req, &resp, time.Second); err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(resp.Body()))
+}
+
+// waitPids returns pids of children serving requests after pred returns true
+// for all of them.
+func waitPids(t *testing.T, addr string, n int, pred func(pid int) bool) map[int]bool {
+	t.Helper()
+	pids := make(map[int]bool)
+	deadline := time.Now().Add(10 * time.Second)
+	for len(pids) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when waiting for %d children. Found pids: %v", n, pids)
+		}
+		pid, err := getPid(addr)
+		if err != nil || !pred(pid) {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		pids[pid] = true
+	}
+	return pids
+}
+
+func isAlive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+func waitExit(t *testing.T, pids map[int]bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for pid := range pids {
+		for isAlive(pid) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout when waiting for child %d exit", pid)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestPrefork(t *testing.T) {
+	addr := testAddr(t)
+	p := &Prefork{
+		Children: 2,
+		sigCh:    make(chan os.Signal),
+	}
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- p.ListenAndServe(addr)
+	}()
+
+	pids := waitPids(t, addr, 2, func(pid int) bool { return true })
+	if pids[os.Getpid()] {
+		t.Fatalf("requests mustn't be served by the master process")
+	}
+
+	// Exited children must be restarted.
+	var killedPid int
+	for pid := range pids {
+		killedPid = pid
+		break
+	}
+	if err := syscall.Kill(killedPid, syscall.SIGKILL); err != nil {
+		t.Fatalf("cannot kill child: %s", err)
+	}
+	waitExit(t, map[int]bool{killedPid: true})
+	waitPids(t, addr, 2, func(pid int) bool { return pid != killedPid })
+
+	// All the children must be replaced on reload.
+	oldPids := waitPids(t, addr, 2, func(pid int) bool { return pid != killedPid })
+	p.sigCh <- syscall.SIGHUP
+	waitExit(t, oldPids)
+	newPids := waitPids(t, addr, 2, func(pid int) bool { return !oldPids[pid] })
+
+	p.sigCh <- syscall.SIGTERM
+	select {
+	case err := <-doneCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timeout")
+	}
+	for pid := range newPids {
+		if isAlive(pid) {
+			t.Fatalf("child %d must exit after ListenAndServe returns", pid)
+		}
+	}
+}
+
+func TestPreforkChildStartError(t *testing.T) {
+	addr := testAddr(t)
+	os.Setenv(testFailEnv, "1")
+	defer os.Unsetenv(testFailEnv)
+
+	p := &Prefork{
+		Children: 2,
+		sigCh:    make(chan os.Signal),
+	}
+	if err := p.ListenAndServe(addr); err == nil {
+		t.Fatalf("expecting error")
+	}
+}
+
+func TestPreforkChildRestartError(t *testing.T) {
+	addr := testAddr(t)
+	p := &Prefork{
+		Children:         1,
+		RecoverThreshold: 3,
+		sigCh:            make(chan os.Signal),
+	}
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- p.ListenAndServe(addr)
+	}()
+	pids := waitPids(t, addr, 1, func(pid int) bool { return true })
+
+	// The master process mustn't remain without children
+	// if they cannot be restarted.
+	os.Setenv(testFailEnv, "1")
+	defer os.Unsetenv(testFailEnv)
+	for pid := range pids {
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+			t.Fatalf("cannot kill child: %s", err)
+		}
+	}
+	select {
+	case err := <-doneCh:
+		if err != ErrTooManyRestarts {
+			t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTooManyRestarts)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestPreforkInvalidAddr(t *testing.T) {
+	p := &Prefork{
+		Children: 2,
+		sigCh:    make(chan os.Signal),
+	}
+	if err := p.ListenAndServe("foobar"); err == nil {
+		t.Fatalf("expecting error")
+	}
+}
@@ -0,0 +1,247 @@
+// Package prommetrics exports fasthttp server metrics in Prometheus
+// text exposition format without depending on Prometheus client library.
+//
+// Example usage:
+//
+//	mc := prommetrics.NewCollector("myapp")
+//	s := &fasthttp.Server{
+//		Handler:          requestHandler,
+//		MetricsCollector: mc,
+//	}
+//
+//	// Serve metrics at /metrics on a separate port.
+//	go fasthttp.ListenAndServe(":9100", mc.Handler)
+package prommetrics
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultDurationBuckets contains default upper bounds in seconds
+// for request duration histogram.
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets contains default upper bounds in bytes
+// for body size histograms.
+var DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1e6, 1e7, 1e8}
+
+// Collector implements fasthttp.ServerMetricsCollector and exports
+// the collected metrics in Prometheus text format.
+//
+// The following metrics are exported, where <namespace> is the namespace
+// passed to NewCollector:
+//
+//	<namespace>_server_requests_total{class="2xx"}  counter
+//	<namespace>_server_request_duration_seconds     histogram
+//	<namespace>_server_requests_in_flight           gauge
+//	<namespace>_server_request_body_size_bytes      histogram
+//	<namespace>_server_response_body_size_bytes     histogram
+//	<namespace>_server_connections_total            counter
+//	<namespace>_server_open_connections             gauge
+//	<namespace>_server_hijacked_connections         gauge
+//
+// Bodies of unknown size aren't accounted in body size histograms.
+//
+// The same Collector may be shared among multiple servers.
+type Collector struct {
+	namespace string
+
+	// requests contains request counters indexed by status class.
+	// Index 0 is used for invalid status codes.
+	requests [6]uint64
+
+	inFlight      int64
+	connsTotal    uint64
+	openConns     int64
+	hijackedConns int64
+
+	duration histogram
+	reqSize  histogram
+	respSize histogram
+}
+
+// NewCollector returns new collector for metrics with the given
+// namespace prefix.
+//
+// "fasthttp" namespace is used if namespace is empty.
+func NewCollector(namespace string) *Collector {
+	if len(namespace) == 0 {
+		namespace = "fasthttp"
+	}
+	c := &Collector{
+		namespace: namespace,
+	}
+	c.duration.init(DefaultDurationBuckets)
+	c.reqSize.init(DefaultSizeBuckets)
+	c.respSize.init(DefaultSizeBuckets)
+	return c
+}
+
+// ConnOpened implements fasthttp.ServerMetricsCollector.
+func (c *Collector) ConnOpened() {
+	atomic.AddUint64(&c.connsTotal, 1)
+	atomic.AddInt64(&c.openConns, 1)
+}
+
+// ConnHijacked implements fasthttp.ServerMetricsCollector.
+func (c *Collector) ConnHijacked() {
+	atomic.AddInt64(&c.hijackedConns, 1)
+}
+
+// ConnClosed implements fasthttp.ServerMetricsCollector.
+func (c *Collector) ConnClosed(hijacked bool) {
+	if hijacked {
+		atomic.AddInt64(&c.hijackedConns, -1)
+	}
+	atomic.AddInt64(&c.openConns, -1)
+}
+
+// RequestStarted implements fasthttp.ServerMetricsCollector.
+func (c *Collector) RequestStarted() {
+	atomic.AddInt64(&c.inFlight, 1)
+}
+
+// RequestFinished implements fasthttp.ServerMetricsCollector.
+func (c *Collector) RequestFinished(statusCode int, duration time.Duration, requestBodySize, responseBodySize int) {
+	atomic.AddInt64(&c.inFlight, -1)
+	class := statusCode / 100
+	if class < 1 || class > 5 {
+		class = 0
+	}
+	atomic.AddUint64(&c.requests[class], 1)
+	c.duration.observe(duration.Seconds())
+	if requestBodySize >= 0 {
+		c.reqSize.observe(float64(requestBodySize))
+	}
+	if responseBodySize >= 0 {
+		c.respSize.observe(float64(responseBodySize))
+	}
+}
+
+// Handler serves the collected metrics in Prometheus text format.
+func (c *Collector) Handler(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("text/plain; version=0.0.4; charset=utf-8")
+	c.WriteMetrics(ctx)
+}
+
+// WriteMetrics writes the collected metrics in Prometheus text format to w.
+func (c *Collector) WriteMetrics(w io.Writer) error {
+	var dst []byte
+
+	name := c.metricName("requests_total")
+	dst = appendHeader(dst, name, "Total number of served requests by status class.", "counter")
+	for class := 1; class <= 5; class++ {
+		dst = append(dst, name...)
+		dst = append(dst, `{class="`...)
+		dst = fasthttp.AppendUint(dst, class)
+		dst = append(dst, `xx"} `...)
+		dst = strconv.AppendUint(dst, atomic.LoadUint64(&c.requests[class]), 10)
+		dst = append(dst, '\n')
+	}
+	dst = append(dst, name...)
+	dst = append(dst, `{class="other"} `...)
+	dst = strconv.AppendUint(dst, atomic.LoadUint64(&c.requests[0]), 10)
+	dst = append(dst, '\n')
+
+	dst = c.duration.appendMetrics(dst, c.metricName("request_duration_seconds"), "Request duration in seconds.")
+	dst = appendGauge(dst, c.metricName("requests_in_flight"), "Number of requests being served.", atomic.LoadInt64(&c.inFlight))
+	dst = c.reqSize.appendMetrics(dst, c.metricName("request_body_size_bytes"), "Request body size in bytes.")
+	dst = c.respSize.appendMetrics(dst, c.metricName("response_body_size_bytes"), "Response body size in bytes.")
+
+	name = c.metricName("connections_total")
+	dst = appendHeader(dst, name, "Total number of served connections.", "counter")
+	dst = append(dst, name...)
+	dst = append(dst, ' ')
+	dst = strconv.AppendUint(dst, atomic.LoadUint64(&c.connsTotal), 10)
+	dst = append(dst, '\n')
+
+	dst = appendGauge(dst, c.metricName("open_connections"), "Number of open connections including hijacked ones.", atomic.LoadInt64(&c.openConns))
+	dst = appendGauge(dst, c.metricName("hijacked_connections"), "Number of open hijacked connections.", atomic.LoadInt64(&c.hijackedConns))
+
+	_, err := w.Write(dst)
+	return err
+}
+
+func (c *Collector) metricName(name string) string {
+	return c.namespace + "_server_" + name
+}
+
+func appendHeader(dst []byte, name, help, typ string) []byte {
+	dst = append(dst, "# HELP "...)
+	dst = append(dst, name...)
+	dst = append(dst, ' ')
+	dst = append(dst, help...)
+	dst = append(dst, "\n# TYPE "...)
+	dst = append(dst, name...)
+	dst = append(dst, ' ')
+	dst = append(dst, typ...)
+	return append(dst, '\n')
+}
+
+func appendGauge(dst []byte, name, help string, v int64) []byte {
+	dst = appendHeader(dst, name, help, "gauge")
+	dst = append(dst, name...)
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, v, 10)
+	return append(dst, '\n')
+}
+
+type histogram struct {
+	upperBounds []float64
+
+	// counts contains non-cumulative bucket counts. The last bucket
+	// is +Inf.
+	counts  []uint64
+	sumBits uint64
+}
+
+func (h *histogram) init(upperBounds []float64) {
+	h.upperBounds = upperBounds
+	h.counts = make([]uint64, len(upperBounds)+1)
+}
+
+func (h *histogram) observe(v float64) {
+	n := sort.SearchFloat64s(h.upperBounds, v)
+	atomic.AddUint64(&h.counts[n], 1)
+	for {
+		oldBits := atomic.LoadUint64(&h.sumBits)
+		newBits := math.Float64bits(math.Float64frombits(oldBits) + v)
+		if atomic.CompareAndSwapUint64(&h.sumBits, oldBits, newBits) {
+			break
+		}
+	}
+}
+
+func (h *histogram) appendMetrics(dst []byte, name, help string) []byte {
+	dst = appendHeader(dst, name, help, "histogram")
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		dst = append(dst, name...)
+		dst = append(dst, `_bucket{le="`...)
+		if i < len(h.upperBounds) {
+			dst = strconv.AppendFloat(dst, h.upperBounds[i], 'g', -1, 64)
+		} else {
+			dst = append(dst, "+Inf"...)
+		}
+		dst = append(dst, `"} `...)
+		dst = strconv.AppendUint(dst, cumulative, 10)
+		dst = append(dst, '\n')
+	}
+	dst = append(dst, name...)
+	dst = append(dst, "_sum "...)
+	dst = strconv.AppendFloat(dst, math.Float64frombits(atomic.LoadUint64(&h.sumBits)), 'g', -1, 64)
+	dst = append(dst, '\n')
+	dst = append(dst, name...)
+	dst = append(dst, "_count "...)
+	// Use the +Inf bucket value, so the count is consistent with buckets.
+	dst = strconv.AppendUint(dst, cumulative, 10)
+	return append(dst, '\n')
+}
@@ -0,0 +1,137 @@
+package prommetrics
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestCollector(t *testing.T) {
+	mc := NewCollector("")
+	hijacked := make(chan struct{})
+	release := make(chan struct{})
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/hijack":
+				ctx.Hijack(func(c net.Conn) {
+					close(hijacked)
+					<-release
+				})
+			case "/missing":
+				ctx.Error("not found", fasthttp.StatusNotFound)
+			default:
+				ctx.WriteString("foobar")
+			}
+		},
+		MetricsCollector: mc,
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln)
+
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	for _, uri := range []string{"/", "/missing"} {
+		if _, _, err := c.Post(nil, "http://foobar"+uri, nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("GET /hijack HTTP/1.1\r\nHost: foobar\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	<-hijacked
+
+	testMetrics(t, mc, []string{
+		`fasthttp_server_requests_total{class="2xx"} 2`,
+		`fasthttp_server_requests_total{class="4xx"} 1`,
+		`fasthttp_server_requests_total{class="other"} 0`,
+		`fasthttp_server_request_duration_seconds_count 3`,
+		`fasthttp_server_requests_in_flight 0`,
+		`fasthttp_server_request_body_size_bytes_bucket{le="100"} 3`,
+		`fasthttp_server_response_body_size_bytes_bucket{le="100"} 3`,
+		`fasthttp_server_response_body_size_bytes_sum 15`,
+		`fasthttp_server_connections_total 2`,
+		`fasthttp_server_open_connections 2`,
+		`fasthttp_server_hijacked_connections 1`,
+	})
+
+	close(release)
+	testMetrics(t, mc, []string{
+		`fasthttp_server_open_connections 1`,
+		`fasthttp_server_hijacked_connections 0`,
+	})
+}
+
+func testMetrics(t *testing.T, mc *Collector, expectedLines []string) {
+	t.Helper()
+	var buf bytes.Buffer
+	deadline := time.Now().Add(time.Second)
+	for {
+		buf.Reset()
+		if err := mc.WriteMetrics(&buf); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		missing := ""
+		for _, line := range expectedLines {
+			if !strings.Contains(buf.String(), line+"\n") {
+				missing = line
+				break
+			}
+		}
+		if len(missing) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("missing %q in metrics:\n%s", missing, buf.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCollectorHistogram(t *testing.T) {
+	mc := NewCollector("myapp")
+	mc.RequestStarted()
+	mc.RequestFinished(200, 30*time.Millisecond, 50, -1)
+	mc.RequestStarted()
+	mc.RequestFinished(600, 2*time.Second, 5000, 200000000)
+
+	var buf bytes.Buffer
+	if err := mc.WriteMetrics(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s := buf.String()
+	for _, line := range []string{
+		"# TYPE myapp_server_request_duration_seconds histogram\n",
+		`myapp_server_requests_total{class="other"} 1` + "\n",
+		`myapp_server_request_duration_seconds_bucket{le="0.025"} 0` + "\n",
+		`myapp_server_request_duration_seconds_bucket{le="0.05"} 1` + "\n",
+		`myapp_server_request_duration_seconds_bucket{le="2.5"} 2` + "\n",
+		`myapp_server_request_duration_seconds_bucket{le="+Inf"} 2` + "\n",
+		"myapp_server_request_duration_seconds_sum 2.03\n",
+		`myapp_server_request_body_size_bytes_bucket{le="100"} 1` + "\n",
+		`myapp_server_request_body_size_bytes_bucket{le="10000"} 2` + "\n",
+		"myapp_server_request_body_size_bytes_sum 5050\n",
+		`myapp_server_response_body_size_bytes_bucket{le="1e+08"} 0` + "\n",
+		`myapp_server_response_body_size_bytes_bucket{le="+Inf"} 1` + "\n",
+		"myapp_server_response_body_size_bytes_count 1\n",
+	} {
+		if !strings.Contains(s, line) {
+			t.Fatalf("missing %q in metrics:\n%s", line, s)
+		}
+	}
+}
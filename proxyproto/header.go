@@ -0,0 +1,240 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Header is PROXY protocol header.
+//
+// See https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+// for details.
+type Header struct {
+	// Version is PROXY protocol version: 1 or 2.
+	Version int
+
+	// SourceAddr is the original client address.
+	//
+	// SourceAddr is nil if the proxy doesn't know the client address,
+	// e.g. for health check connections from the proxy itself.
+	SourceAddr *net.TCPAddr
+
+	// DestinationAddr is the original server address the client
+	// connected to.
+	//
+	// DestinationAddr is nil if SourceAddr is nil.
+	DestinationAddr *net.TCPAddr
+}
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// v1MaxHeaderLen is the maximum length of v1 header including CRLF.
+	v1MaxHeaderLen = 107
+
+	v2CmdLocal = 0x20
+	v2CmdProxy = 0x21
+
+	v2FamilyTCP4 = 0x11
+	v2FamilyTCP6 = 0x21
+)
+
+var errInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// ReadHeader reads PROXY protocol v1 or v2 header from r.
+//
+// ReadHeader doesn't read data past the header, so the remaining data
+// may be read from r after ReadHeader returns.
+func ReadHeader(r io.Reader) (*Header, error) {
+	// The shortest v1 header is "PROXY UNKNOWN\r\n", so it is safe to read
+	// the whole v2 signature at once.
+	var buf [v1MaxHeaderLen]byte
+	b := buf[:len(v2Signature)]
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("cannot read PROXY protocol header: %s", err)
+	}
+	if bytes.Equal(b, v2Signature) {
+		return readHeaderV2(r)
+	}
+	if !bytes.HasPrefix(b, v1Prefix) {
+		return nil, errInvalidHeader
+	}
+	for b[len(b)-1] != '\n' {
+		if len(b) == len(buf) {
+			return nil, fmt.Errorf("too long PROXY protocol v1 header. Max length is %d bytes", v1MaxHeaderLen)
+		}
+		b = buf[:len(b)+1]
+		if _, err := io.ReadFull(r, b[len(b)-1:]); err != nil {
+			return nil, fmt.Errorf("cannot read PROXY protocol v1 header: %s", err)
+		}
+	}
+	return parseHeaderV1(b)
+}
+
+func parseHeaderV1(b []byte) (*Header, error) {
+	if !bytes.HasSuffix(b, []byte("\r\n")) {
+		return nil, errInvalidHeader
+	}
+	fields := bytes.Split(b[len(v1Prefix):len(b)-2], []byte(" "))
+	h := &Header{
+		Version: 1,
+	}
+	switch string(fields[0]) {
+	case "UNKNOWN":
+		// The rest of the header must be ignored.
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol v1 family %q", fields[0])
+	}
+	if len(fields) != 5 {
+		return nil, errInvalidHeader
+	}
+	isIPv4 := string(fields[0]) == "TCP4"
+	var err error
+	if h.SourceAddr, err = parseAddrV1(fields[1], fields[3], isIPv4); err != nil {
+		return nil, err
+	}
+	if h.DestinationAddr, err = parseAddrV1(fields[2], fields[4], isIPv4); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func parseAddrV1(ipStr, portStr []byte, isIPv4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(string(ipStr))
+	if ip == nil || (bytes.IndexByte(ipStr, ':') < 0) != isIPv4 {
+		return nil, fmt.Errorf("invalid ip in PROXY protocol v1 header: %q", ipStr)
+	}
+	port, err := strconv.ParseUint(string(portStr), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in PROXY protocol v1 header: %q", portStr)
+	}
+	return &net.TCPAddr{
+		IP:   ip,
+		Port: int(port),
+	}, nil
+}
+
+func readHeaderV2(r io.Reader) (*Header, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, fmt.Errorf("cannot read PROXY protocol v2 header: %s", err)
+	}
+	cmd := buf[0]
+	family := buf[1]
+	n := int(binary.BigEndian.Uint16(buf[2:]))
+	if cmd != v2CmdLocal && cmd != v2CmdProxy {
+		return nil, fmt.Errorf("unsupported PROXY protocol v2 command 0x%02x", cmd)
+	}
+
+	// The length is limited by uint16, so the payload may be read at once.
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("cannot read PROXY protocol v2 addresses: %s", err)
+	}
+	h := &Header{
+		Version: 2,
+	}
+	if cmd == v2CmdLocal {
+		// Addresses must be ignored for LOCAL command.
+		return h, nil
+	}
+
+	var ipLen int
+	switch family {
+	case v2FamilyTCP4:
+		ipLen = net.IPv4len
+	case v2FamilyTCP6:
+		ipLen = net.IPv6len
+	default:
+		// Unsupported families such as UDP and unix sockets must be
+		// accepted, but their addresses must be ignored.
+		return h, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, fmt.Errorf("too short PROXY protocol v2 addresses: %d bytes", len(payload))
+	}
+	// TLVs following the addresses are ignored.
+	h.SourceAddr = &net.TCPAddr{
+		IP:   net.IP(payload[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	h.DestinationAddr = &net.TCPAddr{
+		IP:   net.IP(payload[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+	return h, nil
+}
+
+// AppendTo appends the header in wire format to dst and returns
+// the extended dst.
+//
+// PROXY protocol v2 is used if Version is 2, otherwise v1 is used.
+// The header without valid SourceAddr or DestinationAddr ip is written
+// as UNKNOWN for v1 and as LOCAL command for v2.
+func (h *Header) AppendTo(dst []byte) []byte {
+	src, dstAddr := h.SourceAddr, h.DestinationAddr
+	var srcIP, dstIP net.IP
+	if src != nil && dstAddr != nil {
+		srcIP, dstIP = src.IP.To4(), dstAddr.IP.To4()
+		if srcIP == nil || dstIP == nil {
+			// Mixed address families are sent as IPv6.
+			srcIP, dstIP = src.IP.To16(), dstAddr.IP.To16()
+		}
+	}
+	if srcIP == nil || dstIP == nil {
+		if h.Version == 2 {
+			dst = append(dst, v2Signature...)
+			return append(dst, v2CmdLocal, 0, 0, 0)
+		}
+		return append(dst, "PROXY UNKNOWN\r\n"...)
+	}
+	if h.Version == 2 {
+		family := byte(v2FamilyTCP4)
+		if len(srcIP) == net.IPv6len {
+			family = v2FamilyTCP6
+		}
+		dst = append(dst, v2Signature...)
+		dst = append(dst, v2CmdProxy, family, 0, byte(2*len(srcIP)+4))
+		dst = append(dst, srcIP...)
+		dst = append(dst, dstIP...)
+		dst = append(dst, byte(src.Port>>8), byte(src.Port), byte(dstAddr.Port>>8), byte(dstAddr.Port))
+		return dst
+	}
+
+	family := "TCP4 "
+	if len(srcIP) == net.IPv6len {
+		family = "TCP6 "
+	}
+	dst = append(dst, v1Prefix...)
+	dst = append(dst, family...)
+	dst = appendIPV1(dst, srcIP)
+	dst = append(dst, ' ')
+	dst = appendIPV1(dst, dstIP)
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, int64(src.Port), 10)
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, int64(dstAddr.Port), 10)
+	return append(dst, "\r\n"...)
+}
+
+func appendIPV1(dst []byte, ip net.IP) []byte {
+	if len(ip) == net.IPv6len {
+		if ip4 := ip.To4(); ip4 != nil {
+			// net.IP.String formats IPv4-mapped addresses as IPv4,
+			// while TCP6 requires IPv6 addresses.
+			dst = append(dst, "::ffff:"...)
+			return append(dst, ip4.String()...)
+		}
+	}
+	return append(dst, ip.String()...)
+}
@@ -0,0 +1,155 @@
+package proxyproto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestReadHeader(t *testing.T) {
+	testReadHeader(t, "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n", 1, "1.2.3.4:1234", "5.6.7.8:80")
+	testReadHeader(t, "PROXY TCP6 2001:db8::1 ::1 1234 443\r\n", 1, "[2001:db8::1]:1234", "[::1]:443")
+	testReadHeader(t, "PROXY UNKNOWN\r\n", 1, "", "")
+	testReadHeader(t, "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", 1, "", "")
+
+	testReadHeader(t, "\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c"+
+		"\x01\x02\x03\x04\x05\x06\x07\x08\x04\xd2\x00\x50", 2, "1.2.3.4:1234", "5.6.7.8:80")
+	testReadHeader(t, "\r\n\r\n\x00\r\nQUIT\n\x21\x21\x00\x27"+
+		"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01"+
+		"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01"+
+		"\x04\xd2\x01\xbb"+
+		"\x04\x00\x00", 2, "[2001:db8::1]:1234", "[::1]:443")
+	testReadHeader(t, "\r\n\r\n\x00\r\nQUIT\n\x20\x11\x00\x0c"+
+		"\x01\x02\x03\x04\x05\x06\x07\x08\x04\xd2\x00\x50", 2, "", "")
+	testReadHeader(t, "\r\n\r\n\x00\r\nQUIT\n\x21\x12\x00\x0c"+
+		"\x01\x02\x03\x04\x05\x06\x07\x08\x04\xd2\x00\x50", 2, "", "")
+}
+
+func testReadHeader(t *testing.T, header string, expectedVersion int, expectedSrc, expectedDst string) {
+	t.Helper()
+	r := bytes.NewBufferString(header + "GET / HTTP/1.1\r\n")
+	h, err := ReadHeader(r)
+	if err != nil {
+		t.Fatalf("unexpected error when reading %q: %s", header, err)
+	}
+	if h.Version != expectedVersion {
+		t.Fatalf("unexpected version: %d. Expecting %d", h.Version, expectedVersion)
+	}
+	var src, dst string
+	if h.SourceAddr != nil {
+		src = h.SourceAddr.String()
+	}
+	if h.DestinationAddr != nil {
+		dst = h.DestinationAddr.String()
+	}
+	if src != expectedSrc || dst != expectedDst {
+		t.Fatalf("unexpected addresses %q, %q. Expecting %q, %q", src, dst, expectedSrc, expectedDst)
+	}
+
+	// The data following the header mustn't be consumed.
+	tail, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(tail) != "GET / HTTP/1.1\r\n" {
+		t.Fatalf("unexpected data after the header: %q", tail)
+	}
+}
+
+func TestReadHeaderError(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"GET / HTTP/1.1\r\n\r\n",
+		"PROXY TCP4 1.2.3.4 5.6.7.8 1234\r\n",
+		"PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\n",
+		"PROXY TCP4 2001:db8::1 5.6.7.8 1234 80\r\n",
+		"PROXY TCP6 1.2.3.4 ::1 1234 80\r\n",
+		"PROXY TCP4 1.2.3.4 5.6.7.8 1234 65536\r\n",
+		"PROXY UDP4 1.2.3.4 5.6.7.8 1234 80\r\n",
+		"PROXY TCP4 1.2.3.4 5.6.7.8 1234 80" + string(bytes.Repeat([]byte(" "), 100)) + "\r\n",
+		"\r\n\r\n\x00\r\nQUIT\n\x22\x11\x00\x00",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x04\x01\x02\x03\x04",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c\x01\x02",
+	} {
+		if _, err := ReadHeader(bytes.NewBufferString(header)); err == nil {
+			t.Fatalf("expecting error for header %q", header)
+		}
+	}
+}
+
+func TestHeaderAppendTo(t *testing.T) {
+	testHeaderAppendTo(t, 1, "1.2.3.4:1234", "5.6.7.8:80", "PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\n")
+	testHeaderAppendTo(t, 1, "[2001:db8::1]:1234", "[::1]:443", "PROXY TCP6 2001:db8::1 ::1 1234 443\r\n")
+	testHeaderAppendTo(t, 1, "1.2.3.4:1234", "[::1]:443", "PROXY TCP6 ::ffff:1.2.3.4 ::1 1234 443\r\n")
+	testHeaderAppendTo(t, 1, "", "", "PROXY UNKNOWN\r\n")
+	testHeaderAppendTo(t, 2, "1.2.3.4:1234", "5.6.7.8:80", "\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c"+
+		"\x01\x02\x03\x04\x05\x06\x07\x08\x04\xd2\x00\x50")
+	testHeaderAppendTo(t, 2, "[2001:db8::1]:1234", "[::1]:443", "")
+	testHeaderAppendTo(t, 2, "", "", "\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00")
+}
+
+func TestHeaderAppendToInvalidIP(t *testing.T) {
+	addr := &net.TCPAddr{
+		IP:   net.IPv4(1, 2, 3, 4),
+		Port: 1234,
+	}
+	for _, invalidAddr := range []*net.TCPAddr{{Port: 80}, {IP: net.IP{1, 2}, Port: 80}} {
+		h := &Header{
+			Version:         1,
+			SourceAddr:      addr,
+			DestinationAddr: invalidAddr,
+		}
+		if b := h.AppendTo(nil); string(b) != "PROXY UNKNOWN\r\n" {
+			t.Fatalf("unexpected header %q. Expecting %q", b, "PROXY UNKNOWN\r\n")
+		}
+		h.Version = 2
+		h.SourceAddr, h.DestinationAddr = invalidAddr, addr
+		expectedHeader := "\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00"
+		if b := h.AppendTo(nil); string(b) != expectedHeader {
+			t.Fatalf("unexpected header %q. Expecting %q", b, expectedHeader)
+		}
+	}
+}
+
+func testHeaderAppendTo(t *testing.T, version int, src, dst, expectedHeader string) {
+	t.Helper()
+	h := &Header{
+		Version: version,
+	}
+	if len(src) > 0 {
+		h.SourceAddr = mustResolveTCPAddr(t, src)
+		h.DestinationAddr = mustResolveTCPAddr(t, dst)
+	}
+	b := h.AppendTo(nil)
+	if len(expectedHeader) > 0 && string(b) != expectedHeader {
+		t.Fatalf("unexpected header %q. Expecting %q", b, expectedHeader)
+	}
+
+	// The header must be read back.
+	h, err := ReadHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("cannot read header %q: %s", b, err)
+	}
+	if h.Version != version {
+		t.Fatalf("unexpected version: %d. Expecting %d", h.Version, version)
+	}
+	if len(src) == 0 {
+		if h.SourceAddr != nil || h.DestinationAddr != nil {
+			t.Fatalf("unexpected addresses in header %q: %s, %s", b, h.SourceAddr, h.DestinationAddr)
+		}
+		return
+	}
+	if h.SourceAddr.String() != src || h.DestinationAddr.String() != dst {
+		t.Fatalf("unexpected addresses %s, %s. Expecting %s, %s", h.SourceAddr, h.DestinationAddr, src, dst)
+	}
+}
+
+func mustResolveTCPAddr(t *testing.T, addr string) *net.TCPAddr {
+	t.Helper()
+	a, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		t.Fatalf("cannot resolve %q: %s", addr, err)
+	}
+	return a
+}
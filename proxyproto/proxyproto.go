@@ -0,0 +1,171 @@
+// Package proxyproto provides HAProxy PROXY protocol support.
+//
+// PROXY protocol is used by TCP proxies and load balancers such as HAProxy
+// and AWS Network Load Balancer for passing the original client address
+// to the server.
+//
+// Example usage:
+//
+//	ln, err := net.Listen("tcp4", ":8080")
+//	if err != nil {
+//		log.Fatalf("cannot listen: %s", err)
+//	}
+//	ln = &proxyproto.Listener{
+//		Listener: ln,
+//	}
+//	// ctx.RemoteAddr() returns the original client address.
+//	fasthttp.Serve(ln, requestHandler)
+package proxyproto
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout is the default timeout for reading PROXY protocol
+// header.
+const DefaultHeaderTimeout = 10 * time.Second
+
+// Listener reads PROXY protocol v1 or v2 header from accepted connections.
+//
+// RemoteAddr and LocalAddr of accepted connections return addresses
+// from the header. Connections without valid header are closed on the first
+// Read call, so Listener must be used only for connections from proxies
+// sending the header.
+//
+// The header is read on the first Read, RemoteAddr or LocalAddr call
+// instead of Accept, so slow clients don't block accepting new connections.
+// fasthttp.Server makes these calls outside its accept loop, including
+// MaxConnsPerIP checks.
+type Listener struct {
+	// Listener is the wrapped listener.
+	Listener net.Listener
+
+	// HeaderTimeout is the maximum duration for reading the header.
+	//
+	// DefaultHeaderTimeout is used by default.
+	HeaderTimeout time.Duration
+}
+
+// Accept implements net.Listener.
+func (ln *Listener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	headerTimeout := ln.HeaderTimeout
+	if headerTimeout <= 0 {
+		headerTimeout = DefaultHeaderTimeout
+	}
+	return &Conn{
+		Conn:          c,
+		headerTimeout: headerTimeout,
+	}, nil
+}
+
+// Close implements net.Listener.
+func (ln *Listener) Close() error {
+	return ln.Listener.Close()
+}
+
+// Addr implements net.Listener.
+func (ln *Listener) Addr() net.Addr {
+	return ln.Listener.Addr()
+}
+
+// Conn is a connection accepted by Listener.
+type Conn struct {
+	net.Conn
+
+	headerTimeout time.Duration
+	headerOnce    sync.Once
+	header        *Header
+	headerErr     error
+
+	// readDeadline is the deadline set via SetReadDeadline or SetDeadline.
+	// It is restored after reading the header.
+	readDeadlineLock sync.Mutex
+	readDeadline     time.Time
+}
+
+// Header returns PROXY protocol header read from the connection.
+func (c *Conn) Header() (*Header, error) {
+	c.headerOnce.Do(c.readHeader)
+	return c.header, c.headerErr
+}
+
+func (c *Conn) readHeader() {
+	c.readDeadlineLock.Lock()
+	deadline := time.Now().Add(c.headerTimeout)
+	if !c.readDeadline.IsZero() && c.readDeadline.Before(deadline) {
+		deadline = c.readDeadline
+	}
+	c.headerErr = c.Conn.SetReadDeadline(deadline)
+	c.readDeadlineLock.Unlock()
+	if c.headerErr != nil {
+		return
+	}
+
+	c.header, c.headerErr = ReadHeader(c.Conn)
+	if c.headerErr != nil {
+		// Do not serve connections without valid header, since
+		// the real client address is unknown.
+		c.Conn.Close()
+		return
+	}
+
+	c.readDeadlineLock.Lock()
+	c.headerErr = c.Conn.SetReadDeadline(c.readDeadline)
+	c.readDeadlineLock.Unlock()
+}
+
+// Read implements net.Conn.
+//
+// Read returns error if the connection has no valid PROXY protocol header.
+func (c *Conn) Read(p []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+// RemoteAddr returns the original client address from PROXY protocol header.
+//
+// The address of the proxy is returned if the header contains no address
+// or it cannot be read.
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.SourceAddr != nil {
+		return h.SourceAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the original server address from PROXY protocol header.
+//
+// The local address of the connection is returned if the header contains
+// no address or it cannot be read.
+func (c *Conn) LocalAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h.DestinationAddr != nil {
+		return h.DestinationAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// SetDeadline implements net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.readDeadlineLock.Lock()
+	c.readDeadline = t
+	err := c.Conn.SetDeadline(t)
+	c.readDeadlineLock.Unlock()
+	return err
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadlineLock.Lock()
+	c.readDeadline = t
+	err := c.Conn.SetReadDeadline(t)
+	c.readDeadlineLock.Unlock()
+	return err
+}
@@ -0,0 +1,154 @@
+package proxyproto_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"github.com/valyala/fasthttp/proxyproto"
+)
+
+func TestListener(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString(ctx.RemoteAddr().String())
+			ctx.WriteString(" ")
+			ctx.WriteString(ctx.LocalAddr().String())
+		},
+		ReadTimeout: time.Second,
+	}
+	go s.Serve(&proxyproto.Listener{
+		Listener: ln,
+	})
+	defer ln.Close()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\nGET / HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var resp fasthttp.Response
+	if err = resp.Read(bufio.NewReader(c)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "1.2.3.4:1234 5.6.7.8:80" {
+		t.Fatalf("unexpected addresses %q. Expecting %q", resp.Body(), "1.2.3.4:1234 5.6.7.8:80")
+	}
+}
+
+func TestListenerMaxConnsPerIP(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString(ctx.RemoteAddr().String())
+		},
+		MaxConnsPerIP: 1,
+	}
+	go s.Serve(&proxyproto.Listener{
+		Listener:      ln,
+		HeaderTimeout: 10 * time.Second,
+	})
+	defer ln.Close()
+
+	// A client without the header mustn't block accepting other clients.
+	silent, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer silent.Close()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1234 80\r\nGET / HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ch := make(chan error, 1)
+	var resp fasthttp.Response
+	go func() {
+		ch <- resp.Read(bufio.NewReader(c))
+	}()
+	select {
+	case err = <-ch:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+	if string(resp.Body()) != "1.2.3.4:1234" {
+		t.Fatalf("unexpected address %q. Expecting %q", resp.Body(), "1.2.3.4:1234")
+	}
+}
+
+func TestListenerInvalidHeader(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	pln := &proxyproto.Listener{
+		Listener: ln,
+	}
+	defer pln.Close()
+	go func() {
+		c, err := ln.Dial()
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			return
+		}
+		c.Write([]byte("GET / HTTP/1.1\r\nHost: aa\r\n\r\n"))
+	}()
+
+	c, err := pln.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = ioutil.ReadAll(c); err == nil {
+		t.Fatalf("expecting error for connection without PROXY protocol header")
+	}
+	if _, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		t.Fatalf("unexpected remote address %s", c.RemoteAddr())
+	}
+}
+
+func TestListenerHeaderTimeout(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	pln := &proxyproto.Listener{
+		Listener:      ln,
+		HeaderTimeout: 50 * time.Millisecond,
+	}
+	defer pln.Close()
+	go func() {
+		c, err := ln.Dial()
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			return
+		}
+		c.Write([]byte("PROXY TCP4"))
+	}()
+
+	c, err := pln.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ch := make(chan error, 1)
+	go func() {
+		_, err := c.(*proxyproto.Conn).Header()
+		ch <- err
+	}()
+	select {
+	case err = <-ch:
+		if err == nil {
+			t.Fatalf("expecting timeout error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
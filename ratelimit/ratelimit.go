@@ -0,0 +1,167 @@
+// Package ratelimit provides request rate limiting middleware for fasthttp.
+//
+// Requests are limited with token bucket per key. The key is the client IP
+// by default, but it may be obtained from request header or arbitrary
+// request data via Config.KeyFunc.
+//
+// Example usage:
+//
+//	m, err := ratelimit.New(ratelimit.Config{
+//		Rate:  10,
+//		Burst: 20,
+//	})
+//	if err != nil {
+//		log.Fatalf("cannot create rate limiter: %s", err)
+//	}
+//	fasthttp.ListenAndServe(":8080", m(requestHandler))
+//
+// Token buckets are stored in process memory by default. Implement Storage
+// for sharing token buckets among multiple server instances, for instance
+// via Redis.
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Limit is token bucket limit.
+type Limit struct {
+	// Rate is the number of tokens added to the bucket per second.
+	Rate float64
+
+	// Burst is the bucket capacity, i.e. the maximum number of requests
+	// allowed at once.
+	Burst int
+}
+
+// Storage stores token buckets.
+//
+// Storage implementation must be safe for concurrent use.
+type Storage interface {
+	// Take takes a token from the bucket for the given key.
+	//
+	// A new full bucket must be created if there is no bucket for the key.
+	//
+	// Take must return zero retryAfter if the token has been taken.
+	// Otherwise it must return the duration until the next token
+	// is available.
+	//
+	// Take mustn't retain key after returning.
+	Take(key []byte, limit Limit) (retryAfter time.Duration, err error)
+}
+
+// Config configures rate limiting middleware created via New.
+type Config struct {
+	// Rate is the maximum sustained number of requests per second per key.
+	//
+	// Rate must be positive.
+	Rate float64
+
+	// Burst is the maximum number of requests per key, which may be served
+	// at once above Rate.
+	//
+	// By default Burst is Rate rounded up.
+	Burst int
+
+	// KeyFunc returns the rate limiting key for the given request.
+	//
+	// Requests with empty key aren't limited.
+	// The returned key must remain valid until the request handler returns.
+	//
+	// By default KeyByIP is used.
+	KeyFunc func(ctx *fasthttp.RequestCtx) []byte
+
+	// Storage stores token buckets.
+	//
+	// Use distinct Storage per middleware or make keys distinct
+	// via KeyFunc if Storage is shared among middlewares.
+	//
+	// By default token buckets are stored in process memory.
+	Storage Storage
+}
+
+// New returns rate limiting middleware with the given config.
+//
+// Requests exceeding the limit are rejected with
+// '429 Too Many Requests' response containing Retry-After header.
+//
+// Requests are served if Storage returns error, since rate limiting
+// is usually less important than serving requests. Storage errors
+// are logged via RequestCtx.Logger.
+func New(cfg Config) (fasthttp.Middleware, error) {
+	if !(cfg.Rate > 0) || math.IsInf(cfg.Rate, 0) {
+		return nil, errors.New("rate limit must be positive")
+	}
+	l := &limiter{
+		limit: Limit{
+			Rate:  cfg.Rate,
+			Burst: cfg.Burst,
+		},
+		keyFunc: cfg.KeyFunc,
+		storage: cfg.Storage,
+	}
+	if l.limit.Burst <= 0 {
+		l.limit.Burst = int(math.Ceil(cfg.Rate))
+	}
+	if l.keyFunc == nil {
+		l.keyFunc = KeyByIP
+	}
+	if l.storage == nil {
+		l.storage = NewMemoryStorage()
+	}
+	return l.middleware, nil
+}
+
+// KeyByIP returns the client IP as rate limiting key.
+func KeyByIP(ctx *fasthttp.RequestCtx) []byte {
+	ip := ctx.RemoteIP()
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// KeyByHeader returns KeyFunc, which uses the given request header value
+// as rate limiting key.
+//
+// Requests without the header aren't limited, so combine it with
+// other limits if the header may be omitted by clients.
+func KeyByHeader(name string) func(ctx *fasthttp.RequestCtx) []byte {
+	return func(ctx *fasthttp.RequestCtx) []byte {
+		return ctx.Request.Header.Peek(name)
+	}
+}
+
+type limiter struct {
+	limit   Limit
+	keyFunc func(ctx *fasthttp.RequestCtx) []byte
+	storage Storage
+}
+
+func (l *limiter) middleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		key := l.keyFunc(ctx)
+		if len(key) > 0 {
+			retryAfter, err := l.storage.Take(key, l.limit)
+			if err != nil {
+				ctx.Logger().Printf("cannot apply rate limit: %s", err)
+			} else if retryAfter > 0 {
+				ctx.Error("Too Many Requests", fasthttp.StatusTooManyRequests)
+				var buf [20]byte
+				ctx.Response.Header.SetBytesV("Retry-After", fasthttp.AppendUint(buf[:0], retryAfterSeconds(retryAfter)))
+				return
+			}
+		}
+		h(ctx)
+	}
+}
+
+// retryAfterSeconds rounds d up to seconds, since Retry-After header
+// contains integer number of seconds.
+func retryAfterSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
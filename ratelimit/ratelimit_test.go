@@ -0,0 +1,163 @@
+package ratelimit
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func newTestStorage() (*MemoryStorage, *testClock) {
+	c := &testClock{
+		t: time.Unix(1500000000, 0),
+	}
+	s := NewMemoryStorage()
+	s.now = c.now
+	return s, c
+}
+
+func testRateLimit(t *testing.T, h fasthttp.RequestHandler, remoteIP string, prepare func(req *fasthttp.Request)) *fasthttp.RequestCtx {
+	t.Helper()
+	var req fasthttp.Request
+	req.SetRequestURI("/foo")
+	if prepare != nil {
+		prepare(&req)
+	}
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP(remoteIP)}, nil)
+	h(&ctx)
+	return &ctx
+}
+
+func expectStatus(t *testing.T, ctx *fasthttp.RequestCtx, expectedStatusCode int, expectedRetryAfter string) {
+	t.Helper()
+	if ctx.Response.StatusCode() != expectedStatusCode {
+		t.Fatalf("unexpected status code: %d. Expecting %d", ctx.Response.StatusCode(), expectedStatusCode)
+	}
+	if retryAfter := string(ctx.Response.Header.Peek("Retry-After")); retryAfter != expectedRetryAfter {
+		t.Fatalf("unexpected Retry-After: %q. Expecting %q", retryAfter, expectedRetryAfter)
+	}
+}
+
+func TestRateLimitByIP(t *testing.T) {
+	s, c := newTestStorage()
+	m, err := New(Config{
+		Rate:    0.5,
+		Burst:   2,
+		Storage: s,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	h := m(func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("OK")
+	})
+
+	expectStatus(t, testRateLimit(t, h, "1.2.3.4", nil), fasthttp.StatusOK, "")
+	expectStatus(t, testRateLimit(t, h, "1.2.3.4", nil), fasthttp.StatusOK, "")
+	expectStatus(t, testRateLimit(t, h, "1.2.3.4", nil), fasthttp.StatusTooManyRequests, "2")
+
+	// Other ips have distinct buckets.
+	expectStatus(t, testRateLimit(t, h, "::1", nil), fasthttp.StatusOK, "")
+
+	c.t = c.t.Add(1500 * time.Millisecond)
+	expectStatus(t, testRateLimit(t, h, "1.2.3.4", nil), fasthttp.StatusTooManyRequests, "1")
+	c.t = c.t.Add(500 * time.Millisecond)
+	expectStatus(t, testRateLimit(t, h, "1.2.3.4", nil), fasthttp.StatusOK, "")
+	expectStatus(t, testRateLimit(t, h, "1.2.3.4", nil), fasthttp.StatusTooManyRequests, "2")
+}
+
+func TestRateLimitByHeader(t *testing.T) {
+	s, _ := newTestStorage()
+	m, err := New(Config{
+		Rate:    1,
+		KeyFunc: KeyByHeader("X-Api-Key"),
+		Storage: s,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	h := m(func(ctx *fasthttp.RequestCtx) {})
+	setKey := func(key string) func(req *fasthttp.Request) {
+		return func(req *fasthttp.Request) {
+			req.Header.Set("X-Api-Key", key)
+		}
+	}
+
+	expectStatus(t, testRateLimit(t, h, "1.2.3.4", setKey("foo")), fasthttp.StatusOK, "")
+	expectStatus(t, testRateLimit(t, h, "1.2.3.4", setKey("foo")), fasthttp.StatusTooManyRequests, "1")
+	expectStatus(t, testRateLimit(t, h, "1.2.3.4", setKey("bar")), fasthttp.StatusOK, "")
+
+	// Requests without key aren't limited.
+	for i := 0; i < 3; i++ {
+		expectStatus(t, testRateLimit(t, h, "1.2.3.4", nil), fasthttp.StatusOK, "")
+	}
+}
+
+type errorStorage struct{}
+
+func (s errorStorage) Take(key []byte, limit Limit) (time.Duration, error) {
+	return 0, errors.New("storage is unavailable")
+}
+
+func TestRateLimitStorageError(t *testing.T) {
+	m, err := New(Config{
+		Rate:    1,
+		Storage: errorStorage{},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	h := m(func(ctx *fasthttp.RequestCtx) {})
+	for i := 0; i < 3; i++ {
+		expectStatus(t, testRateLimit(t, h, "1.2.3.4", nil), fasthttp.StatusOK, "")
+	}
+}
+
+func TestRateLimitInvalidConfig(t *testing.T) {
+	for _, rate := range []float64{0, -1} {
+		if _, err := New(Config{Rate: rate}); err == nil {
+			t.Fatalf("expecting error for rate %v", rate)
+		}
+	}
+}
+
+func TestMemoryStorageCleanup(t *testing.T) {
+	s, c := newTestStorage()
+	limit := Limit{
+		Rate:  1,
+		Burst: 10,
+	}
+	for _, key := range []string{"foo", "bar"} {
+		if d, err := s.Take([]byte(key), limit); err != nil || d != 0 {
+			t.Fatalf("unexpected result: %s, %s", d, err)
+		}
+	}
+
+	c.t = c.t.Add(memoryStorageCleanupInterval + time.Second)
+	for i := 0; i < 10; i++ {
+		if d, err := s.Take([]byte("foo"), limit); err != nil || d != 0 {
+			t.Fatalf("unexpected result: %s, %s", d, err)
+		}
+	}
+	if len(s.buckets) != 1 {
+		t.Fatalf("unexpected number of buckets: %d. Expecting 1", len(s.buckets))
+	}
+	d, err := s.Take([]byte("foo"), limit)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d != time.Second {
+		t.Fatalf("unexpected retryAfter: %s. Expecting %s", d, time.Second)
+	}
+}
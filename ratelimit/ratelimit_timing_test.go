@@ -0,0 +1,29 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func BenchmarkRateLimit(b *testing.B) {
+	m, err := New(Config{
+		Rate: 1e9,
+	})
+	if err != nil {
+		b.Fatalf("unexpected error: %s", err)
+	}
+	h := m(func(ctx *fasthttp.RequestCtx) {})
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var req fasthttp.Request
+		req.SetRequestURI("/foo")
+		var ctx fasthttp.RequestCtx
+		ctx.Init(&req, &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4)}, nil)
+		for pb.Next() {
+			h(&ctx)
+		}
+	})
+}
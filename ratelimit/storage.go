@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStorage stores token buckets in process memory.
+//
+// Full buckets are periodically removed, so memory usage is proportional
+// to the number of recently active keys.
+type MemoryStorage struct {
+	lock        sync.Mutex
+	buckets     map[string]*bucket
+	lastCleanup time.Time
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens   float64
+	lastTime time.Time
+}
+
+// memoryStorageCleanupInterval is the interval for removing full buckets
+// from MemoryStorage.
+const memoryStorageCleanupInterval = time.Minute
+
+// NewMemoryStorage returns new in-memory token bucket storage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take implements Storage.
+func (s *MemoryStorage) Take(key []byte, limit Limit) (time.Duration, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastCleanup) > memoryStorageCleanupInterval {
+		s.cleanup(now, limit)
+		s.lastCleanup = now
+	}
+
+	b := s.buckets[string(key)]
+	if b == nil {
+		b = &bucket{
+			tokens:   float64(limit.Burst),
+			lastTime: now,
+		}
+		s.buckets[string(key)] = b
+	}
+	b.refill(now, limit)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, nil
+	}
+	d := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	if d <= 0 {
+		d = 1
+	}
+	return d, nil
+}
+
+func (s *MemoryStorage) cleanup(now time.Time, limit Limit) {
+	for k, b := range s.buckets {
+		b.refill(now, limit)
+		if b.tokens >= float64(limit.Burst) {
+			delete(s.buckets, k)
+		}
+	}
+}
+
+func (b *bucket) refill(now time.Time, limit Limit) {
+	if elapsed := now.Sub(b.lastTime); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * limit.Rate
+		if b.tokens > float64(limit.Burst) {
+			b.tokens = float64(limit.Burst)
+		}
+	}
+	b.lastTime = now
+}
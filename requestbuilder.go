@@ -0,0 +1,164 @@
+package fasthttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// RequestBuilder builds a request with chained method calls
+// and performs it via Client:
+//
+//	resp, err := c.NewRequest().
+//		Method("POST").
+//		URL("https://example.com/api/items").
+//		Header("X-Request-Id", id).
+//		QueryParam("dry_run", "1").
+//		BodyJSON(item).
+//		Do(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer fasthttp.ReleaseResponse(resp)
+//
+// The request is obtained via AcquireRequest and is released after Do
+// returns, so the builder doesn't give up pooling. The first error
+// occurred while building the request is returned from Do.
+//
+// RequestBuilder instance MUST NOT be used from concurrently running
+// goroutines and MUST NOT be used after Do or Release calls.
+type RequestBuilder struct {
+	c     *Client
+	req   *Request
+	query Args
+	err   error
+}
+
+var requestBuilderPool sync.Pool
+
+// NewRequest returns RequestBuilder for performing a request via c.
+//
+// Call either RequestBuilder.Do or RequestBuilder.Release
+// on the returned builder, so its' resources are returned to the pool.
+func (c *Client) NewRequest() *RequestBuilder {
+	v := requestBuilderPool.Get()
+	if v == nil {
+		v = &RequestBuilder{}
+	}
+	b := v.(*RequestBuilder)
+	b.c = c
+	b.req = AcquireRequest()
+	return b
+}
+
+// Method sets request method.
+//
+// GET is used by default.
+func (b *RequestBuilder) Method(method string) *RequestBuilder {
+	b.req.Header.SetMethod(method)
+	return b
+}
+
+// URL sets request url.
+func (b *RequestBuilder) URL(url string) *RequestBuilder {
+	b.req.SetRequestURI(url)
+	return b
+}
+
+// Header sets request header with the given key to the given value.
+func (b *RequestBuilder) Header(key, value string) *RequestBuilder {
+	b.req.Header.Set(key, value)
+	return b
+}
+
+// QueryParam adds the given query arg to request url.
+//
+// Query args are added to the url passed to URL, so QueryParam
+// may be called before URL.
+func (b *RequestBuilder) QueryParam(key, value string) *RequestBuilder {
+	b.query.Add(key, value)
+	return b
+}
+
+// Body sets request body.
+//
+// It is safe re-using body after the function returns.
+func (b *RequestBuilder) Body(body []byte) *RequestBuilder {
+	b.req.SetBody(body)
+	return b
+}
+
+// BodyString sets request body.
+func (b *RequestBuilder) BodyString(body string) *RequestBuilder {
+	b.req.SetBodyString(body)
+	return b
+}
+
+// BodyJSON sets request body to JSON representation of v
+// and sets Content-Type to application/json.
+//
+// The error occurred while marshaling v is returned from Do.
+func (b *RequestBuilder) BodyJSON(v interface{}) *RequestBuilder {
+	if b.err != nil {
+		return b
+	}
+	b.req.ResetBody()
+	bodyBuf := b.req.bodyBuffer()
+	if err := json.NewEncoder(bodyBuf).Encode(v); err != nil {
+		b.err = fmt.Errorf("cannot marshal request body to JSON: %s", err)
+		return b
+	}
+	// Drop the trailing newline added by Encode.
+	bodyBuf.B = bodyBuf.B[:len(bodyBuf.B)-1]
+	b.req.Header.SetContentTypeBytes(strApplicationJSON)
+	return b
+}
+
+// Request returns the request being built.
+//
+// It may be used for setting request properties not covered
+// by RequestBuilder methods.
+func (b *RequestBuilder) Request() *Request {
+	return b.req
+}
+
+// Do performs the built request via Client.DoContext and returns
+// the response.
+//
+// The returned response must be released via ReleaseResponse
+// when no longer needed. nil response is returned on error.
+//
+// The builder is released and mustn't be used after Do returns.
+func (b *RequestBuilder) Do(ctx context.Context) (*Response, error) {
+	err := b.err
+	if err == nil && b.query.Len() > 0 {
+		queryArgs := b.req.URI().QueryArgs()
+		b.query.VisitAll(func(key, value []byte) {
+			queryArgs.AddBytesKV(key, value)
+		})
+	}
+	var resp *Response
+	if err == nil {
+		resp = AcquireResponse()
+		if err = b.c.DoContext(ctx, b.req, resp); err != nil {
+			ReleaseResponse(resp)
+			resp = nil
+		}
+	}
+	b.Release()
+	return resp, err
+}
+
+// Release returns the builder resources to the pool without performing
+// the request.
+//
+// The builder mustn't be used after Release returns.
+func (b *RequestBuilder) Release() {
+	ReleaseRequest(b.req)
+	b.req = nil
+	b.c = nil
+	b.query.Reset()
+	b.err = nil
+	requestBuilderPool.Put(b)
+}
@@ -0,0 +1,65 @@
+package fasthttp
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestRequestBuilder(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Response.Header.Set("X-Foo", string(ctx.Request.Header.Peek("X-Foo")))
+			ctx.SetContentType(string(ctx.Request.Header.ContentType()))
+			ctx.WriteString(string(ctx.Method()) + " " + string(ctx.RequestURI()) + " ")
+			ctx.Write(ctx.PostBody())
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	resp, err := c.NewRequest().
+		QueryParam("b", "x y").
+		Method("POST").
+		URL("http://example.com/foo?a=1").
+		Header("X-Foo", "bar").
+		BodyJSON(map[string]int{"n": 1}).
+		Do(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer ReleaseResponse(resp)
+
+	expectedBody := `POST /foo?a=1&b=x%20y {"n":1}`
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	if string(resp.Header.Peek("X-Foo")) != "bar" {
+		t.Fatalf("unexpected header %q. Expecting %q", resp.Header.Peek("X-Foo"), "bar")
+	}
+	if string(resp.Header.ContentType()) != "application/json" {
+		t.Fatalf("unexpected content-type %q. Expecting %q", resp.Header.ContentType(), "application/json")
+	}
+
+	// Marshaling errors must be returned from Do.
+	resp, err = c.NewRequest().
+		URL("http://example.com/").
+		BodyJSON(func() {}).
+		Do(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "JSON") {
+		t.Fatalf("expecting JSON marshaling error. Got %v", err)
+	}
+	if resp != nil {
+		t.Fatalf("unexpected non-nil response on error")
+	}
+}
@@ -0,0 +1,149 @@
+package router
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// HostSwitch dispatches requests to handlers registered for request host,
+// so multiple virtual hosts may be served by a single server.
+//
+// It is safe calling HostSwitch.Handler from concurrently running goroutines.
+// Hosts must be registered before HostSwitch.Handler is called.
+//
+// HostSwitch instance may be used as is without initialization:
+//
+//	var hs router.HostSwitch
+//	hs.Handle("example.com", exampleRouter.Handler)
+//	hs.Handle("*.example.com", tenantHandler)
+//	fasthttp.ListenAndServe(":8080", hs.Handler)
+type HostSwitch struct {
+	// Default is called for requests to hosts without registered handlers.
+	//
+	// By default '404 Not Found' response is sent.
+	Default fasthttp.RequestHandler
+
+	hosts map[string]fasthttp.RequestHandler
+
+	// wildcards are sorted by suffix length in descending order,
+	// so the most specific wildcard matches first.
+	wildcards []hostWildcard
+}
+
+type hostWildcard struct {
+	// suffix contains the wildcard host without leading '*',
+	// i.e. '.example.com' for '*.example.com'.
+	suffix string
+	h      fasthttp.RequestHandler
+}
+
+// Handle registers h for requests to the given host.
+//
+// The host is compared to request host case-insensitively, while the port
+// and the trailing dot are ignored in both hosts. The host may start
+// with '*.' wildcard, which matches any subdomain, i.e. '*.example.com'
+// matches 'foo.example.com' and 'foo.bar.example.com', but doesn't match
+// 'example.com'.
+//
+// Exact hosts take precedence over wildcards, while longer wildcards take
+// precedence over shorter wildcards.
+//
+// Handle panics if the host is invalid or already registered.
+func (s *HostSwitch) Handle(host string, h fasthttp.RequestHandler) {
+	if h == nil {
+		panic("router: handler cannot be nil")
+	}
+	host = strings.ToLower(string(stripHostPort([]byte(host))))
+	if len(host) == 0 || strings.IndexByte(host, '/') >= 0 || strings.IndexByte(host[1:], '*') >= 0 {
+		panic("router: invalid host '" + host + "'")
+	}
+
+	if host[0] == '*' {
+		if len(host) < 3 || host[1] != '.' {
+			panic("router: wildcard must be followed by '.' in host '" + host + "'")
+		}
+		suffix := host[1:]
+		for _, w := range s.wildcards {
+			if w.suffix == suffix {
+				panic("router: host '" + host + "' is already registered")
+			}
+		}
+		s.wildcards = append(s.wildcards, hostWildcard{
+			suffix: suffix,
+			h:      h,
+		})
+		sort.SliceStable(s.wildcards, func(i, j int) bool {
+			return len(s.wildcards[i].suffix) > len(s.wildcards[j].suffix)
+		})
+		return
+	}
+
+	if s.hosts == nil {
+		s.hosts = make(map[string]fasthttp.RequestHandler)
+	}
+	if _, ok := s.hosts[host]; ok {
+		panic("router: host '" + host + "' is already registered")
+	}
+	s.hosts[host] = h
+}
+
+// Handler dispatches the request to the handler registered for request host.
+//
+// Pass it to fasthttp.Server as Handler.
+func (s *HostSwitch) Handler(ctx *fasthttp.RequestCtx) {
+	if h := s.lookup(ctx.Host()); h != nil {
+		h(ctx)
+		return
+	}
+
+	if s.Default != nil {
+		s.Default(ctx)
+		return
+	}
+	ctx.Error(fasthttp.StatusMessage(fasthttp.StatusNotFound), fasthttp.StatusNotFound)
+}
+
+func (s *HostSwitch) lookup(host []byte) fasthttp.RequestHandler {
+	host = stripHostPort(host)
+
+	// Hosts are usually short, so lowercase them on the stack.
+	var buf [128]byte
+	b := append(buf[:0], host...)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+
+	if h := s.hosts[string(b)]; h != nil {
+		return h
+	}
+	for _, w := range s.wildcards {
+		n := len(b) - len(w.suffix)
+		if n > 0 && string(b[n:]) == w.suffix {
+			return w.h
+		}
+	}
+	return nil
+}
+
+// stripHostPort returns host without port and trailing dot.
+func stripHostPort(host []byte) []byte {
+	if len(host) > 0 && host[0] == '[' {
+		// IPv6 address
+		if n := bytes.IndexByte(host, ']'); n >= 0 {
+			return host[:n+1]
+		}
+		return host
+	}
+	if n := bytes.LastIndexByte(host, ':'); n >= 0 {
+		host = host[:n]
+	}
+	if len(host) > 0 && host[len(host)-1] == '.' {
+		host = host[:len(host)-1]
+	}
+	return host
+}
@@ -0,0 +1,86 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func testHostSwitchServe(s *HostSwitch, host string) *fasthttp.RequestCtx {
+	var req fasthttp.Request
+	req.SetRequestURI("/foo")
+	req.Header.SetHost(host)
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, nil, nil)
+	s.Handler(&ctx)
+	return &ctx
+}
+
+func TestHostSwitch(t *testing.T) {
+	var s HostSwitch
+	s.Handle("example.com", testRouterHandler("example"))
+	s.Handle("API.example.com", testRouterHandler("api"))
+	s.Handle("*.example.com", testRouterHandler("tenant"))
+	s.Handle("*.eu.example.com", testRouterHandler("euTenant"))
+	s.Handle("[::1]", testRouterHandler("ipv6"))
+
+	// Registered hosts must be normalized like request hosts.
+	s.Handle("Example.NET.:8080", testRouterHandler("portAndDot"))
+	s.Handle("*.example.net:443", testRouterHandler("wildcardPort"))
+
+	testHost := func(host string, expectedStatusCode int, expectedBody string) {
+		t.Helper()
+		ctx := testHostSwitchServe(&s, host)
+		if ctx.Response.StatusCode() != expectedStatusCode {
+			t.Fatalf("unexpected status code %d for host %q. Expecting %d", ctx.Response.StatusCode(), host, expectedStatusCode)
+		}
+		if body := string(ctx.Response.Body()); body != expectedBody {
+			t.Fatalf("unexpected body %q for host %q. Expecting %q", body, host, expectedBody)
+		}
+	}
+	testHost("example.com", fasthttp.StatusOK, "example")
+	testHost("Example.COM:8080", fasthttp.StatusOK, "example")
+	testHost("example.com.", fasthttp.StatusOK, "example")
+	testHost("api.example.com", fasthttp.StatusOK, "api")
+	testHost("foo.example.com", fasthttp.StatusOK, "tenant")
+	testHost("foo.bar.example.com:443", fasthttp.StatusOK, "tenant")
+	testHost("foo.eu.example.com", fasthttp.StatusOK, "euTenant")
+	testHost("eu.example.com", fasthttp.StatusOK, "tenant")
+	testHost("[::1]:8080", fasthttp.StatusOK, "ipv6")
+	testHost("example.net", fasthttp.StatusOK, "portAndDot")
+	testHost("foo.example.net:8080", fasthttp.StatusOK, "wildcardPort")
+	testHost("fooexample.com", fasthttp.StatusNotFound, "Not Found")
+	testHost("example.org", fasthttp.StatusNotFound, "Not Found")
+	testHost("", fasthttp.StatusNotFound, "Not Found")
+
+	s.Default = func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusTeapot)
+		ctx.SetBodyString("default")
+	}
+	testHost("example.org", fasthttp.StatusTeapot, "default")
+}
+
+func TestHostSwitchInvalidHosts(t *testing.T) {
+	testInvalidHosts := func(hosts ...string) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expecting panic for hosts %q", hosts)
+			}
+		}()
+		var s HostSwitch
+		for _, host := range hosts {
+			s.Handle(host, testRouterHandler(host))
+		}
+	}
+
+	testInvalidHosts("")
+	testInvalidHosts("*")
+	testInvalidHosts("*example.com")
+	testInvalidHosts("foo.*.example.com")
+	testInvalidHosts("example.com/foo")
+	testInvalidHosts("example.com", "EXAMPLE.com")
+	testInvalidHosts("*.example.com", "*.Example.com")
+	testInvalidHosts("example.com", "example.com.:8080")
+	testInvalidHosts(":8080")
+}
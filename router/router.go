@@ -0,0 +1,309 @@
+// Package router provides radix tree based request router for fasthttp.
+//
+// Routes are registered per HTTP method and may contain named parameters
+// and catch-all parameters:
+//
+//	/users/:id           matches /users/123, but not /users/123/posts
+//	/files/*filepath     matches /files/, /files/a and /files/a/b.txt
+//
+// Static path segments take precedence over named parameters, which take
+// precedence over catch-all parameters, so /users/new and /users/:id may be
+// registered simultaneously.
+//
+// HostSwitch dispatches requests by request host, so it may be used
+// for serving multiple virtual hosts on a single listener.
+package router
+
+import (
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Router dispatches requests to handlers registered for request method
+// and path.
+//
+// It is safe calling Router.Handler from concurrently running goroutines.
+// Routes must be registered before Router.Handler is called.
+//
+// Router instance may be used as is without initialization:
+//
+//	var r router.Router
+//	r.GET("/users/:id", getUserHandler)
+//	fasthttp.ListenAndServe(":8080", r.Handler)
+type Router struct {
+	// NotFound is called when no route matches the request path.
+	//
+	// By default '404 Not Found' response is sent.
+	NotFound fasthttp.RequestHandler
+
+	// MethodNotAllowed is called when the request path matches routes
+	// registered for other methods only. 'Allow' response header
+	// is set to the list of these methods before the call.
+	//
+	// By default '405 Method Not Allowed' response is sent.
+	MethodNotAllowed fasthttp.RequestHandler
+
+	trees       map[string]*node
+	methods     []string
+	middlewares []fasthttp.Middleware
+}
+
+// Use appends the given middlewares to the middlewares applied to all
+// the routes.
+//
+// Router middlewares wrap route middlewares passed to Handle, i.e. they
+// are called first. Middlewares are called in the order they are passed
+// to Use. NotFound and MethodNotAllowed handlers aren't wrapped.
+//
+// Use panics if it is called after registering routes, since the order
+// of middlewares would depend on the order of routes registration
+// otherwise.
+func (r *Router) Use(m ...fasthttp.Middleware) {
+	if len(r.methods) > 0 {
+		panic("router: Use must be called before registering routes")
+	}
+	r.middlewares = append(r.middlewares, m...)
+}
+
+// GET registers h for GET requests to the given path.
+//
+// See Handle for details.
+func (r *Router) GET(path string, h fasthttp.RequestHandler, m ...fasthttp.Middleware) {
+	r.Handle("GET", path, h, m...)
+}
+
+// HEAD registers h for HEAD requests to the given path.
+//
+// See Handle for details.
+func (r *Router) HEAD(path string, h fasthttp.RequestHandler, m ...fasthttp.Middleware) {
+	r.Handle("HEAD", path, h, m...)
+}
+
+// POST registers h for POST requests to the given path.
+//
+// See Handle for details.
+func (r *Router) POST(path string, h fasthttp.RequestHandler, m ...fasthttp.Middleware) {
+	r.Handle("POST", path, h, m...)
+}
+
+// PUT registers h for PUT requests to the given path.
+//
+// See Handle for details.
+func (r *Router) PUT(path string, h fasthttp.RequestHandler, m ...fasthttp.Middleware) {
+	r.Handle("PUT", path, h, m...)
+}
+
+// PATCH registers h for PATCH requests to the given path.
+//
+// See Handle for details.
+func (r *Router) PATCH(path string, h fasthttp.RequestHandler, m ...fasthttp.Middleware) {
+	r.Handle("PATCH", path, h, m...)
+}
+
+// DELETE registers h for DELETE requests to the given path.
+//
+// See Handle for details.
+func (r *Router) DELETE(path string, h fasthttp.RequestHandler, m ...fasthttp.Middleware) {
+	r.Handle("DELETE", path, h, m...)
+}
+
+// OPTIONS registers h for OPTIONS requests to the given path.
+//
+// See Handle for details.
+func (r *Router) OPTIONS(path string, h fasthttp.RequestHandler, m ...fasthttp.Middleware) {
+	r.Handle("OPTIONS", path, h, m...)
+}
+
+// Handle registers h for requests with the given method and path.
+//
+// The path must start with '/'. Named parameters are specified
+// as ':name' path segments, while catch-all parameter is specified
+// as '*name' at the end of the path. Parameter values may be obtained
+// via Param.
+//
+// The handler is wrapped with the given route middlewares
+// and then with router middlewares registered via Use. See fasthttp.Chain
+// for the order of middleware calls.
+//
+// Handle panics if the path is invalid or conflicts with already
+// registered paths.
+func (r *Router) Handle(method, path string, h fasthttp.RequestHandler, m ...fasthttp.Middleware) {
+	if len(method) == 0 {
+		panic("router: method cannot be empty")
+	}
+	if len(path) == 0 || path[0] != '/' {
+		panic("router: path must start with '/' in path '" + path + "'")
+	}
+	if h == nil {
+		panic("router: handler cannot be nil")
+	}
+
+	if r.trees == nil {
+		r.trees = make(map[string]*node)
+	}
+	root := r.trees[method]
+	if root == nil {
+		root = &node{}
+		r.trees[method] = root
+		r.methods = append(r.methods, method)
+	}
+	h = fasthttp.Chain(h, m...)
+	h = fasthttp.Chain(h, r.middlewares...)
+	root.addRoute(path, h)
+}
+
+// Handler dispatches the request to the handler registered for request
+// method and path.
+//
+// Pass it to fasthttp.Server as Handler.
+func (r *Router) Handler(ctx *fasthttp.RequestCtx) {
+	path := ctx.Path()
+	ps := acquireParams()
+	if root := r.trees[string(ctx.Method())]; root != nil {
+		if h := root.lookup(path, ps); h != nil {
+			if len(ps.params) > 0 {
+				// Params are released by ctx via Params.Close.
+				ctx.SetUserValue(paramsKey, ps)
+			} else {
+				releaseParams(ps)
+			}
+			h(ctx)
+			return
+		}
+	}
+	releaseParams(ps)
+
+	if allow := r.allowedMethods(ctx.Method(), path); len(allow) > 0 {
+		if r.MethodNotAllowed != nil {
+			ctx.Response.Header.Set("Allow", allow)
+			r.MethodNotAllowed(ctx)
+			return
+		}
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusMethodNotAllowed), fasthttp.StatusMethodNotAllowed)
+		ctx.Response.Header.Set("Allow", allow)
+		return
+	}
+
+	if r.NotFound != nil {
+		r.NotFound(ctx)
+		return
+	}
+	ctx.Error(fasthttp.StatusMessage(fasthttp.StatusNotFound), fasthttp.StatusNotFound)
+}
+
+// allowedMethods returns comma-separated methods with routes matching path.
+func (r *Router) allowedMethods(method, path []byte) string {
+	var allow string
+	ps := acquireParams()
+	for _, m := range r.methods {
+		if m == string(method) {
+			continue
+		}
+		if r.trees[m].lookup(path, ps) != nil {
+			if len(allow) > 0 {
+				allow += ", "
+			}
+			allow += m
+		}
+		ps.params = ps.params[:0]
+	}
+	releaseParams(ps)
+	return allow
+}
+
+// paramsKey is the RequestCtx user value key for path parameters.
+const paramsKey = "github.com/valyala/fasthttp/router.Params"
+
+// Param returns the value of the path parameter with the given name
+// for the request routed by Router.
+//
+// The returned value is valid until returning from RequestHandler.
+// Nil is returned if the route has no such parameter.
+func Param(ctx *fasthttp.RequestCtx, name string) []byte {
+	ps, ok := ctx.UserValue(paramsKey).(*Params)
+	if !ok {
+		return nil
+	}
+	return ps.Peek(name)
+}
+
+// ParamsFromCtx returns path parameters for the request routed by Router.
+//
+// The returned value is valid until returning from RequestHandler.
+// Nil is returned if the route has no parameters.
+func ParamsFromCtx(ctx *fasthttp.RequestCtx) *Params {
+	ps, _ := ctx.UserValue(paramsKey).(*Params)
+	return ps
+}
+
+type param struct {
+	name  string
+	value []byte
+}
+
+// Params contains path parameters for the routed request.
+type Params struct {
+	params []param
+}
+
+// Len returns the number of path parameters.
+func (ps *Params) Len() int {
+	return len(ps.params)
+}
+
+// Peek returns the value of the parameter with the given name.
+//
+// Nil is returned if there is no such parameter.
+func (ps *Params) Peek(name string) []byte {
+	for i := range ps.params {
+		p := &ps.params[i]
+		if p.name == name {
+			return p.value
+		}
+	}
+	return nil
+}
+
+// VisitAll calls f for each path parameter in the order they appear
+// in the route path.
+//
+// f must not retain references to value after returning.
+func (ps *Params) VisitAll(f func(name string, value []byte)) {
+	for i := range ps.params {
+		p := &ps.params[i]
+		f(p.name, p.value)
+	}
+}
+
+// Close releases ps. It is called by RequestCtx when removing
+// user values after returning from RequestHandler.
+func (ps *Params) Close() error {
+	releaseParams(ps)
+	return nil
+}
+
+func (ps *Params) add(name string, value []byte) {
+	ps.params = append(ps.params, param{
+		name:  name,
+		value: value,
+	})
+}
+
+func acquireParams() *Params {
+	v := paramsPool.Get()
+	if v == nil {
+		return &Params{}
+	}
+	return v.(*Params)
+}
+
+func releaseParams(ps *Params) {
+	for i := range ps.params {
+		ps.params[i].value = nil
+	}
+	ps.params = ps.params[:0]
+	paramsPool.Put(ps)
+}
+
+var paramsPool sync.Pool
@@ -0,0 +1,203 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func testRouterHandler(name string) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		fmt.Fprintf(ctx, "%s", name)
+		if ps := ParamsFromCtx(ctx); ps != nil {
+			ps.VisitAll(func(name string, value []byte) {
+				fmt.Fprintf(ctx, " %s=%s", name, value)
+			})
+		}
+	}
+}
+
+func testRouterServe(r *Router, method, uri string) *fasthttp.RequestCtx {
+	var req fasthttp.Request
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&req, nil, nil)
+	r.Handler(&ctx)
+	return &ctx
+}
+
+func TestRouterRoutes(t *testing.T) {
+	var r Router
+	r.GET("/", testRouterHandler("root"))
+	r.GET("/users", testRouterHandler("users"))
+	r.GET("/users/new", testRouterHandler("newUser"))
+	r.GET("/users/:id", testRouterHandler("user"))
+	r.GET("/users/:id/posts/:post", testRouterHandler("post"))
+	r.GET("/users/:id/posts/latest", testRouterHandler("latestPost"))
+	r.GET("/files/*filepath", testRouterHandler("files"))
+	r.GET("/static/css/*filepath", testRouterHandler("css"))
+	r.GET("/static/:name", testRouterHandler("static"))
+	r.POST("/users/:id", testRouterHandler("updateUser"))
+
+	testRoute := func(method, uri, expectedBody string) {
+		t.Helper()
+		ctx := testRouterServe(&r, method, uri)
+		if ctx.Response.StatusCode() != fasthttp.StatusOK {
+			t.Fatalf("unexpected status code %d for %s %s. Expecting %d", ctx.Response.StatusCode(), method, uri, fasthttp.StatusOK)
+		}
+		if body := string(ctx.Response.Body()); body != expectedBody {
+			t.Fatalf("unexpected body %q for %s %s. Expecting %q", body, method, uri, expectedBody)
+		}
+	}
+
+	testRoute("GET", "/", "root")
+	testRoute("GET", "/users", "users")
+	testRoute("GET", "/users/new", "newUser")
+	testRoute("GET", "/users/newbie", "user id=newbie")
+	testRoute("GET", "/users/123", "user id=123")
+	testRoute("POST", "/users/123", "updateUser id=123")
+	testRoute("GET", "/users/123/posts/456", "post id=123 post=456")
+	testRoute("GET", "/users/123/posts/latest", "latestPost id=123")
+	testRoute("GET", "/files/", "files filepath=")
+	testRoute("GET", "/files/a/b.txt", "files filepath=a/b.txt")
+	testRoute("GET", "/static/css/a/b.css", "css filepath=a/b.css")
+	testRoute("GET", "/static/css", "static name=css")
+	testRoute("GET", "/static/foo", "static name=foo")
+}
+
+func TestRouterNotFound(t *testing.T) {
+	var r Router
+	r.GET("/users/:id", testRouterHandler("user"))
+	r.PUT("/users/:id", testRouterHandler("putUser"))
+	r.DELETE("/users/:id", testRouterHandler("deleteUser"))
+
+	testStatusCode := func(method, uri string, expectedStatusCode int, expectedAllow string) {
+		t.Helper()
+		ctx := testRouterServe(&r, method, uri)
+		if ctx.Response.StatusCode() != expectedStatusCode {
+			t.Fatalf("unexpected status code %d for %s %s. Expecting %d", ctx.Response.StatusCode(), method, uri, expectedStatusCode)
+		}
+		if allow := string(ctx.Response.Header.Peek("Allow")); allow != expectedAllow {
+			t.Fatalf("unexpected Allow header %q for %s %s. Expecting %q", allow, method, uri, expectedAllow)
+		}
+	}
+
+	testStatusCode("GET", "/users", fasthttp.StatusNotFound, "")
+	testStatusCode("GET", "/users/123/", fasthttp.StatusNotFound, "")
+	testStatusCode("GET", "/foo", fasthttp.StatusNotFound, "")
+	testStatusCode("POST", "/users/123", fasthttp.StatusMethodNotAllowed, "GET, PUT, DELETE")
+	testStatusCode("PUT", "/foo", fasthttp.StatusNotFound, "")
+
+	r.NotFound = func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusTeapot)
+	}
+	r.MethodNotAllowed = func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusConflict)
+	}
+	testStatusCode("GET", "/foo", fasthttp.StatusTeapot, "")
+	testStatusCode("POST", "/users/123", fasthttp.StatusConflict, "GET, PUT, DELETE")
+}
+
+func TestRouterConflicts(t *testing.T) {
+	testConflict := func(paths ...string) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expecting panic for paths %q", paths)
+			}
+		}()
+		var r Router
+		for _, path := range paths {
+			r.GET(path, testRouterHandler(path))
+		}
+	}
+
+	testConflict("")
+	testConflict("users")
+	testConflict("/users", "/users")
+	testConflict("/users/:id", "/users/:name")
+	testConflict("/users/:id", "/users/:id")
+	testConflict("/users/:")
+	testConflict("/files/*")
+	testConflict("/files/*path/foo")
+	testConflict("/files*path")
+	testConflict("/files/*path", "/files/*name")
+
+	// Non-conflicting routes must be registered successfully.
+	var r Router
+	r.GET("/users/:id", testRouterHandler("user"))
+	r.POST("/users/:name", testRouterHandler("user"))
+	r.GET("/users/:id/*rest", testRouterHandler("rest"))
+	r.GET("/users/new", testRouterHandler("newUser"))
+}
+
+func testRouterMiddleware(name string) fasthttp.Middleware {
+	return func(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			fmt.Fprintf(ctx, "%s>", name)
+			h(ctx)
+			fmt.Fprintf(ctx, "<%s", name)
+		}
+	}
+}
+
+func TestRouterMiddlewares(t *testing.T) {
+	var r Router
+	r.Use(testRouterMiddleware("a"), testRouterMiddleware("b"))
+	r.Use(testRouterMiddleware("c"))
+	r.GET("/foo", testRouterHandler("foo"))
+	r.GET("/bar/:id", testRouterHandler("bar"), testRouterMiddleware("d"), testRouterMiddleware("e"))
+
+	testRoute := func(uri string, expectedStatusCode int, expectedBody string) {
+		t.Helper()
+		ctx := testRouterServe(&r, "GET", uri)
+		if ctx.Response.StatusCode() != expectedStatusCode {
+			t.Fatalf("unexpected status code %d for %s. Expecting %d", ctx.Response.StatusCode(), uri, expectedStatusCode)
+		}
+		if body := string(ctx.Response.Body()); body != expectedBody {
+			t.Fatalf("unexpected body %q for %s. Expecting %q", body, uri, expectedBody)
+		}
+	}
+	testRoute("/foo", fasthttp.StatusOK, "a>b>c>foo<c<b<a")
+	testRoute("/bar/123", fasthttp.StatusOK, "a>b>c>d>e>bar id=123<e<d<c<b<a")
+	testRoute("/baz", fasthttp.StatusNotFound, "Not Found")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expecting panic when calling Use after registering routes")
+		}
+	}()
+	r.Use(testRouterMiddleware("f"))
+}
+
+func TestRouterServer(t *testing.T) {
+	var r Router
+	r.GET("/hello/:name", func(ctx *fasthttp.RequestCtx) {
+		fmt.Fprintf(ctx, "Hello, %s!", Param(ctx, "name"))
+	})
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go fasthttp.Serve(ln, r.Handler)
+	c := &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	for _, name := range []string{"world", "gopher"} {
+		statusCode, body, err := c.Get(nil, "http://foobar/hello/"+name)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if statusCode != fasthttp.StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d", statusCode, fasthttp.StatusOK)
+		}
+		if string(body) != "Hello, "+name+"!" {
+			t.Fatalf("unexpected body %q. Expecting %q", body, "Hello, "+name+"!")
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func BenchmarkRouterParams(b *testing.B) {
+	var r Router
+	r.GET("/users/new", func(ctx *fasthttp.RequestCtx) {})
+	r.GET("/users/:id", func(ctx *fasthttp.RequestCtx) {})
+	r.GET("/users/:id/posts/:post", func(ctx *fasthttp.RequestCtx) {
+		if string(Param(ctx, "id")) != "123" || string(Param(ctx, "post")) != "456" {
+			b.Fatalf("unexpected params %q, %q", Param(ctx, "id"), Param(ctx, "post"))
+		}
+	})
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var req fasthttp.Request
+		req.SetRequestURI("/users/123/posts/456")
+		var ctx fasthttp.RequestCtx
+		ctx.Init(&req, nil, nil)
+		for pb.Next() {
+			r.Handler(&ctx)
+			// Emulate user values removal after returning from RequestHandler.
+			ParamsFromCtx(&ctx).Close()
+			ctx.SetUserValue(paramsKey, nil)
+		}
+	})
+}
+
+func BenchmarkHostSwitch(b *testing.B) {
+	var s HostSwitch
+	s.Handle("example.com", func(ctx *fasthttp.RequestCtx) {})
+	s.Handle("*.example.com", func(ctx *fasthttp.RequestCtx) {})
+	s.Handle("*.eu.example.com", func(ctx *fasthttp.RequestCtx) {})
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var req fasthttp.Request
+		req.SetRequestURI("/foo")
+		req.Header.SetHost("Foo.EU.example.com:8080")
+		var ctx fasthttp.RequestCtx
+		ctx.Init(&req, nil, nil)
+		for pb.Next() {
+			s.Handler(&ctx)
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package router
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// node is a radix tree node.
+//
+// Static children are matched before the param child, which is matched
+// before the catch-all child.
+type node struct {
+	// prefix is the static path part matched by the node.
+	// It is empty for param and catch-all nodes.
+	prefix string
+
+	// indices contains the first prefix bytes of children.
+	indices  []byte
+	children []*node
+
+	param    *node
+	catchAll *node
+
+	// name is the parameter name for param and catch-all nodes.
+	name string
+
+	handler fasthttp.RequestHandler
+
+	// path is the registered path for the handler.
+	path string
+}
+
+func (n *node) addRoute(path string, h fasthttp.RequestHandler) {
+	n.insert(path, path, h)
+}
+
+// insert inserts the remaining path p into the static node n.
+func (n *node) insert(path, p string, h fasthttp.RequestHandler) {
+	l := commonPrefixLen(n.prefix, p)
+	if l < len(n.prefix) {
+		// Split the node.
+		child := &node{
+			prefix:   n.prefix[l:],
+			indices:  n.indices,
+			children: n.children,
+			param:    n.param,
+			catchAll: n.catchAll,
+			handler:  n.handler,
+			path:     n.path,
+		}
+		*n = node{
+			prefix:   n.prefix[:l],
+			indices:  []byte{child.prefix[0]},
+			children: []*node{child},
+		}
+	}
+	n.insertChild(path, p[l:], h)
+}
+
+// insertChild inserts the remaining path p into children of n.
+func (n *node) insertChild(path, p string, h fasthttp.RequestHandler) {
+	if len(p) == 0 {
+		n.setHandler(path, h)
+		return
+	}
+
+	switch p[0] {
+	case ':':
+		end := strings.IndexByte(p, '/')
+		if end < 0 {
+			end = len(p)
+		}
+		name := p[1:end]
+		if len(name) == 0 || strings.ContainsAny(name, ":*") {
+			panic("router: invalid parameter name in path '" + path + "'")
+		}
+		if n.param == nil {
+			n.param = &node{
+				name: name,
+			}
+		} else if n.param.name != name {
+			panic("router: parameter ':" + name + "' in path '" + path +
+				"' conflicts with ':" + n.param.name + "' in existing path '" + n.param.anyPath() + "'")
+		}
+		n.param.insertChild(path, p[end:], h)
+	case '*':
+		name := p[1:]
+		if len(name) == 0 || strings.ContainsAny(name, ":*/") {
+			panic("router: invalid catch-all parameter in path '" + path + "'. It must be at the end of the path")
+		}
+		if len(path) == len(p) || path[len(path)-len(p)-1] != '/' {
+			panic("router: catch-all parameter must follow '/' in path '" + path + "'")
+		}
+		if n.catchAll != nil {
+			panic("router: catch-all parameter in path '" + path +
+				"' conflicts with existing path '" + n.catchAll.path + "'")
+		}
+		n.catchAll = &node{
+			name: name,
+		}
+		n.catchAll.setHandler(path, h)
+	default:
+		for i, c := range n.indices {
+			if c == p[0] {
+				n.children[i].insert(path, p, h)
+				return
+			}
+		}
+		end := strings.IndexAny(p, ":*")
+		if end < 0 {
+			end = len(p)
+		}
+		child := &node{
+			prefix: p[:end],
+		}
+		n.indices = append(n.indices, p[0])
+		n.children = append(n.children, child)
+		child.insertChild(path, p[end:], h)
+	}
+}
+
+func (n *node) setHandler(path string, h fasthttp.RequestHandler) {
+	if n.handler != nil {
+		panic("router: a handler is already registered for path '" + n.path + "'")
+	}
+	n.handler = h
+	n.path = path
+}
+
+// anyPath returns any path registered under n.
+func (n *node) anyPath() string {
+	if n.handler != nil {
+		return n.path
+	}
+	for _, child := range n.children {
+		if path := child.anyPath(); len(path) > 0 {
+			return path
+		}
+	}
+	if n.param != nil {
+		if path := n.param.anyPath(); len(path) > 0 {
+			return path
+		}
+	}
+	if n.catchAll != nil {
+		return n.catchAll.path
+	}
+	return ""
+}
+
+// lookup returns the handler for the given path under the static node n.
+//
+// Path parameters are appended to ps.
+func (n *node) lookup(path []byte, ps *Params) fasthttp.RequestHandler {
+	if len(path) < len(n.prefix) || string(path[:len(n.prefix)]) != n.prefix {
+		return nil
+	}
+	return n.lookupChild(path[len(n.prefix):], ps)
+}
+
+// lookupChild returns the handler for the remaining path under children of n.
+func (n *node) lookupChild(path []byte, ps *Params) fasthttp.RequestHandler {
+	if len(path) == 0 {
+		if n.handler != nil {
+			return n.handler
+		}
+		if n.catchAll != nil {
+			ps.add(n.catchAll.name, path)
+			return n.catchAll.handler
+		}
+		return nil
+	}
+
+	for i, c := range n.indices {
+		if c == path[0] {
+			if h := n.children[i].lookup(path, ps); h != nil {
+				return h
+			}
+			break
+		}
+	}
+
+	if n.param != nil {
+		end := bytes.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+		if end > 0 {
+			paramsLen := len(ps.params)
+			ps.add(n.param.name, path[:end])
+			if h := n.param.lookupChild(path[end:], ps); h != nil {
+				return h
+			}
+			ps.params = ps.params[:paramsLen]
+		}
+	}
+
+	if n.catchAll != nil {
+		ps.add(n.catchAll.name, path)
+		return n.catchAll.handler
+	}
+	return nil
+}
+
+func commonPrefixLen(a, b string) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] || b[i] == ':' || b[i] == '*' {
+			return i
+		}
+	}
+	return n
+}
@@ -2,18 +2,25 @@ package fasthttp
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"mime/multipart"
 	"net"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"golang.org/x/net/http2"
 )
 
 // ServeConn serves HTTP requests from the given connection
@@ -141,6 +148,52 @@ type Server struct {
 	// Handler for processing incoming requests.
 	Handler RequestHandler
 
+	// ErrorHandler for returning a response in case of an error while
+	// reading the request, e.g. when the request line is malformed,
+	// the request header is too large or the request body exceeds
+	// MaxRequestBodySize.
+	//
+	// ErrorHandler is called with the error returned by the request reader,
+	// so the response may depend on it, e.g. *ErrSmallBuffer is returned
+	// for too large headers and ErrBodyTooLarge is returned for too large
+	// bodies. The request may be partially read, so ErrorHandler mustn't
+	// rely on its' contents. The connection is closed after writing
+	// the response.
+	//
+	// ErrorHandler may also be used for logging and counting such errors.
+	//
+	// By default '400 Bad Request' or '431 Request Header Fields Too Large'
+	// response is sent.
+	ErrorHandler func(ctx *RequestCtx, err error)
+
+	// HeaderReceived is called after reading the request header and before
+	// reading the request body, so the request may be rejected or limited
+	// depending on its' header, e.g. large uploads from unauthorized
+	// clients may be rejected without reading them.
+	//
+	// The returned RequestConfig overrides server settings for the request.
+	// The request is rejected if non-nil error is returned. The error
+	// is passed to ErrorHandler then, the request body isn't read
+	// and the connection is closed after sending the response.
+	//
+	// HeaderReceived is called for HTTP/1.x requests only.
+	//
+	// By default server settings are used for all the requests.
+	HeaderReceived func(header *RequestHeader) (RequestConfig, error)
+
+	// ContinueHandler is called for requests with 'Expect: 100-continue'
+	// header before sending '100 Continue' response, so the server may
+	// decide whether to read the request body.
+	//
+	// The request body is read and the request is passed to Handler
+	// if ContinueHandler returns true. Otherwise '417 Expectation Failed'
+	// response is sent without reading the request body and calling
+	// Handler, and the connection is closed. Use HeaderReceived with
+	// ErrorHandler for sending custom responses to rejected requests.
+	//
+	// By default '100 Continue' response is sent for all the requests.
+	ContinueHandler func(header *RequestHeader) bool
+
 	// Server name for sending in response headers.
 	//
 	// Default server name is used if left blank.
@@ -151,6 +204,64 @@ type Server struct {
 	// DefaultConcurrency is used if not set.
 	Concurrency int
 
+	// MaxQueuedConns is the maximum number of incoming connections waiting
+	// for being served if Concurrency connections are already served.
+	//
+	// Queued connections are served in FIFO order when the served
+	// connections are closed. Connections exceeding the limit are rejected
+	// with ConcurrencyLimitHandler. Connections passed to ServeConn aren't
+	// queued.
+	//
+	// By default connections exceeding Concurrency are rejected immediately.
+	MaxQueuedConns int
+
+	// MaxQueueWaitTime is the maximum duration a connection may wait
+	// in the queue. See MaxQueuedConns for details.
+	//
+	// Connections waiting for more than MaxQueueWaitTime are rejected
+	// with ConcurrencyLimitHandler.
+	//
+	// By default queued connections wait until they are served.
+	MaxQueueWaitTime time.Duration
+
+	// ConcurrencyLimitHandler writes the response for connections rejected
+	// because of Concurrency limit.
+	//
+	// The request isn't read from the rejected connection, so only
+	// ctx.Response and connection-related ctx methods such as RemoteAddr
+	// may be used. The connection is closed after writing the response.
+	// The response is written with a timeout not exceeding one second,
+	// so ConcurrencyLimitHandler must return quickly.
+	//
+	// By default StatusServiceUnavailable response is sent.
+	ConcurrencyLimitHandler RequestHandler
+
+	// MaxIdleWorkerDuration is the maximum duration an idle worker waits
+	// for new connections before exiting.
+	//
+	// Workers serve connections accepted by Serve. Idle workers are kept
+	// for serving subsequent connections without starting new goroutines.
+	//
+	// Default idle worker duration is 10 seconds.
+	MaxIdleWorkerDuration time.Duration
+
+	// MaxIdleWorkers is the maximum number of idle workers per Serve call.
+	//
+	// Workers exceeding the limit exit after serving their connections,
+	// so memory occupied by them may be freed after connection bursts.
+	//
+	// By default the number of idle workers is limited only by Concurrency.
+	MaxIdleWorkers int
+
+	// WorkerSpawnBurst is the number of workers started at once when there
+	// are no idle workers for serving the incoming connection.
+	//
+	// Extra workers wait for subsequent connections, which may reduce
+	// latency for deployments with high connection churn.
+	//
+	// By default a single worker is started per connection.
+	WorkerSpawnBurst int
+
 	// Whether to disable keep-alive connections.
 	//
 	// The server will close all the incoming connections after sending
@@ -159,6 +270,17 @@ type Server struct {
 	// By default keep-alive connections are enabled.
 	DisableKeepalive bool
 
+	// Whether to keep hijacked connections open after HijackHandler returns.
+	//
+	// The handler becomes responsible for closing the connection then,
+	// so it may pass the connection to another goroutine, e.g. to a pool
+	// of WebSocket or tunnel connections. Such connections are considered
+	// closed by Shutdown and MetricsCollector after the handler returns.
+	//
+	// By default hijacked connections are closed after HijackHandler
+	// returns.
+	KeepHijackedConns bool
+
 	// Per-connection buffer size for requests' reading.
 	// This also limits the maximum header size.
 	//
@@ -176,11 +298,20 @@ type Server struct {
 	// Maximum duration for reading the full request (including body).
 	//
 	// This also limits the maximum duration for idle keep-alive
-	// connections.
+	// connections unless IdleTimeout is set.
 	//
 	// By default request read timeout is unlimited.
 	ReadTimeout time.Duration
 
+	// Maximum duration for waiting for the next request
+	// on keep-alive connection.
+	//
+	// ReadTimeout applies to the next request after its' first byte
+	// is received, so IdleTimeout may be set independently of ReadTimeout.
+	//
+	// By default ReadTimeout is used for idle keep-alive connections.
+	IdleTimeout time.Duration
+
 	// Maximum duration for writing the full response (including body).
 	//
 	// By default response write timeout is unlimited.
@@ -188,10 +319,22 @@ type Server struct {
 
 	// Maximum number of concurrent client connections allowed per IP.
 	//
+	// Connections exceeding the limit are closed after sending
+	// '429 Too Many Requests' response. ServeConn returns
+	// *PerIPConnLimitError for such connections.
+	//
 	// By default unlimited number of concurrent connections
 	// may be established to the server from a single IP address.
 	MaxConnsPerIP int
 
+	// Networks, which aren't limited by MaxConnsPerIP.
+	//
+	// Put trusted reverse proxies and load balancers here, since they
+	// usually multiplex many clients over connections from a single IP.
+	//
+	// By default connections from all the IPs are limited.
+	MaxConnsPerIPAllowlist []*net.IPNet
+
 	// Maximum number of requests served per connection.
 	//
 	// The server closes connection after the last request.
@@ -200,12 +343,38 @@ type Server struct {
 	// By default unlimited number of requests may be served per connection.
 	MaxRequestsPerConn int
 
+	// Whether to enable TCP keep-alive on connections accepted by Serve.
+	//
+	// The kernel sends keep-alive probes on idle connections and closes
+	// connections, which don't answer them. So half-open connections
+	// from crashed clients or broken networks don't linger forever.
+	//
+	// By default TCP keep-alive settings of the listener are left
+	// as is. Note that net.Listen enables TCP keep-alive on accepted
+	// connections with 15 seconds period since go1.13.
+	TCPKeepalive bool
+
+	// Idle duration before sending TCP keep-alive probes
+	// if TCPKeepalive is set.
+	//
+	// By default OS-level duration is used.
+	TCPKeepalivePeriod time.Duration
+
+	// The number of unanswered TCP keep-alive probes after which
+	// the connection is closed if TCPKeepalive is set.
+	//
+	// The option is ignored on platforms without TCP_KEEPCNT socket option
+	// such as windows and darwin.
+	//
+	// By default OS-level count is used.
+	TCPKeepaliveCount int
+
 	// Maximum keep-alive connection lifetime.
 	//
 	// The server closes keep-alive connection after its' lifetime
 	// expiration.
 	//
-	// See also ReadTimeout for limiting the duration of idle keep-alive
+	// See also IdleTimeout for limiting the duration of idle keep-alive
 	// connections.
 	//
 	// By default keep-alive connection lifetime is unlimited.
@@ -218,6 +387,53 @@ type Server struct {
 	// Request body size is limited by DefaultMaxRequestBodySize by default.
 	MaxRequestBodySize int
 
+	// Request bodies are passed to Handler as streams if set to true.
+	//
+	// Handlers read the body incrementally via RequestCtx.RequestBodyStream,
+	// so large uploads may be processed with bounded memory usage.
+	// MaxRequestBodySize still limits the body size. The body remaining
+	// unread after returning from Handler is discarded.
+	//
+	// PostBody and PostArgs read the whole body into memory when called,
+	// while MultipartForm stores large files in temporary files.
+	// See MaxMultipartFormMemory for details.
+	//
+	// By default request bodies are read into memory before calling Handler.
+	StreamRequestBody bool
+
+	// MaxMultipartFormMemory is the maximum size of multipart form files
+	// kept in memory by RequestCtx.MultipartForm. Files exceeding the limit
+	// are stored in temporary files, which are removed after returning
+	// from Handler. Use SaveMultipartFile for moving them to a permanent
+	// place.
+	//
+	// By default files exceeding 16MB are stored in temporary files,
+	// while forms with unknown size, i.e. sent in chunked encoding,
+	// are kept in memory unless StreamRequestBody is set.
+	MaxMultipartFormMemory int
+
+	// MaxMultipartFormSize is the maximum size of multipart form
+	// read by RequestCtx.MultipartForm.
+	//
+	// ErrMultipartFormTooLarge is returned from RequestCtx.MultipartForm
+	// if the form exceeds the limit. Forms of requests with Content-Length
+	// are read before calling Handler unless StreamRequestBody is set,
+	// so the error is passed to ErrorHandler in this case.
+	//
+	// By default form size is limited only by MaxRequestBodySize.
+	MaxMultipartFormSize int
+
+	// MaxMultipartFileSize is the maximum size of a single file
+	// in multipart form read by RequestCtx.MultipartForm.
+	//
+	// ErrMultipartPartTooLarge is reported as described
+	// in MaxMultipartFormSize if any file exceeds the limit. The file
+	// is rejected as soon as it exceeds the limit, so it doesn't occupy
+	// more than MaxMultipartFileSize bytes in memory or on disk.
+	//
+	// By default file size is limited only by MaxMultipartFormSize.
+	MaxMultipartFileSize int
+
 	// Aggressively reduces memory usage at the cost of higher CPU usage
 	// if set to true.
 	//
@@ -265,11 +481,120 @@ type Server struct {
 	//     * cONTENT-lenGTH -> Content-Length
 	DisableHeaderNamesNormalizing bool
 
+	// Serves HTTP/2 over cleartext TCP connections (h2c) if set to true.
+	//
+	// Both connections starting with HTTP/2 connection preface
+	// (prior knowledge) and HTTP/1.1 requests with 'Upgrade: h2c' header
+	// are served via HTTP/2. See RFC 7540, sections 3.2 and 3.4.
+	//
+	// HTTP/2 requests are served by Handler, but cannot be hijacked.
+	//
+	// By default only HTTP/1.x is served over cleartext connections.
+	EnableH2C bool
+
+	// Alt-Svc header value sent in responses to requests received
+	// over TCP connections, e.g. `h3=":443"; ma=86400`.
+	//
+	// This allows clients switching to HTTP/3 served via ServeHTTP3.
+	// The header may be overridden by Handler.
+	//
+	// By default Alt-Svc header isn't sent.
+	AltSvc string
+
+	// ALPNHandlers contains handlers for TLS connections negotiating
+	// the given application protocols via ALPN, e.g. custom protocols
+	// multiplexed with HTTPS over a single listener.
+	//
+	// The protocols are advertised by ServeTLS* and ListenAndServeTLS*.
+	// Add them to tls.Config.NextProtos when passing custom TLS listener
+	// to Serve.
+	//
+	// Connections without negotiated protocol or with protocols missing
+	// in ALPNHandlers are served as HTTP/1.x. Connections negotiating
+	// "acme-tls/1" are closed after the handshake by default,
+	// since tls-alpn-01 challenge is verified during the handshake.
+	//
+	// Shutdown waits until ALPNHandler returns.
+	ALPNHandlers map[string]ALPNHandler
+
+	// Serves HTTP/2 over TLS connections negotiating "h2" via ALPN
+	// if set to true.
+	//
+	// ALPNHandlers["h2"] overrides the default HTTP/2 handler.
+	EnableHTTP2 bool
+
+	// TLSConfig is the base TLS configuration for ServeTLS* and
+	// ListenAndServeTLS* functions.
+	//
+	// Set TLSConfig.GetCertificate for selecting certificates dynamically,
+	// e.g. for loading them from external storage by SNI.
+	// Certificates registered via AppendCert and AppendCertEmbed are added
+	// to TLSConfig.Certificates.
+	//
+	// TLSConfig must be set before serving. It mustn't be modified after that.
+	TLSConfig *tls.Config
+
+	// ClientAuth is the policy for TLS client certificates.
+	//
+	// Set it to tls.RequireAndVerifyClientCert for mutual TLS.
+	// Client certificates are verified against CAs registered via
+	// AppendClientCA* or TLSConfig.ClientCAs. Use RequestCtx.ClientCertificate
+	// for certificate-based authorization in request handlers.
+	//
+	// By default TLSConfig.ClientAuth is used.
+	ClientAuth tls.ClientAuthType
+
 	// Logger, which is used by RequestCtx.Logger().
 	//
 	// By default standard logger from log package is used.
 	Logger Logger
 
+	// Instrumenter starts spans for served requests, e.g. for distributed
+	// tracing.
+	//
+	// By default requests aren't instrumented.
+	Instrumenter ServerInstrumenter
+
+	// MetricsCollector receives connection and request events, e.g. for
+	// exporting Prometheus metrics.
+	//
+	// By default metrics aren't collected.
+	MetricsCollector ServerMetricsCollector
+
+	// ConnState is called when a served connection changes its state.
+	// See ConnState type and its constants for details.
+	//
+	// ConnState is called synchronously from the goroutine serving
+	// the connection, so it mustn't block.
+	//
+	// By default connection state changes aren't reported.
+	ConnState func(c net.Conn, state ConnState)
+
+	// OnShutdownTimeout is called by ShutdownWithContext with the number
+	// of connections closed forcibly, since they didn't finish serving
+	// requests before the context is done.
+	//
+	// By default forcibly closed connections aren't reported.
+	OnShutdownTimeout func(closedConns int)
+
+	// OnShutdownProgress is called by Shutdown while it waits for active
+	// connections, so the progress of connections' draining may be reported.
+	//
+	// It is called with the number of remaining connections and the number
+	// of requests being served on these connections when Shutdown starts
+	// waiting and then each time these numbers change. The numbers are
+	// checked periodically, so short-living changes may be missed.
+	// The last call is made with zero numbers after all the connections
+	// are closed. OnShutdownProgress is called from the goroutine calling
+	// Shutdown.
+	//
+	// By default shutdown progress isn't reported.
+	OnShutdownProgress func(conns, requests int)
+
+	// workerPools contains worker pools of running Serve calls.
+	// It is protected by mu.
+	workerPools []*workerPool
+
 	concurrency      uint32
 	concurrencyCh    chan struct{}
 	perIPConnCounter perIPConnCounter
@@ -280,6 +605,33 @@ type Server struct {
 	writerPool     sync.Pool
 	hijackConnPool sync.Pool
 	bytePool       sync.Pool
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	ln []net.Listener
+
+	// conns maps served connections to their idle state.
+	conns map[net.Conn]bool
+
+	// connsDrained is closed when all the connections are closed
+	// after Shutdown call.
+	connsDrained chan struct{}
+
+	// lastPerIPErrorTime is the last time the MaxConnsPerIP error was logged.
+	lastPerIPErrorTime time.Time
+
+	stop int32
+
+	// h2cServer serves h2c connections if EnableH2C is set.
+	h2cServer     *http2.Server
+	h2cBaseConfig *http.Server
+
+	// h3Servers contains servers passed to ServeHTTP3.
+	h3Servers []HTTP3Server
+
+	// shutdown is closed when Shutdown is called.
+	shutdown chan struct{}
 }
 
 // TimeoutHandler creates RequestHandler, which returns StatusRequestTimeout
@@ -344,6 +696,11 @@ func CompressHandler(h RequestHandler) RequestHandler {
 func CompressHandlerLevel(h RequestHandler, level int) RequestHandler {
 	return func(ctx *RequestCtx) {
 		h(ctx)
+		if ctx.chunked {
+			// The response header has been already sent by WriteChunk,
+			// so the response cannot be compressed.
+			return
+		}
 		ce := ctx.Response.Header.PeekBytes(strContentEncoding)
 		if len(ce) > 0 {
 			// Do not compress responses with non-empty
@@ -358,6 +715,184 @@ func CompressHandlerLevel(h RequestHandler, level int) RequestHandler {
 	}
 }
 
+// CompressHandlerBrotliLevel returns RequestHandler that transparently
+// compresses response body generated by h if the request contains 'br',
+// 'gzip' or 'deflate' 'Accept-Encoding' header. Brotli is preferred
+// over gzip and deflate, since it usually gives better compression ratio.
+//
+// brotliLevel is the desired compression level for brotli:
+//
+//     * CompressBrotliBestSpeed
+//     * CompressBrotliBestCompression
+//     * CompressBrotliDefaultCompression
+//
+// otherLevel is the desired compression level for gzip and deflate:
+//
+//     * CompressNoCompression
+//     * CompressBestSpeed
+//     * CompressBestCompression
+//     * CompressDefaultCompression
+//     * CompressHuffmanOnly
+func CompressHandlerBrotliLevel(h RequestHandler, brotliLevel, otherLevel int) RequestHandler {
+	return func(ctx *RequestCtx) {
+		h(ctx)
+		if ctx.chunked {
+			// The response header has been already sent by WriteChunk,
+			// so the response cannot be compressed.
+			return
+		}
+		ce := ctx.Response.Header.PeekBytes(strContentEncoding)
+		if len(ce) > 0 {
+			// Do not compress responses with non-empty
+			// Content-Encoding.
+			return
+		}
+		if ctx.Request.Header.HasAcceptEncodingBytes(strBr) {
+			ctx.Response.brotliBody(brotliLevel)
+		} else if ctx.Request.Header.HasAcceptEncodingBytes(strGzip) {
+			ctx.Response.gzipBody(otherLevel)
+		} else if ctx.Request.Header.HasAcceptEncodingBytes(strDeflate) {
+			ctx.Response.deflateBody(otherLevel)
+		}
+	}
+}
+
+// CompressConfig is the configuration for CompressHandlerConfig.
+type CompressConfig struct {
+	// Level is the compression level for gzip and deflate:
+	//
+	//     * CompressBestSpeed
+	//     * CompressBestCompression
+	//     * CompressDefaultCompression
+	//     * CompressHuffmanOnly
+	//
+	// By default CompressDefaultCompression is used.
+	Level int
+
+	// EnableBrotli enables brotli compression for requests accepting
+	// 'br' encoding.
+	//
+	// Brotli is preferred over gzip and deflate with the same quality
+	// in 'Accept-Encoding', since it usually compresses HTML and JSON
+	// 15-20% better thanks to its built-in dictionary for web content.
+	// The built-in dictionary tables are precomputed and shared by all
+	// the encoders. Custom dictionaries aren't supported.
+	EnableBrotli bool
+
+	// BrotliLevel is the compression level for brotli in the range
+	// [CompressBrotliBestSpeed..CompressBrotliBestCompression].
+	//
+	// Streamed bodies are compressed with the level not exceeding
+	// CompressBrotliDefaultCompression, since higher levels are too slow
+	// for compressing data on the fly.
+	//
+	// The zero value is CompressBrotliBestSpeed, which is usually the best
+	// choice for dynamically generated responses.
+	BrotliLevel int
+
+	// MinSize is the minimum response body size in bytes for compression.
+	//
+	// Smaller bodies are sent uncompressed, since the compressed body
+	// is likely to be bigger than the original body. Streamed bodies
+	// without Content-Length are always compressed.
+	//
+	// By default bodies smaller than 200 bytes aren't compressed.
+	MinSize int
+
+	// ContentTypes contains media types of compressible responses,
+	// e.g. "application/json". Entries ending with '/' match all
+	// the subtypes, e.g. "text/". Media type parameters such as charset
+	// are ignored.
+	//
+	// By default "text/" content types and common textual "application/"
+	// and "image/" content types such as "application/json",
+	// "application/javascript" and "image/svg+xml" are compressed.
+	// Already compressed content types such as "application/zip"
+	// and "application/pdf" aren't compressed by default.
+	ContentTypes []string
+}
+
+var defaultCompressContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/ld+json",
+	"application/manifest+json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// CompressHandlerConfig returns RequestHandler that transparently compresses
+// response body generated by h according to cfg if the request accepts
+// 'gzip' or 'deflate' encoding via 'Accept-Encoding' header. 'br' encoding
+// is supported if cfg.EnableBrotli is set.
+//
+// Encodings with zero quality such as 'gzip;q=0' aren't used.
+// 'Vary: Accept-Encoding' response header is set for compressible responses,
+// so caches don't serve compressed responses to clients without compression
+// support. Responses with non-empty Content-Encoding, with
+// 'Cache-Control: no-transform' and partial responses with Content-Range
+// aren't compressed. Responses sent via RequestCtx.WriteChunk aren't
+// compressed too.
+func CompressHandlerConfig(h RequestHandler, cfg CompressConfig) RequestHandler {
+	level := cfg.Level
+	if level == CompressNoCompression {
+		level = CompressDefaultCompression
+	}
+	minSize := cfg.MinSize
+	if minSize <= 0 {
+		minSize = minCompressLen
+	}
+	contentTypes := cfg.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = defaultCompressContentTypes
+	}
+	allowedContentTypes := make([][]byte, 0, len(contentTypes))
+	for _, ct := range contentTypes {
+		if len(ct) > 0 {
+			allowedContentTypes = append(allowedContentTypes, []byte(ct))
+		}
+	}
+	brotliLevel := normalizeBrotliCompressLevel(cfg.BrotliLevel)
+	brotliStreamLevel := brotliLevel
+	if brotliStreamLevel > CompressBrotliDefaultCompression {
+		brotliStreamLevel = CompressBrotliDefaultCompression
+	}
+	encodings := [][]byte{strGzip, strDeflate}
+	if cfg.EnableBrotli {
+		encodings = [][]byte{strBr, strGzip, strDeflate}
+	}
+
+	return func(ctx *RequestCtx) {
+		h(ctx)
+		if ctx.chunked {
+			// The response header has been already sent by WriteChunk.
+			return
+		}
+		resp := &ctx.Response
+		if !resp.canCompress(allowedContentTypes, minSize) {
+			return
+		}
+		resp.Header.addVaryValue(strAcceptEncoding)
+		encoding := negotiateContentEncoding(ctx.Request.Header.peek(strAcceptEncoding), encodings)
+		if encoding == nil {
+			return
+		}
+		encodingLevel := level
+		if bytes.Equal(encoding, strBr) {
+			encodingLevel = brotliLevel
+			if resp.bodyStream != nil {
+				encodingLevel = brotliStreamLevel
+			}
+		}
+		resp.compressBody(encoding, encodingLevel, 0)
+	}
+}
+
 // RequestCtx contains incoming request and manages outgoing response.
 //
 // It is forbidden copying RequestCtx instances.
@@ -404,22 +939,117 @@ type RequestCtx struct {
 	timeoutCh       chan struct{}
 	timeoutTimer    *time.Timer
 
-	hijackHandler HijackHandler
+	hijackHandler    HijackHandler
+	hijackRawHandler RawHijackHandler
+
+	// hijackTrack is set if the hijacked connection must be tracked
+	// by Server.Shutdown until hijackHandler returns.
+	hijackTrack bool
+
+	// readDeadlineSet and writeDeadlineSet are set if connection
+	// deadlines are overridden by the request handler.
+	readDeadlineSet  bool
+	writeDeadlineSet bool
+
+	// deadlinesUnsupported is set if the connection is shared
+	// among concurrent requests, so its' deadlines mustn't be changed.
+	deadlinesUnsupported bool
+
+	// doneLock protects the fields below, which are used for implementing
+	// context.Context. done is created lazily by Done.
+	doneLock sync.Mutex
+	done     chan struct{}
+	doneErr  error
+	doneStop chan struct{}
+
+	// connDone is closed when the request is cancelled by net/http.
+	// It is set for requests served via net/http only.
+	connDone <-chan struct{}
+
+	// bw is the connection writer used by WriteChunk. It is passed
+	// between serveConn and WriteChunk, so responses to pipelined
+	// requests are written in order.
+	bw *bufio.Writer
+
+	// netHTTPWriter is set for requests served via net/http.
+	netHTTPWriter http.ResponseWriter
+
+	// chunked is set if the response header has been written by WriteChunk.
+	chunked  bool
+	chunkErr error
+}
+
+// ConnState represents the state of the connection served by Server.
+// It is passed to Server.ConnState.
+type ConnState int
+
+const (
+	// StateNew represents a new connection, which is expected
+	// to send a request immediately.
+	StateNew ConnState = iota
+
+	// StateActive represents a connection, which has read the request
+	// and is serving it.
+	//
+	// Pipelined requests are served without switching the connection
+	// to StateIdle between them.
+	StateActive
+
+	// StateIdle represents a keep-alive connection waiting
+	// for the next request. Shutdown closes idle connections.
+	StateIdle
+
+	// StateHijacked represents a hijacked connection.
+	// This is a terminal state, i.e. StateClosed isn't reported
+	// for hijacked connections.
+	StateHijacked
+
+	// StateClosed represents a connection, which is closed after
+	// the ConnState call. This is a terminal state.
+	StateClosed
+)
+
+var connStateNames = [...]string{
+	StateNew:      "new",
+	StateActive:   "active",
+	StateIdle:     "idle",
+	StateHijacked: "hijacked",
+	StateClosed:   "closed",
+}
+
+// String returns the name of the connection state.
+func (cs ConnState) String() string {
+	if cs < 0 || int(cs) >= len(connStateNames) {
+		return "unknown"
+	}
+	return connStateNames[cs]
 }
 
 // HijackHandler must process the hijacked connection c.
 //
-// The connection c is automatically closed after returning from HijackHandler.
+// The connection c is automatically closed after returning from HijackHandler
+// unless Server.KeepHijackedConns is set.
 //
-// The connection c must not be used after returning from the handler.
+// The connection c must not be used after returning from the handler
+// unless Server.KeepHijackedConns is set.
 type HijackHandler func(c net.Conn)
 
+// RawHijackHandler must process the hijacked connection c.
+//
+// c is the underlying connection accepted by the server. buffered contains
+// data already read by the server from c after the request, e.g. the first
+// bytes of custom protocol. buffered must be processed before reading from c.
+//
+// The connection c is automatically closed after returning
+// from RawHijackHandler unless Server.KeepHijackedConns is set.
+type RawHijackHandler func(c net.Conn, buffered []byte)
+
 // Hijack registers the given handler for connection hijacking.
 //
 // The handler is called after returning from RequestHandler
 // and sending http response. The current connection is passed
 // to the handler. The connection is automatically closed after
-// returning from the handler.
+// returning from the handler unless Server.KeepHijackedConns is set.
 //
 // The server skips calling the handler in the following cases:
 //
@@ -438,13 +1068,71 @@ type HijackHandler func(c net.Conn)
 //     * WebSocket ( https://en.wikipedia.org/wiki/WebSocket )
 //     * HTTP/2.0 ( https://en.wikipedia.org/wiki/HTTP/2 )
 //
+// Use RequestCtx.UpgradeWebSocket for validating WebSocket handshake
+// before hijacking the connection.
 func (ctx *RequestCtx) Hijack(handler HijackHandler) {
 	ctx.hijackHandler = handler
+	ctx.hijackRawHandler = nil
 }
 
-// Hijacked returns true after Hijack is called.
+// HijackRaw registers the given handler for connection hijacking.
+//
+// It works like Hijack, but passes the underlying connection
+// and the data buffered by the server to the handler instead of
+// the connection wrapper returning the buffered data. This allows using
+// optimizations available for the underlying connection such as
+// splice(2) in io.Copy when implementing CONNECT-style tunnels.
+func (ctx *RequestCtx) HijackRaw(handler RawHijackHandler) {
+	ctx.hijackRawHandler = handler
+	ctx.hijackHandler = nil
+}
+
+// Hijacked returns true after Hijack or HijackRaw is called.
 func (ctx *RequestCtx) Hijacked() bool {
-	return ctx.hijackHandler != nil
+	return ctx.hijackHandler != nil || ctx.hijackRawHandler != nil
+}
+
+// ErrDeadlineNotSupported is returned from RequestCtx.SetReadDeadline
+// and RequestCtx.SetWriteDeadline if connection deadlines cannot be changed
+// for the request. For instance, HTTP/2 connections are shared among
+// concurrent requests.
+var ErrDeadlineNotSupported = errors.New("connection deadlines cannot be changed for the request")
+
+// SetReadDeadline sets read deadline on the connection for the current
+// request, overriding Server.ReadTimeout.
+//
+// This may be used for extending or tightening the deadline for reading
+// request body streamed via Server.StreamRequestBody.
+// Zero t means no deadline.
+//
+// Server.ReadTimeout is restored for the next request on the connection.
+func (ctx *RequestCtx) SetReadDeadline(t time.Time) error {
+	if ctx.deadlinesUnsupported || ctx.s == fakeServer {
+		return ErrDeadlineNotSupported
+	}
+	if err := ctx.c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	ctx.readDeadlineSet = true
+	return nil
+}
+
+// SetWriteDeadline sets write deadline on the connection for the current
+// response, overriding Server.WriteTimeout and Server.MaxKeepaliveDuration.
+//
+// This may be used for extending the deadline for long-running responses
+// streamed via SetBodyStreamWriter. Zero t means no deadline.
+//
+// Server.WriteTimeout is restored for the next response on the connection.
+func (ctx *RequestCtx) SetWriteDeadline(t time.Time) error {
+	if ctx.deadlinesUnsupported || ctx.s == fakeServer {
+		return ErrDeadlineNotSupported
+	}
+	if err := ctx.c.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	ctx.writeDeadlineSet = true
+	return nil
 }
 
 // SetUserValue stores the given value (arbitrary object)
@@ -486,6 +1174,143 @@ func (ctx *RequestCtx) UserValueBytes(key []byte) interface{} {
 	return ctx.userValues.GetBytes(key)
 }
 
+// Deadline returns the time when the request context is cancelled
+// due to timeout.
+//
+// The request context has no deadline, so ok is always false.
+//
+// This method is present to make RequestCtx implement the context.Context
+// interface.
+func (ctx *RequestCtx) Deadline() (deadline time.Time, ok bool) {
+	return
+}
+
+// Done returns a channel, which is closed when the request context
+// is cancelled. This happens when:
+//
+//   * the client closes the connection or the connection breaks;
+//   * the server is shut down via Server.Shutdown;
+//   * RequestHandler returns or times out in TimeoutHandler.
+//
+// Closed connections are detected via periodic non-blocking checks.
+// The checks are supported on unix platforms for connections implementing
+// syscall.Conn and for connections served via net/http such as HTTP/2
+// and HTTP/3 connections.
+//
+// The channel is never closed for ctx prepared via Init or Init2,
+// since it isn't served by Server.
+//
+// This method is present to make RequestCtx implement the context.Context
+// interface, so ctx may be passed to functions accepting context.Context
+// such as Client.DoContext for cancelling them together with the request.
+func (ctx *RequestCtx) Done() <-chan struct{} {
+	ctx.doneLock.Lock()
+	if ctx.done == nil {
+		ctx.done = make(chan struct{})
+		ctx.doneStop = make(chan struct{})
+		if ctx.s != nil && ctx.s != fakeServer {
+			go ctx.watchDone(ctx.doneStop, ctx.s.shutdownCh(), ctx.connDone, ctx.c)
+		}
+	}
+	done := ctx.done
+	ctx.doneLock.Unlock()
+	return done
+}
+
+// Err returns context.Canceled after the channel returned from Done
+// is closed. Otherwise nil is returned.
+//
+// This method is present to make RequestCtx implement the context.Context
+// interface.
+func (ctx *RequestCtx) Err() error {
+	ctx.Done()
+	ctx.doneLock.Lock()
+	err := ctx.doneErr
+	ctx.doneLock.Unlock()
+	return err
+}
+
+// Value returns the value stored via SetUserValue* under the given key
+// if the key is string or []byte. Otherwise nil is returned.
+//
+// This method is present to make RequestCtx implement the context.Context
+// interface.
+func (ctx *RequestCtx) Value(key interface{}) interface{} {
+	switch k := key.(type) {
+	case string:
+		return ctx.UserValue(k)
+	case []byte:
+		return ctx.UserValueBytes(k)
+	}
+	return nil
+}
+
+// connCheckInterval is the interval for checking whether the connection
+// is closed while the request context isn't cancelled.
+const connCheckInterval = 100 * time.Millisecond
+
+// watchDone cancels the request context when the server is shut down
+// or the connection c is closed until stop is closed.
+func (ctx *RequestCtx) watchDone(stop, shutdownCh, connDone <-chan struct{}, c net.Conn) {
+	if pc, ok := c.(*perIPConn); ok {
+		c = pc.Conn
+	}
+	var tickerCh <-chan time.Time
+	if connDone == nil {
+		ticker := time.NewTicker(connCheckInterval)
+		defer ticker.Stop()
+		tickerCh = ticker.C
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-shutdownCh:
+		case <-connDone:
+		case <-tickerCh:
+			if !isConnClosed(c) {
+				continue
+			}
+		}
+		break
+	}
+
+	ctx.doneLock.Lock()
+	if ctx.doneStop == stop {
+		// The context belongs to the same request, so cancel it.
+		ctx.cancelDoneNolock()
+	}
+	ctx.doneLock.Unlock()
+}
+
+func (ctx *RequestCtx) cancelDoneNolock() {
+	if ctx.doneErr == nil {
+		ctx.doneErr = context.Canceled
+		close(ctx.done)
+		close(ctx.doneStop)
+	}
+}
+
+// cancelDone cancels the request context after RequestHandler returns.
+//
+// The context is prepared for the next request if reset is set.
+func (ctx *RequestCtx) cancelDone(reset bool) {
+	ctx.doneLock.Lock()
+	if ctx.done != nil {
+		ctx.cancelDoneNolock()
+		if reset {
+			ctx.done = nil
+			ctx.doneErr = nil
+			ctx.doneStop = nil
+		}
+	}
+	if reset {
+		ctx.connDone = nil
+	}
+	ctx.doneLock.Unlock()
+}
+
 // VisitUserValues calls visitor for each existing userValue.
 //
 // visitor must not retain references to key and value after returning.
@@ -513,10 +1338,20 @@ func (ctx *RequestCtx) IsTLS() bool {
 	//
 	//     // other custom fields here
 	// }
-	_, ok := ctx.c.(connTLSer)
+	_, ok := connTLS(ctx.c)
 	return ok
 }
 
+// connTLS returns TLS connection for c if c is TLS connection.
+func connTLS(c net.Conn) (connTLSer, bool) {
+	if pc, ok := c.(*perIPConn); ok {
+		// Connections are wrapped into perIPConn if Server.MaxConnsPerIP is set.
+		c = pc.Conn
+	}
+	tlsConn, ok := c.(connTLSer)
+	return tlsConn, ok
+}
+
 // TLSConnectionState returns TLS connection state.
 //
 // The function returns nil if the underlying connection isn't tls.Conn.
@@ -524,7 +1359,7 @@ func (ctx *RequestCtx) IsTLS() bool {
 // The returned state may be used for verifying TLS version, client certificates,
 // etc.
 func (ctx *RequestCtx) TLSConnectionState() *tls.ConnectionState {
-	tlsConn, ok := ctx.c.(connTLSer)
+	tlsConn, ok := connTLS(ctx.c)
 	if !ok {
 		return nil
 	}
@@ -532,6 +1367,20 @@ func (ctx *RequestCtx) TLSConnectionState() *tls.ConnectionState {
 	return &state
 }
 
+// ClientCertificate returns TLS client certificate.
+//
+// nil is returned if the connection isn't TLS or the client didn't send
+// a certificate. The certificate is verified only if Server.ClientAuth
+// requires verification, so check TLSConnectionState().VerifiedChains
+// for custom ClientAuth policies.
+func (ctx *RequestCtx) ClientCertificate() *x509.Certificate {
+	state := ctx.TLSConnectionState()
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
 type firstByteReader struct {
 	c        net.Conn
 	ch       byte
@@ -553,6 +1402,22 @@ func (r *firstByteReader) Read(b []byte) (int, error) {
 	return n + nn, err
 }
 
+// RequestConfig configures the request being read by Server.
+//
+// It is returned from Server.HeaderReceived. Zero fields mean that
+// the corresponding Server settings are used.
+type RequestConfig struct {
+	// ReadTimeout is the maximum duration for reading the request body,
+	// starting from HeaderReceived call.
+	ReadTimeout time.Duration
+
+	// WriteTimeout is the maximum duration for writing the response.
+	WriteTimeout time.Duration
+
+	// MaxRequestBodySize is the maximum request body size.
+	MaxRequestBodySize int
+}
+
 // Logger is used for logging formatted messages.
 type Logger interface {
 	// Printf must have the same semantics as log.Printf.
@@ -704,6 +1569,7 @@ func (ctx *RequestCtx) QueryArgs() *Args {
 //
 // See also QueryArgs, FormValue and FormFile.
 func (ctx *RequestCtx) PostArgs() *Args {
+	ctx.readRequestBodyStream()
 	return ctx.Request.PostArgs()
 }
 
@@ -718,11 +1584,33 @@ func (ctx *RequestCtx) PostArgs() *Args {
 //
 // Use SaveMultipartFile function for permanently saving uploaded file.
 //
+// The form is read with limits set via Server.MaxMultipartFormMemory,
+// Server.MaxMultipartFormSize and Server.MaxMultipartFileSize.
+//
 // The returned form is valid until returning from RequestHandler.
 //
 // See also FormFile and FormValue.
 func (ctx *RequestCtx) MultipartForm() (*multipart.Form, error) {
-	return ctx.Request.MultipartForm()
+	var l multipartFormLimits
+	if s := ctx.s; s != nil {
+		l = s.multipartFormLimits()
+	}
+	return ctx.Request.multipartFormLimited(l)
+}
+
+// MultipartReader returns MultipartReader for reading parts of requests's
+// multipart form one by one without buffering the whole form in memory.
+//
+// Returns ErrNoMultipartForm if request's content-type
+// isn't 'multipart/form-data'.
+//
+// Parts are read directly from the connection if Server.StreamRequestBody
+// is set. MultipartForm, FormFile, FormValue and PostBody mustn't be used
+// together with MultipartReader in this case.
+//
+// The returned reader is valid until returning from RequestHandler.
+func (ctx *RequestCtx) MultipartReader() (*MultipartReader, error) {
+	return ctx.Request.MultipartReader()
 }
 
 // FormFile returns uploaded file associated with the given multipart form key.
@@ -753,6 +1641,11 @@ func (ctx *RequestCtx) FormFile(key string) (*multipart.FileHeader, error) {
 var ErrMissingFile = errors.New("there is no uploaded file associated with the given key")
 
 // SaveMultipartFile saves multipart file fh under the given filename path.
+//
+// The file is saved atomically, i.e. path contains either the whole file
+// or its' previous contents. The temporary file holding the uploaded file
+// is moved to path without copying if they are located on the same
+// filesystem.
 func SaveMultipartFile(fh *multipart.FileHeader, path string) error {
 	f, err := fh.Open()
 	if err != nil {
@@ -761,15 +1654,35 @@ func SaveMultipartFile(fh *multipart.FileHeader, path string) error {
 	defer f.Close()
 
 	if ff, ok := f.(*os.File); ok {
-		return os.Rename(ff.Name(), path)
+		if err = os.Rename(ff.Name(), path); err == nil {
+			return nil
+		}
+		// The temporary file may be located on another filesystem,
+		// so fall back to copying it.
 	}
+	return saveFileAtomic(f, path)
+}
+
+var saveFileCounter uint64
 
-	ff, err := os.Create(path)
+// saveFileAtomic copies r into temporary file located in the same directory
+// as path and then renames it to path.
+func saveFileAtomic(r io.Reader, path string) error {
+	tmpPath := fmt.Sprintf("%s.%d.%d.tmp", path, os.Getpid(), atomic.AddUint64(&saveFileCounter, 1))
+	ff, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
 	if err != nil {
 		return err
 	}
-	defer ff.Close()
-	_, err = copyZeroAlloc(ff, f)
+	_, err = copyZeroAlloc(ff, r)
+	if err1 := ff.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
 	return err
 }
 
@@ -780,6 +1693,8 @@ func SaveMultipartFile(fh *multipart.FileHeader, path string) error {
 //   * Query string.
 //   * POST or PUT body.
 //
+// Use FormValues for obtaining all the values for the given key.
+//
 // There are more fine-grained methods for obtaining form values:
 //
 //   * QueryArgs for obtaining values from query string.
@@ -807,6 +1722,28 @@ func (ctx *RequestCtx) FormValue(key string) []byte {
 	return nil
 }
 
+// FormValues returns all the form values associated with the given key.
+//
+// Unlike FormValue, the values are collected from all the places
+// in the following order:
+//
+//   * Query string.
+//   * POST or PUT body.
+//   * Multipart form.
+//
+// The returned values are valid until returning from RequestHandler.
+func (ctx *RequestCtx) FormValues(key string) [][]byte {
+	values := ctx.QueryArgs().PeekMulti(key)
+	values = append(values, ctx.PostArgs().PeekMulti(key)...)
+	mf, err := ctx.MultipartForm()
+	if err == nil && mf.Value != nil {
+		for _, v := range mf.Value[key] {
+			values = append(values, []byte(v))
+		}
+	}
+	return values
+}
+
 // IsGet returns true if request method is GET.
 func (ctx *RequestCtx) IsGet() bool {
 	return ctx.Request.Header.IsGet()
@@ -1052,6 +1989,28 @@ func (ctx *RequestCtx) PostBody() []byte {
 	return ctx.Request.Body()
 }
 
+// RequestBodyStream returns the request body stream.
+//
+// The body is read incrementally from the connection if
+// Server.StreamRequestBody is set. Otherwise the returned reader reads
+// the body already read into memory.
+//
+// The returned reader is valid until returning from RequestHandler.
+func (ctx *RequestCtx) RequestBodyStream() io.Reader {
+	if ctx.Request.bodyStream != nil {
+		return ctx.Request.bodyStream
+	}
+	return bytes.NewReader(ctx.Request.Body())
+}
+
+// readRequestBodyStream reads the request body streamed
+// with Server.StreamRequestBody into memory.
+func (ctx *RequestCtx) readRequestBodyStream() {
+	if ctx.Request.bodyStream != nil {
+		ctx.Request.Body()
+	}
+}
+
 // SetBodyStream sets response body stream and, optionally body size.
 //
 // bodyStream.Close() is called after finishing reading all body data
@@ -1082,6 +2041,135 @@ func (ctx *RequestCtx) SetBodyStreamWriter(sw StreamWriter) {
 	ctx.Response.SetBodyStreamWriter(sw)
 }
 
+// WriteChunk sends p to the client as a chunk of the response body
+// without waiting for RequestHandler to return.
+//
+// The response header is sent on the first call, so the status code
+// and header fields mustn't be changed after that except for trailer
+// fields declared via ResponseHeader.AddTrailer. The response body
+// written via Write* before the first call is sent as the first chunk,
+// while the body written after it is sent as the last chunk. The last
+// chunk and the trailer are sent automatically after RequestHandler
+// returns.
+//
+// WriteChunk may be used for streaming the response from RequestHandler
+// itself instead of SetBodyStreamWriter. The body is buffered as if it is
+// written via Write if the response cannot be sent in chunked encoding,
+// e.g. for HTTP/1.0 and HEAD requests.
+//
+// WriteChunk mustn't be used together with SetBodyStream* and in request
+// handlers wrapped by TimeoutHandler. Responses sent via WriteChunk aren't
+// compressed by CompressHandler*, since the response header is sent before
+// the compression could start. Use SetBodyStreamWriter for compressible
+// streamed responses.
+func (ctx *RequestCtx) WriteChunk(p []byte) error {
+	if ctx.chunkErr != nil {
+		return ctx.chunkErr
+	}
+	if !ctx.chunked {
+		if !ctx.canWriteChunks() {
+			ctx.Write(p)
+			return nil
+		}
+		ctx.chunked = true
+		ctx.chunkErr = ctx.writeChunkedHeader()
+		if body := ctx.Response.bodyBytes(); ctx.chunkErr == nil && len(body) > 0 {
+			ctx.chunkErr = ctx.writeChunkBytes(body)
+		}
+		ctx.Response.ResetBody()
+		if ctx.chunkErr != nil {
+			return ctx.chunkErr
+		}
+	}
+	if len(p) == 0 {
+		// Empty chunk terminates chunked body.
+		return nil
+	}
+	ctx.chunkErr = ctx.writeChunkBytes(p)
+	return ctx.chunkErr
+}
+
+// canWriteChunks returns true if the response may be sent
+// in chunked encoding.
+func (ctx *RequestCtx) canWriteChunks() bool {
+	if ctx.netHTTPWriter != nil {
+		return true
+	}
+	if ctx.s == fakeServer || ctx.c == nil {
+		return false
+	}
+	return ctx.Request.Header.IsHTTP11() && !ctx.IsHead() && !ctx.Response.Header.mustSkipContentLength()
+}
+
+func (ctx *RequestCtx) writeChunkedHeader() error {
+	resp := &ctx.Response
+	if w := ctx.netHTTPWriter; w != nil {
+		writeNetHTTPHeader(w, resp, -1)
+		return nil
+	}
+
+	s := ctx.s
+	resp.Header.SetContentLength(-1)
+	if len(resp.Header.Server()) == 0 {
+		resp.Header.SetServerBytes(s.getServerName())
+	}
+	if s.DisableKeepalive || ctx.Request.Header.ConnectionClose() || atomic.LoadInt32(&s.stop) == 1 {
+		resp.SetConnectionClose()
+	}
+	if ctx.bw == nil {
+		ctx.bw = acquireWriter(ctx)
+	}
+	return resp.Header.Write(ctx.bw)
+}
+
+func (ctx *RequestCtx) writeChunkBytes(p []byte) error {
+	if w := ctx.netHTTPWriter; w != nil {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return nil
+	}
+
+	if s := ctx.s; s.WriteTimeout > 0 && !ctx.writeDeadlineSet && !ctx.deadlinesUnsupported {
+		// Every chunk is written with WriteTimeout, since the response
+		// may be streamed for a long time.
+		if err := ctx.c.SetWriteDeadline(time.Now().Add(s.WriteTimeout)); err != nil {
+			return err
+		}
+	}
+	return writeChunk(ctx.bw, p)
+}
+
+// finishChunkedResponse writes the rest of the response started
+// by WriteChunk, i.e. the remaining body, the last chunk and the trailer.
+//
+// w is nil for requests served via net/http.
+func (ctx *RequestCtx) finishChunkedResponse(w *bufio.Writer) error {
+	err := ctx.chunkErr
+	resp := &ctx.Response
+	if err == nil && !resp.SkipBody {
+		body := resp.bodyBytes()
+		if hw := ctx.netHTTPWriter; hw != nil {
+			if _, err = hw.Write(body); err == nil {
+				writeNetHTTPTrailer(hw, resp)
+			}
+		} else {
+			if len(body) > 0 {
+				err = writeChunk(w, body)
+			}
+			if err == nil {
+				err = writeLastChunk(w, resp.Header.trailer())
+			}
+		}
+	}
+	ctx.chunked = false
+	ctx.chunkErr = nil
+	return err
+}
+
 // IsBodyStream returns true if response body is set via SetBodyStream*.
 func (ctx *RequestCtx) IsBodyStream() bool {
 	return ctx.Response.IsBodyStream()
@@ -1217,8 +2305,18 @@ func (s *Server) ListenAndServeTLSEmbed(addr string, certData, keyData []byte) e
 // ServeTLS serves HTTPS requests from the given listener.
 //
 // certFile and keyFile are paths to TLS certificate and key files.
+// They may be empty if certificates are registered via AppendCert,
+// AppendCertEmbed or Server.TLSConfig.
 func (s *Server) ServeTLS(ln net.Listener, certFile, keyFile string) error {
-	lnTLS, err := newTLSListener(ln, certFile, keyFile)
+	var cert *tls.Certificate
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		c, err := loadTLSCert(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	lnTLS, err := s.newTLSListener(ln, cert)
 	if err != nil {
 		return err
 	}
@@ -1228,37 +2326,140 @@ func (s *Server) ServeTLS(ln net.Listener, certFile, keyFile string) error {
 // ServeTLSEmbed serves HTTPS requests from the given listener.
 //
 // certData and keyData must contain valid TLS certificate and key data.
+// They may be empty if certificates are registered via AppendCert,
+// AppendCertEmbed or Server.TLSConfig.
 func (s *Server) ServeTLSEmbed(ln net.Listener, certData, keyData []byte) error {
-	lnTLS, err := newTLSListenerEmbed(ln, certData, keyData)
+	var cert *tls.Certificate
+	if len(certData) > 0 || len(keyData) > 0 {
+		c, err := loadTLSCertEmbed(certData, keyData)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	lnTLS, err := s.newTLSListener(ln, cert)
 	if err != nil {
 		return err
 	}
 	return s.Serve(lnTLS)
 }
 
-func newTLSListener(ln net.Listener, certFile, keyFile string) (net.Listener, error) {
+// AppendCert registers TLS certificate from the given certFile and keyFile
+// for ServeTLS* and ListenAndServeTLS* functions.
+//
+// Register multiple certificates for serving multiple domains
+// over a single listener. The certificate is selected by SNI from
+// the TLS client hello. The first registered certificate is used
+// for clients without SNI or with unknown server name.
+//
+// AppendCert must be called before serving.
+func (s *Server) AppendCert(certFile, keyFile string) error {
+	cert, err := loadTLSCert(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	s.appendCert(cert)
+	return nil
+}
+
+// AppendCertEmbed registers TLS certificate from the given certData
+// and keyData like AppendCert.
+//
+// AppendCertEmbed must be called before serving.
+func (s *Server) AppendCertEmbed(certData, keyData []byte) error {
+	cert, err := loadTLSCertEmbed(certData, keyData)
+	if err != nil {
+		return err
+	}
+	s.appendCert(cert)
+	return nil
+}
+
+// AppendClientCA registers CA certificates from the given PEM file
+// for verifying TLS client certificates.
+//
+// See Server.ClientAuth for details.
+//
+// AppendClientCA must be called before serving.
+func (s *Server) AppendClientCA(caFile string) error {
+	caData, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("cannot read client CA file %q: %s", caFile, err)
+	}
+	return s.AppendClientCAEmbed(caData)
+}
+
+// AppendClientCAEmbed registers CA certificates from the given PEM data
+// for verifying TLS client certificates.
+//
+// AppendClientCAEmbed must be called before serving.
+func (s *Server) AppendClientCAEmbed(caData []byte) error {
+	if s.TLSConfig == nil {
+		s.TLSConfig = newDefaultTLSConfig()
+	}
+	if s.TLSConfig.ClientCAs == nil {
+		s.TLSConfig.ClientCAs = x509.NewCertPool()
+	}
+	if !s.TLSConfig.ClientCAs.AppendCertsFromPEM(caData) {
+		return fmt.Errorf("cannot find PEM-encoded certificates in the provided caData(%d)", len(caData))
+	}
+	return nil
+}
+
+func (s *Server) appendCert(cert tls.Certificate) {
+	if s.TLSConfig == nil {
+		s.TLSConfig = newDefaultTLSConfig()
+	}
+	s.TLSConfig.Certificates = append(s.TLSConfig.Certificates, cert)
+}
+
+func loadTLSCert(certFile, keyFile string) (tls.Certificate, error) {
 	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
-		return nil, fmt.Errorf("cannot load TLS key pair from certFile=%q and keyFile=%q: %s", certFile, keyFile, err)
+		return cert, fmt.Errorf("cannot load TLS key pair from certFile=%q and keyFile=%q: %s", certFile, keyFile, err)
 	}
-	return newCertListener(ln, &cert), nil
+	return cert, nil
 }
 
-func newTLSListenerEmbed(ln net.Listener, certData, keyData []byte) (net.Listener, error) {
+func loadTLSCertEmbed(certData, keyData []byte) (tls.Certificate, error) {
 	cert, err := tls.X509KeyPair(certData, keyData)
 	if err != nil {
-		return nil, fmt.Errorf("cannot load TLS key pair from the provided certData(%d) and keyData(%d): %s",
+		return cert, fmt.Errorf("cannot load TLS key pair from the provided certData(%d) and keyData(%d): %s",
 			len(certData), len(keyData), err)
 	}
-	return newCertListener(ln, &cert), nil
+	return cert, nil
+}
+
+func newDefaultTLSConfig() *tls.Config {
+	return &tls.Config{
+		PreferServerCipherSuites: true,
+	}
 }
 
-func newCertListener(ln net.Listener, cert *tls.Certificate) net.Listener {
-	tlsConfig := &tls.Config{
-		Certificates:             []tls.Certificate{*cert},
-		PreferServerCipherSuites: true,
+var errNoCertificates = errors.New("no TLS certificates provided. Pass certificate to ServeTLS* or register it via AppendCert* or Server.TLSConfig")
+
+// newTLSListener returns TLS listener with Server.TLSConfig and the given
+// optional cert.
+func (s *Server) newTLSListener(ln net.Listener, cert *tls.Certificate) (net.Listener, error) {
+	var tlsConfig *tls.Config
+	if s.TLSConfig != nil {
+		// Clone the config, so the cert passed to ServeTLS* isn't added
+		// to Server.TLSConfig.
+		tlsConfig = s.TLSConfig.Clone()
+	} else {
+		tlsConfig = newDefaultTLSConfig()
+	}
+	if cert != nil {
+		tlsConfig.Certificates = append(tlsConfig.Certificates, *cert)
 	}
-	return tls.NewListener(ln, tlsConfig)
+	tlsConfig.NextProtos = s.nextProtos(tlsConfig.NextProtos)
+	if s.ClientAuth != tls.NoClientCert {
+		tlsConfig.ClientAuth = s.ClientAuth
+	}
+	if len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil && tlsConfig.GetConfigForClient == nil {
+		return nil, errNoCertificates
+	}
+	return tls.NewListener(ln, tlsConfig), nil
 }
 
 // DefaultConcurrency is the maximum number of concurrent connections
@@ -1267,25 +2468,48 @@ const DefaultConcurrency = 256 * 1024
 
 // Serve serves incoming connections from the given listener.
 //
-// Serve blocks until the given listener returns permanent error.
+// Serve blocks until the given listener returns permanent error
+// or Shutdown is called. nil is returned after Shutdown call.
 func (s *Server) Serve(ln net.Listener) error {
 	var lastOverflowErrorTime time.Time
-	var lastPerIPErrorTime time.Time
 	var c net.Conn
 	var err error
 
+	s.mu.Lock()
+	if atomic.LoadInt32(&s.stop) == 1 {
+		s.mu.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.ln = append(s.ln, ln)
+	s.mu.Unlock()
+
+	workerFunc := s.serveConn
+	if s.MaxConnsPerIP > 0 {
+		workerFunc = s.servePerIPConn
+	}
+
 	maxWorkersCount := s.getConcurrency()
 	s.concurrencyCh = make(chan struct{}, maxWorkersCount)
 	wp := &workerPool{
-		WorkerFunc:      s.serveConn,
-		MaxWorkersCount: maxWorkersCount,
-		LogAllErrors:    s.LogAllErrors,
-		Logger:          s.logger(),
+		WorkerFunc:       workerFunc,
+		MaxWorkersCount:  maxWorkersCount,
+		LogAllErrors:     s.LogAllErrors,
+		MaxQueuedConns:   s.MaxQueuedConns,
+		MaxQueueWaitTime: s.MaxQueueWaitTime,
+		RejectFunc:       s.rejectConn,
+		Logger:           s.logger(),
+
+		MaxIdleWorkerDuration: s.MaxIdleWorkerDuration,
+		MaxIdleWorkers:        s.MaxIdleWorkers,
+		SpawnBurst:            s.WorkerSpawnBurst,
 	}
 	wp.Start()
+	s.addWorkerPool(wp)
+	defer s.removeWorkerPool(wp)
 
 	for {
-		if c, err = acceptConn(s, ln, &lastPerIPErrorTime); err != nil {
+		if c, err = acceptConn(s, ln); err != nil {
 			wp.Stop()
 			if err == io.EOF {
 				return nil
@@ -1293,9 +2517,7 @@ func (s *Server) Serve(ln net.Listener) error {
 			return err
 		}
 		if !wp.Serve(c) {
-			s.writeFastError(c, StatusServiceUnavailable,
-				"The connection cannot be served because Server.Concurrency limit exceeded")
-			c.Close()
+			s.rejectConn(c)
 			if time.Since(lastOverflowErrorTime) > time.Minute {
 				s.logger().Printf("The incoming connection cannot be served, because %d concurrent connections are served. "+
 					"Try increasing Server.Concurrency", maxWorkersCount)
@@ -1314,7 +2536,7 @@ func (s *Server) Serve(ln net.Listener) error {
 	}
 }
 
-func acceptConn(s *Server, ln net.Listener, lastPerIPErrorTime *time.Time) (net.Conn, error) {
+func acceptConn(s *Server, ln net.Listener) (net.Conn, error) {
 	for {
 		c, err := ln.Accept()
 		if err != nil {
@@ -1335,35 +2557,65 @@ func acceptConn(s *Server, ln net.Listener, lastPerIPErrorTime *time.Time) (net.
 		if c == nil {
 			panic("BUG: net.Listener returned (nil, nil)")
 		}
-		if s.MaxConnsPerIP > 0 {
-			pic := wrapPerIPConn(s, c)
-			if pic == nil {
-				if time.Since(*lastPerIPErrorTime) > time.Minute {
-					s.logger().Printf("The number of connections from %s exceeds MaxConnsPerIP=%d",
-						getConnIP4(c), s.MaxConnsPerIP)
-					*lastPerIPErrorTime = CoarseTimeNow()
-				}
-				continue
+		if s.TCPKeepalive {
+			if err := s.setTCPKeepalive(c); err != nil {
+				s.logger().Printf("Cannot enable TCP keep-alive on connection from %s: %s", c.RemoteAddr(), err)
 			}
-			c = pic
 		}
 		return c, nil
 	}
 }
 
-func wrapPerIPConn(s *Server, c net.Conn) net.Conn {
-	ip := getUint32IP(c)
-	if ip == 0 {
-		return c
+// servePerIPConn serves c if the number of connections from its ip
+// doesn't exceed MaxConnsPerIP.
+//
+// It runs in the worker instead of the accept loop, since obtaining
+// the client address may block, e.g. for PROXY protocol connections.
+func (s *Server) servePerIPConn(c net.Conn) error {
+	pic, perIPErr := wrapPerIPConn(s, c)
+	if perIPErr != nil {
+		s.mu.Lock()
+		if time.Since(s.lastPerIPErrorTime) > time.Minute {
+			s.logger().Printf("The number of connections from %s exceeds MaxConnsPerIP=%d",
+				perIPErr.IP, s.MaxConnsPerIP)
+			s.lastPerIPErrorTime = CoarseTimeNow()
+		}
+		s.mu.Unlock()
+		return nil
+	}
+	err := s.serveConn(pic)
+	if err != errHijacked {
+		pic.Close()
+	}
+	return err
+}
+
+func wrapPerIPConn(s *Server, c net.Conn) (net.Conn, *PerIPConnLimitError) {
+	ip4 := getConnIP4(c)
+	ip := ip2uint32(ip4)
+	if ip == 0 || s.isPerIPConnAllowlisted(ip4) {
+		return c, nil
 	}
 	n := s.perIPConnCounter.Register(ip)
 	if n > s.MaxConnsPerIP {
 		s.perIPConnCounter.Unregister(ip)
 		s.writeFastError(c, StatusTooManyRequests, "The number of connections from your ip exceeds MaxConnsPerIP")
 		c.Close()
-		return nil
+		return nil, &PerIPConnLimitError{
+			IP:    ip4,
+			Limit: s.MaxConnsPerIP,
+		}
+	}
+	return acquirePerIPConn(c, ip, &s.perIPConnCounter), nil
+}
+
+func (s *Server) isPerIPConnAllowlisted(ip net.IP) bool {
+	for _, ipNet := range s.MaxConnsPerIPAllowlist {
+		if ipNet.Contains(ip) {
+			return true
+		}
 	}
-	return acquirePerIPConn(c, ip, &s.perIPConnCounter)
+	return false
 }
 
 var defaultLogger = Logger(log.New(os.Stderr, "", log.LstdFlags))
@@ -1376,8 +2628,9 @@ func (s *Server) logger() Logger {
 }
 
 var (
-	// ErrPerIPConnLimit may be returned from ServeConn if the number of connections
-	// per ip exceeds Server.MaxConnsPerIP.
+	// ErrPerIPConnLimit matches *PerIPConnLimitError returned from ServeConn
+	// if the number of connections per ip exceeds Server.MaxConnsPerIP.
+	// Use errors.Is for checking it.
 	ErrPerIPConnLimit = errors.New("too many connections per ip")
 
 	// ErrConcurrencyLimit may be returned from ServeConn if the number
@@ -1400,9 +2653,9 @@ var (
 // ServeConn closes c before returning.
 func (s *Server) ServeConn(c net.Conn) error {
 	if s.MaxConnsPerIP > 0 {
-		pic := wrapPerIPConn(s, c)
-		if pic == nil {
-			return ErrPerIPConnLimit
+		pic, err := wrapPerIPConn(s, c)
+		if err != nil {
+			return err
 		}
 		c = pic
 	}
@@ -1410,8 +2663,7 @@ func (s *Server) ServeConn(c net.Conn) error {
 	n := atomic.AddUint32(&s.concurrency, 1)
 	if n > uint32(s.getConcurrency()) {
 		atomic.AddUint32(&s.concurrency, ^uint32(0))
-		s.writeFastError(c, StatusServiceUnavailable, "The connection cannot be served because Server.Concurrency limit exceeded")
-		c.Close()
+		s.rejectConn(c)
 		return ErrConcurrencyLimit
 	}
 
@@ -1432,6 +2684,213 @@ func (s *Server) ServeConn(c net.Conn) error {
 
 var errHijacked = errors.New("connection has been hijacked")
 
+// Shutdown gracefully shuts down the server without interrupting
+// active connections.
+//
+// Shutdown closes all the listeners passed to Serve, then closes
+// idle keep-alive connections and waits until active connections
+// finish serving their current requests. Responses to these requests
+// contain 'Connection: close' header, so clients migrate to other servers
+// quickly. Use Server.OnShutdownProgress for monitoring the progress
+// of connections' draining. HTTP/3 servers passed
+// to ServeHTTP3 are shut down too. Serve returns nil after Shutdown call,
+// so make sure the program waits for Shutdown to return before exiting.
+//
+// Hijacked connections aren't tracked by Shutdown, except connections
+// upgraded via RequestCtx.UpgradeWebSocket. Shutdown waits until their
+// handlers return, so use ShutdownWithContext for closing them forcibly.
+func (s *Server) Shutdown() error {
+	return s.ShutdownWithContext(context.Background())
+}
+
+// ShutdownWithContext gracefully shuts down the server like Shutdown,
+// but bounds the wait for active connections by ctx.
+//
+// Connections still open when ctx is done are closed forcibly,
+// Server.OnShutdownTimeout is called with their number and ctx.Err()
+// is returned.
+func (s *Server) ShutdownWithContext(ctx context.Context) error {
+	s.mu.Lock()
+	atomic.StoreInt32(&s.stop, 1)
+	if s.shutdown == nil {
+		s.shutdown = make(chan struct{})
+	}
+	select {
+	case <-s.shutdown:
+	default:
+		// Cancel contexts of the requests being served.
+		close(s.shutdown)
+	}
+	var err error
+	for _, ln := range s.ln {
+		if errClose := ln.Close(); errClose != nil && err == nil {
+			err = errClose
+		}
+	}
+	s.ln = nil
+	for c, idle := range s.conns {
+		if idle {
+			c.Close()
+		}
+	}
+	if s.h2cBaseConfig != nil {
+		// Send GOAWAY to h2c connections, so they are closed after
+		// serving in-flight requests. The base config has neither
+		// listeners nor connections, so Shutdown returns immediately.
+		s.h2cBaseConfig.Shutdown(context.Background())
+	}
+	var connsDrained chan struct{}
+	if len(s.conns) > 0 {
+		if s.connsDrained == nil {
+			s.connsDrained = make(chan struct{})
+		}
+		connsDrained = s.connsDrained
+	}
+	s.mu.Unlock()
+
+	if errShutdown := s.shutdownHTTP3(ctx); errShutdown != nil && err == nil {
+		err = errShutdown
+	}
+	if connsDrained == nil {
+		if s.OnShutdownProgress != nil {
+			s.OnShutdownProgress(0, 0)
+		}
+		return err
+	}
+	if s.waitConnsDrained(ctx, connsDrained) {
+		return err
+	}
+
+	s.mu.Lock()
+	n := len(s.conns)
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	if n == 0 {
+		// All the connections have been closed in the mean time.
+		return err
+	}
+	if s.OnShutdownTimeout != nil {
+		s.OnShutdownTimeout(n)
+	}
+	return ctx.Err()
+}
+
+// shutdownProgressInterval is the interval for checking the number
+// of remaining connections and requests reported via
+// Server.OnShutdownProgress.
+const shutdownProgressInterval = 100 * time.Millisecond
+
+// waitConnsDrained waits until connsDrained is closed or ctx is done
+// while reporting the progress via Server.OnShutdownProgress.
+//
+// false is returned if ctx is done before all the connections are closed.
+func (s *Server) waitConnsDrained(ctx context.Context, connsDrained <-chan struct{}) bool {
+	if s.OnShutdownProgress == nil {
+		select {
+		case <-connsDrained:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	ticker := time.NewTicker(shutdownProgressInterval)
+	defer ticker.Stop()
+	lastConns, lastRequests := -1, -1
+	for {
+		conns, requests := s.shutdownProgress()
+		if conns != lastConns || requests != lastRequests {
+			s.OnShutdownProgress(conns, requests)
+			lastConns, lastRequests = conns, requests
+		}
+		if conns == 0 {
+			return true
+		}
+		select {
+		case <-connsDrained:
+			// The closed channel remains selectable, while connections
+			// may be tracked after that, e.g. if they were accepted
+			// before the listener is closed. Fall back to the ticker.
+			connsDrained = nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// shutdownProgress returns the number of served connections and
+// the number of requests being served on these connections.
+func (s *Server) shutdownProgress() (conns, requests int) {
+	s.mu.Lock()
+	conns = len(s.conns)
+	for _, idle := range s.conns {
+		if !idle {
+			requests++
+		}
+	}
+	s.mu.Unlock()
+	return conns, requests
+}
+
+// shutdownCh returns the channel, which is closed when Shutdown is called.
+func (s *Server) shutdownCh() <-chan struct{} {
+	s.mu.Lock()
+	if s.shutdown == nil {
+		s.shutdown = make(chan struct{})
+	}
+	ch := s.shutdown
+	s.mu.Unlock()
+	return ch
+}
+
+// trackConn registers c as served, so Shutdown may close it.
+//
+// c is idle until the first request byte arrives.
+func (s *Server) trackConn(c net.Conn) {
+	s.mu.Lock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	s.conns[c] = true
+	s.mu.Unlock()
+}
+
+// untrackConn unregisters c registered via trackConn.
+func (s *Server) untrackConn(c net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	if len(s.conns) == 0 && s.connsDrained != nil {
+		close(s.connsDrained)
+		s.connsDrained = nil
+	}
+	s.mu.Unlock()
+}
+
+// setConnIdle marks c as idle while it waits for the next request.
+//
+// false is returned if c mustn't wait for the next request,
+// since the server is shutting down.
+func (s *Server) setConnIdle(c net.Conn, idle bool) bool {
+	s.mu.Lock()
+	if idle && atomic.LoadInt32(&s.stop) == 1 {
+		s.mu.Unlock()
+		return false
+	}
+	s.conns[c] = idle
+	s.mu.Unlock()
+	return true
+}
+
+// setConnState reports the state of c via Server.ConnState.
+func (s *Server) setConnState(c net.Conn, state ConnState) {
+	if s.ConnState != nil {
+		s.ConnState(c, state)
+	}
+}
+
 func (s *Server) getConcurrency() int {
 	n := s.Concurrency
 	if n <= 0 {
@@ -1458,9 +2917,24 @@ func (s *Server) serveConn(c net.Conn) error {
 	connID := nextConnID()
 	currentTime := CoarseTimeNow()
 	connTime := currentTime
-	maxRequestBodySize := s.MaxRequestBodySize
-	if maxRequestBodySize <= 0 {
-		maxRequestBodySize = DefaultMaxRequestBodySize
+	maxRequestBodySize := s.maxRequestBodySize()
+
+	s.trackConn(c)
+	mc := s.MetricsCollector
+	if mc != nil {
+		mc.ConnOpened()
+	}
+	s.setConnState(c, StateNew)
+
+	if s.hasALPNHandlers() {
+		if served, err := s.serveALPN(c, connID, connTime); served || err != nil {
+			s.untrackConn(c)
+			if mc != nil {
+				mc.ConnClosed(false)
+			}
+			s.setConnState(c, StateClosed)
+			return err
+		}
 	}
 
 	ctx := s.acquireCtx(c)
@@ -1473,25 +2947,57 @@ func (s *Server) serveConn(c net.Conn) error {
 		err             error
 		timeoutResponse *Response
 		hijackHandler   HijackHandler
+		hijackRaw       RawHijackHandler
+		hijackTrack     bool
+		bodyStream      *bodyStreamReader
+		span            RequestSpan
+		timing          *SpanTiming
+		reqStartTime    time.Time
+		reqBodySize     int
+		statusCode      int
+		respBodySize    int
 
 		lastReadDeadlineTime  time.Time
 		lastWriteDeadlineTime time.Time
+		readDeadlineSet       bool
+		writeDeadlineSet      bool
 
 		connectionClose bool
 		isHTTP11        bool
+
+		reqConf        RequestConfig
+		maxReqBodySize int
 	)
 	for {
 		connRequestNum++
 		ctx.time = currentTime
 
-		if s.ReadTimeout > 0 || s.MaxKeepaliveDuration > 0 {
-			lastReadDeadlineTime = s.updateReadDeadline(c, ctx, lastReadDeadlineTime)
+		// The connection is idle until the next request arrives,
+		// unless the next request is already buffered.
+		idle := br == nil
+		waitIdle := idle && connRequestNum > 1 && s.IdleTimeout > 0
+		if waitIdle {
+			// Force deadline update, since it is switched
+			// between IdleTimeout and ReadTimeout. Use precise time,
+			// since coarse time may be up to a second behind.
+			lastReadDeadlineTime = s.updateReadDeadline(c, ctx, s.IdleTimeout, time.Now(), zeroTime)
+			if lastReadDeadlineTime.IsZero() {
+				err = ErrKeepaliveTimeout
+				break
+			}
+		} else if s.ReadTimeout > 0 || s.MaxKeepaliveDuration > 0 {
+			lastReadDeadlineTime = s.updateReadDeadline(c, ctx, s.ReadTimeout, ctx.time, lastReadDeadlineTime)
 			if lastReadDeadlineTime.IsZero() {
 				err = ErrKeepaliveTimeout
 				break
 			}
 		}
-
+		if idle && connRequestNum > 1 {
+			if !s.setConnIdle(c, true) {
+				break
+			}
+			s.setConnState(c, StateIdle)
+		}
 		if !(s.ReduceMemoryUsage || ctx.lastReadDuration > time.Second) || br != nil {
 			if br == nil {
 				br = acquireReader(ctx)
@@ -1501,13 +3007,56 @@ func (s *Server) serveConn(c net.Conn) error {
 		}
 		ctx.Request.isTLS = isTLS
 
+		if err == nil && idle {
+			if _, err = br.Peek(1); err == nil {
+				// Mark the connection as busy as soon as the next request
+				// is started, since Shutdown closes idle connections.
+				s.setConnIdle(c, false)
+				if waitIdle {
+					// Switch to ReadTimeout after the next request is started.
+					err = s.resetReadDeadline(c, ctx, &lastReadDeadlineTime)
+				}
+			}
+			if err != nil {
+				releaseReader(s, br)
+				br = nil
+				if err == ErrKeepaliveTimeout {
+					break
+				}
+				// Treat all errors as EOF on unsuccessful read
+				// of the first request byte.
+				err = io.EOF
+			}
+		}
 		if err == nil {
 			if s.DisableHeaderNamesNormalizing {
 				ctx.Request.Header.DisableNormalizing()
 				ctx.Response.Header.DisableNormalizing()
 			}
-			err = ctx.Request.readLimitBody(br, maxRequestBodySize, s.GetOnly)
-			if br.Buffered() == 0 || err != nil {
+			if connRequestNum == 1 && s.EnableH2C && !isTLS && isH2CPreface(br) {
+				s.serveH2C(c, br, connID, connTime, nil, nil)
+
+				// br isn't returned to the pool, since it may be still read
+				// by http2 server. br may point to ctx.fbr, so do not return
+				// ctx into pool.
+				br = nil
+				ctx = s.acquireCtx(c)
+				break
+			}
+			reqConf = RequestConfig{}
+			maxReqBodySize = maxRequestBodySize
+			if s.HeaderReceived == nil {
+				err = ctx.Request.readLimitBody(br, maxReqBodySize, s.GetOnly, s.StreamRequestBody, s.multipartFormLimits())
+			} else {
+				reqConf, err = s.readRequestWithConfig(c, ctx, br, maxReqBodySize)
+				if reqConf.MaxRequestBodySize > 0 {
+					maxReqBodySize = reqConf.MaxRequestBodySize
+				}
+			}
+			if bs, ok := ctx.Request.bodyStream.(*bodyStreamReader); ok && err == nil {
+				// br is used for reading the body from Handler.
+				bodyStream = bs
+			} else if br.Buffered() == 0 || err != nil {
 				releaseReader(s, br)
 				br = nil
 			}
@@ -1520,14 +3069,31 @@ func (s *Server) serveConn(c net.Conn) error {
 			if err == io.EOF {
 				err = nil
 			} else {
-				bw = writeErrorResponse(bw, ctx, err)
+				bw = s.writeErrorResponse(bw, ctx, err)
 			}
 			break
 		}
+		if idle {
+			s.setConnState(c, StateActive)
+		}
 
 		// 'Expect: 100-continue' request handling.
 		// See http://www.w3.org/Protocols/rfc2616/rfc2616-sec8.html for details.
 		if !ctx.Request.Header.noBody() && ctx.Request.MayContinue() {
+			if s.ContinueHandler != nil && !s.ContinueHandler(&ctx.Request.Header) {
+				// Close the connection, since the client may send
+				// the rejected body without waiting for the response.
+				ctx.SetStatusCode(StatusExpectationFailed)
+				ctx.SetConnectionClose()
+				if bw == nil {
+					bw = acquireWriter(ctx)
+				}
+				if err = writeResponse(ctx, bw, nil, nil); err == nil {
+					err = bw.Flush()
+				}
+				break
+			}
+
 			// Send 'HTTP/1.1 100 Continue' response.
 			if bw == nil {
 				bw = acquireWriter(ctx)
@@ -1544,13 +3110,42 @@ func (s *Server) serveConn(c net.Conn) error {
 			if br == nil {
 				br = acquireReader(ctx)
 			}
-			err = ctx.Request.ContinueReadBody(br, maxRequestBodySize)
-			if br.Buffered() == 0 || err != nil {
+			if s.StreamRequestBody {
+				err = ctx.Request.continueReadBodyStream(br, maxReqBodySize)
+				if bs, ok := ctx.Request.bodyStream.(*bodyStreamReader); ok && err == nil {
+					bodyStream = bs
+				}
+			} else {
+				err = ctx.Request.continueReadBody(br, maxReqBodySize, s.multipartFormLimits())
+			}
+			if bodyStream == nil && (br.Buffered() == 0 || err != nil) {
 				releaseReader(s, br)
 				br = nil
 			}
 			if err != nil {
-				bw = writeErrorResponse(bw, ctx, err)
+				bw = s.writeErrorResponse(bw, ctx, err)
+				break
+			}
+		}
+
+		if s.EnableH2C && !isTLS && atomic.LoadInt32(&s.stop) == 0 {
+			if settings, ok := h2cUpgradeSettings(&ctx.Request.Header); ok {
+				if bw == nil {
+					bw = acquireWriter(ctx)
+				}
+				bw.Write(strResponseSwitchingToH2C)
+				if err = bw.Flush(); err != nil {
+					break
+				}
+				var r io.Reader = c
+				if br != nil {
+					r = br
+					br = nil
+				}
+				s.serveH2C(c, r, connID, connTime, newH2CUpgradeRequest(&ctx.Request), settings)
+
+				// See the comment above on serving h2c with prior knowledge.
+				ctx = s.acquireCtx(c)
 				break
 			}
 		}
@@ -1559,13 +3154,39 @@ func (s *Server) serveConn(c net.Conn) error {
 		isHTTP11 = ctx.Request.Header.IsHTTP11()
 
 		ctx.Response.Header.SetServerBytes(serverName)
+		s.setAltSvc(ctx)
 		ctx.connID = connID
 		ctx.connRequestNum = connRequestNum
 		ctx.connTime = connTime
 		ctx.time = currentTime
+		if s.Instrumenter != nil {
+			startTime := time.Now()
+			if span = s.Instrumenter.StartServerRequest(ctx); span != nil {
+				timing = acquireSpanTiming()
+				timing.Start = startTime
+			}
+		}
+		if mc != nil {
+			reqStartTime = time.Now()
+			reqBodySize = ctx.Request.bodySize()
+			mc.RequestStarted()
+		}
+		ctx.bw = bw
 		s.Handler(ctx)
+		if timing != nil {
+			timing.HandlerDone = time.Now()
+		}
+		// WriteChunk may acquire the writer.
+		bw = ctx.bw
+		ctx.bw = nil
+		readDeadlineSet = ctx.readDeadlineSet
+		ctx.readDeadlineSet = false
 
 		timeoutResponse = ctx.timeoutResponse
+
+		// The context of the timed out request is cancelled, but it isn't
+		// reset, since the request handler may still use it.
+		ctx.cancelDone(timeoutResponse == nil)
 		if timeoutResponse != nil {
 			ctx = s.acquireCtx(c)
 			timeoutResponse.CopyTo(&ctx.Response)
@@ -1573,6 +3194,31 @@ func (s *Server) serveConn(c net.Conn) error {
 				// Close connection, since br may be attached to the old ctx via ctx.fbr.
 				ctx.SetConnectionClose()
 			}
+			if bodyStream != nil {
+				// The timed out handler may still read the body from br,
+				// so br isn't returned to the pool.
+				br = nil
+				bodyStream = nil
+			}
+		}
+		if bodyStream != nil {
+			// Discard the unread body, so the next request may be read
+			// from br.
+			if !bodyStream.discardRest() {
+				ctx.SetConnectionClose()
+			}
+			bodyStream = nil
+			if br.Buffered() == 0 {
+				releaseReader(s, br)
+				br = nil
+			}
+		}
+		if readDeadlineSet {
+			// Restore server timeouts for the next request.
+			lastReadDeadlineTime = zeroTime
+			if s.ReadTimeout <= 0 && s.MaxKeepaliveDuration <= 0 {
+				c.SetReadDeadline(zeroTime)
+			}
 		}
 
 		if !ctx.IsGet() && ctx.IsHead() {
@@ -1581,22 +3227,69 @@ func (s *Server) serveConn(c net.Conn) error {
 		ctx.Request.Reset()
 
 		hijackHandler = ctx.hijackHandler
+		hijackRaw = ctx.hijackRawHandler
+		hijackTrack = ctx.hijackTrack
 		ctx.hijackHandler = nil
+		ctx.hijackRawHandler = nil
+		ctx.hijackTrack = false
+
+		// The connection upgraded via UpgradeWebSocket is hijacked
+		// regardless of keep-alive settings, since it doesn't serve
+		// HTTP requests after the response is sent.
+		upgrade := hijackTrack && ctx.Response.StatusCode() == StatusSwitchingProtocols
 
 		ctx.userValues.Reset()
 
-		if s.MaxRequestsPerConn > 0 && connRequestNum >= uint64(s.MaxRequestsPerConn) {
+		if s.MaxRequestsPerConn > 0 && connRequestNum >= uint64(s.MaxRequestsPerConn) && !upgrade {
 			ctx.SetConnectionClose()
 		}
 
-		if s.WriteTimeout > 0 || s.MaxKeepaliveDuration > 0 {
-			lastWriteDeadlineTime = s.updateWriteDeadline(c, ctx, lastWriteDeadlineTime)
+		if ctx.writeDeadlineSet {
+			// Keep the deadline set by the request handler.
+			ctx.writeDeadlineSet = false
+			writeDeadlineSet = true
+		} else if reqConf.WriteTimeout > 0 {
+			// Use the write timeout returned from HeaderReceived
+			// for the current response only.
+			if err = c.SetWriteDeadline(time.Now().Add(reqConf.WriteTimeout)); err != nil {
+				// The response cannot be sent, but the request
+				// has been already served.
+				if span != nil {
+					timing.End = time.Now()
+					span.End(&ctx.Response, timing, err)
+					releaseSpanTiming(timing)
+					span, timing = nil, nil
+				}
+				if mc != nil {
+					mc.RequestFinished(ctx.Response.StatusCode(), time.Since(reqStartTime), reqBodySize, 0)
+				}
+				break
+			}
+			writeDeadlineSet = true
+		} else {
+			if writeDeadlineSet {
+				// Restore server timeouts after the deadline set
+				// by the previous request handler.
+				lastWriteDeadlineTime = zeroTime
+				if s.WriteTimeout <= 0 && s.MaxKeepaliveDuration <= 0 {
+					c.SetWriteDeadline(zeroTime)
+				}
+				writeDeadlineSet = false
+			}
+			if s.WriteTimeout > 0 || s.MaxKeepaliveDuration > 0 {
+				lastWriteDeadlineTime = s.updateWriteDeadline(c, ctx, lastWriteDeadlineTime)
+			}
 		}
 
 		// Verify Request.Header.connectionCloseFast() again,
 		// since request handler might trigger full headers' parsing.
-		connectionClose = connectionClose || ctx.Request.Header.connectionCloseFast() || ctx.Response.ConnectionClose()
-		if connectionClose {
+		// Close the connection if the server is shutting down.
+		connectionClose = connectionClose || ctx.Request.Header.connectionCloseFast() || ctx.Response.ConnectionClose() ||
+			atomic.LoadInt32(&s.stop) == 1
+		if upgrade {
+			// Keep 'Connection: Upgrade' response header.
+			connectionClose = false
+		} else if connectionClose {
 			ctx.Response.Header.SetCanonical(strConnection, strClose)
 		} else if !isHTTP11 {
 			// Set 'Connection: keep-alive' response header for non-HTTP/1.1 request.
@@ -1612,7 +3305,21 @@ func (s *Server) serveConn(c net.Conn) error {
 		if bw == nil {
 			bw = acquireWriter(ctx)
 		}
-		if err = writeResponse(ctx, bw); err != nil {
+		if mc != nil {
+			statusCode = ctx.Response.StatusCode()
+			respBodySize = 0
+			if ctx.chunked {
+				respBodySize = -1
+			} else if !ctx.Response.SkipBody {
+				respBodySize = ctx.Response.bodySize()
+			}
+		}
+		err = writeResponse(ctx, bw, span, timing)
+		span, timing = nil, nil
+		if mc != nil {
+			mc.RequestFinished(statusCode, time.Since(reqStartTime), reqBodySize, respBodySize)
+		}
+		if err != nil {
 			break
 		}
 
@@ -1628,7 +3335,7 @@ func (s *Server) serveConn(c net.Conn) error {
 			}
 		}
 
-		if hijackHandler != nil {
+		if hijackHandler != nil || hijackRaw != nil {
 			var hjr io.Reader
 			hjr = c
 			if br != nil {
@@ -1648,8 +3355,13 @@ func (s *Server) serveConn(c net.Conn) error {
 			}
 			c.SetReadDeadline(zeroTime)
 			c.SetWriteDeadline(zeroTime)
-			go hijackConnHandler(hjr, c, s, hijackHandler)
+			if mc != nil {
+				mc.ConnHijacked()
+			}
+			s.setConnState(c, StateHijacked)
+			go hijackConnHandler(hjr, c, s, hijackHandler, hijackRaw, hijackTrack)
 			hijackHandler = nil
+			hijackRaw = nil
 			err = errHijacked
 			break
 		}
@@ -1664,18 +3376,89 @@ func (s *Server) serveConn(c net.Conn) error {
 		releaseWriter(s, bw)
 	}
 	s.releaseCtx(ctx)
+	if err != errHijacked || !hijackTrack {
+		// Tracked hijacked connections are untracked by hijackConnHandler.
+		s.untrackConn(c)
+	}
+	if err != errHijacked {
+		if mc != nil {
+			mc.ConnClosed(false)
+		}
+		s.setConnState(c, StateClosed)
+	}
 	return err
 }
 
-func (s *Server) updateReadDeadline(c net.Conn, ctx *RequestCtx, lastDeadlineTime time.Time) time.Time {
-	readTimeout := s.ReadTimeout
-	currentTime := ctx.time
+// readRequestWithConfig reads the request header, obtains RequestConfig
+// for the request via Server.HeaderReceived and then reads the request
+// body according to the obtained config.
+func (s *Server) readRequestWithConfig(c net.Conn, ctx *RequestCtx, br *bufio.Reader, maxBodySize int) (RequestConfig, error) {
+	req := &ctx.Request
+	if err := req.Header.Read(br); err != nil {
+		return RequestConfig{}, err
+	}
+	if s.GetOnly && !req.Header.IsGet() {
+		return RequestConfig{}, errGetOnly
+	}
+
+	reqConf, err := s.HeaderReceived(&req.Header)
+	if err != nil {
+		return reqConf, err
+	}
+	if reqConf.ReadTimeout > 0 {
+		// The deadline is restored to server timeouts
+		// after the request is served.
+		if err = c.SetReadDeadline(time.Now().Add(reqConf.ReadTimeout)); err != nil {
+			return reqConf, err
+		}
+		ctx.readDeadlineSet = true
+	}
+	if reqConf.MaxRequestBodySize > 0 {
+		maxBodySize = reqConf.MaxRequestBodySize
+	}
+	return reqConf, req.readBody(br, maxBodySize, s.StreamRequestBody, s.multipartFormLimits())
+}
+
+// resetReadDeadline switches read deadline from IdleTimeout to ReadTimeout.
+//
+// The error is returned if the deadline cannot be set, e.g. if the connection
+// has been closed by Shutdown.
+func (s *Server) resetReadDeadline(c net.Conn, ctx *RequestCtx, lastDeadlineTime *time.Time) error {
+	if s.ReadTimeout <= 0 && s.MaxKeepaliveDuration <= 0 {
+		if err := c.SetReadDeadline(zeroTime); err != nil {
+			return err
+		}
+		*lastDeadlineTime = zeroTime
+		return nil
+	}
+	deadlineTime, err := s.setReadDeadline(c, ctx, s.ReadTimeout, time.Now(), zeroTime)
+	if err != nil {
+		return err
+	}
+	*lastDeadlineTime = deadlineTime
+	if lastDeadlineTime.IsZero() {
+		return ErrKeepaliveTimeout
+	}
+	return nil
+}
+
+func (s *Server) updateReadDeadline(c net.Conn, ctx *RequestCtx, readTimeout time.Duration, currentTime, lastDeadlineTime time.Time) time.Time {
+	lastDeadlineTime, err := s.setReadDeadline(c, ctx, readTimeout, currentTime, lastDeadlineTime)
+	if err != nil {
+		panic(fmt.Sprintf("BUG: error in SetReadDeadline(%s): %s", readTimeout, err))
+	}
+	return lastDeadlineTime
+}
+
+// setReadDeadline works like updateReadDeadline, but returns the error
+// instead of panicking if the deadline cannot be set.
+func (s *Server) setReadDeadline(c net.Conn, ctx *RequestCtx, readTimeout time.Duration, currentTime, lastDeadlineTime time.Time) (time.Time, error) {
 	if s.MaxKeepaliveDuration > 0 {
 		connTimeout := s.MaxKeepaliveDuration - currentTime.Sub(ctx.connTime)
 		if connTimeout <= 0 {
-			return zeroTime
+			return zeroTime, nil
 		}
-		if connTimeout < readTimeout {
+		if readTimeout <= 0 || connTimeout < readTimeout {
 			readTimeout = connTimeout
 		}
 	}
@@ -1685,11 +3468,11 @@ func (s *Server) updateReadDeadline(c net.Conn, ctx *RequestCtx, lastDeadlineTim
 	// See https://github.com/golang/go/issues/15133 for details.
 	if currentTime.Sub(lastDeadlineTime) > (readTimeout >> 2) {
 		if err := c.SetReadDeadline(currentTime.Add(readTimeout)); err != nil {
-			panic(fmt.Sprintf("BUG: error in SetReadDeadline(%s): %s", readTimeout, err))
+			return lastDeadlineTime, err
 		}
 		lastDeadlineTime = currentTime
 	}
-	return lastDeadlineTime
+	return lastDeadlineTime, nil
 }
 
 func (s *Server) updateWriteDeadline(c net.Conn, ctx *RequestCtx, lastDeadlineTime time.Time) time.Time {
@@ -1720,15 +3503,44 @@ func (s *Server) updateWriteDeadline(c net.Conn, ctx *RequestCtx, lastDeadlineTi
 	return lastDeadlineTime
 }
 
-func hijackConnHandler(r io.Reader, c net.Conn, s *Server, h HijackHandler) {
-	hjc := s.acquireHijackConn(r, c)
-	h(hjc)
+func hijackConnHandler(r io.Reader, c net.Conn, s *Server, h HijackHandler, rh RawHijackHandler, track bool) {
+	br, _ := r.(*bufio.Reader)
+	switch {
+	case rh != nil:
+		var buffered []byte
+		if br != nil {
+			b, _ := br.Peek(br.Buffered())
+			buffered = append(buffered, b...)
+			releaseReader(s, br)
+		}
+		rh(c, buffered)
+	case s.KeepHijackedConns:
+		// The connection may be used after the handler returns,
+		// so it cannot be returned to the pool. br is owned
+		// by the connection for the same reason.
+		h(&hijackConn{
+			Conn: c,
+			r:    r,
+			keep: true,
+		})
+	default:
+		hjc := s.acquireHijackConn(r, c)
+		h(hjc)
+		s.releaseHijackConn(hjc)
+		if br != nil {
+			releaseReader(s, br)
+		}
+	}
 
-	if br, ok := r.(*bufio.Reader); ok {
-		releaseReader(s, br)
+	if !s.KeepHijackedConns {
+		c.Close()
+	}
+	if track {
+		s.untrackConn(c)
+	}
+	if s.MetricsCollector != nil {
+		s.MetricsCollector.ConnClosed(true)
 	}
-	c.Close()
-	s.releaseHijackConn(hjc)
 }
 
 func (s *Server) acquireHijackConn(r io.Reader, c net.Conn) *hijackConn {
@@ -1755,6 +3567,9 @@ func (s *Server) releaseHijackConn(hjc *hijackConn) {
 type hijackConn struct {
 	net.Conn
 	r io.Reader
+
+	// keep is set if the connection is closed by the hijack handler.
+	keep bool
 }
 
 func (c hijackConn) Read(p []byte) (int, error) {
@@ -1762,6 +3577,9 @@ func (c hijackConn) Read(p []byte) (int, error) {
 }
 
 func (c hijackConn) Close() error {
+	if c.keep {
+		return c.Conn.Close()
+	}
 	// hijacked conn is closed in hijackConnHandler.
 	return nil
 }
@@ -1774,11 +3592,21 @@ func (ctx *RequestCtx) LastTimeoutErrorResponse() *Response {
 	return ctx.timeoutResponse
 }
 
-func writeResponse(ctx *RequestCtx, w *bufio.Writer) error {
+func writeResponse(ctx *RequestCtx, w *bufio.Writer, span RequestSpan, timing *SpanTiming) error {
 	if ctx.timeoutResponse != nil {
 		panic("BUG: cannot write timed out response")
 	}
-	err := ctx.Response.Write(w)
+	var err error
+	if ctx.chunked {
+		err = ctx.finishChunkedResponse(w)
+	} else {
+		err = ctx.Response.Write(w)
+	}
+	if span != nil {
+		timing.End = time.Now()
+		span.End(&ctx.Response, timing, err)
+		releaseSpanTiming(timing)
+	}
 	ctx.Response.Reset()
 	return err
 }
@@ -1958,9 +3786,24 @@ func (s *Server) releaseCtx(ctx *RequestCtx) {
 	}
 	ctx.c = nil
 	ctx.fbr.c = nil
+	ctx.readDeadlineSet = false
+	ctx.writeDeadlineSet = false
+	ctx.deadlinesUnsupported = false
+	ctx.bw = nil
+	ctx.netHTTPWriter = nil
+	ctx.chunked = false
+	ctx.chunkErr = nil
 	s.ctxPool.Put(ctx)
 }
 
+func (s *Server) multipartFormLimits() multipartFormLimits {
+	return multipartFormLimits{
+		maxMemory:   s.MaxMultipartFormMemory,
+		maxFormSize: s.MaxMultipartFormSize,
+		maxFileSize: s.MaxMultipartFileSize,
+	}
+}
+
 func (s *Server) getServerName() []byte {
 	v := s.serverName.Load()
 	var serverName []byte
@@ -1988,8 +3831,89 @@ func (s *Server) writeFastError(w io.Writer, statusCode int, msg string) {
 		s.getServerName(), serverDate.Load(), len(msg), msg)
 }
 
-func writeErrorResponse(bw *bufio.Writer, ctx *RequestCtx, err error) *bufio.Writer {
-	if _, ok := err.(*ErrSmallBuffer); ok {
+// WorkerPoolStats contains statistics for workers serving connections
+// accepted by Server.Serve.
+type WorkerPoolStats struct {
+	// ActiveWorkers is the number of workers serving connections.
+	ActiveWorkers int
+
+	// IdleWorkers is the number of workers waiting for new connections.
+	IdleWorkers int
+
+	// QueuedConns is the number of connections waiting for a free worker.
+	// See Server.MaxQueuedConns for details.
+	QueuedConns int
+}
+
+// WorkerPoolStats returns the current statistics for workers of all
+// the running Serve calls.
+func (s *Server) WorkerPoolStats() WorkerPoolStats {
+	var st WorkerPoolStats
+	s.mu.Lock()
+	for _, wp := range s.workerPools {
+		wp.stats(&st)
+	}
+	s.mu.Unlock()
+	return st
+}
+
+func (s *Server) addWorkerPool(wp *workerPool) {
+	s.mu.Lock()
+	s.workerPools = append(s.workerPools, wp)
+	s.mu.Unlock()
+}
+
+func (s *Server) removeWorkerPool(wp *workerPool) {
+	s.mu.Lock()
+	for i, x := range s.workerPools {
+		if x == wp {
+			s.workerPools = append(s.workerPools[:i], s.workerPools[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+}
+
+// rejectConnWriteTimeout is the maximum duration for writing the response
+// to the rejected connection.
+const rejectConnWriteTimeout = time.Second
+
+// rejectConn writes the response for the connection exceeding
+// Concurrency limit and closes c.
+func (s *Server) rejectConn(c net.Conn) {
+	// The connection is rejected from worker pool goroutines, so the client
+	// not reading the response mustn't block them.
+	writeTimeout := rejectConnWriteTimeout
+	if s.WriteTimeout > 0 && s.WriteTimeout < writeTimeout {
+		writeTimeout = s.WriteTimeout
+	}
+	c.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	if s.ConcurrencyLimitHandler == nil {
+		s.writeFastError(c, StatusServiceUnavailable, "The connection cannot be served because Server.Concurrency limit exceeded")
+		c.Close()
+		return
+	}
+
+	ctx := s.acquireCtx(c)
+	ctx.Response.Header.SetServerBytes(s.getServerName())
+	ctx.Response.SetStatusCode(StatusServiceUnavailable)
+	s.ConcurrencyLimitHandler(ctx)
+	ctx.cancelDone(true)
+	ctx.SetConnectionClose()
+	bw := acquireWriter(ctx)
+	writeResponse(ctx, bw, nil, nil)
+	bw.Flush()
+	releaseWriter(s, bw)
+	ctx.userValues.Reset()
+	s.releaseCtx(ctx)
+	c.Close()
+}
+
+func (s *Server) writeErrorResponse(bw *bufio.Writer, ctx *RequestCtx, err error) *bufio.Writer {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(ctx, err)
+	} else if _, ok := err.(*ErrSmallBuffer); ok {
 		ctx.Error("Too big request header", StatusRequestHeaderFieldsTooLarge)
 	} else {
 		ctx.Error("Error when parsing request", StatusBadRequest)
@@ -1998,7 +3922,7 @@ func writeErrorResponse(bw *bufio.Writer, ctx *RequestCtx, err error) *bufio.Wri
 	if bw == nil {
 		bw = acquireWriter(ctx)
 	}
-	writeResponse(ctx, bw)
+	writeResponse(ctx, bw, nil, nil)
 	bw.Flush()
 	return bw
 }
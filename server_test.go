@@ -3,14 +3,26 @@ package fasthttp
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"net"
 	"os"
+	"reflect"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -112,6 +124,175 @@ func TestServerErrSmallBuffer(t *testing.T) {
 	}
 }
 
+func TestServerErrorHandler(t *testing.T) {
+	var errs []error
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("shouldn't be never called")
+		},
+		ErrorHandler: func(ctx *RequestCtx, err error) {
+			errs = append(errs, err)
+			statusCode := StatusBadRequest
+			if _, ok := err.(*ErrSmallBuffer); ok {
+				statusCode = StatusRequestHeaderFieldsTooLarge
+			} else if err == ErrBodyTooLarge {
+				statusCode = StatusRequestEntityTooLarge
+			}
+			ctx.SetStatusCode(statusCode)
+			ctx.SetBodyString("custom error")
+		},
+		ReadBufferSize:     100,
+		MaxRequestBodySize: 5,
+		Logger:             &customLogger{},
+	}
+
+	testServerErrorHandler(t, s, "GET / HTTP/1.1\r\nHost: aaa\r\nVERY-long-Header: "+strings.Repeat("x", 100)+"\r\n\r\n", StatusRequestHeaderFieldsTooLarge)
+	testServerErrorHandler(t, s, "GARBAGE\r\n\r\n", StatusBadRequest)
+	testServerErrorHandler(t, s, "POST / HTTP/1.1\r\nHost: aaa\r\nContent-Length: 10\r\n\r\n0123456789", StatusRequestEntityTooLarge)
+
+	if len(errs) != 3 {
+		t.Fatalf("unexpected number of errors passed to ErrorHandler: %d. Expecting 3", len(errs))
+	}
+	if _, ok := errs[0].(*ErrSmallBuffer); !ok {
+		t.Fatalf("unexpected error %v. Expecting *ErrSmallBuffer", errs[0])
+	}
+	if errs[2] != ErrBodyTooLarge {
+		t.Fatalf("unexpected error %v. Expecting %v", errs[2], ErrBodyTooLarge)
+	}
+}
+
+func testServerErrorHandler(t *testing.T, s *Server, request string, expectedStatusCode int) {
+	t.Helper()
+
+	rw := &readWriter{}
+	rw.r.WriteString(request)
+	if err := s.ServeConn(rw); err == nil {
+		t.Fatalf("expecting error for request %q", request)
+	}
+
+	var resp Response
+	if err := resp.Read(bufio.NewReader(&rw.w)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != expectedStatusCode {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), expectedStatusCode)
+	}
+	if string(resp.Body()) != "custom error" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "custom error")
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("missing 'Connection: close' response header")
+	}
+}
+
+func TestServerHeaderReceived(t *testing.T) {
+	errUnauthorized := errors.New("unauthorized")
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.PostBody())
+		},
+		HeaderReceived: func(header *RequestHeader) (RequestConfig, error) {
+			if len(header.Peek("Authorization")) == 0 {
+				return RequestConfig{}, errUnauthorized
+			}
+			if string(header.RequestURI()) == "/upload" {
+				return RequestConfig{MaxRequestBodySize: 10}, nil
+			}
+			return RequestConfig{}, nil
+		},
+		ErrorHandler: func(ctx *RequestCtx, err error) {
+			switch err {
+			case errUnauthorized:
+				ctx.Error(err.Error(), StatusUnauthorized)
+			case ErrBodyTooLarge:
+				ctx.Error(err.Error(), StatusRequestEntityTooLarge)
+			default:
+				ctx.Error(err.Error(), StatusBadRequest)
+			}
+		},
+		MaxRequestBodySize: 5,
+		Logger:             &customLogger{},
+	}
+
+	testServerHeaderReceived(t, s, "POST /upload HTTP/1.1\r\nHost: aaa\r\nAuthorization: foo\r\nContent-Length: 8\r\n\r\n01234567", StatusOK, "01234567", false)
+	testServerHeaderReceived(t, s, "POST /upload HTTP/1.1\r\nHost: aaa\r\nAuthorization: foo\r\nContent-Length: 11\r\n\r\n0123456789a", StatusRequestEntityTooLarge, ErrBodyTooLarge.Error(), true)
+	testServerHeaderReceived(t, s, "POST /foo HTTP/1.1\r\nHost: aaa\r\nAuthorization: foo\r\nContent-Length: 8\r\n\r\n01234567", StatusRequestEntityTooLarge, ErrBodyTooLarge.Error(), true)
+	testServerHeaderReceived(t, s, "POST /upload HTTP/1.1\r\nHost: aaa\r\nContent-Length: 8\r\n\r\n01234567", StatusUnauthorized, "unauthorized", true)
+
+	// 'Expect: 100-continue' requests must be rejected without sending
+	// '100 Continue' response.
+	rw := &readWriter{}
+	rw.r.WriteString("POST /upload HTTP/1.1\r\nHost: aaa\r\nExpect: 100-continue\r\nContent-Length: 8\r\n\r\n")
+	if err := s.ServeConn(rw); err != errUnauthorized {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errUnauthorized)
+	}
+	if resp := rw.w.String(); !strings.HasPrefix(resp, "HTTP/1.1 401 Unauthorized\r\n") {
+		t.Fatalf("unexpected response %q. Expecting 401 response", resp)
+	}
+}
+
+func testServerHeaderReceived(t *testing.T, s *Server, request string, expectedStatusCode int, expectedBody string, expectedConnectionClose bool) {
+	t.Helper()
+
+	rw := &readWriter{}
+	rw.r.WriteString(request)
+	s.ServeConn(rw)
+
+	var resp Response
+	if err := resp.Read(bufio.NewReader(&rw.w)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != expectedStatusCode {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), expectedStatusCode)
+	}
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	if resp.ConnectionClose() != expectedConnectionClose {
+		t.Fatalf("unexpected 'Connection: close' %v. Expecting %v", resp.ConnectionClose(), expectedConnectionClose)
+	}
+}
+
+func TestServerHeaderReceivedReadTimeout(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.PostBody())
+		},
+		HeaderReceived: func(header *RequestHeader) (RequestConfig, error) {
+			return RequestConfig{ReadTimeout: 50 * time.Millisecond}, nil
+		},
+		Logger: &customLogger{},
+	}
+	serverCh := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			serverCh <- err
+			return
+		}
+		serverCh <- s.ServeConn(c)
+	}()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte("POST / HTTP/1.1\r\nHost: aaa\r\nContent-Length: 10\r\n\r\n01234")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	select {
+	case err = <-serverCh:
+		if err == nil {
+			t.Fatalf("expecting timeout error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the request body must be read with ReadTimeout returned from HeaderReceived")
+	}
+}
+
 func TestRequestCtxIsTLS(t *testing.T) {
 	var ctx RequestCtx
 
@@ -525,6 +706,65 @@ func (conn *fakeIPConn) RemoteAddr() net.Addr {
 	return addr
 }
 
+func TestServerMaxConnsPerIPServeConn(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("OK")
+		},
+		MaxConnsPerIP: 1,
+	}
+	addr := &net.TCPAddr{
+		IP:   net.IPv4(1, 2, 3, 4),
+		Port: 8765,
+	}
+
+	// Occupy the only connection slot for the ip.
+	ip := ip2uint32(addr.IP.To4())
+	s.perIPConnCounter.Register(ip)
+
+	rw := &readWriter{}
+	rw.r.WriteString("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")
+	err := s.ServeConn(&readWriterRemoteAddr{
+		rw:   rw,
+		addr: addr,
+	})
+	limitErr, ok := err.(*PerIPConnLimitError)
+	if !ok {
+		t.Fatalf("unexpected error: %v. Expecting *PerIPConnLimitError", err)
+	}
+	if limitErr.IP.String() != "1.2.3.4" || limitErr.Limit != 1 {
+		t.Fatalf("unexpected error: %+v", limitErr)
+	}
+	if !errors.Is(err, ErrPerIPConnLimit) {
+		t.Fatalf("error %v must match ErrPerIPConnLimit", err)
+	}
+	var resp Response
+	if err = resp.Read(bufio.NewReader(&rw.w)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusTooManyRequests {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusTooManyRequests)
+	}
+
+	// Allowlisted ips aren't limited.
+	_, ipNet, err := net.ParseCIDR("1.2.3.0/24")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s.MaxConnsPerIPAllowlist = []*net.IPNet{ipNet}
+	rw = &readWriter{}
+	rw.r.WriteString("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")
+	if err = s.ServeConn(&readWriterRemoteAddr{
+		rw:   rw,
+		addr: addr,
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	verifyResponse(t, bufio.NewReader(&rw.w), StatusOK, "text/plain; charset=utf-8", "OK")
+
+	s.perIPConnCounter.Unregister(ip)
+}
+
 func TestServerConcurrencyLimit(t *testing.T) {
 	s := &Server{
 		Handler: func(ctx *RequestCtx) {
@@ -598,6 +838,126 @@ func TestServerConcurrencyLimit(t *testing.T) {
 	}
 }
 
+func TestServerConcurrencyQueue(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("OK")
+		},
+		ConcurrencyLimitHandler: func(ctx *RequestCtx) {
+			ctx.Error("busy", StatusTooManyRequests)
+		},
+		Concurrency:    1,
+		MaxQueuedConns: 1,
+		Logger:         &customLogger{},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- s.Serve(ln)
+	}()
+
+	// The first connection is served, the second one is queued,
+	// while the third one is rejected.
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		c, err := ln.Dial()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		conns = append(conns, c)
+	}
+	verifyResponse(t, bufio.NewReader(conns[2]), StatusTooManyRequests, "text/plain; charset=utf-8", "busy")
+	conns[2].Close()
+
+	// The queued connection is served after closing the first one.
+	for _, c := range conns[:2] {
+		if _, err := c.Write([]byte("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		verifyResponse(t, bufio.NewReader(c), StatusOK, string(defaultContentType), "OK")
+		if err := c.Close(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case err := <-serverCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestServerRejectConnWriteDeadline(t *testing.T) {
+	for _, h := range []RequestHandler{nil, func(ctx *RequestCtx) {}} {
+		s := &Server{
+			ConcurrencyLimitHandler: h,
+		}
+		c := &deadlineConn{}
+		s.rejectConn(c)
+		if len(c.writeDeadlines) != 1 {
+			t.Fatalf("unexpected write deadlines %v. Expecting a single deadline", c.writeDeadlines)
+		}
+		if d := time.Until(c.writeDeadlines[0]); d <= 0 || d > rejectConnWriteTimeout {
+			t.Fatalf("unexpected write deadline %s", c.writeDeadlines[0])
+		}
+		if !strings.HasPrefix(c.w.String(), "HTTP/1.1 503 ") {
+			t.Fatalf("unexpected response %q", c.w.String())
+		}
+	}
+}
+
+func TestServerConcurrencyQueueWaitTime(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("OK")
+		},
+		Concurrency:      1,
+		MaxQueuedConns:   1,
+		MaxQueueWaitTime: 50 * time.Millisecond,
+		Logger:           &customLogger{},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	go s.Serve(ln) //nolint:errcheck
+	defer ln.Close()
+
+	c1, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c1.Close()
+	c2, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c2.Close()
+
+	// The queued connection must be rejected after MaxQueueWaitTime,
+	// since the first connection remains busy.
+	ch := make(chan error, 1)
+	go func() {
+		var resp Response
+		err := resp.Read(bufio.NewReader(c2))
+		if err == nil && resp.StatusCode() != StatusServiceUnavailable {
+			err = fmt.Errorf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusServiceUnavailable)
+		}
+		ch <- err
+	}()
+	select {
+	case err = <-ch:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the queued connection must be rejected after MaxQueueWaitTime")
+	}
+}
+
 func TestServerWriteFastError(t *testing.T) {
 	s := &Server{
 		Name: "foobar",
@@ -712,71 +1072,341 @@ func TestServerServeTLSEmbed(t *testing.T) {
 	}
 }
 
-func TestServerMultipartFormDataRequest(t *testing.T) {
-	reqS := `POST /upload HTTP/1.1
-Host: qwerty.com
-Content-Length: 521
-Content-Type: multipart/form-data; boundary=----WebKitFormBoundaryJwfATyF8tmxSJnLg
-
-------WebKitFormBoundaryJwfATyF8tmxSJnLg
-Content-Disposition: form-data; name="f1"
+func TestServerAppendCertSNI(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
 
-value1
-------WebKitFormBoundaryJwfATyF8tmxSJnLg
-Content-Disposition: form-data; name="fileaaa"; filename="TODO"
-Content-Type: application/octet-stream
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("success")
+		},
+	}
+	for _, host := range []string{"foo.com", "bar.com"} {
+		certData, keyData := generateTestCert(t, host)
+		if err := s.AppendCertEmbed(certData, keyData); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := s.AppendCert("./ssl-cert-snakeoil.pem", "./ssl-cert-snakeoil.key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := s.AppendCert("./non-existing.pem", "./non-existing.key"); err == nil {
+		t.Fatalf("expecting error for non-existing certificate files")
+	}
 
-- SessionClient with referer and cookies support.
-- Client with requests' pipelining support.
-- ProxyHandler similar to FSHandler.
-- WebSockets. See https://tools.ietf.org/html/rfc6455 .
-- HTTP/2.0. See https://tools.ietf.org/html/rfc7540 .
+	serverStopCh := make(chan struct{})
+	go func() {
+		if err := s.ServeTLS(ln, "", ""); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		close(serverStopCh)
+	}()
 
-------WebKitFormBoundaryJwfATyF8tmxSJnLg--
+	testServerTLSCert(t, ln, "foo.com", "foo.com")
+	testServerTLSCert(t, ln, "bar.com", "bar.com")
 
-GET / HTTP/1.1
-Host: asbd
-Connection: close
+	// The first certificate must be used for unknown server names.
+	testServerTLSCert(t, ln, "unknown.com", "foo.com")
+	testServerTLSCert(t, ln, "", "foo.com")
 
-`
+	if err := ln.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-serverStopCh:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
 
+func TestServerTLSConfigGetCertificate(t *testing.T) {
 	ln := fasthttputil.NewInmemoryListener()
 
+	certs := make(map[string]*tls.Certificate)
+	for _, host := range []string{"foo.com", "bar.com"} {
+		certData, keyData := generateTestCert(t, host)
+		cert, err := tls.X509KeyPair(certData, keyData)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		certs[host] = &cert
+	}
 	s := &Server{
 		Handler: func(ctx *RequestCtx) {
-			switch string(ctx.Path()) {
-			case "/upload":
-				f, err := ctx.MultipartForm()
-				if err != nil {
-					t.Fatalf("unexpected error: %s", err)
-				}
-				if len(f.Value) != 1 {
-					t.Fatalf("unexpected values %d. Expecting %d", len(f.Value), 1)
-				}
-				if len(f.File) != 1 {
-					t.Fatalf("unexpected file values %d. Expecting %d", len(f.File), 1)
-				}
-				fv := ctx.FormValue("f1")
-				if string(fv) != "value1" {
-					t.Fatalf("unexpected form value: %q. Expecting %q", fv, "value1")
+			ctx.WriteString("success")
+		},
+		TLSConfig: &tls.Config{
+			GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				cert := certs[hello.ServerName]
+				if cert == nil {
+					return nil, fmt.Errorf("unknown server name %q", hello.ServerName)
 				}
-				ctx.Redirect("/", StatusSeeOther)
-			default:
-				ctx.WriteString("non-upload")
-			}
+				return cert, nil
+			},
 		},
 	}
 
-	ch := make(chan struct{})
+	serverStopCh := make(chan struct{})
 	go func() {
-		if err := s.Serve(ln); err != nil {
-			t.Fatalf("unexpected error: %s", err)
+		if err := s.ServeTLS(ln, "", ""); err != nil {
+			t.Errorf("unexpected error: %s", err)
 		}
-		close(ch)
+		close(serverStopCh)
 	}()
 
-	conn, err := ln.Dial()
-	if err != nil {
+	testServerTLSCert(t, ln, "foo.com", "foo.com")
+	testServerTLSCert(t, ln, "bar.com", "bar.com")
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-serverStopCh:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+	if len(s.TLSConfig.Certificates) != 0 {
+		t.Fatalf("Server.TLSConfig mustn't be modified by ServeTLS")
+	}
+}
+
+func TestServerServeTLSNoCertificates(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {},
+	}
+	if err := s.ServeTLS(ln, "", ""); err != errNoCertificates {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errNoCertificates)
+	}
+}
+
+func testServerTLSCert(t *testing.T, ln *fasthttputil.InmemoryListener, serverName, expectedCertName string) {
+	t.Helper()
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	defer tlsConn.Close()
+
+	if _, err = tlsConn.Write([]byte("GET / HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(tlsConn)
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "success")
+
+	cert := tlsConn.ConnectionState().PeerCertificates[0]
+	if cert.Subject.CommonName != expectedCertName {
+		t.Fatalf("unexpected certificate for server name %q: %q. Expecting %q", serverName, cert.Subject.CommonName, expectedCertName)
+	}
+}
+
+func TestServerClientAuth(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			cert := ctx.ClientCertificate()
+			if cert == nil {
+				ctx.Error("missing client certificate", StatusForbidden)
+				return
+			}
+			ctx.WriteString(cert.Subject.CommonName)
+		},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+	if err := s.AppendCert("./ssl-cert-snakeoil.pem", "./ssl-cert-snakeoil.key"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	certData, keyData := generateTestCert(t, "client.com")
+	if err := s.AppendClientCAEmbed(certData); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := s.AppendClientCAEmbed([]byte("foobar")); err == nil {
+		t.Fatalf("expecting error for invalid client CA")
+	}
+	clientCert, err := tls.X509KeyPair(certData, keyData)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	serverStopCh := make(chan struct{})
+	go func() {
+		if err := s.ServeTLS(ln, "", ""); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		close(serverStopCh)
+	}()
+
+	// Clients with trusted certificates must be served.
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tlsConn := tls.Client(conn, &tls.Config{
+		Certificates:       []tls.Certificate{clientCert},
+		InsecureSkipVerify: true,
+	})
+	if _, err = tlsConn.Write([]byte("GET / HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	verifyResponse(t, bufio.NewReader(tlsConn), StatusOK, string(defaultContentType), "client.com")
+	tlsConn.Close()
+
+	// Clients without certificates or with untrusted certificates
+	// must be rejected.
+	untrustedCertData, untrustedKeyData := generateTestCert(t, "client.com")
+	untrustedCert, err := tls.X509KeyPair(untrustedCertData, untrustedKeyData)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, certs := range [][]tls.Certificate{nil, {untrustedCert}} {
+		conn, err := ln.Dial()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		tlsConn := tls.Client(conn, &tls.Config{
+			Certificates:       certs,
+			InsecureSkipVerify: true,
+		})
+		tlsConn.Write([]byte("GET / HTTP/1.1\r\nHost: aaa\r\n\r\n"))
+		var resp Response
+		if err := resp.Read(bufio.NewReader(tlsConn)); err == nil {
+			t.Fatalf("expecting error for client certificates %d", len(certs))
+		}
+		tlsConn.Close()
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-serverStopCh:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestRequestCtxIsTLSPerIPConn(t *testing.T) {
+	var ctx RequestCtx
+	ctx.c = &perIPConn{
+		Conn: tls.Server(&readWriter{}, &tls.Config{}),
+	}
+	if !ctx.IsTLS() {
+		t.Fatalf("IsTLS must return true for TLS connection wrapped into perIPConn")
+	}
+	if ctx.TLSConnectionState() == nil {
+		t.Fatalf("TLSConnectionState must be non-nil for TLS connection wrapped into perIPConn")
+	}
+	if ctx.ClientCertificate() != nil {
+		t.Fatalf("unexpected client certificate before handshake")
+	}
+}
+
+// generateTestCert returns self-signed certificate and key in PEM format
+// for the given host.
+func generateTestCert(t *testing.T, host string) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: host,
+		},
+		DNSNames:  []string{host},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("cannot marshal key: %s", err)
+	}
+	certData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyData := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certData, keyData
+}
+
+func TestServerMultipartFormDataRequest(t *testing.T) {
+	reqS := `POST /upload HTTP/1.1
+Host: qwerty.com
+Content-Length: 521
+Content-Type: multipart/form-data; boundary=----WebKitFormBoundaryJwfATyF8tmxSJnLg
+
+------WebKitFormBoundaryJwfATyF8tmxSJnLg
+Content-Disposition: form-data; name="f1"
+
+value1
+------WebKitFormBoundaryJwfATyF8tmxSJnLg
+Content-Disposition: form-data; name="fileaaa"; filename="TODO"
+Content-Type: application/octet-stream
+
+- SessionClient with referer and cookies support.
+- Client with requests' pipelining support.
+- ProxyHandler similar to FSHandler.
+- WebSockets. See https://tools.ietf.org/html/rfc6455 .
+- HTTP/2.0. See https://tools.ietf.org/html/rfc7540 .
+
+------WebKitFormBoundaryJwfATyF8tmxSJnLg--
+
+GET / HTTP/1.1
+Host: asbd
+Connection: close
+
+`
+
+	ln := fasthttputil.NewInmemoryListener()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/upload":
+				f, err := ctx.MultipartForm()
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if len(f.Value) != 1 {
+					t.Fatalf("unexpected values %d. Expecting %d", len(f.Value), 1)
+				}
+				if len(f.File) != 1 {
+					t.Fatalf("unexpected file values %d. Expecting %d", len(f.File), 1)
+				}
+				fv := ctx.FormValue("f1")
+				if string(fv) != "value1" {
+					t.Fatalf("unexpected form value: %q. Expecting %q", fv, "value1")
+				}
+				ctx.Redirect("/", StatusSeeOther)
+			default:
+				ctx.WriteString("non-upload")
+			}
+		},
+	}
+
+	ch := make(chan struct{})
+	go func() {
+		if err := s.Serve(ln); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		close(ch)
+	}()
+
+	conn, err := ln.Dial()
+	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
 	if _, err = conn.Write([]byte(reqS)); err != nil {
@@ -1159,6 +1789,28 @@ func TestRequestCtxFormValue(t *testing.T) {
 	}
 }
 
+func TestRequestCtxFormValues(t *testing.T) {
+	var ctx RequestCtx
+	var req Request
+	req.SetRequestURI("/foo/bar?tags=a&baz=123&tags=b")
+	req.SetBodyString("tags=c&mmm=sddd&tags=d")
+	req.Header.SetContentType("application/x-www-form-urlencoded")
+
+	ctx.Init(&req, nil, nil)
+
+	vv := ctx.FormValues("tags")
+	expectedVV := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	if !reflect.DeepEqual(vv, expectedVV) {
+		t.Fatalf("unexpected values %q. Expecting %q", vv, expectedVV)
+	}
+	if v := ctx.FormValue("tags"); string(v) != "a" {
+		t.Fatalf("unexpected value %q. Expecting %q", v, "a")
+	}
+	if vv = ctx.FormValues("aaaasdfsdf"); len(vv) > 0 {
+		t.Fatalf("unexpected values for unknown key %q", vv)
+	}
+}
+
 func TestRequestCtxUserValue(t *testing.T) {
 	var ctx RequestCtx
 
@@ -1294,57 +1946,285 @@ func TestServerExpect100Continue(t *testing.T) {
 	}
 }
 
-func TestCompressHandler(t *testing.T) {
-	expectedBody := string(createFixedBody(2e4))
-	h := CompressHandler(func(ctx *RequestCtx) {
-		ctx.Write([]byte(expectedBody))
-	})
+func TestServerExpect100ContinueStreamRequestBodyNoBody(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			body, err := ioutil.ReadAll(ctx.RequestBodyStream())
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if len(body) > 0 {
+				t.Errorf("unexpected body: %q. Expecting empty body", body)
+			}
+			ctx.WriteString("foobar")
+		},
+		StreamRequestBody: true,
+	}
 
-	var ctx RequestCtx
-	var resp Response
+	rw := &readWriter{}
+	rw.r.WriteString("POST /foo HTTP/1.1\r\nHost: gle.com\r\nExpect: 100-continue\r\n\r\n")
 
-	// verify uncompressed response
-	h(&ctx)
-	s := ctx.Response.String()
-	br := bufio.NewReader(bytes.NewBufferString(s))
-	if err := resp.Read(br); err != nil {
-		t.Fatalf("unexpected error: %s", err)
-	}
-	ce := resp.Header.Peek("Content-Encoding")
-	if string(ce) != "" {
-		t.Fatalf("unexpected Content-Encoding: %q. Expecting %q", ce, "")
+	ch := make(chan error)
+	go func() {
+		ch <- s.ServeConn(rw)
+	}()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("Unexpected error from serveConn: %s", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("timeout")
 	}
-	body := resp.Body()
-	if string(body) != expectedBody {
-		t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "foobar")
+}
+
+func TestServerContinueHandler(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.PostBody())
+		},
+		ContinueHandler: func(header *RequestHeader) bool {
+			return header.ContentLength() <= 5
+		},
 	}
 
-	// verify gzip-compressed response
-	ctx.Request.Reset()
-	ctx.Response.Reset()
-	ctx.Request.Header.Set("Accept-Encoding", "gzip, deflate, sdhc")
+	// Accepted request.
+	rw := &readWriter{}
+	rw.r.WriteString("POST /foo HTTP/1.1\r\nHost: gle.com\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n12345")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(&rw.w)
+	if !strings.HasPrefix(rw.w.String(), "HTTP/1.1 100 Continue\r\n\r\n") {
+		t.Fatalf("missing '100 Continue' response in %q", rw.w.String())
+	}
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "12345")
 
-	h(&ctx)
-	s = ctx.Response.String()
-	br = bufio.NewReader(bytes.NewBufferString(s))
-	if err := resp.Read(br); err != nil {
+	// Rejected request.
+	rw = &readWriter{}
+	rw.r.WriteString("POST /foo HTTP/1.1\r\nHost: gle.com\r\nExpect: 100-continue\r\nContent-Length: 10\r\n\r\n0123456789")
+	if err := s.ServeConn(rw); err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
-	ce = resp.Header.Peek("Content-Encoding")
-	if string(ce) != "gzip" {
-		t.Fatalf("unexpected Content-Encoding: %q. Expecting %q", ce, "gzip")
+	if strings.Contains(rw.w.String(), "100 Continue") {
+		t.Fatalf("unexpected '100 Continue' response in %q", rw.w.String())
 	}
-	body, err := resp.BodyGunzip()
-	if err != nil {
+	var resp Response
+	if err := resp.Read(bufio.NewReader(&rw.w)); err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
-	if string(body) != expectedBody {
-		t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+	if resp.StatusCode() != StatusExpectationFailed {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusExpectationFailed)
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("missing 'Connection: close' response header")
 	}
+}
 
-	// an attempt to compress already compressed response
-	ctx.Request.Reset()
-	ctx.Response.Reset()
+func TestRequestCtxWriteChunk(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) != "/chunked" {
+				ctx.WriteString("ok")
+				return
+			}
+			if err := ctx.Response.Header.AddTrailer("X-Checksum"); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			ctx.WriteString("foo")
+			for _, s := range []string{"bar", "", "baz"} {
+				if err := ctx.WriteChunk([]byte(s)); err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}
+			ctx.WriteString("end")
+			ctx.Response.Header.Set("X-Checksum", "abc")
+		},
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("GET /chunked HTTP/1.1\r\nHost: aaa\r\n\r\nGET / HTTP/1.1\r\nHost: aaa\r\n\r\n")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedBody := "3\r\nfoo\r\n3\r\nbar\r\n3\r\nbaz\r\n3\r\nend\r\n0\r\nX-Checksum: abc\r\n\r\n"
+	if !strings.Contains(rw.w.String(), "\r\n\r\n"+expectedBody) {
+		t.Fatalf("missing chunked body %q in %q", expectedBody, rw.w.String())
+	}
+	br := bufio.NewReader(&rw.w)
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "foobarbazend" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "foobarbazend")
+	}
+	if v := string(resp.Header.Peek("X-Checksum")); v != "abc" {
+		t.Fatalf("unexpected trailer value %q. Expecting %q", v, "abc")
+	}
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "ok")
+
+	// The body is buffered for HTTP/1.0 requests.
+	rw = &readWriter{}
+	rw.r.WriteString("GET /chunked HTTP/1.0\r\nHost: aaa\r\n\r\n")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br = bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "foobarbazend")
+
+	// The body is buffered for RequestCtx initialized via Init.
+	var ctx RequestCtx
+	var req Request
+	req.SetRequestURI("http://aaa/chunked")
+	ctx.Init(&req, nil, nil)
+	s.Handler(&ctx)
+	if string(ctx.Response.Body()) != "foobarbazend" {
+		t.Fatalf("unexpected body %q. Expecting %q", ctx.Response.Body(), "foobarbazend")
+	}
+}
+
+func TestRequestCtxWriteChunkStreaming(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	ch := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if err := ctx.WriteChunk([]byte("foo")); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			// The chunk must be sent before returning from the handler.
+			<-ch
+			if err := ctx.WriteChunk([]byte("bar")); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		},
+	}
+	go s.Serve(ln) //nolint:errcheck
+	defer ln.Close()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(c)
+	var h ResponseHeader
+	if err = h.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if h.ContentLength() != -1 {
+		t.Fatalf("unexpected content length %d. Expecting %d", h.ContentLength(), -1)
+	}
+	chunk := make([]byte, len("3\r\nfoo\r\n"))
+	if _, err = io.ReadFull(br, chunk); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(chunk) != "3\r\nfoo\r\n" {
+		t.Fatalf("unexpected chunk %q. Expecting %q", chunk, "3\r\nfoo\r\n")
+	}
+	close(ch)
+	rest, err := br.ReadString('0')
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rest != "3\r\nbar\r\n0" {
+		t.Fatalf("unexpected rest of the body %q. Expecting %q", rest, "3\r\nbar\r\n0")
+	}
+}
+
+func TestRequestCtxWriteChunkCompressHandler(t *testing.T) {
+	body := strings.Repeat("foobar", 50)
+	h := func(ctx *RequestCtx) {
+		if err := ctx.WriteChunk([]byte(body)); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		ctx.WriteString(body)
+	}
+	handlers := map[string]RequestHandler{
+		"CompressHandler":            CompressHandler(h),
+		"CompressHandlerBrotliLevel": CompressHandlerBrotliLevel(h, CompressBrotliDefaultCompression, CompressDefaultCompression),
+		"CompressHandlerConfig":      CompressHandlerConfig(h, CompressConfig{EnableBrotli: true}),
+	}
+	for name, handler := range handlers {
+		s := &Server{
+			Handler: handler,
+		}
+		rw := &readWriter{}
+		rw.r.WriteString("GET / HTTP/1.1\r\nHost: aaa\r\nAccept-Encoding: br, gzip, deflate\r\n\r\n")
+		if err := s.ServeConn(rw); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		var resp Response
+		if err := resp.Read(bufio.NewReader(&rw.w)); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		if ce := resp.Header.Peek("Content-Encoding"); len(ce) > 0 {
+			t.Fatalf("%s: unexpected Content-Encoding %q for chunked response", name, ce)
+		}
+		if string(resp.Body()) != body+body {
+			t.Fatalf("%s: unexpected body %q. Expecting %q", name, resp.Body(), body+body)
+		}
+	}
+}
+
+func TestCompressHandler(t *testing.T) {
+	expectedBody := string(createFixedBody(2e4))
+	h := CompressHandler(func(ctx *RequestCtx) {
+		ctx.Write([]byte(expectedBody))
+	})
+
+	var ctx RequestCtx
+	var resp Response
+
+	// verify uncompressed response
+	h(&ctx)
+	s := ctx.Response.String()
+	br := bufio.NewReader(bytes.NewBufferString(s))
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ce := resp.Header.Peek("Content-Encoding")
+	if string(ce) != "" {
+		t.Fatalf("unexpected Content-Encoding: %q. Expecting %q", ce, "")
+	}
+	body := resp.Body()
+	if string(body) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+	}
+
+	// verify gzip-compressed response
+	ctx.Request.Reset()
+	ctx.Response.Reset()
+	ctx.Request.Header.Set("Accept-Encoding", "gzip, deflate, sdhc")
+
+	h(&ctx)
+	s = ctx.Response.String()
+	br = bufio.NewReader(bytes.NewBufferString(s))
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ce = resp.Header.Peek("Content-Encoding")
+	if string(ce) != "gzip" {
+		t.Fatalf("unexpected Content-Encoding: %q. Expecting %q", ce, "gzip")
+	}
+	body, err := resp.BodyGunzip()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+	}
+
+	// an attempt to compress already compressed response
+	ctx.Request.Reset()
+	ctx.Response.Reset()
 	ctx.Request.Header.Set("Accept-Encoding", "gzip, deflate, sdhc")
 	hh := CompressHandler(h)
 	hh(&ctx)
@@ -1389,6 +2269,188 @@ func TestCompressHandler(t *testing.T) {
 	}
 }
 
+func TestCompressHandlerBrotliLevel(t *testing.T) {
+	expectedBody := string(createFixedBody(2e4))
+	h := CompressHandlerBrotliLevel(func(ctx *RequestCtx) {
+		ctx.Write([]byte(expectedBody))
+	}, CompressBrotliBestSpeed, CompressBestSpeed)
+
+	testCompressHandlerBrotliLevel(t, h, "", "", expectedBody)
+	testCompressHandlerBrotliLevel(t, h, "gzip, deflate, br", "br", expectedBody)
+	testCompressHandlerBrotliLevel(t, h, "gzip, deflate, sdhc", "gzip", expectedBody)
+	testCompressHandlerBrotliLevel(t, h, "foobar, deflate", "deflate", expectedBody)
+	testCompressHandlerBrotliLevel(t, h, "brotli", "", expectedBody)
+
+	// an attempt to compress already compressed response
+	testCompressHandlerBrotliLevel(t, CompressHandlerBrotliLevel(h, CompressBrotliBestSpeed, CompressBestSpeed),
+		"br", "br", expectedBody)
+}
+
+func testCompressHandlerBrotliLevel(t *testing.T, h RequestHandler, acceptEncoding, expectedContentEncoding, expectedBody string) {
+	var ctx RequestCtx
+	var resp Response
+
+	if acceptEncoding != "" {
+		ctx.Request.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	h(&ctx)
+	s := ctx.Response.String()
+	br := bufio.NewReader(bytes.NewBufferString(s))
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ce := resp.Header.Peek("Content-Encoding")
+	if string(ce) != expectedContentEncoding {
+		t.Fatalf("unexpected Content-Encoding: %q. Expecting %q", ce, expectedContentEncoding)
+	}
+	body, err := resp.BodyUncompressed()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+	}
+}
+
+func TestCompressHandlerConfig(t *testing.T) {
+	expectedBody := string(createFixedBody(2e4))
+	h := CompressHandlerConfig(func(ctx *RequestCtx) {
+		ctx.SetContentType(string(ctx.QueryArgs().Peek("ct")))
+		ctx.Write([]byte(expectedBody[:ctx.QueryArgs().GetUintOrZero("size")]))
+		if ctx.QueryArgs().Has("no-transform") {
+			ctx.Response.Header.Set("Cache-Control", "no-transform")
+		}
+		if ctx.QueryArgs().Has("vary") {
+			ctx.Response.Header.Set("Vary", "Cookie")
+		}
+		if ctx.QueryArgs().Has("partial") {
+			ctx.SetStatusCode(StatusPartialContent)
+		}
+		if ctx.QueryArgs().Has("range") {
+			ctx.Response.Header.SetContentRange(0, 1999, 4000)
+		}
+	}, CompressConfig{
+		Level:        CompressBestSpeed,
+		MinSize:      1000,
+		ContentTypes: []string{"text/", "application/json", "image/svg+xml"},
+	})
+
+	testCompressHandlerConfig(t, h, "/?ct=text/html&size=2000", "gzip, deflate", "gzip", "Accept-Encoding", expectedBody[:2000])
+	testCompressHandlerConfig(t, h, "/?ct=application/json;+charset=utf-8&size=2000", "deflate", "deflate", "Accept-Encoding", expectedBody[:2000])
+	testCompressHandlerConfig(t, h, "/?ct=IMAGE/SVG%2BXML&size=2000", "gzip", "gzip", "Accept-Encoding", expectedBody[:2000])
+
+	// Vary must be set for compressible responses even if the client
+	// doesn't support compression.
+	testCompressHandlerConfig(t, h, "/?ct=text/html&size=2000", "", "", "Accept-Encoding", expectedBody[:2000])
+	testCompressHandlerConfig(t, h, "/?ct=text/html&size=2000&vary", "gzip", "gzip", "Cookie, Accept-Encoding", expectedBody[:2000])
+
+	// Quality values must be respected.
+	testCompressHandlerConfig(t, h, "/?ct=text/html&size=2000", "gzip;q=0, deflate", "deflate", "Accept-Encoding", expectedBody[:2000])
+	testCompressHandlerConfig(t, h, "/?ct=text/html&size=2000", "gzip; q=0.5, deflate;q=0.8", "deflate", "Accept-Encoding", expectedBody[:2000])
+	testCompressHandlerConfig(t, h, "/?ct=text/html&size=2000", "*", "gzip", "Accept-Encoding", expectedBody[:2000])
+	testCompressHandlerConfig(t, h, "/?ct=text/html&size=2000", "*;q=0", "", "Accept-Encoding", expectedBody[:2000])
+
+	// Small bodies, disallowed content types and no-transform responses
+	// mustn't be compressed.
+	testCompressHandlerConfig(t, h, "/?ct=text/html&size=999", "gzip", "", "", expectedBody[:999])
+	testCompressHandlerConfig(t, h, "/?ct=application/octet-stream&size=2000", "gzip", "", "", expectedBody[:2000])
+	testCompressHandlerConfig(t, h, "/?ct=text/html&size=2000&no-transform", "gzip", "", "", expectedBody[:2000])
+
+	// Partial responses mustn't be compressed.
+	testCompressHandlerConfig(t, h, "/?ct=text/html&size=2000&partial", "gzip", "", "", expectedBody[:2000])
+	testCompressHandlerConfig(t, h, "/?ct=text/html&size=2000&range", "gzip", "", "", expectedBody[:2000])
+
+	// Already compressed content types mustn't be compressed by default.
+	hd := CompressHandlerConfig(func(ctx *RequestCtx) {
+		ctx.SetContentType(string(ctx.QueryArgs().Peek("ct")))
+		ctx.WriteString(expectedBody)
+	}, CompressConfig{})
+	testCompressHandlerConfig(t, hd, "/?ct=application/json", "gzip", "gzip", "Accept-Encoding", expectedBody)
+	testCompressHandlerConfig(t, hd, "/?ct=image/svg%2Bxml", "gzip", "gzip", "Accept-Encoding", expectedBody)
+	testCompressHandlerConfig(t, hd, "/?ct=application/zip", "gzip", "", "", expectedBody)
+	testCompressHandlerConfig(t, hd, "/?ct=application/pdf", "gzip", "", "", expectedBody)
+
+	// an attempt to compress already compressed response
+	hh := CompressHandlerConfig(h, CompressConfig{})
+	testCompressHandlerConfig(t, hh, "/?ct=text/html&size=2000", "gzip", "gzip", "Accept-Encoding", expectedBody[:2000])
+}
+
+func testCompressHandlerConfig(t *testing.T, h RequestHandler, uri, acceptEncoding, expectedContentEncoding, expectedVary, expectedBody string) {
+	t.Helper()
+	var ctx RequestCtx
+	var resp Response
+
+	ctx.Request.SetRequestURI(uri)
+	if acceptEncoding != "" {
+		ctx.Request.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	h(&ctx)
+	s := ctx.Response.String()
+	br := bufio.NewReader(bytes.NewBufferString(s))
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ce := resp.Header.Peek("Content-Encoding")
+	if string(ce) != expectedContentEncoding {
+		t.Fatalf("unexpected Content-Encoding for %q: %q. Expecting %q", uri, ce, expectedContentEncoding)
+	}
+	vary := resp.Header.Peek("Vary")
+	if !strings.HasSuffix(string(vary), expectedVary) {
+		t.Fatalf("unexpected Vary for %q: %q. Expecting %q", uri, vary, expectedVary)
+	}
+	body, err := resp.BodyUncompressed()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+	}
+}
+
+func TestCompressHandlerConfigBrotli(t *testing.T) {
+	expectedBody := string(createFixedBody(2e4))
+	h := CompressHandlerConfig(func(ctx *RequestCtx) {
+		if ctx.QueryArgs().Has("stream") {
+			ctx.SetBodyStream(strings.NewReader(expectedBody), -1)
+			return
+		}
+		ctx.WriteString(expectedBody)
+	}, CompressConfig{
+		EnableBrotli: true,
+		BrotliLevel:  CompressBrotliBestCompression,
+	})
+
+	testCompressHandlerConfig(t, h, "/", "gzip, deflate, br", "br", "Accept-Encoding", expectedBody)
+	testCompressHandlerConfig(t, h, "/", "br", "br", "Accept-Encoding", expectedBody)
+	testCompressHandlerConfig(t, h, "/", "*", "br", "Accept-Encoding", expectedBody)
+	testCompressHandlerConfig(t, h, "/?stream", "br", "br", "Accept-Encoding", expectedBody)
+	testCompressHandlerConfig(t, h, "/", "br;q=0.5, gzip", "gzip", "Accept-Encoding", expectedBody)
+	testCompressHandlerConfig(t, h, "/", "br;q=0, deflate", "deflate", "Accept-Encoding", expectedBody)
+	testCompressHandlerConfig(t, h, "/", "brotli", "", "Accept-Encoding", expectedBody)
+
+	// br mustn't be used unless enabled.
+	h = CompressHandlerConfig(func(ctx *RequestCtx) {
+		ctx.WriteString(expectedBody)
+	}, CompressConfig{})
+	testCompressHandlerConfig(t, h, "/", "br", "", "Accept-Encoding", expectedBody)
+	testCompressHandlerConfig(t, h, "/", "br, gzip", "gzip", "Accept-Encoding", expectedBody)
+}
+
+func TestCompressHandlerConfigStream(t *testing.T) {
+	expectedBody := string(createFixedBody(2e4))
+	h := CompressHandlerConfig(func(ctx *RequestCtx) {
+		size := ctx.QueryArgs().GetUintOrZero("size")
+		ctx.SetBodyStream(strings.NewReader(expectedBody[:size]), ctx.QueryArgs().GetUintOrZero("cl")-1)
+	}, CompressConfig{
+		MinSize: 1000,
+	})
+
+	// Streams without Content-Length must be compressed.
+	testCompressHandlerConfig(t, h, "/?size=10", "gzip", "gzip", "Accept-Encoding", expectedBody[:10])
+	testCompressHandlerConfig(t, h, "/?size=2000&cl=2001", "gzip", "gzip", "Accept-Encoding", expectedBody[:2000])
+	testCompressHandlerConfig(t, h, "/?size=10&cl=11", "gzip", "", "", expectedBody[:10])
+}
+
 func TestRequestCtxWriteString(t *testing.T) {
 	var ctx RequestCtx
 	n, err := ctx.WriteString("foo")
@@ -1725,73 +2787,264 @@ func TestRequestCtxHijack(t *testing.T) {
 	}
 }
 
-func TestRequestCtxInit(t *testing.T) {
-	var ctx RequestCtx
-	var logger customLogger
-	globalConnID = 0x123456
-	ctx.Init(&ctx.Request, zeroTCPAddr, &logger)
-	ip := ctx.RemoteIP()
-	if !ip.IsUnspecified() {
-		t.Fatalf("unexpected ip for bare RequestCtx: %q. Expected 0.0.0.0", ip)
-	}
-	ctx.Logger().Printf("foo bar %d", 10)
+type closeCountingConn struct {
+	readWriter
+	closeCalls int32
+}
 
-	expectedLog := "#0012345700000000 - 0.0.0.0:0<->0.0.0.0:0 - GET http:/// - foo bar 10\n"
-	if logger.out != expectedLog {
-		t.Fatalf("Unexpected log output: %q. Expected %q", logger.out, expectedLog)
-	}
+func (c *closeCountingConn) Close() error {
+	atomic.AddInt32(&c.closeCalls, 1)
+	return nil
 }
 
-func TestTimeoutHandlerSuccess(t *testing.T) {
-	ln := fasthttputil.NewInmemoryListener()
-	h := func(ctx *RequestCtx) {
-		if string(ctx.Path()) == "/" {
-			ctx.Success("aaa/bbb", []byte("real response"))
-		}
+func TestRequestCtxHijackRaw(t *testing.T) {
+	type hijackResult struct {
+		c        net.Conn
+		buffered string
 	}
+	resultCh := make(chan hijackResult, 1)
 	s := &Server{
-		Handler: TimeoutHandler(h, 10*time.Second, "timeout!!!"),
-	}
-	serverCh := make(chan struct{})
-	go func() {
-		if err := s.Serve(ln); err != nil {
-			t.Fatalf("unexepcted error: %s", err)
-		}
-		close(serverCh)
-	}()
-
-	concurrency := 20
-	clientCh := make(chan struct{}, concurrency)
-	for i := 0; i < concurrency; i++ {
-		go func() {
-			conn, err := ln.Dial()
-			if err != nil {
-				t.Fatalf("unexepcted error: %s", err)
-			}
-			if _, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: google.com\r\n\r\n")); err != nil {
-				t.Fatalf("unexpected error: %s", err)
+		Handler: func(ctx *RequestCtx) {
+			ctx.HijackRaw(func(c net.Conn, buffered []byte) {
+				resultCh <- hijackResult{
+					c:        c,
+					buffered: string(buffered),
+				}
+			})
+			if !ctx.Hijacked() {
+				t.Fatalf("connection must be hijacked")
 			}
-			br := bufio.NewReader(conn)
-			verifyResponse(t, br, StatusOK, "aaa/bbb", "real response")
-			clientCh <- struct{}{}
-		}()
-	}
-
-	for i := 0; i < concurrency; i++ {
-		select {
-		case <-clientCh:
-		case <-time.After(time.Second):
-			t.Fatalf("timeout")
-		}
+		},
 	}
 
-	if err := ln.Close(); err != nil {
+	hijackedString := "foobar baz hijacked!!!"
+	c := &closeCountingConn{}
+	c.r.WriteString("GET /foo HTTP/1.1\r\nHost: google.com\r\n\r\n")
+	c.r.WriteString(hijackedString)
+	if err := s.ServeConn(c); err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
+	verifyResponse(t, bufio.NewReader(&c.w), StatusOK, string(defaultContentType), "")
 
 	select {
-	case <-serverCh:
-	case <-time.After(time.Second):
+	case r := <-resultCh:
+		if r.c != net.Conn(c) {
+			t.Fatalf("unexpected hijacked connection %T. Expecting the underlying connection", r.c)
+		}
+		if r.buffered != hijackedString {
+			t.Fatalf("unexpected buffered data %q. Expecting %q", r.buffered, hijackedString)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestServerKeepHijackedConns(t *testing.T) {
+	hijackedCh := make(chan net.Conn, 1)
+	mc := &testServerMetricsCollector{}
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Hijack(func(c net.Conn) {
+				hijackedCh <- c
+			})
+		},
+		KeepHijackedConns: true,
+		MetricsCollector:  mc,
+	}
+
+	hijackedString := "foobar baz hijacked!!!"
+	c := &closeCountingConn{}
+	c.r.WriteString("GET /foo HTTP/1.1\r\nHost: google.com\r\n\r\n")
+	c.r.WriteString(hijackedString)
+	if err := s.ServeConn(c); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var hjc net.Conn
+	select {
+	case hjc = <-hijackedCh:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		events := mc.Events()
+		if len(events) > 0 && events[len(events)-1] == "closed hijacked=true" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when waiting for hijack handler completion")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&c.closeCalls); n != 0 {
+		t.Fatalf("hijacked connection mustn't be closed by the server. Close calls: %d", n)
+	}
+
+	// The connection remains usable after the hijack handler returns.
+	data, err := ioutil.ReadAll(hjc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(data) != hijackedString {
+		t.Fatalf("unexpected data read from hijacked connection %q. Expecting %q", data, hijackedString)
+	}
+	if err = hjc.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := atomic.LoadInt32(&c.closeCalls); n != 1 {
+		t.Fatalf("unexpected number of Close calls: %d. Expecting 1", n)
+	}
+}
+
+func TestServerConnState(t *testing.T) {
+	var mu sync.Mutex
+	var states []string
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/hijack" {
+				ctx.Hijack(func(c net.Conn) {})
+			}
+		},
+		ConnState: func(c net.Conn, state ConnState) {
+			mu.Lock()
+			states = append(states, state.String())
+			mu.Unlock()
+		},
+	}
+	serverCh := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			serverCh <- err
+			return
+		}
+		serverCh <- s.ServeConn(c)
+	}()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(c)
+	var resp Response
+	for _, path := range []string{"/foo", "/bar", "/hijack"} {
+		if _, err = c.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err = resp.Read(br); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	select {
+	case err = <-serverCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+	c.Close()
+
+	mu.Lock()
+	got := strings.Join(states, ",")
+	mu.Unlock()
+	expected := "new,active,idle,active,idle,active,hijacked"
+	if got != expected {
+		t.Fatalf("unexpected connection states %q. Expecting %q", got, expected)
+	}
+}
+
+func TestServerConnStateClosed(t *testing.T) {
+	var states []ConnState
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {},
+		ConnState: func(c net.Conn, state ConnState) {
+			states = append(states, state)
+		},
+	}
+
+	// Pipelined requests are served without idle state between them.
+	rw := &readWriter{}
+	rw.r.WriteString("GET /foo HTTP/1.1\r\nHost: aaa\r\n\r\nGET /bar HTTP/1.1\r\nHost: aaa\r\n\r\n")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []ConnState{StateNew, StateActive, StateIdle, StateClosed}
+	if !reflect.DeepEqual(states, expected) {
+		t.Fatalf("unexpected connection states %v. Expecting %v", states, expected)
+	}
+}
+
+func TestRequestCtxInit(t *testing.T) {
+	var ctx RequestCtx
+	var logger customLogger
+	globalConnID = 0x123456
+	ctx.Init(&ctx.Request, zeroTCPAddr, &logger)
+	ip := ctx.RemoteIP()
+	if !ip.IsUnspecified() {
+		t.Fatalf("unexpected ip for bare RequestCtx: %q. Expected 0.0.0.0", ip)
+	}
+	ctx.Logger().Printf("foo bar %d", 10)
+
+	expectedLog := "#0012345700000000 - 0.0.0.0:0<->0.0.0.0:0 - GET http:/// - foo bar 10\n"
+	if logger.out != expectedLog {
+		t.Fatalf("Unexpected log output: %q. Expected %q", logger.out, expectedLog)
+	}
+}
+
+func TestTimeoutHandlerSuccess(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	h := func(ctx *RequestCtx) {
+		if string(ctx.Path()) == "/" {
+			ctx.Success("aaa/bbb", []byte("real response"))
+		}
+	}
+	s := &Server{
+		Handler: TimeoutHandler(h, 10*time.Second, "timeout!!!"),
+	}
+	serverCh := make(chan struct{})
+	go func() {
+		if err := s.Serve(ln); err != nil {
+			t.Fatalf("unexepcted error: %s", err)
+		}
+		close(serverCh)
+	}()
+
+	concurrency := 20
+	clientCh := make(chan struct{}, concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			conn, err := ln.Dial()
+			if err != nil {
+				t.Fatalf("unexepcted error: %s", err)
+			}
+			if _, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: google.com\r\n\r\n")); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			br := bufio.NewReader(conn)
+			verifyResponse(t, br, StatusOK, "aaa/bbb", "real response")
+			clientCh <- struct{}{}
+		}()
+	}
+
+	for i := 0; i < concurrency; i++ {
+		select {
+		case <-clientCh:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	select {
+	case <-serverCh:
+	case <-time.After(time.Second):
 		t.Fatalf("timeout")
 	}
 }
@@ -2085,6 +3338,219 @@ func TestServerMaxKeepaliveDuration(t *testing.T) {
 	}
 }
 
+func TestServerIdleTimeout(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("OK")
+		},
+		ReadTimeout: 50 * time.Millisecond,
+		IdleTimeout: 300 * time.Millisecond,
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln)
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for i := 0; i < 2; i++ {
+		// The connection must stay open while idle for longer than ReadTimeout.
+		if i > 0 {
+			time.Sleep(150 * time.Millisecond)
+		}
+		if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		verifyResponse(t, br, StatusOK, string(defaultContentType), "OK")
+	}
+
+	// The connection must be closed after IdleTimeout.
+	start := time.Now()
+	if _, err = br.ReadByte(); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond || d > time.Second {
+		t.Fatalf("unexpected idle connection lifetime: %s. Expecting ~300ms", d)
+	}
+}
+
+func TestServerIdleTimeoutSlowRequest(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("OK")
+		},
+		ReadTimeout: 300 * time.Millisecond,
+		IdleTimeout: 50 * time.Millisecond,
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln)
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "OK")
+	for i := 0; i < 2; i++ {
+		// ReadTimeout applies to the next request after its' first byte
+		// is received.
+		if _, err = c.Write([]byte("GET / HTTP/1.1\r\n")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		time.Sleep(150 * time.Millisecond)
+		if _, err = c.Write([]byte("Host: aa\r\n\r\n")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		verifyResponse(t, br, StatusOK, string(defaultContentType), "OK")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err = br.ReadByte(); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+}
+
+type deadlineConn struct {
+	readWriter
+	readDeadlines  []time.Time
+	writeDeadlines []time.Time
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.readDeadlines = append(c.readDeadlines, t)
+	return nil
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadlines = append(c.writeDeadlines, t)
+	return nil
+}
+
+// closedAfterIdleConn emulates the connection closed by Shutdown
+// after the next request is started on the idle connection.
+type closedAfterIdleConn struct {
+	readWriter
+	chunks []string
+}
+
+func (c *closedAfterIdleConn) Read(b []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, c.chunks[0])
+	c.chunks = c.chunks[1:]
+	return n, nil
+}
+
+func (c *closedAfterIdleConn) SetReadDeadline(t time.Time) error {
+	if t.IsZero() {
+		return net.ErrClosed
+	}
+	return nil
+}
+
+func TestServerIdleConnClosedOnNextRequest(t *testing.T) {
+	var requests int
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			requests++
+		},
+		IdleTimeout: time.Hour,
+	}
+
+	c := &closedAfterIdleConn{
+		chunks: []string{
+			"GET /foo HTTP/1.1\r\nHost: aa\r\n\r\n",
+			"GET /bar HTTP/1.1\r\nHost: aa\r\n\r\n",
+		},
+	}
+	if err := s.ServeConn(c); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if requests != 1 {
+		t.Fatalf("unexpected number of served requests: %d. Expecting 1", requests)
+	}
+	br := bufio.NewReader(&c.w)
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "")
+}
+
+func TestRequestCtxSetDeadline(t *testing.T) {
+	testRequestCtxSetDeadline(t, 0)
+	testRequestCtxSetDeadline(t, time.Hour)
+}
+
+func testRequestCtxSetDeadline(t *testing.T, timeout time.Duration) {
+	readDeadline := time.Now().Add(10 * time.Hour)
+	writeDeadline := time.Now().Add(20 * time.Hour)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) != "/extend" {
+				return
+			}
+			if err := ctx.SetReadDeadline(readDeadline); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err := ctx.SetWriteDeadline(writeDeadline); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		},
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	}
+
+	c := &deadlineConn{}
+	c.r.WriteString("GET /extend HTTP/1.1\r\nHost: aa\r\n\r\n")
+	c.r.WriteString("GET /foo HTTP/1.1\r\nHost: aa\r\n\r\n")
+	if err := s.ServeConn(c); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(&c.w)
+	for i := 0; i < 2; i++ {
+		verifyResponse(t, br, StatusOK, string(defaultContentType), "")
+	}
+
+	// Server timeouts must be restored for the next request.
+	expectDeadlines := func(deadlines []time.Time, handlerDeadline time.Time, hasInitialDeadline bool) {
+		t.Helper()
+		if hasInitialDeadline {
+			// Skip the deadline set by the server for the first request.
+			if len(deadlines) == 0 || time.Until(deadlines[0]) > timeout {
+				t.Fatalf("unexpected deadlines %v", deadlines)
+			}
+			deadlines = deadlines[1:]
+		}
+		if len(deadlines) != 2 || !deadlines[0].Equal(handlerDeadline) {
+			t.Fatalf("unexpected deadlines %v. Expecting [%s, <server deadline>]", deadlines, handlerDeadline)
+		}
+		if timeout > 0 && !deadlines[1].Before(handlerDeadline) || timeout == 0 && !deadlines[1].IsZero() {
+			t.Fatalf("server deadline isn't restored: %v", deadlines)
+		}
+	}
+	expectDeadlines(c.readDeadlines, readDeadline, timeout > 0)
+	// Write deadline isn't set by the server before the request handler
+	// is called.
+	expectDeadlines(c.writeDeadlines, writeDeadline, false)
+
+	// Deadlines cannot be changed for requests not bound to connection.
+	var ctx RequestCtx
+	var req Request
+	ctx.Init(&req, nil, nil)
+	if err := ctx.SetReadDeadline(readDeadline); err != ErrDeadlineNotSupported {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrDeadlineNotSupported)
+	}
+	if err := ctx.SetWriteDeadline(writeDeadline); err != ErrDeadlineNotSupported {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrDeadlineNotSupported)
+	}
+}
+
 func TestServerMaxRequestsPerConn(t *testing.T) {
 	s := &Server{
 		Handler:            func(ctx *RequestCtx) {},
@@ -2522,3 +3988,584 @@ func (rw *readWriter) SetReadDeadline(t time.Time) error {
 func (rw *readWriter) SetWriteDeadline(t time.Time) error {
 	return nil
 }
+
+func TestServerShutdown(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	handlerStarted := make(chan struct{}, 1)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/slow" {
+				handlerStarted <- struct{}{}
+				time.Sleep(100 * time.Millisecond)
+			}
+			ctx.WriteString("ok")
+		},
+	}
+	serveCh := make(chan error, 1)
+	go func() {
+		serveCh <- s.Serve(ln)
+	}()
+
+	// Idle keep-alive connection must be closed by Shutdown.
+	idleConn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	idleBr := bufio.NewReader(idleConn)
+	testServerShutdownRequest(t, idleConn, idleBr, "/fast", false)
+
+	activeConn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = activeConn.Write([]byte("GET /slow HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	<-handlerStarted
+
+	shutdownCh := make(chan error, 1)
+	go func() {
+		shutdownCh <- s.Shutdown()
+	}()
+
+	select {
+	case err = <-serveCh:
+		if err != nil {
+			t.Fatalf("unexpected error returned from Serve: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout when waiting for Serve to return")
+	}
+
+	// The active request must be served with Connection: close.
+	var resp Response
+	if err = resp.Read(bufio.NewReader(activeConn)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("expecting Connection: close response header")
+	}
+
+	select {
+	case err = <-shutdownCh:
+		if err != nil {
+			t.Fatalf("unexpected error returned from Shutdown: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout when waiting for Shutdown to return")
+	}
+
+	if _, err = idleBr.ReadByte(); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting io.EOF for the idle connection", err)
+	}
+}
+
+func TestServerShutdownPartialRequest(t *testing.T) {
+	testServerShutdownPartialRequest(t, 0)
+	testServerShutdownPartialRequest(t, 1)
+}
+
+func testServerShutdownPartialRequest(t *testing.T, prevRequests int) {
+	ln := fasthttputil.NewInmemoryListener()
+	handlerCalled := make(chan struct{}, 1)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/upload" {
+				handlerCalled <- struct{}{}
+			}
+			ctx.Write(ctx.PostBody())
+		},
+	}
+	go s.Serve(ln) //nolint:errcheck
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for i := 0; i < prevRequests; i++ {
+		testServerShutdownRequest(t, c, br, "/fast", false)
+	}
+
+	// The connection reading the request mustn't be closed as idle.
+	if _, err = c.Write([]byte("POST /upload HTTP/1.1\r\nHost: aaa\r\nContent-Length: 6\r\n\r\nfoo")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	shutdownCh := make(chan error, 1)
+	go func() {
+		shutdownCh <- s.Shutdown()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err = c.Write([]byte("bar")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var resp Response
+	if err = resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-handlerCalled:
+	default:
+		t.Fatalf("the handler must be called")
+	}
+	if string(resp.Body()) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "foobar")
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("expecting Connection: close response header")
+	}
+	select {
+	case err = <-shutdownCh:
+		if err != nil {
+			t.Fatalf("unexpected error returned from Shutdown: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout when waiting for Shutdown to return")
+	}
+}
+
+func TestServerShutdownWithContext(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	handlerStarted := make(chan struct{}, 1)
+	var closedConns int
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			handlerStarted <- struct{}{}
+			time.Sleep(300 * time.Millisecond)
+		},
+		OnShutdownTimeout: func(n int) {
+			closedConns = n
+		},
+	}
+	go s.Serve(ln)
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	<-handlerStarted
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	startTime := time.Now()
+	if err = s.ShutdownWithContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(startTime); d > 250*time.Millisecond {
+		t.Fatalf("too long shutdown duration: %s", d)
+	}
+	if closedConns != 1 {
+		t.Fatalf("unexpected number of forcibly closed connections: %d. Expecting 1", closedConns)
+	}
+}
+
+func TestServerShutdownProgress(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	handlerStarted := make(chan struct{}, 1)
+	handlerDone := make(chan struct{})
+	type progress struct {
+		conns    int
+		requests int
+	}
+	progressCh := make(chan progress, 100)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			handlerStarted <- struct{}{}
+			<-handlerDone
+			ctx.WriteString("ok")
+		},
+		OnShutdownProgress: func(conns, requests int) {
+			progressCh <- progress{conns, requests}
+		},
+	}
+	go s.Serve(ln)
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	<-handlerStarted
+
+	shutdownCh := make(chan error, 1)
+	go func() {
+		shutdownCh <- s.Shutdown()
+	}()
+
+	select {
+	case p := <-progressCh:
+		if p.conns != 1 || p.requests != 1 {
+			t.Fatalf("unexpected shutdown progress %+v. Expecting 1 connection with 1 request", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout when waiting for shutdown progress")
+	}
+
+	close(handlerDone)
+	var resp Response
+	if err = resp.Read(bufio.NewReader(c)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("expecting Connection: close response header")
+	}
+
+	select {
+	case err = <-shutdownCh:
+		if err != nil {
+			t.Fatalf("unexpected error returned from Shutdown: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout when waiting for Shutdown to return")
+	}
+	close(progressCh)
+	var last progress
+	for p := range progressCh {
+		if p.requests > p.conns {
+			t.Fatalf("unexpected shutdown progress %+v. The number of requests cannot exceed the number of connections", p)
+		}
+		last = p
+	}
+	if last.conns != 0 || last.requests != 0 {
+		t.Fatalf("unexpected final shutdown progress %+v. Expecting zero connections and requests", last)
+	}
+}
+
+func TestServerShutdownProgressNoConns(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	calls := 0
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {},
+		OnShutdownProgress: func(conns, requests int) {
+			if conns != 0 || requests != 0 {
+				t.Errorf("unexpected shutdown progress: conns=%d, requests=%d. Expecting zeros", conns, requests)
+			}
+			calls++
+		},
+	}
+	serveCh := make(chan error, 1)
+	go func() {
+		serveCh <- s.Serve(ln)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls != 1 {
+		t.Fatalf("unexpected number of OnShutdownProgress calls: %d. Expecting 1", calls)
+	}
+	if err := <-serveCh; err != nil {
+		t.Fatalf("unexpected error returned from Serve: %s", err)
+	}
+}
+
+func testServerShutdownRequest(t *testing.T, c net.Conn, br *bufio.Reader, path string, connectionClose bool) {
+	if _, err := c.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.ConnectionClose() != connectionClose {
+		t.Fatalf("unexpected connection close %v. Expecting %v", resp.ConnectionClose(), connectionClose)
+	}
+}
+
+func TestServerStreamRequestBody(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		StreamRequestBody:  true,
+		MaxRequestBodySize: 100,
+		Handler: func(ctx *RequestCtx) {
+			if ctx.Request.bodyStream != nil && string(ctx.Path()) != "/post-body" && len(ctx.Request.bodyBytes()) > 0 {
+				t.Errorf("unexpected body read into memory")
+			}
+			switch string(ctx.Path()) {
+			case "/partial":
+				// The rest of the body must be discarded by the server.
+				buf := make([]byte, 3)
+				n, err := io.ReadFull(ctx.RequestBodyStream(), buf)
+				if err != nil {
+					ctx.Error(err.Error(), StatusBadRequest)
+					return
+				}
+				ctx.Write(buf[:n])
+			case "/post-body":
+				ctx.Write(ctx.PostBody())
+			default:
+				body, err := ioutil.ReadAll(ctx.RequestBodyStream())
+				if err != nil {
+					ctx.Error(err.Error(), StatusRequestEntityTooLarge)
+					return
+				}
+				ctx.Write(body)
+			}
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+
+	// Pipelined requests must be read after the streamed bodies.
+	if _, err = c.Write([]byte("POST /partial HTTP/1.1\r\nHost: aaa\r\nContent-Length: 10\r\n\r\n0123456789" +
+		"POST /chunked HTTP/1.1\r\nHost: aaa\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n4\r\ndefg\r\n0\r\n\r\n" +
+		"POST /post-body HTTP/1.1\r\nHost: aaa\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /get HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, expectedBody := range []string{"012", "abcdefg", "hello", ""} {
+		testServerStreamRequestBodyResponse(t, br, StatusOK, expectedBody, false)
+	}
+
+	// 'Expect: 100-continue' request.
+	if _, err = c.Write([]byte("POST /continue HTTP/1.1\r\nHost: aaa\r\nContent-Length: 3\r\nExpect: 100-continue\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testServerStreamRequestBodyResponse(t, br, StatusContinue, "", false)
+	if _, err = c.Write([]byte("xyz")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testServerStreamRequestBodyResponse(t, br, StatusOK, "xyz", false)
+
+	// Too large chunked body must break the connection.
+	chunk := strings.Repeat("x", 60)
+	if _, err = c.Write([]byte(fmt.Sprintf("POST /chunked HTTP/1.1\r\nHost: aaa\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"%x\r\n%s\r\n%x\r\n%s\r\n0\r\n\r\n", len(chunk), chunk, len(chunk), chunk))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testServerStreamRequestBodyResponse(t, br, StatusRequestEntityTooLarge, ErrBodyTooLarge.Error(), true)
+
+	// Too large Content-Length must be rejected before calling the handler
+	// like in non-streaming mode.
+	c, err = ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte("POST / HTTP/1.1\r\nHost: aaa\r\nContent-Length: 101\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var resp Response
+	if err = resp.Read(bufio.NewReader(c)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusBadRequest {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusBadRequest)
+	}
+}
+
+func testServerStreamRequestBodyResponse(t *testing.T, br *bufio.Reader, expectedStatusCode int, expectedBody string, connectionClose bool) {
+	t.Helper()
+	var resp Response
+	if expectedStatusCode == StatusContinue {
+		if err := resp.Header.Read(br); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	} else if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != expectedStatusCode {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), expectedStatusCode)
+	}
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	if resp.ConnectionClose() != connectionClose {
+		t.Fatalf("unexpected connection close %v. Expecting %v", resp.ConnectionClose(), connectionClose)
+	}
+}
+
+func TestRequestCtxContextDone(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	doneCh := make(chan (<-chan struct{}), 1)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if err := ctx.Err(); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			doneCh <- ctx.Done()
+			ctx.SetUserValue("foo", "bar")
+			if v := ctx.Value("foo"); v != "bar" {
+				t.Errorf("unexpected value %v. Expecting %q", v, "bar")
+			}
+			if v := ctx.Value([]byte("foo")); v != "bar" {
+				t.Errorf("unexpected value %v. Expecting %q", v, "bar")
+			}
+			if v := ctx.Value(123); v != nil {
+				t.Errorf("unexpected value %v. Expecting nil", v)
+			}
+		},
+	}
+	go s.Serve(ln)
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	if _, _, err := c.Get(nil, "http://aaa/"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	done := <-doneCh
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("context must be cancelled after the handler returns")
+	}
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestRequestCtxContextInit(t *testing.T) {
+	// The context of ctx without the server mustn't be watched.
+	n := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		var req Request
+		var ctx RequestCtx
+		ctx.Init(&req, nil, nil)
+		if ctx.Done() == nil {
+			t.Fatalf("Done must return non-nil channel")
+		}
+		if err := ctx.Err(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	var ctx RequestCtx
+	if err := ctx.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if m := runtime.NumGoroutine(); m >= n+10 {
+		t.Fatalf("unexpected number of goroutines: %d. Expecting less than %d", m, n+10)
+	}
+}
+
+func TestRequestCtxContextShutdown(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	handlerStarted := make(chan struct{}, 1)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			handlerStarted <- struct{}{}
+			<-ctx.Done()
+			if ctx.Err() != context.Canceled {
+				t.Errorf("unexpected error: %v. Expecting %v", ctx.Err(), context.Canceled)
+			}
+			ctx.WriteString("cancelled")
+		},
+	}
+	go s.Serve(ln)
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	<-handlerStarted
+
+	shutdownCh := make(chan error, 1)
+	go func() {
+		shutdownCh <- s.Shutdown()
+	}()
+
+	var resp Response
+	br := bufio.NewReader(c)
+	if err = resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "cancelled" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "cancelled")
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("expecting 'Connection: close' response header")
+	}
+	select {
+	case err = <-shutdownCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestRequestCtxContextClientDisconnect(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("closed connections aren't detected on windows")
+	}
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	handlerStarted := make(chan string, 2)
+	handlerCancelled := make(chan string, 2)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			path := string(ctx.Path())
+			handlerStarted <- path
+			timeout := 5 * time.Second
+			if path == "/pipelined" {
+				// Pipelined requests mustn't cancel the context.
+				timeout = 3 * connCheckInterval
+			}
+			select {
+			case <-ctx.Done():
+				handlerCancelled <- path
+			case <-time.After(timeout):
+			}
+		},
+	}
+	go s.Serve(ln)
+	defer s.Shutdown()
+
+	c, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = c.Write([]byte("GET /pipelined HTTP/1.1\r\nHost: aaa\r\n\r\nGET /disconnect HTTP/1.1\r\nHost: aaa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, expectedPath := range []string{"/pipelined", "/disconnect"} {
+		if path := <-handlerStarted; path != expectedPath {
+			t.Fatalf("unexpected path %q. Expecting %q", path, expectedPath)
+		}
+	}
+	select {
+	case path := <-handlerCancelled:
+		t.Fatalf("unexpected cancellation of %q", path)
+	default:
+	}
+
+	c.Close()
+	select {
+	case path := <-handlerCancelled:
+		if path != "/disconnect" {
+			t.Fatalf("unexpected path %q. Expecting %q", path, "/disconnect")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("context must be cancelled after the client disconnects")
+	}
+}
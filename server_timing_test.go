@@ -26,6 +26,45 @@ func BenchmarkRequestCtxRedirect(b *testing.B) {
 	})
 }
 
+func BenchmarkCompressHandlerConfig(b *testing.B) {
+	body := createFixedBody(4096)
+	h := CompressHandlerConfig(func(ctx *RequestCtx) {
+		ctx.Write(body)
+	}, CompressConfig{
+		Level: CompressBestSpeed,
+	})
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var ctx RequestCtx
+		for pb.Next() {
+			ctx.Request.Header.Set("Accept-Encoding", "gzip, deflate, br")
+			h(&ctx)
+			ctx.Request.Reset()
+			ctx.Response.Reset()
+		}
+	})
+}
+
+func BenchmarkCompressHandlerConfigBrotli(b *testing.B) {
+	body := createFixedBody(4096)
+	h := CompressHandlerConfig(func(ctx *RequestCtx) {
+		ctx.Write(body)
+	}, CompressConfig{
+		EnableBrotli: true,
+		BrotliLevel:  CompressBrotliBestSpeed,
+	})
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		var ctx RequestCtx
+		for pb.Next() {
+			ctx.Request.Header.Set("Accept-Encoding", "gzip, deflate, br")
+			h(&ctx)
+			ctx.Request.Reset()
+			ctx.Response.Reset()
+		}
+	})
+}
+
 func BenchmarkServerGet1ReqPerConn(b *testing.B) {
 	benchmarkServerGet(b, defaultClientsCount, 1)
 }
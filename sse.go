@@ -0,0 +1,341 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DefaultEventSourceReconnectDelay is the default delay between reconnects
+// made by EventSource if neither EventSource.ReconnectDelay nor retry field
+// sent by the server is set.
+const DefaultEventSourceReconnectDelay = 3 * time.Second
+
+// Event is a server-sent event.
+//
+// See https://html.spec.whatwg.org/multipage/server-sent-events.html .
+type Event struct {
+	// ID is the last event id set by the stream.
+	ID string
+
+	// Type is the event type. It is "message" if the event type
+	// isn't set by the stream.
+	Type string
+
+	// Data is the event data. Multiple data lines are joined with '\n'.
+	//
+	// Data is valid until the next event is read.
+	Data []byte
+}
+
+// EventReader parses server-sent events from text/event-stream.
+//
+// Use EventSource for reading events from http endpoints.
+type EventReader struct {
+	br *bufio.Reader
+
+	lastEventID string
+	retry       time.Duration
+	line        []byte
+}
+
+// NewEventReader returns EventReader, which reads events from r.
+func NewEventReader(r io.Reader) *EventReader {
+	return &EventReader{
+		br: bufio.NewReader(r),
+	}
+}
+
+// Retry returns the reconnection delay set by the last retry field
+// of the stream. Zero is returned if the stream contains no retry fields.
+func (er *EventReader) Retry() time.Duration {
+	return er.retry
+}
+
+// Next reads the next event from the stream into ev.
+//
+// io.EOF is returned at the end of the stream. The incomplete event
+// at the end of the stream is discarded.
+func (er *EventReader) Next(ev *Event) error {
+	ev.Type = ""
+	ev.Data = ev.Data[:0]
+	hasData := false
+	for {
+		line, err := er.readLine()
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			// Dispatch the event.
+			if !hasData {
+				ev.Type = ""
+				continue
+			}
+			ev.ID = er.lastEventID
+			if len(ev.Type) == 0 {
+				ev.Type = "message"
+			}
+			return nil
+		}
+		if line[0] == ':' {
+			// Comment line.
+			continue
+		}
+
+		field := line
+		var value []byte
+		if n := bytes.IndexByte(line, ':'); n >= 0 {
+			field = line[:n]
+			value = line[n+1:]
+			if len(value) > 0 && value[0] == ' ' {
+				value = value[1:]
+			}
+		}
+		switch string(field) {
+		case "data":
+			if hasData {
+				ev.Data = append(ev.Data, '\n')
+			}
+			ev.Data = append(ev.Data, value...)
+			hasData = true
+		case "event":
+			ev.Type = string(value)
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				er.lastEventID = string(value)
+			}
+		case "retry":
+			if n, err := ParseUint(value); err == nil {
+				er.retry = time.Duration(n) * time.Millisecond
+			}
+		}
+	}
+}
+
+// readLine reads the next line terminated by CRLF, LF or CR.
+func (er *EventReader) readLine() ([]byte, error) {
+	line := er.line[:0]
+	for {
+		c, err := er.br.ReadByte()
+		if err != nil {
+			er.line = line
+			return nil, err
+		}
+		if c == '\n' {
+			break
+		}
+		if c == '\r' {
+			if next, err := er.br.Peek(1); err == nil && next[0] == '\n' {
+				er.br.ReadByte()
+			}
+			break
+		}
+		line = append(line, c)
+	}
+	er.line = line
+	return line, nil
+}
+
+// ErrEventStreamNoContent is returned by EventSource if the server responds
+// with 204 No Content, i.e. the client must stop reconnecting.
+var ErrEventStreamNoContent = errors.New("the server responded with 204 No Content to the event stream request")
+
+// EventSource reads server-sent events from the given url.
+//
+// EventSource reconnects to the url when the stream is closed or broken,
+// sending Last-Event-ID header, so the server may resume the stream.
+//
+// EventSource instance MUST NOT be used from concurrently running goroutines.
+type EventSource struct {
+	// Client is used for connecting to URL.
+	//
+	// Note that Client.ReadTimeout limits the whole stream duration.
+	//
+	// This field is required.
+	Client *Client
+
+	// URL of the text/event-stream endpoint.
+	//
+	// This field is required.
+	URL string
+
+	// PrepareRequest is called before sending each request to URL,
+	// so additional request headers may be set.
+	PrepareRequest func(req *Request)
+
+	// LastEventID is sent in Last-Event-ID header when connecting to URL.
+	//
+	// It is updated with the id of each read event.
+	LastEventID string
+
+	// Delay between reconnects.
+	//
+	// The delay set by the server via retry field takes precedence.
+	// DefaultEventSourceReconnectDelay is used if not set.
+	ReconnectDelay time.Duration
+
+	// The maximum number of consecutive reconnects.
+	//
+	// The counter is reset after each successfully read event.
+	// Reconnects are disabled if MaxReconnects is negative.
+	//
+	// By default the number of reconnects is unlimited.
+	MaxReconnects int
+
+	req        *Request
+	resp       *Response
+	er         *EventReader
+	retry      time.Duration
+	reconnects int
+}
+
+// Next reads the next event into ev.
+//
+// It connects to the URL if needed and reconnects after errors
+// according to ReconnectDelay and MaxReconnects.
+//
+// ErrEventStreamNoContent is returned if the server asks the client
+// to stop reconnecting. An error is also returned if the server responds
+// with non-200 status code or with non-text/event-stream content type.
+func (es *EventSource) Next(ev *Event) error {
+	for {
+		if es.er == nil {
+			fatal, err := es.connect()
+			if err != nil {
+				if fatal || !es.mayReconnect() {
+					return err
+				}
+				time.Sleep(es.reconnectDelay())
+				continue
+			}
+		}
+
+		err := es.er.Next(ev)
+		if err == nil {
+			es.LastEventID = ev.ID
+			es.reconnects = 0
+			return nil
+		}
+		es.closeStream()
+		if !es.mayReconnect() {
+			return err
+		}
+		time.Sleep(es.reconnectDelay())
+	}
+}
+
+// Subscribe reads events and passes them to f until f returns an error
+// or Next returns an error.
+//
+// The error is returned to the caller. The stream is closed on return.
+func (es *EventSource) Subscribe(f func(ev *Event) error) error {
+	defer es.Close()
+
+	var ev Event
+	for {
+		if err := es.Next(&ev); err != nil {
+			return err
+		}
+		if err := f(&ev); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the current stream.
+//
+// The next Next call connects to the URL again.
+func (es *EventSource) Close() error {
+	es.closeStream()
+	if es.req != nil {
+		ReleaseRequest(es.req)
+		ReleaseResponse(es.resp)
+		es.req = nil
+		es.resp = nil
+	}
+	return nil
+}
+
+func (es *EventSource) connect() (bool, error) {
+	if es.req == nil {
+		es.req = AcquireRequest()
+		es.resp = AcquireResponse()
+	}
+	req := es.req
+	resp := es.resp
+	req.Reset()
+	resp.Reset()
+
+	req.SetRequestURI(es.URL)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if len(es.LastEventID) > 0 {
+		req.Header.Set("Last-Event-ID", es.LastEventID)
+	}
+	if es.PrepareRequest != nil {
+		es.PrepareRequest(req)
+	}
+
+	resp.StreamBody = true
+	if err := es.Client.Do(req, resp); err != nil {
+		return false, err
+	}
+
+	statusCode := resp.StatusCode()
+	if statusCode == StatusNoContent {
+		resp.closeBodyStream()
+		return true, ErrEventStreamNoContent
+	}
+	if statusCode != StatusOK {
+		resp.closeBodyStream()
+		return true, fmt.Errorf("unexpected status code %d returned by event stream %q", statusCode, es.URL)
+	}
+	if contentType := resp.Header.ContentType(); !bytes.HasPrefix(contentType, strTextEventStream) {
+		resp.closeBodyStream()
+		return true, fmt.Errorf("unexpected content type %q returned by event stream %q. Expecting %q",
+			contentType, es.URL, strTextEventStream)
+	}
+
+	body := resp.BodyStream()
+	if body == nil {
+		body = bytes.NewReader(resp.Body())
+	}
+	es.er = NewEventReader(body)
+	es.er.lastEventID = es.LastEventID
+	return false, nil
+}
+
+func (es *EventSource) closeStream() {
+	if es.er == nil {
+		return
+	}
+	if retry := es.er.Retry(); retry > 0 {
+		es.retry = retry
+	}
+	es.er = nil
+	es.resp.closeBodyStream()
+}
+
+func (es *EventSource) mayReconnect() bool {
+	if es.MaxReconnects < 0 {
+		return false
+	}
+	if es.MaxReconnects > 0 && es.reconnects >= es.MaxReconnects {
+		return false
+	}
+	es.reconnects++
+	return true
+}
+
+func (es *EventSource) reconnectDelay() time.Duration {
+	if es.retry > 0 {
+		return es.retry
+	}
+	if es.ReconnectDelay > 0 {
+		return es.ReconnectDelay
+	}
+	return DefaultEventSourceReconnectDelay
+}
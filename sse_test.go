@@ -0,0 +1,197 @@
+package fasthttp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestEventReader(t *testing.T) {
+	s := ": comment\n" +
+		"data: first\n" +
+		"\n" +
+		"event: add\r\n" +
+		"id: 1\r\n" +
+		"data:second\r\n" +
+		"data:  line\r\n" +
+		"\r\n" +
+		"id: 2\r" +
+		"data\r" +
+		"retry: 1500\r" +
+		"\r" +
+		"event: skipped\n" +
+		"\n" +
+		"retry: bad\n" +
+		"data: last\n" +
+		"\n" +
+		"data: incomplete"
+	er := NewEventReader(strings.NewReader(s))
+
+	expectedEvents := []Event{
+		{ID: "", Type: "message", Data: []byte("first")},
+		{ID: "1", Type: "add", Data: []byte("second\n line")},
+		{ID: "2", Type: "message", Data: []byte("")},
+		{ID: "2", Type: "message", Data: []byte("last")},
+	}
+	var ev Event
+	for i, expectedEv := range expectedEvents {
+		if err := er.Next(&ev); err != nil {
+			t.Fatalf("unexpected error when reading event #%d: %s", i, err)
+		}
+		if ev.ID != expectedEv.ID || ev.Type != expectedEv.Type || string(ev.Data) != string(expectedEv.Data) {
+			t.Fatalf("unexpected event #%d: id=%q, type=%q, data=%q. Expecting id=%q, type=%q, data=%q", i,
+				ev.ID, ev.Type, ev.Data, expectedEv.ID, expectedEv.Type, expectedEv.Data)
+		}
+	}
+	if err := er.Next(&ev); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+	if retry := er.Retry(); retry != 1500*time.Millisecond {
+		t.Fatalf("unexpected retry %s. Expecting %s", retry, 1500*time.Millisecond)
+	}
+}
+
+func TestEventSource(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	var requestsCount uint32
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Request.Header.Peek("Accept")) != "text/event-stream" {
+				t.Errorf("unexpected Accept header %q", ctx.Request.Header.Peek("Accept"))
+			}
+			lastEventID := string(ctx.Request.Header.Peek("Last-Event-ID"))
+			switch atomic.AddUint32(&requestsCount, 1) {
+			case 1:
+				if lastEventID != "" {
+					t.Errorf("unexpected Last-Event-ID %q. Expecting empty value", lastEventID)
+				}
+				ctx.SetContentType("text/event-stream")
+				ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+					for i := 1; i <= 2; i++ {
+						fmt.Fprintf(w, "id: %d\ndata: event %d\n\n", i, i)
+						w.Flush()
+					}
+					// Reconnect as soon as possible.
+					fmt.Fprintf(w, "retry: 1\n\n")
+				})
+			case 2:
+				ctx.Error("temporary error", StatusServiceUnavailable)
+			case 3:
+				if lastEventID != "2" {
+					t.Errorf("unexpected Last-Event-ID %q. Expecting %q", lastEventID, "2")
+				}
+				ctx.SetContentType("text/event-stream; charset=utf-8")
+				fmt.Fprintf(ctx, "data: event 3\n\n")
+			default:
+				ctx.SetStatusCode(StatusNoContent)
+			}
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	// Non-200 responses mustn't be retried.
+	es := &EventSource{
+		Client:         c,
+		URL:            "http://foobar/events",
+		ReconnectDelay: time.Millisecond,
+	}
+	var events []string
+	err := es.Subscribe(func(ev *Event) error {
+		events = append(events, fmt.Sprintf("%s %s", ev.ID, ev.Data))
+		return nil
+	})
+	if err == nil || err == ErrEventStreamNoContent {
+		t.Fatalf("unexpected error: %v. Expecting status code error", err)
+	}
+	expectedEvents := []string{"1 event 1", "2 event 2"}
+	if strings.Join(events, ",") != strings.Join(expectedEvents, ",") {
+		t.Fatalf("unexpected events %q. Expecting %q", events, expectedEvents)
+	}
+
+	// The stream must be resumed from the last event id.
+	events = events[:0]
+	err = es.Subscribe(func(ev *Event) error {
+		events = append(events, fmt.Sprintf("%s %s", ev.ID, ev.Data))
+		return nil
+	})
+	if err != ErrEventStreamNoContent {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrEventStreamNoContent)
+	}
+	expectedEvents = []string{"2 event 3"}
+	if strings.Join(events, ",") != strings.Join(expectedEvents, ",") {
+		t.Fatalf("unexpected events %q. Expecting %q", events, expectedEvents)
+	}
+}
+
+func TestEventSourceCallbackError(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetContentType("text/event-stream")
+			fmt.Fprintf(ctx, "data: foo\n\ndata: bar\n\n")
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	es := &EventSource{
+		Client: &Client{
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		},
+		URL: "http://foobar/events",
+	}
+	errStop := errors.New("stop")
+	eventsCount := 0
+	err := es.Subscribe(func(ev *Event) error {
+		eventsCount++
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errStop)
+	}
+	if eventsCount != 1 {
+		t.Fatalf("unexpected number of events: %d. Expecting 1", eventsCount)
+	}
+}
+
+func TestEventSourceContentType(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("data: foo\n\n")
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	es := &EventSource{
+		Client: &Client{
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		},
+		URL: "http://foobar/events",
+	}
+	var ev Event
+	if err := es.Next(&ev); err == nil {
+		t.Fatalf("expecting error for unexpected content type")
+	}
+	es.Close()
+}
@@ -13,6 +13,7 @@ var (
 	strSlashDotSlash    = []byte("/./")
 	strSlashDotDotSlash = []byte("/../")
 	strCRLF             = []byte("\r\n")
+	strLastChunk        = []byte("0\r\n")
 	strHTTP             = []byte("http")
 	strHTTPS            = []byte("https")
 	strHTTP11           = []byte("HTTP/1.1")
@@ -20,34 +21,49 @@ var (
 	strColonSpace       = []byte(": ")
 	strGMT              = []byte("GMT")
 
-	strResponseContinue = []byte("HTTP/1.1 100 Continue\r\n\r\n")
+	strResponseContinue       = []byte("HTTP/1.1 100 Continue\r\n\r\n")
+	strResponseSwitchingToH2C = []byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
 
-	strGet    = []byte("GET")
-	strHead   = []byte("HEAD")
-	strPost   = []byte("POST")
-	strPut    = []byte("PUT")
-	strDelete = []byte("DELETE")
+	strGet     = []byte("GET")
+	strHead    = []byte("HEAD")
+	strPost    = []byte("POST")
+	strPut     = []byte("PUT")
+	strDelete  = []byte("DELETE")
+	strConnect = []byte("CONNECT")
+	strOptions = []byte("OPTIONS")
+	strTrace   = []byte("TRACE")
 
-	strExpect           = []byte("Expect")
-	strConnection       = []byte("Connection")
-	strContentLength    = []byte("Content-Length")
-	strContentType      = []byte("Content-Type")
-	strDate             = []byte("Date")
-	strHost             = []byte("Host")
-	strReferer          = []byte("Referer")
-	strServer           = []byte("Server")
-	strTransferEncoding = []byte("Transfer-Encoding")
-	strContentEncoding  = []byte("Content-Encoding")
-	strAcceptEncoding   = []byte("Accept-Encoding")
-	strUserAgent        = []byte("User-Agent")
-	strCookie           = []byte("Cookie")
-	strSetCookie        = []byte("Set-Cookie")
-	strLocation         = []byte("Location")
-	strIfModifiedSince  = []byte("If-Modified-Since")
-	strLastModified     = []byte("Last-Modified")
-	strAcceptRanges     = []byte("Accept-Ranges")
-	strRange            = []byte("Range")
-	strContentRange     = []byte("Content-Range")
+	strExpect            = []byte("Expect")
+	strConnection        = []byte("Connection")
+	strContentLength     = []byte("Content-Length")
+	strContentType       = []byte("Content-Type")
+	strDate              = []byte("Date")
+	strHost              = []byte("Host")
+	strReferer           = []byte("Referer")
+	strServer            = []byte("Server")
+	strTransferEncoding  = []byte("Transfer-Encoding")
+	strContentEncoding   = []byte("Content-Encoding")
+	strAcceptEncoding    = []byte("Accept-Encoding")
+	strUserAgent         = []byte("User-Agent")
+	strCookie            = []byte("Cookie")
+	strSetCookie         = []byte("Set-Cookie")
+	strLocation          = []byte("Location")
+	strIfModifiedSince   = []byte("If-Modified-Since")
+	strLastModified      = []byte("Last-Modified")
+	strAcceptRanges      = []byte("Accept-Ranges")
+	strRange             = []byte("Range")
+	strContentRange      = []byte("Content-Range")
+	strIfRange           = []byte("If-Range")
+	strETag              = []byte("Etag")
+	strAuthorization     = []byte("Authorization")
+	strCacheControl      = []byte("Cache-Control")
+	strIfNoneMatch       = []byte("If-None-Match")
+	strIfMatch           = []byte("If-Match")
+	strIfUnmodifiedSince = []byte("If-Unmodified-Since")
+	strVary              = []byte("Vary")
+	strAge               = []byte("Age")
+	strExpires           = []byte("Expires")
+	strTrailer           = []byte("Trailer")
 
 	strCookieExpires  = []byte("expires")
 	strCookieDomain   = []byte("domain")
@@ -55,12 +71,31 @@ var (
 	strCookieHTTPOnly = []byte("HttpOnly")
 	strCookieSecure   = []byte("secure")
 
+	strWeakETagPrefix = []byte("W/")
+
+	strNoStore     = []byte("no-store")
+	strNoCache     = []byte("no-cache")
+	strNoTransform = []byte("no-transform")
+	strMaxAge      = []byte("max-age")
+	strStar        = []byte("*")
+	strDot         = []byte(".")
+
+	strQualityParam = []byte("q=")
+
+	strTextEventStream = []byte("text/event-stream")
+
 	strClose               = []byte("close")
 	strGzip                = []byte("gzip")
 	strDeflate             = []byte("deflate")
+	strBr                  = []byte("br")
 	strKeepAlive           = []byte("keep-alive")
 	strKeepAliveCamelCase  = []byte("Keep-Alive")
 	strUpgrade             = []byte("Upgrade")
+	strH2C                 = []byte("h2c")
+	strHTTP2Settings       = []byte("HTTP2-Settings")
+	strAltSvc              = []byte("Alt-Svc")
+	strWebSocket           = []byte("websocket")
+	strWebSocketVersion    = []byte("13")
 	strChunked             = []byte("chunked")
 	strIdentity            = []byte("identity")
 	str100Continue         = []byte("100-continue")
@@ -68,6 +103,11 @@ var (
 	strMultipartFormData   = []byte("multipart/form-data")
 	strBoundary            = []byte("boundary")
 	strBytes               = []byte("bytes")
+	strBasicSpace          = []byte("Basic ")
+	strBearerSpace         = []byte("Bearer ")
 	strTextSlash           = []byte("text/")
 	strApplicationSlash    = []byte("application/")
+	strApplicationJSON     = []byte("application/json")
+
+	strSecWebSocketProtocol = []byte("Sec-WebSocket-Protocol")
 )
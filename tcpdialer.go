@@ -1,6 +1,7 @@
 package fasthttp
 
 import (
+	"context"
 	"errors"
 	"net"
 	"strconv"
@@ -33,7 +34,7 @@ import (
 //     * foo.bar:80
 //     * aaa.com:8080
 func Dial(addr string) (net.Conn, error) {
-	return getDialer(DefaultDialTimeout, false)(addr)
+	return defaultDialer.Dial(addr)
 }
 
 // DialTimeout dials the given TCP addr using tcp4 using the given timeout.
@@ -58,7 +59,7 @@ func Dial(addr string) (net.Conn, error) {
 //     * foo.bar:80
 //     * aaa.com:8080
 func DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
-	return getDialer(timeout, false)(addr)
+	return defaultDialer.DialTimeout(addr, timeout)
 }
 
 // DialDualStack dials the given TCP addr using both tcp4 and tcp6.
@@ -70,6 +71,9 @@ func DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
 //   * It dials all the resolved TCP addresses in round-robin manner until
 //     connection is established. This may be useful if certain addresses
 //     are temporarily unreachable.
+//   * It starts dialing the fallback address family after
+//     DefaultFallbackDelay if the primary address family is slow
+//     or unreachable (Happy Eyeballs).
 //   * It returns ErrDialTimeout if connection cannot be established during
 //     DefaultDialTimeout seconds. Use DialDualStackTimeout for custom dial
 //     timeout.
@@ -86,7 +90,7 @@ func DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
 //     * foo.bar:80
 //     * aaa.com:8080
 func DialDualStack(addr string) (net.Conn, error) {
-	return getDialer(DefaultDialTimeout, true)(addr)
+	return defaultDialer.DialDualStack(addr)
 }
 
 // DialDualStackTimeout dials the given TCP addr using both tcp4 and tcp6
@@ -99,6 +103,9 @@ func DialDualStack(addr string) (net.Conn, error) {
 //   * It dials all the resolved TCP addresses in round-robin manner until
 //     connection is established. This may be useful if certain addresses
 //     are temporarily unreachable.
+//   * It starts dialing the fallback address family after
+//     DefaultFallbackDelay if the primary address family is slow
+//     or unreachable (Happy Eyeballs).
 //
 // This dialer is intended for custom code wrapping before passing
 // to Client.Dial or HostClient.Dial.
@@ -112,92 +119,270 @@ func DialDualStack(addr string) (net.Conn, error) {
 //     * foo.bar:80
 //     * aaa.com:8080
 func DialDualStackTimeout(addr string, timeout time.Duration) (net.Conn, error) {
-	return getDialer(timeout, true)(addr)
+	return defaultDialer.DialDualStackTimeout(addr, timeout)
 }
 
-func getDialer(timeout time.Duration, dualStack bool) DialFunc {
-	if timeout <= 0 {
-		timeout = DefaultDialTimeout
-	}
-	timeoutRounded := int(timeout.Seconds()*10 + 9)
+var defaultDialer = &TCPDialer{}
 
-	m := dialMap
-	if dualStack {
-		m = dialDualStackMap
-	}
+// TCPDialer dials TCP addresses and caches resolved DNS entries.
+//
+// Dial, DialTimeout, DialDualStack and DialDualStackTimeout use
+// TCPDialer with default settings. Create custom TCPDialer
+// for tuning DNS caching and pass its' methods to Client.Dial
+// or HostClient.Dial:
+//
+//     d := &fasthttp.TCPDialer{
+//         DNSCacheDuration:         10 * time.Second,
+//         DNSNegativeCacheDuration: time.Second,
+//     }
+//     c := &fasthttp.Client{
+//         Dial: d.Dial,
+//     }
+//
+// It is safe calling TCPDialer methods from concurrently running goroutines.
+// TCPDialer settings mustn't be changed after the first dial.
+type TCPDialer struct {
+	// Maximum number of concurrent dials.
+	//
+	// DefaultDialConcurrency is used if not set.
+	Concurrency int
+
+	// Resolved TCP addresses are cached for this duration.
+	//
+	// Expired addresses are refreshed in background, while the dialer
+	// continues using them until the refresh completes. Addresses
+	// which aren't refreshed during 2*DNSCacheDuration are evicted
+	// from the cache.
+	//
+	// DefaultDNSCacheDuration is used if not set.
+	DNSCacheDuration time.Duration
+
+	// DNS lookup errors are cached for this duration, so subsequent dials
+	// to the same address fail immediately without hammering DNS resolver.
+	//
+	// By default lookup errors aren't cached.
+	DNSNegativeCacheDuration time.Duration
+
+	// Delay before dialing addresses from the fallback address family
+	// in DialDualStack*.
+	//
+	// DialDualStack* dials addresses from the family of the first resolved
+	// address (usually IPv6) and then starts dialing addresses from
+	// the other family in parallel after the given delay
+	// if the connection isn't established yet. The first established
+	// connection wins. This is known as Happy Eyeballs (RFC 8305).
+	//
+	// Addresses are dialed sequentially if FallbackDelay is negative.
+	//
+	// DefaultFallbackDelay is used if not set.
+	FallbackDelay time.Duration
+
+	// Resolver for looking up host IP addresses.
+	//
+	// Custom resolver may be used for service discovery
+	// or for overriding host addresses in tests.
+	//
+	// net.DefaultResolver is used if not set.
+	Resolver Resolver
 
-	dialMapLock.Lock()
-	d := m[timeoutRounded]
-	if d == nil {
-		dialer := dialerStd
-		if dualStack {
-			dialer = dialerDualStack
-		}
-		d = dialer.NewDial(timeout)
-		m[timeoutRounded] = d
-	}
-	dialMapLock.Unlock()
-	return d
+	tcpAddrsLock sync.Mutex
+	tcpAddrsMap  map[tcpAddrsKey]*tcpAddrEntry
+
+	concurrencyCh chan struct{}
+
+	once sync.Once
 }
 
-var (
-	dialerStd       = &tcpDialer{}
-	dialerDualStack = &tcpDialer{DualStack: true}
+// Resolver looks up IP addresses for the given host.
+//
+// *net.Resolver implements Resolver.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
 
-	dialMap          = make(map[int]DialFunc)
-	dialDualStackMap = make(map[int]DialFunc)
-	dialMapLock      sync.Mutex
-)
+// DefaultFallbackDelay is the delay before dialing the fallback address
+// family by TCPDialer.DialDualStack* if TCPDialer.FallbackDelay isn't set.
+const DefaultFallbackDelay = 300 * time.Millisecond
 
-type tcpDialer struct {
-	DualStack bool
+// DefaultDialConcurrency is the maximum number of concurrent dials
+// performed by TCPDialer if TCPDialer.Concurrency isn't set.
+const DefaultDialConcurrency = 1000
 
-	tcpAddrsLock sync.Mutex
-	tcpAddrsMap  map[string]*tcpAddrEntry
+// Dial dials the given TCP addr using tcp4.
+//
+// ErrDialTimeout is returned if connection cannot be established during
+// DefaultDialTimeout.
+//
+// See Dial for details.
+func (d *TCPDialer) Dial(addr string) (net.Conn, error) {
+	return d.dial(addr, false, DefaultDialTimeout, nil)
+}
 
-	concurrencyCh chan struct{}
+// DialTimeout dials the given TCP addr using tcp4 using the given timeout.
+//
+// See DialTimeout for details.
+func (d *TCPDialer) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	return d.dial(addr, false, timeout, nil)
+}
 
-	once sync.Once
+// DialDualStack dials the given TCP addr using both tcp4 and tcp6.
+//
+// ErrDialTimeout is returned if connection cannot be established during
+// DefaultDialTimeout.
+//
+// See DialDualStack for details.
+func (d *TCPDialer) DialDualStack(addr string) (net.Conn, error) {
+	return d.dial(addr, true, DefaultDialTimeout, nil)
 }
 
-const maxDialConcurrency = 1000
+// DialDualStackTimeout dials the given TCP addr using both tcp4 and tcp6
+// using the given timeout.
+//
+// See DialDualStackTimeout for details.
+func (d *TCPDialer) DialDualStackTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	return d.dial(addr, true, timeout, nil)
+}
 
-func (d *tcpDialer) NewDial(timeout time.Duration) DialFunc {
+func (d *TCPDialer) dial(addr string, dualStack bool, timeout time.Duration, trace *ClientTrace) (net.Conn, error) {
 	d.once.Do(func() {
-		d.concurrencyCh = make(chan struct{}, maxDialConcurrency)
-		d.tcpAddrsMap = make(map[string]*tcpAddrEntry)
+		concurrency := d.Concurrency
+		if concurrency <= 0 {
+			concurrency = DefaultDialConcurrency
+		}
+		d.concurrencyCh = make(chan struct{}, concurrency)
+		d.tcpAddrsMap = make(map[tcpAddrsKey]*tcpAddrEntry)
 		go d.tcpAddrsClean()
 	})
 
-	return func(addr string) (net.Conn, error) {
-		addrs, idx, err := d.getTCPAddrs(addr)
-		if err != nil {
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	addrs, idx, err := d.getTCPAddrs(addr, dualStack, deadline, trace)
+	if err != nil {
+		return nil, err
+	}
+	network := "tcp4"
+	if dualStack {
+		if d.FallbackDelay >= 0 {
+			return d.dialParallel(addrs, idx, deadline, trace)
+		}
+		network = "tcp"
+	}
+
+	var conn net.Conn
+	n := uint32(len(addrs))
+	for n > 0 {
+		conn, err = tryDial(network, &addrs[idx%n], deadline, d.concurrencyCh, trace)
+		if err == nil {
+			return conn, nil
+		}
+		if err == ErrDialTimeout {
 			return nil, err
 		}
-		network := "tcp4"
-		if d.DualStack {
-			network = "tcp"
+		idx++
+		n--
+	}
+	return nil, err
+}
+
+// dialParallel dials addrs from the primary and the fallback address
+// families in parallel according to RFC 8305.
+func (d *TCPDialer) dialParallel(addrs []net.TCPAddr, idx uint32, deadline time.Time, trace *ClientTrace) (net.Conn, error) {
+	n := uint32(len(addrs))
+	primaryIsIPv4 := addrs[idx%n].IP.To4() != nil
+	var primaries, fallbacks []net.TCPAddr
+	for i := uint32(0); i < n; i++ {
+		addr := addrs[(idx+i)%n]
+		if (addr.IP.To4() != nil) == primaryIsIPv4 {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	if len(fallbacks) == 0 {
+		return d.dialSerial(primaries, deadline, trace)
+	}
+
+	type dialParallelResult struct {
+		dialResult
+		primary bool
+	}
+	resultCh := make(chan dialParallelResult)
+	returnedCh := make(chan struct{})
+	defer close(returnedCh)
+
+	startRacer := func(addrs []net.TCPAddr, primary bool) {
+		var r dialParallelResult
+		r.conn, r.err = d.dialSerial(addrs, deadline, trace)
+		r.primary = primary
+		select {
+		case resultCh <- r:
+		case <-returnedCh:
+			if r.conn != nil {
+				r.conn.Close()
+			}
 		}
+	}
+
+	fallbackDelay := d.FallbackDelay
+	if fallbackDelay == 0 {
+		fallbackDelay = DefaultFallbackDelay
+	}
+	go startRacer(primaries, true)
+	tc := acquireTimer(fallbackDelay)
+	defer releaseTimer(tc)
 
-		var conn net.Conn
-		n := uint32(len(addrs))
-		deadline := time.Now().Add(timeout)
-		for n > 0 {
-			conn, err = tryDial(network, &addrs[idx%n], deadline, d.concurrencyCh)
-			if err == nil {
-				return conn, nil
+	fallbackCh := tc.C
+	pending := 1
+	var firstErr error
+	for {
+		select {
+		case <-fallbackCh:
+			fallbackCh = nil
+			pending++
+			go startRacer(fallbacks, false)
+		case r := <-resultCh:
+			pending--
+			if r.err == nil {
+				return r.conn, nil
 			}
-			if err == ErrDialTimeout {
-				return nil, err
+			if r.primary || firstErr == nil {
+				firstErr = r.err
+			}
+			if fallbackCh != nil {
+				// Do not wait for the fallback delay if all
+				// the primary addresses failed.
+				stopTimer(tc)
+				fallbackCh = nil
+				pending++
+				go startRacer(fallbacks, false)
+			}
+			if pending == 0 {
+				return nil, firstErr
 			}
-			idx++
-			n--
 		}
-		return nil, err
 	}
 }
 
-func tryDial(network string, addr *net.TCPAddr, deadline time.Time, concurrencyCh chan struct{}) (net.Conn, error) {
+// dialSerial dials addrs one by one until connection is established.
+func (d *TCPDialer) dialSerial(addrs []net.TCPAddr, deadline time.Time, trace *ClientTrace) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	for i := range addrs {
+		conn, err = tryDial("tcp", &addrs[i], deadline, d.concurrencyCh, trace)
+		if err == nil {
+			return conn, nil
+		}
+		if err == ErrDialTimeout {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func tryDial(network string, addr *net.TCPAddr, deadline time.Time, concurrencyCh chan struct{}, trace *ClientTrace) (net.Conn, error) {
 	timeout := -time.Since(deadline)
 	if timeout <= 0 {
 		return nil, ErrDialTimeout
@@ -225,6 +410,8 @@ func tryDial(network string, addr *net.TCPAddr, deadline time.Time, concurrencyC
 		return nil, ErrDialTimeout
 	}
 
+	trace.connectStart(network, addr.String())
+
 	chv := dialResultChanPool.Get()
 	if chv == nil {
 		chv = make(chan dialResult, 1)
@@ -253,6 +440,8 @@ func tryDial(network string, addr *net.TCPAddr, deadline time.Time, concurrencyC
 	}
 	releaseTimer(tc)
 
+	trace.connectDone(network, addr.String(), err)
+
 	return conn, err
 }
 
@@ -270,10 +459,18 @@ var ErrDialTimeout = errors.New("dialing to the given TCP address timed out")
 // for establishing TCP connections.
 const DefaultDialTimeout = 3 * time.Second
 
+type tcpAddrsKey struct {
+	addr      string
+	dualStack bool
+}
+
 type tcpAddrEntry struct {
 	addrs    []net.TCPAddr
 	addrsIdx uint32
 
+	// err is set for cached DNS lookup errors.
+	err error
+
 	resolveTime time.Time
 	pending     bool
 }
@@ -282,15 +479,26 @@ type tcpAddrEntry struct {
 // by Dial* functions.
 const DefaultDNSCacheDuration = time.Minute
 
-func (d *tcpDialer) tcpAddrsClean() {
-	expireDuration := 2 * DefaultDNSCacheDuration
+func (d *TCPDialer) dnsCacheDuration() time.Duration {
+	if d.DNSCacheDuration <= 0 {
+		return DefaultDNSCacheDuration
+	}
+	return d.DNSCacheDuration
+}
+
+func (d *TCPDialer) tcpAddrsClean() {
+	expireDuration := 2 * d.dnsCacheDuration()
 	for {
 		time.Sleep(time.Second)
 		t := time.Now()
 
 		d.tcpAddrsLock.Lock()
 		for k, e := range d.tcpAddrsMap {
-			if t.Sub(e.resolveTime) > expireDuration {
+			if e.err != nil {
+				if t.Sub(e.resolveTime) > d.DNSNegativeCacheDuration {
+					delete(d.tcpAddrsMap, k)
+				}
+			} else if t.Sub(e.resolveTime) > expireDuration {
 				delete(d.tcpAddrsMap, k)
 			}
 		}
@@ -298,24 +506,42 @@ func (d *tcpDialer) tcpAddrsClean() {
 	}
 }
 
-func (d *tcpDialer) getTCPAddrs(addr string) ([]net.TCPAddr, uint32, error) {
+func (d *TCPDialer) getTCPAddrs(addr string, dualStack bool, deadline time.Time, trace *ClientTrace) ([]net.TCPAddr, uint32, error) {
+	key := tcpAddrsKey{
+		addr:      addr,
+		dualStack: dualStack,
+	}
+
 	d.tcpAddrsLock.Lock()
-	e := d.tcpAddrsMap[addr]
-	if e != nil && !e.pending && time.Since(e.resolveTime) > DefaultDNSCacheDuration {
-		e.pending = true
-		e = nil
+	e := d.tcpAddrsMap[key]
+	if e != nil {
+		age := time.Since(e.resolveTime)
+		if e.err != nil {
+			if age <= d.DNSNegativeCacheDuration {
+				d.tcpAddrsLock.Unlock()
+				return nil, 0, e.err
+			}
+			e = nil
+		} else if !e.pending && age > d.dnsCacheDuration() {
+			// Refresh expired addresses in background, while continuing
+			// using the current addresses.
+			e.pending = true
+			go d.refreshTCPAddrs(key)
+		}
 	}
 	d.tcpAddrsLock.Unlock()
 
 	if e == nil {
-		addrs, err := resolveTCPAddrs(addr, d.DualStack)
+		addrs, err := d.resolveTCPAddrs(addr, dualStack, deadline, trace)
 		if err != nil {
-			d.tcpAddrsLock.Lock()
-			e = d.tcpAddrsMap[addr]
-			if e != nil && e.pending {
-				e.pending = false
+			if d.DNSNegativeCacheDuration > 0 {
+				d.tcpAddrsLock.Lock()
+				d.tcpAddrsMap[key] = &tcpAddrEntry{
+					err:         err,
+					resolveTime: time.Now(),
+				}
+				d.tcpAddrsLock.Unlock()
 			}
-			d.tcpAddrsLock.Unlock()
 			return nil, 0, err
 		}
 
@@ -325,7 +551,7 @@ func (d *tcpDialer) getTCPAddrs(addr string) ([]net.TCPAddr, uint32, error) {
 		}
 
 		d.tcpAddrsLock.Lock()
-		d.tcpAddrsMap[addr] = e
+		d.tcpAddrsMap[key] = e
 		d.tcpAddrsLock.Unlock()
 	}
 
@@ -333,7 +559,26 @@ func (d *tcpDialer) getTCPAddrs(addr string) ([]net.TCPAddr, uint32, error) {
 	return e.addrs, idx, nil
 }
 
-func resolveTCPAddrs(addr string, dualStack bool) ([]net.TCPAddr, error) {
+func (d *TCPDialer) refreshTCPAddrs(key tcpAddrsKey) {
+	addrs, err := d.resolveTCPAddrs(key.addr, key.dualStack, time.Now().Add(DefaultDialTimeout), nil)
+
+	d.tcpAddrsLock.Lock()
+	if err != nil {
+		// Continue using the current addresses until they are evicted
+		// by tcpAddrsClean. The refresh is retried on the next dial.
+		if e := d.tcpAddrsMap[key]; e != nil {
+			e.pending = false
+		}
+	} else {
+		d.tcpAddrsMap[key] = &tcpAddrEntry{
+			addrs:       addrs,
+			resolveTime: time.Now(),
+		}
+	}
+	d.tcpAddrsLock.Unlock()
+}
+
+func (d *TCPDialer) resolveTCPAddrs(addr string, dualStack bool, deadline time.Time, trace *ClientTrace) ([]net.TCPAddr, error) {
 	host, portS, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
@@ -343,21 +588,30 @@ func resolveTCPAddrs(addr string, dualStack bool) ([]net.TCPAddr, error) {
 		return nil, err
 	}
 
-	ips, err := net.LookupIP(host)
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	trace.dnsStart(host)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	ipAddrs, err := resolver.LookupIPAddr(ctx, host)
+	cancel()
+	trace.dnsDone(ipAddrs, err)
 	if err != nil {
 		return nil, err
 	}
 
-	n := len(ips)
+	n := len(ipAddrs)
 	addrs := make([]net.TCPAddr, 0, n)
 	for i := 0; i < n; i++ {
-		ip := ips[i]
-		if !dualStack && ip.To4() == nil {
+		ip := ipAddrs[i]
+		if !dualStack && ip.IP.To4() == nil {
 			continue
 		}
 		addrs = append(addrs, net.TCPAddr{
-			IP:   ip,
+			IP:   ip.IP,
 			Port: port,
+			Zone: ip.Zone,
 		})
 	}
 	if len(addrs) == 0 {
@@ -0,0 +1,189 @@
+package fasthttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func startTCPDialerTestServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return ln
+}
+
+func TestTCPDialerDNSCache(t *testing.T) {
+	ln := startTCPDialerTestServer(t)
+	defer ln.Close()
+
+	d := &TCPDialer{
+		DNSCacheDuration: 50 * time.Millisecond,
+	}
+	addr := ln.Addr().String()
+	key := tcpAddrsKey{
+		addr: addr,
+	}
+
+	dial := func() {
+		conn, err := d.Dial(addr)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		conn.Close()
+	}
+	getEntry := func() *tcpAddrEntry {
+		d.tcpAddrsLock.Lock()
+		e := d.tcpAddrsMap[key]
+		d.tcpAddrsLock.Unlock()
+		return e
+	}
+
+	dial()
+	e := getEntry()
+	if e == nil {
+		t.Fatalf("missing DNS cache entry for %q", addr)
+	}
+	dial()
+	if getEntry() != e {
+		t.Fatalf("DNS cache entry mustn't be refreshed before DNSCacheDuration")
+	}
+
+	// Expired entry must be used while it is refreshed in background.
+	time.Sleep(100 * time.Millisecond)
+	dial()
+	for i := 0; i < 100; i++ {
+		if getEntry() != e {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	e1 := getEntry()
+	if e1 == e {
+		t.Fatalf("DNS cache entry must be refreshed after DNSCacheDuration")
+	}
+	if !e1.resolveTime.After(e.resolveTime) {
+		t.Fatalf("unexpected resolve time %s. Must be after %s", e1.resolveTime, e.resolveTime)
+	}
+}
+
+func TestTCPDialerDNSNegativeCache(t *testing.T) {
+	testTCPDialerDNSNegativeCache(t, 0)
+	testTCPDialerDNSNegativeCache(t, time.Hour)
+}
+
+func testTCPDialerDNSNegativeCache(t *testing.T, negativeCacheDuration time.Duration) {
+	d := &TCPDialer{
+		DNSNegativeCacheDuration: negativeCacheDuration,
+	}
+
+	// The .invalid TLD is guaranteed to be never resolved by RFC 6761.
+	addr := "foobar.invalid:80"
+	_, err := d.Dial(addr)
+	if err == nil {
+		t.Fatalf("expecting non-nil error when dialing %q", addr)
+	}
+	_, err1 := d.Dial(addr)
+	if err1 == nil {
+		t.Fatalf("expecting non-nil error when dialing %q", addr)
+	}
+
+	cached := err1 == err
+	if negativeCacheDuration > 0 && !cached {
+		t.Fatalf("DNS lookup error must be cached. Got %v and %v", err, err1)
+	}
+	if negativeCacheDuration <= 0 && cached {
+		t.Fatalf("DNS lookup error mustn't be cached by default")
+	}
+}
+
+type testResolver struct {
+	hosts map[string][]string
+}
+
+func (r *testResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r.hosts[host]
+	if !ok {
+		return nil, fmt.Errorf("unknown host %q", host)
+	}
+	var ipAddrs []net.IPAddr
+	for _, ip := range ips {
+		ipAddrs = append(ipAddrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return ipAddrs, nil
+}
+
+func TestTCPDialerResolver(t *testing.T) {
+	ln := startTCPDialerTestServer(t)
+	defer ln.Close()
+
+	d := &TCPDialer{
+		Resolver: &testResolver{
+			hosts: map[string][]string{
+				"foobar.test": {"127.0.0.1"},
+			},
+		},
+	}
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	conn, err := d.Dial("foobar.test:" + port)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if conn.RemoteAddr().String() != ln.Addr().String() {
+		t.Fatalf("unexpected remote addr %q. Expecting %q", conn.RemoteAddr(), ln.Addr())
+	}
+	conn.Close()
+
+	if _, err = d.Dial("baz.test:" + port); err == nil {
+		t.Fatalf("expecting non-nil error for unknown host")
+	}
+}
+
+func TestTCPDialerDualStackFallback(t *testing.T) {
+	ln := startTCPDialerTestServer(t)
+	defer ln.Close()
+
+	// IPv6 address from the documentation prefix is unreachable,
+	// so the dialer must fall back to IPv4 address.
+	d := &TCPDialer{
+		Resolver: &testResolver{
+			hosts: map[string][]string{
+				"foobar.test": {"2001:db8::1", "127.0.0.1"},
+			},
+		},
+		FallbackDelay: 50 * time.Millisecond,
+	}
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	startTime := time.Now()
+	conn, err := d.DialDualStackTimeout("foobar.test:"+port, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if conn.RemoteAddr().String() != ln.Addr().String() {
+		t.Fatalf("unexpected remote addr %q. Expecting %q", conn.RemoteAddr(), ln.Addr())
+	}
+	conn.Close()
+	if d := time.Since(startTime); d > time.Second {
+		t.Fatalf("too long dial duration: %s", d)
+	}
+}
@@ -0,0 +1,32 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// setTCPKeepalive enables TCP keep-alive on the given connection
+// according to Server settings.
+//
+// Connections not backed by *net.TCPConn are left as is.
+func (s *Server) setTCPKeepalive(c net.Conn) error {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		c = tlsConn.NetConn()
+	}
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if err := tc.SetKeepAlive(true); err != nil {
+		return err
+	}
+	if s.TCPKeepalivePeriod > 0 {
+		if err := tc.SetKeepAlivePeriod(s.TCPKeepalivePeriod); err != nil {
+			return err
+		}
+	}
+	if s.TCPKeepaliveCount > 0 {
+		return setTCPKeepaliveCount(tc, s.TCPKeepaliveCount)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+// +build dragonfly freebsd illumos linux netbsd solaris
+
+package fasthttp
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// setTCPKeepaliveCount sets the number of unanswered keep-alive probes
+// before the kernel closes the connection.
+func setTCPKeepaliveCount(tc *net.TCPConn, count int) error {
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+	})
+	if err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return os.NewSyscallError("setsockopt", sockErr)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+// +build !dragonfly,!freebsd,!illumos,!linux,!netbsd,!solaris
+
+package fasthttp
+
+import (
+	"net"
+)
+
+// setTCPKeepaliveCount does nothing, since TCP_KEEPCNT socket option
+// isn't supported on this platform.
+func setTCPKeepaliveCount(tc *net.TCPConn, count int) error {
+	return nil
+}
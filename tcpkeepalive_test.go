@@ -0,0 +1,58 @@
+// +build linux
+
+package fasthttp
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServerTCPKeepalive(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			rc, err := ctx.c.(*net.TCPConn).SyscallConn()
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+				return
+			}
+			rc.Control(func(fd uintptr) {
+				var opts [3]int
+				for i, opt := range []struct {
+					level, name int
+				}{
+					{syscall.SOL_SOCKET, syscall.SO_KEEPALIVE},
+					{syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE},
+					{syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT},
+				} {
+					if opts[i], err = syscall.GetsockoptInt(int(fd), opt.level, opt.name); err != nil {
+						t.Errorf("unexpected error: %s", err)
+					}
+				}
+				fmt.Fprintf(ctx, "%v", opts)
+			})
+		},
+		TCPKeepalive:       true,
+		TCPKeepalivePeriod: 7 * time.Second,
+		TCPKeepaliveCount:  3,
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+	go s.Serve(ln)
+
+	statusCode, body, err := Get(nil, "http://"+ln.Addr().String()+"/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusOK)
+	}
+	if string(body) != "[1 7 3]" {
+		t.Fatalf("unexpected socket options %q. Expecting %q", body, "[1 7 3]")
+	}
+}
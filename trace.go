@@ -0,0 +1,102 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// ClientTrace is a set of hooks called at various stages of outgoing
+// HTTP/1.1 request performed by Client or HostClient.
+//
+// Attach it to the request via Request.SetTrace. Any of the hooks
+// may be nil.
+//
+// DNS hooks are called only if the default dialer resolves the host,
+// i.e. they aren't called for cached DNS entries and custom Dial.
+// Connect hooks may be called concurrently from multiple goroutines
+// when DialDualStack dials multiple addresses in parallel.
+type ClientTrace struct {
+	// GotConn is called after the connection for the request is obtained.
+	//
+	// reused is set to true if the connection has been taken from the pool
+	// of idle keep-alive connections.
+	GotConn func(conn net.Conn, reused bool)
+
+	// DNSStart is called before DNS lookup for the given host.
+	DNSStart func(host string)
+
+	// DNSDone is called after DNS lookup is finished.
+	DNSDone func(addrs []net.IPAddr, err error)
+
+	// ConnectStart is called before dialing the given address.
+	ConnectStart func(network, addr string)
+
+	// ConnectDone is called after dialing the given address is finished.
+	ConnectDone func(network, addr string, err error)
+
+	// TLSHandshakeStart is called before TLS handshake.
+	TLSHandshakeStart func()
+
+	// TLSHandshakeDone is called after TLS handshake is finished.
+	TLSHandshakeDone func(state tls.ConnectionState, err error)
+
+	// WroteRequest is called after the request is written and flushed
+	// to the connection.
+	WroteRequest func(err error)
+
+	// GotFirstResponseByte is called when the first byte of the response
+	// is available.
+	GotFirstResponseByte func()
+}
+
+func (t *ClientTrace) gotConn(conn net.Conn, reused bool) {
+	if t != nil && t.GotConn != nil {
+		t.GotConn(conn, reused)
+	}
+}
+
+func (t *ClientTrace) dnsStart(host string) {
+	if t != nil && t.DNSStart != nil {
+		t.DNSStart(host)
+	}
+}
+
+func (t *ClientTrace) dnsDone(addrs []net.IPAddr, err error) {
+	if t != nil && t.DNSDone != nil {
+		t.DNSDone(addrs, err)
+	}
+}
+
+func (t *ClientTrace) connectStart(network, addr string) {
+	if t != nil && t.ConnectStart != nil {
+		t.ConnectStart(network, addr)
+	}
+}
+
+func (t *ClientTrace) connectDone(network, addr string, err error) {
+	if t != nil && t.ConnectDone != nil {
+		t.ConnectDone(network, addr, err)
+	}
+}
+
+func (t *ClientTrace) tlsHandshakeStart() {
+	if t != nil && t.TLSHandshakeStart != nil {
+		t.TLSHandshakeStart()
+	}
+}
+
+func (t *ClientTrace) tlsHandshakeDone(state tls.ConnectionState, err error) {
+	if t != nil && t.TLSHandshakeDone != nil {
+		t.TLSHandshakeDone(state, err)
+	}
+}
+
+func (t *ClientTrace) wroteRequest(err error) {
+	if t != nil && t.WroteRequest != nil {
+		t.WroteRequest(err)
+	}
+}
+
+func (t *ClientTrace) hasGotFirstResponseByte() bool {
+	return t != nil && t.GotFirstResponseByte != nil
+}
@@ -0,0 +1,114 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClientTrace(t *testing.T) {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "ok")
+	}))
+	s.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	s.StartTLS()
+	defer s.Close()
+
+	_, port, err := net.SplitHostPort(s.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	addr := "localhost:" + port
+
+	var eventsLock sync.Mutex
+	var events []string
+	addEvent := func(format string, args ...interface{}) {
+		eventsLock.Lock()
+		events = append(events, fmt.Sprintf(format, args...))
+		eventsLock.Unlock()
+	}
+	trace := &ClientTrace{
+		GotConn: func(conn net.Conn, reused bool) {
+			addEvent("GotConn(reused=%v)", reused)
+		},
+		DNSStart: func(host string) {
+			addEvent("DNSStart(%s)", host)
+		},
+		DNSDone: func(addrs []net.IPAddr, err error) {
+			addEvent("DNSDone(err=%v)", err)
+		},
+		ConnectStart: func(network, addr string) {
+			addEvent("ConnectStart(%s)", network)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			addEvent("ConnectDone(%s, err=%v)", network, err)
+		},
+		TLSHandshakeStart: func() {
+			addEvent("TLSHandshakeStart")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			addEvent("TLSHandshakeDone(err=%v)", err)
+		},
+		WroteRequest: func(err error) {
+			addEvent("WroteRequest(err=%v)", err)
+		},
+		GotFirstResponseByte: func() {
+			addEvent("GotFirstResponseByte")
+		},
+	}
+
+	c := &HostClient{
+		Addr:                  addr,
+		IsTLS:                 true,
+		InsecureSkipTLSVerify: true,
+	}
+	expectedEvents := []string{
+		"DNSStart(localhost)",
+		"DNSDone(err=<nil>)",
+		"ConnectStart(tcp4)",
+		"ConnectDone(tcp4, err=<nil>)",
+		"TLSHandshakeStart",
+		"TLSHandshakeDone(err=<nil>)",
+		"GotConn(reused=false)",
+		"WroteRequest(err=<nil>)",
+		"GotFirstResponseByte",
+	}
+	testClientTrace(t, c, "https://"+addr+"/", trace, &events, expectedEvents)
+
+	// The connection must be re-used for the second request.
+	events = events[:0]
+	expectedEvents = []string{
+		"GotConn(reused=true)",
+		"WroteRequest(err=<nil>)",
+		"GotFirstResponseByte",
+	}
+	testClientTrace(t, c, "https://"+addr+"/", trace, &events, expectedEvents)
+}
+
+func testClientTrace(t *testing.T, c *HostClient, uri string, trace *ClientTrace, events *[]string, expectedEvents []string) {
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+
+	req.SetRequestURI(uri)
+	req.SetTrace(trace)
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+	}
+	result := strings.Join(*events, "\n")
+	expected := strings.Join(expectedEvents, "\n")
+	if result != expected {
+		t.Fatalf("unexpected trace events:\n%s\nExpecting:\n%s", result, expected)
+	}
+}
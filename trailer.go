@@ -0,0 +1,264 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrBadTrailer is returned when adding a header field, which mustn't
+// be sent in trailer, to the list of trailer fields.
+var ErrBadTrailer = errors.New("the header field cannot be sent in trailer")
+
+// forbiddenTrailers contains header fields, which mustn't be sent in trailer.
+//
+// See https://tools.ietf.org/html/rfc7230#section-4.1.2 for details.
+var forbiddenTrailers = [][]byte{
+	strTransferEncoding,
+	strContentLength,
+	strContentType,
+	strContentEncoding,
+	strContentRange,
+	strHost,
+	strConnection,
+	strKeepAliveCamelCase,
+	strUpgrade,
+	strTrailer,
+	strExpect,
+	strRange,
+	strAuthorization,
+	strCacheControl,
+	strCookie,
+	strSetCookie,
+	strDate,
+	strAge,
+	strExpires,
+	strLocation,
+	strVary,
+	[]byte("Te"),
+	[]byte("Max-Forwards"),
+	[]byte("Pragma"),
+	[]byte("Proxy-Authenticate"),
+	[]byte("Proxy-Authorization"),
+	[]byte("Www-Authenticate"),
+	[]byte("Retry-After"),
+	[]byte("Warning"),
+}
+
+func isForbiddenTrailer(key []byte) bool {
+	for _, k := range forbiddenTrailers {
+		if bytes.EqualFold(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTrailer adds the given header field to the list of trailer fields
+// declared in 'Trailer' response header.
+//
+// Values for trailer fields are set via Set* and Add* methods as for usual
+// header fields before returning from RequestHandler. The body stream
+// passed to SetBodyStream may also set them when the stream is read till
+// the end, e.g. for sending the checksum of the streamed body, since
+// the stream is read by the goroutine writing the response. Note that
+// the writer passed to SetBodyStreamWriter is run in another goroutine,
+// so it mustn't set trailer fields.
+//
+// Trailer fields are sent after the body if the response body is sent
+// in chunked encoding, i.e. if the body is streamed and its' size is
+// unknown. Otherwise they are sent as usual header fields.
+//
+// ErrBadTrailer is returned if the field mustn't be sent in trailer,
+// e.g. for Content-Length or Host.
+func (h *ResponseHeader) AddTrailer(key string) error {
+	return h.AddTrailerBytes(s2b(key))
+}
+
+// AddTrailerBytes adds the given header field to the list of trailer fields
+// declared in 'Trailer' response header.
+//
+// See AddTrailer for details.
+func (h *ResponseHeader) AddTrailerBytes(key []byte) error {
+	v, err := appendTrailerKey(h.bufKV.value[:0], &h.bufKV.key, peekArgBytes(h.h, strTrailer), key, h.disableNormalizing)
+	if err != nil {
+		return err
+	}
+	h.bufKV.value = v
+	h.h = setArgBytes(h.h, strTrailer, v)
+	return nil
+}
+
+// AddTrailer adds the given header field to the list of trailer fields
+// declared in 'Trailer' request header.
+//
+// Trailer fields are sent after the body if the request body is sent
+// in chunked encoding, i.e. if the body is streamed and its' size is
+// unknown. Otherwise they are sent as usual header fields.
+//
+// ErrBadTrailer is returned if the field mustn't be sent in trailer,
+// e.g. for Content-Length or Host.
+func (h *RequestHeader) AddTrailer(key string) error {
+	return h.AddTrailerBytes(s2b(key))
+}
+
+// AddTrailerBytes adds the given header field to the list of trailer fields
+// declared in 'Trailer' request header.
+//
+// See AddTrailer for details.
+func (h *RequestHeader) AddTrailerBytes(key []byte) error {
+	h.parseRawHeaders()
+	v, err := appendTrailerKey(h.bufKV.value[:0], &h.bufKV.key, peekArgBytes(h.h, strTrailer), key, h.disableNormalizing)
+	if err != nil {
+		return err
+	}
+	h.bufKV.value = v
+	h.h = setArgBytes(h.h, strTrailer, v)
+	return nil
+}
+
+// appendTrailerKey appends the normalized key to the list of trailer
+// fields and returns the resulting list.
+//
+// keyBuf is used as a buffer for the normalized key.
+func appendTrailerKey(dst []byte, keyBuf *[]byte, trailer, key []byte, disableNormalizing bool) ([]byte, error) {
+	k := append((*keyBuf)[:0], key...)
+	normalizeHeaderKey(k, disableNormalizing)
+	*keyBuf = k
+	if len(k) == 0 || isForbiddenTrailer(k) {
+		return dst, ErrBadTrailer
+	}
+	dst = append(dst, trailer...)
+	if hasHeaderValueFold(trailer, k) {
+		return dst, nil
+	}
+	if len(dst) > 0 {
+		dst = append(dst, ", "...)
+	}
+	return append(dst, k...), nil
+}
+
+// isTrailer returns true if the given key is declared in trailer.
+func isTrailer(trailer, key []byte) bool {
+	return len(trailer) > 0 && !bytes.Equal(key, strTrailer) && hasHeaderValueFold(trailer, key)
+}
+
+// skipTrailerField returns true if the header field with the given key
+// mustn't be written in header.
+//
+// Trailer fields are written after chunked body, while 'Trailer' header
+// is written only for chunked body, since other bodies have no trailer.
+func skipTrailerField(trailer, key []byte, chunked bool) bool {
+	if len(trailer) == 0 {
+		return false
+	}
+	if chunked {
+		return isTrailer(trailer, key)
+	}
+	return bytes.Equal(key, strTrailer)
+}
+
+func (h *ResponseHeader) readTrailer(r *bufio.Reader) error {
+	var err error
+	h.h, err = readTrailer(r, h.h, h.disableNormalizing)
+	return err
+}
+
+func (h *RequestHeader) readTrailer(r *bufio.Reader) error {
+	h.parseRawHeaders()
+	var err error
+	h.h, err = readTrailer(r, h.h, h.disableNormalizing)
+	return err
+}
+
+// trailer returns trailer representation, which is sent after the last
+// chunk of chunked body.
+//
+// The returned value is valid until the next call to ResponseHeader methods.
+func (h *ResponseHeader) trailer() []byte {
+	h.bufKV.value = appendTrailer(h.bufKV.value[:0], h.h)
+	return h.bufKV.value
+}
+
+// trailer returns trailer representation, which is sent after the last
+// chunk of chunked body.
+//
+// The returned value is valid until the next call to RequestHeader methods.
+func (h *RequestHeader) trailer() []byte {
+	h.bufKV.value = appendTrailer(h.bufKV.value[:0], h.h)
+	return h.bufKV.value
+}
+
+// trailerReader reads trailer fields after the last chunk of chunked body.
+type trailerReader interface {
+	readTrailer(r *bufio.Reader) error
+}
+
+// appendTrailer appends trailer fields declared in 'Trailer' header
+// to dst and returns the extended dst.
+func appendTrailer(dst []byte, h []argsKV) []byte {
+	trailer := peekArgBytes(h, strTrailer)
+	for i, n := 0, len(h); i < n; i++ {
+		kv := &h[i]
+		if isTrailer(trailer, kv.key) {
+			dst = appendHeaderLine(dst, kv.key, kv.value)
+		}
+	}
+	return append(dst, strCRLF...)
+}
+
+// readTrailer reads trailer fields following the last chunk of chunked body
+// from r and appends them to dst.
+//
+// Fields, which mustn't be sent in trailer, are skipped.
+func readTrailer(r *bufio.Reader, dst []argsKV, disableNormalizing bool) ([]argsKV, error) {
+	n := 1
+	for {
+		b, err := r.Peek(n)
+		if len(b) == 0 {
+			return dst, fmt.Errorf("cannot read trailer: %s", err)
+		}
+		b = mustPeekBuffered(r)
+
+		dstLen := len(dst)
+		var s headerScanner
+		s.b = b
+		s.disableNormalizing = disableNormalizing
+		for s.next() {
+			if !isForbiddenTrailer(s.key) {
+				dst = appendArgBytes(dst, s.key, s.value)
+			}
+		}
+		if s.err == nil {
+			mustDiscard(r, len(b)-len(s.b))
+			return dst, nil
+		}
+
+		// The trailer is incomplete, so read it again after obtaining
+		// more data.
+		dst = dst[:dstLen]
+		if err != nil {
+			if err == bufio.ErrBufferFull {
+				err = errSmallBuffer
+			}
+			return dst, fmt.Errorf("cannot read trailer: %s. Buffer size=%d, contents: %s", err, len(b), bufferSnippet(b))
+		}
+		n = r.Buffered() + 1
+	}
+}
+
+// writeLastChunk writes the last chunk of chunked body followed
+// by the given trailer.
+func writeLastChunk(w *bufio.Writer, trailer []byte) error {
+	w.Write(strLastChunk)
+	if len(trailer) == 0 {
+		trailer = strCRLF
+	}
+	_, err := w.Write(trailer)
+	err1 := w.Flush()
+	if err == nil {
+		err = err1
+	}
+	return err
+}
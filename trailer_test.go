@@ -0,0 +1,228 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestHeaderAddTrailer(t *testing.T) {
+	var h ResponseHeader
+	if err := h.AddTrailer("grpc-status"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := h.AddTrailerBytes([]byte("Grpc-Message")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := h.AddTrailer("GRPC-STATUS"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, key := range []string{"Content-Length", "host", "Transfer-Encoding", "Trailer", ""} {
+		if err := h.AddTrailer(key); err != ErrBadTrailer {
+			t.Fatalf("unexpected error for %q: %v. Expecting %v", key, err, ErrBadTrailer)
+		}
+	}
+	if v := string(h.Peek("Trailer")); v != "Grpc-Status, Grpc-Message" {
+		t.Fatalf("unexpected trailer %q. Expecting %q", v, "Grpc-Status, Grpc-Message")
+	}
+
+	var req RequestHeader
+	if err := req.AddTrailer("x-checksum"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v := string(req.Peek("Trailer")); v != "X-Checksum" {
+		t.Fatalf("unexpected trailer %q. Expecting %q", v, "X-Checksum")
+	}
+}
+
+func TestResponseWriteTrailer(t *testing.T) {
+	var resp Response
+	resp.Header.AddTrailer("Grpc-Status")
+	resp.Header.Set("Grpc-Status", "0")
+	resp.Header.Set("X-Foo", "bar")
+
+	// The trailer is sent as usual header for the body with known size.
+	resp.SetBodyString("foobar")
+	s := resp.String()
+	if strings.Contains(s, "Trailer:") {
+		t.Fatalf("unexpected 'Trailer' header in %q", s)
+	}
+	if !strings.Contains(s, "\r\nGrpc-Status: 0\r\n") {
+		t.Fatalf("missing 'Grpc-Status' header in %q", s)
+	}
+
+	// The trailer is sent after chunked body.
+	resp.SetBodyStream(bytes.NewBufferString("foobar"), -1)
+	s = resp.String()
+	n := strings.Index(s, "\r\n\r\n")
+	header, body := s[:n+4], s[n+4:]
+	if !strings.Contains(header, "\r\nTrailer: Grpc-Status\r\n") {
+		t.Fatalf("missing 'Trailer' header in %q", header)
+	}
+	if strings.Contains(header, "Grpc-Status: 0") {
+		t.Fatalf("unexpected trailer field in header %q", header)
+	}
+	expectedBody := "6\r\nfoobar\r\n0\r\nGrpc-Status: 0\r\n\r\n"
+	if body != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+	}
+
+	// The trailer must be read back.
+	var resp1 Response
+	if err := resp1.Read(bufio.NewReader(strings.NewReader(s))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp1.Body()) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp1.Body(), "foobar")
+	}
+	if v := string(resp1.Header.Peek("Grpc-Status")); v != "0" {
+		t.Fatalf("unexpected trailer value %q. Expecting %q", v, "0")
+	}
+}
+
+func TestServerRequestTrailer(t *testing.T) {
+	testServerRequestTrailer(t, false)
+	testServerRequestTrailer(t, true)
+}
+
+func testServerRequestTrailer(t *testing.T, stream bool) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if !ctx.IsPost() {
+				// The request following the request with trailer.
+				ctx.WriteString("ok")
+				return
+			}
+			body, err := ioutil.ReadAll(ctx.RequestBodyStream())
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if string(body) != "foobar" {
+				t.Errorf("unexpected body %q. Expecting %q", body, "foobar")
+			}
+			if v := string(ctx.Request.Header.Peek("X-Checksum")); v != "abc" {
+				t.Errorf("unexpected trailer value %q. Expecting %q", v, "abc")
+			}
+			if v := string(ctx.Request.Header.Peek("Host")); v != "aaa" {
+				t.Errorf("forbidden trailer field mustn't override header. Got %q. Expecting %q", v, "aaa")
+			}
+			ctx.WriteString("ok")
+		},
+		StreamRequestBody: stream,
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("POST / HTTP/1.1\r\nHost: aaa\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+		"3\r\nfoo\r\n3\r\nbar\r\n0\r\nX-Checksum: abc\r\nHost: bbb\r\n\r\n" +
+		"GET / HTTP/1.1\r\nHost: aaa\r\nConnection: close\r\n\r\n")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "ok")
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "ok")
+}
+
+// trailerBodyStream sets the trailer of the response when the body
+// is read till the end.
+type trailerBodyStream struct {
+	r      io.Reader
+	header *ResponseHeader
+}
+
+func (bs *trailerBodyStream) Read(p []byte) (int, error) {
+	n, err := bs.r.Read(p)
+	if err == io.EOF {
+		bs.header.Set("Grpc-Status", "0")
+	}
+	return n, err
+}
+
+func TestServerResponseTrailer(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if err := ctx.Response.Header.AddTrailer("Grpc-Status"); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			ctx.Response.Header.Set("Grpc-Message", "foo")
+			ctx.SetBodyStream(&trailerBodyStream{
+				r:      strings.NewReader("foobar"),
+				header: &ctx.Response.Header,
+			}, -1)
+		},
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("GET / HTTP/1.1\r\nHost: aaa\r\n\r\n")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var resp Response
+	if err := resp.Read(bufio.NewReader(&rw.w)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "foobar")
+	}
+	if v := string(resp.Header.Peek("Grpc-Status")); v != "0" {
+		t.Fatalf("unexpected trailer value %q. Expecting %q", v, "0")
+	}
+	if v := string(resp.Header.Peek("Grpc-Message")); v != "foo" {
+		t.Fatalf("unexpected header value %q. Expecting %q", v, "foo")
+	}
+}
+
+func TestServerTrailerHTTP2(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if v := string(ctx.Request.Header.Peek("X-Checksum")); v != "abc" {
+				t.Errorf("unexpected trailer value %q. Expecting %q", v, "abc")
+			}
+			ctx.Response.Header.AddTrailer("Grpc-Status")
+			ctx.SetBodyStream(&trailerBodyStream{
+				r:      strings.NewReader("foobar"),
+				header: &ctx.Response.Header,
+			}, -1)
+		},
+		EnableHTTP2: true,
+	}
+	ln, stop := testALPNServer(t, s)
+	defer stop()
+
+	hc := &http.Client{
+		Transport: &http2.Transport{
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dialALPN(t, ln, http2.NextProtoTLS), nil
+			},
+		},
+	}
+	req, err := http.NewRequest("POST", "https://foobar/", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	req.Trailer = http.Header{"X-Checksum": []string{"abc"}}
+	resp, err := hc.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "foobar")
+	}
+	if v := resp.Trailer.Get("Grpc-Status"); v != "0" {
+		t.Fatalf("unexpected trailer value %q. Expecting %q", v, "0")
+	}
+}
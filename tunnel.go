@@ -0,0 +1,149 @@
+package fasthttp
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Tunnel establishes a tunnel to the given target address via CONNECT
+// request sent to the host (usually HTTP proxy) from HostClient.Addr.
+//
+// The target must be in the form host:port. req may contain additional
+// CONNECT request headers such as Proxy-Authorization. req method
+// and request uri are ignored. req may be nil.
+//
+// The raw connection to the target is returned if the host responds
+// with 2xx status code. The connection isn't counted in MaxConns
+// and must be closed by the caller. Bytes sent by the target
+// and buffered while reading the CONNECT response are returned
+// by the connection, so any protocol (e.g. TLS via tls.Client) may be used
+// over the connection.
+//
+// resp is filled with the CONNECT response if it isn't nil.
+// The response body is read only for non-2xx responses.
+//
+// The whole CONNECT request is limited by the given timeout if it
+// is positive. The returned connection has no deadlines.
+func (c *HostClient) Tunnel(target string, req *Request, resp *Response, timeout time.Duration) (net.Conn, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	conn, addrIdx, err := c.dialHostHard(deadline, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Tunnel connections aren't returned to the pool.
+	c.decAddrRequestsIdx(addrIdx)
+
+	if err = conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err = c.writeConnectRequest(conn, target, req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if resp == nil {
+		resp = AcquireResponse()
+		defer ReleaseResponse(resp)
+	}
+	resp.Reset()
+	if c.DisableHeaderNamesNormalizing {
+		resp.Header.DisableNormalizing()
+	}
+	br := c.acquireReader(conn)
+	if err = resp.Header.Read(br); err != nil {
+		c.releaseReader(br)
+		conn.Close()
+		return nil, err
+	}
+	statusCode := resp.Header.StatusCode()
+	if statusCode < 200 || statusCode >= 300 {
+		if !resp.Header.mustSkipContentLength() {
+			bodyBuf := resp.bodyBuffer()
+			bodyBuf.B, err = readBody(br, resp.Header.ContentLength(), c.MaxResponseBodySize, bodyBuf.B[:0], &resp.Header)
+		}
+		c.releaseReader(br)
+		conn.Close()
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("cannot establish tunnel to %q: unexpected status code %d", target, statusCode)
+	}
+
+	// Do not lose bytes sent by the target right after the CONNECT response.
+	var buffered []byte
+	if n := br.Buffered(); n > 0 {
+		b, _ := br.Peek(n)
+		buffered = append(buffered, b...)
+	}
+	c.releaseReader(br)
+
+	if err = conn.SetDeadline(zeroTime); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if len(buffered) > 0 {
+		conn = &tunnelConn{
+			Conn:     conn,
+			buffered: buffered,
+		}
+	}
+	return conn, nil
+}
+
+func (c *HostClient) writeConnectRequest(conn net.Conn, target string, req *Request) error {
+	// The request is built manually, since CONNECT request has no body,
+	// so Content-Type and Content-Length headers mustn't be sent.
+	userAgent := c.getClientName()
+	if req != nil && len(req.Header.UserAgent()) > 0 {
+		userAgent = req.Header.UserAgent()
+	}
+	var b []byte
+	b = append(b, strConnect...)
+	b = append(b, ' ')
+	b = append(b, target...)
+	b = append(b, ' ')
+	b = append(b, strHTTP11...)
+	b = append(b, strCRLF...)
+	b = appendHeaderLine(b, strHost, s2b(target))
+	b = appendHeaderLine(b, strUserAgent, userAgent)
+	if req != nil {
+		req.Header.VisitAll(func(key, value []byte) {
+			switch string(key) {
+			case "Host", "User-Agent", "Content-Type", "Content-Length":
+			default:
+				b = appendHeaderLine(b, key, value)
+			}
+		})
+	}
+	b = append(b, strCRLF...)
+
+	bw := c.acquireWriter(conn)
+	_, err := bw.Write(b)
+	if err == nil {
+		err = bw.Flush()
+	}
+	c.releaseWriter(bw)
+	return err
+}
+
+// tunnelConn returns bytes buffered while reading CONNECT response
+// before reading from the underlying connection.
+type tunnelConn struct {
+	net.Conn
+	buffered []byte
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	if len(c.buffered) > 0 {
+		n := copy(p, c.buffered)
+		c.buffered = c.buffered[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
@@ -0,0 +1,122 @@
+package fasthttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func startTunnelTestProxy(t *testing.T, ln net.Listener) {
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				br := bufio.NewReader(conn)
+				var h RequestHeader
+				if err := h.Read(br); err != nil {
+					t.Errorf("unexpected error: %s", err)
+					return
+				}
+				if string(h.Method()) != "CONNECT" {
+					t.Errorf("unexpected method %q. Expecting %q", h.Method(), "CONNECT")
+				}
+				if string(h.RequestURI()) != "example.com:443" {
+					t.Errorf("unexpected request uri %q. Expecting %q", h.RequestURI(), "example.com:443")
+				}
+				if string(h.Host()) != "example.com:443" {
+					t.Errorf("unexpected host %q. Expecting %q", h.Host(), "example.com:443")
+				}
+				if len(h.ContentType()) > 0 || len(h.Peek("Content-Length")) > 0 {
+					t.Errorf("unexpected body headers in CONNECT request: %q", h.Header())
+				}
+				if string(h.Peek("Proxy-Authorization")) != "Basic Zm9vOmJhcg==" {
+					conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 13\r\n\r\nauth required"))
+					return
+				}
+
+				// The greeting is sent together with the response
+				// in order to verify buffered bytes aren't lost.
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\nhello"))
+				io.Copy(conn, br)
+			}(conn)
+		}
+	}()
+}
+
+func TestHostClientTunnel(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	startTunnelTestProxy(t, ln)
+
+	c := &HostClient{
+		Addr: "proxy",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	var req Request
+	var resp Response
+	req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	conn, err := c.Tunnel("example.com:443", &req, &resp, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("unexpected greeting %q. Expecting %q", buf, "hello")
+	}
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	buf = buf[:4]
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("unexpected echo %q. Expecting %q", buf, "ping")
+	}
+}
+
+func TestHostClientTunnelError(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	startTunnelTestProxy(t, ln)
+
+	c := &HostClient{
+		Addr: "proxy",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	var resp Response
+	conn, err := c.Tunnel("example.com:443", nil, &resp, time.Second)
+	if err == nil {
+		conn.Close()
+		t.Fatalf("expecting non-nil error")
+	}
+	if resp.StatusCode() != StatusProxyAuthRequired {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusProxyAuthRequired)
+	}
+	if string(resp.Body()) != "auth required" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "auth required")
+	}
+}
@@ -0,0 +1,152 @@
+package fasthttp
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"sync/atomic"
+)
+
+// webSocketGUID is appended to Sec-WebSocket-Key when calculating
+// Sec-WebSocket-Accept. See RFC 6455, section 1.3.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	errWebSocketMethod   = errors.New("WebSocket handshake requires GET request")
+	errWebSocketProtocol = errors.New("WebSocket handshake requires HTTP/1.1 request")
+	errWebSocketUpgrade  = errors.New("missing 'Upgrade: websocket' header in WebSocket handshake")
+	errWebSocketConn     = errors.New("missing 'Connection: Upgrade' header in WebSocket handshake")
+	errWebSocketKey      = errors.New("invalid Sec-WebSocket-Key header in WebSocket handshake")
+	errWebSocketVersion  = errors.New("unsupported Sec-WebSocket-Version in WebSocket handshake")
+	errWebSocketShutdown = errors.New("cannot upgrade connection to WebSocket, since the server is shutting down")
+)
+
+// UpgradeWebSocket validates WebSocket opening handshake from the request
+// and switches the connection to WebSocket protocol.
+// See RFC 6455, section 4.2 for details.
+//
+// The response is set to '101 Switching Protocols' with the required
+// headers, and the handler is registered via Hijack. The handler is called
+// with the connection after the response is sent. Data sent by the client
+// after the handshake and already read by the server is returned first
+// from the connection Read calls. WebSocket frames must be processed
+// by the handler.
+//
+// protocols contains sub-protocols supported by the server. The first
+// protocol requested by the client, which is contained in protocols,
+// is selected and sent in Sec-WebSocket-Protocol response header.
+// The selected protocol may be obtained via
+// ctx.Response.Header.Peek("Sec-WebSocket-Protocol") before returning
+// from RequestHandler.
+//
+// An error is returned if the handshake is invalid. The response status
+// is set to the appropriate error code in this case, while the connection
+// isn't upgraded.
+//
+// Unlike connections hijacked via Hijack, upgraded connections are tracked
+// by Server.Shutdown until the handler returns. The connection is upgraded
+// regardless of Server.DisableKeepalive, Server.MaxRequestsPerConn
+// and 'Connection: close' request header.
+func (ctx *RequestCtx) UpgradeWebSocket(protocols []string, handler HijackHandler) error {
+	key, err := validateWebSocketHandshake(&ctx.Request.Header)
+	if err == nil && atomic.LoadInt32(&ctx.s.stop) == 1 {
+		err = errWebSocketShutdown
+	}
+	if err != nil {
+		switch err {
+		case errWebSocketVersion:
+			ctx.Error(err.Error(), StatusUpgradeRequired)
+			ctx.Response.Header.SetBytesV("Sec-WebSocket-Version", strWebSocketVersion)
+		case errWebSocketMethod:
+			ctx.Error(err.Error(), StatusMethodNotAllowed)
+		case errWebSocketShutdown:
+			ctx.Error(err.Error(), StatusServiceUnavailable)
+		default:
+			ctx.Error(err.Error(), StatusBadRequest)
+		}
+		return err
+	}
+
+	ctx.Response.Reset()
+	ctx.Response.Header.SetServerBytes(ctx.s.getServerName())
+	ctx.SetStatusCode(StatusSwitchingProtocols)
+	h := &ctx.Response.Header
+	h.SetCanonical(strUpgrade, strWebSocket)
+	h.SetCanonical(strConnection, strUpgrade)
+	h.SetBytesV("Sec-WebSocket-Accept", appendWebSocketAccept(nil, key))
+	if protocol := selectWebSocketProtocol(&ctx.Request.Header, protocols); len(protocol) > 0 {
+		h.Set("Sec-WebSocket-Protocol", protocol)
+	}
+
+	ctx.Hijack(handler)
+	ctx.hijackTrack = true
+	return nil
+}
+
+// validateWebSocketHandshake returns Sec-WebSocket-Key if h contains
+// valid WebSocket opening handshake.
+func validateWebSocketHandshake(h *RequestHeader) ([]byte, error) {
+	if !h.IsGet() {
+		return nil, errWebSocketMethod
+	}
+	if !h.IsHTTP11() {
+		return nil, errWebSocketProtocol
+	}
+	if !hasHeaderValueFold(h.PeekBytes(strUpgrade), strWebSocket) {
+		return nil, errWebSocketUpgrade
+	}
+	if !hasHeaderValueFold(h.PeekBytes(strConnection), strUpgrade) {
+		return nil, errWebSocketConn
+	}
+	if !bytes.Equal(h.Peek("Sec-WebSocket-Version"), strWebSocketVersion) {
+		return nil, errWebSocketVersion
+	}
+	key := h.Peek("Sec-WebSocket-Key")
+	if base64.StdEncoding.DecodedLen(len(key)) < 16 {
+		return nil, errWebSocketKey
+	}
+	var buf [18]byte
+	if n, err := base64.StdEncoding.Decode(buf[:], key); err != nil || n != 16 {
+		return nil, errWebSocketKey
+	}
+	return key, nil
+}
+
+// appendWebSocketAccept appends Sec-WebSocket-Accept value
+// for the given Sec-WebSocket-Key to dst.
+func appendWebSocketAccept(dst, key []byte) []byte {
+	h := sha1.New()
+	h.Write(key)
+	h.Write([]byte(webSocketGUID))
+	var sum [sha1.Size]byte
+	n := len(dst)
+	dst = append(dst, make([]byte, base64.StdEncoding.EncodedLen(sha1.Size))...)
+	base64.StdEncoding.Encode(dst[n:], h.Sum(sum[:0]))
+	return dst
+}
+
+// selectWebSocketProtocol returns the first sub-protocol requested
+// in h, which is contained in protocols.
+func selectWebSocketProtocol(h *RequestHeader, protocols []string) string {
+	if len(protocols) == 0 {
+		return ""
+	}
+	var selected string
+	h.VisitAll(func(key, value []byte) {
+		if len(selected) > 0 || !bytes.EqualFold(key, strSecWebSocketProtocol) {
+			return
+		}
+		var vs headerValueScanner
+		vs.b = value
+		for vs.next() {
+			for _, p := range protocols {
+				if string(vs.value) == p {
+					selected = p
+					return
+				}
+			}
+		}
+	})
+	return selected
+}
@@ -0,0 +1,201 @@
+package fasthttp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func testWebSocketServer(t *testing.T, ln net.Listener) *Server {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			err := ctx.UpgradeWebSocket([]string{"chat", "superchat"}, func(c net.Conn) {
+				// Echo the received data.
+				io.Copy(c, c)
+			})
+			if err != nil {
+				t.Logf("cannot upgrade connection: %s", err)
+			}
+		},
+	}
+	go s.Serve(ln)
+	return s
+}
+
+func testWebSocketHandshake(t *testing.T, conn net.Conn, req string) (*bufio.Reader, *Response) {
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(conn)
+	resp := &Response{}
+	if err := resp.Header.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return br, resp
+}
+
+func TestRequestCtxUpgradeWebSocket(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	testWebSocketServer(t, ln)
+	defer ln.Close()
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	// The data following the handshake must be passed to the handler.
+	br, resp := testWebSocketHandshake(t, conn, "GET /chat HTTP/1.1\r\nHost: server.example.com\r\n"+
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Protocol: superchat, chat\r\n\r\nhello")
+	if resp.StatusCode() != StatusSwitchingProtocols {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusSwitchingProtocols)
+	}
+	expectedHeaders := map[string]string{
+		"Upgrade":                "websocket",
+		"Connection":             "Upgrade",
+		"Sec-WebSocket-Accept":   "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=",
+		"Sec-WebSocket-Protocol": "superchat",
+		"Content-Length":         "",
+	}
+	for k, v := range expectedHeaders {
+		if s := string(resp.Header.Peek(k)); s != v {
+			t.Fatalf("unexpected %s header %q. Expecting %q", k, s, v)
+		}
+	}
+
+	buf := make([]byte, len("hello world"))
+	if _, err = conn.Write([]byte(" world")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = io.ReadFull(br, buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(buf) != "hello world" {
+		t.Fatalf("unexpected data %q. Expecting %q", buf, "hello world")
+	}
+}
+
+func TestRequestCtxUpgradeWebSocketInvalidHandshake(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	testWebSocketServer(t, ln)
+	defer ln.Close()
+
+	testInvalidHandshake := func(req string, expectedStatusCode int) {
+		t.Helper()
+		conn, err := ln.Dial()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer conn.Close()
+		_, resp := testWebSocketHandshake(t, conn, req)
+		if resp.StatusCode() != expectedStatusCode {
+			t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), expectedStatusCode)
+		}
+		if expectedStatusCode == StatusUpgradeRequired {
+			if v := string(resp.Header.Peek("Sec-WebSocket-Version")); v != "13" {
+				t.Fatalf("unexpected Sec-WebSocket-Version %q. Expecting %q", v, "13")
+			}
+		}
+	}
+
+	testInvalidHandshake("POST / HTTP/1.1\r\nHost: foo\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nContent-Length: 0\r\n\r\n", StatusMethodNotAllowed)
+	testInvalidHandshake("GET / HTTP/1.1\r\nHost: foo\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", StatusBadRequest)
+	testInvalidHandshake("GET / HTTP/1.1\r\nHost: foo\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", StatusBadRequest)
+	testInvalidHandshake("GET / HTTP/1.1\r\nHost: foo\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: foobar\r\nSec-WebSocket-Version: 13\r\n\r\n", StatusBadRequest)
+	testInvalidHandshake("GET / HTTP/1.1\r\nHost: foo\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n\r\n", StatusUpgradeRequired)
+}
+
+func TestRequestCtxUpgradeWebSocketShutdown(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	s := testWebSocketServer(t, ln)
+	var closedConns int
+	s.OnShutdownTimeout = func(n int) {
+		closedConns = n
+	}
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	br, resp := testWebSocketHandshake(t, conn, "GET / HTTP/1.1\r\nHost: foo\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	if resp.StatusCode() != StatusSwitchingProtocols {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusSwitchingProtocols)
+	}
+
+	// The upgraded connection must be closed forcibly.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = s.ShutdownWithContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.DeadlineExceeded)
+	}
+	if closedConns != 1 {
+		t.Fatalf("unexpected number of closed connections: %d. Expecting 1", closedConns)
+	}
+	if _, err = br.ReadByte(); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+}
+
+func TestRequestCtxUpgradeWebSocketConnectionClose(t *testing.T) {
+	testUpgradeWebSocketConnectionClose := func(s *Server, connectionHeader string) {
+		t.Helper()
+		ln := fasthttputil.NewInmemoryListener()
+		defer ln.Close()
+		s.Handler = func(ctx *RequestCtx) {
+			err := ctx.UpgradeWebSocket(nil, func(c net.Conn) {
+				io.Copy(c, c)
+			})
+			if err != nil {
+				t.Errorf("cannot upgrade connection: %s", err)
+			}
+		}
+		go s.Serve(ln) //nolint:errcheck
+
+		conn, err := ln.Dial()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer conn.Close()
+		br, resp := testWebSocketHandshake(t, conn, "GET / HTTP/1.1\r\nHost: foo\r\n"+
+			"Upgrade: websocket\r\nConnection: "+connectionHeader+"\r\n"+
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+		if resp.StatusCode() != StatusSwitchingProtocols {
+			t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusSwitchingProtocols)
+		}
+		if v := string(resp.Header.Peek("Connection")); v != "Upgrade" {
+			t.Fatalf("unexpected Connection header %q. Expecting %q", v, "Upgrade")
+		}
+
+		// The connection must be passed to the handler.
+		if _, err = conn.Write([]byte("hello")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		buf := make([]byte, len("hello"))
+		if _, err = io.ReadFull(br, buf); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(buf) != "hello" {
+			t.Fatalf("unexpected data %q. Expecting %q", buf, "hello")
+		}
+	}
+
+	testUpgradeWebSocketConnectionClose(&Server{DisableKeepalive: true}, "Upgrade")
+	testUpgradeWebSocketConnectionClose(&Server{MaxRequestsPerConn: 1}, "Upgrade")
+	testUpgradeWebSocketConnectionClose(&Server{}, "close, Upgrade")
+}
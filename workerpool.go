@@ -24,6 +24,27 @@ type workerPool struct {
 
 	MaxIdleWorkerDuration time.Duration
 
+	// MaxIdleWorkers is the maximum number of idle workers. Workers
+	// exceeding the limit exit after serving the connection.
+	// The number of idle workers is unlimited if it isn't set.
+	MaxIdleWorkers int
+
+	// SpawnBurst is the number of workers started at once if there are
+	// no idle workers for serving the incoming connection.
+	SpawnBurst int
+
+	// MaxQueuedConns is the maximum number of connections waiting
+	// for a free worker if MaxWorkersCount workers are busy.
+	MaxQueuedConns int
+
+	// MaxQueueWaitTime is the maximum duration a connection may wait
+	// in the queue. Connections wait until a worker is free if it isn't set.
+	MaxQueueWaitTime time.Duration
+
+	// RejectFunc is called for connections, which cannot be served.
+	// It must close c.
+	RejectFunc func(c net.Conn)
+
 	Logger Logger
 
 	lock         sync.Mutex
@@ -32,6 +53,9 @@ type workerPool struct {
 
 	ready []*workerChan
 
+	// queue contains connections waiting for a free worker in FIFO order.
+	queue []queuedConn
+
 	stopCh chan struct{}
 
 	workerChanPool sync.Pool
@@ -42,6 +66,11 @@ type workerChan struct {
 	ch          chan net.Conn
 }
 
+type queuedConn struct {
+	c         net.Conn
+	queueTime time.Time
+}
+
 func (wp *workerPool) Start() {
 	if wp.stopCh != nil {
 		panic("BUG: workerPool already started")
@@ -50,13 +79,21 @@ func (wp *workerPool) Start() {
 	stopCh := wp.stopCh
 	go func() {
 		var scratch []*workerChan
+		var expired []net.Conn
+		sleepDuration := wp.getMaxIdleWorkerDuration()
+		if wp.MaxQueueWaitTime > 0 && wp.MaxQueueWaitTime < sleepDuration {
+			// Reject connections waiting in the queue for too long
+			// in a timely manner.
+			sleepDuration = wp.MaxQueueWaitTime
+		}
 		for {
 			wp.clean(&scratch)
+			wp.expireQueue(&expired)
 			select {
 			case <-stopCh:
 				return
 			default:
-				time.Sleep(wp.getMaxIdleWorkerDuration())
+				time.Sleep(sleepDuration)
 			}
 		}
 	}()
@@ -80,7 +117,14 @@ func (wp *workerPool) Stop() {
 	}
 	wp.ready = ready[:0]
 	wp.mustStop = true
+	queue := wp.queue
+	wp.queue = nil
 	wp.lock.Unlock()
+
+	// Close queued connections, since nobody is going to serve them.
+	for _, qc := range queue {
+		qc.c.Close()
+	}
 }
 
 func (wp *workerPool) getMaxIdleWorkerDuration() time.Duration {
@@ -126,12 +170,74 @@ func (wp *workerPool) clean(scratch *[]*workerChan) {
 }
 
 func (wp *workerPool) Serve(c net.Conn) bool {
-	ch := wp.getCh()
-	if ch == nil {
-		return false
+	for {
+		ch := wp.getCh()
+		if ch != nil {
+			ch.ch <- c
+			return true
+		}
+		if wp.MaxQueuedConns <= 0 {
+			return false
+		}
+
+		wp.lock.Lock()
+		if len(wp.ready) > 0 || wp.workersCount < wp.MaxWorkersCount {
+			// A worker has been released in the meantime.
+			wp.lock.Unlock()
+			continue
+		}
+		if wp.mustStop || len(wp.queue) >= wp.MaxQueuedConns {
+			wp.lock.Unlock()
+			return false
+		}
+		wp.queue = append(wp.queue, queuedConn{
+			c:         c,
+			queueTime: time.Now(),
+		})
+		wp.lock.Unlock()
+		return true
+	}
+}
+
+// expireQueue rejects connections waiting in the queue
+// for more than MaxQueueWaitTime.
+func (wp *workerPool) expireQueue(expired *[]net.Conn) {
+	if wp.MaxQueueWaitTime <= 0 {
+		return
+	}
+	currentTime := time.Now()
+
+	wp.lock.Lock()
+	queue := wp.queue
+	n := 0
+	for n < len(queue) && currentTime.Sub(queue[n].queueTime) > wp.MaxQueueWaitTime {
+		*expired = append(*expired, queue[n].c)
+		n++
+	}
+	if n > 0 {
+		m := copy(queue, queue[n:])
+		for i := m; i < len(queue); i++ {
+			queue[i] = queuedConn{}
+		}
+		wp.queue = queue[:m]
+	}
+	wp.lock.Unlock()
+
+	// Reject connections outside the wp.lock, since this may be slow.
+	tmp := *expired
+	for i, c := range tmp {
+		wp.reject(c)
+		tmp[i] = nil
+	}
+	*expired = tmp[:0]
+}
+
+func (wp *workerPool) reject(c net.Conn) {
+	if wp.RejectFunc != nil {
+		wp.RejectFunc(c)
+	} else {
+		c.Close()
 	}
-	ch.ch <- c
-	return true
 }
 
 var workerChanCap = func() int {
@@ -150,16 +256,23 @@ var workerChanCap = func() int {
 
 func (wp *workerPool) getCh() *workerChan {
 	var ch *workerChan
-	createWorker := false
+	createWorkers := 0
 
 	wp.lock.Lock()
 	ready := wp.ready
 	n := len(ready) - 1
 	if n < 0 {
-		if wp.workersCount < wp.MaxWorkersCount {
-			createWorker = true
-			wp.workersCount++
+		createWorkers = wp.SpawnBurst
+		if createWorkers < 1 {
+			createWorkers = 1
+		}
+		if wp.MaxIdleWorkers > 0 && createWorkers > wp.MaxIdleWorkers+1 {
+			createWorkers = wp.MaxIdleWorkers + 1
 		}
+		if m := wp.MaxWorkersCount - wp.workersCount; createWorkers > m {
+			createWorkers = m
+		}
+		wp.workersCount += createWorkers
 	} else {
 		ch = ready[n]
 		ready[n] = nil
@@ -168,59 +281,107 @@ func (wp *workerPool) getCh() *workerChan {
 	wp.lock.Unlock()
 
 	if ch == nil {
-		if !createWorker {
+		if createWorkers == 0 {
 			return nil
 		}
-		vch := wp.workerChanPool.Get()
-		if vch == nil {
-			vch = &workerChan{
-				ch: make(chan net.Conn, workerChanCap),
+		ch = wp.startWorker()
+
+		// Extra workers are started in advance for serving subsequent
+		// connections of the burst.
+		if createWorkers > 1 {
+			currentTime := CoarseTimeNow()
+			wp.lock.Lock()
+			if wp.mustStop {
+				wp.workersCount -= createWorkers - 1
+			} else {
+				for i := 1; i < createWorkers; i++ {
+					extraCh := wp.startWorker()
+					extraCh.lastUseTime = currentTime
+					wp.ready = append(wp.ready, extraCh)
+				}
 			}
+			wp.lock.Unlock()
 		}
-		ch = vch.(*workerChan)
-		go func() {
-			wp.workerFunc(ch)
-			wp.workerChanPool.Put(vch)
-		}()
 	}
 	return ch
 }
 
-func (wp *workerPool) release(ch *workerChan) bool {
-	ch.lastUseTime = CoarseTimeNow()
+func (wp *workerPool) startWorker() *workerChan {
+	vch := wp.workerChanPool.Get()
+	if vch == nil {
+		vch = &workerChan{
+			ch: make(chan net.Conn, workerChanCap),
+		}
+	}
+	ch := vch.(*workerChan)
+	go func() {
+		wp.workerFunc(ch)
+		wp.workerChanPool.Put(vch)
+	}()
+	return ch
+}
+
+// stats adds the current pool statistics to st.
+func (wp *workerPool) stats(st *WorkerPoolStats) {
 	wp.lock.Lock()
-	if wp.mustStop {
+	st.ActiveWorkers += wp.workersCount - len(wp.ready)
+	st.IdleWorkers += len(wp.ready)
+	st.QueuedConns += len(wp.queue)
+	wp.lock.Unlock()
+}
+
+// release returns ch to the pool of ready workers.
+//
+// The connection waiting in the queue is returned instead if any,
+// so the worker must serve it. false is returned if the worker must stop,
+// e.g. if there are MaxIdleWorkers idle workers.
+func (wp *workerPool) release(ch *workerChan) (net.Conn, bool) {
+	ch.lastUseTime = CoarseTimeNow()
+	for {
+		wp.lock.Lock()
+		if wp.mustStop {
+			wp.lock.Unlock()
+			return nil, false
+		}
+		if len(wp.queue) == 0 {
+			if wp.MaxIdleWorkers > 0 && len(wp.ready) >= wp.MaxIdleWorkers {
+				wp.lock.Unlock()
+				return nil, false
+			}
+			wp.ready = append(wp.ready, ch)
+			wp.lock.Unlock()
+			return nil, true
+		}
+		qc := wp.queue[0]
+		copy(wp.queue, wp.queue[1:])
+		wp.queue[len(wp.queue)-1] = queuedConn{}
+		wp.queue = wp.queue[:len(wp.queue)-1]
 		wp.lock.Unlock()
-		return false
+
+		if wp.MaxQueueWaitTime > 0 && time.Since(qc.queueTime) > wp.MaxQueueWaitTime {
+			wp.reject(qc.c)
+			continue
+		}
+		return qc.c, true
 	}
-	wp.ready = append(wp.ready, ch)
-	wp.lock.Unlock()
-	return true
 }
 
 func (wp *workerPool) workerFunc(ch *workerChan) {
 	var c net.Conn
 
-	var err error
 	for c = range ch.ch {
 		if c == nil {
 			break
 		}
 
-		if err = wp.WorkerFunc(c); err != nil && err != errHijacked {
-			errStr := err.Error()
-			if wp.LogAllErrors || !(strings.Contains(errStr, "broken pipe") ||
-				strings.Contains(errStr, "reset by peer") ||
-				strings.Contains(errStr, "i/o timeout")) {
-				wp.Logger.Printf("error when serving connection %q<->%q: %s", c.LocalAddr(), c.RemoteAddr(), err)
+		var ok bool
+		for c != nil {
+			wp.serveConn(c)
+			if c, ok = wp.release(ch); !ok {
+				break
 			}
 		}
-		if err != errHijacked {
-			c.Close()
-		}
-		c = nil
-
-		if !wp.release(ch) {
+		if !ok {
 			break
 		}
 	}
@@ -229,3 +390,18 @@ func (wp *workerPool) workerFunc(ch *workerChan) {
 	wp.workersCount--
 	wp.lock.Unlock()
 }
+
+func (wp *workerPool) serveConn(c net.Conn) {
+	err := wp.WorkerFunc(c)
+	if err != nil && err != errHijacked {
+		errStr := err.Error()
+		if wp.LogAllErrors || !(strings.Contains(errStr, "broken pipe") ||
+			strings.Contains(errStr, "reset by peer") ||
+			strings.Contains(errStr, "i/o timeout")) {
+			wp.Logger.Printf("error when serving connection %q<->%q: %s", c.LocalAddr(), c.RemoteAddr(), err)
+		}
+	}
+	if err != errHijacked {
+		c.Close()
+	}
+}
@@ -166,3 +166,82 @@ func testWorkerPoolMaxWorkersCount(t *testing.T) {
 	}
 	wp.Stop()
 }
+
+func TestWorkerPoolSpawnBurst(t *testing.T) {
+	wp := &workerPool{
+		WorkerFunc:      func(conn net.Conn) error { return nil },
+		MaxWorkersCount: 10,
+		SpawnBurst:      4,
+		Logger:          defaultLogger,
+	}
+	wp.Start()
+	defer wp.Stop()
+
+	c, _ := net.Pipe()
+	if !wp.Serve(c) {
+		t.Fatalf("worker pool must serve the conn")
+	}
+
+	var st WorkerPoolStats
+	deadline := time.Now().Add(time.Second)
+	for {
+		st = WorkerPoolStats{}
+		wp.stats(&st)
+		if st.IdleWorkers == 4 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st.IdleWorkers != 4 {
+		t.Fatalf("unexpected number of idle workers: %d. Expecting 4", st.IdleWorkers)
+	}
+	if st.ActiveWorkers != 0 {
+		t.Fatalf("unexpected number of active workers: %d. Expecting 0", st.ActiveWorkers)
+	}
+}
+
+func TestWorkerPoolMaxIdleWorkers(t *testing.T) {
+	ready := make(chan struct{})
+	wp := &workerPool{
+		WorkerFunc: func(conn net.Conn) error {
+			<-ready
+			return nil
+		},
+		MaxWorkersCount: 10,
+		MaxIdleWorkers:  2,
+		Logger:          defaultLogger,
+	}
+	wp.Start()
+	defer wp.Stop()
+
+	for i := 0; i < 5; i++ {
+		c, _ := net.Pipe()
+		if !wp.Serve(c) {
+			t.Fatalf("worker pool must serve the conn")
+		}
+	}
+
+	var st WorkerPoolStats
+	wp.stats(&st)
+	if st.ActiveWorkers != 5 {
+		t.Fatalf("unexpected number of active workers: %d. Expecting 5", st.ActiveWorkers)
+	}
+
+	close(ready)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		st = WorkerPoolStats{}
+		wp.stats(&st)
+		if st.ActiveWorkers == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st.IdleWorkers != 2 {
+		t.Fatalf("unexpected number of idle workers: %d. Expecting 2", st.IdleWorkers)
+	}
+	if st.ActiveWorkers != 0 {
+		t.Fatalf("unexpected number of active workers: %d. Expecting 0", st.ActiveWorkers)
+	}
+}